@@ -0,0 +1,10 @@
+// Package api holds the OpenAPI specification of the CodeLupe API so it is
+// compiled into the binaries that serve it.
+package api
+
+import _ "embed"
+
+// OpenAPI is the contents of openapi.yaml
+//
+//go:embed openapi.yaml
+var OpenAPI []byte
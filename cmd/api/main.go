@@ -1,40 +1,132 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
-	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"codelupe/internal/api"
 	"codelupe/pkg/secrets"
 )
 
 func main() {
-	// Load database configuration
-	dbConfig, err := secrets.LoadDatabaseConfig()
+	cfg, shutdownTimeout, err := loadConfig()
 	if err != nil {
-		log.Fatalf("Failed to load database config: %v", err)
+		log.Fatalf("Invalid configuration:\n%v", err)
 	}
 
-	// Get Elasticsearch URL
-	esURL := secrets.ReadSecretOrDefault("ELASTICSEARCH_URL", "http://localhost:9200")
+	// Create and start API server
+	server := api.NewServer(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting API server on port %s...", cfg.Port)
+		errCh <- server.Start()
+	}()
 
-	// Get API port
-	port := os.Getenv("API_PORT")
-	if port == "" {
-		port = "8080"
+	select {
+	case err := <-errCh:
+		if err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		return
+	case <-ctx.Done():
 	}
 
-	// Create and start API server
-	server := api.NewServer(api.Config{
-		Port:             port,
+	// Give in-flight requests time to finish before exiting
+	log.Printf("Shutting down, waiting up to %s for in-flight requests...", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Fatalf("Shutdown failed: %v", err)
+	}
+	log.Println("API server stopped")
+}
+
+// loadConfig reads the server configuration. The database host and
+// credentials are required, as are API keys when auth is enabled, rather
+// than falling back to a local database that fails at the first query.
+// Every missing or invalid setting is reported in one error.
+func loadConfig() (api.Config, time.Duration, error) {
+	var errs []error
+	collect := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// Zero durations and counts leave the server defaults in place
+	duration := func(name string) time.Duration {
+		d, err := secrets.ReadDuration(name, 0)
+		collect(err)
+		return d
+	}
+	integer := func(name string, defaultValue int) int {
+		n, err := secrets.ReadInt(name, defaultValue)
+		collect(err)
+		return n
+	}
+
+	// API keys are required on /api/v1 when API_AUTH_ENABLED=true
+	enableAuth, err := secrets.ReadBool("API_AUTH_ENABLED", false)
+	collect(err)
+
+	required := []string{"POSTGRES_HOST", "POSTGRES_USER", "POSTGRES_PASSWORD"}
+	if enableAuth {
+		required = append(required, "API_KEYS")
+	}
+	values, err := secrets.ReadSecrets(required...)
+	collect(err)
+
+	dbConfig := &secrets.DatabaseConfig{
+		Host:     values["POSTGRES_HOST"],
+		Port:     secrets.ReadSecretOrDefault("POSTGRES_PORT", "5432"),
+		Database: secrets.ReadSecretOrDefault("POSTGRES_DB", "coding_db"),
+		User:     values["POSTGRES_USER"],
+		Password: values["POSTGRES_PASSWORD"],
+	}
+
+	var apiKeys []string
+	if enableAuth && values["API_KEYS"] != "" {
+		apiKeys, err = secrets.LoadAPIKeys()
+		collect(err)
+	}
+
+	// Per-client rate limit on /api/v1; API_RATE_LIMIT_RPS=0 disables it
+	rateLimitRPS, err := secrets.ReadFloat("API_RATE_LIMIT_RPS", 10)
+	collect(err)
+
+	shutdownTimeout, err := secrets.ReadDuration("API_SHUTDOWN_TIMEOUT", 30*time.Second)
+	collect(err)
+
+	cfg := api.Config{
+		Port:             secrets.ReadSecretOrDefault("API_PORT", "8080"),
 		DatabaseConnStr:  dbConfig.ConnectionString(),
-		ElasticsearchURL: esURL,
+		ElasticsearchURL: secrets.ReadSecretOrDefault("ELASTICSEARCH_URL", "http://localhost:9200"),
 		EnableCORS:       true,
 		EnableMetrics:    true,
-	})
+		EnableAuth:       enableAuth,
+		APIKeys:          apiKeys,
+		RateLimitRPS:     rateLimitRPS,
+		RateLimitBurst:   integer("API_RATE_LIMIT_BURST", 20),
+		ReadTimeout:      duration("API_READ_TIMEOUT"),
+		WriteTimeout:     duration("API_WRITE_TIMEOUT"),
+		IdleTimeout:      duration("API_IDLE_TIMEOUT"),
+		MaxOpenConns:     integer("DB_MAX_OPEN_CONNS", 0),
+		MaxIdleConns:     integer("DB_MAX_IDLE_CONNS", 0),
+		ConnMaxLifetime:  duration("DB_CONN_MAX_LIFETIME"),
+		DatasetCacheTTL:  duration("DATASET_CACHE_TTL"),
+		ResponseCacheTTL: duration("API_CACHE_TTL"),
 
-	log.Printf("Starting API server on port %s...", port)
-	if err := server.Start(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		ExportsDir:        secrets.ReadSecretOrDefault("EXPORTS_DIR", ""),
+		ExportConcurrency: integer("EXPORT_CONCURRENCY", 0),
+		ExportTTL:         duration("EXPORT_TTL"),
 	}
+
+	return cfg, shutdownTimeout, errors.Join(errs...)
 }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// migrationFile is one direction of a migration in the migrations directory
+type migrationFile struct {
+	Version uint
+	Name    string
+	Path    string
+}
+
+// migrationFileRegex matches golang-migrate's file naming:
+// {version}_{title}.{up|down}.sql
+var migrationFileRegex = regexp.MustCompile(`^([0-9]+)_(.*)\.(up|down)\.sql$`)
+
+// migrationNameRegex restricts the names accepted by create
+var migrationNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_]*$`)
+
+// listMigrations returns the migrations in dir for the given direction
+// ("up" or "down"), sorted by version
+func listMigrations(dir, direction string) ([]migrationFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations directory: %w", err)
+	}
+
+	var files []migrationFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := migrationFileRegex.FindStringSubmatch(entry.Name())
+		if match == nil || match[3] != direction {
+			continue
+		}
+		version, err := strconv.ParseUint(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+		files = append(files, migrationFile{
+			Version: uint(version),
+			Name:    entry.Name(),
+			Path:    filepath.Join(dir, entry.Name()),
+		})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Version < files[j].Version })
+	return files, nil
+}
+
+// pendingMigrations returns the up migrations newer than the applied
+// version. applied is false for a database that has never been migrated.
+func pendingMigrations(dir string, current uint, applied bool) ([]migrationFile, error) {
+	files, err := listMigrations(dir, "up")
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migrationFile
+	for _, f := range files {
+		if !applied || f.Version > current {
+			pending = append(pending, f)
+		}
+	}
+	return pending, nil
+}
+
+// rollbackMigrations returns the down migrations a rollback of steps would
+// run, newest first. Zero steps rolls back everything applied.
+func rollbackMigrations(dir string, current uint, applied bool, steps int) ([]migrationFile, error) {
+	if !applied {
+		return nil, nil
+	}
+	files, err := listMigrations(dir, "down")
+	if err != nil {
+		return nil, err
+	}
+
+	var rollback []migrationFile
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].Version > current {
+			continue
+		}
+		if steps > 0 && len(rollback) == steps {
+			break
+		}
+		rollback = append(rollback, files[i])
+	}
+	return rollback, nil
+}
+
+// printPlan lists migrations as version and filename
+func printPlan(w io.Writer, files []migrationFile) {
+	for _, f := range files {
+		fmt.Fprintf(w, "%d\t%s\n", f.Version, f.Name)
+	}
+}
+
+// printSQL writes each migration's SQL under a header naming its file
+func printSQL(w io.Writer, files []migrationFile) error {
+	for _, f := range files {
+		sql, err := os.ReadFile(f.Path)
+		if err != nil {
+			return fmt.Errorf("failed to read migration: %w", err)
+		}
+		fmt.Fprintf(w, "-- %s\n%s\n", f.Name, sql)
+	}
+	return nil
+}
+
+// createMigration writes empty up and down files for name in dir. Versions
+// are UTC timestamps, or the next number after the highest existing
+// version when seq is set.
+func createMigration(dir, name string, now time.Time, seq bool) (upPath, downPath string, err error) {
+	if !migrationNameRegex.MatchString(name) {
+		return "", "", fmt.Errorf("invalid migration name %q: use lowercase letters, digits and underscores", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create migrations directory: %w", err)
+	}
+
+	version := now.UTC().Format("20060102150405")
+	if seq {
+		files, err := listMigrations(dir, "up")
+		if err != nil {
+			return "", "", err
+		}
+		next := uint(1)
+		if len(files) > 0 {
+			next = files[len(files)-1].Version + 1
+		}
+		version = fmt.Sprintf("%06d", next)
+	}
+
+	upPath = filepath.Join(dir, fmt.Sprintf("%s_%s.up.sql", version, name))
+	downPath = filepath.Join(dir, fmt.Sprintf("%s_%s.down.sql", version, name))
+	for _, path := range []string{upPath, downPath} {
+		// O_EXCL so an existing migration is never overwritten
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			if path == downPath {
+				os.Remove(upPath)
+			}
+			return "", "", fmt.Errorf("failed to create migration: %w", err)
+		}
+		f.Close()
+	}
+	return upPath, downPath, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeMigrations creates up and down files for each name in a temp dir
+func writeMigrations(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		for _, direction := range []string{"up", "down"} {
+			path := filepath.Join(dir, name+"."+direction+".sql")
+			sql := "-- " + direction + " " + name + "\n"
+			if err := os.WriteFile(path, []byte(sql), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// Files that aren't migrations are ignored
+	if err := os.WriteFile(filepath.Join(dir, "README.md"), []byte("notes"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func fileNames(files []migrationFile) []string {
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestListMigrations(t *testing.T) {
+	dir := writeMigrations(t, "000010_add_host", "000002_add_repos", "000001_initial")
+
+	files, err := listMigrations(dir, "up")
+	if err != nil {
+		t.Fatalf("listMigrations() error = %v", err)
+	}
+	want := []string{"000001_initial.up.sql", "000002_add_repos.up.sql", "000010_add_host.up.sql"}
+	if got := fileNames(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("listMigrations() = %v, want %v", got, want)
+	}
+	if files[2].Version != 10 {
+		t.Errorf("Version = %d, want 10", files[2].Version)
+	}
+
+	if _, err := listMigrations(filepath.Join(dir, "missing"), "up"); err == nil {
+		t.Error("listMigrations() should fail for a missing directory")
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	dir := writeMigrations(t, "000001_initial", "000002_add_repos", "000003_add_license")
+
+	tests := []struct {
+		name    string
+		current uint
+		applied bool
+		want    []string
+	}{
+		{"fresh database", 0, false, []string{"000001_initial.up.sql", "000002_add_repos.up.sql", "000003_add_license.up.sql"}},
+		{"partly applied", 1, true, []string{"000002_add_repos.up.sql", "000003_add_license.up.sql"}},
+		{"up to date", 3, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending, err := pendingMigrations(dir, tt.current, tt.applied)
+			if err != nil {
+				t.Fatalf("pendingMigrations() error = %v", err)
+			}
+			if got := fileNames(pending); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pendingMigrations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRollbackMigrations(t *testing.T) {
+	dir := writeMigrations(t, "000001_initial", "000002_add_repos", "000003_add_license")
+
+	tests := []struct {
+		name    string
+		current uint
+		applied bool
+		steps   int
+		want    []string
+	}{
+		{"all", 3, true, 0, []string{"000003_add_license.down.sql", "000002_add_repos.down.sql", "000001_initial.down.sql"}},
+		{"one step", 3, true, 1, []string{"000003_add_license.down.sql"}},
+		{"from older version", 2, true, 5, []string{"000002_add_repos.down.sql", "000001_initial.down.sql"}},
+		{"nothing applied", 0, false, 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rollback, err := rollbackMigrations(dir, tt.current, tt.applied, tt.steps)
+			if err != nil {
+				t.Fatalf("rollbackMigrations() error = %v", err)
+			}
+			if got := fileNames(rollback); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rollbackMigrations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintPlanAndSQL(t *testing.T) {
+	dir := writeMigrations(t, "000001_initial", "000002_add_repos")
+	pending, err := pendingMigrations(dir, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plan bytes.Buffer
+	printPlan(&plan, pending)
+	if want := "1\t000001_initial.up.sql\n2\t000002_add_repos.up.sql\n"; plan.String() != want {
+		t.Errorf("printPlan() = %q, want %q", plan.String(), want)
+	}
+
+	var sql bytes.Buffer
+	if err := printSQL(&sql, pending); err != nil {
+		t.Fatalf("printSQL() error = %v", err)
+	}
+	want := "-- 000001_initial.up.sql\n-- up 000001_initial\n\n-- 000002_add_repos.up.sql\n-- up 000002_add_repos\n\n"
+	if sql.String() != want {
+		t.Errorf("printSQL() = %q, want %q", sql.String(), want)
+	}
+}
+
+func TestCreateMigration(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "migrations")
+	now := time.Date(2024, 3, 5, 14, 7, 9, 0, time.UTC)
+
+	upPath, downPath, err := createMigration(dir, "add_license_column", now, false)
+	if err != nil {
+		t.Fatalf("createMigration() error = %v", err)
+	}
+	if filepath.Base(upPath) != "20240305140709_add_license_column.up.sql" ||
+		filepath.Base(downPath) != "20240305140709_add_license_column.down.sql" {
+		t.Errorf("createMigration() = %s, %s", upPath, downPath)
+	}
+	for _, path := range []string{upPath, downPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s not created: %v", path, err)
+		}
+	}
+
+	// The same name and time would overwrite, which is refused
+	if _, _, err := createMigration(dir, "add_license_column", now, false); err == nil {
+		t.Error("createMigration() should not overwrite an existing migration")
+	}
+
+	for _, name := range []string{"", "Add License", "../escape", "_leading"} {
+		if _, _, err := createMigration(dir, name, now, false); err == nil {
+			t.Errorf("createMigration(%q) should fail", name)
+		}
+	}
+}
+
+func TestCreateMigration_Sequential(t *testing.T) {
+	dir := writeMigrations(t, "000001_initial", "000015_add_index")
+
+	upPath, _, err := createMigration(dir, "add_license_column", time.Now(), true)
+	if err != nil {
+		t.Fatalf("createMigration() error = %v", err)
+	}
+	if !strings.HasSuffix(upPath, "000016_add_license_column.up.sql") {
+		t.Errorf("upPath = %s, want version 000016", upPath)
+	}
+
+	// An empty directory starts at 1
+	upPath, _, err = createMigration(t.TempDir(), "initial", time.Now(), true)
+	if err != nil {
+		t.Fatalf("createMigration() error = %v", err)
+	}
+	if filepath.Base(upPath) != "000001_initial.up.sql" {
+		t.Errorf("upPath = %s, want version 000001", upPath)
+	}
+}
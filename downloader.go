@@ -1,28 +1,51 @@
 package main
 
 import (
+	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
-
+	"unicode"
+
+	"codelupe/internal/essync"
+	"codelupe/internal/models"
+	"codelupe/pkg/config"
+	"codelupe/pkg/fsutil"
+	"codelupe/pkg/ghclient"
+	"codelupe/pkg/license"
 	"codelupe/pkg/metrics"
+	"codelupe/pkg/notify"
+	"codelupe/pkg/secrets"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/joho/godotenv"
 	"github.com/lib/pq"
 	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
 
 	_ "github.com/lib/pq"
 )
@@ -30,7 +53,6 @@ import (
 type RepoDownloader struct {
 	esClient      *elasticsearch.Client
 	db            *sql.DB
-	rateLimiter   *rate.Limiter
 	downloadDir   string
 	maxConcurrent int
 	downloaded    map[string]bool
@@ -39,67 +61,154 @@ type RepoDownloader struct {
 	mu            sync.RWMutex
 	stats         DownloadStats
 	qualityFilter *QualityFilter
-	httpClient    *http.Client
-	githubToken   string
+	licenseFilter *license.Filter
+	// owners blocks or force-includes repos by owner; reloaded on SIGHUP
+	owners *ownerLists
+	github *githubClient
+	// hosts maps lowercase hostnames to the provider that serves them
+	hosts map[string]HostProvider
+
+	// downloadedAt holds repos Postgres already marks as downloaded, loaded
+	// at the start of each cycle so they can be skipped before filtering
+	downloadedAt map[string]time.Time
+	// refreshAfter re-fetches downloaded repos older than this; 0 disables
+	refreshAfter time.Duration
+
+	// ctx is cancelled on SIGINT/SIGTERM to stop feeding workers and abort
+	// in-flight git commands
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cloneLimiter spaces the start of downloads. GitHub API requests have
+	// their own limiter in github, so neither budget delays the other.
+	cloneLimiter *rate.Limiter
+	// tarballThresholdKB switches repos larger than this to a tarball
+	// download; 0 always clones
+	tarballThresholdKB int
+
+	// lfsSkipSmudge checks Git LFS files out as their pointer files
+	// instead of downloading the objects
+	lfsSkipSmudge bool
+	// recurseSubmodules checks out first-level submodules after a clone,
+	// bounded by submoduleTimeout
+	recurseSubmodules bool
+	submoduleTimeout  time.Duration
+
+	// layout is layoutFlat or layoutLanguage
+	layout string
+	// Downloads pause while free space is below minFreeBytes (0 disables)
+	minFreeBytes      uint64
+	diskCheckInterval time.Duration
+	freeSpace         func(path string) (uint64, error)
+
+	// workerID tags rows this process marks as downloading so a crashed
+	// run's rows can be told apart from a live one's
+	workerID string
+
+	// notifier is told when a continuous-mode cycle ends
+	notifier notify.Multi
+
+	// metadata measures finished downloads so download slots free up as
+	// soon as the checkout is on disk; nil collects inline
+	metadata        *metadataPool
+	metadataWorkers int
+
+	// reportsDir receives a RunReport after each download, retry and
+	// update run; empty disables. recordRuns also inserts it into
+	// download_runs.
+	reportsDir string
+	recordRuns bool
 }
 
 type DownloadStats struct {
-	Total      int
-	Downloaded int
-	Failed     int
-	Skipped    int
-	Filtered   int
-	mu         sync.RWMutex
-}
-
-type RepoInfo struct {
-	FullName    string    `json:"full_name"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	URL         string    `json:"url"`
-	Stars       int       `json:"stars"`
-	Forks       int       `json:"forks"`
-	Language    string    `json:"language"`
-	Topics      []string  `json:"topics"`
-	LastUpdated time.Time `json:"last_updated"`
-	CrawledAt   time.Time `json:"crawled_at"`
-}
-
-type Repository struct {
-	ID             string
-	FullName       string
-	Name           string
-	Description    string
-	URL            string
-	CloneURL       string
-	Language       string
-	Stars          int
-	Forks          int
-	Watchers       int
-	SizeKB         int
-	LastUpdated    *time.Time
-	CreatedAt      time.Time
-	CrawledAt      time.Time
-	DownloadedAt   *time.Time
-	DownloadStatus string
-	Topics         []string
-	IsFork         bool
-	IsArchived     bool
-	IsPrivate      bool
-	DefaultBranch  string
-	OwnerLogin     string
-	OwnerType      string
-	LicenseName    string
-	LicenseKey     string
-	LocalPath      string
-	ErrorMessage   string
-	QualityScore   int
-	CodeLines      int
-	FileCount      int
+	Total      int `json:"total"`
+	Downloaded int `json:"downloaded"`
+	Failed     int `json:"failed"`
+	Skipped    int `json:"skipped"`
+	Filtered   int `json:"filtered"`
+	// FilteredNew counts repos filtered for the first time or for a new
+	// reason; FilteredUnchanged those already recorded with the same reason
+	FilteredNew       int `json:"filtered_new"`
+	FilteredUnchanged int `json:"filtered_unchanged"`
+	// AlreadyDownloaded counts repos skipped because Postgres has them as downloaded
+	AlreadyDownloaded int `json:"already_downloaded"`
+	Refreshed         int `json:"refreshed"`
+	// OwnerBlocked counts repos skipped because their owner is on
+	// OWNER_BLOCKLIST
+	OwnerBlocked int `json:"owner_blocked"`
+	// BytesCloned sums the measured size of this run's downloads
+	BytesCloned int64 `json:"bytes_cloned"`
+	// Languages counts this run's downloads by primary language
+	Languages map[string]int `json:"languages,omitempty"`
+	mu        sync.RWMutex
 }
 
 type GitHubRepo struct {
-	Language string `json:"language"`
+	Language      string         `json:"language"`
+	Size          int            `json:"size"` // KB
+	DefaultBranch string         `json:"default_branch"`
+	License       *GitHubLicense `json:"license"`
+	Fork          bool           `json:"fork"`
+	Parent        *GitHubParent  `json:"parent"` // only set for forks
+}
+
+// GitHubParent is the repository a fork was made from
+type GitHubParent struct {
+	FullName string `json:"full_name"`
+	Stars    int    `json:"stargazers_count"`
+}
+
+type GitHubLicense struct {
+	Key    string `json:"key"`
+	Name   string `json:"name"`
+	SPDXID string `json:"spdx_id"`
+}
+
+// metadata converts the GitHub API response. GitHub returns no license
+// object when the repo has no license file.
+func (g *GitHubRepo) metadata() *RepoMetadata {
+	meta := &RepoMetadata{Language: g.Language, SizeKB: g.Size, DefaultBranch: g.DefaultBranch, License: license.None,
+		IsFork: g.Fork, ParentStars: -1}
+	if g.Parent != nil {
+		meta.ParentFullName = g.Parent.FullName
+		meta.ParentStars = g.Parent.Stars
+	}
+	if g.License != nil {
+		meta.License = g.License.SPDXID
+		if meta.License == "" {
+			meta.License = license.NoAssertion
+		}
+		meta.LicenseName = g.License.Name
+	}
+	return meta
+}
+
+// RepoMetadata is what a HostProvider's API reports about a repository.
+// Fields a host doesn't report are left empty.
+type RepoMetadata struct {
+	Language      string
+	SizeKB        int
+	DefaultBranch string
+	License       string // SPDX id
+	LicenseName   string
+
+	IsFork         bool
+	ParentFullName string
+	ParentStars    int // -1 when not reported
+}
+
+// setLicense copies the host's license onto repo unless it already has one
+func (m *RepoMetadata) setLicense(repo *models.RepoInfo) {
+	if repo.License != "" || m.License == "" {
+		return
+	}
+	repo.License = m.License
+	repo.LicenseName = m.LicenseName
+}
+
+// setFork copies the host's fork status onto repo
+func (m *RepoMetadata) setFork(repo *models.RepoInfo) {
+	repo.IsFork = m.IsFork
+	repo.ParentFullName = m.ParentFullName
 }
 
 type QualityFilter struct {
@@ -110,6 +219,24 @@ type QualityFilter struct {
 	requiredLanguages []string
 	excludePatterns   []string
 	includePatterns   []string
+
+	// includeWeight is added when the name or description has an include
+	// pattern. Topics weigh separately: topicIncludeWeight is added per
+	// topic with an include pattern and topicExcludePenalty subtracted per
+	// topic with an exclude pattern.
+	includeWeight       int
+	topicIncludeWeight  int
+	topicExcludePenalty int
+
+	// readme returns a repo's README so include patterns can be checked
+	// against its content when the name, description and topics have none.
+	// Nil skips the lookup.
+	readme func(repo *models.RepoInfo) (string, error)
+
+	// Forks are skipped unless includeForks is set or the fork has at least
+	// forkStarDivergence more stars than its parent (0 never keeps a fork)
+	includeForks       bool
+	forkStarDivergence int
 }
 
 func NewQualityFilter() *QualityFilter {
@@ -138,24 +265,298 @@ func NewQualityFilter() *QualityFilter {
 			"monitoring", "logging", "testing", "deployment", "docker",
 			"kubernetes", "terraform", "ansible", "ci-cd", "pipeline",
 		},
+		includeWeight:       10,
+		topicIncludeWeight:  5,
+		topicExcludePenalty: 15,
 	}
 }
 
-func NewRepoDownloader(downloadDir string, maxConcurrent int) (*RepoDownloader, error) {
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found: %v", err)
+// QualityFilterConfig overrides the NewQualityFilter defaults. It is read
+// from YAML or JSON; fields left out keep their default value.
+type QualityFilterConfig struct {
+	MinStars          *int     `yaml:"min_stars"`
+	MinForks          *int     `yaml:"min_forks"`
+	MinCodeLines      *int     `yaml:"min_code_lines"`
+	MaxBinaryPercent  *float64 `yaml:"max_binary_percent"`
+	RequiredLanguages []string `yaml:"required_languages"`
+	ExcludePatterns   []string `yaml:"exclude_patterns"`
+	IncludePatterns   []string `yaml:"include_patterns"`
+
+	TopicIncludeWeight  *int `yaml:"topic_include_weight"`
+	TopicExcludePenalty *int `yaml:"topic_exclude_penalty"`
+
+	IncludeForks       *bool `yaml:"include_forks"`
+	ForkStarDivergence *int  `yaml:"fork_star_divergence"`
+}
+
+// loadQualityFilter returns the default filter with the configured
+// thresholds applied, then any overrides from the filter file at path. An
+// empty path skips the file.
+func loadQualityFilter(path string, thresholds config.Quality) (*QualityFilter, error) {
+	qf := NewQualityFilter()
+	qf.minStars = thresholds.MinStars
+	qf.minForks = thresholds.MinForks
+	qf.minCodeLines = thresholds.MinCodeLines
+	if path == "" {
+		return qf, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open quality filter config: %w", err)
+	}
+	defer f.Close()
+
+	var cfg QualityFilterConfig
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid quality filter config %s: %w", path, err)
+	}
+
+	if err := qf.apply(cfg); err != nil {
+		return nil, fmt.Errorf("invalid quality filter config %s: %w", path, err)
+	}
+	return qf, nil
+}
+
+// apply validates cfg and overrides the fields it sets
+func (qf *QualityFilter) apply(cfg QualityFilterConfig) error {
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{
+		{"min_stars", cfg.MinStars},
+		{"min_forks", cfg.MinForks},
+		{"min_code_lines", cfg.MinCodeLines},
+		{"topic_include_weight", cfg.TopicIncludeWeight},
+		{"topic_exclude_penalty", cfg.TopicExcludePenalty},
+		{"fork_star_divergence", cfg.ForkStarDivergence},
+	} {
+		if field.value != nil && *field.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %d", field.name, *field.value)
+		}
+	}
+	if cfg.MaxBinaryPercent != nil && (*cfg.MaxBinaryPercent < 0 || *cfg.MaxBinaryPercent > 1) {
+		return fmt.Errorf("max_binary_percent must be between 0 and 1, got %v", *cfg.MaxBinaryPercent)
+	}
+	if cfg.RequiredLanguages != nil && len(cfg.RequiredLanguages) == 0 {
+		return fmt.Errorf("required_languages must not be empty")
+	}
+
+	requiredLanguages, err := cleanPatternList("required_languages", cfg.RequiredLanguages, false)
+	if err != nil {
+		return err
+	}
+	// evaluateRepo matches patterns word by word against lowercased text
+	excludePatterns, err := cleanPatternList("exclude_patterns", cfg.ExcludePatterns, true)
+	if err != nil {
+		return err
+	}
+	includePatterns, err := cleanPatternList("include_patterns", cfg.IncludePatterns, true)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MinStars != nil {
+		qf.minStars = *cfg.MinStars
+	}
+	if cfg.MinForks != nil {
+		qf.minForks = *cfg.MinForks
+	}
+	if cfg.MinCodeLines != nil {
+		qf.minCodeLines = *cfg.MinCodeLines
+	}
+	if cfg.MaxBinaryPercent != nil {
+		qf.maxBinaryPercent = *cfg.MaxBinaryPercent
+	}
+	if requiredLanguages != nil {
+		qf.requiredLanguages = requiredLanguages
+	}
+	if excludePatterns != nil {
+		qf.excludePatterns = excludePatterns
+	}
+	if includePatterns != nil {
+		qf.includePatterns = includePatterns
+	}
+	if cfg.TopicIncludeWeight != nil {
+		qf.topicIncludeWeight = *cfg.TopicIncludeWeight
+	}
+	if cfg.TopicExcludePenalty != nil {
+		qf.topicExcludePenalty = *cfg.TopicExcludePenalty
+	}
+	if cfg.IncludeForks != nil {
+		qf.includeForks = *cfg.IncludeForks
+	}
+	if cfg.ForkStarDivergence != nil {
+		qf.forkStarDivergence = *cfg.ForkStarDivergence
+	}
+	return nil
+}
+
+// cleanPatternList trims (and optionally lowercases) each entry, rejecting
+// blank ones. A nil list stays nil so the caller keeps its default.
+func cleanPatternList(field string, values []string, lower bool) ([]string, error) {
+	if values == nil {
+		return nil, nil
 	}
 
-	// Get Elasticsearch URL from environment with retry logic
-	esURL := os.Getenv("ELASTICSEARCH_URL")
-	if esURL == "" {
-		esURL = "http://elasticsearch:9200"
+	cleaned := make([]string, 0, len(values))
+	for i, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, fmt.Errorf("%s[%d] must not be blank", field, i)
+		}
+		if lower {
+			v = strings.ToLower(v)
+		}
+		cleaned = append(cleaned, v)
+	}
+	return cleaned, nil
+}
+
+// String summarizes the effective filter settings for logging
+func (qf *QualityFilter) String() string {
+	return fmt.Sprintf("min_stars=%d min_forks=%d min_code_lines=%d max_binary_percent=%.2f required_languages=%v exclude_patterns=%d include_patterns=%d topic_include_weight=%d topic_exclude_penalty=%d readme=%t include_forks=%t fork_star_divergence=%d",
+		qf.minStars, qf.minForks, qf.minCodeLines, qf.maxBinaryPercent,
+		qf.requiredLanguages, len(qf.excludePatterns), len(qf.includePatterns),
+		qf.topicIncludeWeight, qf.topicExcludePenalty, qf.readme != nil,
+		qf.includeForks, qf.forkStarDivergence)
+}
+
+// allowsFork reports whether repo may be downloaded, and why not. Forks of
+// big projects otherwise add copy after copy of the upstream; one with
+// forkStarDivergence more stars than its parent, like a community fork of
+// an abandoned project, has earned a place of its own. parentStars is -1
+// when the parent isn't known.
+func (qf *QualityFilter) allowsFork(repo *models.RepoInfo, parentStars int) (bool, string) {
+	if !repo.IsFork || qf.includeForks {
+		return true, ""
+	}
+	if qf.forkStarDivergence > 0 && parentStars >= 0 && repo.Stars-parentStars >= qf.forkStarDivergence {
+		return true, ""
+	}
+	if repo.ParentFullName != "" {
+		return false, fmt.Sprintf("fork of %s", repo.ParentFullName)
+	}
+	return false, "fork"
+}
+
+// Owner list verdicts
+const (
+	ownerUnlisted = ""
+	ownerBlocked  = "blocked"
+	ownerAllowed  = "allowed"
+)
+
+// ownerLists hold the OWNER_BLOCKLIST and OWNER_ALLOWLIST glob patterns
+// (microsoft-*), matched case-insensitively against the owner in a repo's
+// full name. Blocked owners' repos are skipped; allowlisted owners' repos
+// skip the star and fork minimums. An owner matching both is blocked.
+type ownerLists struct {
+	mu    sync.RWMutex
+	block []string
+	allow []string
+}
+
+// loadOwnerLists reads OWNER_BLOCKLIST and OWNER_ALLOWLIST, each a file with
+// one pattern per line (# starts a comment) or a comma-separated list
+func loadOwnerLists() (*ownerLists, error) {
+	l := &ownerLists{}
+	return l, l.reload()
+}
+
+// reload re-reads the lists, keeping the current ones if either is invalid
+func (l *ownerLists) reload() error {
+	block, err := parseOwnerList("OWNER_BLOCKLIST", os.Getenv("OWNER_BLOCKLIST"))
+	if err != nil {
+		return err
+	}
+	allow, err := parseOwnerList("OWNER_ALLOWLIST", os.Getenv("OWNER_ALLOWLIST"))
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.block, l.allow = block, allow
+	return nil
+}
+
+func parseOwnerList(name, value string) ([]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var entries []string
+	if data, err := os.ReadFile(value); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			entries = append(entries, line)
+		}
+	} else {
+		entries = strings.Split(value, ",")
+	}
+
+	var patterns []string
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if _, err := path.Match(entry, ""); err != nil || strings.Contains(entry, "/") {
+			return nil, fmt.Errorf("invalid %s pattern %q", name, entry)
+		}
+		patterns = append(patterns, entry)
+	}
+	return patterns, nil
+}
+
+// check returns the verdict for fullName's owner and the pattern that
+// decided it. A nil list leaves every owner unlisted.
+func (l *ownerLists) check(fullName string) (string, string) {
+	if l == nil {
+		return ownerUnlisted, ""
+	}
+	owner, _, _ := strings.Cut(strings.ToLower(fullName), "/")
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for _, pattern := range l.block {
+		if ok, _ := path.Match(pattern, owner); ok {
+			return ownerBlocked, pattern
+		}
+	}
+	for _, pattern := range l.allow {
+		if ok, _ := path.Match(pattern, owner); ok {
+			return ownerAllowed, pattern
+		}
+	}
+	return ownerUnlisted, ""
+}
+
+// String summarizes the lists for logging
+func (l *ownerLists) String() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return fmt.Sprintf("blocklist=%d allowlist=%d", len(l.block), len(l.allow))
+}
+
+func NewRepoDownloader(cfg *config.Config, downloadDir string, maxConcurrent int) (*RepoDownloader, error) {
+	// Check the settings before spending time on connection retries
+	settings, err := loadDownloaderSettings()
+	if err != nil {
+		return nil, err
 	}
 
+	esURL := cfg.Elasticsearch.URL
+
 	log.Printf("Connecting to Elasticsearch at: %s", esURL)
 
 	var esClient *elasticsearch.Client
-	var err error
 
 	// Retry connection with exponential backoff
 	for i := 0; i < 10; i++ {
@@ -185,7 +586,31 @@ func NewRepoDownloader(downloadDir string, maxConcurrent int) (*RepoDownloader,
 		return nil, fmt.Errorf("failed to create Elasticsearch client after retries: %w", err)
 	}
 
-	db, err := connectPostgreSQL()
+	qualityFilter, err := loadQualityFilter(cfg.Quality.FilterFile, cfg.Quality)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Quality filter: %s", qualityFilter)
+
+	licenseFilter, err := license.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("License filter: %s", licenseFilter)
+
+	owners, err := loadOwnerLists()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Owner lists: %s", owners)
+
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Cycle notifications: %s", notifier)
+
+	db, err := connectPostgreSQL(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
@@ -203,9 +628,10 @@ func NewRepoDownloader(downloadDir string, maxConcurrent int) (*RepoDownloader,
 
 	log.Printf("Successfully verified write access to: %s", downloadDir)
 
-	// Create HTTP client with connection pooling for better performance
+	// Create HTTP client with connection pooling for better performance.
+	// There is no client timeout: tarballs can take minutes, so each request
+	// is bounded by its context instead.
 	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
 		Transport: &http.Transport{
 			MaxIdleConns:        100,
 			MaxIdleConnsPerHost: 10,
@@ -215,32 +641,130 @@ func NewRepoDownloader(downloadDir string, maxConcurrent int) (*RepoDownloader,
 		},
 	}
 
-	return &RepoDownloader{
+	cache, err := ghclient.OpenCache(cfg.GitHub.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	github := newGitHubClient(httpClient, "https://api.github.com", cfg.GitHub.Token, cfg.RateLimits.GitHubMaxWait)
+	github.http.Cache = cache
+	github.limitRate(cfg.RateLimits.GitHubAPIInterval, cfg.RateLimits.GitHubAPISlowdownBelow)
+	log.Printf("Rate limits: GitHub API every %v (spread out below %d remaining), clones every %v",
+		cfg.RateLimits.GitHubAPIInterval, cfg.RateLimits.GitHubAPISlowdownBelow, cfg.RateLimits.CloneInterval)
+
+	hostname, _ := os.Hostname()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	rd := &RepoDownloader{
+		ctx:           ctx,
+		cancel:        cancel,
+		workerID:      fmt.Sprintf("%s-%d", hostname, os.Getpid()),
 		esClient:      esClient,
 		db:            db,
-		rateLimiter:   rate.NewLimiter(rate.Every(500*time.Millisecond), 1),
+		cloneLimiter:  newCloneLimiter(cfg.RateLimits.CloneInterval, maxConcurrent),
 		downloadDir:   downloadDir,
 		maxConcurrent: maxConcurrent,
 		downloaded:    make(map[string]bool),
 		processing:    make(map[string]bool),
 		failed:        make(map[string]error),
-		qualityFilter: NewQualityFilter(),
-		httpClient:    httpClient,
-		githubToken:   getEnv("GITHUB_TOKEN", ""),
-	}, nil
+		qualityFilter: qualityFilter,
+		licenseFilter: licenseFilter,
+		owners:        owners,
+		github:        github,
+		hosts:         newHostProviders(github, httpClient, settings.gitlabToken, settings.bitbucketToken, settings.gitlabHosts),
+
+		tarballThresholdKB: settings.tarballThresholdMB * 1024,
+
+		lfsSkipSmudge:    settings.lfsSkipSmudge,
+		submoduleTimeout: settings.submoduleTimeout,
+
+		layout:            settings.layout,
+		minFreeBytes:      uint64(settings.minFreeGB * (1 << 30)),
+		diskCheckInterval: time.Minute,
+		freeSpace:         fsutil.Free,
+
+		notifier: notifier,
+
+		metadataWorkers: cfg.Concurrency.MetadataWorkers,
+
+		reportsDir: cfg.Paths.DownloadReports,
+		recordRuns: settings.recordRuns,
+	}
+
+	// README lookups spend API quota, so they need a token
+	if cfg.GitHub.Token != "" {
+		qualityFilter.readme = rd.fetchReadme
+	}
+	return rd, nil
+}
+
+// downloaderSettings are the environment settings the downloader reads on
+// top of config.Config
+type downloaderSettings struct {
+	tarballThresholdMB int
+	minFreeGB          float64
+	lfsSkipSmudge      bool
+	submoduleTimeout   time.Duration
+	layout             string
+	gitlabHosts        []string
+	gitlabToken        string
+	bitbucketToken     string
+	recordRuns         bool
 }
 
-func connectPostgreSQL() (*sql.DB, error) {
-	host := getEnv("POSTGRES_HOST", "localhost")
-	port := getEnv("POSTGRES_PORT", "5432")
-	user := getEnv("POSTGRES_USER", "coding_user")
-	password := getEnv("POSTGRES_PASSWORD", "coding_pass")
-	dbname := getEnv("POSTGRES_DB", "coding_db")
+// loadDownloaderSettings reads the downloader's environment settings,
+// reporting every invalid one at once. Tokens may also come from a
+// <NAME>_FILE secret.
+func loadDownloaderSettings() (downloaderSettings, error) {
+	var errs []error
+	settings := downloaderSettings{
+		layout:         getEnv("LAYOUT", layoutFlat),
+		gitlabToken:    secrets.ReadSecretOrDefault("GITLAB_TOKEN", ""),
+		bitbucketToken: secrets.ReadSecretOrDefault("BITBUCKET_TOKEN", ""),
+	}
+
+	var err error
+	settings.tarballThresholdMB, err = secrets.ReadInt("TARBALL_THRESHOLD_MB", 500)
+	if err == nil && settings.tarballThresholdMB < 0 {
+		err = fmt.Errorf("invalid TARBALL_THRESHOLD_MB %d: must not be negative", settings.tarballThresholdMB)
+	}
+	errs = append(errs, err)
+
+	settings.minFreeGB, err = secrets.ReadFloat("MIN_FREE_GB", 0)
+	if err == nil && settings.minFreeGB < 0 {
+		err = fmt.Errorf("invalid MIN_FREE_GB %g: must not be negative", settings.minFreeGB)
+	}
+	errs = append(errs, err)
+
+	settings.lfsSkipSmudge, err = secrets.ReadBool("LFS_SKIP_SMUDGE", true)
+	errs = append(errs, err)
+
+	settings.recordRuns, err = secrets.ReadBool("RECORD_DOWNLOAD_RUNS", false)
+	errs = append(errs, err)
+
+	settings.submoduleTimeout, err = secrets.ReadDuration("SUBMODULE_TIMEOUT", 3*time.Minute)
+	if err == nil && settings.submoduleTimeout <= 0 {
+		err = fmt.Errorf("invalid SUBMODULE_TIMEOUT %v: must be positive", settings.submoduleTimeout)
+	}
+	errs = append(errs, err)
+
+	if settings.layout != layoutFlat && settings.layout != layoutLanguage {
+		errs = append(errs, fmt.Errorf("invalid LAYOUT %q (expected flat or language)", settings.layout))
+	}
+
+	if v := getEnv("GITLAB_HOSTS", ""); v != "" {
+		settings.gitlabHosts = strings.Split(v, ",")
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return downloaderSettings{}, fmt.Errorf("invalid downloader configuration:\n%w", err)
+	}
+	return settings, nil
+}
 
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		host, port, user, password, dbname)
+func connectPostgreSQL(cfg *config.Config) (*sql.DB, error) {
+	psqlInfo := cfg.DatabaseURL()
 
-	log.Printf("Connecting to PostgreSQL at %s:%s", host, port)
+	log.Printf("Connecting to PostgreSQL at %s", cfg.DatabaseAddress())
 
 	var db *sql.DB
 	var err error
@@ -274,17 +798,24 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-func (qf *QualityFilter) evaluateRepo(repo *RepoInfo) (bool, int, string) {
+func (qf *QualityFilter) evaluateRepo(repo *models.RepoInfo) (bool, int, string) {
+	return qf.evaluate(repo, false)
+}
+
+// evaluate scores repo. An allowlisted owner's repos skip the star and fork
+// minimums and the minimum score, but must still have a required language
+// and no exclude pattern.
+func (qf *QualityFilter) evaluate(repo *models.RepoInfo, allowlisted bool) (bool, int, string) {
 	score := 10 // Base score for all repos
 	reasons := []string{}
 
-	if repo.Stars < qf.minStars {
+	if repo.Stars < qf.minStars && !allowlisted {
 		reasons = append(reasons, fmt.Sprintf("too few stars (%d < %d)", repo.Stars, qf.minStars))
 		return false, score, strings.Join(reasons, "; ")
 	}
 	score += 10
 
-	if repo.Forks < qf.minForks {
+	if repo.Forks < qf.minForks && !allowlisted {
 		reasons = append(reasons, fmt.Sprintf("too few forks (%d < %d)", repo.Forks, qf.minForks))
 		return false, score, strings.Join(reasons, "; ")
 	}
@@ -304,38 +835,38 @@ func (qf *QualityFilter) evaluateRepo(repo *RepoInfo) (bool, int, string) {
 		return false, score, strings.Join(reasons, "; ")
 	}
 
-	repoNameLower := strings.ToLower(repo.Name)
-	descLower := strings.ToLower(repo.Description)
-	fullNameLower := strings.ToLower(repo.FullName)
+	// Patterns match whole words, so "test" doesn't reject pytest and
+	// "hello-world" matches hello-world-tutorial but not helloworldly
+	text := [][]string{patternWords(repo.Name), patternWords(repo.FullName), patternWords(repo.Description)}
 
-	for _, pattern := range qf.excludePatterns {
-		if strings.Contains(repoNameLower, pattern) ||
-			strings.Contains(descLower, pattern) ||
-			strings.Contains(fullNameLower, pattern) {
-			reasons = append(reasons, fmt.Sprintf("contains excluded pattern: %s", pattern))
-			return false, score, strings.Join(reasons, "; ")
-		}
+	if pattern, ok := matchPattern(qf.excludePatterns, text...); ok {
+		reasons = append(reasons, fmt.Sprintf("contains excluded pattern: %s", pattern))
+		return false, score, strings.Join(reasons, "; ")
+	}
+
+	_, hasIncludePattern := matchPattern(qf.includePatterns, text...)
+	if hasIncludePattern {
+		score += qf.includeWeight
 	}
 
-	hasIncludePattern := false
-	for _, pattern := range qf.includePatterns {
-		if strings.Contains(repoNameLower, pattern) ||
-			strings.Contains(descLower, pattern) ||
-			strings.Contains(fullNameLower, pattern) {
+	for _, topic := range repo.Topics {
+		words := patternWords(topic)
+		if _, ok := matchPattern(qf.excludePatterns, words); ok {
+			score -= qf.topicExcludePenalty
+		}
+		if _, ok := matchPattern(qf.includePatterns, words); ok {
 			hasIncludePattern = true
-			score += 10
-			break
+			score += qf.topicIncludeWeight
 		}
 	}
 
-	for _, topic := range repo.Topics {
-		topicLower := strings.ToLower(topic)
-		for _, pattern := range qf.includePatterns {
-			if strings.Contains(topicLower, pattern) {
-				hasIncludePattern = true
-				score += 5
-				break
-			}
+	if !hasIncludePattern && qf.readme != nil {
+		readme, err := qf.readme(repo)
+		if err != nil {
+			log.Printf("Failed to fetch README for %s: %v", repo.FullName, err)
+		} else if _, ok := matchPattern(qf.includePatterns, patternWords(readme)); ok {
+			hasIncludePattern = true
+			score += qf.includeWeight
 		}
 	}
 
@@ -355,7 +886,7 @@ func (qf *QualityFilter) evaluateRepo(repo *RepoInfo) (bool, int, string) {
 		score += 15
 	}
 
-	passed := score >= 50
+	passed := score >= 50 || allowlisted
 
 	// Record metrics
 	metrics.ObserveHistogram("downloader_repo_quality_score", float64(score))
@@ -365,165 +896,1312 @@ func (qf *QualityFilter) evaluateRepo(repo *RepoInfo) (bool, int, string) {
 		metrics.IncrCounter("downloader_quality_filtered_total", 1)
 	}
 
+	if allowlisted {
+		return passed, score, "passed quality check (allowlisted owner)"
+	}
 	return passed, score, "passed quality check"
 }
 
-func (rd *RepoDownloader) getAllRepos() ([]*RepoInfo, error) {
-	const batchSize = 1000 // Reduced from 5000 to lower memory pressure
-	var allRepos []*RepoInfo
-	from := 0
+// patternWords splits s into lowercase words on anything but letters,
+// digits, '+' and '#', so "go-kit", "rust_analyzer" and "ci/cd" each
+// become separate words while "c++" stays one
+func patternWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '+' && r != '#'
+	})
+}
 
-	for {
-		query := fmt.Sprintf(`{
-			"query": {
-				"match_all": {}
-			},
-			"_source": ["full_name", "name", "description", "url", "stars", "forks", "language", "topics", "last_updated", "crawled_at"],
-			"size": %d,
-			"from": %d
-		}`, batchSize, from)
+// matchPattern returns the first pattern whose words appear consecutively
+// in any of texts
+func matchPattern(patterns []string, texts ...[]string) (string, bool) {
+	for _, pattern := range patterns {
+		want := patternWords(pattern)
+		if len(want) == 0 {
+			continue
+		}
+		for _, words := range texts {
+			if containsWords(words, want) {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
 
-		req := esapi.SearchRequest{
-			Index: []string{"github-coding-repos"},
-			Body:  strings.NewReader(query),
+// containsWords reports whether want appears as a run in words
+func containsWords(words, want []string) bool {
+	for i := 0; i+len(want) <= len(words); i++ {
+		match := true
+		for j := range want {
+			if words[i+j] != want[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// esBatchSize is how many repositories each search_after page requests
+const esBatchSize = 1000
+
+// streamRepos pages through the index with search_after sorted on full_name
+// and sends each repository to out as it arrives, so the full list is never
+// held in memory and paging isn't capped by max_result_window. It returns
+// the number of repositories sent.
+func (rd *RepoDownloader) streamRepos(ctx context.Context, out chan<- *models.RepoInfo) (int, error) {
+	var searchAfter []interface{}
+	sent := 0
+
+	for {
+		body := map[string]interface{}{
+			"query":   map[string]interface{}{"match_all": map[string]interface{}{}},
+			"_source": []string{"full_name", "name", "description", "url", "stars", "forks", "language", "topics", "last_updated", "crawled_at"},
+			"size":    esBatchSize,
+			"sort":    []map[string]string{{"full_name": "asc"}},
+		}
+		if searchAfter != nil {
+			body["search_after"] = searchAfter
 		}
 
-		res, err := req.Do(context.Background(), rd.esClient)
+		query, err := json.Marshal(body)
 		if err != nil {
-			return nil, err
+			return sent, err
 		}
-		defer res.Body.Close()
 
-		if res.IsError() {
-			return nil, fmt.Errorf("elasticsearch error: %s", res.Status())
+		// Read through the alias so a reindex is picked up without a restart
+		req := esapi.SearchRequest{
+			Index: []string{models.RepoIndex},
+			Body:  bytes.NewReader(query),
+		}
+
+		res, err := req.Do(ctx, rd.esClient)
+		if err != nil {
+			return sent, err
 		}
 
 		var result struct {
 			Hits struct {
-				Total struct {
-					Value int `json:"value"`
-				} `json:"total"`
 				Hits []struct {
-					Source RepoInfo `json:"_source"`
+					Source models.RepoInfo `json:"_source"`
+					Sort   []interface{}   `json:"sort"`
 				} `json:"hits"`
 			} `json:"hits"`
 		}
 
-		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-			return nil, err
+		if res.IsError() {
+			res.Body.Close()
+			return sent, fmt.Errorf("elasticsearch error: %s", res.Status())
+		}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return sent, err
 		}
 
-		// Add repos from this batch
-		for _, hit := range result.Hits.Hits {
-			// IMPORTANT: Make a copy to avoid all pointers pointing to the same loop variable
-			repoCopy := hit.Source
-			// Debug: log first few repos to see what data we're getting
-			if len(allRepos) < 3 {
-				log.Printf("Debug repo %d: FullName=%s, Language='%s', Stars=%d",
-					len(allRepos), repoCopy.FullName, repoCopy.Language, repoCopy.Stars)
+		hits := result.Hits.Hits
+		for i := range hits {
+			repo := hits[i].Source
+			select {
+			case out <- &repo:
+				sent++
+			case <-ctx.Done():
+				return sent, ctx.Err()
 			}
-			allRepos = append(allRepos, &repoCopy)
 		}
 
-		currentBatchSize := len(result.Hits.Hits)
-		log.Printf("Fetched batch %d-%d (%d repos) - Total so far: %d",
-			from, from+currentBatchSize-1, currentBatchSize, len(allRepos))
+		rd.stats.mu.Lock()
+		rd.stats.Total = sent
+		rd.stats.mu.Unlock()
+
+		log.Printf("Fetched batch of %d repos - Total so far: %d", len(hits), sent)
 
-		// If we got fewer results than requested, we've reached the end
-		if currentBatchSize < batchSize {
+		// A short page means we've reached the end of the index
+		if len(hits) < esBatchSize {
 			break
 		}
-
-		from += batchSize
+		searchAfter = hits[len(hits)-1].Sort
 	}
 
-	rd.stats.mu.Lock()
-	rd.stats.Total = len(allRepos)
-	rd.stats.mu.Unlock()
+	log.Printf("Found %d repositories to download", sent)
+	return sent, nil
+}
 
-	log.Printf("Found %d repositories to download", len(allRepos))
-	return allRepos, nil
+var errGitHubRateLimited = errors.New("GitHub rate limit exceeded")
+
+// cloneRateLimitPause is how long all GitHub traffic stops after a clone is
+// rejected for rate limiting or abuse detection, which gives no reset time
+const cloneRateLimitPause = 5 * time.Minute
+
+// githubClient wraps GitHub API requests with handling for primary and
+// secondary rate limits: throttled responses pause every request for the
+// time GitHub asks for (bounded by maxWait) and are retried.
+type githubClient struct {
+	http        *ghclient.Client
+	baseURL     string
+	token       string
+	maxWait     time.Duration
+	maxRetries  int
+	pausedUntil int64 // unix nanos, shared by every request and clone
+
+	// limiter spaces API requests at baseLimit, slowed while fewer than
+	// slowdownBelow requests of the quota remain; nil doesn't limit
+	limiter       *rate.Limiter
+	baseLimit     rate.Limit
+	slowdownBelow int
 }
 
-func (rd *RepoDownloader) fetchGitHubLanguage(fullName string) (string, error) {
-	if rd.githubToken == "" {
-		return "", nil // No token, skip API call
+func newGitHubClient(httpClient *http.Client, baseURL, token string, maxWait time.Duration) *githubClient {
+	client := ghclient.New(httpClient, token, nil, "downloader")
+	if u, err := url.Parse(baseURL); err == nil {
+		client.APIHost = u.Host
 	}
+	return &githubClient{http: client, baseURL: baseURL, token: token, maxWait: maxWait, maxRetries: 3}
+}
 
-	url := fmt.Sprintf("https://api.github.com/repos/%s", fullName)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return "", err
+// limitRate spaces API requests interval apart (0 for no limit) and
+// slows them while fewer than slowdownBelow remain (0 never slows)
+func (g *githubClient) limitRate(interval time.Duration, slowdownBelow int) {
+	g.baseLimit = rate.Inf
+	if interval > 0 {
+		g.baseLimit = rate.Every(interval)
 	}
+	g.limiter = rate.NewLimiter(g.baseLimit, 1)
+	g.slowdownBelow = slowdownBelow
+}
 
-	req.Header.Set("Authorization", "token "+rd.githubToken)
-	req.Header.Set("Accept", "application/vnd.github.v3+json")
-	req.Header.Set("User-Agent", "CodeLupe-Downloader/1.0")
-
-	resp, err := rd.httpClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+// get issues an authenticated GET for path, retrying after rate limits
+func (g *githubClient) get(ctx context.Context, path string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if err := g.waitForPause(ctx); err != nil {
+			return nil, err
+		}
+		if g.limiter != nil {
+			if err := g.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
 
-	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
-	}
+		req, err := http.NewRequestWithContext(ctx, "GET", g.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	var githubRepo GitHubRepo
-	if err := json.NewDecoder(resp.Body).Decode(&githubRepo); err != nil {
-		return "", err
-	}
+		resp, err := g.http.Do(req)
+		if err != nil {
+			return nil, err
+		}
 
-	return githubRepo.Language, nil
-}
+		if remaining, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+			metrics.SetGauge("downloader_github_ratelimit_remaining", float64(remaining))
+		}
+		g.adjustRate(resp.Header, time.Now())
 
-func (rd *RepoDownloader) downloadRepo(repo *RepoInfo) error {
-	// Try to fetch language info from GitHub API if missing
-	if repo.Language == "" {
-		if lang, err := rd.fetchGitHubLanguage(repo.FullName); err == nil && lang != "" {
-			repo.Language = lang
-			log.Printf("Updated language for %s: %s", repo.FullName, lang)
+		if !isRateLimitedResponse(resp) {
+			return resp, nil
 		}
-	}
+		resp.Body.Close()
 
-	passed, score, reason := rd.qualityFilter.evaluateRepo(repo)
+		wait := g.rateLimitWait(resp.Header, time.Now())
+		metrics.IncrCounter("downloader_github_rate_limited_total", 1)
+		if attempt >= g.maxRetries {
+			g.pause(wait)
+			return nil, errGitHubRateLimited
+		}
 
-	if !passed {
-		rd.stats.mu.Lock()
-		rd.stats.Filtered++
-		rd.stats.mu.Unlock()
-		log.Printf("Filtered out %s (score: %d): %s", repo.FullName, score, reason)
-		return nil // Don't hit rate limiter for filtered repos
+		log.Printf("⚠️  GitHub rate limit hit on %s (status %d), pausing GitHub requests for %v", path, resp.StatusCode, wait)
+		g.pause(wait)
 	}
+}
 
-	// Only apply rate limiter for repos we're actually downloading
-	if err := rd.rateLimiter.Wait(context.Background()); err != nil {
+// adjustRate slows the API limiter while the remaining quota is below
+// slowdownBelow, spacing the requests left evenly until the quota resets,
+// and restores the configured rate once it has been refilled
+func (g *githubClient) adjustRate(h http.Header, now time.Time) {
+	if g.limiter == nil || g.slowdownBelow <= 0 {
+		return
+	}
+	remaining, err := strconv.Atoi(h.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	limit := g.baseLimit
+	if remaining < g.slowdownBelow {
+		if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			// An exhausted quota is left to the rate limit pause
+			if untilReset := time.Unix(reset, 0).Sub(now); untilReset > 0 && remaining > 0 {
+				if spread := rate.Limit(float64(remaining) / untilReset.Seconds()); spread < limit {
+					limit = spread
+				}
+			}
+		}
+	}
+	if g.limiter.Limit() != limit {
+		if limit < g.baseLimit {
+			log.Printf("GitHub API quota low (%d remaining), slowing to %.2f requests/s", remaining, float64(limit))
+		} else {
+			log.Printf("GitHub API quota refilled, back to the configured rate")
+		}
+		g.limiter.SetLimit(limit)
+	}
+	metrics.SetGauge("downloader_github_api_rate", float64(g.limiter.Limit()))
+}
+
+// newCloneLimiter returns the limiter for starting downloads: one every
+// interval with up to maxConcurrent at once, or only the worker count's
+// bound when interval is 0
+func newCloneLimiter(interval time.Duration, maxConcurrent int) *rate.Limiter {
+	if interval <= 0 {
+		return rate.NewLimiter(rate.Inf, maxConcurrent)
+	}
+	return rate.NewLimiter(rate.Every(interval), maxConcurrent)
+}
+
+// maxReadmeBytes bounds how much of a README the quality filter reads
+const maxReadmeBytes = 64 << 10
+
+// fetchReadme returns the start of fullName's README, or "" when it has none
+func (g *githubClient) fetchReadme(ctx context.Context, fullName string) (string, error) {
+	resp, err := g.get(ctx, "/repos/"+fullName+"/readme")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	var readme struct {
+		Content  string `json:"content"`
+		Encoding string `json:"encoding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&readme); err != nil {
+		return "", err
+	}
+	if readme.Encoding != "base64" {
+		return "", fmt.Errorf("unexpected README encoding %q", readme.Encoding)
+	}
+	content, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(readme.Content, "\n", ""))
+	if err != nil {
+		return "", fmt.Errorf("invalid README content: %w", err)
+	}
+	if len(content) > maxReadmeBytes {
+		content = content[:maxReadmeBytes]
+	}
+	return string(content), nil
+}
+
+// isRateLimitedResponse recognises GitHub's rate limit responses: 429, or a
+// 403 carrying Retry-After, an exhausted quota, or a secondary rate limit /
+// abuse message. The body of a plain 403 is preserved for the caller.
+func isRateLimitedResponse(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusForbidden:
+	default:
+		return false
+	}
+
+	if resp.Header.Get("Retry-After") != "" || resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return true
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), resp.Body))
+	lower := strings.ToLower(string(body))
+	return strings.Contains(lower, "rate limit") || strings.Contains(lower, "abuse")
+}
+
+// rateLimitWait works out how long GitHub wants us to back off from the
+// Retry-After or X-RateLimit-Reset headers, clamped to [1s, maxWait]
+func (g *githubClient) rateLimitWait(h http.Header, now time.Time) time.Duration {
+	wait := time.Minute // GitHub's advice when no header says otherwise
+
+	if secs, err := strconv.Atoi(h.Get("Retry-After")); err == nil {
+		wait = time.Duration(secs) * time.Second
+	} else if reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		wait = time.Unix(reset, 0).Sub(now)
+	}
+
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if g.maxWait > 0 && wait > g.maxWait {
+		wait = g.maxWait
+	}
+	return wait
+}
+
+// pause stops GitHub traffic for wait, never shortening a longer pause
+func (g *githubClient) pause(wait time.Duration) {
+	until := time.Now().Add(wait).UnixNano()
+	for {
+		current := atomic.LoadInt64(&g.pausedUntil)
+		if current >= until || atomic.CompareAndSwapInt64(&g.pausedUntil, current, until) {
+			return
+		}
+	}
+}
+
+// waitForPause blocks until any rate limit pause has passed
+func (g *githubClient) waitForPause(ctx context.Context) error {
+	for {
+		wait := time.Until(time.Unix(0, atomic.LoadInt64(&g.pausedUntil)))
+		if wait <= 0 {
+			return nil
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// isRateLimitedCloneError reports whether git's stderr shows GitHub
+// throttling the clone
+func isRateLimitedCloneError(stderr string) bool {
+	lower := strings.ToLower(stderr)
+	return strings.Contains(lower, "rate limit") || strings.Contains(lower, "abuse")
+}
+
+// Providers recorded in repositories.host
+const (
+	hostGitHub    = "github"
+	hostGitLab    = "gitlab"
+	hostBitbucket = "bitbucket"
+)
+
+// HostProvider knows how to clone from and query the API of one code host
+type HostProvider interface {
+	// Name is the provider recorded in repositories.host
+	Name() string
+	APIBase() string
+	// Token is the credential configured for this host, if any
+	Token() string
+	// CloneURL returns the git URL for repo, with token embedded if set
+	CloneURL(repo *models.RepoInfo, token string) string
+	// FetchMetadata looks a repository up in the host's API. It may return
+	// nil without error when the host can't usefully be queried.
+	FetchMetadata(ctx context.Context, fullName string) (*RepoMetadata, error)
+}
+
+// newHostProviders maps hostnames to providers: github.com, gitlab.com,
+// bitbucket.org and any self-hosted GitLab instances in gitlabHosts
+func newHostProviders(github *githubClient, httpClient *http.Client, gitlabToken, bitbucketToken string, gitlabHosts []string) map[string]HostProvider {
+	hosts := map[string]HostProvider{
+		"github.com":    &githubProvider{client: github},
+		"gitlab.com":    &gitlabProvider{http: httpClient, apiBase: "https://gitlab.com/api/v4", token: gitlabToken},
+		"bitbucket.org": &bitbucketProvider{http: httpClient, apiBase: "https://api.bitbucket.org/2.0", token: bitbucketToken},
+	}
+	for _, host := range gitlabHosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			hosts[host] = &gitlabProvider{http: httpClient, apiBase: "https://" + host + "/api/v4", token: gitlabToken}
+		}
+	}
+	return hosts
+}
+
+// hostFor picks the provider for a repository from its URL's host
+func (rd *RepoDownloader) hostFor(repoURL string) (HostProvider, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid repository URL %q", repoURL)
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	provider, ok := rd.hosts[host]
+	if !ok {
+		return nil, fmt.Errorf("unsupported code host %q", host)
+	}
+	return provider, nil
+}
+
+// hostName is what gets recorded in repositories.host: the provider name,
+// or the bare hostname for hosts without a provider
+func (rd *RepoDownloader) hostName(repoURL string) string {
+	if provider, err := rd.hostFor(repoURL); err == nil {
+		return provider.Name()
+	}
+	if u, err := url.Parse(repoURL); err == nil {
+		return strings.ToLower(u.Hostname())
+	}
+	return ""
+}
+
+// fetchMetadata looks repo up through its host's API, returning nil if the
+// host is unsupported or reports nothing
+func (rd *RepoDownloader) fetchMetadata(repo *models.RepoInfo) (*RepoMetadata, error) {
+	provider, err := rd.hostFor(repo.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(rd.ctx, 30*time.Second)
+	defer cancel()
+	return provider.FetchMetadata(ctx, repo.FullName)
+}
+
+// fetchReadme returns the README of a GitHub-hosted repo for the quality
+// filter. Other hosts have no README lookup and return "".
+func (rd *RepoDownloader) fetchReadme(repo *models.RepoInfo) (string, error) {
+	provider, err := rd.hostFor(repo.URL)
+	if err != nil || provider.Name() != hostGitHub {
+		return "", nil
+	}
+
+	ctx, cancel := context.WithTimeout(rd.ctx, 30*time.Second)
+	defer cancel()
+	return rd.github.fetchReadme(ctx, repo.FullName)
+}
+
+// authCloneURL returns repoURL as a .git clone URL with user:token embedded
+func authCloneURL(repoURL, user, token string) string {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return repoURL + ".git"
+	}
+	if !strings.HasSuffix(u.Path, ".git") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + ".git"
+	}
+	if token != "" {
+		u.User = url.UserPassword(user, token)
+	}
+	return u.String()
+}
+
+// getJSON decodes a successful API response into v
+func getJSON(ctx context.Context, client *http.Client, apiURL string, header http.Header, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	req.Header.Set("User-Agent", "CodeLupe-Downloader/1.0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned status %d", apiURL, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+type githubProvider struct {
+	client *githubClient
+}
+
+func (p *githubProvider) Name() string    { return hostGitHub }
+func (p *githubProvider) APIBase() string { return p.client.baseURL }
+func (p *githubProvider) Token() string   { return p.client.token }
+
+func (p *githubProvider) CloneURL(repo *models.RepoInfo, token string) string {
+	return authCloneURL(repo.URL, "token", token)
+}
+
+// FetchMetadata returns nil without a token, since the unauthenticated
+// quota is too small to matter
+func (p *githubProvider) FetchMetadata(ctx context.Context, fullName string) (*RepoMetadata, error) {
+	if p.client.token == "" {
+		return nil, nil // No token, skip API call
+	}
+
+	resp, err := p.client.get(ctx, "/repos/"+fullName)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	var githubRepo GitHubRepo
+	if err := json.NewDecoder(resp.Body).Decode(&githubRepo); err != nil {
+		return nil, err
+	}
+
+	return githubRepo.metadata(), nil
+}
+
+// gitlabProvider serves gitlab.com and self-hosted GitLab. Its API doesn't
+// report SPDX ids, so licenses are detected from the checkout.
+type gitlabProvider struct {
+	http    *http.Client
+	apiBase string
+	token   string
+}
+
+func (p *gitlabProvider) Name() string    { return hostGitLab }
+func (p *gitlabProvider) APIBase() string { return p.apiBase }
+func (p *gitlabProvider) Token() string   { return p.token }
+
+func (p *gitlabProvider) CloneURL(repo *models.RepoInfo, token string) string {
+	return authCloneURL(repo.URL, "oauth2", token)
+}
+
+func (p *gitlabProvider) FetchMetadata(ctx context.Context, fullName string) (*RepoMetadata, error) {
+	header := http.Header{}
+	if p.token != "" {
+		header.Set("PRIVATE-TOKEN", p.token)
+	}
+	projectURL := p.apiBase + "/projects/" + url.PathEscape(fullName)
+
+	var project struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := getJSON(ctx, p.http, projectURL, header, &project); err != nil {
+		return nil, err
+	}
+
+	// Languages come back as percentages; the largest is the primary one
+	var languages map[string]float64
+	if err := getJSON(ctx, p.http, projectURL+"/languages", header, &languages); err != nil {
+		return nil, err
+	}
+	meta := &RepoMetadata{DefaultBranch: project.DefaultBranch}
+	best := -1.0
+	for lang, percent := range languages {
+		if percent > best || (percent == best && lang < meta.Language) {
+			meta.Language, best = lang, percent
+		}
+	}
+	return meta, nil
+}
+
+type bitbucketProvider struct {
+	http    *http.Client
+	apiBase string
+	token   string
+}
+
+func (p *bitbucketProvider) Name() string    { return hostBitbucket }
+func (p *bitbucketProvider) APIBase() string { return p.apiBase }
+func (p *bitbucketProvider) Token() string   { return p.token }
+
+// CloneURL uses the user name Bitbucket expects for access tokens
+func (p *bitbucketProvider) CloneURL(repo *models.RepoInfo, token string) string {
+	return authCloneURL(repo.URL, "x-token-auth", token)
+}
+
+func (p *bitbucketProvider) FetchMetadata(ctx context.Context, fullName string) (*RepoMetadata, error) {
+	header := http.Header{}
+	if p.token != "" {
+		header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	var repo struct {
+		Language   string `json:"language"`
+		Size       int64  `json:"size"` // bytes
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	if err := getJSON(ctx, p.http, p.apiBase+"/repositories/"+fullName, header, &repo); err != nil {
+		return nil, err
+	}
+	return &RepoMetadata{
+		Language:      repo.Language,
+		SizeKB:        int(repo.Size / 1024),
+		DefaultBranch: repo.MainBranch.Name,
+	}, nil
+}
+
+// Download methods recorded in repositories.download_method
+const (
+	downloadMethodClone   = "clone"
+	downloadMethodTarball = "tarball"
+)
+
+// tarballMarker is written into tarball downloads, which have no .git
+// directory, so they still count as present on disk
+const tarballMarker = ".codelupe-tarball"
+
+// tarballTimeout bounds downloading and extracting one tarball
+const tarballTimeout = 15 * time.Minute
+
+// chooseDownloadMethod picks a tarball for repos larger than thresholdKB,
+// which regularly time out as git clones. A threshold <= 0 always clones.
+func chooseDownloadMethod(sizeKB, thresholdKB int) string {
+	if thresholdKB > 0 && sizeKB > thresholdKB {
+		return downloadMethodTarball
+	}
+	return downloadMethodClone
+}
+
+// downloadTarball fetches the branch tarball from the GitHub API and
+// extracts it into repoPath
+func (rd *RepoDownloader) downloadTarball(ctx context.Context, fullName, branch, repoPath string) error {
+	resp, err := rd.github.get(ctx, fmt.Sprintf("/repos/%s/tarball/%s", fullName, branch))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("tarball download returned status %d", resp.StatusCode)
+	}
+
+	if err := extractTarball(resp.Body, repoPath); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(repoPath, tarballMarker), []byte(branch+"\n"), 0644)
+}
+
+// extractTarball unpacks a gzipped GitHub tarball into destDir, dropping the
+// archive's "<owner>-<repo>-<sha>/" top-level directory. Entries that would
+// land outside destDir are skipped, as are links, which could point out of it.
+func extractTarball(r io.Reader, destDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Strip the top-level directory
+		name := header.Name
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		} else {
+			continue
+		}
+		if name == "" {
+			continue
+		}
+
+		path := filepath.Join(destDir, name)
+
+		// Security check
+		if !strings.HasPrefix(path, filepath.Clean(destDir)+string(os.PathSeparator)) {
+			continue
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode)&0755|0600)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// performTarballDownload is the tarball counterpart of the clone in
+// performDownload, used for repos above the size threshold
+func (rd *RepoDownloader) performTarballDownload(repo *models.RepoInfo, repoRecord *models.Repository, meta *RepoMetadata, repoPath string) error {
+	startTime := time.Now()
+
+	log.Printf("Downloading tarball of %s (%d MB, branch %s)", repo.FullName, meta.SizeKB/1024, meta.DefaultBranch)
+
+	if repoRecord != nil {
+		rd.updateDownloadStatus(repoRecord.ID, "downloading", "", "")
+	}
+
+	ctx, cancel := context.WithTimeout(rd.ctx, tarballTimeout)
+	defer cancel()
+
+	var touch func()
+	if repoRecord != nil {
+		touch = func() { rd.touchHeartbeat(repoRecord.ID) }
+	}
+	stopHeartbeat := startHeartbeat("downloading tarball of", repo.FullName, startTime, touch)
+	err := rd.downloadTarball(ctx, repo.FullName, meta.DefaultBranch, repoPath)
+	stopHeartbeat()
+
+	if err != nil {
+		os.RemoveAll(repoPath)
+
+		if rd.ctx.Err() != nil {
+			if repoRecord != nil {
+				rd.updateDownloadStatus(repoRecord.ID, "pending", "", "")
+			}
+			return fmt.Errorf("tarball download of %s cancelled: %w", repo.FullName, rd.ctx.Err())
+		}
+
+		errorMsg := fmt.Sprintf("tarball download failed for %s: %v", repo.FullName, err)
+		if repoRecord != nil {
+			rd.updateDownloadStatus(repoRecord.ID, "failed", "", errorMsg)
+		}
+		metrics.IncrCounter("downloader_repos_failed_total", 1)
+		return errors.New(errorMsg)
+	}
+
+	meta.setLicense(repo)
+	if !rd.checkLicense(repo, repoRecord, repoPath) {
+		return nil
+	}
+
+	rd.queueMetadata(repoPath, repoRecord, true)
+
+	if repoRecord != nil {
+		rd.updateDefaultBranch(repoRecord.ID, meta.DefaultBranch)
+		rd.updateDownloadStatus(repoRecord.ID, "downloaded", repoPath, "")
+		rd.setDownloadMethod(repoRecord.ID, downloadMethodTarball)
+	}
+
+	rd.recordDownloaded(repo)
+
+	metrics.ObserveHistogram("downloader_tarball_duration_seconds", time.Since(startTime).Seconds())
+	metrics.IncrCounter("downloader_repos_downloaded_total", 1)
+	metrics.IncrCounter("downloader_tarball_downloads_total", 1)
+
+	log.Printf("✓ Downloaded tarball of %s in %v", repo.FullName, time.Since(startTime))
+	return nil
+}
+
+func (rd *RepoDownloader) downloadRepo(repo *models.RepoInfo) error {
+	verdict, pattern := rd.owners.check(repo.FullName)
+	if verdict == ownerBlocked {
+		rd.stats.mu.Lock()
+		rd.stats.OwnerBlocked++
+		rd.stats.mu.Unlock()
+		metrics.IncrCounter("downloader_repos_owner_blocked_total", 1)
+		log.Printf("Skipping %s: owner matches blocklist pattern %q", repo.FullName, pattern)
+		return nil
+	}
+
+	skip, refresh := rd.checkDownloaded(repo.FullName)
+	if skip {
+		rd.stats.mu.Lock()
+		rd.stats.AlreadyDownloaded++
+		rd.stats.mu.Unlock()
+		metrics.IncrCounter("downloader_repos_already_downloaded_total", 1)
+		return nil
+	}
+	if refresh {
+		if repoPath, exists := rd.locateRepo(repo); exists {
+			return rd.refreshRepo(repo, repoPath)
+		}
+		log.Printf("%s is due for refresh but missing on disk, cloning again", repo.FullName)
+	}
+
+	// The host's API is asked at most once, and only when something below
+	// needs an answer the crawler didn't record
+	var looked bool
+	parentStars := -1
+	lookup := func() {
+		if looked {
+			return
+		}
+		looked = true
+		meta, err := rd.fetchMetadata(repo)
+		if err != nil || meta == nil {
+			return
+		}
+		meta.setLicense(repo)
+		meta.setFork(repo)
+		parentStars = meta.ParentStars
+		if repo.Language == "" && meta.Language != "" {
+			repo.Language = meta.Language
+			log.Printf("Updated language for %s: %s", repo.FullName, meta.Language)
+		}
+	}
+
+	// Try to fetch language info from the host's API if missing
+	if repo.Language == "" {
+		lookup()
+	}
+
+	passed, score, reason := rd.qualityFilter.evaluate(repo, verdict == ownerAllowed)
+
+	if passed && !rd.qualityFilter.includeForks {
+		lookup()
+		passed, reason = rd.qualityFilter.allowsFork(repo, parentStars)
+	}
+
+	// With a token the license is checked before cloning; without one it is
+	// detected from the checkout in checkLicense
+	if passed && rd.licenseFilter.Enabled() {
+		if repo.License == "" {
+			lookup()
+		}
+		if repo.License != "" {
+			passed, reason = rd.licenseFilter.Allows(repo.License)
+		}
+	}
+
+	if !passed {
+		unchanged, err := rd.recordFiltered(repo, score, reason)
+		if err != nil {
+			log.Printf("Failed to record filtered repository %s: %v", repo.FullName, err)
+		}
+
+		rd.stats.mu.Lock()
+		rd.stats.Filtered++
+		if unchanged {
+			rd.stats.FilteredUnchanged++
+		} else {
+			rd.stats.FilteredNew++
+		}
+		rd.stats.mu.Unlock()
+		metrics.IncrCounter("downloader_repos_filtered_total", 1)
+		log.Printf("Filtered out %s (score: %d): %s", repo.FullName, score, reason)
+		return nil // Don't hit rate limiter for filtered repos
+	}
+
+	// Only apply rate limiter for repos we're actually downloading
+	if err := rd.cloneLimiter.Wait(rd.ctx); err != nil {
+		return fmt.Errorf("rate limiter error: %w", err)
+	}
+
+	repoRecord, err := rd.upsertRepository(repo, score)
+	if err != nil {
+		log.Printf("Failed to upsert repository %s: %v", repo.FullName, err)
+	}
+
+	return rd.performDownload(repo, repoRecord)
+}
+
+// loadDownloadedRepos returns the full_name and downloaded_at of every
+// repository Postgres has marked as downloaded
+func (rd *RepoDownloader) loadDownloadedRepos() (map[string]time.Time, error) {
+	rows, err := rd.db.Query(`SELECT full_name, downloaded_at FROM repositories WHERE download_status = 'downloaded'`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	downloaded := make(map[string]time.Time)
+	for rows.Next() {
+		var fullName string
+		var downloadedAt sql.NullTime
+		if err := rows.Scan(&fullName, &downloadedAt); err != nil {
+			return nil, err
+		}
+		// A missing timestamp counts as infinitely old, so it is refreshed
+		downloaded[fullName] = downloadedAt.Time
+	}
+	return downloaded, rows.Err()
+}
+
+// checkDownloaded reports whether a repo is already downloaded and should be
+// skipped, or was downloaded before the refresh cutoff and should be fetched
+func (rd *RepoDownloader) checkDownloaded(fullName string) (skip, refresh bool) {
+	rd.mu.RLock()
+	downloadedAt, ok := rd.downloadedAt[fullName]
+	rd.mu.RUnlock()
+
+	if !ok {
+		return false, false
+	}
+	if rd.refreshAfter > 0 && time.Since(downloadedAt) > rd.refreshAfter {
+		return false, true
+	}
+	return true, false
+}
+
+// refreshRepo brings an existing shallow clone up to date with git fetch
+func (rd *RepoDownloader) refreshRepo(repo *models.RepoInfo, repoPath string) error {
+	if err := rd.cloneLimiter.Wait(rd.ctx); err != nil {
+		return fmt.Errorf("rate limiter error: %w", err)
+	}
+
+	err := rd.syncClone(repo.FullName, repoPath)
+	rd.recordSyncResult(repo.FullName, err)
+	if err != nil {
+		metrics.IncrCounter("downloader_repos_failed_total", 1)
+		return err
+	}
+
+	if _, err := rd.db.Exec(`UPDATE repositories SET downloaded_at = $1 WHERE full_name = $2`, time.Now(), repo.FullName); err != nil {
+		log.Printf("Failed to update downloaded_at for %s: %v", repo.FullName, err)
+	}
+
+	rd.stats.mu.Lock()
+	rd.stats.Refreshed++
+	rd.stats.mu.Unlock()
+	metrics.IncrCounter("downloader_repos_refreshed_total", 1)
+
+	log.Printf("✓ Refreshed %s", repo.FullName)
+	return nil
+}
+
+// syncTimeout bounds the fetch and reset of one existing clone
+const syncTimeout = 3 * time.Minute
+
+// gitEnv is the environment for git commands that talk to a remote: never
+// prompt for credentials, give up on stalled transfers and, unless
+// LFS_SKIP_SMUDGE=false, leave LFS files as pointers
+func (rd *RepoDownloader) gitEnv() []string {
+	env := append(os.Environ(),
+		"GIT_TERMINAL_PROMPT=0",
+		"GIT_ASKPASS=echo",
+		"GIT_HTTP_LOW_SPEED_LIMIT=1000", // Minimum transfer rate (bytes/sec)
+		"GIT_HTTP_LOW_SPEED_TIME=60",    // Timeout if below speed limit
+	)
+	if rd.lfsSkipSmudge {
+		env = append(env, "GIT_LFS_SKIP_SMUDGE=1")
+	}
+	return env
+}
+
+// Submodule modes accepted by --recurse-submodules
+const (
+	submodulesOff    = "off"
+	submodulesDepth1 = "depth:1"
+)
+
+// parseSubmoduleMode reports whether mode asks for submodules to be checked
+// out
+func parseSubmoduleMode(mode string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", submodulesOff:
+		return false, nil
+	case submodulesDepth1:
+		return true, nil
+	}
+	return false, fmt.Errorf("invalid --recurse-submodules %q (expected off or depth:1)", mode)
+}
+
+// checkoutSubmodules shallow-clones the first level of submodules of a
+// fresh clone. Failures are logged and the clone is kept: a repo with an
+// unreachable submodule still has its own code.
+func (rd *RepoDownloader) checkoutSubmodules(fullName, repoPath string) {
+	if _, err := os.Stat(filepath.Join(repoPath, ".gitmodules")); err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(rd.ctx, rd.submoduleTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	stop := startHeartbeat("checking out submodules of", fullName, startTime, nil)
+	cmd := exec.CommandContext(ctx, "git", "-C", repoPath, "submodule", "update", "--init", "--depth", "1")
+	cmd.Env = rd.gitEnv()
+	out, err := cmd.CombinedOutput()
+	stop()
+
+	if err != nil {
+		metrics.IncrCounter("downloader_submodule_failures_total", 1)
+		if ctx.Err() == context.DeadlineExceeded {
+			log.Printf("Submodule checkout of %s timed out after %v, keeping the clone without them", fullName, rd.submoduleTimeout)
+			return
+		}
+		log.Printf("Submodule checkout of %s failed, keeping the clone without them: %v, output: %s", fullName, err, strings.TrimSpace(string(out)))
+		return
+	}
+	log.Printf("Checked out submodules of %s in %v", fullName, time.Since(startTime))
+}
+
+// startHeartbeat logs every 15 seconds that a long git operation is still
+// running, calling onTick (if set) each time, until the returned function is
+// called
+func startHeartbeat(action, fullName string, startTime time.Time, onTick func()) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				log.Printf("Still %s %s... (%v elapsed)", action, fullName, time.Since(startTime))
+				if onTick != nil {
+					onTick()
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// syncClone fast-forwards a shallow clone to the tip of its branch with
+// git fetch --depth 1 followed by git reset --hard origin/<branch>
+func (rd *RepoDownloader) syncClone(fullName, repoPath string) error {
+	branch, err := rd.getDefaultBranch(repoPath)
+	if err != nil || branch == "HEAD" {
+		return fmt.Errorf("failed to determine branch for %s: %v", fullName, err)
+	}
+
+	ctx, cancel := context.WithTimeout(rd.ctx, syncTimeout)
+	defer cancel()
+
+	stop := startHeartbeat("syncing", fullName, time.Now(), nil)
+	defer stop()
+
+	for _, args := range [][]string{
+		{"fetch", "--depth", "1", "origin", branch},
+		{"reset", "--hard", "origin/" + branch},
+	} {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", repoPath}, args...)...)
+		cmd.Env = rd.gitEnv()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("sync timeout for %s", fullName)
+			}
+			return fmt.Errorf("git %s failed for %s: %v, output: %s", args[0], fullName, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// recordSyncResult stores last_synced_at on success or sync_error on
+// failure. download_status is left alone: the previous clone is still usable.
+func (rd *RepoDownloader) recordSyncResult(fullName string, syncErr error) {
+	var err error
+	if syncErr != nil {
+		_, err = rd.db.Exec(`UPDATE repositories SET sync_error = $1 WHERE full_name = $2`, syncErr.Error(), fullName)
+	} else {
+		_, err = rd.db.Exec(`UPDATE repositories SET last_synced_at = $1, sync_error = NULL WHERE full_name = $2`, time.Now(), fullName)
+	}
+	if err != nil {
+		log.Printf("Failed to record sync result for %s: %v", fullName, err)
+	}
+}
+
+// localClone is a git checkout found in the download directory
+type localClone struct {
+	fullName string
+	path     string
+}
+
+// listClones returns every valid git clone in the download directory, in
+// either the flat <owner>/<repo> or the <language>/<owner>/<repo> layout
+func (rd *RepoDownloader) listClones() ([]localClone, error) {
+	root := filepath.Clean(rd.downloadDir)
+
+	var clones []localClone
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Failed to read %s: %v", path, err)
+			return nil
+		}
+		if !d.IsDir() || path == root {
+			return nil
+		}
+		if strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir
+		}
+
+		depth := strings.Count(strings.TrimPrefix(path, root), string(os.PathSeparator))
+		// Tarball downloads have no git history to fetch into
+		if _, err := os.Stat(filepath.Join(path, ".git")); err == nil && depth >= 2 {
+			if rd.isValidRepo(path) {
+				clones = append(clones, localClone{
+					fullName: filepath.Base(filepath.Dir(path)) + "/" + d.Name(),
+					path:     path,
+				})
+			}
+			return filepath.SkipDir
+		}
+		if depth >= 3 {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return clones, err
+}
+
+// updateRepo syncs one existing clone and refreshes its code metrics
+func (rd *RepoDownloader) updateRepo(fullName, repoPath string) error {
+	if err := rd.cloneLimiter.Wait(rd.ctx); err != nil {
 		return fmt.Errorf("rate limiter error: %w", err)
 	}
 
-	repoRecord, err := rd.upsertRepository(repo, score)
+	err := rd.syncClone(fullName, repoPath)
+	rd.recordSyncResult(fullName, err)
 	if err != nil {
-		log.Printf("Failed to upsert repository %s: %v", repo.FullName, err)
+		metrics.IncrCounter("downloader_sync_failures_total", 1)
+		return err
 	}
 
-	return rd.performDownload(repo, repoRecord)
+	repoRecord := &models.Repository{FullName: fullName}
+	if err := rd.db.QueryRow(`SELECT id FROM repositories WHERE full_name = $1`, fullName).Scan(&repoRecord.ID); err != nil {
+		// Clones without a row still get synced, just without metrics
+		repoRecord = nil
+	}
+	rd.queueMetadata(repoPath, repoRecord, false)
+
+	metrics.IncrCounter("downloader_repos_synced_total", 1)
+	return nil
+}
+
+// updateAll walks every existing clone and brings it up to date
+func (rd *RepoDownloader) updateAll() (err error) {
+	started := time.Now()
+	defer func() { rd.finishRun("update", started, err) }()
+
+	clones, err := rd.listClones()
+	if err != nil {
+		return fmt.Errorf("failed to list clones: %w", err)
+	}
+	log.Printf("Updating %d existing clones", len(clones))
+	rd.stats.mu.Lock()
+	rd.stats.Total = len(clones)
+	rd.stats.mu.Unlock()
+
+	var wg sync.WaitGroup
+	repoChan := make(chan localClone)
+	stopMetadata := rd.startMetadataPool()
+
+	for i := 0; i < rd.maxConcurrent; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for clone := range repoChan {
+				if err := rd.updateRepo(clone.fullName, clone.path); err != nil {
+					rd.recordFailure(clone.fullName, err)
+					log.Printf("✗ Failed to update %s: %v", clone.fullName, err)
+					continue
+				}
+				rd.stats.mu.Lock()
+				rd.stats.Refreshed++
+				rd.stats.mu.Unlock()
+				log.Printf("✓ Updated %s", clone.fullName)
+			}
+		}()
+	}
+
+	for _, clone := range clones {
+		repoChan <- clone
+	}
+	close(repoChan)
+	wg.Wait()
+	stopMetadata()
+
+	rd.stats.mu.RLock()
+	log.Printf("Update complete: %d synced, %d failed", rd.stats.Refreshed, rd.stats.Failed)
+	rd.stats.mu.RUnlock()
+	return nil
+}
+
+// Download directory layouts, selected with LAYOUT
+const (
+	layoutFlat     = "flat"     // <downloadDir>/<owner>/<repo>
+	layoutLanguage = "language" // <downloadDir>/<language>/<owner>/<repo>
+)
+
+// languageDir turns a GitHub language name into a directory name
+func languageDir(language string) string {
+	language = strings.TrimSpace(strings.ToLower(language))
+	if language == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer("++", "pp", "#", "sharp", "/", "-", " ", "-").Replace(language)
+}
+
+// repoPath returns where repo lives under the given layout
+func (rd *RepoDownloader) repoPath(repo *models.RepoInfo, layout string) string {
+	if layout == layoutLanguage {
+		return filepath.Join(rd.downloadDir, languageDir(repo.Language), repo.FullName)
+	}
+	return filepath.Join(rd.downloadDir, repo.FullName)
 }
 
-func (rd *RepoDownloader) performDownload(repo *RepoInfo, repoRecord *Repository) error {
+// locateRepo returns the path of an existing download of repo, checking the
+// configured layout first and then the other one. If there is none it
+// returns where a new download should go.
+func (rd *RepoDownloader) locateRepo(repo *models.RepoInfo) (string, bool) {
+	preferred := rd.repoPath(repo, rd.layout)
+
+	candidates := []string{preferred, rd.repoPath(repo, layoutFlat), rd.repoPath(repo, layoutLanguage)}
+	for _, path := range candidates {
+		if rd.isValidRepo(path) {
+			return path, true
+		}
+	}
+	return preferred, false
+}
+
+// waitForDiskSpace blocks while free space in the download directory is
+// below minFreeBytes, rechecking every diskCheckInterval. It only returns an
+// error when the downloader is shutting down.
+func (rd *RepoDownloader) waitForDiskSpace() error {
+	if rd.minFreeBytes == 0 {
+		return nil
+	}
+
+	paused := false
+	for {
+		free, err := rd.freeSpace(rd.downloadDir)
+		if err != nil {
+			log.Printf("⚠️  Failed to check free disk space: %v", err)
+			return nil
+		}
+		metrics.SetGauge("downloader_disk_free_bytes", float64(free))
+
+		if free >= rd.minFreeBytes {
+			if paused {
+				log.Printf("Disk space recovered (%.1f GB free), resuming downloads", float64(free)/(1<<30))
+				metrics.SetGauge("downloader_disk_paused", 0)
+			}
+			return nil
+		}
+
+		if !paused {
+			paused = true
+			log.Printf("⚠️  Pausing downloads: only %.1f GB free in %s, below MIN_FREE_GB=%.1f",
+				float64(free)/(1<<30), rd.downloadDir, float64(rd.minFreeBytes)/(1<<30))
+			metrics.SetGauge("downloader_disk_paused", 1)
+			metrics.IncrCounter("downloader_disk_pauses_total", 1)
+		}
+
+		select {
+		case <-time.After(rd.diskCheckInterval):
+		case <-rd.ctx.Done():
+			return rd.ctx.Err()
+		}
+	}
+}
+
+func (rd *RepoDownloader) performDownload(repo *models.RepoInfo, repoRecord *models.Repository) error {
 	startTime := time.Now()
 
 	// Track active downloads
-	metrics.IncrCounter("downloader_active_downloads", 1)
-	defer metrics.IncrCounter("downloader_active_downloads", -1)
-
-	repoPath := filepath.Join(rd.downloadDir, repo.FullName)
+	metrics.AddGauge("downloader_active_downloads", 1)
+	defer metrics.AddGauge("downloader_active_downloads", -1)
 
-	// Check if repo exists AND has content (not just an empty directory)
-	if rd.isValidRepo(repoPath) {
+	// Check if repo exists AND has content (not just an empty directory),
+	// in either layout so switching LAYOUT doesn't re-download everything
+	repoPath, exists := rd.locateRepo(repo)
+	if exists {
 		rd.stats.mu.Lock()
 		rd.stats.Skipped++
 		rd.stats.mu.Unlock()
+		metrics.IncrCounter("downloader_repos_skipped_total", 1)
 		log.Printf("Skipping %s (already exists)", repo.FullName)
 
 		if repoRecord != nil && repoRecord.DownloadStatus != "downloaded" {
@@ -532,16 +2210,47 @@ func (rd *RepoDownloader) performDownload(repo *RepoInfo, repoRecord *Repository
 		return nil
 	}
 
+	if err := rd.waitForDiskSpace(); err != nil {
+		return err
+	}
+
 	parentDir := filepath.Dir(repoPath)
 	if err := os.MkdirAll(parentDir, 0755); err != nil {
 		return fmt.Errorf("failed to create parent directory: %w", err)
 	}
 
-	cloneURL := strings.Replace(repo.URL, "https://github.com/", "https://github.com/", 1) + ".git"
+	provider, err := rd.hostFor(repo.URL)
+	if err != nil {
+		if repoRecord != nil {
+			rd.updateDownloadStatus(repoRecord.ID, "failed", "", err.Error())
+		}
+		return err
+	}
+	onGitHub := provider.Name() == hostGitHub
+
+	// Huge repos regularly hit the clone timeout; fetch them as a tarball.
+	// Only the GitHub tarball API is supported.
+	if onGitHub && rd.tarballThresholdKB > 0 {
+		meta, err := rd.fetchMetadata(repo)
+		if err != nil {
+			log.Printf("Failed to look up size of %s, cloning: %v", repo.FullName, err)
+		} else if meta != nil {
+			meta.setLicense(repo)
+			if meta.DefaultBranch != "" &&
+				chooseDownloadMethod(meta.SizeKB, rd.tarballThresholdKB) == downloadMethodTarball {
+				return rd.performTarballDownload(repo, repoRecord, meta, repoPath)
+			}
+		}
+	}
 
 	// Use authentication if available
-	if rd.githubToken != "" {
-		cloneURL = strings.Replace(repo.URL, "https://", fmt.Sprintf("https://token:%s@", rd.githubToken), 1) + ".git"
+	cloneURL := provider.CloneURL(repo, provider.Token())
+
+	// Don't start a clone while GitHub has us paused
+	if onGitHub {
+		if err := rd.github.waitForPause(rd.ctx); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("Cloning %s (★%d, %s, Score: %d)", repo.FullName, repo.Stars, repo.Language, repoRecord.QualityScore)
@@ -550,16 +2259,11 @@ func (rd *RepoDownloader) performDownload(repo *RepoInfo, repoRecord *Repository
 		rd.updateDownloadStatus(repoRecord.ID, "downloading", "", "")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Increased timeout for Windows
+	ctx, cancel := context.WithTimeout(rd.ctx, 5*time.Minute) // Increased timeout for Windows
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", "--single-branch", cloneURL, repoPath)
-	cmd.Env = append(os.Environ(),
-		"GIT_TERMINAL_PROMPT=0",
-		"GIT_ASKPASS=echo",
-		"GIT_HTTP_LOW_SPEED_LIMIT=1000", // Minimum transfer rate (bytes/sec)
-		"GIT_HTTP_LOW_SPEED_TIME=60",    // Timeout if below speed limit
-	)
+	cmd.Env = rd.gitEnv()
 
 	var stderr bytes.Buffer
 	cmd.Stdout = nil
@@ -567,24 +2271,15 @@ func (rd *RepoDownloader) performDownload(repo *RepoInfo, repoRecord *Repository
 
 	log.Printf("Starting clone of %s...", repo.FullName)
 
-	// Start heartbeat goroutine to log progress
-	done := make(chan bool)
-	go func() {
-		ticker := time.NewTicker(15 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-done:
-				return
-			case <-ticker.C:
-				elapsed := time.Since(startTime)
-				log.Printf("Still cloning %s... (%v elapsed)", repo.FullName, elapsed)
-			}
-		}
-	}()
-
-	err := cmd.Run()
-	close(done) // Stop heartbeat
+	// Log progress while the clone runs and keep heartbeat_at fresh so the
+	// row isn't mistaken for one left behind by a crashed run
+	var touch func()
+	if repoRecord != nil {
+		touch = func() { rd.touchHeartbeat(repoRecord.ID) }
+	}
+	stopHeartbeat := startHeartbeat("cloning", repo.FullName, startTime, touch)
+	err = cmd.Run()
+	stopHeartbeat()
 
 	if err != nil {
 		elapsed := time.Since(startTime)
@@ -604,6 +2299,23 @@ func (rd *RepoDownloader) performDownload(repo *RepoInfo, repoRecord *Repository
 		// Clean up any partial download
 		os.RemoveAll(repoPath)
 
+		// Interrupted by shutdown: not the repo's fault, so retry next run
+		if rd.ctx.Err() != nil {
+			if repoRecord != nil {
+				rd.updateDownloadStatus(repoRecord.ID, "pending", "", "")
+			}
+			return fmt.Errorf("clone of %s cancelled: %w", repo.FullName, rd.ctx.Err())
+		}
+
+		// GitHub throttled the clone: pause everyone instead of failing the repo
+		if onGitHub && isRateLimitedCloneError(stderr.String()) {
+			rd.github.pause(cloneRateLimitPause)
+			if repoRecord != nil {
+				rd.updateDownloadStatus(repoRecord.ID, "pending", "", "")
+			}
+			return fmt.Errorf("clone of %s: %w", repo.FullName, errGitHubRateLimited)
+		}
+
 		if repoRecord != nil {
 			rd.updateDownloadStatus(repoRecord.ID, "failed", "", errorMsg)
 		}
@@ -628,28 +2340,34 @@ func (rd *RepoDownloader) performDownload(repo *RepoInfo, repoRecord *Repository
 		return fmt.Errorf(errorMsg)
 	}
 
-	rd.collectRepoMetadata(repoPath, repoRecord)
+	if !rd.checkLicense(repo, repoRecord, repoPath) {
+		return nil
+	}
+
+	if rd.recurseSubmodules {
+		rd.checkoutSubmodules(repo.FullName, repoPath)
+	}
+
+	rd.queueMetadata(repoPath, repoRecord, true)
+	rd.checkMirror(repo, repoRecord, repoPath)
 
 	if repoRecord != nil {
 		rd.updateDownloadStatus(repoRecord.ID, "downloaded", repoPath, "")
+		rd.setDownloadMethod(repoRecord.ID, downloadMethodClone)
 	}
 
-	rd.stats.mu.Lock()
-	rd.stats.Downloaded++
-	rd.stats.mu.Unlock()
+	rd.recordDownloaded(repo)
 
 	// Record success metrics
 	duration := time.Since(startTime).Seconds()
 	metrics.ObserveHistogram("downloader_clone_duration_seconds", duration)
 	metrics.IncrCounter("downloader_repos_downloaded_total", 1)
-	metrics.SetGauge("downloader_last_repo_size_kb", float64(repoRecord.SizeKB))
-	metrics.ObserveHistogram("downloader_repo_lines_of_code", float64(repoRecord.CodeLines))
 
-	log.Printf("✓ Downloaded %s (Lines: %d, Files: %d)", repo.FullName, repoRecord.CodeLines, repoRecord.FileCount)
+	log.Printf("✓ Downloaded %s", repo.FullName)
 	return nil
 }
 
-func (rd *RepoDownloader) downloadWorker(repos <-chan *RepoInfo, wg *sync.WaitGroup) {
+func (rd *RepoDownloader) downloadWorker(repos <-chan *models.RepoInfo, wg *sync.WaitGroup) {
 	defer wg.Done()
 	defer func() {
 		if r := recover(); r != nil {
@@ -658,15 +2376,20 @@ func (rd *RepoDownloader) downloadWorker(repos <-chan *RepoInfo, wg *sync.WaitGr
 	}()
 
 	for repo := range repos {
+		metrics.SetGauge("downloader_queue_depth", float64(len(repos)))
+
+		// Drain whatever is still buffered once shutdown has started
+		if rd.ctx.Err() != nil {
+			continue
+		}
+
 		log.Printf("Worker picked up repo: %s", repo.FullName)
 
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
 					log.Printf("❌ Panic while processing %s: %v", repo.FullName, r)
-					rd.stats.mu.Lock()
-					rd.stats.Failed++
-					rd.stats.mu.Unlock()
+					rd.recordFailure(repo.FullName, fmt.Errorf("panic: %v", r))
 
 					// Remove from processing map
 					rd.mu.Lock()
@@ -701,14 +2424,16 @@ func (rd *RepoDownloader) downloadWorker(repos <-chan *RepoInfo, wg *sync.WaitGr
 			}()
 
 			if err := rd.downloadRepo(repo); err != nil {
-				rd.mu.Lock()
-				rd.failed[repo.FullName] = err
-				rd.mu.Unlock()
-
-				rd.stats.mu.Lock()
-				rd.stats.Failed++
-				rd.stats.mu.Unlock()
+				if rd.ctx.Err() != nil {
+					log.Printf("Stopped %s for shutdown", repo.FullName)
+					return
+				}
+				if errors.Is(err, errGitHubRateLimited) {
+					log.Printf("Deferred %s: %v", repo.FullName, err)
+					return
+				}
 
+				rd.recordFailure(repo.FullName, err)
 				log.Printf("✗ Failed to download %s: %v", repo.FullName, err)
 			} else {
 				rd.mu.Lock()
@@ -721,22 +2446,86 @@ func (rd *RepoDownloader) downloadWorker(repos <-chan *RepoInfo, wg *sync.WaitGr
 	log.Println("Worker finished - channel closed")
 }
 
+// recordDownloaded counts a finished download under its language
+func (rd *RepoDownloader) recordDownloaded(repo *models.RepoInfo) {
+	language := repo.Language
+	if language == "" {
+		language = "unknown"
+	}
+	rd.stats.mu.Lock()
+	defer rd.stats.mu.Unlock()
+	rd.stats.Downloaded++
+	if rd.stats.Languages == nil {
+		rd.stats.Languages = make(map[string]int)
+	}
+	rd.stats.Languages[language]++
+}
+
+// recordFailure counts a failed repo and keeps its error for the end of
+// the run
+func (rd *RepoDownloader) recordFailure(fullName string, err error) {
+	rd.mu.Lock()
+	rd.failed[fullName] = err
+	rd.mu.Unlock()
+
+	rd.stats.mu.Lock()
+	rd.stats.Failed++
+	rd.stats.mu.Unlock()
+}
+
+// statusMux serves Prometheus-style metrics on /metrics and a JSON snapshot
+// of the current run's DownloadStats on /status
+func (rd *RepoDownloader) statusMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		rd.stats.mu.RLock()
+		body, err := json.Marshal(&rd.stats)
+		rd.stats.mu.RUnlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	})
+	return mux
+}
+
 func (rd *RepoDownloader) printStats() {
 	rd.stats.mu.RLock()
 	defer rd.stats.mu.RUnlock()
 
-	log.Printf("Progress: %d/%d downloaded, %d failed, %d skipped, %d filtered",
-		rd.stats.Downloaded, rd.stats.Total, rd.stats.Failed, rd.stats.Skipped, rd.stats.Filtered)
+	log.Printf("Progress: %d/%d downloaded, %d failed, %d skipped, %d filtered (%d new, %d unchanged), %d already downloaded, %d refreshed",
+		rd.stats.Downloaded, rd.stats.Total, rd.stats.Failed, rd.stats.Skipped,
+		rd.stats.Filtered, rd.stats.FilteredNew, rd.stats.FilteredUnchanged,
+		rd.stats.AlreadyDownloaded, rd.stats.Refreshed)
+	if rd.stats.OwnerBlocked > 0 {
+		log.Printf("Owner blocklist: %d skipped", rd.stats.OwnerBlocked)
+	}
+	if rd.github != nil && rd.github.http != nil && rd.github.http.Cache != nil {
+		hits, misses := rd.github.http.Cache.Stats()
+		log.Printf("GitHub cache: %d hits, %d misses", hits, misses)
+	}
 }
 
-func (rd *RepoDownloader) downloadAll() error {
-	repos, err := rd.getAllRepos()
+func (rd *RepoDownloader) downloadAll() (err error) {
+	started := time.Now()
+	defer func() { rd.finishRun("download", started, err) }()
+
+	downloaded, err := rd.loadDownloadedRepos()
 	if err != nil {
-		return fmt.Errorf("failed to get repositories: %w", err)
+		// Not fatal: performDownload still skips clones already on disk
+		log.Printf("⚠️  Failed to load downloaded repos from PostgreSQL: %v", err)
 	}
+	rd.mu.Lock()
+	rd.downloadedAt = downloaded
+	rd.mu.Unlock()
+	log.Printf("%d repos already downloaded according to PostgreSQL", len(downloaded))
 
-	repoChan := make(chan *RepoInfo, 100) // Reduced buffer from 1000 to 100
+	repoChan := make(chan *models.RepoInfo, 100) // Reduced buffer from 1000 to 100
 	var wg sync.WaitGroup
+	stopMetadata := rd.startMetadataPool()
 
 	for i := 0; i < rd.maxConcurrent; i++ {
 		wg.Add(1)
@@ -752,26 +2541,27 @@ func (rd *RepoDownloader) downloadAll() error {
 		}
 	}()
 
-	// Send repos to channel in a separate goroutine to avoid blocking
+	// Stream repos from Elasticsearch straight into the worker queue
+	var streamErr error
 	go func() {
-		log.Printf("Sending %d repos to worker queue...", len(repos))
-		for i, repo := range repos {
-			repoChan <- repo
-			if i > 0 && i%100 == 0 {
-				log.Printf("Queued %d/%d repos...", i, len(repos))
-				// Add small delay every 100 repos to allow memory to be freed
-				time.Sleep(100 * time.Millisecond)
-			}
-		}
-		log.Printf("All %d repos queued, closing channel", len(repos))
-		close(repoChan)
+		defer close(repoChan)
+		_, streamErr = rd.streamRepos(rd.ctx, repoChan)
 	}()
 
 	wg.Wait()
+	stopMetadata()
 	statsTicker.Stop()
 
 	rd.printStats()
 
+	if rd.ctx.Err() != nil {
+		log.Println("Download cycle interrupted by shutdown")
+		return nil
+	}
+	if streamErr != nil {
+		return fmt.Errorf("failed to get repositories: %w", streamErr)
+	}
+
 	if len(rd.failed) > 0 {
 		log.Printf("Failed downloads:")
 		rd.mu.RLock()
@@ -791,10 +2581,13 @@ func (rd *RepoDownloader) downloadAllContinuous(checkInterval time.Duration) err
 		log.Println("========================================")
 		log.Printf("Starting new download cycle at %s", time.Now().Format(time.RFC3339))
 
+		started := time.Now()
 		if err := rd.downloadAll(); err != nil {
 			log.Printf("⚠️  Download cycle failed: %v", err)
+			rd.notifyCycleEnd(fmt.Sprintf("Download cycle failed: %v", err), started)
 		} else {
 			log.Println("✓ Download cycle completed successfully")
+			rd.notifyCycleEnd("Download cycle completed", started)
 		}
 
 		// Clear memory between cycles to prevent accumulation
@@ -810,78 +2603,292 @@ func (rd *RepoDownloader) downloadAllContinuous(checkInterval time.Duration) err
 		rd.stats.Failed = 0
 		rd.stats.Skipped = 0
 		rd.stats.Filtered = 0
+		rd.stats.FilteredNew = 0
+		rd.stats.FilteredUnchanged = 0
+		rd.stats.AlreadyDownloaded = 0
+		rd.stats.Refreshed = 0
+		rd.stats.OwnerBlocked = 0
+		rd.stats.BytesCloned = 0
+		rd.stats.Languages = nil
 		rd.stats.mu.Unlock()
 
 		log.Printf("Memory cleanup completed")
 
 		log.Printf("Waiting %v before next cycle...", checkInterval)
-		time.Sleep(checkInterval)
+		select {
+		case <-time.After(checkInterval):
+		case <-rd.ctx.Done():
+			log.Println("Continuous download stopped")
+			return nil
+		}
 	}
 }
 
-func (rd *RepoDownloader) retryFailed() error {
-	rd.mu.RLock()
-	failedRepos := make([]string, 0, len(rd.failed))
-	for repo := range rd.failed {
-		failedRepos = append(failedRepos, repo)
+// notifyCycleEnd sends the cycle's stats to the configured notifiers. It
+// runs before the stats are reset, and a failed notification is only
+// logged.
+func (rd *RepoDownloader) notifyCycleEnd(summary string, started time.Time) {
+	rd.stats.mu.RLock()
+	stats := map[string]any{
+		"duration":           time.Since(started).Round(time.Second).String(),
+		"total":              rd.stats.Total,
+		"downloaded":         rd.stats.Downloaded,
+		"failed":             rd.stats.Failed,
+		"skipped":            rd.stats.Skipped,
+		"filtered":           rd.stats.Filtered,
+		"already_downloaded": rd.stats.AlreadyDownloaded,
+		"refreshed":          rd.stats.Refreshed,
+	}
+	rd.stats.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err := rd.notifier.Notify(ctx, notify.Event{
+		Type:    notify.EventDownloadCycleCompleted,
+		Source:  "downloader",
+		Summary: summary,
+		Stats:   stats,
+	})
+	if err != nil {
+		log.Printf("⚠️  Failed to send cycle notification: %v", err)
+	}
+}
+
+// maxReportFailureReasons caps the failure reasons a RunReport lists
+const maxReportFailureReasons = 20
+
+// RunReport summarizes one download, retry or update run. finishRun writes
+// it as JSON to the reports directory when the run ends.
+type RunReport struct {
+	Command         string    `json:"command"`
+	WorkerID        string    `json:"worker_id"`
+	StartedAt       time.Time `json:"started_at"`
+	FinishedAt      time.Time `json:"finished_at"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	// Interrupted is set when shutdown stopped the run early
+	Interrupted bool   `json:"interrupted"`
+	Error       string `json:"error,omitempty"`
+	// Outcomes counts repos by what happened to them
+	Outcomes       map[string]int  `json:"outcomes"`
+	FailureReasons []FailureReason `json:"failure_reasons"`
+	BytesCloned    int64           `json:"bytes_cloned"`
+	Languages      map[string]int  `json:"languages"`
+}
+
+// FailureReason is an error message shared by Count failed repos
+type FailureReason struct {
+	Reason string `json:"reason"`
+	Count  int    `json:"count"`
+}
+
+// runReport builds the report of the run started at started from the
+// current stats and failures
+func (rd *RepoDownloader) runReport(command string, started time.Time, runErr error) RunReport {
+	finished := time.Now()
+	report := RunReport{
+		Command:         command,
+		WorkerID:        rd.workerID,
+		StartedAt:       started,
+		FinishedAt:      finished,
+		DurationSeconds: finished.Sub(started).Seconds(),
+		Interrupted:     rd.ctx != nil && rd.ctx.Err() != nil,
+		Languages:       make(map[string]int),
 	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+
+	rd.stats.mu.RLock()
+	report.Outcomes = map[string]int{
+		"total":              rd.stats.Total,
+		"downloaded":         rd.stats.Downloaded,
+		"failed":             rd.stats.Failed,
+		"skipped":            rd.stats.Skipped,
+		"filtered":           rd.stats.Filtered,
+		"filtered_new":       rd.stats.FilteredNew,
+		"filtered_unchanged": rd.stats.FilteredUnchanged,
+		"already_downloaded": rd.stats.AlreadyDownloaded,
+		"refreshed":          rd.stats.Refreshed,
+		"owner_blocked":      rd.stats.OwnerBlocked,
+	}
+	report.BytesCloned = rd.stats.BytesCloned
+	for language, n := range rd.stats.Languages {
+		report.Languages[language] = n
+	}
+	rd.stats.mu.RUnlock()
+
+	rd.mu.RLock()
+	report.FailureReasons = topFailureReasons(rd.failed, maxReportFailureReasons)
 	rd.mu.RUnlock()
+	return report
+}
 
-	if len(failedRepos) == 0 {
-		log.Println("No failed downloads to retry")
-		return nil
+// topFailureReasons groups failures by message, with the repo's name
+// replaced so the same error from different repos counts together, and
+// returns the limit most common
+func topFailureReasons(failed map[string]error, limit int) []FailureReason {
+	counts := make(map[string]int)
+	for fullName, err := range failed {
+		reason := strings.ReplaceAll(err.Error(), fullName, "<repo>")
+		if len(reason) > 300 {
+			reason = strings.ToValidUTF8(reason[:300], "")
+		}
+		counts[reason]++
 	}
 
-	log.Printf("Retrying %d failed downloads", len(failedRepos))
+	reasons := make([]FailureReason, 0, len(counts))
+	for reason, n := range counts {
+		reasons = append(reasons, FailureReason{Reason: reason, Count: n})
+	}
+	sort.Slice(reasons, func(i, j int) bool {
+		if reasons[i].Count != reasons[j].Count {
+			return reasons[i].Count > reasons[j].Count
+		}
+		return reasons[i].Reason < reasons[j].Reason
+	})
+	if len(reasons) > limit {
+		reasons = reasons[:limit]
+	}
+	return reasons
+}
 
-	query := fmt.Sprintf(`{
-		"query": {
-			"terms": {
-				"full_name": [%s]
-			}
-		},
-		"_source": ["full_name", "name", "description", "url", "stars", "forks", "language", "topics", "last_updated", "crawled_at"]
-	}`, `"`+strings.Join(failedRepos, `", "`)+`"`)
+// finishRun writes the run's report to reportsDir and, with
+// RECORD_DOWNLOAD_RUNS, to download_runs. A report that can't be saved is
+// only logged.
+func (rd *RepoDownloader) finishRun(command string, started time.Time, runErr error) {
+	report := rd.runReport(command, started, runErr)
+	if rd.reportsDir != "" {
+		path, err := writeRunReport(rd.reportsDir, report)
+		if err != nil {
+			log.Printf("⚠️  Failed to write run report: %v", err)
+		} else {
+			log.Printf("Run report written to %s", path)
+		}
+	}
+	if rd.recordRuns {
+		if err := rd.recordRun(report); err != nil {
+			log.Printf("⚠️  Failed to record run: %v", err)
+		}
+	}
+}
 
-	req := esapi.SearchRequest{
-		Index: []string{"github-coding-repos"},
-		Body:  strings.NewReader(query),
+// writeRunReport writes report to dir as <command>-<start time>.json and
+// returns its path
+func writeRunReport(dir string, report RunReport) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create reports directory: %w", err)
+	}
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
 	}
+	name := fmt.Sprintf("%s-%s.json", report.Command, report.StartedAt.UTC().Format("20060102T150405Z"))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, append(body, '\n'), 0644); err != nil {
+		return "", fmt.Errorf("failed to write run report: %w", err)
+	}
+	return path, nil
+}
 
-	res, err := req.Do(context.Background(), rd.esClient)
+// recordRun inserts report into download_runs
+func (rd *RepoDownloader) recordRun(report RunReport) error {
+	body, err := json.Marshal(report)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
+	_, err = rd.db.Exec(`
+		INSERT INTO download_runs (command, worker_id, started_at, finished_at, downloaded, failed, bytes_cloned, interrupted, report)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		report.Command, report.WorkerID, report.StartedAt, report.FinishedAt,
+		report.Outcomes["downloaded"], report.Outcomes["failed"], report.BytesCloned, report.Interrupted, body)
+	return err
+}
 
-	var result struct {
-		Hits struct {
-			Hits []struct {
-				Source RepoInfo `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
+// claimFailedRepos resets failed rows that are under the retry cap (and
+// whose error contains errorContains, if set) to pending, bumping their
+// retry_count, and returns them
+func (rd *RepoDownloader) claimFailedRepos(errorContains string, maxRetries int) ([]*models.RepoInfo, error) {
+	rows, err := rd.db.Query(`
+		UPDATE repositories SET download_status = 'pending', retry_count = retry_count + 1
+		WHERE download_status = 'failed'
+			AND retry_count < $1
+			AND ($2 = '' OR error_message ILIKE '%' || $2 || '%')
+		RETURNING full_name, name, description, url, stars, forks, language, topics, last_updated, crawled_at`,
+		maxRetries, errorContains)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim failed repositories: %w", err)
+	}
+	defer rows.Close()
+
+	var repos []*models.RepoInfo
+	for rows.Next() {
+		var repo models.RepoInfo
+		var description, language sql.NullString
+		var crawledAt sql.NullTime
+		if err := rows.Scan(&repo.FullName, &repo.Name, &description, &repo.URL, &repo.Stars, &repo.Forks,
+			&language, pq.Array(&repo.Topics), &repo.LastUpdated, &crawledAt); err != nil {
+			return nil, err
+		}
+		repo.Description = description.String
+		repo.Language = language.String
+		repo.CrawledAt = crawledAt.Time
+		repos = append(repos, &repo)
+	}
+	return repos, rows.Err()
+}
+
+// retryFailed re-downloads repos Postgres has as failed, up to maxRetries
+// attempts per repo
+func (rd *RepoDownloader) retryFailed(errorContains string, maxRetries int) (err error) {
+	if maxRetries <= 0 {
+		return fmt.Errorf("max retries must be positive, got %d", maxRetries)
 	}
+	started := time.Now()
+	defer func() { rd.finishRun("retry", started, err) }()
 
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+	var exhausted int
+	if err := rd.db.QueryRow(`SELECT COUNT(*) FROM repositories WHERE download_status = 'failed' AND retry_count >= $1`,
+		maxRetries).Scan(&exhausted); err != nil {
+		log.Printf("Failed to count exhausted retries: %v", err)
+	} else if exhausted > 0 {
+		log.Printf("%d failed repos have reached the retry limit of %d and will be left alone", exhausted, maxRetries)
+	}
+
+	repos, err := rd.claimFailedRepos(errorContains, maxRetries)
+	if err != nil {
 		return err
 	}
 
-	rd.mu.Lock()
-	rd.failed = make(map[string]error)
-	rd.mu.Unlock()
+	if len(repos) == 0 {
+		log.Println("No failed downloads to retry")
+		return nil
+	}
 
-	repoChan := make(chan *RepoInfo, 10)
+	log.Printf("Retrying %d failed downloads", len(repos))
+	rd.stats.mu.Lock()
+	rd.stats.Total = len(repos)
+	rd.stats.mu.Unlock()
+
+	repoChan := make(chan *models.RepoInfo)
 	var wg sync.WaitGroup
+	stopMetadata := rd.startMetadataPool()
 
-	wg.Add(1)
-	go rd.downloadWorker(repoChan, &wg)
+	for i := 0; i < rd.maxConcurrent; i++ {
+		wg.Add(1)
+		go rd.downloadWorker(repoChan, &wg)
+	}
 
-	for _, hit := range result.Hits.Hits {
-		repoChan <- &hit.Source
+	for _, repo := range repos {
+		if rd.ctx.Err() != nil {
+			break
+		}
+		repoChan <- repo
 	}
 	close(repoChan)
 
 	wg.Wait()
+	stopMetadata()
+	rd.printStats()
 	return nil
 }
 
@@ -894,37 +2901,109 @@ func main() {
 	}()
 
 	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run downloader.go download|continuous|retry [download_directory] [max_concurrent]")
+		log.Fatal("Usage: go run downloader.go download|continuous|retry|update|reevaluate|backfill-metadata|sync-es [--refresh-after=720h] [--error-contains=timeout] [--max-retries=3] [--recurse-submodules=depth:1] [--sync-interval=5m] [download_directory] [max_concurrent]")
+	}
+
+	command := os.Args[1]
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found: %v", err)
+	}
+
+	defaultRefresh, err := secrets.ReadDuration("REFRESH_AFTER", 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fs := flag.NewFlagSet(command, flag.ExitOnError)
+	refreshAfter := fs.Duration("refresh-after", defaultRefresh, "Re-fetch repos downloaded longer ago than this instead of skipping them (0 disables, env REFRESH_AFTER)")
+	errorContains := fs.String("error-contains", "", "retry: only retry failures whose error message contains this text (e.g. timeout)")
+	maxRetries := fs.Int("max-retries", 3, "retry: give up on a repo after this many retries")
+	syncInterval := fs.Duration("sync-interval", 0, "sync-es: repeat the sync this often until stopped (0 syncs once)")
+	submoduleMode := fs.String("recurse-submodules", getEnv("RECURSE_SUBMODULES", submodulesOff), "Check out submodules after cloning: off or depth:1, bounded by SUBMODULE_TIMEOUT (env RECURSE_SUBMODULES)")
+	cfg, err := config.Load(fs, os.Args[2:])
+	if err != nil {
+		log.Fatal(err)
 	}
+	recurseSubmodules, err := parseSubmoduleMode(*submoduleMode)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Configuration:")
+	cfg.Print(log.Writer())
+	args := fs.Args()
 
-	command := os.Args[1]
-	downloadDir := getEnv("REPOS_DIR", "/app/repos")
-	maxConcurrent := 3
+	downloadDir := cfg.Paths.ReposDir
+	maxConcurrent := cfg.Concurrency.Downloads
 
-	if len(os.Args) > 2 {
-		downloadDir = os.Args[2]
+	if len(args) > 0 {
+		downloadDir = args[0]
 	}
-	if len(os.Args) > 3 {
-		if n, err := fmt.Sscanf(os.Args[3], "%d", &maxConcurrent); n != 1 || err != nil {
+	if len(args) > 1 {
+		if n, err := fmt.Sscanf(args[1], "%d", &maxConcurrent); n != 1 || err != nil {
 			log.Fatal("Invalid max_concurrent value")
 		}
 	}
 
-	downloader, err := NewRepoDownloader(downloadDir, maxConcurrent)
+	downloader, err := NewRepoDownloader(cfg, downloadDir, maxConcurrent)
 	if err != nil {
 		log.Fatal("Failed to create downloader:", err)
 	}
 	defer downloader.Close()
+	downloader.refreshAfter = *refreshAfter
+	downloader.recurseSubmodules = recurseSubmodules
+	log.Printf("Clone policy: lfs_skip_smudge=%t recurse_submodules=%t submodule_timeout=%v",
+		downloader.lfsSkipSmudge, recurseSubmodules, downloader.submoduleTimeout)
 
-	// Start metrics HTTP server
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		http.Handle("/metrics", metrics.Handler())
-		log.Printf("📊 Downloader metrics available at http://localhost:9091/metrics")
-		if err := http.ListenAndServe(":9091", nil); err != nil {
-			log.Printf("Metrics server error: %v", err)
+		sig := <-sigChan
+		log.Printf("Received %v, finishing in-flight work and shutting down...", sig)
+		downloader.cancel()
+	}()
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			if err := downloader.owners.reload(); err != nil {
+				log.Printf("⚠️  Keeping the previous owner lists: %v", err)
+				continue
+			}
+			log.Printf("Reloaded owner lists: %s", downloader.owners)
 		}
 	}()
 
+	if n, err := downloader.recoverStaleDownloads(staleDownloadAfter); err != nil {
+		log.Printf("⚠️  Failed to recover stale downloads: %v", err)
+	} else if n > 0 {
+		log.Printf("Recovered %d downloads left in progress by a previous run", n)
+	}
+
+	// Start metrics HTTP server
+	if port := getEnv("METRICS_PORT", "9091"); port != "0" {
+		go func() {
+			log.Printf("📊 Downloader metrics available at http://localhost:%s/metrics (status at /status)", port)
+			if err := http.ListenAndServe(":"+port, downloader.statusMux()); err != nil {
+				log.Printf("Metrics server error: %v", err)
+			}
+		}()
+	}
+
+	// Short runs like retry can finish before Prometheus scrapes them, so
+	// also push to a Pushgateway when METRICS_PUSH_URL is set
+	pusher, err := metrics.NewPusherFromEnv("downloader")
+	if err != nil {
+		log.Fatal(err)
+	}
+	pusher.Start(func(err error) {
+		log.Printf("⚠️  Metrics push failed: %v", err)
+	})
+	exit := func(code int) {
+		flushMetrics(pusher)
+		os.Exit(code)
+	}
+
 	// Set initial gauges
 	metrics.SetGauge("downloader_max_concurrent", float64(maxConcurrent))
 
@@ -942,7 +3021,7 @@ func main() {
 	case "download":
 		if err := downloader.downloadAll(); err != nil {
 			log.Printf("❌ Download failed: %v", err)
-			os.Exit(1)
+			exit(1)
 		}
 		log.Println("Download process completed")
 	case "continuous":
@@ -950,52 +3029,102 @@ func main() {
 		checkInterval := 1 * time.Hour
 		if err := downloader.downloadAllContinuous(checkInterval); err != nil {
 			log.Printf("❌ Continuous download failed: %v", err)
-			os.Exit(1)
+			exit(1)
 		}
 	case "retry":
-		if err := downloader.retryFailed(); err != nil {
+		if err := downloader.retryFailed(*errorContains, *maxRetries); err != nil {
 			log.Printf("❌ Retry failed: %v", err)
-			os.Exit(1)
+			exit(1)
 		}
 		log.Println("Retry process completed")
+	case "update":
+		if err := downloader.updateAll(); err != nil {
+			log.Printf("❌ Update failed: %v", err)
+			exit(1)
+		}
+	case "reevaluate":
+		if err := downloader.reevaluateFiltered(); err != nil {
+			log.Printf("❌ Re-evaluation failed: %v", err)
+			exit(1)
+		}
+	case "backfill-metadata":
+		if err := downloader.backfillMetadata(); err != nil {
+			log.Printf("❌ Metadata backfill failed: %v", err)
+			exit(1)
+		}
+	case "sync-es":
+		if err := downloader.syncElasticsearch(*syncInterval); err != nil {
+			log.Printf("❌ Elasticsearch sync failed: %v", err)
+			exit(1)
+		}
 	default:
-		log.Fatal("Invalid command. Use 'download', 'continuous', or 'retry'")
+		log.Fatal("Invalid command. Use 'download', 'continuous', 'retry', 'update', 'reevaluate', 'backfill-metadata', or 'sync-es'")
 	}
+	flushMetrics(pusher)
 }
 
-func (rd *RepoDownloader) upsertRepository(repo *RepoInfo, qualityScore int) (*Repository, error) {
-	var repoRecord Repository
+// flushMetrics pushes the final metrics to the Pushgateway, if configured,
+// and writes them to METRICS_DUMP_FILE so batch runs keep their results
+func flushMetrics(pusher *metrics.Pusher) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := pusher.Stop(ctx); err != nil {
+		log.Printf("⚠️  Failed to push final metrics: %v", err)
+	}
+	if err := metrics.DumpToFile(os.Getenv("METRICS_DUMP_FILE")); err != nil {
+		log.Printf("⚠️  Failed to dump metrics: %v", err)
+	}
+}
 
-	parts := strings.Split(repo.FullName, "/")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid repository full name: %s", repo.FullName)
+// splitFullName splits owner/name. GitLab projects can sit in nested
+// groups, so the owner is everything before the last slash.
+func splitFullName(fullName string) (owner, name string, err error) {
+	i := strings.LastIndex(fullName, "/")
+	if i <= 0 || i == len(fullName)-1 || strings.HasPrefix(fullName, "/") || strings.Contains(fullName, "//") {
+		return "", "", fmt.Errorf("invalid repository full name: %s", fullName)
 	}
+	return fullName[:i], fullName[i+1:], nil
+}
 
-	ownerLogin := parts[0]
-	repoName := parts[1]
-	cloneURL := repo.URL + ".git"
+func (rd *RepoDownloader) upsertRepository(repo *models.RepoInfo, qualityScore int) (*models.Repository, error) {
+	var repoRecord models.Repository
+
+	ownerLogin, repoName, err := splitFullName(repo.FullName)
+	if err != nil {
+		return nil, err
+	}
+	cloneURL := authCloneURL(repo.URL, "", "")
 
 	// Use PostgreSQL UPSERT (INSERT ... ON CONFLICT) to handle duplicates
 	upsertQuery := `
 		INSERT INTO repositories (
 			full_name, name, description, url, clone_url, language, stars, forks,
-			last_updated, crawled_at, download_status, topics, owner_login, quality_score
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			last_updated, crawled_at, download_status, topics, owner_login, quality_score, host,
+			is_fork, parent_full_name
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, NULLIF($15, ''), $16, NULLIF($17, ''))
 		ON CONFLICT (full_name) DO UPDATE SET
+			host = COALESCE(EXCLUDED.host, repositories.host),
 			description = EXCLUDED.description,
 			stars = EXCLUDED.stars,
 			forks = EXCLUDED.forks,
 			language = EXCLUDED.language,
 			last_updated = EXCLUDED.last_updated,
 			topics = EXCLUDED.topics,
-			quality_score = EXCLUDED.quality_score
+			quality_score = EXCLUDED.quality_score,
+			is_fork = EXCLUDED.is_fork,
+			parent_full_name = EXCLUDED.parent_full_name,
+			-- a repo filtered out earlier that now passes goes back to pending
+			download_status = CASE WHEN repositories.download_status = 'filtered'
+				THEN 'pending' ELSE repositories.download_status END,
+			filter_reason = NULL
 		RETURNING id, full_name, download_status, quality_score, created_at`
 
 	topicsArray := pq.Array(repo.Topics)
-	err := rd.db.QueryRow(upsertQuery,
+	err = rd.db.QueryRow(upsertQuery,
 		repo.FullName, repoName, repo.Description, repo.URL, cloneURL,
 		repo.Language, repo.Stars, repo.Forks, repo.LastUpdated, repo.CrawledAt,
-		"pending", topicsArray, ownerLogin, qualityScore,
+		"pending", topicsArray, ownerLogin, qualityScore, rd.hostName(repo.URL),
+		repo.IsFork, repo.ParentFullName,
 	).Scan(&repoRecord.ID, &repoRecord.FullName, &repoRecord.DownloadStatus, &repoRecord.QualityScore, &repoRecord.CreatedAt)
 
 	if err != nil {
@@ -1006,18 +3135,150 @@ func (rd *RepoDownloader) upsertRepository(repo *RepoInfo, qualityScore int) (*R
 	return &repoRecord, nil
 }
 
+// recordFiltered upserts a repo that failed the quality filter with
+// download_status 'filtered' and the rejection reason. Rows that are already
+// downloading or downloaded are left untouched. It reports whether the repo
+// was already filtered for the same reason.
+func (rd *RepoDownloader) recordFiltered(repo *models.RepoInfo, qualityScore int, reason string) (unchanged bool, err error) {
+	ownerLogin, repoName, err := splitFullName(repo.FullName)
+	if err != nil {
+		return false, err
+	}
+
+	// The CTE sees the row as it was before this statement
+	query := `
+		WITH previous AS (
+			SELECT download_status, filter_reason FROM repositories WHERE full_name = $1
+		)
+		INSERT INTO repositories (
+			full_name, name, description, url, clone_url, language, stars, forks,
+			last_updated, crawled_at, download_status, topics, owner_login, quality_score, filter_reason, host,
+			is_fork, parent_full_name
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, 'filtered', $11, $12, $13, $14, NULLIF($15, ''), $16, NULLIF($17, ''))
+		ON CONFLICT (full_name) DO UPDATE SET
+			host = COALESCE(EXCLUDED.host, repositories.host),
+			description = EXCLUDED.description,
+			stars = EXCLUDED.stars,
+			forks = EXCLUDED.forks,
+			language = EXCLUDED.language,
+			last_updated = EXCLUDED.last_updated,
+			topics = EXCLUDED.topics,
+			quality_score = EXCLUDED.quality_score,
+			is_fork = EXCLUDED.is_fork,
+			parent_full_name = EXCLUDED.parent_full_name,
+			download_status = 'filtered',
+			filter_reason = EXCLUDED.filter_reason
+		WHERE repositories.download_status NOT IN ('downloading', 'downloaded')
+		RETURNING (SELECT download_status FROM previous), (SELECT filter_reason FROM previous)`
+
+	var prevStatus, prevReason sql.NullString
+	err = rd.db.QueryRow(query,
+		repo.FullName, repoName, repo.Description, repo.URL, authCloneURL(repo.URL, "", ""),
+		repo.Language, repo.Stars, repo.Forks, repo.LastUpdated, repo.CrawledAt,
+		pq.Array(repo.Topics), ownerLogin, qualityScore, reason, rd.hostName(repo.URL),
+		repo.IsFork, repo.ParentFullName,
+	).Scan(&prevStatus, &prevReason)
+	if err == sql.ErrNoRows {
+		// Already downloaded under older thresholds; nothing changed
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record filtered repository: %w", err)
+	}
+
+	return prevStatus.String == "filtered" && prevReason.String == reason, nil
+}
+
+// reevaluateFiltered re-runs the quality filter over every filtered row,
+// promoting repos that now pass back to pending and refreshing the reason
+// for the rest
+func (rd *RepoDownloader) reevaluateFiltered() error {
+	rows, err := rd.db.Query(`
+		SELECT full_name, name, description, url, stars, forks, language, topics, license_key,
+			COALESCE(is_fork, FALSE), parent_full_name
+		FROM repositories WHERE download_status = 'filtered'`)
+	if err != nil {
+		return fmt.Errorf("failed to query filtered repositories: %w", err)
+	}
+
+	var repos []*models.RepoInfo
+	for rows.Next() {
+		var repo models.RepoInfo
+		var description, language, licenseKey, parent sql.NullString
+		if err := rows.Scan(&repo.FullName, &repo.Name, &description, &repo.URL,
+			&repo.Stars, &repo.Forks, &language, pq.Array(&repo.Topics), &licenseKey,
+			&repo.IsFork, &parent); err != nil {
+			rows.Close()
+			return err
+		}
+		repo.Description = description.String
+		repo.Language = language.String
+		repo.License = licenseKey.String
+		repo.ParentFullName = parent.String
+		repos = append(repos, &repo)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	log.Printf("Re-evaluating %d filtered repositories", len(repos))
+
+	promoted := 0
+	for _, repo := range repos {
+		verdict, pattern := rd.owners.check(repo.FullName)
+		passed, score, reason := rd.qualityFilter.evaluate(repo, verdict == ownerAllowed)
+		if verdict == ownerBlocked {
+			passed, reason = false, fmt.Sprintf("owner matches blocklist pattern %q", pattern)
+		}
+		if passed {
+			// The parent's stars aren't stored, so only include_forks
+			// promotes a filtered fork
+			passed, reason = rd.qualityFilter.allowsFork(repo, -1)
+		}
+		if passed && repo.License != "" {
+			passed, reason = rd.licenseFilter.Allows(repo.License)
+		}
+
+		var err error
+		if passed {
+			_, err = rd.db.Exec(`UPDATE repositories SET download_status = 'pending', filter_reason = NULL, quality_score = $1 WHERE full_name = $2`,
+				score, repo.FullName)
+			if err == nil {
+				promoted++
+				log.Printf("Promoted %s (score: %d)", repo.FullName, score)
+			}
+		} else {
+			_, err = rd.db.Exec(`UPDATE repositories SET filter_reason = $1, quality_score = $2 WHERE full_name = $3`,
+				reason, score, repo.FullName)
+		}
+		if err != nil {
+			log.Printf("Failed to update %s: %v", repo.FullName, err)
+		}
+	}
+
+	log.Printf("Re-evaluation complete: %d promoted to pending, %d still filtered", promoted, len(repos)-promoted)
+	return nil
+}
+
 func (rd *RepoDownloader) updateDownloadStatus(repoID, status, localPath, errorMessage string) {
 	var query string
 	var args []interface{}
 
 	if status == "downloaded" {
-		query = `UPDATE repositories SET download_status = $1, downloaded_at = $2, local_path = $3 WHERE id = $4`
+		query = `UPDATE repositories SET download_status = $1, downloaded_at = $2, local_path = $3, worker_id = NULL WHERE id = $4`
 		args = []interface{}{status, time.Now(), localPath, repoID}
 	} else if status == "failed" {
-		query = `UPDATE repositories SET download_status = $1, error_message = $2 WHERE id = $3`
+		query = `UPDATE repositories SET download_status = $1, error_message = $2, worker_id = NULL WHERE id = $3`
 		args = []interface{}{status, errorMessage, repoID}
+	} else if status == "filtered" {
+		query = `UPDATE repositories SET download_status = $1, filter_reason = $2, worker_id = NULL WHERE id = $3`
+		args = []interface{}{status, errorMessage, repoID}
+	} else if status == "downloading" {
+		query = `UPDATE repositories SET download_status = $1, worker_id = $2, heartbeat_at = $3 WHERE id = $4`
+		args = []interface{}{status, rd.workerID, time.Now(), repoID}
 	} else {
-		query = `UPDATE repositories SET download_status = $1 WHERE id = $2`
+		query = `UPDATE repositories SET download_status = $1, worker_id = NULL WHERE id = $2`
 		args = []interface{}{status, repoID}
 	}
 
@@ -1027,14 +3288,169 @@ func (rd *RepoDownloader) updateDownloadStatus(repoID, status, localPath, errorM
 	}
 }
 
-func (rd *RepoDownloader) collectRepoMetadata(repoPath string, repoRecord *Repository) {
+// touchHeartbeat marks a downloading row as still being worked on
+func (rd *RepoDownloader) touchHeartbeat(repoID string) {
+	if _, err := rd.db.Exec(`UPDATE repositories SET heartbeat_at = $1 WHERE id = $2`, time.Now(), repoID); err != nil {
+		log.Printf("Failed to update heartbeat for %s: %v", repoID, err)
+	}
+}
+
+// staleDownloadAfter is how long a downloading row may go without a
+// heartbeat before it is assumed to belong to a crashed run
+const staleDownloadAfter = 10 * time.Minute
+
+// recoverStaleDownloads resets rows left in downloading by a crashed run
+// (this worker's id, or no heartbeat within staleAfter) to pending and
+// removes their partial clone directories
+func (rd *RepoDownloader) recoverStaleDownloads(staleAfter time.Duration) (int, error) {
+	rows, err := rd.db.Query(`
+		UPDATE repositories SET download_status = 'pending', worker_id = NULL
+		WHERE download_status = 'downloading'
+			AND (worker_id = $1 OR heartbeat_at IS NULL OR heartbeat_at < $2)
+		RETURNING full_name, language`, rd.workerID, time.Now().Add(-staleAfter))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	recovered := 0
+	for rows.Next() {
+		var fullName string
+		var language sql.NullString
+		if err := rows.Scan(&fullName, &language); err != nil {
+			return recovered, err
+		}
+		recovered++
+
+		// The clone never finished, so whatever is on disk is partial
+		repoPath := rd.repoPath(&models.RepoInfo{FullName: fullName, Language: language.String}, rd.layout)
+		if err := os.RemoveAll(repoPath); err != nil {
+			log.Printf("Failed to remove partial clone %s: %v", repoPath, err)
+		}
+		log.Printf("Recovered stale download %s", fullName)
+	}
+	return recovered, rows.Err()
+}
+
+// resetInFlight returns this worker's downloading rows to pending so an
+// interrupted run doesn't leave them stuck
+func (rd *RepoDownloader) resetInFlight() {
+	res, err := rd.db.Exec(`UPDATE repositories SET download_status = 'pending', worker_id = NULL WHERE download_status = 'downloading' AND worker_id = $1`, rd.workerID)
+	if err != nil {
+		log.Printf("Failed to reset in-flight downloads: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Reset %d in-flight downloads to pending", n)
+	}
+}
+
+// checkLicense records the repo's license, detecting it from the checkout
+// when the GitHub API didn't supply one, and removes downloads the license
+// filter rejects. It reports whether the download was kept.
+func (rd *RepoDownloader) checkLicense(repo *models.RepoInfo, repoRecord *models.Repository, repoPath string) bool {
+	if repo.License == "" {
+		id, err := license.Detect(repoPath)
+		if err != nil {
+			log.Printf("Failed to detect license of %s: %v", repo.FullName, err)
+			id = license.NoAssertion
+		}
+		repo.License = id
+	}
+
+	if repoRecord != nil {
+		rd.updateLicense(repoRecord.ID, repo.License, repo.LicenseName)
+		repoRecord.LicenseKey = repo.License
+		repoRecord.LicenseName = repo.LicenseName
+	}
+
+	allowed, reason := rd.licenseFilter.Allows(repo.License)
+	if allowed {
+		return true
+	}
+
+	os.RemoveAll(repoPath)
+	if repoRecord != nil {
+		rd.updateDownloadStatus(repoRecord.ID, "filtered", "", reason)
+	}
+
+	rd.stats.mu.Lock()
+	rd.stats.Filtered++
+	rd.stats.FilteredNew++
+	rd.stats.mu.Unlock()
+	metrics.IncrCounter("downloader_repos_filtered_total", 1)
+	metrics.IncrCounter("downloader_repos_license_filtered_total", 1)
+
+	log.Printf("Filtered out %s after download: %s", repo.FullName, reason)
+	return false
+}
+
+// checkMirror records the clone's HEAD commit and flags the repo as a mirror
+// when an older repo with the same name has the same HEAD. Mirrors stay on
+// disk but are left out of exports. Tarballs have no commit to compare.
+func (rd *RepoDownloader) checkMirror(repo *models.RepoInfo, repoRecord *models.Repository, repoPath string) {
 	if repoRecord == nil {
 		return
 	}
 
+	head, err := rd.getHeadCommit(repoPath)
+	if err != nil {
+		log.Printf("Failed to read HEAD of %s: %v", repo.FullName, err)
+		return
+	}
+	repoRecord.HeadCommit = head
+
+	_, name, err := splitFullName(repo.FullName)
+	if err != nil {
+		return
+	}
+
+	var mirrorOf sql.NullString
+	err = rd.db.QueryRow(`
+		UPDATE repositories SET head_commit = $1, mirror_of = (
+			SELECT full_name FROM repositories
+			WHERE head_commit = $1 AND LOWER(name) = LOWER($2) AND id <> $3 AND mirror_of IS NULL
+			ORDER BY created_at LIMIT 1
+		)
+		WHERE id = $3
+		RETURNING mirror_of`, head, name, repoRecord.ID).Scan(&mirrorOf)
+	if err != nil {
+		log.Printf("Failed to check %s for mirrors: %v", repo.FullName, err)
+		return
+	}
+
+	if mirrorOf.Valid {
+		repoRecord.MirrorOf = mirrorOf.String
+		metrics.IncrCounter("downloader_repos_mirrors_total", 1)
+		log.Printf("%s is a mirror of %s (HEAD %s)", repo.FullName, mirrorOf.String, head)
+	}
+}
+
+// getHeadCommit returns the commit hash checked out in repoPath
+func (rd *RepoDownloader) getHeadCommit(repoPath string) (string, error) {
+	cmd := exec.Command("git", "-C", repoPath, "rev-parse", "HEAD")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+// collectRepoMetadata measures a checkout and stores the results. The
+// default branch is best effort, as tarballs have no .git; failing to size
+// or count the checkout is returned.
+func (rd *RepoDownloader) collectRepoMetadata(repoPath string, repoRecord *models.Repository) error {
+	if repoRecord == nil {
+		return nil
+	}
+
+	var errs []error
 	if sizeKB, err := rd.getDirectorySize(repoPath); err == nil {
 		rd.updateRepoSize(repoRecord.ID, sizeKB)
 		repoRecord.SizeKB = sizeKB
+	} else {
+		errs = append(errs, fmt.Errorf("size: %w", err))
 	}
 
 	if branch, err := rd.getDefaultBranch(repoPath); err == nil {
@@ -1046,22 +3462,245 @@ func (rd *RepoDownloader) collectRepoMetadata(repoPath string, repoRecord *Repos
 		rd.updateCodeMetrics(repoRecord.ID, codeLines, fileCount)
 		repoRecord.CodeLines = codeLines
 		repoRecord.FileCount = fileCount
+	} else {
+		errs = append(errs, fmt.Errorf("line count: %w", err))
 	}
+
+	repoRecord.HasSubmodules, repoRecord.HasLFS = inspectCheckout(repoPath)
+	rd.updateCheckoutFlags(repoRecord.ID, repoRecord.HasSubmodules, repoRecord.HasLFS)
+	return errors.Join(errs...)
 }
 
-func (rd *RepoDownloader) getDirectorySize(path string) (int, error) {
-	cmd := exec.Command("du", "-sk", path)
-	output, err := cmd.Output()
+// Values of repositories.metadata_status
+const (
+	metadataPending  = "pending"
+	metadataComplete = "complete"
+	metadataFailed   = "failed"
+)
+
+// metadataJob is a checkout waiting to be measured. record is a copy, so
+// the download worker that queued it can carry on with its own.
+type metadataJob struct {
+	repoPath string
+	record   models.Repository
+	// downloaded records the download metrics that need the checkout's
+	// size, which only the metadata worker knows
+	downloaded bool
+}
+
+// metadataPool is a bounded set of workers collecting checkout metadata
+type metadataPool struct {
+	jobs chan metadataJob
+	wg   sync.WaitGroup
+}
+
+// startMetadataPool starts metadataWorkers workers measuring the checkouts
+// queued with queueMetadata. The returned function stops taking jobs and
+// waits for the queued ones.
+func (rd *RepoDownloader) startMetadataPool() (stop func()) {
+	workers := rd.metadataWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	pool := &metadataPool{jobs: make(chan metadataJob, workers*10)}
+	for i := 0; i < workers; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for job := range pool.jobs {
+				metrics.SetGauge("downloader_metadata_queue_depth", float64(len(pool.jobs)))
+				rd.runMetadataJob(&job)
+			}
+		}()
+	}
+	rd.metadata = pool
+
+	return func() {
+		close(pool.jobs)
+		pool.wg.Wait()
+		rd.metadata = nil
+		metrics.SetGauge("downloader_metadata_queue_depth", 0)
+	}
+}
+
+// queueMetadata hands a finished checkout to the metadata pool, or
+// collects its metadata inline when no pool is running. The repo is marked
+// pending first, so an interrupted run leaves it for backfill-metadata.
+func (rd *RepoDownloader) queueMetadata(repoPath string, repoRecord *models.Repository, downloaded bool) {
+	if repoRecord == nil {
+		return
+	}
+	job := metadataJob{repoPath: repoPath, record: *repoRecord, downloaded: downloaded}
+	rd.setMetadataStatus(repoRecord.ID, metadataPending)
+
+	if rd.metadata == nil {
+		rd.runMetadataJob(&job)
+		*repoRecord = job.record
+		return
+	}
+	select {
+	case rd.metadata.jobs <- job:
+	case <-rd.ctx.Done():
+	}
+}
+
+// runMetadataJob collects a checkout's metadata and records the outcome in
+// metadata_status. Jobs still queued at shutdown stay pending.
+func (rd *RepoDownloader) runMetadataJob(job *metadataJob) {
+	if rd.ctx.Err() != nil {
+		return
+	}
+
+	startTime := time.Now()
+	record := &job.record
+	if err := rd.collectRepoMetadata(job.repoPath, record); err != nil {
+		log.Printf("⚠️  Incomplete metadata for %s: %v", record.FullName, err)
+		rd.setMetadataStatus(record.ID, metadataFailed)
+		metrics.IncrCounter("downloader_metadata_failures_total", 1)
+		return
+	}
+	rd.setMetadataStatus(record.ID, metadataComplete)
+	metrics.ObserveHistogram("downloader_metadata_duration_seconds", time.Since(startTime).Seconds())
+
+	if job.downloaded {
+		metrics.SetGauge("downloader_last_repo_size_kb", float64(record.SizeKB))
+		metrics.IncrCounter("downloader_bytes_cloned_total", int64(record.SizeKB)*1024)
+		rd.stats.mu.Lock()
+		rd.stats.BytesCloned += int64(record.SizeKB) * 1024
+		rd.stats.mu.Unlock()
+		metrics.ObserveHistogram("downloader_repo_lines_of_code", float64(record.CodeLines))
+		log.Printf("Measured %s (Lines: %d, Files: %d)", record.FullName, record.CodeLines, record.FileCount)
+	}
+}
+
+func (rd *RepoDownloader) setMetadataStatus(repoID, status string) {
+	_, err := rd.db.Exec(`UPDATE repositories SET metadata_status = $1 WHERE id = $2`, status, repoID)
 	if err != nil {
-		return 0, err
+		log.Printf("Failed to update metadata status for %s: %v", repoID, err)
+	}
+}
+
+// backfillMetadata collects the metadata of downloaded repos whose
+// collection never finished or failed, e.g. because the downloader was
+// stopped with jobs still queued
+func (rd *RepoDownloader) backfillMetadata() error {
+	rows, err := rd.db.Query(`
+		SELECT id, full_name, local_path FROM repositories
+		WHERE download_status = 'downloaded' AND local_path IS NOT NULL
+		  AND metadata_status IS DISTINCT FROM 'complete'
+		ORDER BY updated_at`)
+	if err != nil {
+		return fmt.Errorf("failed to list repos with incomplete metadata: %w", err)
+	}
+	var jobs []metadataJob
+	for rows.Next() {
+		var job metadataJob
+		if err := rows.Scan(&job.record.ID, &job.record.FullName, &job.repoPath); err != nil {
+			rows.Close()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	log.Printf("Backfilling metadata of %d repos", len(jobs))
+
+	stop := rd.startMetadataPool()
+	for _, job := range jobs {
+		if rd.ctx.Err() != nil {
+			break
+		}
+		if _, err := os.Stat(job.repoPath); err != nil {
+			log.Printf("Skipping %s: %v", job.record.FullName, err)
+			continue
+		}
+		rd.metadata.jobs <- job
+	}
+	stop()
+
+	log.Printf("Metadata backfill complete")
+	return nil
+}
+
+// syncElasticsearch copies repositories rows changed since the last sync
+// into models.EnrichedRepoIndex, once or every interval until stopped. In
+// the loop a failed sync is logged and retried on the next tick, picking up
+// from the last row indexed.
+func (rd *RepoDownloader) syncElasticsearch(interval time.Duration) error {
+	syncer := essync.New(rd.db, rd.esClient)
+	if err := syncer.EnsureIndex(rd.ctx); err != nil {
+		return err
+	}
+
+	for {
+		result, err := syncer.Sync(rd.ctx)
+		log.Printf("Indexed %d repositories into %s, synced through %s",
+			result.Indexed, models.EnrichedRepoIndex, result.Watermark.UpdatedAt.Format(time.RFC3339))
+		if result.Rejected > 0 {
+			log.Printf("⚠️  %d repositories were rejected by Elasticsearch and recorded in es_sync_failures", result.Rejected)
+		}
+		if interval <= 0 {
+			return err
+		}
+		if err != nil {
+			log.Printf("⚠️  Elasticsearch sync failed: %v", err)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-rd.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// inspectCheckout reports whether a checkout declares submodules in
+// .gitmodules and tracks files with Git LFS in .gitattributes
+func inspectCheckout(repoPath string) (hasSubmodules, hasLFS bool) {
+	if info, err := os.Stat(filepath.Join(repoPath, ".gitmodules")); err == nil && info.Mode().IsRegular() {
+		hasSubmodules = true
+	}
+	if data, err := os.ReadFile(filepath.Join(repoPath, ".gitattributes")); err == nil {
+		hasLFS = bytes.Contains(data, []byte("filter=lfs"))
+	}
+	return hasSubmodules, hasLFS
+}
+
+// lfsPointerPrefix starts every Git LFS pointer file
+const lfsPointerPrefix = "version https://git-lfs.github.com/spec/v1"
+
+// maxLFSPointerSize is the largest file checked for being a pointer; real
+// pointers are around 130 bytes
+const maxLFSPointerSize = 1024
+
+// isLFSPointer reports whether the file at path is a Git LFS pointer left
+// in place of the real content
+func isLFSPointer(path string, size int64) bool {
+	if size > maxLFSPointerSize || size < int64(len(lfsPointerPrefix)) {
+		return false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
 	}
+	defer f.Close()
 
-	fields := strings.Fields(string(output))
-	if len(fields) < 1 {
-		return 0, fmt.Errorf("unexpected du output")
+	head := make([]byte, len(lfsPointerPrefix))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
 	}
+	return string(head) == lfsPointerPrefix
+}
 
-	return strconv.Atoi(fields[0])
+// getDirectorySize returns the size of path in KB, including .git
+func (rd *RepoDownloader) getDirectorySize(path string) (int, error) {
+	size, err := fsutil.DirSize(path, false)
+	if err != nil {
+		return 0, err
+	}
+	return int((size + 1023) / 1024), nil
 }
 
 func (rd *RepoDownloader) getDefaultBranch(repoPath string) (string, error) {
@@ -1106,6 +3745,10 @@ func (rd *RepoDownloader) analyzeCodeContent(repoPath string) (int, int, error)
 		if !codeExtensions[ext] {
 			return nil
 		}
+		// An LFS pointer is a stand-in for content that wasn't downloaded
+		if isLFSPointer(path, info.Size()) {
+			return nil
+		}
 
 		if lines, err := rd.countLines(path); err == nil {
 			totalLines += lines
@@ -1119,18 +3762,7 @@ func (rd *RepoDownloader) analyzeCodeContent(repoPath string) (int, int, error)
 }
 
 func (rd *RepoDownloader) countLines(filename string) (int, error) {
-	cmd := exec.Command("wc", "-l", filename)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, err
-	}
-
-	fields := strings.Fields(string(output))
-	if len(fields) < 1 {
-		return 0, fmt.Errorf("unexpected wc output")
-	}
-
-	return strconv.Atoi(fields[0])
+	return fsutil.CountLines(filename)
 }
 
 func (rd *RepoDownloader) updateRepoSize(repoID string, sizeKB int) {
@@ -1149,6 +3781,21 @@ func (rd *RepoDownloader) updateDefaultBranch(repoID, branch string) {
 	}
 }
 
+func (rd *RepoDownloader) updateLicense(repoID, spdxID, name string) {
+	query := `UPDATE repositories SET license_key = $1, license_name = NULLIF($2, '') WHERE id = $3`
+	_, err := rd.db.Exec(query, spdxID, name, repoID)
+	if err != nil {
+		log.Printf("Failed to update license: %v", err)
+	}
+}
+
+func (rd *RepoDownloader) setDownloadMethod(repoID, method string) {
+	_, err := rd.db.Exec(`UPDATE repositories SET download_method = $1 WHERE id = $2`, method, repoID)
+	if err != nil {
+		log.Printf("Failed to update download method for %s: %v", repoID, err)
+	}
+}
+
 func (rd *RepoDownloader) updateCodeMetrics(repoID string, codeLines, fileCount int) {
 	query := `UPDATE repositories SET code_lines = $1, file_count = $2 WHERE id = $3`
 	_, err := rd.db.Exec(query, codeLines, fileCount, repoID)
@@ -1157,16 +3804,30 @@ func (rd *RepoDownloader) updateCodeMetrics(repoID string, codeLines, fileCount
 	}
 }
 
+func (rd *RepoDownloader) updateCheckoutFlags(repoID string, hasSubmodules, hasLFS bool) {
+	query := `UPDATE repositories SET has_submodules = $1, has_lfs = $2 WHERE id = $3`
+	_, err := rd.db.Exec(query, hasSubmodules, hasLFS, repoID)
+	if err != nil {
+		log.Printf("Failed to update submodule and LFS flags: %v", err)
+	}
+}
+
+// isValidRepo reports whether repoPath holds a checkout with content. LFS
+// pointer files and empty submodule directories count: they are what a
+// clone with LFS_SKIP_SMUDGE or without --recurse-submodules looks like.
 func (rd *RepoDownloader) isValidRepo(repoPath string) bool {
 	// Check if directory exists
 	if _, err := os.Stat(repoPath); os.IsNotExist(err) {
 		return false
 	}
 
-	// Check if .git directory exists (indicates a valid git repo)
+	// Check if .git directory exists (indicates a valid git repo), or the
+	// marker left by a tarball download
 	gitPath := filepath.Join(repoPath, ".git")
 	if _, err := os.Stat(gitPath); os.IsNotExist(err) {
-		return false
+		if _, err := os.Stat(filepath.Join(repoPath, tarballMarker)); err != nil {
+			return false
+		}
 	}
 
 	// Check if directory has files (not empty)
@@ -1179,7 +3840,11 @@ func (rd *RepoDownloader) isValidRepo(repoPath string) bool {
 }
 
 func (rd *RepoDownloader) Close() error {
+	if rd.cancel != nil {
+		rd.cancel()
+	}
 	if rd.db != nil {
+		rd.resetInFlight()
 		return rd.db.Close()
 	}
 	return nil
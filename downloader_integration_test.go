@@ -0,0 +1,114 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"codelupe/internal/models"
+	"codelupe/internal/testenv"
+
+	"github.com/lib/pq"
+)
+
+// TestIntegration_DownloaderReadsCrawlerIndex is the second stage of the
+// pipeline test: it reads the repos the crawler stage indexed, filters and
+// upserts them, and places the fixture checkouts where clones would go
+// instead of cloning.
+func TestIntegration_DownloaderReadsCrawlerIndex(t *testing.T) {
+	db := testenv.Postgres(t)
+	cfg := testenv.Config(t)
+
+	if _, err := db.Exec(`TRUNCATE repositories`); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(testenv.ReposDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	rd, err := NewRepoDownloader(cfg, testenv.ReposDir(), 1)
+	if err != nil {
+		t.Fatalf("NewRepoDownloader() error = %v", err)
+	}
+	defer rd.Close()
+
+	repos := make(chan *models.RepoInfo)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(repos)
+		_, err := rd.streamRepos(context.Background(), repos)
+		errc <- err
+	}()
+
+	read := 0
+	for repo := range repos {
+		read++
+		passed, score, reason := rd.qualityFilter.evaluateRepo(repo)
+		if !passed {
+			if _, err := rd.recordFiltered(repo, score, reason); err != nil {
+				t.Fatalf("recordFiltered(%s) error = %v", repo.FullName, err)
+			}
+			continue
+		}
+		record, err := rd.upsertRepository(repo, score)
+		if err != nil {
+			t.Fatalf("upsertRepository(%s) error = %v", repo.FullName, err)
+		}
+
+		path := rd.repoPath(repo, rd.layout)
+		if err := os.CopyFS(path, os.DirFS(testenv.Fixture("checkouts", repo.FullName))); err != nil {
+			t.Fatalf("no fixture checkout for %s: %v", repo.FullName, err)
+		}
+		rd.updateDownloadStatus(record.ID, "downloaded", path, "")
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("streamRepos() error = %v", err)
+	}
+	if read != 4 {
+		t.Fatalf("read %d repos from the index, want the 4 the crawler stage indexed", read)
+	}
+
+	type row struct {
+		status   string
+		stars    int
+		language string
+		topics   []string
+	}
+	want := map[string]row{
+		"acme-tools/fastjson":         {"downloaded", 1200, "Go", []string{"json", "parser"}},
+		"datalabs/pipeline-kit":       {"downloaded", 640, "Python", []string{"etl", "pipeline"}},
+		"ferrous-io/tiny-http-server": {"downloaded", 230, "Rust", []string{"http"}},
+		"weekend-hacks/scratchpad":    {"filtered", 2, "Go", []string{}},
+	}
+
+	rows, err := db.Query(`SELECT full_name, download_status, stars, language, topics, COALESCE(filter_reason, '') FROM repositories`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make(map[string]row)
+	for rows.Next() {
+		var name, reason string
+		var r row
+		if err := rows.Scan(&name, &r.status, &r.stars, &r.language, pq.Array(&r.topics), &reason); err != nil {
+			t.Fatal(err)
+		}
+		if r.status == "filtered" && !strings.Contains(reason, "too few stars") {
+			t.Errorf("%s filtered for %q, want too few stars", name, reason)
+		}
+		if r.topics == nil {
+			r.topics = []string{}
+		}
+		got[name] = r
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("repositories =\n%+v\nwant\n%+v", got, want)
+	}
+}
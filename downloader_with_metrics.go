@@ -5,7 +5,7 @@
 // Then add metrics calls at key points:
 
 // In performDownload() after successful clone (around line 595):
-func (rd *RepoDownloader) performDownloadWithMetrics(repo *RepoInfo, repoRecord *Repository) error {
+func (rd *RepoDownloader) performDownloadWithMetrics(repo *models.RepoInfo, repoRecord *models.Repository) error {
 	startTime := time.Now()
 
 	// ... existing download logic ...
@@ -29,7 +29,7 @@ func (rd *RepoDownloader) performDownloadWithMetrics(repo *RepoInfo, repoRecord
 }
 
 // In downloadWorker() to track active workers:
-func (rd *RepoDownloader) downloadWorkerWithMetrics(repos <-chan *RepoInfo, wg *sync.WaitGroup) {
+func (rd *RepoDownloader) downloadWorkerWithMetrics(repos <-chan *models.RepoInfo, wg *sync.WaitGroup) {
 	defer wg.Done()
 	metrics.IncrCounter("active_download_workers", 1)
 	defer metrics.IncrCounter("active_download_workers", -1)
@@ -38,7 +38,7 @@ func (rd *RepoDownloader) downloadWorkerWithMetrics(repos <-chan *RepoInfo, wg *
 }
 
 // In evaluateRepo() to track quality filtering:
-func (qf *QualityFilter) evaluateRepoWithMetrics(repo *RepoInfo) (bool, int, string) {
+func (qf *QualityFilter) evaluateRepoWithMetrics(repo *models.RepoInfo) (bool, int, string) {
 	passed, score, reason := qf.evaluateRepo(repo)
 
 	metrics.ObserveHistogram("repo_quality_score", float64(score))
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiKeyDigest is the SHA-256 of an accepted API key. Keys are compared by
+// digest so every comparison has the same length.
+type apiKeyDigest [sha256.Size]byte
+
+// parseAPIKeys converts configured keys to digests. A key written as
+// sha256:<hex> is already a digest.
+func parseAPIKeys(keys []string) ([]apiKeyDigest, error) {
+	digests := make([]apiKeyDigest, 0, len(keys))
+	for i, key := range keys {
+		hexDigest, hashed := strings.CutPrefix(key, "sha256:")
+		if !hashed {
+			digests = append(digests, sha256.Sum256([]byte(key)))
+			continue
+		}
+
+		raw, err := hex.DecodeString(hexDigest)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("API key %d: sha256: must be followed by 64 hex characters", i+1)
+		}
+		var digest apiKeyDigest
+		copy(digest[:], raw)
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// requestAPIKey returns the key sent as "Authorization: Bearer <key>" or
+// "X-API-Key: <key>"
+func requestAPIKey(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// validAPIKey reports whether key matches one of keys. Every key is
+// compared, in constant time, so timing reveals nothing about which
+// matched or how closely.
+func validAPIKey(keys []apiKeyDigest, key string) bool {
+	digest := sha256.Sum256([]byte(key))
+	match := 0
+	for _, k := range keys {
+		match |= subtle.ConstantTimeCompare(digest[:], k[:])
+	}
+	return match == 1
+}
+
+// apiKeyAuth rejects requests that don't carry one of keys with 401
+func apiKeyAuth(keys []apiKeyDigest) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := requestAPIKey(r)
+			if key == "" {
+				writeUnauthorized(w, "API key required")
+				return
+			}
+			if !validAPIKey(keys, key) {
+				writeUnauthorized(w, "Invalid API key")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeUnauthorized writes a 401 with a JSON error body
+func writeUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="codelupe"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// setupAuthServer returns a mock server with auth enabled for keys
+func setupAuthServer(t *testing.T, keys ...string) (*Server, sqlmock.Sqlmock) {
+	t.Helper()
+	server, mock := setupMockServer(t)
+
+	digests, err := parseAPIKeys(keys)
+	if err != nil {
+		t.Fatalf("parseAPIKeys() error = %v", err)
+	}
+	server.config.EnableAuth = true
+	server.apiKeys = digests
+	server.router = mux.NewRouter()
+	server.setupRoutes()
+	return server, mock
+}
+
+func expectDistributionQuery(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"range", "count"}).AddRow("90-100", 10))
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	sum := sha256.Sum256([]byte("hashed-key"))
+	hashed := "sha256:" + hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"missing key", nil, http.StatusUnauthorized},
+		{"wrong bearer key", map[string]string{"Authorization": "Bearer not-a-key"}, http.StatusUnauthorized},
+		{"wrong header key", map[string]string{"X-API-Key": "secret-ke"}, http.StatusUnauthorized},
+		{"basic auth is not a key", map[string]string{"Authorization": "Basic c2VjcmV0LWtleQ=="}, http.StatusUnauthorized},
+		{"valid bearer key", map[string]string{"Authorization": "Bearer secret-key"}, http.StatusOK},
+		{"lowercase bearer", map[string]string{"Authorization": "bearer secret-key"}, http.StatusOK},
+		{"valid header key", map[string]string{"X-API-Key": "other-key"}, http.StatusOK},
+		{"key matching a configured digest", map[string]string{"X-API-Key": "hashed-key"}, http.StatusOK},
+		{"the digest itself is not a key", map[string]string{"X-API-Key": hashed}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupAuthServer(t, "secret-key", "other-key", hashed)
+			defer server.db.Close()
+			if tt.want == http.StatusOK {
+				expectDistributionQuery(mock)
+			}
+
+			req := httptest.NewRequest("GET", "/api/v1/quality/distribution", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("Status code = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusUnauthorized {
+				var body map[string]string
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil || body["error"] == "" {
+					t.Errorf("401 body = %q, want a JSON error", w.Body.String())
+				}
+				if w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("401 without WWW-Authenticate")
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth_PublicRoutes(t *testing.T) {
+	server, mock := setupAuthServer(t, "secret-key")
+	defer server.db.Close()
+	mock.ExpectPing()
+
+	for _, path := range []string{"/health", "/api/docs"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+
+		server.router.ServeHTTP(w, req)
+
+		if w.Code == http.StatusUnauthorized {
+			t.Errorf("%s requires a key, want it public", path)
+		}
+	}
+}
+
+func TestAPIKeyAuth_Disabled(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	expectDistributionQuery(mock)
+
+	req := httptest.NewRequest("GET", "/api/v1/quality/distribution", nil)
+	w := httptest.NewRecorder()
+
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status code = %d, want %d with auth disabled", w.Code, http.StatusOK)
+	}
+}
+
+func TestParseAPIKeys_InvalidDigest(t *testing.T) {
+	for _, key := range []string{"sha256:abc", "sha256:" + strings.Repeat("zz", 32)} {
+		if _, err := parseAPIKeys([]string{key}); err == nil {
+			t.Errorf("parseAPIKeys(%q) should fail", key)
+		}
+	}
+}
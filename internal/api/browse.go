@@ -0,0 +1,225 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+)
+
+// maxBinaryBlobSize is the largest binary file the blob endpoint serves.
+// Text files are streamed at any size.
+const maxBinaryBlobSize = 1 << 20
+
+// sniffLen is how much of a file is read to detect its type
+const sniffLen = 512
+
+var errInvalidPath = errors.New("invalid path")
+
+// TreeEntry is one entry of a directory listing
+type TreeEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // file, dir or symlink
+	Size int64  `json:"size"`
+}
+
+// cleanRepoPath validates a path relative to a repository root and returns
+// it cleaned, "." for the root. Absolute paths, ".." components and the
+// .git directory are refused outright; symlinks are left to os.Root, which
+// refuses any that lead outside the root.
+func cleanRepoPath(p string) (string, error) {
+	if p == "" {
+		return ".", nil
+	}
+	if strings.ContainsRune(p, 0) || strings.Contains(p, `\`) || path.IsAbs(p) {
+		return "", errInvalidPath
+	}
+	for _, part := range strings.Split(p, "/") {
+		if part == ".." || part == ".git" {
+			return "", errInvalidPath
+		}
+	}
+	return path.Clean(p), nil
+}
+
+// openRepoRoot returns the on-disk root of repository id, writing an error
+// response and returning nil when it can't be opened
+func (s *Server) openRepoRoot(w http.ResponseWriter, id string) *os.Root {
+	var localPath sql.NullString
+	err := s.db.QueryRow("SELECT local_path FROM repositories WHERE id = $1", id).Scan(&localPath)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil
+	}
+	if localPath.String == "" {
+		http.Error(w, "Repository has not been downloaded", http.StatusNotFound)
+		return nil
+	}
+
+	root, err := os.OpenRoot(localPath.String)
+	if err != nil {
+		http.Error(w, "Repository is not on disk", http.StatusNotFound)
+		return nil
+	}
+	return root
+}
+
+// handleRepositoryTree lists a directory of a downloaded repository
+func (s *Server) handleRepositoryTree(w http.ResponseWriter, r *http.Request) {
+	dir, err := cleanRepoPath(r.URL.Query().Get("path"))
+	if err != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	root := s.openRepoRoot(w, mux.Vars(r)["id"])
+	if root == nil {
+		return
+	}
+	defer root.Close()
+
+	f, err := root.Open(dir)
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !info.IsDir() {
+		http.Error(w, "Path is not a directory", http.StatusBadRequest)
+		return
+	}
+
+	dirEntries, err := f.ReadDir(-1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entries := make([]TreeEntry, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		if dir == "." && entry.Name() == ".git" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		// Symlinks are reported, not followed
+		te := TreeEntry{Name: entry.Name(), Type: "file", Size: info.Size()}
+		switch {
+		case entry.Type()&fs.ModeSymlink != 0:
+			te.Type = "symlink"
+			te.Size = 0
+		case entry.IsDir():
+			te.Type = "dir"
+			te.Size = 0
+		case !entry.Type().IsRegular():
+			continue
+		}
+		entries = append(entries, te)
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"path":    dir,
+		"entries": entries,
+	})
+}
+
+// handleRepositoryBlob streams a file of a downloaded repository. Text is
+// always served as text/plain so repository content can't run as HTML in
+// the browser.
+func (s *Server) handleRepositoryBlob(w http.ResponseWriter, r *http.Request) {
+	file, err := cleanRepoPath(r.URL.Query().Get("path"))
+	if err != nil || file == "." {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	root := s.openRepoRoot(w, mux.Vars(r)["id"])
+	if root == nil {
+		return
+	}
+	defer root.Close()
+
+	f, err := root.Open(file)
+	if err != nil {
+		http.Error(w, "Path not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		http.Error(w, "Path is not a file", http.StatusBadRequest)
+		return
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	head = head[:n]
+
+	contentType := "text/plain; charset=utf-8"
+	if isBinary(head) {
+		if info.Size() > maxBinaryBlobSize {
+			http.Error(w, "Binary file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		contentType = http.DetectContentType(head)
+		if strings.HasPrefix(contentType, "text/") {
+			contentType = "application/octet-stream"
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	http.ServeContent(w, r, path.Base(file), info.ModTime(), f)
+}
+
+// isBinary reports whether head, the start of a file, looks binary: it
+// holds a NUL byte or isn't valid UTF-8. A multi-byte rune cut off at the
+// end of head doesn't count.
+func isBinary(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	for i := 0; i < len(head); {
+		r, size := utf8.DecodeRune(head[i:])
+		if r == utf8.RuneError && size == 1 {
+			return len(head)-i >= utf8.UTFMax || utf8.FullRune(head[i:])
+		}
+		i += size
+	}
+	return false
+}
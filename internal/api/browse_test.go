@@ -0,0 +1,288 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// setupRepoDir creates a repository checkout next to a secret file that
+// must never be reachable through it, and returns the checkout path
+func setupRepoDir(t *testing.T) string {
+	t.Helper()
+	base := t.TempDir()
+	repo := filepath.Join(base, "repo")
+	files := map[string]string{
+		"README.md":       "# Widgets\n",
+		"src/main.go":     "package main\n",
+		"src/index.html":  "<script>alert(1)</script>",
+		".git/config":     "[remote \"origin\"]\n",
+		"../secret.txt":   "top secret",
+		"../repo-evil/x":  "sibling with a shared prefix",
+		"docs/guide.md":   "héllo wörld",
+		"assets/logo.png": "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR",
+	}
+	for name, content := range files {
+		path := filepath.Join(repo, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(repo, "assets/huge.bin"),
+		bytes.Repeat([]byte{0}, maxBinaryBlobSize+1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	links := map[string]string{
+		"escape":         filepath.Join(base, "secret.txt"),
+		"escape-rel":     "../secret.txt",
+		"escape-dir":     base,
+		"src/deep":       "../../secret.txt",
+		"readme-link":    "README.md",
+		"src/readme-rel": "../README.md",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(repo, name)); err != nil {
+			t.Skipf("symlinks unsupported: %v", err)
+		}
+	}
+	return repo
+}
+
+// browse requests endpoint ("tree" or "blob") for repository 1 checked out
+// at repoDir
+func browse(t *testing.T, repoDir, endpoint, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	mock.ExpectQuery("SELECT local_path FROM repositories WHERE id = \\$1").
+		WithArgs("1").
+		WillReturnRows(sqlmock.NewRows([]string{"local_path"}).AddRow(repoDir))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/1/"+endpoint+"?path="+url.QueryEscape(path), nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestCleanRepoPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string // "" means rejected
+	}{
+		{"", "."},
+		{".", "."},
+		{"src", "src"},
+		{"src/", "src"},
+		{"./src//main.go", "src/main.go"},
+		{"..", ""},
+		{"../secret.txt", ""},
+		{"src/../../secret.txt", ""},
+		{"src/..", ""},
+		{"/etc/passwd", ""},
+		{`..\secret.txt`, ""},
+		{"src\x00.go", ""},
+		{".git/config", ""},
+		{"sub/.git/config", ""},
+		{"...", "..."},
+		{"..foo", "..foo"},
+	}
+
+	for _, tt := range tests {
+		got, err := cleanRepoPath(tt.path)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("cleanRepoPath(%q) = %q, want it rejected", tt.path, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("cleanRepoPath(%q) = %q, %v; want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+func TestHandleRepositoryTree(t *testing.T) {
+	repo := setupRepoDir(t)
+
+	w := browse(t, repo, "tree", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response struct {
+		Path    string      `json:"path"`
+		Entries []TreeEntry `json:"entries"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	types := map[string]string{}
+	for _, e := range response.Entries {
+		types[e.Name] = e.Type
+		if e.Name == "README.md" && e.Size != int64(len("# Widgets\n")) {
+			t.Errorf("README.md size = %d", e.Size)
+		}
+	}
+	if types["src"] != "dir" || types["README.md"] != "file" || types["escape"] != "symlink" {
+		t.Errorf("entries = %+v", response.Entries)
+	}
+	if _, ok := types[".git"]; ok {
+		t.Error(".git is listed")
+	}
+
+	w = browse(t, repo, "tree", "src")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"main.go"`) {
+		t.Errorf("tree src = %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestHandleRepositoryBlob(t *testing.T) {
+	repo := setupRepoDir(t)
+
+	tests := []struct {
+		name        string
+		path        string
+		wantBody    string
+		contentType string
+	}{
+		{"text file", "src/main.go", "package main\n", "text/plain; charset=utf-8"},
+		{"UTF-8 text", "docs/guide.md", "héllo wörld", "text/plain; charset=utf-8"},
+		{"HTML is served as text", "src/index.html", "<script>alert(1)</script>", "text/plain; charset=utf-8"},
+		{"binary file", "assets/logo.png", "", "image/png"},
+		{"symlink inside the repository", "readme-link", "# Widgets\n", "text/plain; charset=utf-8"},
+		{"relative symlink inside the repository", "src/readme-rel", "# Widgets\n", "text/plain; charset=utf-8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := browse(t, repo, "blob", tt.path)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if w.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Error("X-Content-Type-Options not set")
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestHandleRepositoryBlob_LargeBinary(t *testing.T) {
+	repo := setupRepoDir(t)
+
+	w := browse(t, repo, "blob", "assets/huge.bin")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestRepositoryBrowse_Traversal(t *testing.T) {
+	repo := setupRepoDir(t)
+
+	// Rejected before touching the filesystem
+	lexical := []string{
+		"..",
+		"../secret.txt",
+		"src/../../secret.txt",
+		"src/../..",
+		"/etc/passwd",
+		filepath.Join(filepath.Dir(repo), "secret.txt"),
+		`..\secret.txt`,
+		".git/config",
+		"../repo-evil/x",
+	}
+	// Resolved by os.Root, which refuses to follow them out of the root
+	symlinks := []string{
+		"escape",
+		"escape-rel",
+		"escape-dir",
+		"escape-dir/secret.txt",
+		"src/deep",
+	}
+
+	for _, endpoint := range []string{"tree", "blob"} {
+		for _, path := range lexical {
+			t.Run(endpoint+" "+path, func(t *testing.T) {
+				server, mock := setupMockServer(t)
+				defer server.db.Close()
+
+				req := httptest.NewRequest("GET", "/api/v1/repositories/1/"+endpoint+"?path="+url.QueryEscape(path), nil)
+				w := httptest.NewRecorder()
+				server.router.ServeHTTP(w, req)
+
+				if w.Code != http.StatusBadRequest {
+					t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+				}
+				if strings.Contains(w.Body.String(), "secret") {
+					t.Errorf("body leaks the secret: %s", w.Body.String())
+				}
+				if err := mock.ExpectationsWereMet(); err != nil {
+					t.Errorf("no query should run: %v", err)
+				}
+			})
+		}
+		for _, path := range symlinks {
+			t.Run(endpoint+" "+path, func(t *testing.T) {
+				w := browse(t, repo, endpoint, path)
+
+				if w.Code != http.StatusNotFound {
+					t.Errorf("Status code = %d, want %d", w.Code, http.StatusNotFound)
+				}
+				if strings.Contains(w.Body.String(), "top secret") || strings.Contains(w.Body.String(), "repo-evil") {
+					t.Errorf("body leaks outside the repository: %s", w.Body.String())
+				}
+			})
+		}
+	}
+}
+
+func TestRepositoryBrowse_NotDownloaded(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	mock.ExpectQuery("SELECT local_path").WillReturnRows(sqlmock.NewRows([]string{"local_path"}).AddRow(nil))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/1/tree", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestIsBinary(t *testing.T) {
+	tests := []struct {
+		name string
+		head []byte
+		want bool
+	}{
+		{"ASCII", []byte("hello"), false},
+		{"UTF-8", []byte("héllo"), false},
+		{"NUL byte", []byte("a\x00b"), true},
+		{"invalid UTF-8", []byte("a\xffb"), true},
+		{"rune cut off at the end", []byte("ab\xc3"), false},
+	}
+	for _, tt := range tests {
+		if got := isBinary(tt.head); got != tt.want {
+			t.Errorf("%s: isBinary() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
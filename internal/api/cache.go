@@ -0,0 +1,171 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"codelupe/pkg/metrics"
+)
+
+// maxCachedResponses bounds the entries a responseCache holds
+const maxCachedResponses = 1000
+
+// responseCache keeps the JSON bodies of aggregate endpoints whose tables
+// change slowly, keyed by path and the query parameters the handler reads,
+// for ttl. Every body gets a strong ETag so clients can revalidate with
+// If-None-Match.
+type responseCache struct {
+	ttl        time.Duration
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+// cachedResponse is a 200 response body and its ETag
+type cachedResponse struct {
+	body      []byte
+	etag      string
+	expiresAt time.Time
+}
+
+// newResponseCache caches responses for ttl
+func newResponseCache(ttl time.Duration) *responseCache {
+	return &responseCache{
+		ttl:        ttl,
+		maxEntries: maxCachedResponses,
+		now:        time.Now,
+		entries:    make(map[string]cachedResponse),
+	}
+}
+
+// cacheKey identifies a request by path and the first value of each of
+// params, the only ones the handler reads. Anything else in the query
+// would otherwise make a new entry per request.
+func cacheKey(r *http.Request, params []string) string {
+	query := r.URL.Query()
+	kept := url.Values{}
+	for _, name := range params {
+		if value := query.Get(name); value != "" {
+			kept.Set(name, value)
+		}
+	}
+	return r.URL.Path + "?" + kept.Encode()
+}
+
+// get returns the unexpired response for key
+func (c *responseCache) get(key string) (cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return cachedResponse{}, false
+	}
+	if !c.now().Before(entry.expiresAt) {
+		delete(c.entries, key)
+		return cachedResponse{}, false
+	}
+	return entry, true
+}
+
+// put stores body under key and returns the cached entry. Expired entries
+// are swept first, and if the cache is still full the entry that expires
+// soonest makes way.
+func (c *responseCache) put(key string, body []byte) cachedResponse {
+	sum := sha256.Sum256(body)
+	now := c.now()
+	entry := cachedResponse{
+		body:      body,
+		etag:      `"` + hex.EncodeToString(sum[:16]) + `"`,
+		expiresAt: now.Add(c.ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var soonest string
+	for k, e := range c.entries {
+		if !now.Before(e.expiresAt) {
+			delete(c.entries, k)
+		} else if soonest == "" || e.expiresAt.Before(c.entries[soonest].expiresAt) {
+			soonest = k
+		}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		delete(c.entries, soonest)
+	}
+	c.entries[key] = entry
+	return entry
+}
+
+// flush drops every entry and returns how many there were
+func (c *responseCache) flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = make(map[string]cachedResponse)
+	return n
+}
+
+// cached serves next's responses from the cache, keyed on the query
+// parameters in params. A miss runs next without
+// Accept-Encoding so the identity body is cached, and only 200 responses
+// are kept. Either way the body goes out through writeJSONBytes with its
+// ETag, or as a 304 when If-None-Match already has it.
+func (c *responseCache) cached(next http.HandlerFunc, params ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := cacheKey(r, params)
+		entry, hit := c.get(key)
+		if hit {
+			w.Header().Set("X-Cache", "HIT")
+			metrics.IncrCounterWithLabels("codelupe_api_response_cache_total", map[string]string{"result": "hit"}, 1)
+		} else {
+			inner := r.Clone(r.Context())
+			inner.Header.Del("Accept-Encoding")
+			rec := httptest.NewRecorder()
+			next(rec, inner)
+			if rec.Code != http.StatusOK {
+				for name, values := range rec.Header() {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(rec.Code)
+				w.Write(rec.Body.Bytes())
+				return
+			}
+
+			entry = c.put(key, rec.Body.Bytes())
+			w.Header().Set("X-Cache", "MISS")
+			metrics.IncrCounterWithLabels("codelupe_api_response_cache_total", map[string]string{"result": "miss"}, 1)
+		}
+
+		w.Header().Set("ETag", entry.etag)
+		if etagMatches(r.Header.Get("If-None-Match"), entry.etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSONBytes(w, r, entry.body)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag or is *
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// handleCacheFlush empties the response cache so the next requests
+// recompute their aggregates
+func (s *Server) handleCacheFlush(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, map[string]interface{}{"status": "flushed", "entries": s.responses.flush()})
+}
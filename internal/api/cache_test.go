@@ -0,0 +1,250 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// cacheTestServer returns a server whose response cache runs on a clock
+// the test advances
+func cacheTestServer(t *testing.T) (*Server, sqlmock.Sqlmock, func(time.Duration)) {
+	t.Helper()
+	server, mock := setupMockServer(t)
+	t.Cleanup(func() { server.db.Close() })
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.responses.now = func() time.Time { return now }
+	return server, mock, func(d time.Duration) { now = now.Add(d) }
+}
+
+func expectLanguagesQuery(mock sqlmock.Sqlmock, count int) {
+	mock.ExpectQuery("SELECT language, COUNT").
+		WillReturnRows(sqlmock.NewRows([]string{"language", "count", "avg_stars"}).AddRow("Go", count, 120.5))
+}
+
+func serve(server *Server, method, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestResponseCache_HitAndMiss(t *testing.T) {
+	server, mock, _ := cacheTestServer(t)
+	expectLanguagesQuery(mock, 10)
+
+	first := serve(server, "GET", "/api/v1/languages", nil)
+	if first.Code != http.StatusOK || first.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("first request: status %d, X-Cache %q; want a 200 miss", first.Code, first.Header().Get("X-Cache"))
+	}
+	etag := first.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `"`) || strings.HasPrefix(etag, "W/") {
+		t.Errorf("ETag = %q, want a strong ETag", etag)
+	}
+
+	// Served without another query, gzipped for clients that accept it
+	second := serve(server, "GET", "/api/v1/languages", map[string]string{"Accept-Encoding": "gzip"})
+	if second.Header().Get("X-Cache") != "HIT" || second.Header().Get("ETag") != etag {
+		t.Errorf("second request: X-Cache %q, ETag %q; want a hit with %s",
+			second.Header().Get("X-Cache"), second.Header().Get("ETag"), etag)
+	}
+	body := second.Body.Bytes()
+	if second.Header().Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(second.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ = io.ReadAll(gz)
+	}
+	if string(body) != first.Body.String() {
+		t.Errorf("cached body = %s, want %s", body, first.Body.String())
+	}
+
+	// Parameters the handler doesn't read share the entry
+	if w := serve(server, "GET", "/api/v1/languages?page=2&_=123", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache = %q for an ignored query parameter, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResponseCache_Expiry(t *testing.T) {
+	server, mock, advance := cacheTestServer(t)
+	expectLanguagesQuery(mock, 10)
+	expectLanguagesQuery(mock, 11)
+
+	first := serve(server, "GET", "/api/v1/languages", nil)
+	advance(defaultResponseCacheTTL - time.Second)
+	if w := serve(server, "GET", "/api/v1/languages", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("X-Cache = %q within the TTL, want HIT", w.Header().Get("X-Cache"))
+	}
+
+	advance(time.Second)
+	expired := serve(server, "GET", "/api/v1/languages", nil)
+	if expired.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("X-Cache = %q after the TTL, want MISS", expired.Header().Get("X-Cache"))
+	}
+	if expired.Header().Get("ETag") == first.Header().Get("ETag") {
+		t.Error("ETag unchanged after the payload changed")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResponseCache_ConditionalRequests(t *testing.T) {
+	server, mock, advance := cacheTestServer(t)
+	expectDistributionQuery(mock)
+
+	etag := serve(server, "GET", "/api/v1/quality/distribution", nil).Header().Get("ETag")
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+	}{
+		{"matching", etag, http.StatusNotModified},
+		{"in a list", `"stale", ` + etag, http.StatusNotModified},
+		{"wildcard", "*", http.StatusNotModified},
+		{"stale", `"stale"`, http.StatusOK},
+		{"weak form", "W/" + etag, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(server, "GET", "/api/v1/quality/distribution", map[string]string{"If-None-Match": tt.ifNoneMatch})
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusNotModified && (w.Body.Len() != 0 || w.Header().Get("ETag") != etag) {
+				t.Errorf("304 with a %d byte body and ETag %q", w.Body.Len(), w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	// An unchanged payload recomputed after expiry still revalidates
+	advance(defaultResponseCacheTTL)
+	expectDistributionQuery(mock)
+	w := serve(server, "GET", "/api/v1/quality/distribution", map[string]string{"If-None-Match": etag})
+	if w.Code != http.StatusNotModified || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("status = %d, X-Cache %q; want a 304 miss", w.Code, w.Header().Get("X-Cache"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResponseCache_ErrorsAreNotCached(t *testing.T) {
+	server, mock, _ := cacheTestServer(t)
+	mock.ExpectQuery("SELECT language, COUNT").WillReturnError(io.ErrUnexpectedEOF)
+	expectLanguagesQuery(mock, 10)
+
+	if w := serve(server, "GET", "/api/v1/languages", nil); w.Code != http.StatusInternalServerError || w.Header().Get("ETag") != "" {
+		t.Errorf("status = %d, ETag %q; want an uncached 500", w.Code, w.Header().Get("ETag"))
+	}
+	if w := serve(server, "GET", "/api/v1/languages", nil); w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("status = %d, X-Cache %q; want a 200 miss after the error", w.Code, w.Header().Get("X-Cache"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleCacheFlush(t *testing.T) {
+	server, mock, _ := cacheTestServer(t)
+	expectLanguagesQuery(mock, 10)
+	expectLanguagesQuery(mock, 10)
+
+	serve(server, "GET", "/api/v1/languages", nil)
+
+	w := serve(server, "POST", "/api/v1/cache/flush", nil)
+	var response struct {
+		Status  string `json:"status"`
+		Entries int    `json:"entries"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || response.Status != "flushed" || response.Entries != 1 {
+		t.Errorf("flush = %d %+v, want 1 entry flushed", w.Code, response)
+	}
+
+	if w := serve(server, "GET", "/api/v1/languages", nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("X-Cache = %q after a flush, want MISS", w.Header().Get("X-Cache"))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResponseCache_KeysOnHandlerParams(t *testing.T) {
+	server, mock, _ := cacheTestServer(t)
+	expectDistributionQuery(mock)
+	expectDistributionQuery(mock)
+
+	serve(server, "GET", "/api/v1/quality/distribution?language=Go&min_stars=10", nil)
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/v1/quality/distribution?min_stars=10&language=Go", "HIT"},
+		{"/api/v1/quality/distribution?language=Go&min_stars=10&utm_source=x", "HIT"},
+		{"/api/v1/quality/distribution?language=Go&min_stars=10&min_stars=20", "HIT"},
+		{"/api/v1/quality/distribution?language=Rust&min_stars=10", "MISS"},
+	}
+	for _, tt := range tests {
+		if w := serve(server, "GET", tt.path, nil); w.Header().Get("X-Cache") != tt.want {
+			t.Errorf("GET %s: X-Cache = %q, want %s", tt.path, w.Header().Get("X-Cache"), tt.want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestResponseCache_MaxEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := newResponseCache(time.Minute)
+	cache.maxEntries = 2
+	cache.now = func() time.Time { return now }
+
+	cache.put("a", []byte("1"))
+	now = now.Add(time.Second)
+	cache.put("b", []byte("2"))
+	now = now.Add(time.Second)
+
+	// Full: the entry expiring soonest makes way
+	cache.put("c", []byte("3"))
+	if _, ok := cache.get("a"); ok {
+		t.Error("oldest entry kept past the limit")
+	}
+	if _, ok := cache.get("b"); !ok {
+		t.Error("newer entry evicted")
+	}
+
+	// Expired entries are swept on put, before anything live is evicted
+	now = now.Add(time.Minute - time.Second)
+	cache.put("d", []byte("4"))
+	if _, ok := cache.entries["b"]; ok || len(cache.entries) != 2 {
+		t.Errorf("entries after put = %d, expired entry still there: %v", len(cache.entries), ok)
+	}
+	if _, ok := cache.get("c"); !ok {
+		t.Error("live entry evicted while an expired one was there")
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"codelupe/internal/models"
+)
+
+// maxCrawlRequestBody bounds the POST /repositories body
+const maxCrawlRequestBody = 4 << 10
+
+var (
+	// githubOwnerPattern matches GitHub user and organization names
+	githubOwnerPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,37}[A-Za-z0-9])?$`)
+	// githubRepoPattern matches GitHub repository names
+	githubRepoPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,100}$`)
+)
+
+// validateFullName checks that fullName is a GitHub "owner/repo"
+func validateFullName(fullName string) error {
+	owner, repo, ok := strings.Cut(fullName, "/")
+	if !ok || strings.Contains(repo, "/") {
+		return fmt.Errorf("full_name must be owner/repo")
+	}
+	if !githubOwnerPattern.MatchString(owner) {
+		return fmt.Errorf("invalid owner %q", owner)
+	}
+	if !githubRepoPattern.MatchString(repo) || repo == "." || repo == ".." {
+		return fmt.Errorf("invalid repository name %q", repo)
+	}
+	return nil
+}
+
+// handleCreateRepository queues a repository for download. The row is
+// inserted as pending and the repository is added to the crawl index,
+// which is where the downloader finds work, so it goes through the same
+// quality filter as crawled repositories. A repository that is already
+// known is returned as-is with 200.
+func (s *Server) handleCreateRepository(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		FullName string `json:"full_name"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCrawlRequestBody)
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := validateFullName(request.FullName); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// GitHub names are case-insensitive but full_name is stored as crawled,
+	// so look for an existing row either way
+	existing := func() (models.RepositoryResponse, error) {
+		return scanRepository(s.db.QueryRow(
+			"SELECT "+repositoryColumns+" FROM repositories WHERE LOWER(full_name) = LOWER($1)",
+			request.FullName))
+	}
+	repo, err := existing()
+	if err == nil {
+		writeJSON(w, r, repo)
+		return
+	}
+	if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	owner, name, _ := strings.Cut(request.FullName, "/")
+	url := "https://github.com/" + request.FullName
+
+	repo, err = scanRepository(s.db.QueryRow(`
+		INSERT INTO repositories (full_name, name, url, clone_url, owner_login, download_status, host)
+		VALUES ($1, $2, $3, $4, $5, 'pending', 'github')
+		ON CONFLICT (full_name) DO NOTHING
+		RETURNING `+repositoryColumns,
+		request.FullName, name, url, url+".git", owner))
+	if err == sql.ErrNoRows {
+		// Inserted concurrently since the lookup
+		repo, err = existing()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, r, repo)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.indexCrawlRequest(r.Context(), request.FullName, name, url); err != nil {
+		log.Printf("Failed to add %s to the crawl index: %v", request.FullName, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(repo)
+}
+
+// indexCrawlRequest adds a minimal document for the repository to the crawl
+// index. A document the crawler already wrote is left alone.
+func (s *Server) indexCrawlRequest(ctx context.Context, fullName, name, url string) error {
+	if s.esClient == nil {
+		return nil
+	}
+
+	doc, err := json.Marshal(map[string]interface{}{
+		"full_name":  fullName,
+		"name":       name,
+		"url":        url,
+		"crawled_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := s.esClient.Create(searchIndex, strings.ReplaceAll(fullName, "/", "-"), bytes.NewReader(doc),
+		s.esClient.Create.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() && res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("index error: %s", res.String())
+	}
+	return nil
+}
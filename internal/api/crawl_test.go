@@ -0,0 +1,179 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codelupe/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var repositoryColumnNames = []string{
+	"id", "full_name", "name", "description", "language", "stars", "forks",
+	"quality_score", "download_status", "local_path", "created_at", "updated_at",
+}
+
+func TestValidateFullName(t *testing.T) {
+	tests := []struct {
+		fullName string
+		valid    bool
+	}{
+		{"rust-lang/rust", true},
+		{"golang/go", true},
+		{"a/b", true},
+		{"user-1/my.repo_name-2", true},
+		{"", false},
+		{"rust-lang", false},
+		{"/rust", false},
+		{"rust-lang/", false},
+		{"rust-lang/rust/extra", false},
+		{"rust-lang%2Frust", false},
+		{"rust-lang/rust%2F..", false},
+		{"-owner/repo", false},
+		{"owner-/repo", false},
+		{"own_er/repo", false},
+		{"owner/..", false},
+		{"owner/.", false},
+		{"owner/re po", false},
+		{"owner/repo\n", false},
+		{"https://github.com/owner/repo", false},
+		{strings.Repeat("a", 40) + "/repo", false},
+		{"owner/" + strings.Repeat("r", 101), false},
+		{strings.Repeat("a", 39) + "/" + strings.Repeat("r", 100), true},
+	}
+
+	for _, tt := range tests {
+		err := validateFullName(tt.fullName)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateFullName(%q) error = %v, want valid %v", tt.fullName, err, tt.valid)
+		}
+	}
+}
+
+func postRepository(server *Server, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/api/v1/repositories", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleCreateRepository(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	es, doc := newFakeElasticsearch(t, http.StatusCreated, `{"result": "created"}`)
+	server.esClient = es
+
+	now := time.Now()
+	mock.ExpectQuery("WHERE LOWER\\(full_name\\) = LOWER\\(\\$1\\)").
+		WithArgs("octo-org/widgets").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO repositories .* ON CONFLICT \\(full_name\\) DO NOTHING").
+		WithArgs("octo-org/widgets", "widgets", "https://github.com/octo-org/widgets",
+			"https://github.com/octo-org/widgets.git", "octo-org").
+		WillReturnRows(sqlmock.NewRows(repositoryColumnNames).
+			AddRow(9, "octo-org/widgets", "widgets", nil, "", 0, 0, 0, "pending", nil, now, now))
+
+	w := postRepository(server, `{"full_name": "octo-org/widgets"}`)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+	var repo models.RepositoryResponse
+	if err := json.NewDecoder(w.Body).Decode(&repo); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if repo.ID != 9 || repo.DownloadStatus != "pending" {
+		t.Errorf("repo = %+v, want the pending row", repo)
+	}
+	if (*doc)["full_name"] != "octo-org/widgets" || (*doc)["url"] != "https://github.com/octo-org/widgets" {
+		t.Errorf("indexed document = %v", *doc)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleCreateRepository_Existing(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("WHERE LOWER\\(full_name\\) = LOWER\\(\\$1\\)").
+		WithArgs("Rust-Lang/Rust").
+		WillReturnRows(sqlmock.NewRows(repositoryColumnNames).
+			AddRow(1, "rust-lang/rust", "rust", "A safe language", "Rust", 50000, 10000, 95,
+				"downloaded", "/repos/rust", now, now))
+
+	w := postRepository(server, `{"full_name": "Rust-Lang/Rust"}`)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var repo models.RepositoryResponse
+	json.NewDecoder(w.Body).Decode(&repo)
+	if repo.ID != 1 || repo.DownloadStatus != "downloaded" {
+		t.Errorf("repo = %+v, want the existing row", repo)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleCreateRepository_ConcurrentInsert(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("WHERE LOWER\\(full_name\\)").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("INSERT INTO repositories").WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("WHERE LOWER\\(full_name\\)").
+		WillReturnRows(sqlmock.NewRows(repositoryColumnNames).
+			AddRow(5, "a/b", "b", nil, "", 0, 0, 0, "pending", nil, now, now))
+
+	w := postRepository(server, `{"full_name": "a/b"}`)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleCreateRepository_BadRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `full_name=a/b`},
+		{"missing name", `{}`},
+		{"no slash", `{"full_name": "rust"}`},
+		{"URL-encoded slash", `{"full_name": "rust-lang%2Frust"}`},
+		{"path traversal", `{"full_name": "../etc"}`},
+		{"URL instead of name", `{"full_name": "https://github.com/rust-lang/rust"}`},
+		{"overly long name", `{"full_name": "owner/` + strings.Repeat("r", 200) + `"}`},
+		{"oversized body", `{"full_name": "a/b", "padding": "` + strings.Repeat("x", maxCrawlRequestBody) + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupMockServer(t)
+			defer server.db.Close()
+
+			w := postRepository(server, tt.body)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("no query should run: %v", err)
+			}
+		})
+	}
+}
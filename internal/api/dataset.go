@@ -0,0 +1,84 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"codelupe/internal/dataset"
+)
+
+// maxActivityHours bounds the activity window to 30 days
+const maxActivityHours = 720
+
+// handleDatasetOverview returns the dataset analyzer's full report
+func (s *Server) handleDatasetOverview(w http.ResponseWriter, r *http.Request) {
+	report, err := s.dataset.Overview()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, report)
+}
+
+// handleDatasetLanguages returns per-language statistics with each
+// language's top repositories
+func (s *Server) handleDatasetLanguages(w http.ResponseWriter, r *http.Request) {
+	languages, err := s.dataset.Languages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if languages == nil {
+		languages = []dataset.LanguageStats{}
+	}
+	writeJSON(w, r, languages)
+}
+
+// handleDatasetActivity returns files processed over the last hours,
+// bucketed by hour or day and language
+func (s *Server) handleDatasetActivity(w http.ResponseWriter, r *http.Request) {
+	hours := 24
+	if raw := r.URL.Query().Get("hours"); raw != "" {
+		var err error
+		hours, err = strconv.Atoi(raw)
+		if err != nil || hours < 1 || hours > maxActivityHours {
+			http.Error(w, fmt.Sprintf("hours must be between 1 and %d", maxActivityHours), http.StatusBadRequest)
+			return
+		}
+	}
+
+	granularity := r.URL.Query().Get("granularity")
+	switch granularity {
+	case "":
+		granularity = dataset.GranularityHour
+	case dataset.GranularityHour, dataset.GranularityDay:
+	default:
+		http.Error(w, fmt.Sprintf("Invalid granularity %q", granularity), http.StatusBadRequest)
+		return
+	}
+	language := r.URL.Query().Get("language")
+
+	activity, err := s.dataset.Activity(hours, granularity, language)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if activity == nil {
+		activity = []dataset.ActivityBucket{}
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"hours":       hours,
+		"granularity": granularity,
+		"language":    language,
+		"activity":    activity,
+	})
+}
+
+// handleDatasetRefresh drops the cached statistics so the next request
+// recomputes them
+func (s *Server) handleDatasetRefresh(w http.ResponseWriter, r *http.Request) {
+	s.dataset.Invalidate()
+	writeJSON(w, r, map[string]string{"status": "invalidated"})
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codelupe/internal/dataset"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectDatasetActivity(mock sqlmock.Sqlmock, files int64) {
+	mock.ExpectQuery("DATE_TRUNC\\(\\$1, processed_at\\)").
+		WithArgs("day", 48, "Go").
+		WillReturnRows(sqlmock.NewRows([]string{"period", "language", "files_processed", "bytes_processed"}).
+			AddRow(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), "Go", files, files*100))
+}
+
+func TestHandleDatasetActivity(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	expectDatasetActivity(mock, 40)
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/dataset/activity?hours=48&granularity=day&language=Go", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The second request is served from cache; sqlmock fails on a second query
+	for i := 0; i < 2; i++ {
+		w := get()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+		}
+
+		var response struct {
+			Hours       int                      `json:"hours"`
+			Granularity string                   `json:"granularity"`
+			Activity    []dataset.ActivityBucket `json:"activity"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if response.Hours != 48 || response.Granularity != "day" {
+			t.Errorf("hours = %d, granularity = %q", response.Hours, response.Granularity)
+		}
+		if len(response.Activity) != 1 || response.Activity[0].FilesProcessed != 40 {
+			t.Errorf("activity = %+v", response.Activity)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleDatasetActivity_BadRequest(t *testing.T) {
+	server, _ := setupMockServer(t)
+	defer server.db.Close()
+
+	for _, query := range []string{"hours=0", "hours=721", "hours=day", "granularity=week"} {
+		req := httptest.NewRequest("GET", "/api/v1/dataset/activity?"+query, nil)
+		w := httptest.NewRecorder()
+
+		server.handleDatasetActivity(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestHandleDatasetRefresh(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	expectDatasetActivity(mock, 40)
+	expectDatasetActivity(mock, 55)
+
+	activity := func() int64 {
+		req := httptest.NewRequest("GET", "/api/v1/dataset/activity?hours=48&granularity=day&language=Go", nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+
+		var response struct {
+			Activity []dataset.ActivityBucket `json:"activity"`
+		}
+		json.NewDecoder(w.Body).Decode(&response)
+		return response.Activity[0].FilesProcessed
+	}
+
+	if got := activity(); got != 40 {
+		t.Fatalf("files_processed = %d, want 40", got)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/dataset/refresh", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh status code = %d, want %d", w.Code, http.StatusOK)
+	}
+
+	if got := activity(); got != 55 {
+		t.Errorf("files_processed after refresh = %d, want a recomputed 55", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleDatasetLanguages_Empty(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("COUNT\\(\\*\\) as total_files").
+		WillReturnRows(sqlmock.NewRows([]string{"total_files", "total_size", "total_repos",
+			"avg_quality", "avg_file_size", "avg_lines_per_file"}).
+			AddRow(0, 0, 0, 0.0, 0.0, 0.0))
+	mock.ExpectQuery("MIN\\(processed_at\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"start_time", "end_time"}).AddRow(nil, nil))
+	mock.ExpectQuery("GROUP BY language").
+		WillReturnRows(sqlmock.NewRows([]string{"language", "file_count", "total_size",
+			"avg_size", "avg_quality", "avg_lines"}))
+	mock.ExpectQuery("license_key").
+		WillReturnRows(sqlmock.NewRows([]string{"license", "file_count", "repo_count", "total_size"}))
+	mock.ExpectQuery("quality_tier").
+		WillReturnRows(sqlmock.NewRows([]string{"quality_tier", "file_count"}))
+
+	req := httptest.NewRequest("GET", "/api/v1/dataset/languages", nil)
+	w := httptest.NewRecorder()
+
+	server.handleDatasetLanguages(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Errorf("body = %q, want an empty array", body)
+	}
+}
@@ -0,0 +1,532 @@
+package api
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"codelupe/internal/models"
+	"codelupe/pkg/contentstore"
+	"codelupe/pkg/metrics"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Defaults for the export settings in Config
+const (
+	defaultExportsDir        = "exports"
+	defaultExportConcurrency = 2
+	defaultExportTTL         = 24 * time.Hour
+)
+
+const (
+	// maxQueuedExports bounds the jobs waiting for a free slot; further
+	// requests get 429
+	maxQueuedExports = 20
+	// exportBatchSize is how many files an export reads per query
+	exportBatchSize = 500
+	// exportSweepInterval is how often expired artifacts are deleted
+	exportSweepInterval = 10 * time.Minute
+	// maxExportRequestBody bounds the POST /exports body
+	maxExportRequestBody = 4 << 10
+)
+
+// Export job statuses
+const (
+	exportQueued    = "queued"
+	exportRunning   = "running"
+	exportCompleted = "completed"
+	exportFailed    = "failed"
+)
+
+// errExportQueueFull is returned by submit when maxQueued jobs are waiting
+var errExportQueueFull = errors.New("too many export jobs queued")
+
+// ExportFilter selects the processed files an export job writes. Files
+// marked removed from their repository are always left out.
+type ExportFilter struct {
+	Languages  []string `json:"languages,omitempty"` // matched case-insensitively; empty means all
+	RepoName   string   `json:"repo_name,omitempty"`
+	MinQuality int      `json:"min_quality,omitempty"`
+	MinLines   int      `json:"min_lines,omitempty"`
+	MaxLines   int      `json:"max_lines,omitempty"`
+	MaxSize    int64    `json:"max_size,omitempty"` // bytes
+	// Limit caps the files written, lowest ids first; 0 writes every match
+	Limit int64 `json:"limit,omitempty"`
+}
+
+// validate checks the filter's bounds
+func (f ExportFilter) validate() error {
+	if f.MinQuality < 0 || f.MinQuality > 100 {
+		return fmt.Errorf("min_quality must be between 0 and 100")
+	}
+	if f.MinLines < 0 || f.MaxLines < 0 || f.MaxSize < 0 || f.Limit < 0 {
+		return fmt.Errorf("min_lines, max_lines, max_size and limit can't be negative")
+	}
+	if f.MaxLines > 0 && f.MinLines > f.MaxLines {
+		return fmt.Errorf("min_lines %d is above max_lines %d", f.MinLines, f.MaxLines)
+	}
+	return nil
+}
+
+// where returns the filter's WHERE clause and the arguments it binds
+func (f ExportFilter) where() (string, []interface{}) {
+	conditions := []string{"removed_at IS NULL"}
+	var args []interface{}
+	add := func(condition string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if len(f.Languages) > 0 {
+		languages := make([]string, len(f.Languages))
+		for i, language := range f.Languages {
+			languages[i] = strings.ToLower(strings.TrimSpace(language))
+		}
+		add("LOWER(language) = ANY($%d)", pq.Array(languages))
+	}
+	if f.RepoName != "" {
+		add("repo_name = $%d", f.RepoName)
+	}
+	if f.MinQuality > 0 {
+		add("quality_score >= $%d", f.MinQuality)
+	}
+	if f.MinLines > 0 {
+		add("lines >= $%d", f.MinLines)
+	}
+	if f.MaxLines > 0 {
+		add("lines <= $%d", f.MaxLines)
+	}
+	if f.MaxSize > 0 {
+		add("size <= $%d", f.MaxSize)
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// ExportJob is a dataset snapshot requested through POST /api/v1/exports:
+// the matching processed files as gzipped JSONL, one models.FileResponse
+// with content per line
+type ExportJob struct {
+	ID     string       `json:"id"`
+	Status string       `json:"status"`
+	Filter ExportFilter `json:"filter"`
+	// FilesTotal is counted when the job starts; Progress is the share of
+	// it written so far
+	FilesTotal   int64      `json:"files_total"`
+	FilesWritten int64      `json:"files_written"`
+	Progress     float64    `json:"progress"`
+	Bytes        int64      `json:"bytes,omitempty"` // artifact size, once completed
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty"`
+	// ExpiresAt is when a finished job and its artifact are deleted
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"`
+}
+
+// exportManager runs export jobs in the background, at most
+// cap(slots) at a time. Jobs live in memory: artifacts left over from a
+// previous run of the server can't be asked for and are deleted by the
+// janitor.
+type exportManager struct {
+	db        *sql.DB
+	content   *contentstore.Store
+	dir       string
+	ttl       time.Duration
+	maxQueued int
+	now       func() time.Time
+
+	slots  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs map[string]*ExportJob
+}
+
+// newExportManager writes artifacts to dir, running concurrency jobs at
+// once and keeping finished ones for ttl
+func newExportManager(db *sql.DB, content *contentstore.Store, dir string, concurrency int, ttl time.Duration) *exportManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &exportManager{
+		db:        db,
+		content:   content,
+		dir:       dir,
+		ttl:       ttl,
+		maxQueued: maxQueuedExports,
+		now:       time.Now,
+		slots:     make(chan struct{}, concurrency),
+		ctx:       ctx,
+		cancel:    cancel,
+		jobs:      make(map[string]*ExportJob),
+	}
+}
+
+// artifactPath is where a job's gzipped JSONL is written
+func (m *exportManager) artifactPath(id string) string {
+	return filepath.Join(m.dir, id+".jsonl.gz")
+}
+
+// submit queues a job for filter and returns it
+func (m *exportManager) submit(filter ExportFilter) (ExportJob, error) {
+	var raw [8]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return ExportJob{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queued := 0
+	for _, job := range m.jobs {
+		if job.Status == exportQueued {
+			queued++
+		}
+	}
+	if queued >= m.maxQueued {
+		return ExportJob{}, errExportQueueFull
+	}
+
+	job := &ExportJob{
+		ID:        hex.EncodeToString(raw[:]),
+		Status:    exportQueued,
+		Filter:    filter,
+		CreatedAt: m.now().UTC(),
+	}
+	m.jobs[job.ID] = job
+	m.wg.Add(1)
+	go m.run(job.ID)
+	return *job, nil
+}
+
+// get returns a copy of the job with its progress filled in
+func (m *exportManager) get(id string) (ExportJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return ExportJob{}, false
+	}
+	out := *job
+	switch {
+	case out.Status == exportCompleted:
+		out.Progress = 1
+	case out.FilesTotal > 0:
+		out.Progress = float64(out.FilesWritten) / float64(out.FilesTotal)
+	}
+	return out, true
+}
+
+// update changes a job under the lock
+func (m *exportManager) update(id string, change func(job *ExportJob)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if job, ok := m.jobs[id]; ok {
+		change(job)
+	}
+}
+
+// run waits for a slot, then writes the job's artifact
+func (m *exportManager) run(id string) {
+	defer m.wg.Done()
+
+	finish := func(err error) {
+		now := m.now().UTC()
+		expires := now.Add(m.ttl)
+		status := exportCompleted
+		if err != nil {
+			status = exportFailed
+			log.Printf("Export %s failed: %v", id, err)
+		}
+		m.update(id, func(job *ExportJob) {
+			job.Status = status
+			job.CompletedAt = &now
+			job.ExpiresAt = &expires
+			if err != nil {
+				job.Error = err.Error()
+				return
+			}
+			job.DownloadURL = "/api/v1/exports/" + id + "/download"
+		})
+		metrics.IncrCounterWithLabels("codelupe_api_exports_total", map[string]string{"status": status}, 1)
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	case <-m.ctx.Done():
+		finish(m.ctx.Err())
+		return
+	}
+	defer func() { <-m.slots }()
+
+	started := m.now().UTC()
+	var filter ExportFilter
+	m.update(id, func(job *ExportJob) {
+		job.Status = exportRunning
+		job.StartedAt = &started
+		filter = job.Filter
+	})
+	finish(m.write(id, filter))
+}
+
+// write streams the files matching filter into the job's artifact. It is
+// written under a temporary name and renamed once complete, so a download
+// never sees a partial file.
+func (m *exportManager) write(id string, filter ExportFilter) (err error) {
+	where, args := filter.where()
+
+	var total int64
+	if err := m.db.QueryRowContext(m.ctx, "SELECT COUNT(*) FROM processed_files"+where, args...).Scan(&total); err != nil {
+		return fmt.Errorf("failed to count files: %w", err)
+	}
+	if filter.Limit > 0 && total > filter.Limit {
+		total = filter.Limit
+	}
+	m.update(id, func(job *ExportJob) { job.FilesTotal = total })
+
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return err
+	}
+	path := m.artifactPath(id)
+	f, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(path + ".tmp")
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	enc := json.NewEncoder(gz)
+	query := `SELECT ` + fileColumns + `, content, content_zstd, content_path FROM processed_files` + where +
+		fmt.Sprintf(" AND id > $%d ORDER BY id LIMIT $%d", len(args)+1, len(args)+2)
+
+	var written, afterID int64
+	for filter.Limit == 0 || written < filter.Limit {
+		if err := m.ctx.Err(); err != nil {
+			return err
+		}
+		limit := int64(exportBatchSize)
+		if filter.Limit > 0 {
+			limit = min(limit, filter.Limit-written)
+		}
+
+		n, lastID, err := m.writeBatch(enc, query, append(args, afterID, limit))
+		if err != nil {
+			return err
+		}
+		written += n
+		afterID = lastID
+		m.update(id, func(job *ExportJob) { job.FilesWritten = written })
+		if n < limit {
+			break
+		}
+	}
+
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	info, err := os.Stat(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	m.update(id, func(job *ExportJob) { job.Bytes = info.Size() })
+	return nil
+}
+
+// writeBatch writes one page of files and returns how many there were and
+// the id of the last
+func (m *exportManager) writeBatch(enc *json.Encoder, query string, args []interface{}) (int64, int64, error) {
+	rows, err := m.db.QueryContext(m.ctx, query, args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query files: %w", err)
+	}
+	defer rows.Close()
+
+	var n, lastID int64
+	for rows.Next() {
+		var file models.FileResponse
+		var content, contentPath sql.NullString
+		var compressed []byte
+		if err := rows.Scan(
+			&file.ID, &file.RepoName, &file.RelativePath, &file.Language,
+			&file.Lines, &file.Size, &file.Hash, &file.QualityScore, &file.ProcessedAt,
+			&content, &compressed, &contentPath,
+		); err != nil {
+			return 0, 0, err
+		}
+		data, err := m.content.Load(contentstore.FromColumns(content, compressed, contentPath))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to load content of file %d: %w", file.ID, err)
+		}
+		text := string(data)
+		file.Content = &text
+		if err := enc.Encode(file); err != nil {
+			return 0, 0, err
+		}
+		n++
+		lastID = file.ID
+	}
+	return n, lastID, rows.Err()
+}
+
+// sweep forgets finished jobs past their expiry and deletes their
+// artifacts, returning how many were dropped
+func (m *exportManager) sweep() int {
+	now := m.now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dropped := 0
+	for id, job := range m.jobs {
+		if job.ExpiresAt == nil || now.Before(*job.ExpiresAt) {
+			continue
+		}
+		if err := os.Remove(m.artifactPath(id)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete expired export %s: %v", id, err)
+			continue
+		}
+		delete(m.jobs, id)
+		dropped++
+	}
+	return dropped
+}
+
+// removeOrphans deletes artifacts no job knows about, left by an earlier
+// run of the server
+func (m *exportManager) removeOrphans() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, entry := range entries {
+		name := entry.Name()
+		id, ok := strings.CutSuffix(strings.TrimSuffix(name, ".tmp"), ".jsonl.gz")
+		if !ok || m.jobs[id] != nil {
+			continue
+		}
+		os.Remove(filepath.Join(m.dir, name))
+	}
+}
+
+// janitor deletes orphaned artifacts, then expired ones every
+// exportSweepInterval until stop
+func (m *exportManager) janitor() {
+	m.removeOrphans()
+	ticker := time.NewTicker(exportSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if n := m.sweep(); n > 0 {
+				log.Printf("Deleted %d expired exports", n)
+			}
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// stop cancels queued and running jobs and waits for them to finish
+func (m *exportManager) stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// handleCreateExport queues an export of the processed files matching the
+// JSON filter in the body. It answers 202 with the job, whose progress is
+// polled at its Location.
+func (s *Server) handleCreateExport(w http.ResponseWriter, r *http.Request) {
+	var filter ExportFilter
+	r.Body = http.MaxBytesReader(w, r.Body, maxExportRequestBody)
+	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if err := filter.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.exports.submit(filter)
+	if err == errExportQueueFull {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many exports queued; try again later", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/v1/exports/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleGetExport returns an export job's status and progress
+func (s *Server) handleGetExport(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.exports.get(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, job)
+}
+
+// handleDownloadExport serves a completed export's gzipped JSONL
+func (s *Server) handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	job, ok := s.exports.get(id)
+	if !ok {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+	if job.Status != exportCompleted {
+		http.Error(w, fmt.Sprintf("Export %s is %s", id, job.Status), http.StatusConflict)
+		return
+	}
+
+	f, err := os.Open(s.exports.artifactPath(id))
+	if os.IsNotExist(err) {
+		http.Error(w, "Export has expired", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	// Large artifacts take longer than the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	name := "codelupe-export-" + id + ".jsonl.gz"
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	http.ServeContent(w, r, name, *job.CompletedAt, f)
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codelupe/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var exportColumnNames = []string{
+	"id", "repo_name", "relative_path", "language", "lines", "size", "hash", "quality_score", "processed_at",
+	"content", "content_zstd", "content_path",
+}
+
+// setupExportServer returns a mock server whose exports go to a temp dir,
+// one at a time
+func setupExportServer(t *testing.T) (*Server, sqlmock.Sqlmock, string) {
+	server, mock := setupMockServer(t)
+	dir := t.TempDir()
+	server.exports = newExportManager(server.db, server.content, dir, 1, time.Hour)
+	return server, mock, dir
+}
+
+func serveExports(server *Server, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	return w
+}
+
+// waitForExport polls the job until it has finished
+func waitForExport(t *testing.T, server *Server, id string) ExportJob {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w := serveExports(server, "GET", "/api/v1/exports/"+id, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET export status code = %d: %s", w.Code, w.Body.String())
+		}
+		var job ExportJob
+		if err := json.Unmarshal(w.Body.Bytes(), &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == exportCompleted || job.Status == exportFailed {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("export still %s", job.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExportLifecycle(t *testing.T) {
+	server, mock, dir := setupExportServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM processed_files WHERE removed_at IS NULL AND LOWER\(language\) = ANY\(\$1\) AND quality_score >= \$2`).
+		WithArgs(sqlmock.AnyArg(), 80).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	// Fewer rows than a batch means there are no more
+	mock.ExpectQuery(`SELECT id, repo_name, .*, content, content_zstd, content_path FROM processed_files WHERE .* AND id > \$3 ORDER BY id LIMIT \$4`).
+		WithArgs(sqlmock.AnyArg(), 80, int64(0), int64(exportBatchSize)).
+		WillReturnRows(sqlmock.NewRows(exportColumnNames).
+			AddRow(3, "repo-a", "app.py", "Python", 2, 20, "h3", 85, now, "print('a')\n", nil, nil).
+			AddRow(8, "repo-b", "lib.py", "Python", 1, 10, "h8", 92, now, "x = 1", nil, nil))
+
+	w := serveExports(server, "POST", "/api/v1/exports", `{"languages": ["python"], "min_quality": 80}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST status code = %d: %s", w.Code, w.Body.String())
+	}
+	var queued ExportJob
+	if err := json.Unmarshal(w.Body.Bytes(), &queued); err != nil {
+		t.Fatal(err)
+	}
+	if queued.ID == "" || w.Header().Get("Location") != "/api/v1/exports/"+queued.ID {
+		t.Fatalf("job id %q, Location %q", queued.ID, w.Header().Get("Location"))
+	}
+
+	job := waitForExport(t, server, queued.ID)
+	if job.Status != exportCompleted || job.FilesTotal != 2 || job.FilesWritten != 2 || job.Progress != 1 || job.Bytes == 0 {
+		t.Fatalf("finished job = %+v", job)
+	}
+	if job.DownloadURL != "/api/v1/exports/"+job.ID+"/download" || job.ExpiresAt == nil {
+		t.Errorf("download_url = %q, expires_at = %v", job.DownloadURL, job.ExpiresAt)
+	}
+
+	w = serveExports(server, "GET", job.DownloadURL, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("download status code = %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, job.ID+".jsonl.gz") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("download is not gzipped: %v", err)
+	}
+	var files []models.FileResponse
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var file models.FileResponse
+		if err := json.Unmarshal(scanner.Bytes(), &file); err != nil {
+			t.Fatalf("invalid JSONL line %q: %v", scanner.Text(), err)
+		}
+		files = append(files, file)
+	}
+	if len(files) != 2 || files[0].RelativePath != "app.py" || files[0].Content == nil || *files[0].Content != "print('a')\n" {
+		t.Fatalf("downloaded files = %+v", files)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	// Once expired the job and its artifact are gone
+	server.exports.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if n := server.exports.sweep(); n != 1 {
+		t.Errorf("sweep() = %d, want 1", n)
+	}
+	if _, err := os.Stat(filepath.Join(dir, job.ID+".jsonl.gz")); !os.IsNotExist(err) {
+		t.Errorf("artifact still there after expiry: %v", err)
+	}
+	if w := serveExports(server, "GET", "/api/v1/exports/"+job.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET expired export status code = %d, want 404", w.Code)
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	server, mock, dir := setupExportServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM processed_files WHERE removed_at IS NULL`).
+		WithArgs().
+		WillReturnError(errors.New("connection reset"))
+
+	w := serveExports(server, "POST", "/api/v1/exports", `{}`)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("POST status code = %d: %s", w.Code, w.Body.String())
+	}
+	var queued ExportJob
+	json.Unmarshal(w.Body.Bytes(), &queued)
+
+	job := waitForExport(t, server, queued.ID)
+	if job.Status != exportFailed || !strings.Contains(job.Error, "connection reset") || job.DownloadURL != "" {
+		t.Errorf("failed job = %+v", job)
+	}
+	if w := serveExports(server, "GET", "/api/v1/exports/"+job.ID+"/download", ""); w.Code != http.StatusConflict {
+		t.Errorf("download of failed export status code = %d, want 409", w.Code)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("failed export left %d files behind", len(entries))
+	}
+}
+
+func TestCreateExport_InvalidFilter(t *testing.T) {
+	server, _, _ := setupExportServer(t)
+	defer server.db.Close()
+
+	for _, body := range []string{
+		``,
+		`{"languages": "python"}`,
+		`{"min_quality": 120}`,
+		`{"min_lines": -1}`,
+		`{"min_lines": 50, "max_lines": 10}`,
+	} {
+		if w := serveExports(server, "POST", "/api/v1/exports", body); w.Code != http.StatusBadRequest {
+			t.Errorf("POST %q status code = %d, want 400", body, w.Code)
+		}
+	}
+	if w := serveExports(server, "GET", "/api/v1/exports/nope", ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET unknown export status code = %d, want 404", w.Code)
+	}
+}
+
+func TestExportQueueLimit(t *testing.T) {
+	server, _, _ := setupExportServer(t)
+	defer server.db.Close()
+
+	// Hold the only slot so submitted jobs stay queued
+	server.exports.maxQueued = 2
+	server.exports.slots <- struct{}{}
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		w := serveExports(server, "POST", "/api/v1/exports", `{}`)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("POST %d status code = %d", i, w.Code)
+		}
+		var job ExportJob
+		json.Unmarshal(w.Body.Bytes(), &job)
+		ids = append(ids, job.ID)
+	}
+	w := serveExports(server, "POST", "/api/v1/exports", `{}`)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("POST over the queue limit status code = %d, Retry-After %q; want 429", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serveExports(server, "GET", "/api/v1/exports/"+ids[0]+"/download", ""); w.Code != http.StatusConflict {
+		t.Errorf("download of queued export status code = %d, want 409", w.Code)
+	}
+
+	// Shutting down fails the jobs still waiting
+	server.exports.stop()
+	for _, id := range ids {
+		if job, _ := server.exports.get(id); job.Status != exportFailed {
+			t.Errorf("job %s after stop = %s, want failed", id, job.Status)
+		}
+	}
+}
+
+func TestExportRemoveOrphans(t *testing.T) {
+	server, _, dir := setupExportServer(t)
+	defer server.db.Close()
+
+	server.exports.jobs["known"] = &ExportJob{ID: "known", Status: exportCompleted}
+	for _, name := range []string{"known.jsonl.gz", "stale.jsonl.gz", "partial.jsonl.gz.tmp", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, name), []byte("x"), 0644)
+	}
+
+	server.exports.removeOrphans()
+
+	entries, _ := os.ReadDir(dir)
+	var left []string
+	for _, entry := range entries {
+		left = append(left, entry.Name())
+	}
+	if strings.Join(left, ",") != "known.jsonl.gz,notes.txt" {
+		t.Errorf("files left = %v, want the known artifact and unrelated files", left)
+	}
+}
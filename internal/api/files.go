@@ -0,0 +1,309 @@
+package api
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"codelupe/internal/models"
+	"codelupe/pkg/contentstore"
+
+	"github.com/gorilla/mux"
+)
+
+// File listing page sizes
+const (
+	defaultFilePageSize = 20
+	maxFilePageSize     = 100
+)
+
+// gzipMinSize is the smallest response worth compressing
+const gzipMinSize = 1024
+
+// fileColumns are the processed_files columns scanned into a models.FileResponse,
+// excluding content
+const fileColumns = `id, repo_name, relative_path, language, lines, size, hash, quality_score, processed_at`
+
+// fileFilter selects a page of processed files. Pages are keyed on id:
+// AfterID is the last id of the previous page.
+type fileFilter struct {
+	Language   string
+	RepoName   string
+	MinQuality int
+	MinLines   int
+	MaxLines   int
+	AfterID    int64
+	Limit      int
+}
+
+// parseFileFilter reads a file filter from the query string
+func parseFileFilter(values url.Values) (fileFilter, error) {
+	filter := fileFilter{
+		Language: values.Get("language"),
+		RepoName: values.Get("repo_name"),
+		Limit:    defaultFilePageSize,
+	}
+
+	ints := []struct {
+		name string
+		dest *int
+	}{
+		{"min_quality", &filter.MinQuality},
+		{"min_lines", &filter.MinLines},
+		{"max_lines", &filter.MaxLines},
+		{"limit", &filter.Limit},
+	}
+	for _, param := range ints {
+		value := values.Get(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return filter, fmt.Errorf("Invalid %s %q", param.name, value)
+		}
+		*param.dest = n
+	}
+
+	if value := values.Get("after_id"); value != "" {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || id < 0 {
+			return filter, fmt.Errorf("Invalid after_id %q", value)
+		}
+		filter.AfterID = id
+	}
+
+	if filter.Limit < 1 {
+		filter.Limit = defaultFilePageSize
+	}
+	if filter.Limit > maxFilePageSize {
+		filter.Limit = maxFilePageSize
+	}
+	return filter, nil
+}
+
+// listFiles returns a page of files matching filter, and the after_id of the
+// next page or 0 if this is the last one
+func (s *Server) listFiles(filter fileFilter) ([]models.FileResponse, int64, error) {
+	query := `SELECT ` + fileColumns + ` FROM processed_files WHERE id > $1`
+	args := []interface{}{filter.AfterID}
+
+	addCondition := func(condition string, value interface{}) {
+		args = append(args, value)
+		query += fmt.Sprintf(" AND "+condition, len(args))
+	}
+	if filter.Language != "" {
+		addCondition("language = $%d", filter.Language)
+	}
+	if filter.RepoName != "" {
+		addCondition("repo_name = $%d", filter.RepoName)
+	}
+	if filter.MinQuality > 0 {
+		addCondition("quality_score >= $%d", filter.MinQuality)
+	}
+	if filter.MinLines > 0 {
+		addCondition("lines >= $%d", filter.MinLines)
+	}
+	if filter.MaxLines > 0 {
+		addCondition("lines <= $%d", filter.MaxLines)
+	}
+
+	// One extra row tells whether there is a next page
+	args = append(args, filter.Limit+1)
+	query += fmt.Sprintf(" ORDER BY id LIMIT $%d", len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	files := []models.FileResponse{}
+	for rows.Next() {
+		var file models.FileResponse
+		if err := rows.Scan(
+			&file.ID, &file.RepoName, &file.RelativePath, &file.Language,
+			&file.Lines, &file.Size, &file.Hash, &file.QualityScore, &file.ProcessedAt,
+		); err != nil {
+			return nil, 0, err
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var nextAfterID int64
+	if len(files) > filter.Limit {
+		files = files[:filter.Limit]
+		nextAfterID = files[len(files)-1].ID
+	}
+	return files, nextAfterID, nil
+}
+
+// writeFilePage writes a page of files as returned by listFiles
+func writeFilePage(w http.ResponseWriter, r *http.Request, files []models.FileResponse, limit int, nextAfterID int64) {
+	response := map[string]interface{}{
+		"data":  files,
+		"count": len(files),
+		"limit": limit,
+	}
+	if nextAfterID > 0 {
+		response["next_after_id"] = nextAfterID
+	}
+	writeJSON(w, r, response)
+}
+
+// handleListFiles returns a page of processed files
+func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFileFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	files, nextAfterID, err := s.listFiles(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeFilePage(w, r, files, filter.Limit, nextAfterID)
+}
+
+// handleListRepositoryFiles returns a page of one repository's processed
+// files. The processors record files under the base name of the clone
+// directory, so that is what is matched.
+func (s *Server) handleListRepositoryFiles(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseFileFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var name string
+	var localPath sql.NullString
+	err = s.db.QueryRow(`SELECT name, local_path FROM repositories WHERE id = $1`, mux.Vars(r)["id"]).
+		Scan(&name, &localPath)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filter.RepoName = name
+	if localPath.Valid && localPath.String != "" {
+		filter.RepoName = filepath.Base(localPath.String)
+	}
+
+	files, nextAfterID, err := s.listFiles(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeFilePage(w, r, files, filter.Limit, nextAfterID)
+}
+
+// handleGetFile returns one processed file, with its content unless
+// ?content=false
+func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid file id", http.StatusBadRequest)
+		return
+	}
+	withContent := r.URL.Query().Get("content") != "false"
+
+	query := `SELECT ` + fileColumns
+	if withContent {
+		query += `, content, content_zstd, content_path`
+	}
+	query += ` FROM processed_files WHERE id = $1`
+
+	var file models.FileResponse
+	var content, contentPath sql.NullString
+	var compressed []byte
+	dest := []interface{}{
+		&file.ID, &file.RepoName, &file.RelativePath, &file.Language,
+		&file.Lines, &file.Size, &file.Hash, &file.QualityScore, &file.ProcessedAt,
+	}
+	if withContent {
+		dest = append(dest, &content, &compressed, &contentPath)
+	}
+
+	err = s.db.QueryRow(query, id).Scan(dest...)
+	if err == sql.ErrNoRows {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if withContent {
+		data, err := s.content.Load(contentstore.FromColumns(content, compressed, contentPath))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		text := string(data)
+		file.Content = &text
+	}
+
+	writeJSON(w, r, file)
+}
+
+// writeJSON writes v as JSON, gzipped when the client accepts it and the
+// body is big enough to benefit
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONBytes(w, r, data)
+}
+
+// writeJSONBytes writes an encoded JSON body, gzipped like writeJSON
+func writeJSONBytes(w http.ResponseWriter, r *http.Request, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if len(data) < gzipMinSize || !acceptsGzip(r) {
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzip.NewWriter(w)
+	gz.Write(data)
+	gz.Close()
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), "gzip") {
+			continue
+		}
+		// gzip;q=0 explicitly refuses it
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if weight, err := strconv.ParseFloat(q, 64); err == nil && weight == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
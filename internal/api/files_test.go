@@ -0,0 +1,303 @@
+package api
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codelupe/internal/models"
+	"codelupe/pkg/contentstore"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+var fileColumnNames = []string{
+	"id", "repo_name", "relative_path", "language", "lines", "size", "hash", "quality_score", "processed_at",
+}
+
+func fileRows(ids ...int64) *sqlmock.Rows {
+	rows := sqlmock.NewRows(fileColumnNames)
+	for _, id := range ids {
+		rows.AddRow(id, "rust", "src/lib.rs", "Rust", 120, 4096, "abc123", 85, time.Now())
+	}
+	return rows
+}
+
+func TestHandleListFiles(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	// limit+1 rows are fetched to detect the next page
+	mock.ExpectQuery("FROM processed_files WHERE id > \\$1 AND language = \\$2 AND repo_name = \\$3 "+
+		"AND quality_score >= \\$4 AND lines >= \\$5 AND lines <= \\$6 ORDER BY id LIMIT \\$7").
+		WithArgs(int64(40), "Rust", "rust", 70, 10, 500, 3).
+		WillReturnRows(fileRows(41, 45, 46))
+
+	req := httptest.NewRequest("GET",
+		"/api/v1/files?language=Rust&repo_name=rust&min_quality=70&min_lines=10&max_lines=500&after_id=40&limit=2", nil)
+	w := httptest.NewRecorder()
+
+	server.handleListFiles(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response struct {
+		Data        []models.FileResponse `json:"data"`
+		Count       int                   `json:"count"`
+		NextAfterID int64                 `json:"next_after_id"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Count != 2 || len(response.Data) != 2 {
+		t.Errorf("count = %d, len(data) = %d, want 2", response.Count, len(response.Data))
+	}
+	if response.NextAfterID != 45 {
+		t.Errorf("next_after_id = %d, want 45", response.NextAfterID)
+	}
+	if response.Data[0].Content != nil {
+		t.Error("listing should not include content")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleListFiles_LastPage(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	// A limit over the maximum is capped at 100
+	mock.ExpectQuery("FROM processed_files WHERE id > \\$1 ORDER BY id LIMIT \\$2").
+		WithArgs(int64(0), maxFilePageSize+1).
+		WillReturnRows(fileRows(1, 2))
+
+	req := httptest.NewRequest("GET", "/api/v1/files?limit=5000", nil)
+	w := httptest.NewRecorder()
+
+	server.handleListFiles(w, req)
+
+	var response map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&response)
+	if response["limit"] != float64(maxFilePageSize) {
+		t.Errorf("limit = %v, want %d", response["limit"], maxFilePageSize)
+	}
+	if _, ok := response["next_after_id"]; ok {
+		t.Errorf("last page should have no next_after_id, got %v", response["next_after_id"])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleListFiles_InvalidParameters(t *testing.T) {
+	for _, query := range []string{"min_quality=high", "max_lines=-1", "after_id=abc"} {
+		t.Run(query, func(t *testing.T) {
+			server, _ := setupMockServer(t)
+			defer server.db.Close()
+
+			req := httptest.NewRequest("GET", "/api/v1/files?"+query, nil)
+			w := httptest.NewRecorder()
+
+			server.handleListFiles(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestHandleListRepositoryFiles(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("SELECT name, local_path FROM repositories WHERE id = \\$1").
+		WithArgs("7").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "local_path"}).
+			AddRow("rust", "/app/repos/Rust/rust-lang/rust"))
+	mock.ExpectQuery("FROM processed_files WHERE id > \\$1 AND repo_name = \\$2").
+		WithArgs(int64(0), "rust", defaultFilePageSize+1).
+		WillReturnRows(fileRows(1))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/7/files", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	w := httptest.NewRecorder()
+
+	server.handleListRepositoryFiles(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleListRepositoryFiles_NotFound(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("SELECT name, local_path FROM repositories").
+		WithArgs("999").
+		WillReturnError(sql.ErrNoRows)
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/999/files", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "999"})
+	w := httptest.NewRecorder()
+
+	server.handleListRepositoryFiles(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandleGetFile(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	// Content written by a processor in compressed mode
+	content := strings.Repeat("fn main() { println!(\"hello\"); }\n", 100)
+	compressedStore, err := contentstore.New(contentstore.ModeCompressed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := compressedStore.Save("abc123", []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectQuery("SELECT id, repo_name.*, content, content_zstd, content_path FROM processed_files WHERE id = \\$1").
+		WithArgs(int64(42)).
+		WillReturnRows(sqlmock.NewRows(append(fileColumnNames, "content", "content_zstd", "content_path")).
+			AddRow(42, "rust", "src/main.rs", "Rust", 100, len(content), "abc123", 90, time.Now(),
+				nil, stored.Compressed, nil))
+
+	req := httptest.NewRequest("GET", "/api/v1/files/42", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	req = mux.SetURLVars(req, map[string]string{"id": "42"})
+	w := httptest.NewRecorder()
+
+	server.handleGetFile(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", w.Header().Get("Content-Encoding"))
+	}
+
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Response is not gzipped: %v", err)
+	}
+	var file models.FileResponse
+	if err := json.NewDecoder(gz).Decode(&file); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if file.ID != 42 || file.Content == nil || *file.Content != content {
+		t.Errorf("file %d content was not returned decompressed", file.ID)
+	}
+}
+
+func TestHandleGetFile_MetadataOnly(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("SELECT id, repo_name, relative_path, language, lines, size, hash, quality_score, processed_at FROM processed_files WHERE id = \\$1").
+		WithArgs(int64(42)).
+		WillReturnRows(fileRows(42))
+
+	req := httptest.NewRequest("GET", "/api/v1/files/42?content=false", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req = mux.SetURLVars(req, map[string]string{"id": "42"})
+	w := httptest.NewRecorder()
+
+	server.handleGetFile(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	// Too small to be worth compressing
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("Content-Encoding = %q, want none", w.Header().Get("Content-Encoding"))
+	}
+
+	var response map[string]interface{}
+	json.NewDecoder(w.Body).Decode(&response)
+	if _, ok := response["content"]; ok {
+		t.Error("content=false response should not include content")
+	}
+	if response["relative_path"] != "src/lib.rs" {
+		t.Errorf("relative_path = %v, want src/lib.rs", response["relative_path"])
+	}
+}
+
+func TestHandleGetFile_Errors(t *testing.T) {
+	t.Run("not found", func(t *testing.T) {
+		server, mock := setupMockServer(t)
+		defer server.db.Close()
+
+		mock.ExpectQuery("FROM processed_files WHERE id = \\$1").
+			WithArgs(int64(999)).
+			WillReturnError(sql.ErrNoRows)
+
+		req := httptest.NewRequest("GET", "/api/v1/files/999", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "999"})
+		w := httptest.NewRecorder()
+
+		server.handleGetFile(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Status code = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		server, _ := setupMockServer(t)
+		defer server.db.Close()
+
+		req := httptest.NewRequest("GET", "/api/v1/files/abc", nil)
+		req = mux.SetURLVars(req, map[string]string{"id": "abc"})
+		w := httptest.NewRecorder()
+
+		server.handleGetFile(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"br, gzip;q=0.8", true},
+		{"GZIP", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"deflate, br", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsGzip(req); got != tt.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// repoFilter narrows the repository aggregates to a subset of repositories
+type repoFilter struct {
+	Language       string `json:"language"`
+	MinStars       int    `json:"min_stars"`
+	DownloadedOnly bool   `json:"downloaded_only"`
+}
+
+// repoFilterParams are the query parameters parseRepoFilter reads
+var repoFilterParams = []string{"language", "min_stars", "downloaded_only"}
+
+// parseRepoFilter reads the language, min_stars and downloaded_only query
+// parameters
+func parseRepoFilter(r *http.Request) (repoFilter, error) {
+	query := r.URL.Query()
+	filter := repoFilter{Language: query.Get("language")}
+
+	if raw := query.Get("min_stars"); raw != "" {
+		minStars, err := strconv.Atoi(raw)
+		if err != nil || minStars < 0 {
+			return filter, fmt.Errorf("invalid min_stars %q", raw)
+		}
+		filter.MinStars = minStars
+	}
+
+	if raw := query.Get("downloaded_only"); raw != "" {
+		downloadedOnly, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid downloaded_only %q", raw)
+		}
+		filter.DownloadedOnly = downloadedOnly
+	}
+
+	return filter, nil
+}
+
+// where returns a WHERE clause combining conditions with the filter, and the
+// arguments it binds. It is empty when there is nothing to filter on.
+func (f repoFilter) where(conditions ...string) (string, []interface{}) {
+	var args []interface{}
+	if f.Language != "" {
+		args = append(args, f.Language)
+		conditions = append(conditions, fmt.Sprintf("language = $%d", len(args)))
+	}
+	if f.MinStars > 0 {
+		args = append(args, f.MinStars)
+		conditions = append(conditions, fmt.Sprintf("stars >= $%d", len(args)))
+	}
+	if f.DownloadedOnly {
+		conditions = append(conditions, "download_status = 'downloaded'")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -0,0 +1,364 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// funnelTimeout bounds all of a funnel request's queries together
+const funnelTimeout = 10 * time.Second
+
+// funnelTopN is how many filter reasons and failure classes are listed
+const funnelTopN = 5
+
+// maxFunnelLanguages caps the languages Elasticsearch buckets the index by
+const maxFunnelLanguages = 200
+
+// FunnelStages counts repositories at each pipeline stage, from crawled
+// into Elasticsearch to files written by the processors. A count is null
+// when the query behind it failed.
+type FunnelStages struct {
+	Indexed        *int64 `json:"indexed"`
+	InPostgres     *int64 `json:"in_postgres"`
+	Filtered       *int64 `json:"filtered"`
+	Downloaded     *int64 `json:"downloaded"`
+	Failed         *int64 `json:"failed"`
+	JobsCompleted  *int64 `json:"jobs_completed"`
+	FilesProcessed *int64 `json:"files_processed"`
+}
+
+// fields returns the stage counts in funnel order
+func (f *FunnelStages) fields() []**int64 {
+	return []**int64{&f.Indexed, &f.InPostgres, &f.Filtered, &f.Downloaded,
+		&f.Failed, &f.JobsCompleted, &f.FilesProcessed}
+}
+
+// merge copies the counts set in src
+func (f *FunnelStages) merge(src *FunnelStages) {
+	dst := f.fields()
+	for i, count := range src.fields() {
+		if *count != nil {
+			*dst[i] = *count
+		}
+	}
+}
+
+// FunnelReason counts the repositories sharing a filter reason or a class
+// of download error
+type FunnelReason struct {
+	Reason string `json:"reason"`
+	Count  int64  `json:"count"`
+}
+
+// FunnelError reports a funnel query that failed
+type FunnelError struct {
+	Source string `json:"source"`
+	Error  string `json:"error"`
+}
+
+// PipelineFunnel shows where repositories fall out of the pipeline
+type PipelineFunnel struct {
+	FunnelStages
+	TopFilterReasons  []FunnelReason `json:"top_filter_reasons"`
+	TopFailureClasses []FunnelReason `json:"top_failure_classes"`
+	// ByLanguage is keyed by repository language, except files_processed,
+	// which is by the language of each file
+	ByLanguage map[string]*FunnelStages `json:"by_language,omitempty"`
+	Errors     []FunnelError            `json:"errors"`
+}
+
+// funnelPart is what one funnel query found
+type funnelPart struct {
+	totals    FunnelStages
+	languages map[string]*FunnelStages
+	reasons   []FunnelReason
+}
+
+// stageField selects one count of FunnelStages
+type stageField func(*FunnelStages) **int64
+
+// languageOrUnknown groups repositories with no recorded language together
+const languageOrUnknown = `COALESCE(NULLIF(language, ''), 'unknown')`
+
+// filterReasonExpr drops the numbers from filter reasons, so "too few
+// stars (3 < 10)" and "too few stars (5 < 10)" are counted together
+const filterReasonExpr = `COALESCE(NULLIF(split_part(filter_reason, ' (', 1), ''), 'unknown')`
+
+// failureClassExpr sorts the downloader's error messages, which embed the
+// repository name and git's output, into a few classes. The first match
+// wins, so "clone timeout" is a timeout rather than a clone failure.
+const failureClassExpr = `CASE
+		WHEN error_message IS NULL OR error_message = '' THEN 'unknown'
+		WHEN error_message ILIKE '%timeout%' OR error_message ILIKE '%timed out%' THEN 'timeout'
+		WHEN error_message ILIKE '%rate limit%' THEN 'rate_limited'
+		WHEN error_message ILIKE '%not found%' OR error_message ILIKE '%404%' THEN 'not_found'
+		WHEN error_message ILIKE '%could not read Username%' OR error_message ILIKE '%authentication%' THEN 'auth_required'
+		WHEN error_message ILIKE '%no space left%' THEN 'disk_full'
+		WHEN error_message ILIKE '%validation failed%' THEN 'invalid_checkout'
+		WHEN error_message ILIKE 'tarball download failed%' THEN 'tarball_failed'
+		WHEN error_message ILIKE 'git clone failed%' THEN 'clone_failed'
+		ELSE 'other'
+	END`
+
+// handlePipelineFunnel returns repository counts at each pipeline stage,
+// with ?by=language adding a per-language breakdown. The queries run
+// concurrently under one timeout; one that fails leaves its counts null and
+// is reported in errors rather than failing the request, so the funnel
+// still shows Postgres while Elasticsearch is down.
+func (s *Server) handlePipelineFunnel(w http.ResponseWriter, r *http.Request) {
+	by := r.URL.Query().Get("by")
+	if by != "" && by != "language" {
+		http.Error(w, fmt.Sprintf("Invalid by %q: only language is supported", by), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), funnelTimeout)
+	defer cancel()
+
+	writeJSON(w, r, s.pipelineFunnel(ctx, by == "language"))
+}
+
+// pipelineFunnel runs the funnel queries and assembles their results
+func (s *Server) pipelineFunnel(ctx context.Context, byLanguage bool) PipelineFunnel {
+	inPostgres := func(f *FunnelStages) **int64 { return &f.InPostgres }
+	filtered := func(f *FunnelStages) **int64 { return &f.Filtered }
+	downloaded := func(f *FunnelStages) **int64 { return &f.Downloaded }
+	failed := func(f *FunnelStages) **int64 { return &f.Failed }
+	jobsCompleted := func(f *FunnelStages) **int64 { return &f.JobsCompleted }
+	filesProcessed := func(f *FunnelStages) **int64 { return &f.FilesProcessed }
+
+	queries := []struct {
+		source string
+		run    func() (funnelPart, error)
+	}{
+		{"elasticsearch", func() (funnelPart, error) {
+			return s.funnelIndexed(ctx, byLanguage)
+		}},
+		{"repositories", func() (funnelPart, error) {
+			return s.funnelCounts(ctx, byLanguage, `
+				COUNT(*),
+				COUNT(*) FILTER (WHERE download_status = 'filtered'),
+				COUNT(*) FILTER (WHERE download_status = 'downloaded'),
+				COUNT(*) FILTER (WHERE download_status = 'failed')
+				FROM repositories`, languageOrUnknown,
+				inPostgres, filtered, downloaded, failed)
+		}},
+		{"filter_reasons", func() (funnelPart, error) {
+			return s.funnelReasons(ctx, filterReasonExpr, "filtered")
+		}},
+		{"failure_classes", func() (funnelPart, error) {
+			return s.funnelReasons(ctx, failureClassExpr, "failed")
+		}},
+		{"processing_jobs", func() (funnelPart, error) {
+			return s.funnelCounts(ctx, byLanguage, `
+				COUNT(*) FROM processing_jobs j WHERE j.status = 'completed'`,
+				`COALESCE((SELECT NULLIF(r.language, '') FROM repositories r WHERE r.local_path = j.repo_path LIMIT 1), 'unknown')`,
+				jobsCompleted)
+		}},
+		{"processed_files", func() (funnelPart, error) {
+			return s.funnelCounts(ctx, byLanguage, `
+				COUNT(*) FROM processed_files WHERE removed_at IS NULL`, "language",
+				filesProcessed)
+		}},
+	}
+
+	parts := make([]funnelPart, len(queries))
+	errs := make([]error, len(queries))
+	var wg sync.WaitGroup
+	for i, q := range queries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			parts[i], errs[i] = q.run()
+		}()
+	}
+	wg.Wait()
+
+	funnel := PipelineFunnel{Errors: []FunnelError{}}
+	if byLanguage {
+		funnel.ByLanguage = make(map[string]*FunnelStages)
+	}
+	for i, part := range parts {
+		if errs[i] != nil {
+			log.Printf("Pipeline funnel: %s query failed: %v", queries[i].source, errs[i])
+			funnel.Errors = append(funnel.Errors, FunnelError{Source: queries[i].source, Error: errs[i].Error()})
+			continue
+		}
+		funnel.merge(&part.totals)
+		for language, counts := range part.languages {
+			if funnel.ByLanguage[language] == nil {
+				funnel.ByLanguage[language] = &FunnelStages{}
+			}
+			funnel.ByLanguage[language].merge(counts)
+		}
+		switch queries[i].source {
+		case "filter_reasons":
+			funnel.TopFilterReasons = part.reasons
+		case "failure_classes":
+			funnel.TopFailureClasses = part.reasons
+		}
+	}
+
+	// A language missing from a query that succeeded has none at that stage
+	zero := int64(0)
+	for _, counts := range funnel.ByLanguage {
+		fields := counts.fields()
+		for i, total := range funnel.fields() {
+			if *total != nil && *fields[i] == nil {
+				*fields[i] = &zero
+			}
+		}
+	}
+	return funnel
+}
+
+// funnelCounts runs "SELECT <counts>" and sets one field per count column,
+// grouping by langExpr to fill the per-language counts when byLanguage is
+// set. counts must end in its FROM and WHERE clauses.
+func (s *Server) funnelCounts(ctx context.Context, byLanguage bool, counts, langExpr string, fields ...stageField) (funnelPart, error) {
+	query := "SELECT '' AS lang," + counts
+	if byLanguage {
+		query = "SELECT " + langExpr + " AS lang," + counts + " GROUP BY lang"
+	}
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return funnelPart{}, err
+	}
+	defer rows.Close()
+
+	part := funnelPart{languages: make(map[string]*FunnelStages)}
+	totals := make([]int64, len(fields))
+	for rows.Next() {
+		var language string
+		values := make([]int64, len(fields))
+		dest := []interface{}{&language}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return funnelPart{}, err
+		}
+
+		for i := range values {
+			totals[i] += values[i]
+		}
+		if byLanguage {
+			counts := &FunnelStages{}
+			for i, field := range fields {
+				*field(counts) = &values[i]
+			}
+			part.languages[language] = counts
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return funnelPart{}, err
+	}
+
+	for i, field := range fields {
+		*field(&part.totals) = &totals[i]
+	}
+	return part, nil
+}
+
+// funnelReasons returns the most common values of reasonExpr among
+// repositories with the given download status
+func (s *Server) funnelReasons(ctx context.Context, reasonExpr, status string) (funnelPart, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+reasonExpr+` AS reason, COUNT(*) AS count
+		FROM repositories
+		WHERE download_status = $1
+		GROUP BY reason
+		ORDER BY count DESC, reason
+		LIMIT $2
+	`, status, funnelTopN)
+	if err != nil {
+		return funnelPart{}, err
+	}
+	defer rows.Close()
+
+	part := funnelPart{reasons: []FunnelReason{}}
+	for rows.Next() {
+		var reason FunnelReason
+		if err := rows.Scan(&reason.Reason, &reason.Count); err != nil {
+			return funnelPart{}, err
+		}
+		part.reasons = append(part.reasons, reason)
+	}
+	return part, rows.Err()
+}
+
+// funnelIndexed counts the repositories in the search index, bucketed by
+// language when byLanguage is set
+func (s *Server) funnelIndexed(ctx context.Context, byLanguage bool) (funnelPart, error) {
+	if s.esClient == nil {
+		return funnelPart{}, errors.New("Elasticsearch is not configured")
+	}
+
+	query := map[string]interface{}{
+		"size":             0,
+		"track_total_hits": true,
+	}
+	if byLanguage {
+		query["aggs"] = map[string]interface{}{
+			"languages": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field":   "language",
+					"missing": "unknown",
+					"size":    maxFunnelLanguages,
+				},
+			},
+		}
+	}
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(query); err != nil {
+		return funnelPart{}, err
+	}
+
+	res, err := s.esClient.Search(
+		s.esClient.Search.WithContext(ctx),
+		s.esClient.Search.WithIndex(searchIndex),
+		s.esClient.Search.WithBody(&body),
+	)
+	if err != nil {
+		return funnelPart{}, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return funnelPart{}, fmt.Errorf("search error: %s", res.String())
+	}
+
+	var response struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+		} `json:"hits"`
+		Aggregations struct {
+			Languages struct {
+				Buckets []struct {
+					Key      string `json:"key"`
+					DocCount int64  `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"languages"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return funnelPart{}, fmt.Errorf("failed to decode search response: %w", err)
+	}
+
+	part := funnelPart{languages: make(map[string]*FunnelStages)}
+	part.totals.Indexed = &response.Hits.Total.Value
+	for _, bucket := range response.Aggregations.Languages.Buckets {
+		count := bucket.DocCount
+		part.languages[bucket.Key] = &FunnelStages{Indexed: &count}
+	}
+	return part, nil
+}
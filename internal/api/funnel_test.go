@@ -0,0 +1,197 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectFunnelQueries sets up the Postgres side of the funnel. The queries
+// run concurrently, so mock must not match them in order.
+func expectFunnelQueries(mock sqlmock.Sqlmock, byLanguage bool) {
+	mock.MatchExpectationsInOrder(false)
+
+	repos := sqlmock.NewRows([]string{"lang", "count", "filtered", "downloaded", "failed"})
+	jobs := sqlmock.NewRows([]string{"lang", "count"})
+	files := sqlmock.NewRows([]string{"lang", "count"})
+	if byLanguage {
+		repos.AddRow("Go", 60, 10, 40, 5).AddRow("Rust", 40, 20, 15, 2)
+		jobs.AddRow("Go", 35) // no Rust job has completed
+		files.AddRow("Go", 900).AddRow("YAML", 100)
+	} else {
+		repos.AddRow("", 100, 30, 55, 7)
+		jobs.AddRow("", 35)
+		files.AddRow("", 1000)
+	}
+
+	mock.ExpectQuery("FILTER \\(WHERE download_status = 'filtered'\\).*FROM repositories").
+		WillReturnRows(repos)
+	mock.ExpectQuery("split_part\\(filter_reason.*WHERE download_status = \\$1").
+		WithArgs("filtered", funnelTopN).
+		WillReturnRows(sqlmock.NewRows([]string{"reason", "count"}).
+			AddRow("too few stars", 25).AddRow("license GPL-3.0-only is blocked", 5))
+	mock.ExpectQuery("error_message ILIKE.*WHERE download_status = \\$1").
+		WithArgs("failed", funnelTopN).
+		WillReturnRows(sqlmock.NewRows([]string{"reason", "count"}).
+			AddRow("timeout", 4).AddRow("not_found", 3))
+	mock.ExpectQuery("FROM processing_jobs j WHERE j.status = 'completed'").
+		WillReturnRows(jobs)
+	mock.ExpectQuery("FROM processed_files WHERE removed_at IS NULL").
+		WillReturnRows(files)
+}
+
+func getFunnel(t *testing.T, server *Server, query string) PipelineFunnel {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/api/v1/pipeline/funnel"+query, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var funnel PipelineFunnel
+	if err := json.NewDecoder(w.Body).Decode(&funnel); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return funnel
+}
+
+func count(n *int64) interface{} {
+	if n == nil {
+		return nil
+	}
+	return *n
+}
+
+func TestHandlePipelineFunnel(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	server.esClient, _ = newFakeElasticsearch(t, http.StatusOK, `{"hits": {"total": {"value": 120, "relation": "eq"}, "hits": []}}`)
+	expectFunnelQueries(mock, false)
+
+	funnel := getFunnel(t, server, "")
+
+	want := map[string]int64{"indexed": 120, "in_postgres": 100, "filtered": 30, "downloaded": 55,
+		"failed": 7, "jobs_completed": 35, "files_processed": 1000}
+	got := map[string]interface{}{
+		"indexed": count(funnel.Indexed), "in_postgres": count(funnel.InPostgres),
+		"filtered": count(funnel.Filtered), "downloaded": count(funnel.Downloaded),
+		"failed": count(funnel.Failed), "jobs_completed": count(funnel.JobsCompleted),
+		"files_processed": count(funnel.FilesProcessed),
+	}
+	for stage, n := range want {
+		if got[stage] != n {
+			t.Errorf("%s = %v, want %d", stage, got[stage], n)
+		}
+	}
+	if len(funnel.TopFilterReasons) != 2 || funnel.TopFilterReasons[0] != (FunnelReason{"too few stars", 25}) {
+		t.Errorf("top_filter_reasons = %+v", funnel.TopFilterReasons)
+	}
+	if len(funnel.TopFailureClasses) != 2 || funnel.TopFailureClasses[1] != (FunnelReason{"not_found", 3}) {
+		t.Errorf("top_failure_classes = %+v", funnel.TopFailureClasses)
+	}
+	if len(funnel.Errors) != 0 || funnel.ByLanguage != nil {
+		t.Errorf("errors = %+v, by_language = %+v; want neither", funnel.Errors, funnel.ByLanguage)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandlePipelineFunnel_ByLanguage(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	var query *map[string]interface{}
+	server.esClient, query = newFakeElasticsearch(t, http.StatusOK, `{
+		"hits": {"total": {"value": 130, "relation": "eq"}, "hits": []},
+		"aggregations": {"languages": {"buckets": [{"key": "Go", "doc_count": 70}, {"key": "Rust", "doc_count": 60}]}}
+	}`)
+	expectFunnelQueries(mock, true)
+
+	funnel := getFunnel(t, server, "?by=language")
+
+	if _, ok := (*query)["aggs"]; !ok {
+		t.Errorf("search = %v, want a language aggregation", *query)
+	}
+	if count(funnel.InPostgres) != int64(100) || count(funnel.JobsCompleted) != int64(35) || count(funnel.Indexed) != int64(130) {
+		t.Errorf("totals = %+v", funnel.FunnelStages)
+	}
+
+	rust := funnel.ByLanguage["Rust"]
+	if rust == nil {
+		t.Fatalf("by_language = %+v, want Rust", funnel.ByLanguage)
+	}
+	if count(rust.Indexed) != int64(60) || count(rust.Filtered) != int64(20) {
+		t.Errorf("Rust = indexed %v, filtered %v; want 60, 20", count(rust.Indexed), count(rust.Filtered))
+	}
+	// Counted as zero, not null, since the queries succeeded
+	if count(rust.JobsCompleted) != int64(0) || count(rust.FilesProcessed) != int64(0) {
+		t.Errorf("Rust = jobs %v, files %v; want 0, 0", count(rust.JobsCompleted), count(rust.FilesProcessed))
+	}
+	if yaml := funnel.ByLanguage["YAML"]; yaml == nil || count(yaml.FilesProcessed) != int64(100) || count(yaml.InPostgres) != int64(0) {
+		t.Errorf("YAML = %+v, want 100 files from no repositories", yaml)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandlePipelineFunnel_ElasticsearchDown(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	server.esClient, _ = newFakeElasticsearch(t, http.StatusServiceUnavailable, `{"error": "cluster unavailable"}`)
+	expectFunnelQueries(mock, true)
+
+	funnel := getFunnel(t, server, "?by=language")
+
+	if funnel.Indexed != nil {
+		t.Errorf("indexed = %d, want null", *funnel.Indexed)
+	}
+	if len(funnel.Errors) != 1 || funnel.Errors[0].Source != "elasticsearch" {
+		t.Errorf("errors = %+v, want one from elasticsearch", funnel.Errors)
+	}
+	if count(funnel.Downloaded) != int64(55) {
+		t.Errorf("downloaded = %v, want 55 from Postgres", count(funnel.Downloaded))
+	}
+	if goStages := funnel.ByLanguage["Go"]; goStages == nil || goStages.Indexed != nil || count(goStages.Downloaded) != int64(40) {
+		t.Errorf("Go = %+v, want indexed null and 40 downloaded", goStages)
+	}
+}
+
+func TestHandlePipelineFunnel_PostgresError(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	mock.MatchExpectationsInOrder(false)
+	mock.ExpectQuery("FROM repositories").WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectQuery("WHERE download_status = \\$1").WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectQuery("WHERE download_status = \\$1").WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectQuery("FROM processing_jobs").WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectQuery("FROM processed_files").
+		WillReturnRows(sqlmock.NewRows([]string{"lang", "count"}).AddRow("", 1000))
+
+	funnel := getFunnel(t, server, "")
+
+	// Elasticsearch isn't configured in the mock server
+	if len(funnel.Errors) != 5 {
+		t.Errorf("errors = %+v, want five", funnel.Errors)
+	}
+	if funnel.InPostgres != nil || funnel.TopFilterReasons != nil || count(funnel.FilesProcessed) != int64(1000) {
+		t.Errorf("funnel = %+v, want only files_processed", funnel)
+	}
+}
+
+func TestHandlePipelineFunnel_BadRequest(t *testing.T) {
+	server, _ := setupMockServer(t)
+	defer server.db.Close()
+
+	req := httptest.NewRequest("GET", "/api/v1/pipeline/funnel?by=owner", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,100 @@
+//go:build integration
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"codelupe/internal/testenv"
+	"codelupe/pkg/contentstore"
+
+	"github.com/gorilla/mux"
+)
+
+// TestIntegration_EndpointsMatchPipeline is the last stage of the pipeline
+// test: after the crawler, downloader and processor stages have run over
+// the fixtures, the endpoints must agree on how far each repo got.
+func TestIntegration_EndpointsMatchPipeline(t *testing.T) {
+	content, err := contentstore.New(contentstore.ModeInline, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{
+		router:   mux.NewRouter(),
+		db:       testenv.Postgres(t),
+		esClient: testenv.Elasticsearch(t),
+		content:  content,
+	}
+	server.setupRoutes()
+
+	get := func(path string, v interface{}) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s = %d: %s", path, w.Code, w.Body.String())
+		}
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	var funnel PipelineFunnel
+	get("/api/v1/pipeline/funnel?by=language", &funnel)
+	if len(funnel.Errors) > 0 {
+		t.Fatalf("funnel queries failed: %+v", funnel.Errors)
+	}
+	want := map[string]int64{
+		"indexed": 4, "in_postgres": 4, "filtered": 1, "downloaded": 3,
+		"failed": 0, "jobs_completed": 3, "files_processed": 9,
+	}
+	got := map[string]*int64{
+		"indexed": funnel.Indexed, "in_postgres": funnel.InPostgres, "filtered": funnel.Filtered,
+		"downloaded": funnel.Downloaded, "failed": funnel.Failed,
+		"jobs_completed": funnel.JobsCompleted, "files_processed": funnel.FilesProcessed,
+	}
+	for stage, n := range want {
+		if got[stage] == nil || *got[stage] != n {
+			t.Errorf("funnel %s = %v, want %d", stage, got[stage], n)
+		}
+	}
+	if len(funnel.TopFilterReasons) != 1 || funnel.TopFilterReasons[0].Reason != "too few stars" {
+		t.Errorf("top filter reasons = %+v, want too few stars", funnel.TopFilterReasons)
+	}
+	for _, language := range []string{"Go", "Python", "Rust"} {
+		stages := funnel.ByLanguage[language]
+		if stages == nil || stages.FilesProcessed == nil || *stages.FilesProcessed != 3 {
+			t.Errorf("funnel files processed for %s = %+v, want 3", language, stages)
+		}
+	}
+
+	// The other endpoints count the same tables their own way
+	var stats struct {
+		Total      int64 `json:"total"`
+		Downloaded int64 `json:"downloaded"`
+	}
+	get("/api/v1/repositories/stats", &stats)
+	if stats.Total != *funnel.InPostgres || stats.Downloaded != *funnel.Downloaded {
+		t.Errorf("repository stats = %+v, funnel has %d in Postgres and %d downloaded",
+			stats, *funnel.InPostgres, *funnel.Downloaded)
+	}
+
+	var jobs JobSummary
+	get("/api/v1/jobs/summary", &jobs)
+	if jobs.StatusCounts["completed"] != *funnel.JobsCompleted || jobs.FilesLast24h != *funnel.FilesProcessed {
+		t.Errorf("job summary = %d completed and %d files, funnel has %d and %d",
+			jobs.StatusCounts["completed"], jobs.FilesLast24h, *funnel.JobsCompleted, *funnel.FilesProcessed)
+	}
+
+	var files struct {
+		Count int64 `json:"count"`
+	}
+	get("/api/v1/files?limit=100", &files)
+	if files.Count != *funnel.FilesProcessed {
+		t.Errorf("files list has %d files, funnel has %d", files.Count, *funnel.FilesProcessed)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// jobStatuses are the statuses the processors give processing_jobs rows
+var jobStatuses = map[string]bool{
+	"pending":    true,
+	"processing": true,
+	"completed":  true,
+	"failed":     true,
+	"skipped":    true,
+}
+
+// Job is a processing_jobs row: one repository queued for the processors
+type Job struct {
+	ID             int64      `json:"id"`
+	RepoPath       string     `json:"repo_path"`
+	Status         string     `json:"status"`
+	FilesFound     int        `json:"files_found"`
+	FilesProcessed int        `json:"files_processed"`
+	Attempts       int        `json:"attempts"`
+	WorkerID       string     `json:"worker_id,omitempty"`
+	ErrorMsg       string     `json:"error_msg,omitempty"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	HeartbeatAt    *time.Time `json:"heartbeat_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	// ProcessedFileCount counts the job's processed_files rows; only set
+	// when fetching a single job
+	ProcessedFileCount *int64 `json:"processed_file_count,omitempty"`
+}
+
+// jobColumns are the processing_jobs columns scanned by scanJob
+const jobColumns = `id, repo_path, status, COALESCE(files_found, 0), COALESCE(files_processed, 0),
+	attempts, worker_id, error_msg, started_at, completed_at, heartbeat_at, created_at, updated_at`
+
+// scanJob scans a row selected with jobColumns
+func scanJob(row interface{ Scan(...interface{}) error }) (Job, error) {
+	var job Job
+	var workerID, errorMsg sql.NullString
+	var startedAt, completedAt, heartbeatAt sql.NullTime
+	err := row.Scan(
+		&job.ID, &job.RepoPath, &job.Status, &job.FilesFound, &job.FilesProcessed,
+		&job.Attempts, &workerID, &errorMsg, &startedAt, &completedAt, &heartbeatAt,
+		&job.CreatedAt, &job.UpdatedAt,
+	)
+	if err != nil {
+		return job, err
+	}
+
+	job.WorkerID = workerID.String
+	job.ErrorMsg = errorMsg.String
+	if startedAt.Valid {
+		job.StartedAt = &startedAt.Time
+	}
+	if completedAt.Valid {
+		job.CompletedAt = &completedAt.Time
+	}
+	if heartbeatAt.Valid {
+		job.HeartbeatAt = &heartbeatAt.Time
+	}
+	return job, nil
+}
+
+// handleListJobs returns a page of processing jobs, newest first, optionally
+// filtered by status and worker
+func (s *Server) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !jobStatuses[status] {
+		http.Error(w, fmt.Sprintf("Invalid status %q", status), http.StatusBadRequest)
+		return
+	}
+	worker := r.URL.Query().Get("worker")
+
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	where := " WHERE 1=1"
+	var args []interface{}
+	if status != "" {
+		args = append(args, status)
+		where += fmt.Sprintf(" AND status = $%d", len(args))
+	}
+	if worker != "" {
+		args = append(args, worker)
+		where += fmt.Sprintf(" AND worker_id = $%d", len(args))
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM processing_jobs"+where, args...).Scan(&total); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	query := "SELECT " + jobColumns + " FROM processing_jobs" + where +
+		fmt.Sprintf(" ORDER BY id DESC LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	rows, err := s.db.Query(query, append(args, limit, (page-1)*limit)...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	jobs := []Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	writeJSON(w, r, map[string]interface{}{
+		"data":  jobs,
+		"page":  page,
+		"limit": limit,
+		"total": total,
+	})
+}
+
+// handleGetJob returns a job with the number of files it has processed
+func (s *Server) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := scanJob(s.db.QueryRow("SELECT "+jobColumns+" FROM processing_jobs WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var count int64
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM processed_files WHERE job_id = $1", id).Scan(&count); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	job.ProcessedFileCount = &count
+
+	writeJSON(w, r, job)
+}
+
+// HourlyFiles counts files processed in one hour
+type HourlyFiles struct {
+	Hour  time.Time `json:"hour"`
+	Files int64     `json:"files"`
+}
+
+// JobSummary is an overview of the processing pipeline
+type JobSummary struct {
+	StatusCounts  map[string]int64 `json:"status_counts"`
+	FilesLast24h  int64            `json:"files_last_24h"`
+	FilesPerHour  float64          `json:"files_per_hour"`
+	HourlyFiles   []HourlyFiles    `json:"hourly_files"`
+	ActiveWorkers []string         `json:"active_workers"`
+}
+
+// handleJobSummary returns job counts per status, processing throughput over
+// the last 24 hours and the workers currently holding jobs
+func (s *Server) handleJobSummary(w http.ResponseWriter, r *http.Request) {
+	summary := JobSummary{
+		StatusCounts:  map[string]int64{},
+		HourlyFiles:   []HourlyFiles{},
+		ActiveWorkers: []string{},
+	}
+
+	rows, err := s.db.Query(`SELECT status, COUNT(*) FROM processing_jobs GROUP BY status`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var status string
+		var count int64
+		if err := rows.Scan(&status, &count); err == nil {
+			summary.StatusCounts[status] = count
+		}
+	}
+	rows.Close()
+
+	rows, err = s.db.Query(`
+		SELECT DATE_TRUNC('hour', processed_at) as hour, COUNT(*)
+		FROM processed_files
+		WHERE processed_at >= NOW() - INTERVAL '24 hours'
+		GROUP BY hour
+		ORDER BY hour
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var hourly HourlyFiles
+		if err := rows.Scan(&hourly.Hour, &hourly.Files); err == nil {
+			summary.HourlyFiles = append(summary.HourlyFiles, hourly)
+			summary.FilesLast24h += hourly.Files
+		}
+	}
+	rows.Close()
+	summary.FilesPerHour = float64(summary.FilesLast24h) / 24
+
+	rows, err = s.db.Query(`
+		SELECT DISTINCT worker_id
+		FROM processing_jobs
+		WHERE status = 'processing' AND worker_id IS NOT NULL
+		ORDER BY worker_id
+	`)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for rows.Next() {
+		var worker string
+		if err := rows.Scan(&worker); err == nil {
+			summary.ActiveWorkers = append(summary.ActiveWorkers, worker)
+		}
+	}
+	rows.Close()
+
+	writeJSON(w, r, summary)
+}
+
+// handleRetryJob resets a failed job to pending so a worker picks it up
+// again. Attempts are cleared too, otherwise a job that failed by running
+// out of attempts would never be claimed.
+func (s *Server) handleRetryJob(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job id", http.StatusBadRequest)
+		return
+	}
+
+	job, err := scanJob(s.db.QueryRow(`
+		UPDATE processing_jobs
+		SET status = 'pending', worker_id = NULL, error_msg = NULL, heartbeat_at = NULL,
+		    attempts = 0, updated_at = NOW()
+		WHERE id = $1 AND status = 'failed'
+		RETURNING `+jobColumns, id))
+	if err == nil {
+		writeJSON(w, r, job)
+		return
+	}
+	if err != sql.ErrNoRows {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Nothing updated: either there is no such job or it hasn't failed
+	var status string
+	err = s.db.QueryRow("SELECT status FROM processing_jobs WHERE id = $1", id).Scan(&status)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, fmt.Sprintf("Only failed jobs can be retried; job %d is %s", id, status), http.StatusConflict)
+}
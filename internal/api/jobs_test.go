@@ -0,0 +1,232 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+var jobColumnNames = []string{
+	"id", "repo_path", "status", "files_found", "files_processed", "attempts", "worker_id",
+	"error_msg", "started_at", "completed_at", "heartbeat_at", "created_at", "updated_at",
+}
+
+func TestHandleListJobs(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM processing_jobs WHERE 1=1 AND status = \\$1 AND worker_id = \\$2").
+		WithArgs("failed", "worker-1").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+	mock.ExpectQuery("FROM processing_jobs WHERE 1=1 AND status = \\$1 AND worker_id = \\$2 ORDER BY id DESC LIMIT \\$3 OFFSET \\$4").
+		WithArgs("failed", "worker-1", 5, 5).
+		WillReturnRows(sqlmock.NewRows(jobColumnNames).
+			AddRow(7, "/repos/rust", "failed", 10, 3, 1, "worker-1", "clone failed", now, nil, now, now, now))
+
+	req := httptest.NewRequest("GET", "/api/v1/jobs?status=failed&worker=worker-1&page=2&limit=5", nil)
+	w := httptest.NewRecorder()
+
+	server.handleListJobs(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response struct {
+		Data  []Job `json:"data"`
+		Total int   `json:"total"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Total != 12 || len(response.Data) != 1 {
+		t.Fatalf("total = %d, len(data) = %d, want 12 and 1", response.Total, len(response.Data))
+	}
+	job := response.Data[0]
+	if job.ErrorMsg != "clone failed" || job.WorkerID != "worker-1" || job.CompletedAt != nil {
+		t.Errorf("job = %+v", job)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleListJobs_InvalidStatus(t *testing.T) {
+	server, _ := setupMockServer(t)
+	defer server.db.Close()
+
+	req := httptest.NewRequest("GET", "/api/v1/jobs?status=done", nil)
+	w := httptest.NewRecorder()
+
+	server.handleListJobs(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleGetJob(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("FROM processing_jobs WHERE id = \\$1").
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows(jobColumnNames).
+			AddRow(7, "/repos/rust", "completed", 10, 10, 0, nil, nil, now, now, nil, now, now))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM processed_files WHERE job_id = \\$1").
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(9))
+
+	req := httptest.NewRequest("GET", "/api/v1/jobs/7", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "7"})
+	w := httptest.NewRecorder()
+
+	server.handleGetJob(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var job Job
+	if err := json.NewDecoder(w.Body).Decode(&job); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if job.ProcessedFileCount == nil || *job.ProcessedFileCount != 9 {
+		t.Errorf("processed_file_count = %v, want 9", job.ProcessedFileCount)
+	}
+	if job.CompletedAt == nil || job.WorkerID != "" {
+		t.Errorf("job = %+v", job)
+	}
+}
+
+func TestHandleGetJob_NotFound(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("FROM processing_jobs WHERE id = \\$1").
+		WithArgs(int64(999)).
+		WillReturnError(sql.ErrNoRows)
+
+	req := httptest.NewRequest("GET", "/api/v1/jobs/999", nil)
+	req = mux.SetURLVars(req, map[string]string{"id": "999"})
+	w := httptest.NewRecorder()
+
+	server.handleGetJob(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandleJobSummary(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	hour := time.Now().Truncate(time.Hour)
+	mock.ExpectQuery("SELECT status, COUNT\\(\\*\\) FROM processing_jobs GROUP BY status").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+			AddRow("completed", 90).
+			AddRow("processing", 3).
+			AddRow("failed", 7))
+	mock.ExpectQuery("FROM processed_files\\s+WHERE processed_at >= NOW\\(\\) - INTERVAL '24 hours'").
+		WillReturnRows(sqlmock.NewRows([]string{"hour", "count"}).
+			AddRow(hour.Add(-time.Hour), 1000).
+			AddRow(hour, 200))
+	mock.ExpectQuery("SELECT DISTINCT worker_id").
+		WillReturnRows(sqlmock.NewRows([]string{"worker_id"}).
+			AddRow("worker-1").
+			AddRow("worker-2"))
+
+	// Through the router, so "summary" isn't taken for a job id
+	req := httptest.NewRequest("GET", "/api/v1/jobs/summary", nil)
+	w := httptest.NewRecorder()
+
+	server.router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var summary JobSummary
+	if err := json.NewDecoder(w.Body).Decode(&summary); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if summary.StatusCounts["completed"] != 90 || summary.StatusCounts["failed"] != 7 {
+		t.Errorf("status_counts = %v", summary.StatusCounts)
+	}
+	if summary.FilesLast24h != 1200 || summary.FilesPerHour != 50 || len(summary.HourlyFiles) != 2 {
+		t.Errorf("files_last_24h = %d, files_per_hour = %v, hourly = %v",
+			summary.FilesLast24h, summary.FilesPerHour, summary.HourlyFiles)
+	}
+	if len(summary.ActiveWorkers) != 2 || summary.ActiveWorkers[0] != "worker-1" {
+		t.Errorf("active_workers = %v", summary.ActiveWorkers)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleRetryJob(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		status string // current status; "" means the job doesn't exist
+		want   int
+	}{
+		{"failed job is reset", "failed", http.StatusOK},
+		{"completed job is refused", "completed", http.StatusConflict},
+		{"missing job", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupMockServer(t)
+			defer server.db.Close()
+
+			update := mock.ExpectQuery("UPDATE processing_jobs\\s+SET status = 'pending'.*attempts = 0.*WHERE id = \\$1 AND status = 'failed'").
+				WithArgs(int64(7))
+			if tt.status == "failed" {
+				update.WillReturnRows(sqlmock.NewRows(jobColumnNames).
+					AddRow(7, "/repos/rust", "pending", 10, 3, 0, nil, nil, now, nil, nil, now, now))
+			} else {
+				update.WillReturnError(sql.ErrNoRows)
+				lookup := mock.ExpectQuery("SELECT status FROM processing_jobs WHERE id = \\$1").WithArgs(int64(7))
+				if tt.status == "" {
+					lookup.WillReturnError(sql.ErrNoRows)
+				} else {
+					lookup.WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.status))
+				}
+			}
+
+			req := httptest.NewRequest("POST", "/api/v1/jobs/7/retry", nil)
+			req = mux.SetURLVars(req, map[string]string{"id": "7"})
+			w := httptest.NewRecorder()
+
+			server.handleRetryJob(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("Status code = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want == http.StatusOK {
+				var job Job
+				json.NewDecoder(w.Body).Decode(&job)
+				if job.Status != "pending" || job.Attempts != 0 {
+					t.Errorf("job = %+v, want pending with no attempts", job)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
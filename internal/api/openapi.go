@@ -0,0 +1,142 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec is the served OpenAPI document: the embedded spec plus the
+// operations routes attach with document. It is rendered once, on first
+// request, so every route must be documented from setupRoutes.
+type openAPISpec struct {
+	base       []byte
+	operations []specOperation
+
+	once      sync.Once
+	yamlDoc   []byte
+	jsonDoc   []byte
+	renderErr error
+}
+
+// specOperation is an OpenAPI operation object, in YAML, for one route
+type specOperation struct {
+	path     string
+	method   string
+	fragment string
+}
+
+func newOpenAPISpec(base []byte) *openAPISpec {
+	return &openAPISpec{base: base}
+}
+
+// document attaches the operation for method on path. fragment is the YAML
+// operation object, as it would appear under paths.<path>.<method>.
+func (s *openAPISpec) document(path, method, fragment string) {
+	s.operations = append(s.operations, specOperation{
+		path:     path,
+		method:   strings.ToLower(method),
+		fragment: fragment,
+	})
+}
+
+// render returns the spec as YAML and as JSON
+func (s *openAPISpec) render() ([]byte, []byte, error) {
+	s.once.Do(func() {
+		s.yamlDoc, s.jsonDoc, s.renderErr = s.build()
+	})
+	return s.yamlDoc, s.jsonDoc, s.renderErr
+}
+
+func (s *openAPISpec) build() ([]byte, []byte, error) {
+	// Work on the node tree so the YAML keeps the order it was written in
+	var doc yaml.Node
+	if err := yaml.Unmarshal(s.base, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil, nil, fmt.Errorf("OpenAPI spec is empty")
+	}
+	paths := mappingValue(doc.Content[0], "paths")
+
+	for _, op := range s.operations {
+		var operation yaml.Node
+		if err := yaml.Unmarshal([]byte(op.fragment), &operation); err != nil {
+			return nil, nil, fmt.Errorf("invalid OpenAPI fragment for %s %s: %w", op.method, op.path, err)
+		}
+		item := mappingValue(paths, op.path)
+		if lookupKey(item, op.method) != nil {
+			return nil, nil, fmt.Errorf("%s %s is documented twice", op.method, op.path)
+		}
+		item.Content = append(item.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: op.method},
+			operation.Content[0])
+	}
+
+	var yamlDoc bytes.Buffer
+	enc := yaml.NewEncoder(&yamlDoc)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, nil, err
+	}
+	var tree interface{}
+	if err := doc.Decode(&tree); err != nil {
+		return nil, nil, err
+	}
+	jsonDoc, err := json.Marshal(tree)
+	if err != nil {
+		return nil, nil, err
+	}
+	return yamlDoc.Bytes(), jsonDoc, nil
+}
+
+// lookupKey returns the value under key in a mapping node, or nil
+func lookupKey(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the mapping under key in mapping, adding an empty
+// one at the end when key is missing
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if value := lookupKey(mapping, key); value != nil {
+		return value
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// handleOpenAPISpec serves the OpenAPI specification as YAML
+func (s *Server) handleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	yamlDoc, _, err := s.spec.render()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(yamlDoc)
+}
+
+// handleOpenAPIJSON serves the OpenAPI specification as JSON
+func (s *Server) handleOpenAPIJSON(w http.ResponseWriter, r *http.Request) {
+	_, jsonDoc, err := s.spec.render()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonDoc)
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+)
+
+func getSpec(t *testing.T, server *Server, path string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", path, nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s status code = %d: %s", path, w.Code, w.Body.String())
+	}
+	return w
+}
+
+// TestOpenAPISpec_DocumentsEveryRoute fails when a route is added in
+// setupRoutes without an operation in api/openapi.yaml or s.spec.document
+func TestOpenAPISpec_DocumentsEveryRoute(t *testing.T) {
+	server, _ := setupMockServer(t)
+	defer server.db.Close()
+	server.config.EnableMetrics = true
+	server.router = mux.NewRouter()
+	server.setupRoutes()
+
+	var spec struct {
+		Paths map[string]map[string]interface{} `json:"paths"`
+	}
+	if err := json.NewDecoder(getSpec(t, server, "/api/openapi.json").Body).Decode(&spec); err != nil {
+		t.Fatalf("Failed to decode spec: %v", err)
+	}
+
+	routes := 0
+	err := server.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouter prefixes have no methods of their own
+			return nil
+		}
+		for _, method := range methods {
+			routes++
+			if _, ok := spec.Paths[path][strings.ToLower(method)]; !ok {
+				t.Errorf("%s %s is not in the OpenAPI spec", method, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+	if routes < 20 {
+		t.Errorf("walked %d routes, expected the full API", routes)
+	}
+}
+
+func TestOpenAPISpec_YAMLAndJSON(t *testing.T) {
+	server, _ := setupMockServer(t)
+	defer server.db.Close()
+
+	yamlResp := getSpec(t, server, "/api/openapi.yaml")
+	if ct := yamlResp.Header().Get("Content-Type"); ct != "application/yaml" {
+		t.Errorf("YAML Content-Type = %q", ct)
+	}
+	if !strings.HasPrefix(yamlResp.Body.String(), "openapi: 3.0.3\n") {
+		t.Errorf("YAML spec should keep its key order, starts with %q", yamlResp.Body.String()[:40])
+	}
+
+	jsonResp := getSpec(t, server, "/api/openapi.json")
+	if ct := jsonResp.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("JSON Content-Type = %q", ct)
+	}
+
+	var fromYAML, fromJSON map[string]interface{}
+	if err := yaml.Unmarshal(yamlResp.Body.Bytes(), &fromYAML); err != nil {
+		t.Fatalf("Failed to parse YAML spec: %v", err)
+	}
+	if err := json.Unmarshal(jsonResp.Body.Bytes(), &fromJSON); err != nil {
+		t.Fatalf("Failed to parse JSON spec: %v", err)
+	}
+	paths := fromJSON["paths"].(map[string]interface{})
+	if len(paths) != len(fromYAML["paths"].(map[string]interface{})) {
+		t.Errorf("YAML and JSON specs have different paths")
+	}
+	if _, ok := paths["/api/openapi.json"]; !ok {
+		t.Error("documented route missing from the JSON spec")
+	}
+}
+
+func TestOpenAPISpec_Document(t *testing.T) {
+	base := []byte("openapi: 3.0.3\npaths:\n  /a:\n    get:\n      summary: A\n")
+
+	spec := newOpenAPISpec(base)
+	spec.document("/a", "POST", "summary: Create A\n")
+	spec.document("/b", "GET", "summary: B\n")
+	yamlDoc, jsonDoc, err := spec.render()
+	if err != nil {
+		t.Fatalf("render() error = %v", err)
+	}
+	want := "openapi: 3.0.3\npaths:\n  /a:\n    get:\n      summary: A\n    post:\n      summary: Create A\n  /b:\n    get:\n      summary: B\n"
+	if string(yamlDoc) != want {
+		t.Errorf("YAML =\n%s\nwant\n%s", yamlDoc, want)
+	}
+	if !strings.Contains(string(jsonDoc), `"/b":{"get":{"summary":"B"}}`) {
+		t.Errorf("JSON = %s", jsonDoc)
+	}
+
+	spec = newOpenAPISpec(base)
+	spec.document("/a", "GET", "summary: Again\n")
+	if _, _, err := spec.render(); err == nil {
+		t.Error("render() should fail when an operation is documented twice")
+	}
+
+	spec = newOpenAPISpec(base)
+	spec.document("/c", "GET", "summary: [unclosed\n")
+	if _, _, err := spec.render(); err == nil {
+		t.Error("render() should fail on an invalid fragment")
+	}
+}
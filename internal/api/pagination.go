@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"codelupe/internal/models"
+)
+
+// Repository list sort orders. Each sorts descending with id as the tie
+// breaker, so (column, id) is unique and can be used as a keyset.
+const (
+	repoSortStars        = "stars"
+	repoSortQualityScore = "quality_score"
+	repoSortCreatedAt    = "created_at"
+)
+
+// repoSortColumns maps each sort order to its repositories column
+var repoSortColumns = map[string]string{
+	repoSortStars:        "stars",
+	repoSortQualityScore: "quality_score",
+	repoSortCreatedAt:    "created_at",
+}
+
+// maxOffsetPage is the deepest page served with OFFSET. Past it Postgres
+// has to walk every skipped row, so clients must follow next_cursor instead.
+const maxOffsetPage = 50
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+// repoCursor marks the last repository of a page. The next page starts
+// after it in the cursor's sort order.
+type repoCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// newRepoCursor returns the cursor positioned after repo
+func newRepoCursor(sort string, repo models.RepositoryResponse) repoCursor {
+	cursor := repoCursor{Sort: sort, ID: repo.ID}
+	switch sort {
+	case repoSortQualityScore:
+		cursor.Value = strconv.Itoa(repo.QualityScore)
+	case repoSortCreatedAt:
+		cursor.Value = repo.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		cursor.Value = strconv.Itoa(repo.Stars)
+	}
+	return cursor
+}
+
+// encode returns the cursor as an opaque URL-safe token
+func (c repoCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeRepoCursor parses a token from encode
+func decodeRepoCursor(token string) (repoCursor, error) {
+	var cursor repoCursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, errInvalidCursor
+	}
+	if _, ok := repoSortColumns[cursor.Sort]; !ok {
+		return cursor, errInvalidCursor
+	}
+	if _, err := cursor.value(); err != nil {
+		return cursor, errInvalidCursor
+	}
+	return cursor, nil
+}
+
+// value returns the cursor's sort value as a query argument
+func (c repoCursor) value() (interface{}, error) {
+	if c.Sort == repoSortCreatedAt {
+		return time.Parse(time.RFC3339Nano, c.Value)
+	}
+	return strconv.Atoi(c.Value)
+}
+
+// countCache caches a COUNT(*) that is too slow to run on every request.
+// The first Get counts synchronously; after that a stale count is returned
+// as-is while a single background refresh runs.
+type countCache struct {
+	ttl   time.Duration
+	count func() (int64, error)
+	now   func() time.Time
+
+	mu         sync.Mutex
+	value      int64
+	fetchedAt  time.Time
+	loaded     bool
+	refreshing bool
+}
+
+// newCountCache caches the result of count for ttl
+func newCountCache(ttl time.Duration, count func() (int64, error)) *countCache {
+	return &countCache{ttl: ttl, count: count, now: time.Now}
+}
+
+// Get returns the cached count
+func (c *countCache) Get() (int64, error) {
+	c.mu.Lock()
+	if !c.loaded {
+		c.mu.Unlock()
+		return c.refresh()
+	}
+	value := c.value
+	if c.now().Sub(c.fetchedAt) >= c.ttl && !c.refreshing {
+		c.refreshing = true
+		go func() {
+			if _, err := c.refresh(); err != nil {
+				log.Printf("Failed to refresh count: %v", err)
+			}
+		}()
+	}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// refresh runs the count and stores the result
+func (c *countCache) refresh() (int64, error) {
+	value, err := c.count()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err != nil {
+		return c.value, fmt.Errorf("count: %w", err)
+	}
+	c.value = value
+	c.fetchedAt = c.now()
+	c.loaded = true
+	return value, nil
+}
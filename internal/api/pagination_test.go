@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"codelupe/internal/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRepoCursor_RoundTrip(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 123456000, time.UTC)
+	repo := models.RepositoryResponse{ID: 42, Stars: 1500, QualityScore: 87, CreatedAt: created}
+
+	tests := []struct {
+		sort string
+		want interface{}
+	}{
+		{repoSortStars, 1500},
+		{repoSortQualityScore, 87},
+		{repoSortCreatedAt, created},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			token := newRepoCursor(tt.sort, repo).encode()
+
+			cursor, err := decodeRepoCursor(token)
+			if err != nil {
+				t.Fatalf("decodeRepoCursor() error = %v", err)
+			}
+			if cursor.Sort != tt.sort || cursor.ID != 42 {
+				t.Errorf("cursor = %+v", cursor)
+			}
+			value, err := cursor.value()
+			if err != nil {
+				t.Fatalf("value() error = %v", err)
+			}
+			if got, ok := value.(time.Time); ok {
+				if !got.Equal(created) {
+					t.Errorf("value() = %v, want %v", got, created)
+				}
+			} else if value != tt.want {
+				t.Errorf("value() = %v, want %v", value, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRepoCursor_Invalid(t *testing.T) {
+	tokens := []string{
+		"not base64!",
+		repoCursor{Sort: "forks", Value: "1", ID: 1}.encode(),
+		repoCursor{Sort: repoSortStars, Value: "many", ID: 1}.encode(),
+		repoCursor{Sort: repoSortCreatedAt, Value: "yesterday", ID: 1}.encode(),
+	}
+	for _, token := range tokens {
+		if _, err := decodeRepoCursor(token); err == nil {
+			t.Errorf("decodeRepoCursor(%q) should fail", token)
+		}
+	}
+}
+
+func TestHandleListRepositories_Cursor(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	cursor := repoCursor{Sort: repoSortQualityScore, Value: "90", ID: 7}
+	mock.ExpectQuery("WHERE \\(quality_score, id\\) < \\(\\$1, \\$2\\)\\s+ORDER BY quality_score DESC, id DESC\\s+LIMIT \\$3").
+		WithArgs(90, int64(7), 2).
+		WillReturnRows(sqlmock.NewRows(repositoryColumnNames).
+			AddRow(6, "a/one", "one", nil, "Go", 10, 1, 90, "downloaded", nil, now, now).
+			AddRow(3, "b/two", "two", nil, "Go", 20, 2, 85, "downloaded", nil, now, now))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(100))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories?sort=quality_score&limit=2&cursor="+cursor.encode(), nil)
+	w := httptest.NewRecorder()
+
+	server.handleListRepositories(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	var response struct {
+		Data       []models.RepositoryResponse `json:"data"`
+		Page       *int                        `json:"page"`
+		NextCursor string                      `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Data) != 2 || response.Page != nil {
+		t.Errorf("len(data) = %d, page = %v; want 2 and no page", len(response.Data), response.Page)
+	}
+
+	next, err := decodeRepoCursor(response.NextCursor)
+	if err != nil {
+		t.Fatalf("next_cursor %q: %v", response.NextCursor, err)
+	}
+	if next != (repoCursor{Sort: repoSortQualityScore, Value: "85", ID: 3}) {
+		t.Errorf("next_cursor = %+v, want after the last row", next)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+// Repositories added through POST /repositories have no language until
+// they are crawled; they must still be listed and paged past
+func TestHandleListRepositories_QueuedRepository(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, full_name, name, description, COALESCE\(language, ''\)`).
+		WithArgs(1, 0).
+		WillReturnRows(sqlmock.NewRows(repositoryColumnNames).
+			AddRow(9, "octo-org/widgets", "widgets", nil, "", 0, 0, 0, "pending", nil, now, now))
+	mock.ExpectQuery("SELECT COUNT").WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories?limit=1", nil)
+	w := httptest.NewRecorder()
+
+	server.handleListRepositories(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	var response struct {
+		Data       []models.RepositoryResponse `json:"data"`
+		NextCursor string                      `json:"next_cursor"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response.Data) != 1 || response.Data[0].FullName != "octo-org/widgets" || response.NextCursor == "" {
+		t.Errorf("data = %+v, next_cursor = %q; want the queued repository and a cursor past it", response.Data, response.NextCursor)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleListRepositories_ScanError(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT id, full_name").
+		WillReturnRows(sqlmock.NewRows(repositoryColumnNames).
+			AddRow(9, "octo-org/widgets", "widgets", nil, "", "many", 0, 0, "pending", nil, now, now))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories", nil)
+	w := httptest.NewRecorder()
+
+	server.handleListRepositories(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Status code = %d, want %d for a row that doesn't scan", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestHandleListRepositories_BadRequest(t *testing.T) {
+	starsCursor := repoCursor{Sort: repoSortStars, Value: "10", ID: 1}.encode()
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"unknown sort", "sort=forks"},
+		{"garbage cursor", "cursor=abc"},
+		{"cursor from another sort", "sort=created_at&cursor=" + starsCursor},
+		{"page too deep for offset", "page=51"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := setupMockServer(t)
+			defer server.db.Close()
+
+			req := httptest.NewRequest("GET", "/api/v1/repositories?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.handleListRepositories(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestCountCache(t *testing.T) {
+	var calls atomic.Int64
+	refreshed := make(chan struct{}, 1)
+	cache := newCountCache(time.Minute, func() (int64, error) {
+		n := calls.Add(1)
+		if n > 1 {
+			refreshed <- struct{}{}
+		}
+		return n * 100, nil
+	})
+	now := time.Unix(1700000000, 0)
+	cache.now = func() time.Time { return now }
+
+	// First call counts, the next is served from cache
+	for i := 0; i < 2; i++ {
+		if got, err := cache.Get(); err != nil || got != 100 {
+			t.Fatalf("Get() = %d, %v; want 100", got, err)
+		}
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("count ran %d times, want 1", calls.Load())
+	}
+
+	// Once stale, the old count is returned while a refresh runs
+	now = now.Add(time.Minute)
+	if got, _ := cache.Get(); got != 100 {
+		t.Errorf("stale Get() = %d, want the cached 100", got)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal("stale count was not refreshed")
+	}
+	// Wait for the refresh to store its result
+	deadline := time.Now().Add(time.Second)
+	for {
+		got, _ := cache.Get()
+		if got == 200 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Get() = %d after refresh, want 200", got)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCountCache_Error(t *testing.T) {
+	cache := newCountCache(time.Minute, func() (int64, error) {
+		return 0, errors.New("database down")
+	})
+
+	if _, err := cache.Get(); err == nil {
+		t.Error("Get() should fail when the first count fails")
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"codelupe/pkg/metrics"
+
+	"golang.org/x/time/rate"
+)
+
+// defaultRateLimitIdle is how long a client's bucket is kept after its last
+// request. A full bucket carries no state worth keeping, so anything idle
+// long enough to have refilled can go.
+const defaultRateLimitIdle = 10 * time.Minute
+
+// clientBucket is one client's token bucket
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// rateLimiter keeps a token bucket per client. Idle buckets are swept out
+// as requests come in, so memory is bounded by the clients seen within the
+// idle window.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+	idle  time.Duration
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter allows each client rps requests per second on average,
+// with bursts of up to burst requests
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		limit:     rate.Limit(rps),
+		burst:     burst,
+		idle:      defaultRateLimitIdle,
+		now:       time.Now,
+		buckets:   make(map[string]*clientBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// allow takes a token from client's bucket. When the bucket is empty it
+// returns false and how long until a token is available.
+func (rl *rateLimiter) allow(client string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	if now.Sub(rl.lastSweep) >= rl.idle {
+		rl.sweep(now)
+	}
+
+	bucket, ok := rl.buckets[client]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(rl.limit, rl.burst)}
+		rl.buckets[client] = bucket
+	}
+	bucket.lastSeen = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops buckets idle for longer than the idle window
+func (rl *rateLimiter) sweep(now time.Time) {
+	for client, bucket := range rl.buckets {
+		if now.Sub(bucket.lastSeen) >= rl.idle {
+			delete(rl.buckets, client)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// size returns the number of buckets held
+func (rl *rateLimiter) size() int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.buckets)
+}
+
+// rateLimitKey identifies the client a request counts against. With auth on
+// that's its API key, which apiKeyAuth has already checked; otherwise it's
+// the client IP, since an unchecked key could be changed per request to
+// dodge the limit.
+func rateLimitKey(r *http.Request, byAPIKey bool) string {
+	if byAPIKey {
+		if key := requestAPIKey(r); key != "" {
+			return "key:" + key
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rateLimitMiddleware answers clients that exceed their rate with 429 and a
+// Retry-After header
+func rateLimitMiddleware(rl *rateLimiter, byAPIKey bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed, retryAfter := rl.allow(rateLimitKey(r, byAPIKey))
+			if !allowed {
+				metrics.IncrCounter("codelupe_api_rate_limited_total", 1)
+
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Rate limit exceeded"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestRateLimiter returns a limiter on a fake clock and a function that
+// advances it
+func newTestRateLimiter(rps float64, burst int) (*rateLimiter, func(time.Duration)) {
+	rl := newRateLimiter(rps, burst)
+	now := time.Unix(1700000000, 0)
+	rl.now = func() time.Time { return now }
+	rl.lastSweep = now
+	return rl, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestRateLimiter_Burst(t *testing.T) {
+	rl, _ := newTestRateLimiter(1, 3)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.allow("client"); !ok {
+			t.Fatalf("request %d denied within burst", i+1)
+		}
+	}
+	ok, retryAfter := rl.allow("client")
+	if ok {
+		t.Fatal("request beyond burst allowed")
+	}
+	if retryAfter != time.Second {
+		t.Errorf("retryAfter = %v, want 1s", retryAfter)
+	}
+}
+
+func TestRateLimiter_SteadyState(t *testing.T) {
+	rl, advance := newTestRateLimiter(2, 1)
+
+	if ok, _ := rl.allow("client"); !ok {
+		t.Fatal("first request denied")
+	}
+	if ok, _ := rl.allow("client"); ok {
+		t.Fatal("second request allowed before refill")
+	}
+
+	// At 2 rps a token comes back every 500ms, and no sooner
+	for i := 0; i < 10; i++ {
+		advance(400 * time.Millisecond)
+		if ok, _ := rl.allow("client"); ok {
+			t.Fatalf("step %d: allowed after 400ms", i)
+		}
+		advance(100 * time.Millisecond)
+		if ok, _ := rl.allow("client"); !ok {
+			t.Fatalf("step %d: denied after 500ms", i)
+		}
+	}
+}
+
+func TestRateLimiter_ClientsAreIndependent(t *testing.T) {
+	rl, _ := newTestRateLimiter(1, 1)
+
+	if ok, _ := rl.allow("a"); !ok {
+		t.Fatal("a denied")
+	}
+	if ok, _ := rl.allow("a"); ok {
+		t.Fatal("a allowed twice")
+	}
+	if ok, _ := rl.allow("b"); !ok {
+		t.Error("b denied because of a")
+	}
+}
+
+func TestRateLimiter_EvictsIdleClients(t *testing.T) {
+	rl, advance := newTestRateLimiter(1, 1)
+
+	for _, client := range []string{"a", "b", "c"} {
+		rl.allow(client)
+	}
+	advance(rl.idle / 2)
+	rl.allow("d")
+	if got := rl.size(); got != 4 {
+		t.Fatalf("size() = %d, want 4 before the idle window", got)
+	}
+
+	// a, b and c have been idle a full window; d only half of one
+	advance(rl.idle / 2)
+	rl.allow("e")
+	if got := rl.size(); got != 2 {
+		t.Errorf("size() = %d, want 2 (d and e) after eviction", got)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+	server.config.RateLimitRPS = 0.5
+	server.config.RateLimitBurst = 2
+	server.config.EnableMetrics = true
+	server.router = mux.NewRouter()
+	server.setupRoutes()
+
+	// The second request is served from the response cache
+	expectDistributionQuery(mock)
+
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/quality/distribution", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := get("10.0.0.1:1234"); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status code = %d, want %d", i+1, w.Code, http.StatusOK)
+		}
+	}
+
+	// Another port on the same host is the same client
+	w := get("10.0.0.1:5678")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Status code = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want %q", got, "2")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	metricsReq := httptest.NewRequest("GET", "/metrics", nil)
+	metricsW := httptest.NewRecorder()
+	server.router.ServeHTTP(metricsW, metricsReq)
+	if !strings.Contains(metricsW.Body.String(), "codelupe_api_rate_limited_total") {
+		t.Errorf("metrics output lacks codelupe_api_rate_limited_total:\n%s", metricsW.Body.String())
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	req := httptest.NewRequest("GET", "/api/v1/languages", nil)
+	req.RemoteAddr = "192.0.2.7:4000"
+	req.Header.Set("X-API-Key", "secret-key")
+
+	if got := rateLimitKey(req, true); got != "key:secret-key" {
+		t.Errorf("rateLimitKey(byAPIKey) = %q, want the key", got)
+	}
+	// Without auth the key is unchecked, so the IP is used
+	if got := rateLimitKey(req, false); got != "ip:192.0.2.7" {
+		t.Errorf("rateLimitKey() = %q, want the IP", got)
+	}
+}
@@ -0,0 +1,348 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"codelupe/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// searchIndex is the Elasticsearch index the crawler writes repositories to
+const searchIndex = models.RepoIndex
+
+// searchIndexes are the indices a search tries in order: the one the
+// downloader's sync-es command fills from Postgres, which knows quality
+// scores and download status, then the crawler's own
+var searchIndexes = []string{models.EnrichedRepoIndex, searchIndex}
+
+// maxSearchResults caps the number of hits a search returns
+const maxSearchResults = 50
+
+// Search sort orders
+const (
+	sortRelevance = "relevance"
+	sortStars     = "stars"
+	sortRecent    = "recent"
+)
+
+// errTopicsNeedElasticsearch is returned when a topic filter can't be served
+// because only Postgres is available, which doesn't store topics
+var errTopicsNeedElasticsearch = errors.New("filtering by topics requires Elasticsearch")
+
+// searchParams are the parsed query parameters of a repository search
+type searchParams struct {
+	Query    string
+	Language string
+	MinStars int
+	Topics   []string
+	Sort     string
+}
+
+// SearchResult is a repository matching a search. Score is the Elasticsearch
+// relevance score and is omitted when the search was served by Postgres.
+type SearchResult struct {
+	models.RepositoryResponse
+	Topics []string `json:"topics,omitempty"`
+	Score  *float64 `json:"score,omitempty"`
+}
+
+// parseSearchParams reads and validates the search query string
+func parseSearchParams(values url.Values) (searchParams, error) {
+	params := searchParams{
+		Query:    strings.TrimSpace(values.Get("q")),
+		Language: values.Get("language"),
+		Sort:     values.Get("sort"),
+	}
+	if params.Query == "" {
+		return params, errors.New("Query parameter 'q' is required")
+	}
+
+	params.MinStars, _ = strconv.Atoi(values.Get("min_stars"))
+
+	for _, topic := range strings.Split(values.Get("topics"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			params.Topics = append(params.Topics, topic)
+		}
+	}
+
+	switch params.Sort {
+	case "":
+		params.Sort = sortRelevance
+	case sortRelevance, sortStars, sortRecent:
+	default:
+		return params, fmt.Errorf("Invalid sort %q: use relevance, stars or recent", params.Sort)
+	}
+
+	return params, nil
+}
+
+// handleSearchRepositories searches repositories by query. Elasticsearch
+// serves the search when it is reachable; otherwise it falls back to ILIKE
+// matching in Postgres.
+func (s *Server) handleSearchRepositories(w http.ResponseWriter, r *http.Request) {
+	params, err := parseSearchParams(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	source := "elasticsearch"
+	results, total, index, err := s.searchElasticsearch(r.Context(), params)
+	if err != nil {
+		if s.esClient != nil {
+			log.Printf("Elasticsearch search failed, falling back to Postgres: %v", err)
+		}
+		source = "postgres"
+		results, total, err = s.searchPostgres(params)
+	}
+	if errors.Is(err, errTopicsNeedElasticsearch) {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"results": results,
+		"count":   len(results),
+		"total":   total,
+		"sort":    params.Sort,
+		"source":  source,
+	}
+	if index != "" {
+		response["index"] = index
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// buildSearchQuery builds the Elasticsearch request body for a search
+func buildSearchQuery(params searchParams) map[string]interface{} {
+	filters := []interface{}{}
+	if params.Language != "" {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"language": params.Language},
+		})
+	}
+	if params.MinStars > 0 {
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{"stars": map[string]interface{}{"gte": params.MinStars}},
+		})
+	}
+	for _, topic := range params.Topics {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{"topics": topic},
+		})
+	}
+
+	query := map[string]interface{}{
+		"size":             maxSearchResults,
+		"track_total_hits": true,
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"multi_match": map[string]interface{}{
+						"query":  params.Query,
+						"fields": []string{"name^3", "description", "topics^2"},
+					},
+				},
+				"filter": filters,
+			},
+		},
+	}
+
+	// Sorting by a field drops scoring unless it's asked for explicitly
+	switch params.Sort {
+	case sortStars:
+		query["sort"] = []interface{}{
+			map[string]interface{}{"stars": map[string]interface{}{"order": "desc"}},
+			"_score",
+		}
+		query["track_scores"] = true
+	case sortRecent:
+		query["sort"] = []interface{}{
+			map[string]interface{}{"last_updated": map[string]interface{}{"order": "desc", "missing": "_last"}},
+			"_score",
+		}
+		query["track_scores"] = true
+	}
+
+	return query
+}
+
+// searchElasticsearch runs a search against the first of searchIndexes
+// that answers and returns the hits, the total number of matches and the
+// index that served them
+func (s *Server) searchElasticsearch(ctx context.Context, params searchParams) ([]SearchResult, int64, string, error) {
+	if s.esClient == nil {
+		return nil, 0, "", errors.New("Elasticsearch is not configured")
+	}
+
+	var errs []error
+	for _, index := range searchIndexes {
+		results, total, err := s.searchInIndex(ctx, index, params)
+		if err == nil {
+			s.attachRepositoryRows(results)
+			return results, total, index, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", index, err))
+	}
+	return nil, 0, "", errors.Join(errs...)
+}
+
+// searchInIndex runs a search against one index. Hits from
+// models.EnrichedRepoIndex carry their quality score and download status.
+func (s *Server) searchInIndex(ctx context.Context, index string, params searchParams) ([]SearchResult, int64, error) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(buildSearchQuery(params)); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := s.esClient.Search(
+		s.esClient.Search.WithContext(ctx),
+		s.esClient.Search.WithIndex(index),
+		s.esClient.Search.WithBody(&body),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, 0, fmt.Errorf("search error: %s", res.String())
+	}
+
+	var response struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score  *float64            `json:"_score"`
+				Source models.EnrichedRepo `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode search response: %w", err)
+	}
+
+	results := make([]SearchResult, 0, len(response.Hits.Hits))
+	for _, hit := range response.Hits.Hits {
+		repo := hit.Source.RepoInfo.Response()
+		if index == models.EnrichedRepoIndex {
+			repo = hit.Source.Response()
+		}
+		results = append(results, SearchResult{
+			RepositoryResponse: repo,
+			Topics:             hit.Source.Topics,
+			Score:              hit.Score,
+		})
+	}
+	return results, response.Hits.Total.Value, nil
+}
+
+// attachRepositoryRows fills in the fields only Postgres knows (id, quality
+// score, download status) for search hits, replacing what an enriched hit
+// carried with the current values. It is best effort: hits for repositories
+// not in Postgres keep the values they came with.
+func (s *Server) attachRepositoryRows(results []SearchResult) {
+	if len(results) == 0 {
+		return
+	}
+
+	byName := make(map[string]*SearchResult, len(results))
+	names := make([]string, 0, len(results))
+	for i := range results {
+		byName[results[i].FullName] = &results[i]
+		names = append(names, results[i].FullName)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, full_name, quality_score, download_status
+		FROM repositories
+		WHERE full_name = ANY($1)
+	`, pq.Array(names))
+	if err != nil {
+		log.Printf("Failed to look up search hits in Postgres: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var fullName, status string
+		var quality int
+		if err := rows.Scan(&id, &fullName, &quality, &status); err != nil {
+			continue
+		}
+		if result, ok := byName[fullName]; ok {
+			result.ID = id
+			result.QualityScore = quality
+			result.DownloadStatus = status
+		}
+	}
+}
+
+// searchPostgres matches the query against repository names and
+// descriptions with ILIKE. It can't rank by relevance, so relevance sorting
+// falls back to stars.
+func (s *Server) searchPostgres(params searchParams) ([]SearchResult, int64, error) {
+	if len(params.Topics) > 0 {
+		return nil, 0, errTopicsNeedElasticsearch
+	}
+
+	query := `
+		SELECT ` + repositoryColumns + `, COUNT(*) OVER() AS total
+		FROM repositories
+		WHERE (full_name ILIKE $1 OR description ILIKE $1)
+	`
+	args := []interface{}{"%" + params.Query + "%"}
+
+	if params.Language != "" {
+		args = append(args, params.Language)
+		query += fmt.Sprintf(" AND language = $%d", len(args))
+	}
+
+	if params.MinStars > 0 {
+		args = append(args, params.MinStars)
+		query += fmt.Sprintf(" AND stars >= $%d", len(args))
+	}
+
+	if params.Sort == sortRecent {
+		query += " ORDER BY updated_at DESC"
+	} else {
+		query += " ORDER BY stars DESC"
+	}
+	query += fmt.Sprintf(" LIMIT %d", maxSearchResults)
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	var total int64
+	for rows.Next() {
+		repo, err := scanRepository(rows, &total)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, SearchResult{RepositoryResponse: repo})
+	}
+
+	return results, total, rows.Err()
+}
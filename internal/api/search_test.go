@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/lib/pq"
+)
+
+// newFakeElasticsearch returns a client for a server that answers every
+// search with status and body, and records the last search request body
+func newFakeElasticsearch(t *testing.T, status int, body string) (*elasticsearch.Client, *map[string]interface{}) {
+	t.Helper()
+
+	var query map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&query)
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatalf("Failed to create Elasticsearch client: %v", err)
+	}
+	return client, &query
+}
+
+func searchRows() *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(append(repositoryColumnNames, "total")).AddRow(
+		1, "rust-lang/rust", "rust", "A safe language",
+		"Rust", 50000, 10000, 95, "downloaded", nil, now, now, 1,
+	)
+}
+
+func decodeSearchResponse(t *testing.T, w *httptest.ResponseRecorder) (results []SearchResult, response map[string]interface{}) {
+	t.Helper()
+
+	var body struct {
+		Results []SearchResult `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return body.Results, response
+}
+
+func TestHandleSearchRepositories_Elasticsearch(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	client, query := newFakeElasticsearch(t, http.StatusOK, `{
+		"hits": {
+			"total": {"value": 120, "relation": "eq"},
+			"hits": [
+				{"_score": 12.5, "_source": {"full_name": "rust-lang/rust", "name": "rust", "language": "Rust", "stars": 50000, "topics": ["compiler"]}},
+				{"_score": 7.25, "_source": {"full_name": "new/repo", "name": "repo", "language": "Rust", "stars": 150}}
+			]
+		}
+	}`)
+	server.esClient = client
+
+	// Only the first hit is known to Postgres
+	mock.ExpectQuery("WHERE full_name = ANY").
+		WithArgs(pq.Array([]string{"rust-lang/rust", "new/repo"})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "full_name", "quality_score", "download_status"}).
+			AddRow(1, "rust-lang/rust", 95, "downloaded"))
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust&language=Rust&min_stars=100&topics=compiler,%20systems&sort=stars", nil)
+	w := httptest.NewRecorder()
+
+	server.handleSearchRepositories(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	results, response := decodeSearchResponse(t, w)
+	if response["source"] != "elasticsearch" {
+		t.Errorf("source = %v, want elasticsearch", response["source"])
+	}
+	if response["total"] != float64(120) || response["count"] != float64(2) {
+		t.Errorf("total = %v, count = %v, want 120 and 2", response["total"], response["count"])
+	}
+	if len(results) != 2 {
+		t.Fatalf("len(results) = %d, want 2", len(results))
+	}
+	if results[0].Score == nil || *results[0].Score != 12.5 {
+		t.Errorf("results[0].score = %v, want 12.5", results[0].Score)
+	}
+	if results[0].ID != 1 || results[0].QualityScore != 95 || results[0].DownloadStatus != "downloaded" {
+		t.Errorf("results[0] was not merged with its Postgres row: %+v", results[0])
+	}
+	if results[1].ID != 0 || results[1].FullName != "new/repo" {
+		t.Errorf("results[1] = %+v, want the unmerged new/repo hit", results[1])
+	}
+
+	// The request carries the query, every filter and the sort
+	filters := (*query)["query"].(map[string]interface{})["bool"].(map[string]interface{})["filter"].([]interface{})
+	if len(filters) != 4 {
+		t.Errorf("got %d filters, want language, min_stars and two topics: %v", len(filters), filters)
+	}
+	if (*query)["track_scores"] != true || (*query)["sort"] == nil {
+		t.Errorf("stars sort should sort by field and keep scores: %v", *query)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleSearchRepositories_PrefersEnrichedIndex(t *testing.T) {
+	hits := map[string]string{
+		"repositories-enriched": `{"hits": {"total": {"value": 1}, "hits": [
+			{"_score": 3.5, "_source": {"full_name": "rust-lang/rust", "name": "rust", "quality_score": 88, "download_status": "downloaded", "license": "MIT"}}
+		]}}`,
+		"github-coding-repos": `{"hits": {"total": {"value": 1}, "hits": [
+			{"_score": 2.0, "_source": {"full_name": "rust-lang/rust", "name": "rust"}}
+		]}}`,
+	}
+
+	tests := []struct {
+		name string
+		// missing is the index that answers 404
+		missing     string
+		wantIndex   string
+		wantQuality int
+	}{
+		{"enriched index available", "", "repositories-enriched", 88},
+		{"enriched index missing", "repositories-enriched", "github-coding-repos", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupMockServer(t)
+			defer server.db.Close()
+
+			es := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				index := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), "/_search")
+				w.Header().Set("X-Elastic-Product", "Elasticsearch")
+				w.Header().Set("Content-Type", "application/json")
+				if index == tt.missing {
+					w.WriteHeader(http.StatusNotFound)
+					w.Write([]byte(`{"error": {"type": "index_not_found_exception"}}`))
+					return
+				}
+				w.Write([]byte(hits[index]))
+			}))
+			defer es.Close()
+			client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{es.URL}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			server.esClient = client
+
+			// The hit isn't in Postgres, so it keeps what the index had
+			mock.ExpectQuery("WHERE full_name = ANY").
+				WillReturnRows(sqlmock.NewRows([]string{"id", "full_name", "quality_score", "download_status"}))
+
+			req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust", nil)
+			w := httptest.NewRecorder()
+			server.handleSearchRepositories(w, req)
+
+			results, response := decodeSearchResponse(t, w)
+			if response["source"] != "elasticsearch" || response["index"] != tt.wantIndex {
+				t.Errorf("source = %v, index = %v, want elasticsearch and %s", response["source"], response["index"], tt.wantIndex)
+			}
+			if len(results) != 1 || results[0].QualityScore != tt.wantQuality {
+				t.Errorf("results = %+v, want quality score %d", results, tt.wantQuality)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleSearchRepositories_Postgres(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("SELECT id, full_name, name, description, COALESCE\\(language, ''\\).*AND language = \\$2 AND stars >= \\$3 ORDER BY updated_at DESC").
+		WithArgs("%rust%", "Rust", 100).
+		WillReturnRows(searchRows())
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust&language=Rust&min_stars=100&sort=recent", nil)
+	w := httptest.NewRecorder()
+
+	server.handleSearchRepositories(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+
+	results, response := decodeSearchResponse(t, w)
+	if response["source"] != "postgres" || response["sort"] != "recent" {
+		t.Errorf("source = %v, sort = %v, want postgres and recent", response["source"], response["sort"])
+	}
+	if response["count"] != float64(1) || response["total"] != float64(1) {
+		t.Errorf("count = %v, total = %v, want 1", response["count"], response["total"])
+	}
+	if len(results) != 1 || results[0].FullName != "rust-lang/rust" || results[0].Score != nil {
+		t.Errorf("results = %+v, want rust-lang/rust without a score", results)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleSearchRepositories_FallsBackWhenElasticsearchFails(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	server.esClient, _ = newFakeElasticsearch(t, http.StatusServiceUnavailable, `{"error": "cluster unavailable"}`)
+
+	mock.ExpectQuery("SELECT id, full_name.*ORDER BY stars DESC").
+		WithArgs("%rust%").
+		WillReturnRows(searchRows())
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust", nil)
+	w := httptest.NewRecorder()
+
+	server.handleSearchRepositories(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Status code = %d, want %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if _, response := decodeSearchResponse(t, w); response["source"] != "postgres" {
+		t.Errorf("source = %v, want postgres", response["source"])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestHandleSearchRepositories_TopicsNeedElasticsearch(t *testing.T) {
+	server, _ := setupMockServer(t)
+	defer server.db.Close()
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust&topics=compiler", nil)
+	w := httptest.NewRecorder()
+
+	server.handleSearchRepositories(w, req)
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Status code = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestHandleSearchRepositories_BadRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{"missing query", "/api/v1/repositories/search"},
+		{"blank query", "/api/v1/repositories/search?q=%20"},
+		{"unknown sort", "/api/v1/repositories/search?q=rust&sort=forks"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := setupMockServer(t)
+			defer server.db.Close()
+
+			req := httptest.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+
+			server.handleSearchRepositories(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
+func TestSetupRoutes_SearchIsNotAnID(t *testing.T) {
+	server, mock := setupMockServer(t)
+	defer server.db.Close()
+
+	mock.ExpectQuery("SELECT id, full_name").WithArgs("%rust%").WillReturnRows(searchRows())
+
+	req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust", nil)
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, req)
+
+	if _, response := decodeSearchResponse(t, w); response["source"] != "postgres" {
+		t.Errorf("/repositories/search was not routed to the search handler: %s", w.Body.String())
+	}
+}
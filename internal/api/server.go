@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 
+	apispec "codelupe/api"
+	"codelupe/internal/dataset"
+	"codelupe/internal/models"
+	"codelupe/pkg/contentstore"
+	"codelupe/pkg/metrics"
+
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
@@ -24,6 +28,75 @@ type Config struct {
 	ElasticsearchURL string
 	EnableCORS       bool
 	EnableMetrics    bool
+
+	// EnableAuth requires one of APIKeys on every /api/v1 route. Keys may
+	// be given as sha256:<hex> digests.
+	EnableAuth bool
+	APIKeys    []string
+
+	// RateLimitRPS and RateLimitBurst bound each client's request rate on
+	// /api/v1. Clients are told apart by API key when auth is enabled and
+	// by IP otherwise. A RateLimitRPS of 0 disables rate limiting.
+	RateLimitRPS   float64
+	RateLimitBurst int
+
+	// HTTP server timeouts; zero uses the defaults below
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// Database connection pool; zero uses the defaults below
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// DatasetCacheTTL is how long /api/v1/dataset statistics are cached;
+	// zero uses the default below
+	DatasetCacheTTL time.Duration
+
+	// ResponseCacheTTL is how long the repository stats, languages and
+	// quality distribution responses are cached; zero uses the default below
+	ResponseCacheTTL time.Duration
+
+	// Export jobs write their artifacts to ExportsDir, ExportConcurrency at
+	// a time, and are deleted ExportTTL after they finish; unset fields use
+	// the defaults in exports.go
+	ExportsDir        string
+	ExportConcurrency int
+	ExportTTL         time.Duration
+}
+
+// Defaults for unset Config fields
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultReadTimeout       = 15 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultMaxOpenConns      = 25
+	defaultMaxIdleConns      = 10
+	defaultConnMaxLifetime   = 30 * time.Minute
+	defaultDatasetCacheTTL   = 5 * time.Minute
+	defaultResponseCacheTTL  = 60 * time.Second
+
+	// repoCountTTL is how long the repositories total may be stale
+	repoCountTTL = 30 * time.Second
+)
+
+// durationOr returns d, or def when d is unset
+func durationOr(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return d
+}
+
+// intOr returns n, or def when n is unset
+func intOr(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
 }
 
 // Server represents the API server
@@ -32,14 +105,47 @@ type Server struct {
 	router   *mux.Router
 	db       *sql.DB
 	esClient *elasticsearch.Client
+	content  *contentstore.Store
+	apiKeys  []apiKeyDigest
+
+	// repoCount caches the repositories total shown by the list endpoint
+	repoCount *countCache
+
+	// dataset caches the dataset analyzer's statistics
+	dataset *dataset.Cache
+
+	// responses caches the aggregate endpoints' bodies
+	responses *responseCache
+
+	// exports runs the dataset snapshots requested through /api/v1/exports
+	exports *exportManager
+
+	// spec is the OpenAPI document served under /api
+	spec *openAPISpec
+
+	httpServer *http.Server
 }
 
 // NewServer creates a new API server
 func NewServer(config Config) *Server {
-	return &Server{
+	s := &Server{
 		config: config,
 		router: mux.NewRouter(),
 	}
+	s.httpServer = s.newHTTPServer()
+	return s
+}
+
+// newHTTPServer returns the http.Server that serves the router
+func (s *Server) newHTTPServer() *http.Server {
+	return &http.Server{
+		Addr:              ":" + s.config.Port,
+		Handler:           s.router,
+		ReadHeaderTimeout: durationOr(s.config.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       durationOr(s.config.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:      durationOr(s.config.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:       durationOr(s.config.IdleTimeout, defaultIdleTimeout),
+	}
 }
 
 // Start initializes and starts the API server
@@ -49,6 +155,9 @@ func (s *Server) Start() error {
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	db.SetMaxOpenConns(intOr(s.config.MaxOpenConns, defaultMaxOpenConns))
+	db.SetMaxIdleConns(intOr(s.config.MaxIdleConns, defaultMaxIdleConns))
+	db.SetConnMaxLifetime(durationOr(s.config.ConnMaxLifetime, defaultConnMaxLifetime))
 	s.db = db
 
 	// Initialize Elasticsearch client
@@ -60,37 +169,185 @@ func (s *Server) Start() error {
 	}
 	s.esClient = esClient
 
+	// File content may be stored inline, compressed or on disk
+	content, err := contentstore.FromEnv()
+	if err != nil {
+		return fmt.Errorf("failed to configure content storage: %w", err)
+	}
+	s.content = content
+
+	if s.config.EnableAuth {
+		keys, err := parseAPIKeys(s.config.APIKeys)
+		if err != nil {
+			return fmt.Errorf("invalid API keys: %w", err)
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("authentication is enabled but no API keys are configured")
+		}
+		s.apiKeys = keys
+	}
+
 	// Setup routes
 	s.setupRoutes()
+	go s.exports.janitor()
+
+	// Start server; returns once Shutdown is called
+	log.Printf("API server listening on %s", s.httpServer.Addr)
+	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
 
-	// Start server
-	addr := ":" + s.config.Port
-	log.Printf("API server listening on %s", addr)
-	return http.ListenAndServe(addr, s.router)
+// Shutdown stops accepting connections, waits for in-flight requests to
+// finish or ctx to expire, then closes the database
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.httpServer.Shutdown(ctx)
+	if closeErr := s.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
 // setupRoutes configures API routes
 func (s *Server) setupRoutes() {
+	s.repoCount = newCountCache(repoCountTTL, func() (int64, error) {
+		var total int64
+		err := s.db.QueryRow("SELECT COUNT(*) FROM repositories").Scan(&total)
+		return total, err
+	})
+	s.dataset = dataset.NewCache(dataset.NewAnalyzer(s.db),
+		durationOr(s.config.DatasetCacheTTL, defaultDatasetCacheTTL))
+	s.responses = newResponseCache(durationOr(s.config.ResponseCacheTTL, defaultResponseCacheTTL))
+	s.spec = newOpenAPISpec(apispec.OpenAPI)
+	exportsDir := s.config.ExportsDir
+	if exportsDir == "" {
+		exportsDir = defaultExportsDir
+	}
+	s.exports = newExportManager(s.db, s.content, exportsDir,
+		intOr(s.config.ExportConcurrency, defaultExportConcurrency),
+		durationOr(s.config.ExportTTL, defaultExportTTL))
+
 	// Health check
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
 
-	// API documentation
+	// API documentation. Routes without an entry in api/openapi.yaml attach
+	// their operation with s.spec.document.
 	s.router.HandleFunc("/api/docs", s.handleSwaggerUI).Methods("GET")
+	s.spec.document("/api/docs", "GET", `
+tags: [Documentation]
+summary: Swagger UI for this API
+operationId: getDocs
+security: []
+responses:
+  '200':
+    description: HTML page
+    content:
+      text/html:
+        schema:
+          type: string
+`)
 	s.router.HandleFunc("/api/openapi.yaml", s.handleOpenAPISpec).Methods("GET")
+	s.spec.document("/api/openapi.yaml", "GET", `
+tags: [Documentation]
+summary: This specification as YAML
+operationId: getOpenAPIYAML
+security: []
+responses:
+  '200':
+    description: OpenAPI document
+    content:
+      application/yaml:
+        schema:
+          type: string
+`)
+	s.router.HandleFunc("/api/openapi.json", s.handleOpenAPIJSON).Methods("GET")
+	s.spec.document("/api/openapi.json", "GET", `
+tags: [Documentation]
+summary: This specification as JSON
+operationId: getOpenAPIJSON
+security: []
+responses:
+  '200':
+    description: OpenAPI document
+    content:
+      application/json:
+        schema:
+          type: object
+`)
+
+	// Prometheus metrics
+	if s.config.EnableMetrics {
+		s.router.Handle("/metrics", metrics.Handler()).Methods("GET")
+		s.spec.document("/metrics", "GET", `
+tags: [Health]
+summary: Prometheus metrics
+operationId: getMetrics
+security: []
+responses:
+  '200':
+    description: Metrics in the Prometheus text exposition format
+    content:
+      text/plain:
+        schema:
+          type: string
+`)
+	}
+
+	// Everything under /api/v1 requires an API key when auth is enabled
+	v1 := s.router.PathPrefix("/api/v1").Subrouter()
+	if s.config.EnableAuth {
+		v1.Use(apiKeyAuth(s.apiKeys))
+	}
+	// Rate limiting runs after auth so only checked keys get a bucket
+	if s.config.RateLimitRPS > 0 {
+		limiter := newRateLimiter(s.config.RateLimitRPS, s.config.RateLimitBurst)
+		v1.Use(rateLimitMiddleware(limiter, s.config.EnableAuth))
+	}
 
 	// Repository endpoints
-	s.router.HandleFunc("/api/v1/repositories", s.handleListRepositories).Methods("GET")
-	s.router.HandleFunc("/api/v1/repositories/{id}", s.handleGetRepository).Methods("GET")
-	s.router.HandleFunc("/api/v1/repositories/search", s.handleSearchRepositories).Methods("GET")
-	s.router.HandleFunc("/api/v1/repositories/stats", s.handleRepositoryStats).Methods("GET")
+	v1.HandleFunc("/repositories", s.handleListRepositories).Methods("GET")
+	v1.HandleFunc("/repositories", s.handleCreateRepository).Methods("POST")
+	v1.HandleFunc("/repositories/search", s.handleSearchRepositories).Methods("GET")
+	v1.HandleFunc("/repositories/stats", s.responses.cached(s.handleRepositoryStats, repoFilterParams...)).Methods("GET")
+	// Registered after search and stats so {id} doesn't swallow them
+	v1.HandleFunc("/repositories/{id}", s.handleGetRepository).Methods("GET")
+	v1.HandleFunc("/repositories/{id}/files", s.handleListRepositoryFiles).Methods("GET")
+	v1.HandleFunc("/repositories/{id}/tree", s.handleRepositoryTree).Methods("GET")
+	v1.HandleFunc("/repositories/{id}/blob", s.handleRepositoryBlob).Methods("GET")
+
+	// Processed files
+	v1.HandleFunc("/files", s.handleListFiles).Methods("GET")
+	v1.HandleFunc("/files/{id}", s.handleGetFile).Methods("GET")
+
+	// Processing pipeline
+	v1.HandleFunc("/jobs", s.handleListJobs).Methods("GET")
+	v1.HandleFunc("/jobs/summary", s.handleJobSummary).Methods("GET")
+	v1.HandleFunc("/jobs/{id}", s.handleGetJob).Methods("GET")
+	v1.HandleFunc("/jobs/{id}/retry", s.handleRetryJob).Methods("POST")
+	v1.HandleFunc("/pipeline/funnel", s.handlePipelineFunnel).Methods("GET")
 
 	// Language statistics
-	s.router.HandleFunc("/api/v1/languages", s.handleListLanguages).Methods("GET")
-	s.router.HandleFunc("/api/v1/languages/{language}/stats", s.handleLanguageStats).Methods("GET")
+	v1.HandleFunc("/languages", s.responses.cached(s.handleListLanguages)).Methods("GET")
+	v1.HandleFunc("/languages/{language}/stats", s.handleLanguageStats).Methods("GET")
 
 	// Quality metrics
-	s.router.HandleFunc("/api/v1/quality/top", s.handleTopQualityRepos).Methods("GET")
-	s.router.HandleFunc("/api/v1/quality/distribution", s.handleQualityDistribution).Methods("GET")
+	v1.HandleFunc("/quality/top", s.handleTopQualityRepos).Methods("GET")
+	v1.HandleFunc("/quality/distribution", s.responses.cached(s.handleQualityDistribution, repoFilterParams...)).Methods("GET")
+
+	// Dataset statistics
+	v1.HandleFunc("/dataset/overview", s.handleDatasetOverview).Methods("GET")
+	v1.HandleFunc("/dataset/languages", s.handleDatasetLanguages).Methods("GET")
+	v1.HandleFunc("/dataset/activity", s.handleDatasetActivity).Methods("GET")
+	v1.HandleFunc("/dataset/refresh", s.handleDatasetRefresh).Methods("POST")
+
+	// Cached aggregate responses
+	v1.HandleFunc("/cache/flush", s.handleCacheFlush).Methods("POST")
+
+	// Dataset snapshots exported in the background
+	v1.HandleFunc("/exports", s.handleCreateExport).Methods("POST")
+	v1.HandleFunc("/exports/{id}", s.handleGetExport).Methods("GET")
+	v1.HandleFunc("/exports/{id}/download", s.handleDownloadExport).Methods("GET")
 
 	// CORS middleware
 	if s.config.EnableCORS {
@@ -101,22 +358,6 @@ func (s *Server) setupRoutes() {
 	s.router.Use(loggingMiddleware)
 }
 
-// Repository represents a repository response
-type Repository struct {
-	ID             int64     `json:"id"`
-	FullName       string    `json:"full_name"`
-	Name           string    `json:"name"`
-	Description    string    `json:"description"`
-	Language       string    `json:"language"`
-	Stars          int       `json:"stars"`
-	Forks          int       `json:"forks"`
-	QualityScore   int       `json:"quality_score"`
-	DownloadStatus string    `json:"download_status"`
-	LocalPath      string    `json:"local_path,omitempty"`
-	CreatedAt      time.Time `json:"created_at"`
-	UpdatedAt      time.Time `json:"updated_at"`
-}
-
 // handleHealth returns server health status
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	health := map[string]interface{}{
@@ -134,8 +375,9 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check Elasticsearch
-	_, err := s.esClient.Info()
-	if err != nil {
+	if s.esClient == nil {
+		health["elasticsearch"] = "disabled"
+	} else if _, err := s.esClient.Info(); err != nil {
 		health["elasticsearch"] = "error"
 	} else {
 		health["elasticsearch"] = "ok"
@@ -144,12 +386,19 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
-// handleListRepositories returns a paginated list of repositories
+// handleListRepositories returns a page of repositories sorted by stars,
+// quality_score or created_at. The first pages can be fetched by page number;
+// beyond that, and for stable iteration, clients follow next_cursor.
 func (s *Server) handleListRepositories(w http.ResponseWriter, r *http.Request) {
 	// Parse query parameters
-	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
-	if page < 1 {
-		page = 1
+	sort := r.URL.Query().Get("sort")
+	if sort == "" {
+		sort = repoSortStars
+	}
+	column, ok := repoSortColumns[sort]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid sort %q", sort), http.StatusBadRequest)
+		return
 	}
 
 	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
@@ -157,82 +406,119 @@ func (s *Server) handleListRepositories(w http.ResponseWriter, r *http.Request)
 		limit = 20
 	}
 
-	offset := (page - 1) * limit
-
-	// Query database
-	query := `
-		SELECT id, full_name, name, description, language, stars, forks,
-		       quality_score, download_status, created_at, updated_at
-		FROM repositories
-		ORDER BY stars DESC
-		LIMIT $1 OFFSET $2
-	`
+	var query string
+	var args []interface{}
+	var page int
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		cursor, err := decodeRepoCursor(token)
+		if err != nil || cursor.Sort != sort {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+		value, _ := cursor.value()
+		query = fmt.Sprintf(`
+			SELECT `+repositoryColumns+`
+			FROM repositories
+			WHERE (%[1]s, id) < ($1, $2)
+			ORDER BY %[1]s DESC, id DESC
+			LIMIT $3
+		`, column)
+		args = []interface{}{value, cursor.ID, limit}
+	} else {
+		page, _ = strconv.Atoi(r.URL.Query().Get("page"))
+		if page < 1 {
+			page = 1
+		}
+		if page > maxOffsetPage {
+			http.Error(w, fmt.Sprintf("page must be at most %d; use cursor to page further", maxOffsetPage), http.StatusBadRequest)
+			return
+		}
+		query = fmt.Sprintf(`
+			SELECT `+repositoryColumns+`
+			FROM repositories
+			ORDER BY %[1]s DESC, id DESC
+			LIMIT $1 OFFSET $2
+		`, column)
+		args = []interface{}{limit, (page - 1) * limit}
+	}
 
-	rows, err := s.db.Query(query, limit, offset)
+	rows, err := s.db.Query(query, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var repos []Repository
+	// A row that fails to scan is an error rather than skipped, since a
+	// short page would also end cursor pagination early
+	repos := []models.RepositoryResponse{}
 	for rows.Next() {
-		var repo Repository
-		var name, description sql.NullString
-		err := rows.Scan(
-			&repo.ID, &repo.FullName, &name, &description,
-			&repo.Language, &repo.Stars, &repo.Forks,
-			&repo.QualityScore, &repo.DownloadStatus,
-			&repo.CreatedAt, &repo.UpdatedAt,
-		)
+		repo, err := scanRepository(rows)
 		if err != nil {
-			continue
-		}
-
-		if name.Valid {
-			repo.Name = name.String
-		}
-		if description.Valid {
-			repo.Description = description.String
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-
 		repos = append(repos, repo)
 	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	// Get total count
-	var total int
-	s.db.QueryRow("SELECT COUNT(*) FROM repositories").Scan(&total)
+	// The total is cached; counting millions of rows per request is too slow
+	total, err := s.repoCount.Get()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	response := map[string]interface{}{
 		"data":  repos,
-		"page":  page,
 		"limit": limit,
+		"sort":  sort,
 		"total": total,
 	}
+	if page > 0 {
+		response["page"] = page
+	}
+	// A full page may have more after it
+	if len(repos) == limit {
+		response["next_cursor"] = newRepoCursor(sort, repos[len(repos)-1]).encode()
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// handleGetRepository returns a single repository by ID
-func (s *Server) handleGetRepository(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+// repositoryColumns are the repositories columns scanned by scanRepository.
+// Rows queued through the API have no language or counts yet.
+const repositoryColumns = `id, full_name, name, description, COALESCE(language, ''),
+	COALESCE(stars, 0), COALESCE(forks, 0), COALESCE(quality_score, 0),
+	COALESCE(download_status, 'pending'), local_path, created_at, updated_at`
 
-	var repo Repository
+// scanRepository scans a row selected with repositoryColumns, followed by
+// any extra columns into extra
+func scanRepository(row interface{ Scan(...interface{}) error }, extra ...interface{}) (models.RepositoryResponse, error) {
+	var repo models.RepositoryResponse
 	var name, description, localPath sql.NullString
-
-	err := s.db.QueryRow(`
-		SELECT id, full_name, name, description, language, stars, forks,
-		       quality_score, download_status, local_path, created_at, updated_at
-		FROM repositories WHERE id = $1
-	`, id).Scan(
+	err := row.Scan(append([]interface{}{
 		&repo.ID, &repo.FullName, &name, &description,
 		&repo.Language, &repo.Stars, &repo.Forks,
 		&repo.QualityScore, &repo.DownloadStatus, &localPath,
 		&repo.CreatedAt, &repo.UpdatedAt,
-	)
+	}, extra...)...)
+	repo.Name = name.String
+	repo.Description = description.String
+	repo.LocalPath = localPath.String
+	return repo, err
+}
 
+// handleGetRepository returns a single repository by ID
+func (s *Server) handleGetRepository(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["id"]
+
+	repo, err := scanRepository(s.db.QueryRow("SELECT "+repositoryColumns+" FROM repositories WHERE id = $1", id))
 	if err == sql.ErrNoRows {
 		http.Error(w, "Repository not found", http.StatusNotFound)
 		return
@@ -242,120 +528,56 @@ func (s *Server) handleGetRepository(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if name.Valid {
-		repo.Name = name.String
-	}
-	if description.Valid {
-		repo.Description = description.String
-	}
-	if localPath.Valid {
-		repo.LocalPath = localPath.String
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(repo)
 }
 
-// handleSearchRepositories searches repositories by query
-func (s *Server) handleSearchRepositories(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query().Get("q")
-	if q == "" {
-		http.Error(w, "Query parameter 'q' is required", http.StatusBadRequest)
-		return
-	}
-
-	language := r.URL.Query().Get("language")
-	minStars, _ := strconv.Atoi(r.URL.Query().Get("min_stars"))
-
-	// Build SQL query
-	query := `
-		SELECT id, full_name, name, description, language, stars, forks,
-		       quality_score, download_status
-		FROM repositories
-		WHERE (full_name ILIKE $1 OR description ILIKE $1)
-	`
-	args := []interface{}{"%" + q + "%"}
-
-	if language != "" {
-		query += " AND language = $2"
-		args = append(args, language)
-	}
-
-	if minStars > 0 {
-		query += fmt.Sprintf(" AND stars >= $%d", len(args)+1)
-		args = append(args, minStars)
-	}
-
-	query += " ORDER BY stars DESC LIMIT 50"
-
-	rows, err := s.db.Query(query, args...)
+// handleRepositoryStats returns repository statistics, optionally limited
+// by language, min_stars and downloaded_only
+func (s *Server) handleRepositoryStats(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRepoFilter(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer rows.Close()
-
-	var repos []Repository
-	for rows.Next() {
-		var repo Repository
-		var name, description sql.NullString
-		err := rows.Scan(
-			&repo.ID, &repo.FullName, &name, &description,
-			&repo.Language, &repo.Stars, &repo.Forks,
-			&repo.QualityScore, &repo.DownloadStatus,
-		)
-		if err != nil {
-			continue
-		}
-
-		if name.Valid {
-			repo.Name = name.String
-		}
-		if description.Valid {
-			repo.Description = description.String
-		}
-
-		repos = append(repos, repo)
-	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"results": repos,
-		"count":   len(repos),
-	})
-}
-
-// handleRepositoryStats returns overall repository statistics
-func (s *Server) handleRepositoryStats(w http.ResponseWriter, r *http.Request) {
 	stats := make(map[string]interface{})
+	stats["filters"] = filter
 
 	// Total repositories
 	var total int
-	s.db.QueryRow("SELECT COUNT(*) FROM repositories").Scan(&total)
+	where, args := filter.where()
+	s.db.QueryRow("SELECT COUNT(*) FROM repositories"+where, args...).Scan(&total)
 	stats["total"] = total
 
 	// Downloaded count
 	var downloaded int
-	s.db.QueryRow("SELECT COUNT(*) FROM repositories WHERE download_status = 'downloaded'").Scan(&downloaded)
+	where, args = filter.where("download_status = 'downloaded'")
+	s.db.QueryRow("SELECT COUNT(*) FROM repositories"+where, args...).Scan(&downloaded)
 	stats["downloaded"] = downloaded
 
 	// Average quality score
-	var avgQuality float64
-	s.db.QueryRow("SELECT AVG(quality_score) FROM repositories WHERE quality_score > 0").Scan(&avgQuality)
-	stats["avg_quality_score"] = avgQuality
+	var avgQuality sql.NullFloat64
+	where, args = filter.where("quality_score > 0")
+	s.db.QueryRow("SELECT AVG(quality_score) FROM repositories"+where, args...).Scan(&avgQuality)
+	stats["avg_quality_score"] = avgQuality.Float64
 
 	// Top languages
-	rows, _ := s.db.Query(`
+	where, args = filter.where("language IS NOT NULL", "language != ''")
+	rows, err := s.db.Query(`
 		SELECT language, COUNT(*) as count
-		FROM repositories
-		WHERE language IS NOT NULL AND language != ''
+		FROM repositories`+where+`
 		GROUP BY language
 		ORDER BY count DESC
 		LIMIT 10
-	`)
+	`, args...)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	defer rows.Close()
 
-	var languages []map[string]interface{}
+	languages := []map[string]interface{}{}
 	for rows.Next() {
 		var lang string
 		var count int
@@ -417,10 +639,15 @@ func (s *Server) handleLanguageStats(w http.ResponseWriter, r *http.Request) {
 	stats["count"] = count
 
 	// Stars statistics
+	var avgStars sql.NullFloat64
+	var maxStars, minStars sql.NullInt64
 	s.db.QueryRow(`
 		SELECT AVG(stars), MAX(stars), MIN(stars)
 		FROM repositories WHERE language = $1
-	`, language).Scan(&stats["avg_stars"], &stats["max_stars"], &stats["min_stars"])
+	`, language).Scan(&avgStars, &maxStars, &minStars)
+	stats["avg_stars"] = avgStars.Float64
+	stats["max_stars"] = maxStars.Int64
+	stats["min_stars"] = minStars.Int64
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
@@ -446,9 +673,9 @@ func (s *Server) handleTopQualityRepos(w http.ResponseWriter, r *http.Request) {
 	}
 	defer rows.Close()
 
-	var repos []Repository
+	var repos []models.RepositoryResponse
 	for rows.Next() {
-		var repo Repository
+		var repo models.RepositoryResponse
 		var name sql.NullString
 		rows.Scan(&repo.ID, &repo.FullName, &name, &repo.Language,
 			&repo.Stars, &repo.Forks, &repo.QualityScore)
@@ -462,8 +689,16 @@ func (s *Server) handleTopQualityRepos(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(repos)
 }
 
-// handleQualityDistribution returns quality score distribution
+// handleQualityDistribution returns the quality score distribution,
+// optionally limited by language, min_stars and downloaded_only
 func (s *Server) handleQualityDistribution(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseRepoFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	where, args := filter.where("quality_score > 0")
 	rows, err := s.db.Query(`
 		SELECT
 			CASE
@@ -474,18 +709,17 @@ func (s *Server) handleQualityDistribution(w http.ResponseWriter, r *http.Reques
 				ELSE '0-59'
 			END as range,
 			COUNT(*) as count
-		FROM repositories
-		WHERE quality_score > 0
+		FROM repositories`+where+`
 		GROUP BY range
 		ORDER BY range DESC
-	`)
+	`, args...)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var distribution []map[string]interface{}
+	distribution := []map[string]interface{}{}
 	for rows.Next() {
 		var rangeStr string
 		var count int
@@ -497,7 +731,10 @@ func (s *Server) handleQualityDistribution(w http.ResponseWriter, r *http.Reques
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(distribution)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"filters":      filter,
+		"distribution": distribution,
+	})
 }
 
 // handleSwaggerUI serves the Swagger UI HTML page
@@ -543,26 +780,6 @@ func (s *Server) handleSwaggerUI(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(html))
 }
 
-// handleOpenAPISpec serves the OpenAPI specification file
-func (s *Server) handleOpenAPISpec(w http.ResponseWriter, r *http.Request) {
-	// Try to find openapi.yaml in the api directory
-	specPath := filepath.Join("api", "openapi.yaml")
-
-	// If not found, try project root
-	if _, err := os.Stat(specPath); os.IsNotExist(err) {
-		specPath = "openapi.yaml"
-	}
-
-	data, err := os.ReadFile(specPath)
-	if err != nil {
-		http.Error(w, "OpenAPI specification not found", http.StatusNotFound)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/x-yaml")
-	w.Write(data)
-}
-
 // Middleware functions
 
 func corsMiddleware(next http.Handler) http.Handler {
@@ -588,8 +805,11 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// Close closes all connections
+// Close cancels running exports and closes all connections
 func (s *Server) Close() error {
+	if s.exports != nil {
+		s.exports.stop()
+	}
 	if s.db != nil {
 		return s.db.Close()
 	}
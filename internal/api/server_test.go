@@ -1,24 +1,36 @@
 package api
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"codelupe/internal/models"
+	"codelupe/pkg/contentstore"
+
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/gorilla/mux"
 )
 
 func setupMockServer(t *testing.T) (*Server, sqlmock.Sqlmock) {
-	db, mock, err := sqlmock.New()
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	if err != nil {
 		t.Fatalf("Failed to create mock db: %v", err)
 	}
 
+	content, err := contentstore.New(contentstore.ModeInline, "")
+	if err != nil {
+		t.Fatalf("Failed to create content store: %v", err)
+	}
+
 	server := &Server{
 		config: Config{
 			Port:             "8080",
@@ -29,6 +41,7 @@ func setupMockServer(t *testing.T) (*Server, sqlmock.Sqlmock) {
 		router:   mux.NewRouter(),
 		db:       db,
 		esClient: nil, // Would need ES mock for full tests
+		content:  content,
 	}
 
 	server.setupRoutes()
@@ -118,13 +131,9 @@ func TestHandleListRepositories(t *testing.T) {
 	defer server.db.Close()
 
 	// Mock repository query
-	rows := sqlmock.NewRows([]string{
-		"id", "full_name", "name", "description", "language",
-		"stars", "forks", "quality_score", "download_status",
-		"created_at", "updated_at",
-	}).AddRow(
+	rows := sqlmock.NewRows(repositoryColumnNames).AddRow(
 		1, "rust-lang/rust", "rust", "A safe, concurrent language",
-		"Rust", 50000, 10000, 95, "downloaded",
+		"Rust", 50000, 10000, 95, "downloaded", "/app/repos/rust",
 		time.Now(), time.Now(),
 	)
 
@@ -188,7 +197,7 @@ func TestHandleGetRepository(t *testing.T) {
 		t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
 	}
 
-	var repo Repository
+	var repo models.RepositoryResponse
 	if err := json.NewDecoder(w.Body).Decode(&repo); err != nil {
 		t.Fatalf("Failed to decode response: %v", err)
 	}
@@ -221,95 +230,104 @@ func TestHandleGetRepository_NotFound(t *testing.T) {
 	}
 }
 
-func TestHandleSearchRepositories(t *testing.T) {
-	server, mock := setupMockServer(t)
-	defer server.db.Close()
-
-	rows := sqlmock.NewRows([]string{
-		"id", "full_name", "name", "description", "language",
-		"stars", "forks", "quality_score", "download_status",
-	}).AddRow(
-		1, "rust-lang/rust", "rust", "A safe language",
-		"Rust", 50000, 10000, 95, "downloaded",
-	)
-
-	mock.ExpectQuery("SELECT id, full_name").
-		WithArgs("%rust%").
-		WillReturnRows(rows)
-
-	req := httptest.NewRequest("GET", "/api/v1/repositories/search?q=rust", nil)
-	w := httptest.NewRecorder()
-
-	server.handleSearchRepositories(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
-	}
-
-	var response map[string]interface{}
-	json.NewDecoder(w.Body).Decode(&response)
-
-	if response["count"] != float64(1) {
-		t.Errorf("count = %v, want 1", response["count"])
+func TestHandleRepositoryStats(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		where string // expected filter conditions, as a regexp
+		args  []driver.Value
+		want  repoFilter
+	}{
+		{"unfiltered", "", "", nil, repoFilter{}},
+		{
+			"filtered", "?language=Rust&min_stars=100&downloaded_only=true",
+			" AND language = \\$1 AND stars >= \\$2 AND download_status = 'downloaded'",
+			[]driver.Value{"Rust", 100},
+			repoFilter{Language: "Rust", MinStars: 100, DownloadedOnly: true},
+		},
 	}
-}
-
-func TestHandleSearchRepositories_MissingQuery(t *testing.T) {
-	server, mock := setupMockServer(t)
-	defer server.db.Close()
 
-	req := httptest.NewRequest("GET", "/api/v1/repositories/search", nil)
-	w := httptest.NewRecorder()
-
-	server.handleSearchRepositories(w, req)
-
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Status code = %d, want %d", w.Code, http.StatusBadRequest)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupMockServer(t)
+			defer server.db.Close()
+
+			// Mock stats queries
+			totalWhere := ""
+			if tt.where != "" {
+				totalWhere = " WHERE" + strings.TrimPrefix(tt.where, " AND")
+			}
+			totalRows := sqlmock.NewRows([]string{"count"}).AddRow(100)
+			mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM repositories" + totalWhere + "$").
+				WithArgs(tt.args...).WillReturnRows(totalRows)
+
+			downloadedRows := sqlmock.NewRows([]string{"count"}).AddRow(80)
+			mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM repositories WHERE download_status = 'downloaded'" + tt.where + "$").
+				WithArgs(tt.args...).WillReturnRows(downloadedRows)
+
+			avgQualityRows := sqlmock.NewRows([]string{"avg"}).AddRow(75.5)
+			mock.ExpectQuery("SELECT AVG\\(quality_score\\) FROM repositories WHERE quality_score > 0" + tt.where + "$").
+				WithArgs(tt.args...).WillReturnRows(avgQualityRows)
+
+			langRows := sqlmock.NewRows([]string{"language", "count"}).
+				AddRow("Rust", 30).
+				AddRow("Go", 25)
+			mock.ExpectQuery("SELECT language, COUNT.*WHERE language IS NOT NULL AND language != ''" + tt.where + "\\s+GROUP BY").
+				WithArgs(tt.args...).WillReturnRows(langRows)
+
+			req := httptest.NewRequest("GET", "/api/v1/repositories/stats"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.handleRepositoryStats(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var response struct {
+				Total           int        `json:"total"`
+				Downloaded      int        `json:"downloaded"`
+				AvgQualityScore float64    `json:"avg_quality_score"`
+				Filters         repoFilter `json:"filters"`
+			}
+			json.NewDecoder(w.Body).Decode(&response)
+
+			if response.Total != 100 {
+				t.Errorf("total = %v, want 100", response.Total)
+			}
+
+			if response.Downloaded != 80 {
+				t.Errorf("downloaded = %v, want 80", response.Downloaded)
+			}
+
+			if response.AvgQualityScore != 75.5 {
+				t.Errorf("avg_quality_score = %v, want 75.5", response.AvgQualityScore)
+			}
+
+			if response.Filters != tt.want {
+				t.Errorf("filters = %+v, want %+v", response.Filters, tt.want)
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
 	}
 }
 
-func TestHandleRepositoryStats(t *testing.T) {
-	server, mock := setupMockServer(t)
-	defer server.db.Close()
-
-	// Mock stats queries
-	totalRows := sqlmock.NewRows([]string{"count"}).AddRow(100)
-	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM repositories").WillReturnRows(totalRows)
-
-	downloadedRows := sqlmock.NewRows([]string{"count"}).AddRow(80)
-	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM repositories WHERE download_status").
-		WillReturnRows(downloadedRows)
-
-	avgQualityRows := sqlmock.NewRows([]string{"avg"}).AddRow(75.5)
-	mock.ExpectQuery("SELECT AVG\\(quality_score\\)").WillReturnRows(avgQualityRows)
-
-	langRows := sqlmock.NewRows([]string{"language", "count"}).
-		AddRow("Rust", 30).
-		AddRow("Go", 25)
-	mock.ExpectQuery("SELECT language, COUNT").WillReturnRows(langRows)
-
-	req := httptest.NewRequest("GET", "/api/v1/repositories/stats", nil)
-	w := httptest.NewRecorder()
-
-	server.handleRepositoryStats(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
-	}
+func TestHandleRepositoryStats_InvalidFilter(t *testing.T) {
+	for _, query := range []string{"min_stars=lots", "min_stars=-1", "downloaded_only=maybe"} {
+		server, _ := setupMockServer(t)
 
-	var response map[string]interface{}
-	json.NewDecoder(w.Body).Decode(&response)
-
-	if response["total"] != float64(100) {
-		t.Errorf("total = %v, want 100", response["total"])
-	}
+		req := httptest.NewRequest("GET", "/api/v1/repositories/stats?"+query, nil)
+		w := httptest.NewRecorder()
 
-	if response["downloaded"] != float64(80) {
-		t.Errorf("downloaded = %v, want 80", response["downloaded"])
-	}
+		server.handleRepositoryStats(w, req)
 
-	if response["avg_quality_score"] != 75.5 {
-		t.Errorf("avg_quality_score = %v, want 75.5", response["avg_quality_score"])
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status code = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+		server.db.Close()
 	}
 }
 
@@ -335,7 +353,7 @@ func TestHandleTopQualityRepos(t *testing.T) {
 		t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
 	}
 
-	var repos []Repository
+	var repos []models.RepositoryResponse
 	json.NewDecoder(w.Body).Decode(&repos)
 
 	if len(repos) != 2 {
@@ -348,30 +366,57 @@ func TestHandleTopQualityRepos(t *testing.T) {
 }
 
 func TestHandleQualityDistribution(t *testing.T) {
-	server, mock := setupMockServer(t)
-	defer server.db.Close()
-
-	rows := sqlmock.NewRows([]string{"range", "count"}).
-		AddRow("90-100", 10).
-		AddRow("80-89", 25).
-		AddRow("70-79", 40)
-
-	mock.ExpectQuery("SELECT").WillReturnRows(rows)
-
-	req := httptest.NewRequest("GET", "/api/v1/quality/distribution", nil)
-	w := httptest.NewRecorder()
-
-	server.handleQualityDistribution(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
-	}
-
-	var distribution []map[string]interface{}
-	json.NewDecoder(w.Body).Decode(&distribution)
-
-	if len(distribution) != 3 {
-		t.Errorf("len(distribution) = %d, want 3", len(distribution))
+	tests := []struct {
+		name  string
+		query string
+		where string
+		args  []driver.Value
+		want  repoFilter
+	}{
+		{"unfiltered", "", "", nil, repoFilter{}},
+		{"by language", "?language=Python", " AND language = \\$1", []driver.Value{"Python"}, repoFilter{Language: "Python"}},
+		{"by stars", "?min_stars=100", " AND stars >= \\$1", []driver.Value{100}, repoFilter{MinStars: 100}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, mock := setupMockServer(t)
+			defer server.db.Close()
+
+			rows := sqlmock.NewRows([]string{"range", "count"}).
+				AddRow("90-100", 10).
+				AddRow("80-89", 25).
+				AddRow("70-79", 40)
+
+			mock.ExpectQuery("FROM repositories WHERE quality_score > 0" + tt.where + "\\s+GROUP BY range").
+				WithArgs(tt.args...).
+				WillReturnRows(rows)
+
+			req := httptest.NewRequest("GET", "/api/v1/quality/distribution"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			server.handleQualityDistribution(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Status code = %d, want %d", w.Code, http.StatusOK)
+			}
+
+			var response struct {
+				Filters      repoFilter               `json:"filters"`
+				Distribution []map[string]interface{} `json:"distribution"`
+			}
+			json.NewDecoder(w.Body).Decode(&response)
+
+			if len(response.Distribution) != 3 {
+				t.Errorf("len(distribution) = %d, want 3", len(response.Distribution))
+			}
+			if response.Filters != tt.want {
+				t.Errorf("filters = %+v, want %+v", response.Filters, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
 	}
 }
 
@@ -410,10 +455,11 @@ func TestLoggingMiddleware(t *testing.T) {
 }
 
 func TestServerClose(t *testing.T) {
-	db, _, err := sqlmock.New()
+	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("Failed to create mock db: %v", err)
 	}
+	mock.ExpectClose()
 
 	server := &Server{db: db}
 
@@ -422,8 +468,89 @@ func TestServerClose(t *testing.T) {
 	}
 }
 
+func TestServerShutdown_DrainsInFlightRequests(t *testing.T) {
+	server, mock := setupMockServer(t)
+	mock.ExpectClose()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server.router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("done"))
+	})
+	server.httpServer = server.newHTTPServer()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.httpServer.Serve(ln) }()
+
+	type result struct {
+		body string
+		err  error
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err != nil {
+			resultCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		resultCh <- result{string(body), err}
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	// Shutdown must wait for the request rather than cut it off
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown() returned %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	res := <-resultCh
+	if res.err != nil || res.body != "done" {
+		t.Errorf("in-flight request = %q, %v; want it to complete", res.body, res.err)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Shutdown() error = %v, want nil", err)
+	}
+	if err := <-serveErr; err != http.ErrServerClosed {
+		t.Errorf("Serve() error = %v, want %v", err, http.ErrServerClosed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestNewServer_Defaults(t *testing.T) {
+	server := NewServer(Config{Port: "9090", WriteTimeout: 10 * time.Second})
+
+	if server.httpServer.Addr != ":9090" {
+		t.Errorf("Addr = %q, want %q", server.httpServer.Addr, ":9090")
+	}
+	if server.httpServer.WriteTimeout != 10*time.Second {
+		t.Errorf("WriteTimeout = %v, want the configured 10s", server.httpServer.WriteTimeout)
+	}
+	if server.httpServer.ReadHeaderTimeout != defaultReadHeaderTimeout {
+		t.Errorf("ReadHeaderTimeout = %v, want default %v", server.httpServer.ReadHeaderTimeout, defaultReadHeaderTimeout)
+	}
+}
+
 func BenchmarkHandleHealth(b *testing.B) {
-	db, mock, _ := sqlmock.New()
+	db, mock, _ := sqlmock.New(sqlmock.MonitorPingsOption(true))
 	defer db.Close()
 
 	server := &Server{db: db}
@@ -0,0 +1,390 @@
+// Package dataset computes statistics over the processed dataset: overall
+// totals, per-language and per-license breakdowns, quality tiers and recent
+// processing activity. The dataset analyzer prints them as a report and the
+// API serves them as JSON.
+package dataset
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LanguageStats represents statistics for a programming language
+type LanguageStats struct {
+	Language       string     `json:"language"`
+	FileCount      int64      `json:"file_count"`
+	TotalSize      int64      `json:"total_size"`
+	AvgSize        float64    `json:"avg_size"`
+	AvgQuality     float64    `json:"avg_quality"`
+	AvgLines       float64    `json:"avg_lines"`
+	TopRepos       []RepoStat `json:"top_repos"`
+	Percentage     float64    `json:"percentage"`
+	SizePercentage float64    `json:"size_percentage"`
+}
+
+// RepoStat represents repository statistics for a language
+type RepoStat struct {
+	RepoName  string `json:"repo_name"`
+	FileCount int64  `json:"file_count"`
+	TotalSize int64  `json:"total_size"`
+}
+
+// LicenseStats represents file counts for one repository license
+type LicenseStats struct {
+	License    string  `json:"license"`
+	FileCount  int64   `json:"file_count"`
+	RepoCount  int64   `json:"repo_count"`
+	TotalSize  int64   `json:"total_size"`
+	Percentage float64 `json:"percentage"`
+}
+
+// OverallStats represents overall dataset statistics
+type OverallStats struct {
+	TotalFiles      int64           `json:"total_files"`
+	TotalSize       int64           `json:"total_size"`
+	TotalRepos      int64           `json:"total_repos"`
+	AvgQuality      float64         `json:"avg_quality"`
+	AvgFileSize     float64         `json:"avg_file_size"`
+	AvgLinesPerFile float64         `json:"avg_lines_per_file"`
+	ProcessingTime  time.Duration   `json:"-"`
+	Languages       []LanguageStats `json:"languages"`
+	Licenses        []LicenseStats  `json:"licenses"`
+}
+
+// QualityTier counts files in one quality score band
+type QualityTier struct {
+	Tier       string  `json:"tier"`
+	FileCount  int64   `json:"file_count"`
+	Percentage float64 `json:"percentage"`
+}
+
+// Report is the full analysis, as written by the dataset analyzer with
+// --format=json and served by /api/v1/dataset/overview
+type Report struct {
+	GeneratedAt           time.Time     `json:"generated_at"`
+	ProcessingTimeSeconds float64       `json:"processing_time_seconds"`
+	Overall               *OverallStats `json:"overall"`
+	QualityDistribution   []QualityTier `json:"quality_distribution"`
+}
+
+// ActivityBucket counts the files of one language processed in one period
+type ActivityBucket struct {
+	Period         time.Time `json:"period"`
+	Language       string    `json:"language"`
+	FilesProcessed int64     `json:"files_processed"`
+	BytesProcessed int64     `json:"bytes_processed"`
+}
+
+// Activity granularities accepted by GetRecentActivity
+const (
+	GranularityHour = "hour"
+	GranularityDay  = "day"
+)
+
+// QualityTiers are the GetQualityDistribution bands, best first
+var QualityTiers = []string{
+	"Excellent (90-100)",
+	"Good (80-89)",
+	"Fair (70-79)",
+	"Poor (60-69)",
+	"Very Poor (0-59)",
+}
+
+// Analyzer aggregates the processed_files table. Every query scans the
+// whole table, so callers serving requests should go through a Cache.
+type Analyzer struct {
+	db *sql.DB
+}
+
+// NewAnalyzer returns an Analyzer reading from db
+func NewAnalyzer(db *sql.DB) *Analyzer {
+	return &Analyzer{db: db}
+}
+
+// GetOverallStats returns dataset-wide totals and averages
+func (a *Analyzer) GetOverallStats() (*OverallStats, error) {
+	stats := &OverallStats{}
+
+	// Get overall statistics
+	err := a.db.QueryRow(`
+		SELECT 
+			COUNT(*) as total_files,
+			COALESCE(SUM(size), 0) as total_size,
+			COUNT(DISTINCT repo_name) as total_repos,
+			COALESCE(AVG(quality_score), 0) as avg_quality,
+			COALESCE(AVG(size), 0) as avg_file_size,
+			COALESCE(AVG(lines), 0) as avg_lines_per_file
+		FROM processed_files
+	`).Scan(
+		&stats.TotalFiles,
+		&stats.TotalSize,
+		&stats.TotalRepos,
+		&stats.AvgQuality,
+		&stats.AvgFileSize,
+		&stats.AvgLinesPerFile,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get overall stats: %w", err)
+	}
+
+	// Get processing time range
+	var startTime, endTime sql.NullTime
+	err = a.db.QueryRow(`
+		SELECT 
+			MIN(processed_at) as start_time,
+			MAX(processed_at) as end_time
+		FROM processed_files
+	`).Scan(&startTime, &endTime)
+	if err == nil && startTime.Valid && endTime.Valid {
+		stats.ProcessingTime = endTime.Time.Sub(startTime.Time)
+	}
+
+	return stats, nil
+}
+
+// GetLanguageStats breaks processed files down by language, each with its
+// top repositories. totalFiles and totalSize give the percentages.
+func (a *Analyzer) GetLanguageStats(totalFiles, totalSize int64) ([]LanguageStats, error) {
+	// Get language statistics
+	rows, err := a.db.Query(`
+		SELECT 
+			language,
+			COUNT(*) as file_count,
+			COALESCE(SUM(size), 0) as total_size,
+			COALESCE(AVG(size), 0) as avg_size,
+			COALESCE(AVG(quality_score), 0) as avg_quality,
+			COALESCE(AVG(lines), 0) as avg_lines
+		FROM processed_files
+		GROUP BY language
+		ORDER BY file_count DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get language stats: %w", err)
+	}
+	defer rows.Close()
+
+	var languages []LanguageStats
+	for rows.Next() {
+		var lang LanguageStats
+		err := rows.Scan(
+			&lang.Language,
+			&lang.FileCount,
+			&lang.TotalSize,
+			&lang.AvgSize,
+			&lang.AvgQuality,
+			&lang.AvgLines,
+		)
+		if err != nil {
+			continue
+		}
+
+		// Calculate percentages
+		if totalFiles > 0 {
+			lang.Percentage = float64(lang.FileCount) / float64(totalFiles) * 100
+		}
+		if totalSize > 0 {
+			lang.SizePercentage = float64(lang.TotalSize) / float64(totalSize) * 100
+		}
+
+		// Get top repositories for this language
+		lang.TopRepos = a.getTopReposForLanguage(lang.Language, 5)
+
+		languages = append(languages, lang)
+	}
+
+	return languages, nil
+}
+
+// getTopReposForLanguage returns the repositories with the most files in
+// language
+func (a *Analyzer) getTopReposForLanguage(language string, limit int) []RepoStat {
+	rows, err := a.db.Query(`
+		SELECT 
+			repo_name,
+			COUNT(*) as file_count,
+			COALESCE(SUM(size), 0) as total_size
+		FROM processed_files
+		WHERE language = $1
+		GROUP BY repo_name
+		ORDER BY file_count DESC
+		LIMIT $2
+	`, language, limit)
+	if err != nil {
+		return nil
+	}
+	defer rows.Close()
+
+	var repos []RepoStat
+	for rows.Next() {
+		var repo RepoStat
+		if err := rows.Scan(&repo.RepoName, &repo.FileCount, &repo.TotalSize); err != nil {
+			continue
+		}
+		repos = append(repos, repo)
+	}
+
+	return repos
+}
+
+// GetLicenseStats breaks processed files down by the license the downloader
+// recorded for their repository. Files whose repository has no recorded
+// license are reported as "unknown".
+func (a *Analyzer) GetLicenseStats(totalFiles int64) ([]LicenseStats, error) {
+	rows, err := a.db.Query(`
+		SELECT 
+			COALESCE(NULLIF(r.license_key, ''), 'unknown') as license,
+			COUNT(*) as file_count,
+			COUNT(DISTINCT f.repo_name) as repo_count,
+			COALESCE(SUM(f.size), 0) as total_size
+		FROM processed_files f
+		LEFT JOIN processing_jobs j ON j.id = f.job_id
+		LEFT JOIN repositories r ON r.local_path = j.repo_path
+		GROUP BY 1
+		ORDER BY file_count DESC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get license stats: %w", err)
+	}
+	defer rows.Close()
+
+	var licenses []LicenseStats
+	for rows.Next() {
+		var lic LicenseStats
+		if err := rows.Scan(&lic.License, &lic.FileCount, &lic.RepoCount, &lic.TotalSize); err != nil {
+			continue
+		}
+		if totalFiles > 0 {
+			lic.Percentage = float64(lic.FileCount) / float64(totalFiles) * 100
+		}
+		licenses = append(licenses, lic)
+	}
+
+	return licenses, nil
+}
+
+// GetQualityDistribution counts files per QualityTiers band
+func (a *Analyzer) GetQualityDistribution() (map[string]int64, error) {
+	rows, err := a.db.Query(`
+		SELECT 
+			CASE 
+				WHEN quality_score >= 90 THEN 'Excellent (90-100)'
+				WHEN quality_score >= 80 THEN 'Good (80-89)'
+				WHEN quality_score >= 70 THEN 'Fair (70-79)'
+				WHEN quality_score >= 60 THEN 'Poor (60-69)'
+				ELSE 'Very Poor (0-59)'
+			END as quality_tier,
+			COUNT(*) as file_count
+		FROM processed_files
+		GROUP BY quality_tier
+		ORDER BY 
+			CASE 
+				WHEN quality_score >= 90 THEN 1
+				WHEN quality_score >= 80 THEN 2
+				WHEN quality_score >= 70 THEN 3
+				WHEN quality_score >= 60 THEN 4
+				ELSE 5
+			END
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	distribution := make(map[string]int64)
+	for rows.Next() {
+		var tier string
+		var count int64
+		if err := rows.Scan(&tier, &count); err != nil {
+			continue
+		}
+		distribution[tier] = count
+	}
+
+	return distribution, nil
+}
+
+// GetRecentActivity buckets files processed in the last hours by period and
+// language, newest first. granularity is "hour" or "day"; a non-empty
+// language restricts the result to that language.
+func (a *Analyzer) GetRecentActivity(hours int, granularity, language string) ([]ActivityBucket, error) {
+	switch granularity {
+	case GranularityHour, GranularityDay:
+	default:
+		return nil, fmt.Errorf("invalid granularity %q: want %q or %q", granularity, GranularityHour, GranularityDay)
+	}
+
+	query := `
+		SELECT 
+			DATE_TRUNC($1, processed_at) as period,
+			language,
+			COUNT(*) as files_processed,
+			COALESCE(SUM(size), 0) as bytes_processed
+		FROM processed_files
+		WHERE processed_at >= NOW() - make_interval(hours => $2)`
+	args := []interface{}{granularity, hours}
+	if language != "" {
+		query += `
+		  AND language = $3`
+		args = append(args, language)
+	}
+	query += `
+		GROUP BY period, language
+		ORDER BY period DESC, files_processed DESC
+		LIMIT 20
+	`
+
+	rows, err := a.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var activity []ActivityBucket
+	for rows.Next() {
+		var bucket ActivityBucket
+		if err := rows.Scan(&bucket.Period, &bucket.Language, &bucket.FilesProcessed, &bucket.BytesProcessed); err != nil {
+			continue
+		}
+		activity = append(activity, bucket)
+	}
+
+	return activity, rows.Err()
+}
+
+// BuildReport gathers everything the report covers
+func (a *Analyzer) BuildReport() (*Report, error) {
+	stats, err := a.GetOverallStats()
+	if err != nil {
+		return nil, err
+	}
+	if stats.Languages, err = a.GetLanguageStats(stats.TotalFiles, stats.TotalSize); err != nil {
+		return nil, err
+	}
+	if stats.Licenses, err = a.GetLicenseStats(stats.TotalFiles); err != nil {
+		return nil, err
+	}
+	dist, err := a.GetQualityDistribution()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quality distribution: %w", err)
+	}
+
+	report := &Report{
+		GeneratedAt:           time.Now().UTC(),
+		ProcessingTimeSeconds: stats.ProcessingTime.Seconds(),
+		Overall:               stats,
+		QualityDistribution:   []QualityTier{},
+	}
+	for _, tier := range QualityTiers {
+		count, ok := dist[tier]
+		if !ok {
+			continue
+		}
+		qt := QualityTier{Tier: tier, FileCount: count}
+		if stats.TotalFiles > 0 {
+			qt.Percentage = float64(count) / float64(stats.TotalFiles) * 100
+		}
+		report.QualityDistribution = append(report.QualityDistribution, qt)
+	}
+	return report, nil
+}
@@ -0,0 +1,110 @@
+package dataset
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func newMockAnalyzer(t *testing.T) (*Analyzer, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewAnalyzer(db), mock
+}
+
+func TestGetLanguageStats_EmptyDataset(t *testing.T) {
+	a, mock := newMockAnalyzer(t)
+	mock.ExpectQuery("GROUP BY language").
+		WillReturnRows(sqlmock.NewRows([]string{"language", "file_count", "total_size",
+			"avg_size", "avg_quality", "avg_lines"}).
+			AddRow("Go", 0, 0, 0.0, 0.0, 0.0))
+	mock.ExpectQuery("WHERE language = \\$1").WithArgs("Go", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"repo_name", "file_count", "total_size"}))
+
+	languages, err := a.GetLanguageStats(0, 0)
+	if err != nil {
+		t.Fatalf("GetLanguageStats() error = %v", err)
+	}
+	// NaN percentages would make the stats impossible to encode as JSON
+	if languages[0].Percentage != 0 || languages[0].SizePercentage != 0 {
+		t.Errorf("percentages = %v, %v; want 0 with no files", languages[0].Percentage, languages[0].SizePercentage)
+	}
+}
+
+func TestGetRecentActivity(t *testing.T) {
+	day := time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		hours       int
+		granularity string
+		language    string
+		args        []driver.Value
+		want        []ActivityBucket
+	}{
+		{
+			name:        "hourly, all languages",
+			hours:       24,
+			granularity: GranularityHour,
+			args:        []driver.Value{"hour", 24},
+			want: []ActivityBucket{
+				{Period: day.Add(13 * time.Hour), Language: "Go", FilesProcessed: 40, BytesProcessed: 8000},
+				{Period: day.Add(12 * time.Hour), Language: "Python", FilesProcessed: 10, BytesProcessed: 2000},
+			},
+		},
+		{
+			name:        "daily, one language",
+			hours:       168,
+			granularity: GranularityDay,
+			language:    "Go",
+			args:        []driver.Value{"day", 168, "Go"},
+			want: []ActivityBucket{
+				{Period: day, Language: "Go", FilesProcessed: 400, BytesProcessed: 80000},
+				{Period: day.AddDate(0, 0, -1), Language: "Go", FilesProcessed: 250, BytesProcessed: 50000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, mock := newMockAnalyzer(t)
+
+			rows := sqlmock.NewRows([]string{"period", "language", "files_processed", "bytes_processed"})
+			for _, b := range tt.want {
+				rows.AddRow(b.Period, b.Language, b.FilesProcessed, b.BytesProcessed)
+			}
+			mock.ExpectQuery("DATE_TRUNC\\(\\$1, processed_at\\).*make_interval\\(hours => \\$2\\)").
+				WithArgs(tt.args...).
+				WillReturnRows(rows)
+
+			got, err := a.GetRecentActivity(tt.hours, tt.granularity, tt.language)
+			if err != nil {
+				t.Fatalf("GetRecentActivity() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetRecentActivity() = %+v, want %+v", got, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetRecentActivity_InvalidGranularity(t *testing.T) {
+	a, mock := newMockAnalyzer(t)
+
+	if _, err := a.GetRecentActivity(24, "week", ""); err == nil {
+		t.Error("GetRecentActivity() with granularity week should fail")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("no query should run: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package dataset
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// cacheEntry is one cached result
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+// Cache memoizes Analyzer results for a TTL. The queries aggregate the
+// whole processed_files table, so serving them per request would be far too
+// slow; Invalidate drops everything when fresh numbers are needed sooner.
+type Cache struct {
+	analyzer *Analyzer
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// NewCache caches analyzer's results for ttl
+func NewCache(analyzer *Analyzer, ttl time.Duration) *Cache {
+	return &Cache{
+		analyzer: analyzer,
+		ttl:      ttl,
+		now:      time.Now,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// Overview returns the full report
+func (c *Cache) Overview() (*Report, error) {
+	value, err := c.get("overview", func() (interface{}, error) {
+		return c.analyzer.BuildReport()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Report), nil
+}
+
+// Languages returns the per-language breakdown. It comes from the overview,
+// so the two always agree.
+func (c *Cache) Languages() ([]LanguageStats, error) {
+	report, err := c.Overview()
+	if err != nil {
+		return nil, err
+	}
+	return report.Overall.Languages, nil
+}
+
+// Activity returns GetRecentActivity(hours, granularity, language)
+func (c *Cache) Activity(hours int, granularity, language string) ([]ActivityBucket, error) {
+	key := fmt.Sprintf("activity:%d:%s:%s", hours, granularity, language)
+	value, err := c.get(key, func() (interface{}, error) {
+		return c.analyzer.GetRecentActivity(hours, granularity, language)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]ActivityBucket), nil
+}
+
+// Invalidate drops every cached result
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+}
+
+// get returns the cached value for key, calling load when it is missing or
+// expired. Errors are not cached. The lock is held while loading so
+// concurrent requests for a cold cache run the queries once.
+func (c *Cache) get(key string, load func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop expired entries so old activity windows don't pile up
+	for k, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+	return value, nil
+}
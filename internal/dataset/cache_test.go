@@ -0,0 +1,94 @@
+package dataset
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectActivityQuery mocks one GetRecentActivity query returning a bucket
+// of files
+func expectActivityQuery(mock sqlmock.Sqlmock, files int64) {
+	mock.ExpectQuery("DATE_TRUNC").
+		WillReturnRows(sqlmock.NewRows([]string{"period", "language", "files_processed", "bytes_processed"}).
+			AddRow(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), "Go", files, files*100))
+}
+
+func newTestCache(t *testing.T) (*Cache, sqlmock.Sqlmock, func(time.Duration)) {
+	t.Helper()
+	a, mock := newMockAnalyzer(t)
+	cache := NewCache(a, time.Minute)
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return cache, mock, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestCache_ServesUntilExpiry(t *testing.T) {
+	cache, mock, advance := newTestCache(t)
+	expectActivityQuery(mock, 10)
+	expectActivityQuery(mock, 20)
+
+	for i := 0; i < 2; i++ {
+		got, err := cache.Activity(24, GranularityHour, "")
+		if err != nil || got[0].FilesProcessed != 10 {
+			t.Fatalf("Activity() = %v, %v; want the first query's result", got, err)
+		}
+		advance(30 * time.Second)
+	}
+
+	got, err := cache.Activity(24, GranularityHour, "")
+	if err != nil || got[0].FilesProcessed != 20 {
+		t.Errorf("Activity() after TTL = %v, %v; want a fresh query", got, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCache_KeysByParameters(t *testing.T) {
+	cache, mock, _ := newTestCache(t)
+	expectActivityQuery(mock, 10)
+	expectActivityQuery(mock, 20)
+
+	cache.Activity(24, GranularityHour, "")
+	got, _ := cache.Activity(24, GranularityHour, "Go")
+	if got[0].FilesProcessed != 20 {
+		t.Errorf("Activity() for Go = %v, want its own query", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	cache, mock, _ := newTestCache(t)
+	expectActivityQuery(mock, 10)
+	expectActivityQuery(mock, 20)
+
+	cache.Activity(24, GranularityHour, "")
+	cache.Invalidate()
+
+	got, _ := cache.Activity(24, GranularityHour, "")
+	if got[0].FilesProcessed != 20 {
+		t.Errorf("Activity() after Invalidate = %v, want a fresh query", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCache_DoesNotCacheErrors(t *testing.T) {
+	cache, mock, _ := newTestCache(t)
+	mock.ExpectQuery("DATE_TRUNC").WillReturnError(errors.New("connection reset"))
+	expectActivityQuery(mock, 10)
+
+	if _, err := cache.Activity(24, GranularityHour, ""); err == nil {
+		t.Fatal("Activity() should return the query error")
+	}
+	got, err := cache.Activity(24, GranularityHour, "")
+	if err != nil || got[0].FilesProcessed != 10 {
+		t.Errorf("Activity() after an error = %v, %v; want a retry", got, err)
+	}
+}
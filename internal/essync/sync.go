@@ -0,0 +1,339 @@
+// Package essync copies repositories rows into the models.EnrichedRepoIndex
+// Elasticsearch index, so searches can filter on what the pipeline learned
+// after the crawl.
+//
+// Rows are read in (updated_at, full_name) order and the position of the
+// last one indexed is kept in the es_sync_state table, so each Sync only
+// sends the rows changed since the previous one. A row the bulk request
+// fails to index for a retryable reason (429 or 5xx) holds the watermark
+// back: the next Sync starts from it again, and rows already indexed after
+// it are simply upserted twice. A row rejected with any other 4xx, such as
+// a mapping error, would fail the same way every time, so it is recorded
+// in es_sync_failures and the watermark moves past it.
+package essync
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"codelupe/internal/models"
+	"codelupe/pkg/metrics"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/lib/pq"
+)
+
+// defaultBatchSize is how many rows go into one bulk request
+const defaultBatchSize = 500
+
+// Watermark is the position of the last row synced
+type Watermark struct {
+	UpdatedAt time.Time
+	// FullName orders rows updated at the same time
+	FullName string
+}
+
+// Result summarizes a Sync
+type Result struct {
+	// Indexed counts the documents Elasticsearch accepted
+	Indexed int
+	// Rejected counts the documents recorded in es_sync_failures
+	Rejected int
+	// Watermark is where the next Sync starts
+	Watermark Watermark
+}
+
+// BulkError reports the documents a bulk request failed to index for a
+// retryable reason
+type BulkError struct {
+	Failed int
+	// First is the first rejected document and its reason
+	First string
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d documents failed to index, first %s", e.Failed, e.First)
+}
+
+// itemFailure is why one document in a bulk request wasn't indexed
+type itemFailure struct {
+	Status int
+	Reason string
+}
+
+// retryable reports whether sending the document again may succeed: the
+// cluster was overloaded (429) or failed (5xx). Other 4xx are about the
+// document itself.
+func (f itemFailure) retryable() bool {
+	return f.Status == http.StatusTooManyRequests || f.Status < 400 || f.Status >= 500
+}
+
+// Syncer bulk-upserts changed repositories rows into an index
+type Syncer struct {
+	db        *sql.DB
+	es        *elasticsearch.Client
+	index     string
+	batchSize int
+}
+
+// New returns a Syncer writing to models.EnrichedRepoIndex
+func New(db *sql.DB, es *elasticsearch.Client) *Syncer {
+	return &Syncer{db: db, es: es, index: models.EnrichedRepoIndex, batchSize: defaultBatchSize}
+}
+
+// EnsureIndex creates the index with models.EnrichedRepoIndexProperties if
+// it doesn't exist yet
+func (s *Syncer) EnsureIndex(ctx context.Context) error {
+	res, err := esapi.IndicesExistsRequest{Index: []string{s.index}}.Do(ctx, s.es)
+	if err != nil {
+		return fmt.Errorf("failed to check for index %s: %w", s.index, err)
+	}
+	res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+	default:
+		return fmt.Errorf("failed to check for index %s: %s", s.index, res.Status())
+	}
+
+	created, err := esapi.IndicesCreateRequest{
+		Index: s.index,
+		Body:  strings.NewReader(`{"mappings": {"properties": ` + models.EnrichedRepoIndexProperties + `}}`),
+	}.Do(ctx, s.es)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", s.index, err)
+	}
+	defer created.Body.Close()
+	if created.IsError() {
+		return fmt.Errorf("failed to create index %s: %s", s.index, created.Status())
+	}
+	return nil
+}
+
+// Sync indexes every row updated past the watermark, a batch at a time,
+// saving the watermark after each batch. Documents rejected permanently are
+// recorded and skipped. It stops at the first batch with a retryable
+// failure and returns a *BulkError for it.
+func (s *Syncer) Sync(ctx context.Context) (Result, error) {
+	mark, err := s.loadWatermark(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	result := Result{Watermark: mark}
+
+	for {
+		repos, err := s.changedRows(ctx, mark)
+		if err != nil {
+			return result, err
+		}
+		if len(repos) == 0 {
+			return result, nil
+		}
+
+		failures, err := s.bulkIndex(ctx, repos)
+		if err != nil {
+			return result, err
+		}
+
+		// The watermark moves up to the first row that can be retried;
+		// rejected rows are recorded before it moves past them
+		synced := len(repos)
+		var bulkErr *BulkError
+		rejected := 0
+		for i, failure := range failures {
+			switch {
+			case failure == nil:
+			case !failure.retryable():
+				if err := s.recordFailure(ctx, repos[i], *failure); err != nil {
+					return result, err
+				}
+				rejected++
+			default:
+				if bulkErr == nil {
+					synced = i
+					bulkErr = &BulkError{First: repos[i].FullName + ": " + failure.Reason}
+				}
+				bulkErr.Failed++
+			}
+		}
+		indexed := len(repos) - rejected
+		if bulkErr != nil {
+			indexed -= bulkErr.Failed
+			metrics.IncrCounterWithLabels("es_sync_documents_total", map[string]string{"result": "failed"}, int64(bulkErr.Failed))
+		}
+		metrics.IncrCounterWithLabels("es_sync_documents_total", map[string]string{"result": "rejected"}, int64(rejected))
+		metrics.IncrCounterWithLabels("es_sync_documents_total", map[string]string{"result": "indexed"}, int64(indexed))
+		result.Indexed += indexed
+		result.Rejected += rejected
+
+		if synced > 0 {
+			last := repos[synced-1]
+			mark = Watermark{UpdatedAt: last.UpdatedAt, FullName: last.FullName}
+			if err := s.saveWatermark(ctx, mark); err != nil {
+				return result, err
+			}
+			result.Watermark = mark
+			metrics.SetGauge("es_sync_watermark_timestamp_seconds", float64(mark.UpdatedAt.Unix()))
+		}
+		if bulkErr != nil {
+			return result, bulkErr
+		}
+		if len(repos) < s.batchSize {
+			return result, nil
+		}
+	}
+}
+
+// loadWatermark returns the saved watermark, or the zero Watermark before
+// the first sync
+func (s *Syncer) loadWatermark(ctx context.Context) (Watermark, error) {
+	var mark Watermark
+	err := s.db.QueryRowContext(ctx, `
+		SELECT synced_through, last_full_name FROM es_sync_state WHERE index_name = $1
+	`, s.index).Scan(&mark.UpdatedAt, &mark.FullName)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return Watermark{}, fmt.Errorf("failed to load sync watermark: %w", err)
+	}
+	return mark, nil
+}
+
+func (s *Syncer) saveWatermark(ctx context.Context, mark Watermark) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO es_sync_state (index_name, synced_through, last_full_name, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (index_name) DO UPDATE SET
+			synced_through = EXCLUDED.synced_through,
+			last_full_name = EXCLUDED.last_full_name,
+			updated_at = NOW()
+	`, s.index, mark.UpdatedAt, mark.FullName)
+	if err != nil {
+		return fmt.Errorf("failed to save sync watermark: %w", err)
+	}
+	return nil
+}
+
+// recordFailure keeps the latest permanent failure of repo in
+// es_sync_failures
+func (s *Syncer) recordFailure(ctx context.Context, repo models.EnrichedRepo, failure itemFailure) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO es_sync_failures (index_name, full_name, row_updated_at, status, reason, failed_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (index_name, full_name) DO UPDATE SET
+			row_updated_at = EXCLUDED.row_updated_at,
+			status = EXCLUDED.status,
+			reason = EXCLUDED.reason,
+			failed_at = NOW()
+	`, s.index, repo.FullName, repo.UpdatedAt, failure.Status, failure.Reason)
+	if err != nil {
+		return fmt.Errorf("failed to record sync failure of %s: %w", repo.FullName, err)
+	}
+	return nil
+}
+
+// changedRows returns the next batch of rows past mark
+func (s *Syncer) changedRows(ctx context.Context, mark Watermark) ([]models.EnrichedRepo, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT full_name, COALESCE(name, ''), COALESCE(description, ''), COALESCE(url, ''),
+		       COALESCE(language, ''), COALESCE(stars, 0), COALESCE(forks, 0), topics,
+		       COALESCE(license_key, ''), COALESCE(license_name, ''),
+		       COALESCE(is_fork, FALSE), COALESCE(parent_full_name, ''),
+		       COALESCE(quality_score, 0), COALESCE(download_status, 'pending'),
+		       COALESCE(code_lines, 0), COALESCE(file_count, 0),
+		       last_updated, crawled_at, updated_at
+		FROM repositories
+		WHERE (updated_at, full_name) > ($1, $2)
+		ORDER BY updated_at, full_name
+		LIMIT $3
+	`, mark.UpdatedAt, mark.FullName, s.batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query changed repositories: %w", err)
+	}
+	defer rows.Close()
+
+	var repos []models.EnrichedRepo
+	for rows.Next() {
+		var repo models.EnrichedRepo
+		var lastUpdated, crawledAt sql.NullTime
+		err := rows.Scan(
+			&repo.FullName, &repo.Name, &repo.Description, &repo.URL,
+			&repo.Language, &repo.Stars, &repo.Forks, pq.Array(&repo.Topics),
+			&repo.License, &repo.LicenseName,
+			&repo.IsFork, &repo.ParentFullName,
+			&repo.QualityScore, &repo.DownloadStatus,
+			&repo.CodeLines, &repo.FileCount,
+			&lastUpdated, &crawledAt, &repo.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan repository: %w", err)
+		}
+		if lastUpdated.Valid {
+			repo.LastUpdated = &lastUpdated.Time
+		}
+		repo.CrawledAt = crawledAt.Time
+		repos = append(repos, repo)
+	}
+	return repos, rows.Err()
+}
+
+// bulkIndex upserts repos by full name in one bulk request. It returns why
+// each document wasn't indexed, nil for those that were; err is set only
+// when the request as a whole failed.
+func (s *Syncer) bulkIndex(ctx context.Context, repos []models.EnrichedRepo) ([]*itemFailure, error) {
+	var body bytes.Buffer
+	for _, repo := range repos {
+		action := map[string]interface{}{"index": map[string]string{"_index": s.index, "_id": repo.FullName}}
+		if err := json.NewEncoder(&body).Encode(action); err != nil {
+			return nil, err
+		}
+		if err := json.NewEncoder(&body).Encode(repo); err != nil {
+			return nil, err
+		}
+	}
+
+	res, err := esapi.BulkRequest{Body: &body}.Do(ctx, s.es)
+	if err != nil {
+		return nil, fmt.Errorf("bulk request failed: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("bulk request failed: %s", res.String())
+	}
+
+	var response struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if len(response.Items) != len(repos) {
+		return nil, fmt.Errorf("bulk response has %d items for %d documents", len(response.Items), len(repos))
+	}
+
+	failures := make([]*itemFailure, len(repos))
+	for i, item := range response.Items {
+		result := item["index"]
+		switch {
+		case result.Error != nil:
+			failures[i] = &itemFailure{Status: result.Status, Reason: result.Error.Type + ": " + result.Error.Reason}
+		case result.Status >= 300:
+			failures[i] = &itemFailure{Status: result.Status, Reason: http.StatusText(result.Status)}
+		}
+	}
+	return failures, nil
+}
@@ -0,0 +1,328 @@
+package essync
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// fakeElasticsearch answers each bulk request with the next of responses
+// and records the _id of every document sent
+type fakeElasticsearch struct {
+	responses []string
+	ids       [][]string
+	created   bool
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut:
+		f.created = true
+		io.WriteString(w, `{"acknowledged": true}`)
+	case strings.HasSuffix(r.URL.Path, "/_bulk"):
+		var ids []string
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var action struct {
+				Index *struct {
+					ID string `json:"_id"`
+				} `json:"index"`
+			}
+			if json.Unmarshal(scanner.Bytes(), &action) == nil && action.Index != nil {
+				ids = append(ids, action.Index.ID)
+			}
+		}
+		f.ids = append(f.ids, ids)
+		io.WriteString(w, f.responses[0])
+		f.responses = f.responses[1:]
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// bulkResponse returns a bulk response with an item per status
+func bulkResponse(statuses ...int) string {
+	items := make([]string, len(statuses))
+	hasErrors := false
+	for i, status := range statuses {
+		switch {
+		case status == http.StatusTooManyRequests:
+			hasErrors = true
+			items[i] = `{"index": {"status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "rejected execution"}}}`
+		case status >= 300:
+			hasErrors = true
+			items[i] = fmt.Sprintf(`{"index": {"status": %d, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [stars]"}}}`, status)
+		default:
+			items[i] = `{"index": {"status": 201}}`
+		}
+	}
+	errorsJSON, _ := json.Marshal(hasErrors)
+	return `{"errors": ` + string(errorsJSON) + `, "items": [` + strings.Join(items, ",") + `]}`
+}
+
+func newTestSyncer(t *testing.T, fake *fakeElasticsearch) (*Syncer, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(db, client), mock
+}
+
+var repoColumns = []string{
+	"full_name", "name", "description", "url", "language", "stars", "forks", "topics",
+	"license_key", "license_name", "is_fork", "parent_full_name",
+	"quality_score", "download_status", "code_lines", "file_count",
+	"last_updated", "crawled_at", "updated_at",
+}
+
+// repoRows returns rows for names, updated a minute apart from start
+func repoRows(start time.Time, names ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows(repoColumns)
+	for i, name := range names {
+		rows.AddRow(name, name[strings.Index(name, "/")+1:], "", "https://github.com/"+name, "Go", 10, 1, "{cli}",
+			"MIT", "MIT License", false, "", 80, "downloaded", 1200, 30,
+			nil, start, start.Add(time.Duration(i)*time.Minute))
+	}
+	return rows
+}
+
+func expectWatermark(mock sqlmock.Sqlmock, mark *Watermark) {
+	query := mock.ExpectQuery("SELECT synced_through, last_full_name FROM es_sync_state").WithArgs("repositories-enriched")
+	if mark == nil {
+		query.WillReturnError(sql.ErrNoRows)
+		return
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"synced_through", "last_full_name"}).AddRow(mark.UpdatedAt, mark.FullName))
+}
+
+func expectSave(mock sqlmock.Sqlmock, updatedAt time.Time, fullName string) {
+	mock.ExpectExec("INSERT INTO es_sync_state").
+		WithArgs("repositories-enriched", updatedAt, fullName).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestSync_AdvancesWatermark(t *testing.T) {
+	fake := &fakeElasticsearch{responses: []string{bulkResponse(201, 201), bulkResponse(201)}}
+	syncer, mock := newTestSyncer(t, fake)
+	syncer.batchSize = 2
+
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	mark := Watermark{UpdatedAt: start.Add(-time.Hour), FullName: "old/repo"}
+	expectWatermark(mock, &mark)
+
+	// Full batches are followed by another query from the new watermark
+	mock.ExpectQuery("WHERE \\(updated_at, full_name\\) > \\(\\$1, \\$2\\)").
+		WithArgs(mark.UpdatedAt, mark.FullName, 2).
+		WillReturnRows(repoRows(start, "a/one", "b/two"))
+	expectSave(mock, start.Add(time.Minute), "b/two")
+	mock.ExpectQuery("FROM repositories").
+		WithArgs(start.Add(time.Minute), "b/two", 2).
+		WillReturnRows(repoRows(start.Add(5*time.Minute), "c/three"))
+	expectSave(mock, start.Add(5*time.Minute), "c/three")
+
+	result, err := syncer.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	want := Watermark{UpdatedAt: start.Add(5 * time.Minute), FullName: "c/three"}
+	if result.Indexed != 3 || result.Watermark != want {
+		t.Errorf("Sync() = %+v, want 3 indexed through %+v", result, want)
+	}
+	if len(fake.ids) != 2 || strings.Join(fake.ids[0], ",") != "a/one,b/two" {
+		t.Errorf("bulk requests carried %v, want a/one,b/two then c/three", fake.ids)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSync_NothingChanged(t *testing.T) {
+	fake := &fakeElasticsearch{}
+	syncer, mock := newTestSyncer(t, fake)
+	expectWatermark(mock, nil)
+	mock.ExpectQuery("FROM repositories").
+		WithArgs(time.Time{}, "", defaultBatchSize).
+		WillReturnRows(sqlmock.NewRows(repoColumns))
+
+	result, err := syncer.Sync(context.Background())
+	if err != nil || result.Indexed != 0 || !result.Watermark.UpdatedAt.IsZero() {
+		t.Errorf("Sync() = %+v, %v; want nothing indexed from the zero watermark", result, err)
+	}
+	if len(fake.ids) != 0 {
+		t.Errorf("sent %d bulk requests with nothing to sync", len(fake.ids))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func expectRecordFailure(mock sqlmock.Sqlmock, fullName string, updatedAt time.Time, status int) {
+	mock.ExpectExec("INSERT INTO es_sync_failures").
+		WithArgs("repositories-enriched", fullName, updatedAt, status, "mapper_parsing_exception: failed to parse field [stars]").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestSync_RetryableFailureHoldsWatermark(t *testing.T) {
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		statuses []int
+		// saved is the row the watermark moves to, "" for none
+		saved   string
+		indexed int
+	}{
+		{"middle row throttled", []int{201, 429, 201}, "a/one", 2},
+		{"first row failed", []int{503, 201, 201}, "", 2},
+		{"every row failed", []int{429, 500, 503}, "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeElasticsearch{responses: []string{bulkResponse(tt.statuses...)}}
+			syncer, mock := newTestSyncer(t, fake)
+			expectWatermark(mock, nil)
+			mock.ExpectQuery("FROM repositories").WillReturnRows(repoRows(start, "a/one", "b/two", "c/three"))
+			if tt.saved != "" {
+				expectSave(mock, start, tt.saved)
+			}
+
+			result, err := syncer.Sync(context.Background())
+			var bulkErr *BulkError
+			if !errors.As(err, &bulkErr) {
+				t.Fatalf("Sync() error = %v, want a *BulkError", err)
+			}
+			if bulkErr.Failed != 3-tt.indexed || !strings.Contains(bulkErr.First, "exception") {
+				t.Errorf("BulkError = %+v, want %d failed with the reason", bulkErr, 3-tt.indexed)
+			}
+			if result.Indexed != tt.indexed || result.Watermark.FullName != tt.saved {
+				t.Errorf("Sync() = %+v, want %d indexed through %q", result, tt.indexed, tt.saved)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+		})
+	}
+}
+
+func TestSync_PermanentFailureIsRecorded(t *testing.T) {
+	fake := &fakeElasticsearch{responses: []string{bulkResponse(400, 201, 404), bulkResponse(201)}}
+	syncer, mock := newTestSyncer(t, fake)
+	syncer.batchSize = 3
+
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	expectWatermark(mock, nil)
+	mock.ExpectQuery("FROM repositories").WillReturnRows(repoRows(start, "a/one", "b/two", "c/three"))
+	expectRecordFailure(mock, "a/one", start, 400)
+	expectRecordFailure(mock, "c/three", start.Add(2*time.Minute), 404)
+	expectSave(mock, start.Add(2*time.Minute), "c/three")
+
+	// The next batch starts past the rejected rows
+	mock.ExpectQuery("FROM repositories").
+		WithArgs(start.Add(2*time.Minute), "c/three", 3).
+		WillReturnRows(repoRows(start.Add(time.Hour), "d/four"))
+	expectSave(mock, start.Add(time.Hour), "d/four")
+
+	result, err := syncer.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if result.Indexed != 2 || result.Rejected != 2 || result.Watermark.FullName != "d/four" {
+		t.Errorf("Sync() = %+v, want 2 indexed and 2 rejected through d/four", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSync_MixedFailures(t *testing.T) {
+	fake := &fakeElasticsearch{responses: []string{bulkResponse(400, 201, 429, 201)}}
+	syncer, mock := newTestSyncer(t, fake)
+
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	expectWatermark(mock, nil)
+	mock.ExpectQuery("FROM repositories").WillReturnRows(repoRows(start, "a/one", "b/two", "c/three", "d/four"))
+	expectRecordFailure(mock, "a/one", start, 400)
+	// The rejected row is passed, the throttled one is not
+	expectSave(mock, start.Add(time.Minute), "b/two")
+
+	result, err := syncer.Sync(context.Background())
+	var bulkErr *BulkError
+	if !errors.As(err, &bulkErr) || bulkErr.Failed != 1 || !strings.HasPrefix(bulkErr.First, "c/three") {
+		t.Fatalf("Sync() error = %v, want a *BulkError for c/three", err)
+	}
+	if result.Indexed != 2 || result.Rejected != 1 || result.Watermark.FullName != "b/two" {
+		t.Errorf("Sync() = %+v, want 2 indexed and 1 rejected through b/two", result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSync_RecordFailureError(t *testing.T) {
+	fake := &fakeElasticsearch{responses: []string{bulkResponse(201, 400)}}
+	syncer, mock := newTestSyncer(t, fake)
+
+	start := time.Date(2025, 10, 1, 12, 0, 0, 0, time.UTC)
+	expectWatermark(mock, nil)
+	mock.ExpectQuery("FROM repositories").WillReturnRows(repoRows(start, "a/one", "b/two"))
+	mock.ExpectExec("INSERT INTO es_sync_failures").WillReturnError(sql.ErrConnDone)
+
+	// A rejection that wasn't recorded isn't passed either
+	if _, err := syncer.Sync(context.Background()); !errors.Is(err, sql.ErrConnDone) {
+		t.Errorf("Sync() error = %v, want the insert error", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSync_BulkRequestRejected(t *testing.T) {
+	fake := &fakeElasticsearch{responses: []string{`{"errors": false, "items": []}`}}
+	syncer, mock := newTestSyncer(t, fake)
+	expectWatermark(mock, nil)
+	mock.ExpectQuery("FROM repositories").WillReturnRows(repoRows(time.Now(), "a/one"))
+
+	// A response that doesn't account for every document saves nothing
+	if _, err := syncer.Sync(context.Background()); err == nil {
+		t.Fatal("Sync() succeeded with a short bulk response")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestEnsureIndex_CreatesMissingIndex(t *testing.T) {
+	fake := &fakeElasticsearch{}
+	syncer, _ := newTestSyncer(t, fake)
+	if err := syncer.EnsureIndex(context.Background()); err != nil {
+		t.Fatalf("EnsureIndex() error = %v", err)
+	}
+	if !fake.created {
+		t.Error("EnsureIndex() didn't create the missing index")
+	}
+}
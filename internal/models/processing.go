@@ -0,0 +1,100 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProcessingJob represents a resumable processing job
+type ProcessingJob struct {
+	ID             int        `json:"id"`
+	RepoPath       string     `json:"repo_path"`
+	Status         string     `json:"status"` // pending, processing, completed, failed, skipped
+	FilesFound     int        `json:"files_found"`
+	FilesProcessed int        `json:"files_processed"`
+	StartedAt      *time.Time `json:"started_at"`
+	CompletedAt    *time.Time `json:"completed_at"`
+	ErrorMsg       string     `json:"error_msg"`
+	WorkerID       string     `json:"worker_id"`
+	Attempts       int        `json:"attempts"` // times reclaimed from a worker that stopped heartbeating
+	// Reprocess is set when a completed repo changed on disk: only new
+	// content is inserted and files no longer present are marked removed
+	Reprocess bool `json:"reprocess"`
+}
+
+// ProcessedFile represents a processed code file with full metadata
+type ProcessedFile struct {
+	ID             int       `json:"id"`
+	JobID          int       `json:"job_id"`
+	FilePath       string    `json:"file_path"`
+	RelativePath   string    `json:"relative_path"`
+	Content        string    `json:"content"`
+	Language       string    `json:"language"`
+	Lines          int       `json:"lines"`
+	Size           int64     `json:"size"`
+	Hash           string    `json:"hash"`
+	NormalizedHash string    `json:"normalized_hash"` // hash after stripping comments and whitespace
+	RepoName       string    `json:"repo_name"`
+	ProcessedAt    time.Time `json:"processed_at"`
+	QualityScore   int       `json:"quality_score"`
+	SecretsFound   []string  `json:"secrets_found,omitempty"` // pkg/scrub detectors that fired
+	// Metrics are the pkg/codemetrics measurements, for languages with an
+	// analyzer
+	Metrics json.RawMessage `json:"metrics,omitempty"`
+
+	// Repo-level context, the same for every file in a repo (pkg/repocontext)
+	RepoReadmeExcerpt string `json:"repo_readme_excerpt,omitempty"`
+	ManifestKind      string `json:"manifest_kind,omitempty"` // manifest file name, e.g. go.mod
+	ManifestExcerpt   string `json:"manifest_excerpt,omitempty"`
+
+	// Chunks are the file's top-level declarations (pkg/chunk), when the
+	// processor runs with --chunks
+	Chunks []ProcessedChunk `json:"chunks,omitempty"`
+}
+
+// ProcessedChunk is a function, method, class or type declared at the top
+// level of a processed file
+type ProcessedChunk struct {
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	StartLine  int    `json:"start_line"`
+	EndLine    int    `json:"end_line"`
+	Content    string `json:"content"`
+	DocComment string `json:"doc_comment,omitempty"`
+}
+
+// FileResponse is a processed file as returned by the API. Content is only
+// filled in when a single file is fetched with content.
+type FileResponse struct {
+	ID           int64     `json:"id"`
+	RepoName     string    `json:"repo_name"`
+	RelativePath string    `json:"relative_path"`
+	Language     string    `json:"language"`
+	Lines        int       `json:"lines"`
+	Size         int64     `json:"size"`
+	Hash         string    `json:"hash"`
+	QualityScore int       `json:"quality_score"`
+	ProcessedAt  time.Time `json:"processed_at"`
+	Content      *string   `json:"content,omitempty"`
+}
+
+// Response returns the API representation of the file, with its content
+// when withContent is set
+func (f *ProcessedFile) Response(withContent bool) FileResponse {
+	resp := FileResponse{
+		ID:           int64(f.ID),
+		RepoName:     f.RepoName,
+		RelativePath: f.RelativePath,
+		Language:     f.Language,
+		Lines:        f.Lines,
+		Size:         f.Size,
+		Hash:         f.Hash,
+		QualityScore: f.QualityScore,
+		ProcessedAt:  f.ProcessedAt,
+	}
+	if withContent {
+		content := f.Content
+		resp.Content = &content
+	}
+	return resp
+}
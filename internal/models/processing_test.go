@@ -0,0 +1,57 @@
+package models
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessedFile_Response(t *testing.T) {
+	file := &ProcessedFile{
+		ID:             9,
+		JobID:          2,
+		FilePath:       "/repos/octo-org/widgets/main.go",
+		RelativePath:   "main.go",
+		Content:        "package main",
+		Language:       "Go",
+		Lines:          1,
+		Size:           12,
+		Hash:           "abc123",
+		NormalizedHash: "def456",
+		RepoName:       "widgets",
+		ProcessedAt:    time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+		QualityScore:   75,
+	}
+
+	resp := file.Response(false)
+	if resp.ID != 9 || resp.RelativePath != "main.go" || resp.QualityScore != 75 || resp.Content != nil {
+		t.Errorf("Response(false) = %+v", resp)
+	}
+	data, _ := json.Marshal(resp)
+	if strings.Contains(string(data), `"content"`) {
+		t.Errorf("content should be omitted: %s", data)
+	}
+
+	resp = file.Response(true)
+	if resp.Content == nil || *resp.Content != "package main" {
+		t.Errorf("Response(true).Content = %v", resp.Content)
+	}
+}
+
+func TestProcessingJob_JSONRoundTrip(t *testing.T) {
+	started := time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC)
+	job := ProcessingJob{ID: 3, RepoPath: "/repos/widgets", Status: "processing", StartedAt: &started, WorkerID: "w1", Attempts: 1}
+
+	data, err := json.Marshal(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ProcessingJob
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != job.ID || got.Status != job.Status || !got.StartedAt.Equal(started) || got.CompletedAt != nil {
+		t.Errorf("round trip = %+v, want %+v", got, job)
+	}
+}
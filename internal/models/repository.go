@@ -1,19 +1,133 @@
 package models
 
-import "time"
+import (
+	"strconv"
+	"time"
+)
+
+// RepoIndex is the Elasticsearch alias the crawler writes RepoInfo
+// documents through and the downloader reads them from. It points at one
+// versioned index, named by RepoIndexName, so a mapping change can be
+// rolled out by reindexing into the next version and moving the alias.
+const RepoIndex = "github-coding-repos"
+
+// RepoIndexVersion is the version the crawler creates when RepoIndex
+// doesn't exist yet
+const RepoIndexVersion = 2
+
+// RepoIndexName returns the concrete index for a RepoIndex version
+func RepoIndexName(version int) string {
+	return RepoIndex + "-v" + strconv.Itoa(version)
+}
+
+// RepoIndexProperties is the RepoIndex mapping. Every RepoInfo JSON field
+// has a property here.
+const RepoIndexProperties = `{
+	"name": {"type": "text"},
+	"full_name": {"type": "keyword"},
+	"description": {"type": "text"},
+	"url": {"type": "keyword"},
+	"language": {"type": "keyword"},
+	"stars": {"type": "integer"},
+	"forks": {"type": "integer"},
+	"last_updated": {"type": "date"},
+	"topics": {"type": "keyword"},
+	"source": {"type": "keyword"},
+	"crawled_at": {"type": "date"},
+	"trending_rank": {"type": "integer"},
+	"trending_window": {"type": "keyword"},
+	"stars_gained": {"type": "integer"},
+	"license": {"type": "keyword"},
+	"license_name": {"type": "keyword"},
+	"is_fork": {"type": "boolean"},
+	"parent_full_name": {"type": "keyword"}
+}`
+
+// EnrichedRepoIndex is the Elasticsearch index the downloader's sync-es
+// command copies repositories rows into. Unlike RepoIndex, which keeps the
+// crawler's snapshot, it carries what the later stages learned about each
+// repository, so searches can filter on it.
+const EnrichedRepoIndex = "repositories-enriched"
+
+// EnrichedRepoIndexProperties is the EnrichedRepoIndex mapping. Every
+// EnrichedRepo JSON field has a property here.
+const EnrichedRepoIndexProperties = `{
+	"name": {"type": "text"},
+	"full_name": {"type": "keyword"},
+	"description": {"type": "text"},
+	"url": {"type": "keyword"},
+	"language": {"type": "keyword"},
+	"stars": {"type": "integer"},
+	"forks": {"type": "integer"},
+	"last_updated": {"type": "date"},
+	"topics": {"type": "keyword"},
+	"source": {"type": "keyword"},
+	"crawled_at": {"type": "date"},
+	"trending_rank": {"type": "integer"},
+	"trending_window": {"type": "keyword"},
+	"stars_gained": {"type": "integer"},
+	"license": {"type": "keyword"},
+	"license_name": {"type": "keyword"},
+	"is_fork": {"type": "boolean"},
+	"parent_full_name": {"type": "keyword"},
+	"quality_score": {"type": "integer"},
+	"download_status": {"type": "keyword"},
+	"code_lines": {"type": "integer"},
+	"file_count": {"type": "integer"},
+	"updated_at": {"type": "date"}
+}`
 
 // RepoInfo represents repository information from Elasticsearch
 type RepoInfo struct {
-	FullName    string    `json:"full_name"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	URL         string    `json:"url"`
-	Stars       int       `json:"stars"`
-	Forks       int       `json:"forks"`
-	Language    string    `json:"language"`
-	Topics      []string  `json:"topics"`
-	LastUpdated time.Time `json:"last_updated"`
-	CrawledAt   time.Time `json:"crawled_at"`
+	FullName    string   `json:"full_name"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	URL         string   `json:"url"`
+	Stars       int      `json:"stars"`
+	Forks       int      `json:"forks"`
+	Language    string   `json:"language"`
+	Topics      []string `json:"topics"`
+	// LastUpdated is nil when the crawler couldn't read it
+	LastUpdated *time.Time `json:"last_updated"`
+	CrawledAt   time.Time  `json:"crawled_at"`
+	// Source is the listing that found the repository: search, topic or
+	// trending
+	Source string `json:"source,omitempty"`
+
+	// Set only for repositories found on github.com/trending
+	TrendingRank   int    `json:"trending_rank,omitempty"`
+	TrendingWindow string `json:"trending_window,omitempty"`
+	StarsGained    int    `json:"stars_gained,omitempty"`
+
+	// License is the SPDX id, empty until looked up or detected
+	License     string `json:"license,omitempty"`
+	LicenseName string `json:"license_name,omitempty"`
+
+	// Set once the host's API has reported the repository as a fork
+	IsFork         bool   `json:"is_fork,omitempty"`
+	ParentFullName string `json:"parent_full_name,omitempty"`
+}
+
+// EnrichedRepo is a repositories row as indexed in EnrichedRepoIndex: the
+// crawler's fields plus the scores and status Postgres has since recorded
+type EnrichedRepo struct {
+	RepoInfo
+	QualityScore   int    `json:"quality_score"`
+	DownloadStatus string `json:"download_status"`
+	CodeLines      int    `json:"code_lines"`
+	FileCount      int    `json:"file_count"`
+	// UpdatedAt is the row's updated_at when it was indexed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Response returns the API representation of an enriched search hit. The
+// id is left zero.
+func (r *EnrichedRepo) Response() RepositoryResponse {
+	response := r.RepoInfo.Response()
+	response.QualityScore = r.QualityScore
+	response.DownloadStatus = r.DownloadStatus
+	response.UpdatedAt = r.UpdatedAt
+	return response
 }
 
 // Repository represents a repository in the database
@@ -33,9 +147,12 @@ type Repository struct {
 	CreatedAt      time.Time
 	CrawledAt      time.Time
 	DownloadedAt   *time.Time
+	LastSyncedAt   *time.Time
+	SyncError      string
 	DownloadStatus string
 	Topics         []string
 	IsFork         bool
+	ParentFullName string
 	IsArchived     bool
 	IsPrivate      bool
 	DefaultBranch  string
@@ -45,9 +162,107 @@ type Repository struct {
 	LicenseKey     string
 	LocalPath      string
 	ErrorMessage   string
-	QualityScore   int
-	CodeLines      int
-	FileCount      int
+	FilterReason   string
+	// HeadCommit is the default branch's HEAD at download time; MirrorOf
+	// names an earlier download with the same name and HEAD
+	HeadCommit   string
+	MirrorOf     string
+	QualityScore int
+	CodeLines    int
+	FileCount    int
+	// HasSubmodules and HasLFS record a .gitmodules file and LFS-tracked
+	// files in the checkout
+	HasSubmodules bool
+	HasLFS        bool
+}
+
+// RepositoryResponse is a repository as returned by the API
+type RepositoryResponse struct {
+	ID             int64     `json:"id"`
+	FullName       string    `json:"full_name"`
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Language       string    `json:"language"`
+	Stars          int       `json:"stars"`
+	Forks          int       `json:"forks"`
+	QualityScore   int       `json:"quality_score"`
+	DownloadStatus string    `json:"download_status"`
+	LocalPath      string    `json:"local_path,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// NewRepository returns the database row for a crawled repository
+func NewRepository(info *RepoInfo) *Repository {
+	return &Repository{
+		FullName:       info.FullName,
+		Name:           info.Name,
+		Description:    info.Description,
+		URL:            info.URL,
+		Language:       info.Language,
+		Stars:          info.Stars,
+		Forks:          info.Forks,
+		LastUpdated:    info.LastUpdated,
+		CrawledAt:      info.CrawledAt,
+		Topics:         info.Topics,
+		LicenseKey:     info.License,
+		LicenseName:    info.LicenseName,
+		IsFork:         info.IsFork,
+		ParentFullName: info.ParentFullName,
+	}
+}
+
+// RepoInfo returns the Elasticsearch document for the row
+func (r *Repository) RepoInfo() *RepoInfo {
+	return &RepoInfo{
+		FullName:       r.FullName,
+		Name:           r.Name,
+		Description:    r.Description,
+		URL:            r.URL,
+		Stars:          r.Stars,
+		Forks:          r.Forks,
+		Language:       r.Language,
+		Topics:         r.Topics,
+		LastUpdated:    r.LastUpdated,
+		CrawledAt:      r.CrawledAt,
+		License:        r.LicenseKey,
+		LicenseName:    r.LicenseName,
+		IsFork:         r.IsFork,
+		ParentFullName: r.ParentFullName,
+	}
+}
+
+// Response returns the API representation of the row. updatedAt is the
+// row's updated_at, which Repository doesn't carry.
+func (r *Repository) Response(updatedAt time.Time) RepositoryResponse {
+	id, _ := strconv.ParseInt(r.ID, 10, 64)
+	return RepositoryResponse{
+		ID:             id,
+		FullName:       r.FullName,
+		Name:           r.Name,
+		Description:    r.Description,
+		Language:       r.Language,
+		Stars:          r.Stars,
+		Forks:          r.Forks,
+		QualityScore:   r.QualityScore,
+		DownloadStatus: r.DownloadStatus,
+		LocalPath:      r.LocalPath,
+		CreatedAt:      r.CreatedAt,
+		UpdatedAt:      updatedAt,
+	}
+}
+
+// Response returns the API representation of a search hit. Fields only
+// the database knows, like the id and quality score, are left zero.
+func (r *RepoInfo) Response() RepositoryResponse {
+	return RepositoryResponse{
+		FullName:    r.FullName,
+		Name:        r.Name,
+		Description: r.Description,
+		Language:    r.Language,
+		Stars:       r.Stars,
+		Forks:       r.Forks,
+	}
 }
 
 // DownloadStats tracks download statistics
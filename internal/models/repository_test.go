@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/json"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -129,3 +132,172 @@ func TestRepoInfo_AgeInDays_Zero(t *testing.T) {
 		t.Errorf("AgeInDays() = %d, want 0 for zero time", age)
 	}
 }
+
+func TestRepoInfo_JSONRoundTrip(t *testing.T) {
+	updated := time.Date(2025, 9, 30, 8, 15, 0, 0, time.UTC)
+	repo := &RepoInfo{
+		FullName:       "tokio-rs/tokio",
+		Name:           "tokio",
+		Description:    "A runtime for writing reliable asynchronous applications",
+		URL:            "https://github.com/tokio-rs/tokio",
+		Stars:          28000,
+		Forks:          2500,
+		Language:       "Rust",
+		Topics:         []string{"async", "rust"},
+		LastUpdated:    &updated,
+		CrawledAt:      time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+		Source:         "trending",
+		TrendingRank:   3,
+		TrendingWindow: "daily",
+		StarsGained:    120,
+		License:        "MIT",
+		LicenseName:    "MIT License",
+		IsFork:         true,
+		ParentFullName: "tokio-rs/tokio-upstream",
+	}
+
+	data, err := json.Marshal(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got RepoInfo
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, repo) {
+		t.Errorf("round trip = %+v, want %+v", got, *repo)
+	}
+}
+
+func TestRepoInfo_DecodeCrawlerDocument(t *testing.T) {
+	// A search-mode document as the crawler indexes it: no trending or
+	// license fields, and last_updated unknown
+	doc := `{
+		"name": "widgets",
+		"full_name": "octo-org/widgets",
+		"description": "",
+		"url": "https://github.com/octo-org/widgets",
+		"language": "Go",
+		"stars": 42,
+		"forks": 7,
+		"last_updated": null,
+		"topics": null,
+		"source": "search",
+		"crawled_at": "2025-10-01T12:00:00Z"
+	}`
+
+	var repo RepoInfo
+	if err := json.Unmarshal([]byte(doc), &repo); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if repo.FullName != "octo-org/widgets" || repo.Stars != 42 || repo.Source != "search" {
+		t.Errorf("decoded = %+v", repo)
+	}
+	if repo.LastUpdated != nil {
+		t.Errorf("LastUpdated = %v, want nil for null", repo.LastUpdated)
+	}
+
+	// Re-encoding doesn't add empty optional fields
+	data, _ := json.Marshal(&repo)
+	for _, field := range []string{"trending_rank", "license", "license_name"} {
+		if strings.Contains(string(data), `"`+field+`"`) {
+			t.Errorf("encoded document has empty %s: %s", field, data)
+		}
+	}
+}
+
+func TestRepoIndexProperties_MatchRepoInfo(t *testing.T) {
+	var mapping map[string]struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(RepoIndexProperties), &mapping); err != nil {
+		t.Fatalf("RepoIndexProperties is not valid JSON: %v", err)
+	}
+
+	fields := map[string]bool{}
+	typ := reflect.TypeOf(RepoInfo{})
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		fields[name] = true
+		if _, ok := mapping[name]; !ok {
+			t.Errorf("RepoInfo field %q has no mapping property", name)
+		}
+	}
+	for name := range mapping {
+		if !fields[name] {
+			t.Errorf("mapping property %q has no RepoInfo field", name)
+		}
+	}
+}
+
+func TestEnrichedRepoIndexProperties_MatchEnrichedRepo(t *testing.T) {
+	var mapping map[string]struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(EnrichedRepoIndexProperties), &mapping); err != nil {
+		t.Fatalf("EnrichedRepoIndexProperties is not valid JSON: %v", err)
+	}
+
+	// The embedded RepoInfo's fields are inlined in the document
+	fields := map[string]bool{}
+	for _, typ := range []reflect.Type{reflect.TypeOf(RepoInfo{}), reflect.TypeOf(EnrichedRepo{})} {
+		for i := 0; i < typ.NumField(); i++ {
+			if typ.Field(i).Anonymous {
+				continue
+			}
+			name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+			fields[name] = true
+			if _, ok := mapping[name]; !ok {
+				t.Errorf("EnrichedRepo field %q has no mapping property", name)
+			}
+		}
+	}
+	for name := range mapping {
+		if !fields[name] {
+			t.Errorf("mapping property %q has no EnrichedRepo field", name)
+		}
+	}
+}
+
+func TestRepository_Conversions(t *testing.T) {
+	updated := time.Date(2025, 9, 30, 0, 0, 0, 0, time.UTC)
+	info := &RepoInfo{
+		FullName:       "octo-org/widgets",
+		Name:           "widgets",
+		Description:    "Widgets",
+		URL:            "https://github.com/octo-org/widgets",
+		Stars:          42,
+		Forks:          7,
+		Language:       "Go",
+		Topics:         []string{"cli"},
+		LastUpdated:    &updated,
+		CrawledAt:      time.Date(2025, 10, 1, 0, 0, 0, 0, time.UTC),
+		License:        "apache-2.0",
+		LicenseName:    "Apache License 2.0",
+		IsFork:         true,
+		ParentFullName: "upstream/widgets",
+	}
+
+	row := NewRepository(info)
+	if row.LicenseKey != "apache-2.0" || row.LastUpdated != info.LastUpdated || !row.IsFork {
+		t.Errorf("NewRepository() = %+v", row)
+	}
+	if got := row.RepoInfo(); !reflect.DeepEqual(got, info) {
+		t.Errorf("RepoInfo() = %+v, want %+v", got, info)
+	}
+
+	row.ID = "17"
+	row.QualityScore = 80
+	row.DownloadStatus = "downloaded"
+	resp := row.Response(updated)
+	if resp.ID != 17 || resp.QualityScore != 80 || resp.DownloadStatus != "downloaded" ||
+		resp.FullName != info.FullName || !resp.UpdatedAt.Equal(updated) {
+		t.Errorf("Response() = %+v", resp)
+	}
+
+	// Search hits carry only what Elasticsearch knows
+	hit := info.Response()
+	if hit.ID != 0 || hit.Stars != 42 || hit.Language != "Go" {
+		t.Errorf("RepoInfo.Response() = %+v", hit)
+	}
+}
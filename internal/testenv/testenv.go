@@ -0,0 +1,246 @@
+//go:build integration
+
+// Package testenv starts the Postgres and Elasticsearch containers the
+// integration tests run against.
+//
+// The crawler, downloader and processor are separate binaries built from
+// one file each, so every stage of the pipeline is tested by its own go
+// test run. The containers are named and reused, which lets each stage read
+// what the previous one wrote; make test-integration runs the stages in
+// order and removes the containers afterwards.
+package testenv
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"codelupe/pkg/config"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	_ "github.com/lib/pq"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Images match docker-compose.yml
+const (
+	postgresImage      = "postgres:16-alpine"
+	elasticsearchImage = "docker.elastic.co/elasticsearch/elasticsearch:8.11.0"
+
+	postgresContainer      = "codelupe-it-postgres"
+	elasticsearchContainer = "codelupe-it-elasticsearch"
+
+	startTimeout = 3 * time.Minute
+)
+
+var (
+	pgOnce sync.Once
+	pgURL  string
+	pgErr  error
+
+	esOnce sync.Once
+	esURL  string
+	esErr  error
+)
+
+// Root returns the repository root, where migrations/, postgres/init and
+// testdata/ live
+func Root() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}
+
+// Fixture returns the path of a file under testdata/integration
+func Fixture(elem ...string) string {
+	return filepath.Join(append([]string{Root(), "testdata", "integration"}, elem...)...)
+}
+
+// ReposDir is where the downloader stage places checkouts and the processor
+// stage reads them. It outlives a single test run like the containers do.
+func ReposDir() string {
+	return filepath.Join(os.TempDir(), "codelupe-it-repos")
+}
+
+// PostgresURL starts the Postgres container, or reuses a running one, and
+// brings its schema up to the latest migration. It returns the container's
+// connection URL.
+func PostgresURL(t testing.TB) string {
+	t.Helper()
+	pgOnce.Do(func() {
+		pgURL, pgErr = start(startPostgres)
+	})
+	if pgErr != nil {
+		t.Fatalf("failed to start Postgres: %v", pgErr)
+	}
+	return pgURL
+}
+
+// Postgres returns a connection to the migrated Postgres container, closed
+// when t ends
+func Postgres(t testing.TB) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("postgres", PostgresURL(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// start runs f, turning the panic testcontainers raises when it finds no
+// Docker host into an error
+func start(f func() (string, error)) (url string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("is Docker running? %v", r)
+		}
+	}()
+	return f()
+}
+
+func startPostgres() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        postgresImage,
+			Name:         postgresContainer,
+			ExposedPorts: []string{"5432/tcp"},
+			Env: map[string]string{
+				"POSTGRES_USER":     "codelupe",
+				"POSTGRES_PASSWORD": "codelupe",
+				"POSTGRES_DB":       "codelupe",
+			},
+			// Like docker-compose.yml, the schema starts from postgres/init
+			// and the migrations are applied on top
+			Files: initScripts(),
+			// The server restarts once after running the init scripts
+			WaitingFor: wait.ForLog("database system is ready to accept connections").
+				WithOccurrence(2).WithStartupTimeout(startTimeout),
+		},
+		Started: true,
+		Reuse:   true,
+	})
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := c.PortEndpoint(ctx, "5432/tcp", "")
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("postgres://codelupe:codelupe@%s/codelupe?sslmode=disable", endpoint)
+	return url, migrateUp(url)
+}
+
+// initScripts copies postgres/init into the container's
+// docker-entrypoint-initdb.d
+func initScripts() []testcontainers.ContainerFile {
+	scripts, _ := filepath.Glob(filepath.Join(Root(), "postgres", "init", "*.sql"))
+	files := make([]testcontainers.ContainerFile, len(scripts))
+	for i, script := range scripts {
+		files[i] = testcontainers.ContainerFile{
+			HostFilePath:      script,
+			ContainerFilePath: "/docker-entrypoint-initdb.d/" + filepath.Base(script),
+			FileMode:          0644,
+		}
+	}
+	return files
+}
+
+// migrateUp applies the migrations the way cmd/migrate does
+func migrateUp(url string) error {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	driver, err := postgres.WithInstance(db, &postgres.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create migration driver: %w", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.Join(Root(), "migrations"), "postgres", driver)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+	return nil
+}
+
+// ElasticsearchURL starts the Elasticsearch container, or reuses a running
+// one
+func ElasticsearchURL(t testing.TB) string {
+	t.Helper()
+	esOnce.Do(func() {
+		esURL, esErr = start(startElasticsearch)
+	})
+	if esErr != nil {
+		t.Fatalf("failed to start Elasticsearch: %v", esErr)
+	}
+	return esURL
+}
+
+// Elasticsearch returns a client for the Elasticsearch container
+func Elasticsearch(t testing.TB) *elasticsearch.Client {
+	t.Helper()
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{ElasticsearchURL(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func startElasticsearch() (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), startTimeout)
+	defer cancel()
+
+	c, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        elasticsearchImage,
+			Name:         elasticsearchContainer,
+			ExposedPorts: []string{"9200/tcp"},
+			Env: map[string]string{
+				"discovery.type":         "single-node",
+				"xpack.security.enabled": "false",
+				"ES_JAVA_OPTS":           "-Xms512m -Xmx512m",
+			},
+			WaitingFor: wait.ForHTTP("/_cluster/health?wait_for_status=yellow").
+				WithPort("9200/tcp").WithStartupTimeout(startTimeout),
+		},
+		Started: true,
+		Reuse:   true,
+	})
+	if err != nil {
+		return "", err
+	}
+	endpoint, err := c.PortEndpoint(ctx, "9200/tcp", "http")
+	if err != nil {
+		return "", err
+	}
+	return endpoint, nil
+}
+
+// Config returns the defaults pointed at the containers and ReposDir
+func Config(t testing.TB) *config.Config {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Database.URL = PostgresURL(t)
+	cfg.Elasticsearch.URL = ElasticsearchURL(t)
+	cfg.Paths.ReposDir = ReposDir()
+	cfg.GitHub.CacheDir = "off"
+	return cfg
+}
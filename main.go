@@ -1,15 +1,25 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"math"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,7 +27,11 @@ import (
 	"syscall"
 	"time"
 
+	"codelupe/internal/models"
+	"codelupe/pkg/config"
+	"codelupe/pkg/ghclient"
 	"codelupe/pkg/metrics"
+	"codelupe/pkg/notify"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/elastic/go-elasticsearch/v8"
@@ -25,22 +39,100 @@ import (
 	"golang.org/x/time/rate"
 )
 
+// Repository is a crawled repository: the document indexed into
+// Elasticsearch plus crawler-only state
 type Repository struct {
-	Name        string     `json:"name"`
-	FullName    string     `json:"full_name"`
-	Description string     `json:"description"`
-	URL         string     `json:"url"`
-	Language    string     `json:"language"`
-	Stars       int        `json:"stars"`
-	Forks       int        `json:"forks"`
-	LastUpdated *time.Time `json:"last_updated"`
-	Topics      []string   `json:"topics"`
-	CrawledAt   time.Time  `json:"crawled_at"`
+	models.RepoInfo
+
+	// starsFromCard is set when the star count was read from the search
+	// result card, letting --details=missing skip the repo page fetch.
+	starsFromCard bool
+	// term is the search or topic term that found the repository, for
+	// metric labels
+	term string
 }
 
+// maxRateLimitRetries bounds how often a single request is retried after 429s
+const maxRateLimitRetries = 3
+
+var errRateLimited = errors.New("rate limited")
+
+// errBotBlocked is returned when GitHub keeps serving a bot-detection page
+// instead of results. It wraps errRateLimited since the only cure is the
+// same: waiting.
+var errBotBlocked = fmt.Errorf("%w: blocked as a bot", errRateLimited)
+
+// Kinds of page fetchDocument fetches, labelling its metrics
+const (
+	pageSearch   = "search"
+	pageTopic    = "topic"
+	pageTrending = "trending"
+	pageRepo     = "repo"
+)
+
+// Status classes of fetches that got no usable HTTP status. The others are
+// 2xx, 3xx, 4xx, 429 and 5xx, see statusClass.
+const (
+	statusNetworkError = "network_error" // no response
+	statusBlocked      = "blocked"       // a bot-detection page
+	statusParseError   = "parse_error"   // a page without the expected markup
+)
+
+// statusClass buckets an HTTP status for metric labels. 429 is kept apart
+// from the other 4xx since it means throttling rather than a missing page.
+func statusClass(code int) string {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return "429"
+	case code >= 500:
+		return "5xx"
+	case code >= 400:
+		return "4xx"
+	case code >= 300:
+		return "3xx"
+	default:
+		return "2xx"
+	}
+}
+
+// fetchError is a failed page fetch with the status class it is counted
+// under
+type fetchError struct {
+	class string
+	err   error
+}
+
+func (e *fetchError) Error() string { return e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// errorClass returns the status class of a failed fetch. Errors that
+// carry none never got a response.
+func errorClass(err error) string {
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		return fe.class
+	}
+	return statusNetworkError
+}
+
+// Pages GitHub serves in place of results when it suspects a bot
+const (
+	blockPageAbuse = "abuse" // "Whoa there!" abuse detection / secondary rate limit
+	blockPageLogin = "login" // sign-in interstitial
+)
+
+// Details modes controlling when scrapeRepoDetails is called for a result
+const (
+	detailsAlways  = "always"
+	detailsMissing = "missing"
+	detailsNever   = "never"
+)
+
 type Crawler struct {
 	client      *http.Client
+	cache       *ghclient.Cache // conditional requests for pages; nil disables
 	esClient    *elasticsearch.Client
+	sink        Sink
 	rateLimiter *rate.Limiter
 	mu          sync.Mutex
 	crawled     map[string]bool
@@ -48,6 +140,36 @@ type Crawler struct {
 	ctx         context.Context
 	cancel      context.CancelFunc
 	stats       *CrawlerStats
+	searchTerms []string
+	detailsMode string
+	baseURL     string
+	pausedUntil int64 // unix nanos; set on 429 so every goroutine backs off
+	checkpoint  *crawlCheckpoint
+	maxPages    int
+	concurrency int
+	pageDelay   time.Duration
+	// botBlockWait is how long every request pauses after GitHub serves a
+	// bot-detection page; much longer than a 429's Retry-After since
+	// retrying early extends the block
+	botBlockWait time.Duration
+	// maxReadyPause is how long a rate-limit pause may last before /readyz
+	// reports the crawler as not ready
+	maxReadyPause time.Duration
+	logger        *slog.Logger
+	proxies       *proxyPool
+	// headers sends each request with a browser header profile; nil sends
+	// ghclient.UserAgent
+	headers *headerRotator
+
+	// languageTargets caps the repos indexed per language (lowercased);
+	// once a language reaches its target, terms associated with it are
+	// skipped. termTarget stops paging a term after that many repos are
+	// indexed from it; 0 means no limit.
+	languageTargets map[string]int64
+	termTarget      int64
+
+	// notifier is told when a run ends
+	notifier notify.Multi
 }
 
 type CrawlerStats struct {
@@ -56,8 +178,16 @@ type CrawlerStats struct {
 	totalErrors    int64
 	termsProcessed int64
 	pagesProcessed int64
+	rateLimitWaits int64
+	pagesResumed   int64
+	pagesExhausted int64
+	pagesSkipped   int64 // pages of terms whose language target was reached
+	totalPages     int64
 	startTime      time.Time
 	lastReported   time.Time
+
+	// indexedByLanguage counts indexed repos by lowercased language
+	indexedByLanguage map[string]int64
 }
 
 // cleanLanguageString removes percentage indicators and extra whitespace from language strings
@@ -99,190 +229,830 @@ func cleanLanguageString(lang string) string {
 	return strings.TrimSpace(lang)
 }
 
-var codingSearchTerms = []string{
-	// Core Programming Languages - Primary Focus
-	"rust", "rust-lang", "rustlang", "cargo", "rust-programming", "rust-development",
-	"golang", "go-lang", "go-programming", "go-development", "go-modules", "goroutines",
-	"python", "python3", "python-programming", "python-development", "pip", "conda",
-	"typescript", "ts", "typescript-programming", "deno", "bun",
-	"javascript", "js", "nodejs", "node-js", "npm", "yarn", "pnpm",
-	"dart", "dart-lang", "dart-programming", "flutter-dart",
-
-	// AI & Machine Learning
-	"artificial-intelligence", "ai", "machine-learning", "ml", "deep-learning", "dl",
-	"neural-networks", "neural-network", "tensorflow", "pytorch", "keras", "scikit-learn",
-	"sklearn", "pandas", "numpy", "matplotlib", "seaborn", "jupyter", "notebook",
-	"data-science", "data-analysis", "data-mining", "data-visualization", "plotly",
-	"opencv", "computer-vision", "nlp", "natural-language-processing", "huggingface",
-	"transformers", "bert", "gpt", "llm", "large-language-model", "generative-ai",
-	"reinforcement-learning", "supervised-learning", "unsupervised-learning",
-	"gradient-boosting", "xgboost", "lightgbm", "catboost", "ensemble-methods",
-
-	// Frontend Frameworks & Libraries
-	"angular", "angular2", "angular-cli", "rxjs", "angular-material", "ngrx",
-	"react", "reactjs", "react-hooks", "react-router", "redux", "next-js", "gatsby",
-	"vue", "vuejs", "vue3", "nuxt", "vuex", "pinia",
-	"svelte", "sveltekit", "solid-js", "alpine-js",
-	"flutter", "flutter-framework", "flutter-ui", "flutter-mobile", "flutter-web",
-	"react-native", "ionic", "cordova", "phonegap",
-
-	// Backend Frameworks & Libraries
-	"express", "express-js", "koa", "fastify", "nest-js", "hapi",
-	"django", "flask", "fastapi", "tornado", "pyramid", "bottle",
-	"spring", "spring-boot", "spring-framework", "hibernate", "jpa",
-	"gin", "echo", "fiber", "beego", "iris", "gorilla-mux",
-	"actix", "axum", "warp", "rocket", "tokio", "async-std",
-	"rails", "ruby-on-rails", "sinatra", "hanami",
-	"laravel", "symfony", "codeigniter", "cakephp", "zend",
-	"asp-net", "dotnet", "entity-framework", "blazor",
-
-	// Databases - Primary Focus
-	"postgresql", "postgres", "pg", "postgis", "postgres-sql",
-	"mssql", "sql-server", "microsoft-sql-server", "t-sql", "sqlcmd",
-	"elasticsearch", "elastic", "kibana", "logstash", "elastic-stack", "elk",
-	"mongodb", "mongo", "mongoose", "mongo-db", "nosql", "document-database",
-	"mysql", "mariadb", "sqlite", "redis", "memcached", "cassandra",
-	"neo4j", "graph-database", "dynamodb", "cosmosdb", "firestore",
-	"influxdb", "timescaledb", "clickhouse", "bigquery", "snowflake",
-
-	// Development Tools & Platforms
-	"docker", "docker-compose", "dockerfile", "containers", "containerization",
-	"kubernetes", "k8s", "helm", "kubectl", "minikube", "kind",
-	"terraform", "ansible", "vagrant", "puppet", "chef", "salt",
-	"jenkins", "github-actions", "gitlab-ci", "circleci", "travis-ci",
-	"git", "github", "gitlab", "bitbucket", "version-control", "git-flow",
-	"vscode", "visual-studio-code", "intellij", "sublime-text", "atom",
-	"webpack", "vite", "rollup", "parcel", "esbuild", "turbopack",
-	"babel", "prettier", "eslint", "tslint", "stylelint", "husky",
-
-	// Cloud & Infrastructure
-	"aws", "amazon-web-services", "s3", "ec2", "lambda", "dynamodb",
-	"azure", "microsoft-azure", "azure-functions", "blob-storage",
-	"gcp", "google-cloud", "google-cloud-platform", "firebase", "firestore",
-	"heroku", "netlify", "vercel", "digital-ocean", "linode", "vultr",
-	"serverless", "faas", "functions-as-a-service", "microservices",
-	"graphql", "apollo", "relay", "prisma", "hasura", "supabase",
-
-	// Testing & Quality Assurance
-	"testing", "unit-testing", "integration-testing", "e2e-testing",
-	"jest", "mocha", "chai", "jasmine", "karma", "protractor",
-	"pytest", "unittest", "nose", "tox", "coverage", "mock",
-	"junit", "testng", "mockito", "selenium", "webdriver",
-	"cypress", "playwright", "puppeteer", "webdriverio",
-	"test-driven-development", "tdd", "behavior-driven-development", "bdd",
-	"continuous-integration", "ci", "continuous-deployment", "cd",
-
-	// Architecture & Design Patterns
-	"design-patterns", "solid-principles", "clean-architecture", "hexagonal-architecture",
-	"mvc", "mvp", "mvvm", "observer-pattern", "singleton-pattern", "factory-pattern",
-	"dependency-injection", "inversion-of-control", "repository-pattern",
-	"event-driven-architecture", "event-sourcing", "cqrs", "saga-pattern",
-	"microservices-architecture", "monolith", "distributed-systems",
-	"load-balancing", "caching", "performance-optimization", "scalability",
-
-	// Specific Programming Concepts
-	"algorithms", "data-structures", "sorting-algorithms", "graph-algorithms",
-	"dynamic-programming", "greedy-algorithms", "divide-and-conquer",
-	"binary-search", "hash-tables", "linked-lists", "trees", "graphs",
-	"concurrent-programming", "parallel-programming", "multithreading",
-	"asynchronous-programming", "async-await", "promises", "futures",
-	"functional-programming", "object-oriented-programming", "procedural-programming",
-
-	// API & Communication
-	"rest-api", "restful", "graphql", "grpc", "websockets", "sse",
-	"json", "xml", "yaml", "protobuf", "avro", "msgpack",
-	"swagger", "openapi", "postman", "insomnia", "api-testing",
-	"oauth", "jwt", "authentication", "authorization", "security",
-
-	// Mobile Development
-	"android", "android-development", "kotlin", "java-android",
-	"ios", "ios-development", "swift", "objective-c", "xcode",
-	"flutter-mobile", "react-native", "xamarin", "ionic-mobile",
-	"cordova", "phonegap", "native-script", "expo",
-
-	// Game Development
-	"game-development", "unity", "unreal-engine", "godot", "pygame",
-	"game-engine", "3d-graphics", "opengl", "vulkan", "directx",
-	"shader-programming", "game-physics", "collision-detection",
-
-	// Data Engineering & Big Data
-	"data-engineering", "etl", "data-pipeline", "apache-spark", "hadoop",
-	"kafka", "apache-kafka", "stream-processing", "batch-processing",
-	"airflow", "luigi", "prefect", "dagster", "dbt", "data-build-tool",
-	"pandas", "dask", "polars", "pyspark", "scala-spark",
-
-	// DevOps & Monitoring
-	"devops", "sre", "site-reliability-engineering", "monitoring",
-	"prometheus", "grafana", "jaeger", "zipkin", "new-relic", "datadog",
-	"logging", "log-aggregation", "fluentd", "logstash", "beats",
-	"infrastructure-as-code", "iac", "configuration-management",
-
-	// Security & Cryptography
-	"cryptography", "encryption", "hashing", "ssl", "tls", "certificates",
-	"oauth2", "openid-connect", "saml", "ldap", "active-directory",
-	"security-best-practices", "vulnerability-assessment", "penetration-testing",
-
-	// Programming Paradigms & Methodologies
-	"agile", "scrum", "kanban", "lean", "extreme-programming", "xp",
-	"pair-programming", "code-review", "refactoring", "technical-debt",
-	"clean-code", "code-quality", "static-analysis", "linting",
-
-	// Emerging Technologies
-	"blockchain", "smart-contracts", "ethereum", "solidity", "web3",
-	"cryptocurrency", "defi", "nft", "decentralized-applications", "dapps",
-	"quantum-computing", "quantum-algorithms", "qiskit",
-	"augmented-reality", "ar", "virtual-reality", "vr", "mixed-reality",
-	"iot", "internet-of-things", "embedded-systems", "raspberry-pi", "arduino",
-
-	// Language-Specific Ecosystems
-	"rust-actix", "rust-tokio", "rust-serde", "rust-diesel", "rust-wasm",
-	"rust-embedded", "rust-async", "rust-web-assembly", "rust-cli",
-	"go-gin", "go-echo", "go-gorilla", "go-gorm", "go-cobra", "go-viper",
-	"go-microservices", "go-concurrency", "go-channels", "go-context",
-	"python-django", "python-flask", "python-fastapi", "python-asyncio",
-	"python-celery", "python-requests", "python-sqlalchemy", "python-pydantic",
-	"typescript-node", "typescript-react", "typescript-angular", "typescript-vue",
-	"typescript-express", "typescript-nestjs", "typescript-graphql",
-
-	// Development Workflows
-	"gitflow", "github-flow", "trunk-based-development", "feature-branches",
-	"code-review", "pull-requests", "merge-requests", "pair-programming",
-	"continuous-integration", "continuous-deployment", "automated-testing",
-	"deployment-strategies", "blue-green-deployment", "canary-deployment",
-
-	// Performance & Optimization
-	"performance-optimization", "profiling", "benchmarking", "load-testing",
-	"memory-management", "garbage-collection", "caching-strategies",
-	"database-optimization", "query-optimization", "indexing",
-	"cdn", "content-delivery-network", "edge-computing",
-
-	// Documentation & Communication
-	"documentation", "technical-writing", "api-documentation", "readme",
-	"markdown", "gitbook", "confluence", "notion", "wiki",
-	"code-comments", "inline-documentation", "docstrings",
-
-	// Learning & Education
-	"coding-bootcamp", "programming-tutorial", "coding-interview",
-	"algorithm-practice", "competitive-programming", "leetcode",
-	"coding-challenges", "programming-exercises", "code-kata",
-	"open-source", "open-source-contribution", "hacktoberfest",
-
-	// Specific Tools & Libraries
-	"axios", "fetch", "lodash", "moment", "date-fns", "uuid",
-	"bcrypt", "jsonwebtoken", "passport", "helmet", "cors",
-	"express-validator", "joi", "yup", "formik", "react-hook-form",
-	"styled-components", "emotion", "tailwindcss", "bootstrap", "material-ui",
-	"ant-design", "chakra-ui", "semantic-ui", "bulma", "foundation",
-}
-
-func NewCrawler() (*Crawler, error) {
-	// Get Elasticsearch URL from environment with retry logic
-	esURL := os.Getenv("ELASTICSEARCH_URL")
-	if esURL == "" {
-		esURL = "http://elasticsearch:9200"
-	}
-
-	log.Printf("Connecting to Elasticsearch at: %s", esURL)
+// searchTermGroups are the built-in search terms by category. The category
+// labels the crawler's request metrics, so throttling or broken selectors
+// can be told apart per kind of search.
+var searchTermGroups = []struct {
+	category string
+	terms    []string
+}{
+	{"languages", []string{ // Core Programming Languages - Primary Focus
+		"rust", "rust-lang", "rustlang", "cargo", "rust-programming", "rust-development",
+		"golang", "go-lang", "go-programming", "go-development", "go-modules", "goroutines",
+		"python", "python3", "python-programming", "python-development", "pip", "conda",
+		"typescript", "ts", "typescript-programming", "deno", "bun",
+		"javascript", "js", "nodejs", "node-js", "npm", "yarn", "pnpm",
+		"dart", "dart-lang", "dart-programming", "flutter-dart",
+	}},
+	{"ai-ml", []string{ // AI & Machine Learning
+		"artificial-intelligence", "ai", "machine-learning", "ml", "deep-learning", "dl",
+		"neural-networks", "neural-network", "tensorflow", "pytorch", "keras", "scikit-learn",
+		"sklearn", "pandas", "numpy", "matplotlib", "seaborn", "jupyter", "notebook",
+		"data-science", "data-analysis", "data-mining", "data-visualization", "plotly",
+		"opencv", "computer-vision", "nlp", "natural-language-processing", "huggingface",
+		"transformers", "bert", "gpt", "llm", "large-language-model", "generative-ai",
+		"reinforcement-learning", "supervised-learning", "unsupervised-learning",
+		"gradient-boosting", "xgboost", "lightgbm", "catboost", "ensemble-methods",
+	}},
+	{"frontend", []string{ // Frontend Frameworks & Libraries
+		"angular", "angular2", "angular-cli", "rxjs", "angular-material", "ngrx",
+		"react", "reactjs", "react-hooks", "react-router", "redux", "next-js", "gatsby",
+		"vue", "vuejs", "vue3", "nuxt", "vuex", "pinia",
+		"svelte", "sveltekit", "solid-js", "alpine-js",
+		"flutter", "flutter-framework", "flutter-ui", "flutter-mobile", "flutter-web",
+		"react-native", "ionic", "cordova", "phonegap",
+	}},
+	{"backend", []string{ // Backend Frameworks & Libraries
+		"express", "express-js", "koa", "fastify", "nest-js", "hapi",
+		"django", "flask", "fastapi", "tornado", "pyramid", "bottle",
+		"spring", "spring-boot", "spring-framework", "hibernate", "jpa",
+		"gin", "echo", "fiber", "beego", "iris", "gorilla-mux",
+		"actix", "axum", "warp", "rocket", "tokio", "async-std",
+		"rails", "ruby-on-rails", "sinatra", "hanami",
+		"laravel", "symfony", "codeigniter", "cakephp", "zend",
+		"asp-net", "dotnet", "entity-framework", "blazor",
+	}},
+	{"databases", []string{ // Databases - Primary Focus
+		"postgresql", "postgres", "pg", "postgis", "postgres-sql",
+		"mssql", "sql-server", "microsoft-sql-server", "t-sql", "sqlcmd",
+		"elasticsearch", "elastic", "kibana", "logstash", "elastic-stack", "elk",
+		"mongodb", "mongo", "mongoose", "mongo-db", "nosql", "document-database",
+		"mysql", "mariadb", "sqlite", "redis", "memcached", "cassandra",
+		"neo4j", "graph-database", "dynamodb", "cosmosdb", "firestore",
+		"influxdb", "timescaledb", "clickhouse", "bigquery", "snowflake",
+	}},
+	{"dev-tools", []string{ // Development Tools & Platforms
+		"docker", "docker-compose", "dockerfile", "containers", "containerization",
+		"kubernetes", "k8s", "helm", "kubectl", "minikube", "kind",
+		"terraform", "ansible", "vagrant", "puppet", "chef", "salt",
+		"jenkins", "github-actions", "gitlab-ci", "circleci", "travis-ci",
+		"git", "github", "gitlab", "bitbucket", "version-control", "git-flow",
+		"vscode", "visual-studio-code", "intellij", "sublime-text", "atom",
+		"webpack", "vite", "rollup", "parcel", "esbuild", "turbopack",
+		"babel", "prettier", "eslint", "tslint", "stylelint", "husky",
+	}},
+	{"cloud", []string{ // Cloud & Infrastructure
+		"aws", "amazon-web-services", "s3", "ec2", "lambda", "dynamodb",
+		"azure", "microsoft-azure", "azure-functions", "blob-storage",
+		"gcp", "google-cloud", "google-cloud-platform", "firebase", "firestore",
+		"heroku", "netlify", "vercel", "digital-ocean", "linode", "vultr",
+		"serverless", "faas", "functions-as-a-service", "microservices",
+		"graphql", "apollo", "relay", "prisma", "hasura", "supabase",
+	}},
+	{"testing", []string{ // Testing & Quality Assurance
+		"testing", "unit-testing", "integration-testing", "e2e-testing",
+		"jest", "mocha", "chai", "jasmine", "karma", "protractor",
+		"pytest", "unittest", "nose", "tox", "coverage", "mock",
+		"junit", "testng", "mockito", "selenium", "webdriver",
+		"cypress", "playwright", "puppeteer", "webdriverio",
+		"test-driven-development", "tdd", "behavior-driven-development", "bdd",
+		"continuous-integration", "ci", "continuous-deployment", "cd",
+	}},
+	{"architecture", []string{ // Architecture & Design Patterns
+		"design-patterns", "solid-principles", "clean-architecture", "hexagonal-architecture",
+		"mvc", "mvp", "mvvm", "observer-pattern", "singleton-pattern", "factory-pattern",
+		"dependency-injection", "inversion-of-control", "repository-pattern",
+		"event-driven-architecture", "event-sourcing", "cqrs", "saga-pattern",
+		"microservices-architecture", "monolith", "distributed-systems",
+		"load-balancing", "caching", "performance-optimization", "scalability",
+	}},
+	{"concepts", []string{ // Specific Programming Concepts
+		"algorithms", "data-structures", "sorting-algorithms", "graph-algorithms",
+		"dynamic-programming", "greedy-algorithms", "divide-and-conquer",
+		"binary-search", "hash-tables", "linked-lists", "trees", "graphs",
+		"concurrent-programming", "parallel-programming", "multithreading",
+		"asynchronous-programming", "async-await", "promises", "futures",
+		"functional-programming", "object-oriented-programming", "procedural-programming",
+	}},
+	{"api", []string{ // API & Communication
+		"rest-api", "restful", "graphql", "grpc", "websockets", "sse",
+		"json", "xml", "yaml", "protobuf", "avro", "msgpack",
+		"swagger", "openapi", "postman", "insomnia", "api-testing",
+		"oauth", "jwt", "authentication", "authorization", "security",
+	}},
+	{"mobile", []string{ // Mobile Development
+		"android", "android-development", "kotlin", "java-android",
+		"ios", "ios-development", "swift", "objective-c", "xcode",
+		"flutter-mobile", "react-native", "xamarin", "ionic-mobile",
+		"cordova", "phonegap", "native-script", "expo",
+	}},
+	{"gamedev", []string{ // Game Development
+		"game-development", "unity", "unreal-engine", "godot", "pygame",
+		"game-engine", "3d-graphics", "opengl", "vulkan", "directx",
+		"shader-programming", "game-physics", "collision-detection",
+	}},
+	{"data-engineering", []string{ // Data Engineering & Big Data
+		"data-engineering", "etl", "data-pipeline", "apache-spark", "hadoop",
+		"kafka", "apache-kafka", "stream-processing", "batch-processing",
+		"airflow", "luigi", "prefect", "dagster", "dbt", "data-build-tool",
+		"pandas", "dask", "polars", "pyspark", "scala-spark",
+	}},
+	{"devops", []string{ // DevOps & Monitoring
+		"devops", "sre", "site-reliability-engineering", "monitoring",
+		"prometheus", "grafana", "jaeger", "zipkin", "new-relic", "datadog",
+		"logging", "log-aggregation", "fluentd", "logstash", "beats",
+		"infrastructure-as-code", "iac", "configuration-management",
+	}},
+	{"security", []string{ // Security & Cryptography
+		"cryptography", "encryption", "hashing", "ssl", "tls", "certificates",
+		"oauth2", "openid-connect", "saml", "ldap", "active-directory",
+		"security-best-practices", "vulnerability-assessment", "penetration-testing",
+	}},
+	{"methodologies", []string{ // Programming Paradigms & Methodologies
+		"agile", "scrum", "kanban", "lean", "extreme-programming", "xp",
+		"pair-programming", "code-review", "refactoring", "technical-debt",
+		"clean-code", "code-quality", "static-analysis", "linting",
+	}},
+	{"emerging", []string{ // Emerging Technologies
+		"blockchain", "smart-contracts", "ethereum", "solidity", "web3",
+		"cryptocurrency", "defi", "nft", "decentralized-applications", "dapps",
+		"quantum-computing", "quantum-algorithms", "qiskit",
+		"augmented-reality", "ar", "virtual-reality", "vr", "mixed-reality",
+		"iot", "internet-of-things", "embedded-systems", "raspberry-pi", "arduino",
+	}},
+	{"ecosystems", []string{ // Language-Specific Ecosystems
+		"rust-actix", "rust-tokio", "rust-serde", "rust-diesel", "rust-wasm",
+		"rust-embedded", "rust-async", "rust-web-assembly", "rust-cli",
+		"go-gin", "go-echo", "go-gorilla", "go-gorm", "go-cobra", "go-viper",
+		"go-microservices", "go-concurrency", "go-channels", "go-context",
+		"python-django", "python-flask", "python-fastapi", "python-asyncio",
+		"python-celery", "python-requests", "python-sqlalchemy", "python-pydantic",
+		"typescript-node", "typescript-react", "typescript-angular", "typescript-vue",
+		"typescript-express", "typescript-nestjs", "typescript-graphql",
+	}},
+	{"workflows", []string{ // Development Workflows
+		"gitflow", "github-flow", "trunk-based-development", "feature-branches",
+		"code-review", "pull-requests", "merge-requests", "pair-programming",
+		"continuous-integration", "continuous-deployment", "automated-testing",
+		"deployment-strategies", "blue-green-deployment", "canary-deployment",
+	}},
+	{"performance", []string{ // Performance & Optimization
+		"performance-optimization", "profiling", "benchmarking", "load-testing",
+		"memory-management", "garbage-collection", "caching-strategies",
+		"database-optimization", "query-optimization", "indexing",
+		"cdn", "content-delivery-network", "edge-computing",
+	}},
+	{"documentation", []string{ // Documentation & Communication
+		"documentation", "technical-writing", "api-documentation", "readme",
+		"markdown", "gitbook", "confluence", "notion", "wiki",
+		"code-comments", "inline-documentation", "docstrings",
+	}},
+	{"learning", []string{ // Learning & Education
+		"coding-bootcamp", "programming-tutorial", "coding-interview",
+		"algorithm-practice", "competitive-programming", "leetcode",
+		"coding-challenges", "programming-exercises", "code-kata",
+		"open-source", "open-source-contribution", "hacktoberfest",
+	}},
+	{"libraries", []string{ // Specific Tools & Libraries
+		"axios", "fetch", "lodash", "moment", "date-fns", "uuid",
+		"bcrypt", "jsonwebtoken", "passport", "helmet", "cors",
+		"express-validator", "joi", "yup", "formik", "react-hook-form",
+		"styled-components", "emotion", "tailwindcss", "bootstrap", "material-ui",
+		"ant-design", "chakra-ui", "semantic-ui", "bulma", "foundation",
+	}},
+}
+
+// codingSearchTerms are the built-in search terms, in group order
+var codingSearchTerms = func() []string {
+	var terms []string
+	for _, group := range searchTermGroups {
+		terms = append(terms, group.terms...)
+	}
+	return terms
+}()
+
+// termCategories maps each built-in term to its group's category. Terms in
+// several groups keep the first.
+var termCategories = func() map[string]string {
+	categories := make(map[string]string)
+	for _, group := range searchTermGroups {
+		for _, term := range group.terms {
+			if _, ok := categories[term]; !ok {
+				categories[term] = group.category
+			}
+		}
+	}
+	return categories
+}()
+
+// termCategory returns the metric label for term: its group's category,
+// "languages" for other terms naming a language, "custom" for the rest of
+// SEARCH_TERMS and "none" for pages not found by a term
+func termCategory(term string) string {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if term == "" {
+		return "none"
+	}
+	if category, ok := termCategories[term]; ok {
+		return category
+	}
+	if termLanguage(term) != "" {
+		return "languages"
+	}
+	return "custom"
+}
+
+// parseLineList reads one entry per line (search terms, proxies), skipping
+// blank lines and lines starting with '#'. Trailing " # comment" text is
+// stripped; a '#' inside an entry, as in "c#", is kept.
+func parseLineList(r io.Reader) ([]string, error) {
+	var terms []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for i := 1; i < len(line); i++ {
+			if line[i] == '#' && (line[i-1] == ' ' || line[i-1] == '\t') {
+				line = strings.TrimSpace(line[:i])
+				break
+			}
+		}
+		if line == "" {
+			continue
+		}
+		terms = append(terms, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return terms, nil
+}
+
+// validateSearchTerms rejects empty and duplicate (case-insensitive) terms
+func validateSearchTerms(terms []string) error {
+	if len(terms) == 0 {
+		return fmt.Errorf("no search terms provided")
+	}
+
+	seen := make(map[string]bool, len(terms))
+	for i, term := range terms {
+		normalized := strings.ToLower(strings.TrimSpace(term))
+		if normalized == "" {
+			return fmt.Errorf("search term %d is empty", i+1)
+		}
+		if seen[normalized] {
+			return fmt.Errorf("duplicate search term: %q", term)
+		}
+		seen[normalized] = true
+	}
+	return nil
+}
+
+// languageAliases maps the words search terms are built from to the
+// language they're primarily about, for language targets
+var languageAliases = map[string]string{
+	"rust": "rust", "rustlang": "rust", "cargo": "rust",
+	"go": "go", "golang": "go", "goroutines": "go",
+	"python": "python", "python3": "python", "pip": "python", "conda": "python", "django": "python", "flask": "python",
+	"typescript": "typescript", "ts": "typescript", "deno": "typescript",
+	"javascript": "javascript", "js": "javascript", "nodejs": "javascript", "node": "javascript", "npm": "javascript",
+	"dart": "dart", "flutter": "dart",
+	"java": "java", "kotlin": "kotlin", "swift": "swift", "ruby": "ruby", "php": "php",
+	"c": "c", "cpp": "c++", "c++": "c++", "csharp": "c#", "dotnet": "c#",
+}
+
+// termLanguage returns the lowercased language term is primarily about, or
+// "" for terms like "machine-learning" that span languages. The whole term
+// is tried first, then its dash-separated words in order.
+func termLanguage(term string) string {
+	lower := strings.ToLower(strings.TrimSpace(term))
+	if lang, ok := languageAliases[lower]; ok {
+		return lang
+	}
+	for _, part := range strings.Split(lower, "-") {
+		if lang, ok := languageAliases[part]; ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// parseLanguageTargets parses "Rust=2000,Dart=500" into per-language
+// targets keyed by lowercased language
+func parseLanguageTargets(spec string) (map[string]int64, error) {
+	targets := make(map[string]int64)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		lang, count, ok := strings.Cut(entry, "=")
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		if !ok || lang == "" {
+			return nil, fmt.Errorf("invalid target %q (expected language=count)", entry)
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(count), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid target %q: count must be a positive integer", entry)
+		}
+		if _, dup := targets[lang]; dup {
+			return nil, fmt.Errorf("duplicate target for %s", lang)
+		}
+		targets[lang] = n
+	}
+	return targets, nil
+}
+
+// loadSearchTerms resolves the effective search terms. SEARCH_TERMS
+// (comma-separated) takes precedence over SEARCH_TERMS_FILE, and the
+// built-in codingSearchTerms list is used when neither is set.
+func loadSearchTerms() ([]string, error) {
+	if raw := os.Getenv("SEARCH_TERMS"); raw != "" {
+		var terms []string
+		for _, term := range strings.Split(raw, ",") {
+			terms = append(terms, strings.TrimSpace(term))
+		}
+		if err := validateSearchTerms(terms); err != nil {
+			return nil, fmt.Errorf("invalid SEARCH_TERMS: %w", err)
+		}
+		return terms, nil
+	}
+
+	if path := os.Getenv("SEARCH_TERMS_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open search terms file: %w", err)
+		}
+		defer f.Close()
+
+		terms, err := parseLineList(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read search terms file: %w", err)
+		}
+		if err := validateSearchTerms(terms); err != nil {
+			return nil, fmt.Errorf("invalid search terms file %s: %w", path, err)
+		}
+		return terms, nil
+	}
+
+	return codingSearchTerms, nil
+}
+
+// filterSearchTerms keeps the terms matching any of the comma-separated
+// filters. A term matches when it equals a filter or one of its
+// hyphen-separated parts does, so "rust" selects "rust" and "rust-tokio"
+// but not "trust-store".
+func filterSearchTerms(terms []string, filter string) []string {
+	var filters []string
+	for _, f := range strings.Split(filter, ",") {
+		if f = strings.ToLower(strings.TrimSpace(f)); f != "" {
+			filters = append(filters, f)
+		}
+	}
+	if len(filters) == 0 {
+		return terms
+	}
+
+	var filtered []string
+	for _, term := range terms {
+		lower := strings.ToLower(term)
+		parts := strings.Split(lower, "-")
+	match:
+		for _, f := range filters {
+			if lower == f {
+				filtered = append(filtered, term)
+				break
+			}
+			for _, part := range parts {
+				if part == f {
+					filtered = append(filtered, term)
+					break match
+				}
+			}
+		}
+	}
+	return filtered
+}
+
+// crawlCheckpoint records which (term, page) pairs finished so an
+// interrupted crawl can resume where it left off.
+type crawlCheckpoint struct {
+	mu        sync.Mutex
+	path      string
+	Completed map[string][]int `json:"completed"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// loadCheckpoint reads the checkpoint at path, returning an empty one if the
+// file doesn't exist yet.
+func loadCheckpoint(path string) (*crawlCheckpoint, error) {
+	cp := &crawlCheckpoint{path: path, Completed: make(map[string][]int)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	if cp.Completed == nil {
+		cp.Completed = make(map[string][]int)
+	}
+	return cp, nil
+}
+
+// IsDone reports whether the page for term was completed in a previous run
+func (cp *crawlCheckpoint) IsDone(term string, page int) bool {
+	if cp == nil {
+		return false
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	for _, p := range cp.Completed[term] {
+		if p == page {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkDone records a completed page and persists the checkpoint
+func (cp *crawlCheckpoint) MarkDone(term string, page int) error {
+	if cp == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.Completed[term] = append(cp.Completed[term], page)
+	return cp.saveLocked()
+}
+
+// Count returns the number of completed (term, page) pairs
+func (cp *crawlCheckpoint) Count() int {
+	if cp == nil {
+		return 0
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	count := 0
+	for _, pages := range cp.Completed {
+		count += len(pages)
+	}
+	return count
+}
+
+// Reset discards all progress and removes the checkpoint file
+func (cp *crawlCheckpoint) Reset() error {
+	if cp == nil {
+		return nil
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	cp.Completed = make(map[string][]int)
+	if err := os.Remove(cp.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove checkpoint: %w", err)
+	}
+	return nil
+}
+
+// saveLocked writes the checkpoint via a temp file so a crash mid-write
+// never leaves a truncated file behind. cp.mu must be held.
+func (cp *crawlCheckpoint) saveLocked() error {
+	cp.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(cp.path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create checkpoint directory: %w", err)
+		}
+	}
+
+	tmp := cp.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+// envPositiveInt reads a positive integer from the environment, returning
+// def when the variable is unset
+func envPositiveInt(name string, def int) (int, error) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive integer", name, raw)
+	}
+	return n, nil
+}
+
+// proxyEntry is one upstream proxy with its own transport and rate limiter
+type proxyEntry struct {
+	url            *url.URL
+	client         *http.Client
+	limiter        *rate.Limiter
+	failures       int
+	unhealthyUntil time.Time
+}
+
+// label identifies the proxy in logs and metrics
+func (p *proxyEntry) label() string {
+	if p == nil {
+		return "direct"
+	}
+	return p.url.Host
+}
+
+// proxyPool rotates requests across proxies round-robin. A proxy that fails
+// maxFailures times in a row is benched for cooldown; once that elapses it
+// is re-probed by routing the next request through it.
+type proxyPool struct {
+	mu          sync.Mutex
+	proxies     []*proxyEntry
+	next        int
+	maxFailures int
+	cooldownFor time.Duration
+	now         func() time.Time
+}
+
+// parseProxyList reads PROXY_LIST, which is either a path to a file with
+// one proxy per line or a comma-separated list. Entries without a scheme
+// are treated as http:// proxies.
+func parseProxyList(value string) ([]*url.URL, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var entries []string
+	if f, err := os.Open(value); err == nil {
+		defer f.Close()
+		if entries, err = parseLineList(f); err != nil {
+			return nil, fmt.Errorf("failed to read proxy list %s: %w", value, err)
+		}
+	} else {
+		for _, entry := range strings.Split(value, ",") {
+			if entry = strings.TrimSpace(entry); entry != "" {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	var proxies []*url.URL
+	for _, entry := range entries {
+		if !strings.Contains(entry, "://") {
+			entry = "http://" + entry
+		}
+		u, err := url.Parse(entry)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q", entry)
+		}
+		proxies = append(proxies, u)
+	}
+	return proxies, nil
+}
+
+// newProxyPool builds a transport, client and rate limiter per proxy
+func newProxyPool(proxyURLs []*url.URL, newLimiter func() *rate.Limiter, maxFailures int, cooldown time.Duration) *proxyPool {
+	pool := &proxyPool{maxFailures: maxFailures, cooldownFor: cooldown, now: time.Now}
+
+	for _, u := range proxyURLs {
+		transport := &http.Transport{
+			Proxy: http.ProxyURL(u),
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 10,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+			ForceAttemptHTTP2:   true,
+		}
+
+		pool.proxies = append(pool.proxies, &proxyEntry{
+			url:     u,
+			client:  &http.Client{Timeout: 30 * time.Second, Transport: transport},
+			limiter: newLimiter(),
+		})
+	}
+	return pool
+}
+
+// pick returns the next healthy proxy. If every proxy is benched, the one
+// whose cooldown ends first is returned so it gets re-probed once
+// benchedUntil has passed. A nil pool returns nil, meaning requests go out
+// directly.
+func (p *proxyPool) pick() *proxyEntry {
+	if p == nil || len(p.proxies) == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var soonest *proxyEntry
+	for i := 0; i < len(p.proxies); i++ {
+		entry := p.proxies[(p.next+i)%len(p.proxies)]
+		if !entry.unhealthyUntil.After(now) {
+			p.next = (p.next + i + 1) % len(p.proxies)
+			return entry
+		}
+		if soonest == nil || entry.unhealthyUntil.Before(soonest.unhealthyUntil) {
+			soonest = entry
+		}
+	}
+	return soonest
+}
+
+// report records the outcome of a request made through entry
+func (p *proxyPool) report(entry *proxyEntry, ok bool) {
+	if p == nil || entry == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ok {
+		entry.failures = 0
+		metrics.IncrCounterWithLabels("crawler_proxy_success_total", map[string]string{"proxy": entry.label()}, 1)
+		return
+	}
+
+	entry.failures++
+	metrics.IncrCounterWithLabels("crawler_proxy_failure_total", map[string]string{"proxy": entry.label()}, 1)
+	if entry.failures >= p.maxFailures {
+		entry.unhealthyUntil = p.now().Add(p.cooldownFor)
+		slog.Warn("Proxy marked unhealthy", "proxy", entry.label(), "failures", entry.failures, "cooldown", p.cooldownFor)
+	}
+}
+
+// benchedUntil returns when entry comes off the bench, or the zero time for
+// no proxy
+func (p *proxyPool) benchedUntil(entry *proxyEntry) time.Time {
+	if p == nil || entry == nil {
+		return time.Time{}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return entry.unhealthyUntil
+}
+
+// cooldown benches entry for at least wait, e.g. after a 429 with Retry-After
+func (p *proxyPool) cooldown(entry *proxyEntry, wait time.Duration) {
+	if p == nil || entry == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if until := p.now().Add(wait); until.After(entry.unhealthyUntil) {
+		entry.unhealthyUntil = until
+	}
+}
+
+// headerProfile is a set of request headers a real browser sends, e.g.
+// User-Agent, Accept-Language and the Sec-CH-UA client hints
+type headerProfile struct {
+	Name    string            `json:"name"`
+	Headers map[string]string `json:"headers"`
+}
+
+// defaultHeaderProfiles are used when HEADER_PROFILES isn't set. Firefox
+// and Safari don't send client hints, so their profiles have none.
+var defaultHeaderProfiles = []headerProfile{
+	{Name: "chrome-windows", Headers: map[string]string{
+		"User-Agent":         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		"Accept":             "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+		"Accept-Language":    "en-US,en;q=0.9",
+		"Sec-CH-UA":          `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`,
+		"Sec-CH-UA-Mobile":   "?0",
+		"Sec-CH-UA-Platform": `"Windows"`,
+	}},
+	{Name: "chrome-macos", Headers: map[string]string{
+		"User-Agent":         "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36",
+		"Accept":             "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+		"Accept-Language":    "en-GB,en;q=0.9",
+		"Sec-CH-UA":          `"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`,
+		"Sec-CH-UA-Mobile":   "?0",
+		"Sec-CH-UA-Platform": `"macOS"`,
+	}},
+	{Name: "edge-windows", Headers: map[string]string{
+		"User-Agent":         "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36 Edg/124.0.0.0",
+		"Accept":             "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8",
+		"Accept-Language":    "en-US,en;q=0.9,de;q=0.8",
+		"Sec-CH-UA":          `"Chromium";v="124", "Microsoft Edge";v="124", "Not-A.Brand";v="99"`,
+		"Sec-CH-UA-Mobile":   "?0",
+		"Sec-CH-UA-Platform": `"Windows"`,
+	}},
+	{Name: "firefox-linux", Headers: map[string]string{
+		"User-Agent":      "Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+		"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		"Accept-Language": "en-US,en;q=0.5",
+	}},
+	{Name: "safari-macos", Headers: map[string]string{
+		"User-Agent":      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+		"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+		"Accept-Language": "en-US,en;q=0.9",
+	}},
+}
+
+// loadHeaderProfiles reads a JSON array of profiles from path, or returns
+// defaultHeaderProfiles when path is empty. Every profile needs a name and
+// a User-Agent.
+func loadHeaderProfiles(path string) ([]headerProfile, error) {
+	if path == "" {
+		return defaultHeaderProfiles, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header profiles: %w", err)
+	}
+	var profiles []headerProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to parse header profiles %s: %w", path, err)
+	}
+	if len(profiles) == 0 {
+		return nil, fmt.Errorf("no header profiles in %s", path)
+	}
+	for i, profile := range profiles {
+		// Header names are matched the way net/http writes them
+		headers := make(map[string]string, len(profile.Headers))
+		for name, value := range profile.Headers {
+			headers[http.CanonicalHeaderKey(name)] = value
+		}
+		if profile.Name == "" || headers["User-Agent"] == "" {
+			return nil, fmt.Errorf("header profile %d in %s needs a name and a User-Agent", i, path)
+		}
+		profiles[i].Headers = headers
+	}
+	return profiles, nil
+}
+
+// headerRotator picks a header profile for each request. Requests for the
+// same search term keep the profile first picked for it, so paging through
+// a term looks like one browser; requests without a term get a new pick.
+type headerRotator struct {
+	mu       sync.Mutex
+	profiles []headerProfile
+	rng      *rand.Rand
+	byTerm   map[string]int
+}
+
+// newHeaderRotator picks among profiles with rng
+func newHeaderRotator(profiles []headerProfile, rng *rand.Rand) *headerRotator {
+	return &headerRotator{profiles: profiles, rng: rng, byTerm: make(map[string]int)}
+}
+
+// pick returns the profile for a request about term
+func (h *headerRotator) pick(term string) headerProfile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if term == "" {
+		return h.profiles[h.rng.Intn(len(h.profiles))]
+	}
+	i, ok := h.byTerm[term]
+	if !ok {
+		i = h.rng.Intn(len(h.profiles))
+		h.byTerm[term] = i
+	}
+	return h.profiles[i]
+}
+
+// apply sets the headers of term's profile on req. A nil rotator leaves
+// req alone, so ghclient sends its own User-Agent.
+func (h *headerRotator) apply(req *http.Request, term string) string {
+	if h == nil {
+		return ""
+	}
+	profile := h.pick(term)
+	for name, value := range profile.Headers {
+		req.Header.Set(name, value)
+	}
+	return profile.Name
+}
+
+// connectElasticsearch connects to esURL, retrying while the cluster
+// starts up
+func connectElasticsearch(esURL string) (*elasticsearch.Client, error) {
+	slog.Info("Connecting to Elasticsearch", "url", esURL)
 
 	var esClient *elasticsearch.Client
 	var err error
@@ -298,7 +1068,7 @@ func NewCrawler() (*Crawler, error) {
 			// Test the connection
 			_, err = esClient.Info()
 			if err == nil {
-				log.Printf("Successfully connected to Elasticsearch")
+				slog.Info("Successfully connected to Elasticsearch")
 				break
 			}
 		}
@@ -307,7 +1077,7 @@ func NewCrawler() (*Crawler, error) {
 		if waitTime > 30*time.Second {
 			waitTime = 30 * time.Second
 		}
-		log.Printf("Elasticsearch not ready (attempt %d/10), waiting %v: %v", i+1, waitTime, err)
+		slog.Warn("Elasticsearch not ready", "attempt", i+1, "max_attempts", 10, "wait", waitTime, "error", err)
 		time.Sleep(waitTime)
 	}
 
@@ -315,6 +1085,12 @@ func NewCrawler() (*Crawler, error) {
 		return nil, fmt.Errorf("failed to create Elasticsearch client after retries: %w", err)
 	}
 
+	return esClient, nil
+}
+
+// NewCrawler creates a crawler writing to sink. esClient may be nil when
+// Elasticsearch isn't one of the configured sinks.
+func NewCrawler(esClient *elasticsearch.Client, sink Sink) (*Crawler, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Create HTTP client with connection pooling for better performance
@@ -332,11 +1108,22 @@ func NewCrawler() (*Crawler, error) {
 	return &Crawler{
 		client:      httpClient,
 		esClient:    esClient,
+		sink:        sink,
 		rateLimiter: rate.NewLimiter(rate.Every(3*time.Second), 1),
 		crawled:     make(map[string]bool),
 		ctx:         ctx,
 		cancel:      cancel,
 		stats:       &CrawlerStats{startTime: time.Now(), lastReported: time.Now()},
+		searchTerms: codingSearchTerms,
+		detailsMode: detailsAlways,
+		baseURL:     "https://github.com",
+		maxPages:    5,
+		concurrency: 2,
+		pageDelay:   2 * time.Second,
+
+		botBlockWait:  15 * time.Minute,
+		maxReadyPause: 10 * time.Minute,
+		logger:        slog.Default(),
 	}, nil
 }
 
@@ -366,50 +1153,190 @@ func parseNumber(s string) (int, error) {
 }
 
 func (c *Crawler) searchGitHub(term string, page int) ([]*Repository, error) {
-	if atomic.LoadInt32(&c.shutdown) == 1 {
-		return nil, fmt.Errorf("crawler is shutting down")
-	}
+	searchURL := fmt.Sprintf("%s/search?q=%s&type=repositories&p=%d",
+		c.baseURL, url.QueryEscape(term), page)
 
-	if err := c.rateLimiter.Wait(c.ctx); err != nil {
-		return nil, err
-	}
-
-	searchURL := fmt.Sprintf("https://github.com/search?q=%s&type=repositories&p=%d",
-		url.QueryEscape(term), page)
-
-	req, err := http.NewRequestWithContext(c.ctx, "GET", searchURL, nil)
+	doc, err := c.fetchDocument(searchURL, pageSearch, term)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; CodeCrawler/1.0)")
-
-	resp, err := c.client.Do(req)
+	repos, err := c.parseRepositories(doc)
 	if err != nil {
-		return nil, err
+		return nil, &fetchError{class: statusParseError, err: err}
 	}
-	defer resp.Body.Close()
+	return repos, nil
+}
 
-	if resp.StatusCode == 429 {
-		return nil, c.handleRateLimit(resp)
+// fetchDocument GETs a page and parses it as HTML. A 429 response pauses
+// the shared rate limiter for the Retry-After period and the same request
+// is retried in place, up to maxRateLimitRetries times. Through a proxy
+// pool only the proxy is benched, and the retry goes through the next one,
+// waiting for its bench to end if every proxy is benched. Every attempt is
+// counted in crawler_requests_total by kind of page, status class and the
+// category of the term the page is for; errors are *fetchError.
+func (c *Crawler) fetchDocument(target, kind, term string) (*goquery.Document, error) {
+	count := func(class string) {
+		metrics.IncrCounterWithLabels("crawler_requests_total", map[string]string{
+			"kind": kind, "status_class": class, "term_category": termCategory(term),
+		}, 1)
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
-	}
+	for attempt := 0; ; attempt++ {
+		if atomic.LoadInt32(&c.shutdown) == 1 {
+			return nil, fmt.Errorf("crawler is shutting down")
+		}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+		// With a proxy pool each proxy has its own client and rate limiter
+		client, limiter := c.client, c.rateLimiter
+		proxy := c.proxies.pick()
+		if proxy != nil {
+			client, limiter = proxy.client, proxy.limiter
+		}
 
-	return c.parseRepositories(doc)
-}
+		if err := c.waitForTurn(limiter, c.proxies.benchedUntil(proxy)); err != nil {
+			return nil, err
+		}
 
-func (c *Crawler) parseRepositories(doc *goquery.Document) ([]*Repository, error) {
-	var repos []*Repository
+		req, err := http.NewRequestWithContext(c.ctx, "GET", target, nil)
+		if err != nil {
+			return nil, err
+		}
+		profile := c.headers.apply(req, term)
+
+		// Do may read the body to cache it, so the time to first byte is
+		// taken from the trace rather than from when Do returns
+		start := time.Now()
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotFirstResponseByte: func() {
+				metrics.ObserveHistogramWithLabels("crawler_time_to_first_byte_seconds",
+					map[string]string{"kind": kind}, time.Since(start).Seconds())
+			},
+		}))
+		resp, err := ghclient.New(client, "", c.cache, "crawler").Do(req)
+		if err != nil {
+			c.proxies.report(proxy, false)
+			count(statusNetworkError)
+			return nil, &fetchError{class: statusNetworkError, err: fmt.Errorf("GET %s: %w", target, err)}
+		}
+		c.logger.Debug("Fetched page", "url", target, "status_code", resp.StatusCode,
+			"duration", time.Since(start), "attempt", attempt+1, "proxy", proxy.label(), "header_profile", profile)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			resp.Body.Close()
+			c.proxies.report(proxy, false)
+			count(statusClass(resp.StatusCode))
+			if attempt >= maxRateLimitRetries {
+				return nil, &fetchError{class: statusClass(resp.StatusCode),
+					err: fmt.Errorf("%w: giving up after %d retries", errRateLimited, attempt)}
+			}
+			wait := parseRetryAfter(resp.Header.Get("Retry-After"))
+			if proxy != nil {
+				// Only this proxy is limited; retry through the next one
+				c.proxies.cooldown(proxy, wait)
+				continue
+			}
+			c.handleRateLimit(wait)
+			continue
+		}
 
-	repoElements := doc.Find("div.search-title")
+		// 403s and 5xx usually mean the proxy's IP is blocked or broken.
+		// GitHub's bot-detection pages come as either 200 or 403, so both
+		// are parsed before deciding.
+		var doc *goquery.Document
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusForbidden {
+			doc, err = goquery.NewDocumentFromReader(resp.Body)
+		}
+		resp.Body.Close()
+
+		blockPage := ""
+		if doc != nil {
+			blockPage = blockPageKind(doc)
+		}
+		c.proxies.report(proxy, resp.StatusCode < 500 && resp.StatusCode != http.StatusForbidden && blockPage == "")
+
+		if blockPage != "" {
+			metrics.IncrCounterWithLabels("crawler_bot_blocked_total", map[string]string{"page": blockPage}, 1)
+			count(statusBlocked)
+			if attempt >= maxRateLimitRetries {
+				return nil, &fetchError{class: statusBlocked,
+					err: fmt.Errorf("%w: %s page, giving up after %d retries", errBotBlocked, blockPage, attempt)}
+			}
+			c.logger.Warn("GitHub served a bot-detection page", "page", blockPage, "url", target, "proxy", proxy.label())
+			if proxy != nil {
+				c.proxies.cooldown(proxy, c.botBlockWait)
+				continue
+			}
+			c.handleRateLimit(c.botBlockWait)
+			continue
+		}
+
+		count(statusClass(resp.StatusCode))
+		if resp.StatusCode != http.StatusOK {
+			return nil, &fetchError{class: statusClass(resp.StatusCode),
+				err: fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)}
+		}
+		if err != nil {
+			return nil, &fetchError{class: statusParseError, err: err}
+		}
+		return doc, nil
+	}
+}
+
+// blockPageKind recognizes the pages GitHub serves instead of the requested
+// one when it suspects a bot, returning blockPageAbuse, blockPageLogin or
+// "" for a normal page. Without this they parse as pages with no results.
+func blockPageKind(doc *goquery.Document) string {
+	title := strings.ToLower(doc.Find("title").First().Text())
+
+	// The abuse page's message is a bare container under <body>. Only that
+	// is read, since READMEs and descriptions on repo pages can quote it.
+	notice := strings.ToLower(doc.Find("body > .container-lg").Text())
+	if strings.Contains(title, "whoa there") || strings.Contains(notice, "whoa there!") ||
+		strings.Contains(notice, "abuse detection mechanism") || strings.Contains(notice, "secondary rate limit") {
+		return blockPageAbuse
+	}
+	// Every logged-out page links to /login; only the interstitial has the
+	// sign-in form itself
+	if strings.HasPrefix(title, "sign in to github") || doc.Find(`form[action="/session"]`).Length() > 0 {
+		return blockPageLogin
+	}
+	return ""
+}
+
+// isEmptyResultsPage reports whether doc is a search that genuinely matched
+// no repositories
+func isEmptyResultsPage(doc *goquery.Document) bool {
+	body := strings.ToLower(strings.ReplaceAll(doc.Find("body").Text(), "’", "'"))
+	return strings.Contains(body, "did not match any repositories") ||
+		strings.Contains(body, "couldn't find any repositories")
+}
+
+// waitForTurn blocks until any rate-limit pause has elapsed, and notBefore
+// has passed, and the given rate limiter grants a token.
+func (c *Crawler) waitForTurn(limiter *rate.Limiter, notBefore time.Time) error {
+	until := time.Unix(0, atomic.LoadInt64(&c.pausedUntil))
+	if notBefore.After(until) {
+		until = notBefore
+	}
+	if wait := time.Until(until); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-timer.C:
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+
+	return limiter.Wait(c.ctx)
+}
+
+func (c *Crawler) parseRepositories(doc *goquery.Document) ([]*Repository, error) {
+	var repos []*Repository
+
+	repoElements := doc.Find("div.search-title")
 	if repoElements.Length() == 0 {
 		repoElements = doc.Find("h3.f4")
 	}
@@ -418,6 +1345,9 @@ func (c *Crawler) parseRepositories(doc *goquery.Document) ([]*Repository, error
 	}
 
 	if repoElements.Length() == 0 {
+		if isEmptyResultsPage(doc) {
+			return nil, nil
+		}
 		return nil, fmt.Errorf("no repository elements found on page")
 	}
 
@@ -452,90 +1382,227 @@ func (c *Crawler) parseRepositories(doc *goquery.Document) ([]*Repository, error
 			return
 		}
 
-		repo := &Repository{
+		repo := &Repository{RepoInfo: models.RepoInfo{
 			Name:      parts[1],
 			FullName:  fullName,
 			URL:       "https://github.com" + href,
+			Source:    sourceSearch,
 			CrawledAt: time.Now(),
-		}
+		}}
 
-		parent := s.Parent()
-		for parent.Length() > 0 && !parent.Is("div.Box-row") && !parent.HasClass("search-result-item") {
-			parent = parent.Parent()
-			if parent.Is("body") || parent.HasClass("application-main") {
-				parent = s.Parent()
-				break
-			}
-		}
+		parseResultCard(findResultCard(s), repo)
+
+		repos = append(repos, repo)
+	})
+
+	return repos, nil
+}
+
+// findResultCard returns the element wrapping a single search result, so
+// that field lookups don't leak into neighbouring results.
+func findResultCard(s *goquery.Selection) *goquery.Selection {
+	isCard := func(sel *goquery.Selection) bool {
+		return sel.Is("div.Box-row, article.Box-row, li.repo-list-item") ||
+			sel.HasClass("search-result-item") ||
+			sel.Parent().Is("[data-testid='results-list']")
+	}
 
-		desc := parent.Find("p, .text-gray").First().Text()
-		repo.Description = strings.TrimSpace(desc)
+	if isCard(s) {
+		return s
+	}
 
-		// Try multiple selectors for language in search results
-		langSelectors := []string{
-			"span[itemprop='programmingLanguage']",
-			".ml-0.mr-3",
-			"[data-search-type='code'] span",
-			".text-gray span:first-child",
-			".f6 span:first-child",
+	for parent := s.Parent(); parent.Length() > 0; parent = parent.Parent() {
+		if parent.Is("body") || parent.HasClass("application-main") {
+			break
+		}
+		if isCard(parent) {
+			return parent
 		}
+	}
+
+	return s.Parent()
+}
+
+// parseResultCard fills in whatever metadata a search result card exposes:
+// description, language, stars, forks and the last update timestamp.
+func parseResultCard(card *goquery.Selection, repo *Repository) {
+	desc := card.Find("p, .text-gray, .search-match").First().Text()
+	repo.Description = strings.TrimSpace(desc)
 
-		for _, selector := range langSelectors {
-			langSpan := parent.Find(selector).First()
-			if langSpan.Length() > 0 {
-				lang := strings.TrimSpace(langSpan.Text())
-				if lang != "" && !strings.Contains(lang, "Update") && !strings.Contains(lang, "ago") {
-					// Clean up language string - remove percentages and extra whitespace
-					lang = cleanLanguageString(lang)
-					if lang != "" {
-						repo.Language = lang
-						break
-					}
+	// Try multiple selectors for language in search results
+	langSelectors := []string{
+		"span[aria-label$='language']",
+		"span[itemprop='programmingLanguage']",
+		".ml-0.mr-3",
+		"[data-search-type='code'] span",
+		".text-gray span:first-child",
+		".f6 span:first-child",
+	}
+
+	for _, selector := range langSelectors {
+		langSpan := card.Find(selector).First()
+		if langSpan.Length() > 0 {
+			lang := strings.TrimSpace(langSpan.Text())
+			if lang != "" && !strings.Contains(lang, "Update") && !strings.Contains(lang, "ago") {
+				// Clean up language string - remove percentages and extra whitespace
+				lang = cleanLanguageString(lang)
+				if lang != "" {
+					repo.Language = lang
+					break
 				}
 			}
 		}
+	}
 
-		repos = append(repos, repo)
-	})
+	starsElem := card.Find("a[href$='/stargazers']").First()
+	if starsElem.Length() > 0 {
+		if stars, err := parseNumber(starsElem.Text()); err == nil {
+			repo.Stars = stars
+			repo.starsFromCard = true
+		}
+	}
 
-	return repos, nil
+	forksElem := card.Find("a[href$='/forks'], a[href$='/network/members']").First()
+	if forksElem.Length() > 0 {
+		if forks, err := parseNumber(forksElem.Text()); err == nil {
+			repo.Forks = forks
+		}
+	}
+
+	if updated := parseTimestamp(card.Find("relative-time").First()); updated != nil {
+		repo.LastUpdated = updated
+	} else {
+		// Newer cards render plain "Updated 3 days ago" text without a datetime
+		card.Find("span, div, li").EachWithBreak(func(i int, s *goquery.Selection) bool {
+			text := strings.TrimSpace(s.Text())
+			if !strings.HasPrefix(text, "Updated") || len(text) > 40 {
+				return true
+			}
+			if updated, err := parseRelativeTime(text, time.Now()); err == nil {
+				repo.LastUpdated = &updated
+				return false
+			}
+			return true
+		})
+	}
 }
 
-func (c *Crawler) scrapeRepoDetails(repo *Repository) error {
-	startTime := time.Now()
+var relativeTimePattern = regexp.MustCompile(`^(a|an|\d+)\s+(second|minute|hour|day|week|month|year)s?\s+ago$`)
+
+// parseRelativeTime converts GitHub's human readable timestamps such as
+// "Updated 3 days ago", "yesterday", "last month" or "on Jan 6, 2024" into
+// an absolute time relative to now. RFC 3339 strings are accepted as well.
+func parseRelativeTime(text string, now time.Time) (time.Time, error) {
+	s := strings.ToLower(strings.TrimSpace(text))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "updated"))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "on "))
+
+	switch s {
+	case "":
+		return time.Time{}, fmt.Errorf("empty timestamp")
+	case "now", "just now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	case "last week":
+		return now.AddDate(0, 0, -7), nil
+	case "last month":
+		return now.AddDate(0, -1, 0), nil
+	case "last year":
+		return now.AddDate(-1, 0, 0), nil
+	}
 
-	if atomic.LoadInt32(&c.shutdown) == 1 {
-		return fmt.Errorf("crawler is shutting down")
+	if m := relativeTimePattern.FindStringSubmatch(s); m != nil {
+		n := 1
+		if m[1] != "a" && m[1] != "an" {
+			n, _ = strconv.Atoi(m[1])
+		}
+
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
 	}
 
-	if err := c.rateLimiter.Wait(c.ctx); err != nil {
-		metrics.IncrCounter("crawler_scrape_errors_total", 1)
-		return err
+	if t, err := time.Parse(time.RFC3339, strings.TrimSpace(text)); err == nil {
+		return t, nil
 	}
 
-	req, err := http.NewRequestWithContext(c.ctx, "GET", repo.URL, nil)
-	if err != nil {
-		return err
+	for _, layout := range []string{"Jan 2, 2006", "Jan 2 2006", "2 Jan 2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	// Dates in the current year are rendered without one ("on Mar 4")
+	for _, layout := range []string{"Jan 2", "2 Jan"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now) {
+				t = t.AddDate(-1, 0, 0)
+			}
+			return t, nil
+		}
 	}
 
-	req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; CodeCrawler/1.0)")
+	return time.Time{}, fmt.Errorf("unrecognized timestamp: %q", text)
+}
 
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return err
+// parseTimestamp reads a <relative-time> style element, preferring its
+// machine readable datetime attribute over the rendered text.
+func parseTimestamp(sel *goquery.Selection) *time.Time {
+	if sel.Length() == 0 {
+		return nil
+	}
+
+	if datetime, exists := sel.Attr("datetime"); exists {
+		if t, err := time.Parse(time.RFC3339, datetime); err == nil {
+			return &t
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 429 {
-		return c.handleRateLimit(resp)
+	if t, err := parseRelativeTime(sel.Text(), time.Now()); err == nil {
+		return &t
 	}
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, resp.Status)
+	return nil
+}
+
+// needsDetails reports whether the repository page has to be fetched given
+// the configured details mode and what the search card already provided.
+func (c *Crawler) needsDetails(repo *Repository) bool {
+	switch c.detailsMode {
+	case detailsNever:
+		return false
+	case detailsMissing:
+		return !repo.starsFromCard || repo.Language == ""
+	default:
+		return true
 	}
+}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+func (c *Crawler) scrapeRepoDetails(repo *Repository) error {
+	startTime := time.Now()
+
+	doc, err := c.fetchDocument(repo.URL, pageRepo, repo.term)
 	if err != nil {
+		metrics.IncrCounterWithLabels("crawler_scrape_errors_total", map[string]string{
+			"status_class": errorClass(err), "term_category": termCategory(repo.term),
+		}, 1)
+		metrics.ObserveHistogramWithLabels("crawler_scrape_duration_seconds",
+			map[string]string{"outcome": "error"}, time.Since(startTime).Seconds())
 		return err
 	}
 
@@ -619,60 +1686,79 @@ func (c *Crawler) scrapeRepoDetails(repo *Repository) error {
 				lang = cleanLanguageString(lang)
 				if lang != "" {
 					repo.Language = lang
-					log.Printf("DEBUG: Found language '%s' for %s using selector: %s", lang, repo.FullName, selector)
+					c.logger.Debug("Found language", "repo", repo.FullName, "language", lang, "selector", selector)
 					break
 				}
 			}
 		}
 	}
 
-	log.Printf("DEBUG: Scraped %s - Stars: %d, Forks: %d, Topics: %v",
-		repo.FullName, repo.Stars, repo.Forks, repo.Topics)
+	// The first timestamp on the repo page belongs to the latest commit
+	for _, selector := range []string{
+		"[data-testid='latest-commit'] relative-time",
+		".js-details-container relative-time",
+		"relative-time",
+	} {
+		if updated := parseTimestamp(doc.Find(selector).First()); updated != nil {
+			repo.LastUpdated = updated
+			break
+		}
+	}
+
+	duration := time.Since(startTime)
+	c.logger.Debug("Scraped repository", "repo", repo.FullName, "stars", repo.Stars,
+		"forks", repo.Forks, "topics", repo.Topics, "duration", duration)
 
 	// Record metrics
-	duration := time.Since(startTime).Seconds()
-	metrics.ObserveHistogram("crawler_scrape_duration_seconds", duration)
+	metrics.ObserveHistogramWithLabels("crawler_scrape_duration_seconds",
+		map[string]string{"outcome": "success"}, duration.Seconds())
 	metrics.IncrCounter("crawler_repos_scraped_total", 1)
 
 	return nil
 }
 
-func (c *Crawler) handleRateLimit(resp *http.Response) error {
-	retryAfter := resp.Header.Get("Retry-After")
-	if retryAfter == "" {
-		retryAfter = "60"
-	}
+// parseRetryAfter converts a Retry-After header (delay in seconds or an
+// HTTP date) into a wait duration, defaulting to 60 seconds.
+func parseRetryAfter(value string) time.Duration {
+	const defaultWait = 60 * time.Second
 
-	waitTime, err := strconv.Atoi(retryAfter)
-	if err != nil {
-		waitTime = 60
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return defaultWait
 	}
 
-	log.Printf("Rate limited. Waiting %d seconds before retry...", waitTime)
-	c.printStats()
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
 
-	select {
-	case <-time.After(time.Duration(waitTime) * time.Second):
-		return fmt.Errorf("rate limited, waited %d seconds", waitTime)
-	case <-ticker.C:
-		c.printStats()
-		return fmt.Errorf("rate limited, stats printed")
-	case <-c.ctx.Done():
-		return c.ctx.Err()
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+		return 0
 	}
+
+	return defaultWait
 }
 
-func (c *Crawler) exponentialBackoff(attempt int) time.Duration {
-	base := 2.0
-	maxDelay := 300 * time.Second
-	delay := time.Duration(math.Pow(base, float64(attempt))) * time.Second
-	if delay > maxDelay {
-		delay = maxDelay
+// handleRateLimit pauses every request going through waitForTurn until the
+// given wait has elapsed. The caller retries its request afterwards.
+func (c *Crawler) handleRateLimit(wait time.Duration) {
+	until := time.Now().Add(wait).UnixNano()
+	for {
+		current := atomic.LoadInt64(&c.pausedUntil)
+		if current >= until || atomic.CompareAndSwapInt64(&c.pausedUntil, current, until) {
+			break
+		}
 	}
-	return delay
+
+	c.stats.mu.Lock()
+	c.stats.rateLimitWaits++
+	c.stats.mu.Unlock()
+	metrics.IncrCounter("crawler_rate_limited_total", 1)
+
+	c.logger.Warn("Rate limited, pausing requests before retry", "wait", wait.Round(time.Second))
+	c.printStats()
 }
 
 func (c *Crawler) printStats() {
@@ -683,280 +1769,1492 @@ func (c *Crawler) printStats() {
 	totalErrors := c.stats.totalErrors
 	termsProcessed := c.stats.termsProcessed
 	pagesProcessed := c.stats.pagesProcessed
+	rateLimitWaits := c.stats.rateLimitWaits
+	pagesResumed := c.stats.pagesResumed
+	pagesExhausted := c.stats.pagesExhausted
+	pagesSkipped := c.stats.pagesSkipped
+	totalPages := c.stats.totalPages
+	pagesRemaining := c.stats.pagesRemaining()
 	c.stats.mu.RUnlock()
 
-	log.Printf("📊 CRAWLER STATS - Elapsed: %v, Since last report: %v", elapsed.Round(time.Second), sinceLastReport.Round(time.Second))
-	log.Printf("   Repositories indexed: %d", totalIndexed)
-	log.Printf("   Total errors: %d", totalErrors)
-	log.Printf("   Terms processed: %d", termsProcessed)
-	log.Printf("   Pages processed: %d", pagesProcessed)
+	attrs := []any{
+		"elapsed", elapsed.Round(time.Second),
+		"since_last_report", sinceLastReport.Round(time.Second),
+		"repos_indexed", totalIndexed,
+		"errors", totalErrors,
+		"terms_processed", termsProcessed,
+		"pages_processed", pagesProcessed,
+		"pages_exhausted", pagesExhausted,
+		"rate_limit_waits", rateLimitWaits,
+	}
+	if c.cache != nil {
+		hits, misses := c.cache.Stats()
+		attrs = append(attrs, "cache_hits", hits, "cache_misses", misses)
+	}
+	if totalPages > 0 {
+		attrs = append(attrs, "pages_remaining", pagesRemaining, "pages_resumed", pagesResumed)
+	}
 	if elapsed > 0 {
-		rate := float64(totalIndexed) / elapsed.Minutes()
-		log.Printf("   Average rate: %.2f repos/min", rate)
+		attrs = append(attrs, "repos_per_min", fmt.Sprintf("%.2f", float64(totalIndexed)/elapsed.Minutes()))
+	}
+	if progress := c.languageProgress(); len(progress) > 0 {
+		langs := make([]string, 0, len(progress))
+		for lang := range progress {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+		parts := make([]string, len(langs))
+		for i, lang := range langs {
+			parts[i] = fmt.Sprintf("%s=%d/%d", lang, progress[lang].Indexed, progress[lang].Target)
+		}
+		attrs = append(attrs, "language_targets", strings.Join(parts, " "), "pages_skipped", pagesSkipped)
 	}
+	c.logger.Info("📊 Crawler stats", attrs...)
 
 	c.stats.mu.Lock()
 	c.stats.lastReported = time.Now()
 	c.stats.mu.Unlock()
 }
 
-func (c *Crawler) indexRepository(repo *Repository) error {
-	data, err := json.Marshal(repo)
+// notifyTimeout bounds how long the end of a run waits on notifications
+const notifyTimeout = time.Minute
+
+// notifyRunEnd sends the run's totals to the configured notifiers. A
+// failed notification is only logged.
+func (c *Crawler) notifyRunEnd(summary string) {
+	c.stats.mu.RLock()
+	stats := map[string]any{
+		"elapsed":         time.Since(c.stats.startTime).Round(time.Second).String(),
+		"repos_indexed":   c.stats.totalIndexed,
+		"errors":          c.stats.totalErrors,
+		"terms_processed": c.stats.termsProcessed,
+		"pages_processed": c.stats.pagesProcessed,
+	}
+	c.stats.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	err := c.notifier.Notify(ctx, notify.Event{
+		Type:    notify.EventCrawlCompleted,
+		Source:  "crawler",
+		Summary: summary,
+		Stats:   stats,
+	})
 	if err != nil {
-		metrics.IncrCounter("crawler_index_errors_total", 1)
-		return err
+		c.logger.Warn("Failed to send run notification", "error", err)
 	}
+}
 
-	req := esapi.IndexRequest{
-		Index:      "github-coding-repos",
-		DocumentID: strings.ReplaceAll(repo.FullName, "/", "-"),
-		Body:       strings.NewReader(string(data)),
-		Refresh:    "true",
+// StatusSnapshot is the JSON body served by /status
+type StatusSnapshot struct {
+	TotalIndexed   int64   `json:"total_indexed"`
+	TotalErrors    int64   `json:"total_errors"`
+	TermsProcessed int64   `json:"terms_processed"`
+	PagesProcessed int64   `json:"pages_processed"`
+	PagesRemaining int64   `json:"pages_remaining"`
+	PagesExhausted int64   `json:"pages_exhausted"`
+	RateLimitWaits int64   `json:"rate_limit_waits"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	ReposPerMinute float64 `json:"repos_per_minute"`
+	RateLimitedFor float64 `json:"rate_limited_for_seconds"`
+
+	LanguageTargets map[string]LanguageProgress `json:"language_targets,omitempty"`
+}
+
+// LanguageProgress is how close a language is to its target
+type LanguageProgress struct {
+	Indexed int64 `json:"indexed"`
+	Target  int64 `json:"target"`
+}
+
+// pagesRemaining returns the planned pages not yet processed, resumed or
+// skipped. s.mu must be held.
+func (s *CrawlerStats) pagesRemaining() int64 {
+	return s.totalPages - s.pagesProcessed - s.pagesResumed - s.pagesExhausted - s.pagesSkipped
+}
+
+// languageProgress returns the indexed count of every language with a
+// target
+func (c *Crawler) languageProgress() map[string]LanguageProgress {
+	if len(c.languageTargets) == 0 {
+		return nil
 	}
+	c.stats.mu.RLock()
+	defer c.stats.mu.RUnlock()
 
-	res, err := req.Do(context.Background(), c.esClient)
-	if err != nil {
-		metrics.IncrCounter("crawler_index_errors_total", 1)
-		return err
+	progress := make(map[string]LanguageProgress, len(c.languageTargets))
+	for lang, target := range c.languageTargets {
+		progress[lang] = LanguageProgress{Indexed: c.stats.indexedByLanguage[lang], Target: target}
 	}
-	defer res.Body.Close()
+	return progress
+}
 
-	if res.IsError() {
-		metrics.IncrCounter("crawler_index_errors_total", 1)
-		return fmt.Errorf("failed to index repository: %s", res.Status())
+// languageDone reports whether lang has reached its target
+func (c *Crawler) languageDone(lang string) bool {
+	target, ok := c.languageTargets[lang]
+	if !ok {
+		return false
 	}
+	c.stats.mu.RLock()
+	defer c.stats.mu.RUnlock()
+	return c.stats.indexedByLanguage[lang] >= target
+}
 
-	// Record success metrics
-	metrics.IncrCounter("crawler_repos_indexed_total", 1)
-	metrics.SetGauge("crawler_last_repo_stars", float64(repo.Stars))
+// snapshot copies the live stats under the read lock
+func (s *CrawlerStats) snapshot() StatusSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	elapsed := time.Since(s.startTime)
+	snap := StatusSnapshot{
+		TotalIndexed:   s.totalIndexed,
+		TotalErrors:    s.totalErrors,
+		TermsProcessed: s.termsProcessed,
+		PagesProcessed: s.pagesProcessed,
+		PagesExhausted: s.pagesExhausted,
+		RateLimitWaits: s.rateLimitWaits,
+		ElapsedSeconds: elapsed.Seconds(),
+	}
+	if s.totalPages > 0 {
+		snap.PagesRemaining = s.pagesRemaining()
+	}
+	if elapsed > 0 {
+		snap.ReposPerMinute = float64(s.totalIndexed) / elapsed.Minutes()
+	}
+	return snap
+}
 
-	return nil
+// rateLimitPause returns how much longer requests are paused for
+func (c *Crawler) rateLimitPause() time.Duration {
+	if wait := time.Until(time.Unix(0, atomic.LoadInt64(&c.pausedUntil))); wait > 0 {
+		return wait
+	}
+	return 0
 }
 
-func (c *Crawler) crawlCodingRepos() error {
-	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, 2) // Reduced from 3 to 2 for lower resource usage
+// handleHealthz reports that the process is alive
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
 
-	for _, term := range codingSearchTerms {
-		for page := 1; page <= 5; page++ {
-			select {
-			case <-c.ctx.Done():
-				log.Println("Crawling cancelled")
-				return c.ctx.Err()
-			default:
-			}
-
-			wg.Add(1)
-			go func(searchTerm string, pageNum int) {
-				defer wg.Done()
-				semaphore <- struct{}{}
-				defer func() { <-semaphore }()
-
-				log.Printf("Crawling page %d for term: %s", pageNum, searchTerm)
-
-				var repos []*Repository
-				var err error
-				maxRetries := 5
-
-				for attempt := 0; attempt < maxRetries; attempt++ {
-					if atomic.LoadInt32(&c.shutdown) == 1 {
-						return
-					}
-
-					repos, err = c.searchGitHub(searchTerm, pageNum)
-					if err == nil {
-						break
-					}
-
-					if strings.Contains(err.Error(), "429") {
-						backoffTime := c.exponentialBackoff(attempt)
-						log.Printf("Rate limited on attempt %d for %s page %d. Backing off for %v", attempt+1, searchTerm, pageNum, backoffTime)
-
-						select {
-						case <-time.After(backoffTime):
-							continue
-						case <-c.ctx.Done():
-							return
-						}
-					} else {
-						log.Printf("Error searching GitHub for term %s, page %d: %v", searchTerm, pageNum, err)
-						return
-					}
-				}
+// handleReadyz reports whether Elasticsearch is reachable and the crawler
+// isn't stuck behind a long rate-limit pause
+func (c *Crawler) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 
-				if err != nil {
-					log.Printf("Failed to search after %d attempts for term %s, page %d: %v", maxRetries, searchTerm, pageNum, err)
-					return
-				}
+	if atomic.LoadInt32(&c.shutdown) == 1 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "shutting down")
+		return
+	}
 
-				for _, repo := range repos {
-					// Scrape detailed information from the repo page
-					if err := c.scrapeRepoDetails(repo); err != nil {
-						log.Printf("Error scraping details for %s: %v", repo.FullName, err)
-						c.stats.mu.Lock()
-						c.stats.totalErrors++
-						c.stats.mu.Unlock()
-						continue
-					}
-
-					if err := c.indexRepository(repo); err != nil {
-						log.Printf("Error indexing repository %s: %v", repo.FullName, err)
-						c.stats.mu.Lock()
-						c.stats.totalErrors++
-						c.stats.mu.Unlock()
-					} else {
-						log.Printf("Indexed: %s (Stars: %d, Forks: %d)", repo.FullName, repo.Stars, repo.Forks)
-						c.stats.mu.Lock()
-						c.stats.totalIndexed++
-						c.stats.mu.Unlock()
-					}
-				}
+	if pause := c.rateLimitPause(); pause > c.maxReadyPause {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "rate limited for another %v\n", pause.Round(time.Second))
+		return
+	}
 
-				c.stats.mu.Lock()
-				c.stats.pagesProcessed++
-				c.stats.mu.Unlock()
+	if c.esClient != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
 
-				time.Sleep(2 * time.Second)
-			}(term, page)
+		res, err := c.esClient.Ping(c.esClient.Ping.WithContext(ctx))
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "elasticsearch unreachable: %v\n", err)
+			return
 		}
+		res.Body.Close()
+		if res.IsError() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "elasticsearch unhealthy: %s\n", res.Status())
+			return
+		}
+	}
 
-		c.stats.mu.Lock()
-		c.stats.termsProcessed++
-		c.stats.mu.Unlock()
+	fmt.Fprintln(w, "ready")
+}
+
+// handleStatus serves the live crawler stats as JSON
+func (c *Crawler) handleStatus(w http.ResponseWriter, r *http.Request) {
+	snap := c.stats.snapshot()
+	snap.RateLimitedFor = c.rateLimitPause().Seconds()
+	snap.LanguageTargets = c.languageProgress()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(snap); err != nil {
+		c.logger.Error("Failed to encode status", "error", err)
 	}
+}
+
+func (c *Crawler) indexRepository(repo *Repository) error {
+	if err := c.sink.Index(repo); err != nil {
+		metrics.IncrCounter("crawler_index_errors_total", 1)
+		return err
+	}
+
+	// Record success metrics
+	metrics.IncrCounter("crawler_repos_indexed_total", 1)
+	metrics.SetGauge("crawler_last_repo_stars", float64(repo.Stars))
 
-	wg.Wait()
 	return nil
 }
 
-func (c *Crawler) createIndex() error {
-	mapping := `{
-		"mappings": {
-			"properties": {
-				"name": {"type": "text"},
-				"full_name": {"type": "keyword"},
-				"description": {"type": "text"},
-				"url": {"type": "keyword"},
-				"language": {"type": "keyword"},
-				"stars": {"type": "integer"},
-				"forks": {"type": "integer"},
-				"last_updated": {"type": "date"},
-				"topics": {"type": "keyword"},
-				"crawled_at": {"type": "date"}
-			}
-		}
-	}`
+// Sink receives crawled repositories. Index may buffer; Flush makes
+// everything indexed so far durable and Close flushes before releasing
+// resources.
+type Sink interface {
+	Index(repo *Repository) error
+	Flush() error
+	Close() error
+}
+
+// esSink indexes each repository into Elasticsearch as it arrives
+type esSink struct {
+	client *elasticsearch.Client
+	index  string
+}
+
+func (s *esSink) Index(repo *Repository) error {
+	data, err := json.Marshal(repo)
+	if err != nil {
+		return err
+	}
 
-	createReq := esapi.IndicesCreateRequest{
-		Index: "github-coding-repos",
-		Body:  strings.NewReader(mapping),
+	req := esapi.IndexRequest{
+		Index:      s.index,
+		DocumentID: strings.ReplaceAll(repo.FullName, "/", "-"),
+		Body:       bytes.NewReader(data),
+		Refresh:    "true",
 	}
 
-	res, err := createReq.Do(context.Background(), c.esClient)
+	res, err := req.Do(context.Background(), s.client)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		if res.StatusCode == 400 || strings.Contains(res.Status(), "already_exists") {
-			log.Printf("Index already exists, attempting to update mapping...")
-
-			updateReq := esapi.IndicesPutMappingRequest{
-				Index: []string{"github-coding-repos"},
-				Body: strings.NewReader(`{
-					"properties": {
-						"name": {"type": "text"},
-						"full_name": {"type": "keyword"},
-						"description": {"type": "text"},
-						"url": {"type": "keyword"},
-						"language": {"type": "keyword"},
-						"stars": {"type": "integer"},
-						"forks": {"type": "integer"},
-						"last_updated": {"type": "date"},
-						"topics": {"type": "keyword"},
-						"crawled_at": {"type": "date"}
-					}
-				}`),
-			}
-
-			updateRes, updateErr := updateReq.Do(context.Background(), c.esClient)
-			if updateErr != nil {
-				return fmt.Errorf("failed to update mapping: %w", updateErr)
-			}
-			defer updateRes.Body.Close()
-
-			if updateRes.IsError() {
-				log.Printf("Warning: failed to update mapping: %s", updateRes.Status())
-			} else {
-				log.Println("Successfully updated index mapping")
-			}
-		} else {
-			return fmt.Errorf("failed to create index: %s", res.Status())
-		}
-	} else {
-		log.Println("Successfully created new index")
+		return fmt.Errorf("failed to index repository: %s", res.Status())
 	}
-
 	return nil
 }
 
-func main() {
-	log.Println("Starting GitHub Coding Repository Crawler")
+// Documents are refreshed on write, so there is nothing to flush
+func (s *esSink) Flush() error { return nil }
+func (s *esSink) Close() error { return nil }
+
+// ndjsonSink appends one JSON object per line to a local file. Writes are
+// buffered and fsynced every syncEvery repositories rather than per line.
+type ndjsonSink struct {
+	mu        sync.Mutex
+	file      *os.File
+	w         *bufio.Writer
+	pending   int
+	syncEvery int
+}
 
-	// Start metrics HTTP server
-	go func() {
-		http.Handle("/metrics", metrics.Handler())
-		log.Printf("📊 Crawler metrics available at http://localhost:9092/metrics")
-		if err := http.ListenAndServe(":9092", nil); err != nil {
-			log.Printf("Metrics server error: %v", err)
+func newNDJSONSink(path string, syncEvery int) (*ndjsonSink, error) {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
 		}
-	}()
+	}
 
-	crawler, err := NewCrawler()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
-		log.Fatal("Failed to create crawler:", err)
+		return nil, err
 	}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	return &ndjsonSink{file: f, w: bufio.NewWriter(f), syncEvery: syncEvery}, nil
+}
 
-	go func() {
-		<-sigChan
-		log.Println("\nReceived shutdown signal, stopping crawler gracefully...")
-		atomic.StoreInt32(&crawler.shutdown, 1)
-		crawler.cancel()
-	}()
+func (s *ndjsonSink) Index(repo *Repository) error {
+	// json.Marshal escapes newlines inside strings, so each record stays on
+	// one line
+	data, err := json.Marshal(repo)
+	if err != nil {
+		return err
+	}
 
-	if err := crawler.createIndex(); err != nil {
-		log.Fatal("Failed to create Elasticsearch index:", err)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.w.Write(append(data, '\n')); err != nil {
+		return err
 	}
+	s.pending++
+	if s.pending >= s.syncEvery {
+		return s.flushLocked()
+	}
+	return nil
+}
 
-	log.Println("Starting crawl process...")
+func (s *ndjsonSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
 
-	go func() {
-		ticker := time.NewTicker(2 * time.Minute)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				crawler.printStats()
-			case <-crawler.ctx.Done():
+func (s *ndjsonSink) flushLocked() error {
+	if err := s.w.Flush(); err != nil {
+		return err
+	}
+	s.pending = 0
+	return s.file.Sync()
+}
+
+func (s *ndjsonSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	flushErr := s.flushLocked()
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// multiSink fans every call out to all of its sinks, so one failing sink
+// doesn't stop the others from receiving the repository
+type multiSink []Sink
+
+func (m multiSink) Index(repo *Repository) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Index(repo); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) Flush() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Flush(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ndjsonSyncEvery is how many repositories the file sink buffers between fsyncs
+const ndjsonSyncEvery = 100
+
+// newSinks builds the sinks named in spec, a comma-separated list of
+// "elasticsearch" (or "es") and "file:/path/repos.ndjson" entries. connectES
+// is only called when Elasticsearch is requested.
+func newSinks(spec string, connectES func() (*elasticsearch.Client, error)) (Sink, *elasticsearch.Client, error) {
+	var sinks multiSink
+	var esClient *elasticsearch.Client
+
+	closeAll := func(err error) (Sink, *elasticsearch.Client, error) {
+		sinks.Close()
+		return nil, nil, err
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "elasticsearch" || entry == "es":
+			if esClient != nil {
+				continue
+			}
+			client, err := connectES()
+			if err != nil {
+				return closeAll(err)
+			}
+			esClient = client
+			sinks = append(sinks, &esSink{client: client, index: models.RepoIndex})
+		case strings.HasPrefix(entry, "file:"):
+			path := strings.TrimPrefix(entry, "file:")
+			if path == "" {
+				return closeAll(fmt.Errorf("sink %q is missing a file path", entry))
+			}
+			sink, err := newNDJSONSink(path, ndjsonSyncEvery)
+			if err != nil {
+				return closeAll(fmt.Errorf("failed to open sink %q: %w", entry, err))
+			}
+			sinks = append(sinks, sink)
+		default:
+			return closeAll(fmt.Errorf("unknown sink %q (expected elasticsearch or file:/path)", entry))
+		}
+	}
+
+	switch len(sinks) {
+	case 0:
+		return nil, nil, errors.New("no sinks configured")
+	case 1:
+		return sinks[0], esClient, nil
+	}
+	return sinks, esClient, nil
+}
+
+// Crawl modes selecting which GitHub listings feed the index
+const (
+	crawlModeSearch = "search"
+	crawlModeTopics = "topics"
+	crawlModeBoth   = "both"
+)
+
+// Values of Repository.Source, naming the listing a repository was found on
+const (
+	sourceSearch   = "search"
+	sourceTopic    = "topic"
+	sourceTrending = "trending"
+)
+
+// crawl runs the requested mode(s) and clears the checkpoint once every
+// page has completed, so the next run starts from scratch.
+func (c *Crawler) crawl(mode string) error {
+	var steps []func() error
+	switch mode {
+	case crawlModeSearch:
+		steps = []func() error{c.crawlCodingRepos}
+	case crawlModeTopics:
+		steps = []func() error{c.crawlTopics}
+	case crawlModeBoth:
+		steps = []func() error{c.crawlCodingRepos, c.crawlTopics}
+	default:
+		return fmt.Errorf("unknown crawl mode %q", mode)
+	}
+
+	c.stats.mu.Lock()
+	c.stats.totalPages = int64(len(steps) * len(c.searchTerms) * c.maxPages)
+	c.stats.mu.Unlock()
+
+	if resumed := c.checkpoint.Count(); resumed > 0 {
+		c.logger.Info("Resuming crawl from checkpoint", "pages_completed", resumed)
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	// Keep the checkpoint while pages are outstanding so they are retried
+	c.stats.mu.RLock()
+	remaining := c.stats.pagesRemaining()
+	c.stats.mu.RUnlock()
+	if remaining == 0 {
+		if err := c.checkpoint.Reset(); err != nil {
+			c.logger.Error("Failed to reset checkpoint", "error", err)
+		}
+	} else {
+		c.logger.Warn("Some pages did not complete and will be retried on the next run", "pages_remaining", remaining)
+	}
+	return nil
+}
+
+func (c *Crawler) crawlCodingRepos() error {
+	return c.crawlPages(crawlModeSearch, c.searchGitHub)
+}
+
+// crawlTopics walks github.com/topics/<term> for every search term
+func (c *Crawler) crawlTopics() error {
+	return c.crawlPages(crawlModeTopics, c.fetchTopicPage)
+}
+
+// crawlPages fetches up to maxPages pages for every search term using
+// fetch and indexes the results, skipping pages recorded in the checkpoint.
+// Terms are crawled concurrently while each term's pages are fetched in
+// order, so paging stops as soon as a page yields no new repositories.
+func (c *Crawler) crawlPages(mode string, fetch func(term string, page int) ([]*Repository, error)) error {
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, c.concurrency)
+
+	checkpointKey := func(term string) string {
+		if mode == crawlModeSearch {
+			return term
+		}
+		return mode + ":" + term
+	}
+
+	for _, term := range c.searchTerms {
+		select {
+		case <-c.ctx.Done():
+			c.logger.Info("Crawling cancelled")
+			wg.Wait()
+			return c.ctx.Err()
+		case semaphore <- struct{}{}:
+		}
+
+		if lang := termLanguage(term); c.languageDone(lang) {
+			<-semaphore
+			c.skipTargetPages(term, checkpointKey(term), 1, lang)
+			c.stats.mu.Lock()
+			c.stats.termsProcessed++
+			c.stats.mu.Unlock()
+			continue
+		}
+
+		wg.Add(1)
+		go func(searchTerm string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			c.crawlTerm(mode, searchTerm, checkpointKey(searchTerm), fetch)
+
+			c.stats.mu.Lock()
+			c.stats.termsProcessed++
+			c.stats.mu.Unlock()
+		}(term)
+	}
+
+	wg.Wait()
+	return c.ctx.Err()
+}
+
+// crawlTerm pages through a single term until maxPages is reached or a page
+// returns only repositories that were already crawled.
+func (c *Crawler) crawlTerm(mode, term, key string, fetch func(term string, page int) ([]*Repository, error)) {
+	lang := termLanguage(term)
+	var indexed int64
+	for page := 1; page <= c.maxPages; page++ {
+		if c.ctx.Err() != nil || atomic.LoadInt32(&c.shutdown) == 1 {
+			return
+		}
+
+		// The target may be reached by another term while this one pages
+		if c.languageDone(lang) {
+			c.skipTargetPages(term, key, page, lang)
+			return
+		}
+		if c.termTarget > 0 && indexed >= c.termTarget {
+			c.logger.Info("Term reached its target, skipping remaining pages", "term", term, "indexed", indexed)
+			c.skipExhaustedPages(term, key, page)
+			return
+		}
+
+		if c.checkpoint.IsDone(key, page) {
+			c.stats.mu.Lock()
+			c.stats.pagesResumed++
+			c.stats.mu.Unlock()
+			continue
+		}
+
+		logger := c.logger.With("mode", mode, "term", term, "page", page)
+		logger.Info("Crawling page")
+
+		repos, err := fetch(term, page)
+		if err != nil {
+			logger.Error("Failed to crawl page", "error", err)
+			metrics.IncrCounterWithLabels("crawler_search_errors_total", map[string]string{
+				"mode": mode, "status_class": errorClass(err), "term_category": termCategory(term),
+			}, 1)
+			continue
+		}
+		for _, repo := range repos {
+			repo.term = term
+		}
+
+		indexed += int64(c.processRepositories(repos))
+
+		c.stats.mu.Lock()
+		c.stats.pagesProcessed++
+		c.stats.mu.Unlock()
+
+		if err := c.checkpoint.MarkDone(key, page); err != nil {
+			logger.Error("Failed to update checkpoint", "error", err)
+		}
+
+		if len(repos) == 0 && page < c.maxPages {
+			c.skipExhaustedPages(term, key, page+1)
+			return
+		}
+
+		time.Sleep(c.pageDelay)
+	}
+}
+
+// skipExhaustedPages records the pages after fromPage as done because the
+// term stopped producing new repositories.
+func (c *Crawler) skipExhaustedPages(term, key string, fromPage int) {
+	skipped := 0
+	for page := fromPage; page <= c.maxPages; page++ {
+		if c.checkpoint.IsDone(key, page) {
+			c.stats.mu.Lock()
+			c.stats.pagesResumed++
+			c.stats.mu.Unlock()
+			continue
+		}
+		if err := c.checkpoint.MarkDone(key, page); err != nil {
+			c.logger.Error("Failed to update checkpoint", "term", term, "page", page, "error", err)
+		}
+		skipped++
+	}
+
+	c.logger.Info("No new repositories, skipping remaining pages", "term", term, "pages_skipped", skipped)
+
+	c.stats.mu.Lock()
+	c.stats.pagesExhausted += int64(skipped)
+	c.stats.mu.Unlock()
+	metrics.IncrCounter("crawler_pages_exhausted_total", int64(skipped))
+}
+
+// skipTargetPages counts the pages from fromPage on as skipped because
+// lang reached its target. They aren't checkpointed: targets count this
+// run's repos, so the next run crawls the term again.
+func (c *Crawler) skipTargetPages(term, key string, fromPage int, lang string) {
+	var resumed, skipped int64
+	for page := fromPage; page <= c.maxPages; page++ {
+		if c.checkpoint.IsDone(key, page) {
+			resumed++
+		} else {
+			skipped++
+		}
+	}
+
+	c.logger.Info("Language reached its target, skipping term", "term", term, "language", lang, "pages_skipped", skipped)
+
+	c.stats.mu.Lock()
+	c.stats.pagesResumed += resumed
+	c.stats.pagesSkipped += skipped
+	c.stats.mu.Unlock()
+	metrics.IncrCounterWithLabels("crawler_pages_skipped_total", map[string]string{"language": lang}, skipped)
+}
+
+// processRepositories fetches repo pages where needed and indexes each
+// repo, returning how many were indexed. Repos of a language past its
+// target are still indexed and counted.
+func (c *Crawler) processRepositories(repos []*Repository) int {
+	indexed := 0
+	for _, repo := range repos {
+		// Scrape detailed information from the repo page
+		if c.needsDetails(repo) {
+			if err := c.scrapeRepoDetails(repo); err != nil {
+				c.logger.Error("Failed to scrape repository details", "repo", repo.FullName, "error", err)
+				c.stats.mu.Lock()
+				c.stats.totalErrors++
+				c.stats.mu.Unlock()
+				continue
+			}
+		} else {
+			metrics.IncrCounter("crawler_details_skipped_total", 1)
+		}
+
+		if err := c.indexRepository(repo); err != nil {
+			c.logger.Error("Failed to index repository", "repo", repo.FullName, "error", err)
+			c.stats.mu.Lock()
+			c.stats.totalErrors++
+			c.stats.mu.Unlock()
+		} else {
+			c.logger.Info("Indexed repository", "repo", repo.FullName, "stars", repo.Stars, "forks", repo.Forks)
+			indexed++
+
+			lang := strings.ToLower(repo.Language)
+			c.stats.mu.Lock()
+			c.stats.totalIndexed++
+			var langCount int64
+			if lang != "" {
+				if c.stats.indexedByLanguage == nil {
+					c.stats.indexedByLanguage = make(map[string]int64)
+				}
+				c.stats.indexedByLanguage[lang]++
+				langCount = c.stats.indexedByLanguage[lang]
+			}
+			c.stats.mu.Unlock()
+			if lang != "" {
+				metrics.SetGaugeWithLabels("crawler_language_indexed", map[string]string{"language": lang}, float64(langCount))
+			}
+		}
+	}
+	return indexed
+}
+
+// topicSlug converts a search term into GitHub's topic slug format
+func topicSlug(term string) string {
+	return strings.Join(strings.Fields(strings.ToLower(term)), "-")
+}
+
+// fetchTopicPage fetches one page of github.com/topics/<term>
+func (c *Crawler) fetchTopicPage(term string, page int) ([]*Repository, error) {
+	topicURL := fmt.Sprintf("%s/topics/%s?page=%d", c.baseURL, url.PathEscape(topicSlug(term)), page)
+
+	doc, err := c.fetchDocument(topicURL, pageTopic, term)
+	if err != nil {
+		return nil, err
+	}
+
+	repos, err := c.parseTopicPage(doc)
+	if err != nil {
+		return nil, &fetchError{class: statusParseError, err: err}
+	}
+	return repos, nil
+}
+
+// parseTopicPage extracts repository cards from a topic page. Repos already
+// seen by any crawl mode are skipped.
+func (c *Crawler) parseTopicPage(doc *goquery.Document) ([]*Repository, error) {
+	var repos []*Repository
+
+	cards := doc.Find("article.border, article.my-4")
+	if cards.Length() == 0 {
+		return nil, fmt.Errorf("no repository cards found on topic page")
+	}
+
+	cards.Each(func(i int, card *goquery.Selection) {
+		// The heading links to the owner first and the repository second
+		href, exists := card.Find("h3 a.text-bold, h3 a[data-hydro-click*='repository']").First().Attr("href")
+		if !exists {
+			href, exists = card.Find("h3 a").Last().Attr("href")
+		}
+		if !exists {
+			return
+		}
+
+		fullName := strings.Trim(href, "/")
+		parts := strings.Split(fullName, "/")
+		if len(parts) != 2 {
+			return
+		}
+		href = "/" + fullName
+
+		c.mu.Lock()
+		if c.crawled[href] {
+			c.mu.Unlock()
+			return
+		}
+		c.crawled[href] = true
+		c.mu.Unlock()
+
+		repo := &Repository{RepoInfo: models.RepoInfo{
+			Name:      parts[1],
+			FullName:  fullName,
+			URL:       "https://github.com" + href,
+			Source:    sourceTopic,
+			CrawledAt: time.Now(),
+		}}
+
+		parseResultCard(card, repo)
+
+		// Topic cards render stars in a counter whose title holds the exact count
+		if !repo.starsFromCard {
+			counter := card.Find("#repo-stars-counter-star, .Counter.js-social-count").First()
+			text, ok := counter.Attr("title")
+			if !ok {
+				text = counter.Text()
+			}
+			if stars, err := parseNumber(text); err == nil {
+				repo.Stars = stars
+				repo.starsFromCard = true
+			}
+		}
+
+		repos = append(repos, repo)
+	})
+
+	return repos, nil
+}
+
+// Trending windows accepted by github.com/trending
+var trendingWindows = map[string]bool{"daily": true, "weekly": true, "monthly": true}
+
+// crawlTrending indexes github.com/trending for each language (an empty
+// list crawls the all-languages page) over the given window.
+func (c *Crawler) crawlTrending(languages []string, since string) error {
+	if !trendingWindows[since] {
+		return fmt.Errorf("invalid trending window %q (expected daily, weekly or monthly)", since)
+	}
+	if len(languages) == 0 {
+		languages = []string{""}
+	}
+
+	for _, lang := range languages {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+
+		trendingURL := fmt.Sprintf("%s/trending/%s?since=%s", c.baseURL, url.PathEscape(strings.ToLower(lang)), since)
+		doc, err := c.fetchDocument(trendingURL, pageTrending, "")
+		if err != nil {
+			c.logger.Error("Failed to fetch trending repositories", "language", lang, "since", since, "error", err)
+			c.stats.mu.Lock()
+			c.stats.totalErrors++
+			c.stats.mu.Unlock()
+			continue
+		}
+
+		repos, err := parseTrendingPage(doc, since)
+		if err != nil {
+			c.logger.Error("Failed to parse trending repositories", "language", lang, "since", since, "error", err)
+			continue
+		}
+
+		c.logger.Info("Found trending repositories", "language", lang, "since", since, "count", len(repos))
+		c.processRepositories(repos)
+
+		c.stats.mu.Lock()
+		c.stats.pagesProcessed++
+		c.stats.mu.Unlock()
+	}
+
+	return nil
+}
+
+// parseTrendingPage extracts the ranked repository list from a trending
+// page. Trending repos are always returned, even if seen by another mode,
+// so their rank and stars gained stay current in the index.
+func parseTrendingPage(doc *goquery.Document, since string) ([]*Repository, error) {
+	var repos []*Repository
+
+	rows := doc.Find("article.Box-row")
+	if rows.Length() == 0 {
+		return nil, fmt.Errorf("no trending repositories found on page")
+	}
+
+	rows.Each(func(i int, row *goquery.Selection) {
+		href, exists := row.Find("h2 a, h1 a").First().Attr("href")
+		if !exists {
+			return
+		}
+
+		fullName := strings.Trim(href, "/")
+		parts := strings.Split(fullName, "/")
+		if len(parts) != 2 {
+			return
+		}
+
+		repo := &Repository{RepoInfo: models.RepoInfo{
+			Name:           parts[1],
+			FullName:       fullName,
+			URL:            "https://github.com/" + fullName,
+			Source:         sourceTrending,
+			CrawledAt:      time.Now(),
+			TrendingRank:   len(repos) + 1,
+			TrendingWindow: since,
+		}}
+
+		parseResultCard(row, repo)
+
+		// e.g. "1,234 stars today" / "310 stars this week"
+		gained := strings.Fields(row.Find("span.float-sm-right, span.d-inline-block.float-sm-right").First().Text())
+		if len(gained) > 0 {
+			if n, err := parseNumber(gained[0]); err == nil {
+				repo.StarsGained = n
+			}
+		}
+
+		repos = append(repos, repo)
+	})
+
+	return repos, nil
+}
+
+// repoIndexMapping is the body that creates a RepoIndex version
+const repoIndexMapping = `{"mappings": {"properties": ` + models.RepoIndexProperties + `}}`
+
+// currentRepoIndex returns the index models.RepoIndex resolves to and its
+// version. A concrete index named models.RepoIndex, left by crawlers that
+// predate the alias, is version 1; legacy is set for it. index is "" when
+// there is neither.
+func (c *Crawler) currentRepoIndex(ctx context.Context) (index string, version int, legacy bool, err error) {
+	res, err := esapi.IndicesGetAliasRequest{Name: []string{models.RepoIndex}}.Do(ctx, c.esClient)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to look up alias %s: %w", models.RepoIndex, err)
+	}
+	defer res.Body.Close()
+
+	if !res.IsError() {
+		var aliases map[string]json.RawMessage
+		if err := json.NewDecoder(res.Body).Decode(&aliases); err != nil {
+			return "", 0, false, fmt.Errorf("failed to decode alias %s: %w", models.RepoIndex, err)
+		}
+		if len(aliases) != 1 {
+			return "", 0, false, fmt.Errorf("alias %s points at %d indices, want 1", models.RepoIndex, len(aliases))
+		}
+		for name := range aliases {
+			version, err := parseRepoIndexVersion(name)
+			if err != nil {
+				return "", 0, false, err
+			}
+			return name, version, false, nil
+		}
+	}
+	if res.StatusCode != http.StatusNotFound {
+		return "", 0, false, fmt.Errorf("failed to look up alias %s: %s", models.RepoIndex, res.Status())
+	}
+
+	exists, err := esapi.IndicesExistsRequest{Index: []string{models.RepoIndex}}.Do(ctx, c.esClient)
+	if err != nil {
+		return "", 0, false, fmt.Errorf("failed to check for index %s: %w", models.RepoIndex, err)
+	}
+	defer exists.Body.Close()
+	switch exists.StatusCode {
+	case http.StatusOK:
+		return models.RepoIndex, 1, true, nil
+	case http.StatusNotFound:
+		return "", 0, false, nil
+	default:
+		return "", 0, false, fmt.Errorf("failed to check for index %s: %s", models.RepoIndex, exists.Status())
+	}
+}
+
+// parseRepoIndexVersion reads the version from a models.RepoIndexName name
+func parseRepoIndexVersion(index string) (int, error) {
+	suffix, ok := strings.CutPrefix(index, models.RepoIndex+"-v")
+	if !ok {
+		return 0, fmt.Errorf("alias %s points at unexpected index %s", models.RepoIndex, index)
+	}
+	version, err := strconv.Atoi(suffix)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("alias %s points at unexpected index %s", models.RepoIndex, index)
+	}
+	return version, nil
+}
+
+// createIndex makes sure models.RepoIndex can be written to. On a fresh
+// cluster it creates models.RepoIndexVersion with the current mapping and
+// points the alias at it; otherwise it adds any new fields to the mapping
+// of the index the alias points at. Changing an existing field's type needs
+// the reindex command.
+func (c *Crawler) createIndex() error {
+	ctx := context.Background()
+	index, _, legacy, err := c.currentRepoIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	if index == "" {
+		index = models.RepoIndexName(models.RepoIndexVersion)
+		if err := c.createRepoIndexVersion(ctx, index); err != nil {
+			return err
+		}
+		res, err := esapi.IndicesPutAliasRequest{Index: []string{index}, Name: models.RepoIndex}.Do(ctx, c.esClient)
+		if err != nil {
+			return fmt.Errorf("failed to create alias %s: %w", models.RepoIndex, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("failed to create alias %s: %s", models.RepoIndex, res.Status())
+		}
+		c.logger.Info("Successfully created new index", "index", index, "alias", models.RepoIndex)
+		return nil
+	}
+
+	if legacy {
+		c.logger.Warn("Writing to an unversioned index; run the reindex command to move it behind an alias", "index", index)
+	}
+	updateReq := esapi.IndicesPutMappingRequest{
+		Index: []string{index},
+		Body:  strings.NewReader(`{"properties": ` + models.RepoIndexProperties + `}`),
+	}
+
+	updateRes, err := updateReq.Do(ctx, c.esClient)
+	if err != nil {
+		return fmt.Errorf("failed to update mapping: %w", err)
+	}
+	defer updateRes.Body.Close()
+
+	if updateRes.IsError() {
+		c.logger.Warn("Failed to update index mapping", "index", index, "status_code", updateRes.StatusCode)
+	} else {
+		c.logger.Info("Successfully updated index mapping", "index", index)
+	}
+	return nil
+}
+
+// createRepoIndexVersion creates index with the current mapping
+func (c *Crawler) createRepoIndexVersion(ctx context.Context, index string) error {
+	res, err := esapi.IndicesCreateRequest{
+		Index: index,
+		Body:  strings.NewReader(repoIndexMapping),
+	}.Do(ctx, c.esClient)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", index, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("failed to create index %s: %s", index, res.Status())
+	}
+	return nil
+}
+
+// reindexTask is the part of a _tasks response reindex reads
+type reindexTask struct {
+	Completed bool `json:"completed"`
+	Task      struct {
+		Status struct {
+			Total   int64 `json:"total"`
+			Created int64 `json:"created"`
+			Updated int64 `json:"updated"`
+			Deleted int64 `json:"deleted"`
+		} `json:"status"`
+	} `json:"task"`
+	Error    json.RawMessage `json:"error"`
+	Response struct {
+		Failures []json.RawMessage `json:"failures"`
+	} `json:"response"`
+}
+
+// reindex copies the index models.RepoIndex points at into the next version,
+// created with the current mapping, and then moves the alias in one
+// _aliases request so readers never see a missing or half-filled index.
+// Progress is logged every poll. A versioned old index is kept for
+// rollback; a legacy one is deleted to free its name for the alias. Stop
+// the crawl first: documents written after the copy starts stay behind.
+func (c *Crawler) reindex(poll time.Duration) error {
+	source, version, legacy, err := c.currentRepoIndex(c.ctx)
+	if err != nil {
+		return err
+	}
+	if source == "" {
+		return fmt.Errorf("nothing to reindex: neither alias nor index %s exists", models.RepoIndex)
+	}
+	dest := models.RepoIndexName(version + 1)
+	c.logger.Info("Reindexing", "from", source, "to", dest)
+
+	if err := c.createRepoIndexVersion(c.ctx, dest); err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"source": map[string]string{"index": source},
+		"dest":   map[string]string{"index": dest},
+	})
+	if err != nil {
+		return err
+	}
+	wait := false
+	res, err := esapi.ReindexRequest{Body: bytes.NewReader(body), WaitForCompletion: &wait}.Do(c.ctx, c.esClient)
+	if err != nil {
+		return fmt.Errorf("failed to start reindex: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("failed to start reindex: %s", res.Status())
+	}
+	var started struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&started); err != nil || started.Task == "" {
+		return fmt.Errorf("reindex returned no task: %v", err)
+	}
+
+	if err := c.waitForReindex(started.Task, poll); err != nil {
+		return err
+	}
+	if err := c.swapRepoIndexAlias(source, dest, legacy); err != nil {
+		return err
+	}
+	c.logger.Info("Reindex complete", "alias", models.RepoIndex, "index", dest, "previous", source)
+	return nil
+}
+
+// waitForReindex polls the reindex task every poll until it completes
+func (c *Crawler) waitForReindex(taskID string, poll time.Duration) error {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+
+	for {
+		res, err := esapi.TasksGetRequest{TaskID: taskID}.Do(c.ctx, c.esClient)
+		if err != nil {
+			return fmt.Errorf("failed to poll reindex task %s: %w", taskID, err)
+		}
+		var task reindexTask
+		decodeErr := json.NewDecoder(res.Body).Decode(&task)
+		res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("failed to poll reindex task %s: %s", taskID, res.Status())
+		}
+		if decodeErr != nil {
+			return fmt.Errorf("failed to decode reindex task %s: %w", taskID, decodeErr)
+		}
+
+		status := task.Task.Status
+		done := status.Created + status.Updated + status.Deleted
+		c.logger.Info("Reindex progress", "task", taskID, "done", done, "total", status.Total)
+
+		if task.Completed {
+			if len(task.Error) > 0 {
+				return fmt.Errorf("reindex task %s failed: %s", taskID, task.Error)
+			}
+			if len(task.Response.Failures) > 0 {
+				return fmt.Errorf("reindex task %s had %d failures, first: %s", taskID, len(task.Response.Failures), task.Response.Failures[0])
+			}
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-c.ctx.Done():
+			return fmt.Errorf("stopped waiting for reindex task %s, which keeps running: %w", taskID, c.ctx.Err())
+		}
+	}
+}
+
+// aliasSwapActions builds the _aliases body that points models.RepoIndex at
+// dest instead of source. A legacy source is a concrete index with the
+// alias's name, so it is removed in the same request to free the name.
+func aliasSwapActions(source, dest string, legacy bool) ([]byte, error) {
+	remove := map[string]any{"remove": map[string]string{"index": source, "alias": models.RepoIndex}}
+	if legacy {
+		remove = map[string]any{"remove_index": map[string]string{"index": source}}
+	}
+	return json.Marshal(map[string]any{
+		"actions": []any{
+			remove,
+			map[string]any{"add": map[string]string{"index": dest, "alias": models.RepoIndex}},
+		},
+	})
+}
+
+// swapRepoIndexAlias moves models.RepoIndex from source to dest atomically
+func (c *Crawler) swapRepoIndexAlias(source, dest string, legacy bool) error {
+	body, err := aliasSwapActions(source, dest, legacy)
+	if err != nil {
+		return err
+	}
+	res, err := esapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(body)}.Do(c.ctx, c.esClient)
+	if err != nil {
+		return fmt.Errorf("failed to move alias %s to %s: %w", models.RepoIndex, dest, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("failed to move alias %s to %s: %s", models.RepoIndex, dest, res.Status())
+	}
+	return nil
+}
+
+// newLogger builds the crawler's logger from LOG_LEVEL (debug, info, warn,
+// error; default info) and LOG_FORMAT (text or json; default text).
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "", "info":
+		lvl = slog.LevelInfo
+	case "warn", "warning":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q (expected debug, info, warn or error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q (expected text or json)", format)
+	}
+}
+
+// fatal logs at error level and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func main() {
+	termsFilter := flag.String("terms-filter", "", "Comma-separated list restricting the crawl to matching search terms (e.g. rust,go)")
+	crawlMode := flag.String("mode", os.Getenv("CRAWL_MODE"), "What to crawl: search, topics or both (default search, env CRAWL_MODE)")
+	forceRestart := flag.Bool("force-restart", false, "Ignore the crawl checkpoint and start from the first term and page")
+	detailsMode := flag.String("details", detailsAlways, "When to fetch repository pages: always, missing (only if the search card lacks stars/language) or never")
+	sinkSpec := flag.String("sink", os.Getenv("CRAWL_SINK"), "Where to write results: comma-separated elasticsearch and/or file:/path/repos.ndjson (default elasticsearch, env CRAWL_SINK)")
+	trendingLanguages := flag.String("languages", os.Getenv("TRENDING_LANGUAGES"), "trending: comma-separated languages to crawl (default all languages, env TRENDING_LANGUAGES)")
+	trendingSince := flag.String("since", os.Getenv("TRENDING_SINCE"), "trending: daily, weekly or monthly (default daily, env TRENDING_SINCE)")
+	targetSpec := flag.String("targets", os.Getenv("CRAWL_TARGETS"), "Comma-separated language=count caps on repos indexed per language, e.g. Rust=2000,Dart=500 (env CRAWL_TARGETS)")
+	termTarget := flag.Int64("term-target", 0, "Stop paging a term after this many repos are indexed from it (0 for no limit)")
+	headerProfiles := flag.String("header-profiles", os.Getenv("HEADER_PROFILES"), "JSON file of browser header profiles to rotate through (default built-in profiles, env HEADER_PROFILES)")
+	noHeaderRotation := flag.Bool("no-header-rotation", false, "Send every request with the crawler's own User-Agent instead of rotating browser header profiles")
+	reindexPoll := flag.Duration("reindex-poll", 10*time.Second, "reindex: how often to check and log _reindex progress")
+
+	// "crawler trending [flags]" runs the trending crawl instead of
+	// search/topics; "crawler reindex" moves the index to the next version
+	command := "crawl"
+	args := os.Args[1:]
+	if len(args) > 0 && (args[0] == "trending" || args[0] == "reindex") {
+		command = args[0]
+		args = args[1:]
+	}
+	cfg, err := config.Load(flag.CommandLine, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logger, err := newLogger(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	if *trendingSince == "" {
+		*trendingSince = "daily"
+	}
+	if command == "trending" && !trendingWindows[*trendingSince] {
+		fatal("Invalid --since (expected daily, weekly or monthly)", "since", *trendingSince)
+	}
+
+	var trendingInterval time.Duration
+	if raw := os.Getenv("TRENDING_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil {
+			fatal("Invalid TRENDING_INTERVAL", "value", raw, "error", err)
+		}
+		trendingInterval = interval
+	}
+
+	if *sinkSpec == "" {
+		*sinkSpec = "elasticsearch"
+	}
+
+	if *crawlMode == "" {
+		*crawlMode = crawlModeSearch
+	}
+	switch *crawlMode {
+	case crawlModeSearch, crawlModeTopics, crawlModeBoth:
+	default:
+		fatal("Invalid crawl mode (expected search, topics or both)", "mode", *crawlMode)
+	}
+
+	switch *detailsMode {
+	case detailsAlways, detailsMissing, detailsNever:
+	default:
+		fatal("Invalid --details mode (expected always, missing or never)", "details", *detailsMode)
+	}
+
+	slog.Info("Starting GitHub Coding Repository Crawler")
+
+	searchTerms, err := loadSearchTerms()
+	if err != nil {
+		fatal("Failed to load search terms", "error", err)
+	}
+	if *termsFilter != "" {
+		searchTerms = filterSearchTerms(searchTerms, *termsFilter)
+		if len(searchTerms) == 0 {
+			fatal("No search terms match filter", "filter", *termsFilter)
+		}
+	}
+	slog.Info("Loaded search terms", "count", len(searchTerms))
+
+	// Start metrics HTTP server; /readyz and /status are added once the
+	// crawler exists
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", handleHealthz)
+	go func() {
+		slog.Info("📊 Crawler metrics available", "url", "http://localhost:9092/metrics")
+		if err := http.ListenAndServe(":9092", mux); err != nil {
+			slog.Error("Metrics server error", "error", err)
+		}
+	}()
+
+	sink, esClient, err := newSinks(*sinkSpec, func() (*elasticsearch.Client, error) {
+		return connectElasticsearch(cfg.Elasticsearch.URL)
+	})
+	if err != nil {
+		fatal("Failed to set up sinks", "error", err)
+	}
+	defer func() {
+		if err := sink.Close(); err != nil {
+			slog.Error("Failed to close sinks", "error", err)
+		}
+	}()
+
+	crawler, err := NewCrawler(esClient, sink)
+	if err != nil {
+		fatal("Failed to create crawler", "error", err)
+	}
+	crawler.searchTerms = searchTerms
+	crawler.detailsMode = *detailsMode
+
+	notifier, err := notify.FromEnv()
+	if err != nil {
+		fatal("Invalid notification configuration", "error", err)
+	}
+	crawler.notifier = notifier
+	slog.Info("Run notifications", "notifiers", notifier.String())
+
+	targets, err := parseLanguageTargets(*targetSpec)
+	if err != nil {
+		fatal("Invalid --targets", "error", err)
+	}
+	if *termTarget < 0 {
+		fatal("Invalid --term-target (must not be negative)", "term_target", *termTarget)
+	}
+	crawler.languageTargets = targets
+	crawler.termTarget = *termTarget
+	for lang, target := range targets {
+		metrics.SetGaugeWithLabels("crawler_language_target", map[string]string{"language": lang}, float64(target))
+	}
+	if len(targets) > 0 || *termTarget > 0 {
+		slog.Info("Crawl targets configured", "languages", *targetSpec, "term_target", *termTarget)
+	}
+
+	checkpoint, err := loadCheckpoint(cfg.Paths.CrawlCheckpoint)
+	if err != nil {
+		fatal("Failed to load crawl checkpoint", "error", err)
+	}
+	if *forceRestart {
+		slog.Info("--force-restart given, discarding crawl checkpoint")
+		if err := checkpoint.Reset(); err != nil {
+			fatal("Failed to reset crawl checkpoint", "error", err)
+		}
+	}
+	crawler.checkpoint = checkpoint
+
+	crawler.maxPages = cfg.RateLimits.CrawlMaxPages
+	crawler.concurrency = cfg.Concurrency.CrawlPages
+	crawler.maxReadyPause = cfg.RateLimits.ReadyMaxWait
+	if crawler.cache, err = ghclient.OpenCache(cfg.GitHub.CacheDir); err != nil {
+		fatal("Failed to open GitHub cache", "error", err)
+	}
+	slog.Info("Crawl limits configured", "max_pages", crawler.maxPages, "concurrency", crawler.concurrency)
+
+	if raw := os.Getenv("BOT_BLOCK_WAIT"); raw != "" {
+		if crawler.botBlockWait, err = time.ParseDuration(raw); err != nil {
+			fatal("Invalid BOT_BLOCK_WAIT", "value", raw, "error", err)
+		}
+	}
+
+	proxyURLs, err := parseProxyList(os.Getenv("PROXY_LIST"))
+	if err != nil {
+		fatal("Invalid PROXY_LIST", "error", err)
+	}
+	if len(proxyURLs) > 0 {
+		maxFailures, err := envPositiveInt("PROXY_MAX_FAILURES", 3)
+		if err != nil {
+			fatal("Invalid proxy configuration", "error", err)
+		}
+		cooldown := 5 * time.Minute
+		if raw := os.Getenv("PROXY_COOLDOWN"); raw != "" {
+			if cooldown, err = time.ParseDuration(raw); err != nil {
+				fatal("Invalid PROXY_COOLDOWN", "value", raw, "error", err)
+			}
+		}
+
+		crawler.proxies = newProxyPool(proxyURLs, func() *rate.Limiter {
+			return rate.NewLimiter(rate.Every(3*time.Second), 1)
+		}, maxFailures, cooldown)
+		slog.Info("Rotating requests through proxies", "count", len(proxyURLs), "max_failures", maxFailures, "cooldown", cooldown)
+	}
+
+	if *noHeaderRotation {
+		slog.Info("Header rotation disabled", "user_agent", ghclient.UserAgent)
+	} else {
+		profiles, err := loadHeaderProfiles(*headerProfiles)
+		if err != nil {
+			fatal("Invalid header profiles", "error", err)
+		}
+		crawler.headers = newHeaderRotator(profiles, rand.New(rand.NewSource(time.Now().UnixNano())))
+		slog.Info("Rotating browser header profiles", "count", len(profiles))
+	}
+
+	mux.HandleFunc("/readyz", crawler.handleReadyz)
+	mux.HandleFunc("/status", crawler.handleStatus)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-sigChan
+		slog.Info("Received shutdown signal, stopping crawler gracefully")
+		atomic.StoreInt32(&crawler.shutdown, 1)
+		crawler.cancel()
+	}()
+
+	if command == "reindex" {
+		if crawler.esClient == nil {
+			fatal("reindex needs the elasticsearch sink", "sink", *sinkSpec)
+		}
+		if *reindexPoll <= 0 {
+			fatal("Invalid --reindex-poll (must be positive)", "reindex_poll", *reindexPoll)
+		}
+		if err := crawler.reindex(*reindexPoll); err != nil {
+			fatal("Reindex failed", "error", err)
+		}
+		return
+	}
+
+	if crawler.esClient != nil {
+		if err := crawler.createIndex(); err != nil {
+			fatal("Failed to create Elasticsearch index", "error", err)
+		}
+	}
+
+	slog.Info("Starting crawl process", "command", command)
+
+	go func() {
+		ticker := time.NewTicker(2 * time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				crawler.printStats()
+				if err := crawler.sink.Flush(); err != nil {
+					slog.Error("Failed to flush sinks", "error", err)
+				}
+			case <-crawler.ctx.Done():
 				return
 			}
 		}
 	}()
 
-	if err := crawler.crawlCodingRepos(); err != nil {
+	if command == "trending" {
+		var languages []string
+		for _, lang := range strings.Split(*trendingLanguages, ",") {
+			if lang = strings.TrimSpace(lang); lang != "" {
+				languages = append(languages, lang)
+			}
+		}
+
+		for {
+			if err := crawler.crawlTrending(languages, *trendingSince); err != nil {
+				slog.Error("Trending crawl failed", "error", err)
+				crawler.notifyRunEnd(fmt.Sprintf("Trending crawl failed: %v", err))
+			} else {
+				crawler.notifyRunEnd("Trending crawl completed")
+			}
+			crawler.printStats()
+
+			if trendingInterval <= 0 {
+				return
+			}
+
+			slog.Info("Scheduled next trending crawl", "in", trendingInterval)
+			select {
+			case <-time.After(trendingInterval):
+			case <-crawler.ctx.Done():
+				slog.Info("Trending crawl stopped")
+				return
+			}
+		}
+	}
+
+	if err := crawler.crawl(*crawlMode); err != nil {
 		if err == context.Canceled {
-			log.Println("Crawling was cancelled by user")
+			slog.Info("Crawling was cancelled by user")
 		} else {
-			log.Printf("Crawling failed: %v", err)
+			slog.Error("Crawling failed", "error", err)
+			crawler.notifyRunEnd(fmt.Sprintf("Crawl failed: %v", err))
 		}
 		crawler.printStats()
 		return
 	}
 
-	log.Println("Crawling completed successfully")
+	slog.Info("Crawling completed successfully")
 	crawler.printStats()
+	crawler.notifyRunEnd("Crawl completed")
 }
@@ -0,0 +1,70 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"codelupe/internal/models"
+	"codelupe/internal/testenv"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// TestIntegration_CrawlerIndexesRepos is the first stage of the pipeline
+// test: it indexes the fixture repos into a fresh index the way a crawl
+// does, for the downloader stage to read back.
+func TestIntegration_CrawlerIndexesRepos(t *testing.T) {
+	es := testenv.Elasticsearch(t)
+	ctx := context.Background()
+
+	// Start from an empty index; deleting it also removes the alias
+	res, err := esapi.IndicesDeleteRequest{
+		Index:             []string{models.RepoIndexName(models.RepoIndexVersion)},
+		IgnoreUnavailable: esapi.BoolPtr(true),
+	}.Do(ctx, es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	crawler, err := NewCrawler(es, &esSink{client: es, index: models.RepoIndex})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := crawler.createIndex(); err != nil {
+		t.Fatalf("createIndex() error = %v", err)
+	}
+
+	data, err := os.ReadFile(testenv.Fixture("repos.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var repos []*Repository
+	if err := json.Unmarshal(data, &repos); err != nil {
+		t.Fatal(err)
+	}
+	for _, repo := range repos {
+		if err := crawler.indexRepository(repo); err != nil {
+			t.Fatalf("indexRepository(%s) error = %v", repo.FullName, err)
+		}
+	}
+
+	res, err = esapi.CountRequest{Index: []string{models.RepoIndex}}.Do(ctx, es)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var count struct {
+		Count int `json:"count"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count.Count != len(repos) {
+		t.Errorf("%s holds %d documents, want %d", models.RepoIndex, count.Count, len(repos))
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Content hash algorithms for deduplication
+const (
+	hashMD5    = "md5"
+	hashXXHash = "xxhash"
+)
+
+const contentSetShards = 64
+
+// contentSet remembers which repository first contributed each file
+// content hash. It is sharded so workers saving files don't all contend on
+// one lock.
+type contentSet struct {
+	algorithm string
+	hash      func([]byte) string
+	shards    [contentSetShards]contentShard
+}
+
+type contentShard struct {
+	mu    sync.Mutex
+	first map[string]string // hash -> repo full name
+}
+
+// newContentSet creates a set keyed by the given hash algorithm: md5 or
+// xxhash (much faster, 64-bit)
+func newContentSet(algorithm string) (*contentSet, error) {
+	cs := &contentSet{algorithm: algorithm}
+	switch algorithm {
+	case hashMD5:
+		cs.hash = func(b []byte) string {
+			sum := md5.Sum(b)
+			return string(sum[:])
+		}
+	case hashXXHash:
+		cs.hash = func(b []byte) string {
+			return string(binary.BigEndian.AppendUint64(nil, xxhash.Sum64(b)))
+		}
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %q (expected md5 or xxhash)", algorithm)
+	}
+
+	for i := range cs.shards {
+		cs.shards[i].first = make(map[string]string)
+	}
+	return cs, nil
+}
+
+// Sum returns the hash of content as stored in the set
+func (cs *contentSet) Sum(content []byte) string {
+	return cs.hash(content)
+}
+
+// Add records repo as the contributor of hash unless another repo got there
+// first. It returns the first contributor and whether this call added it.
+func (cs *contentSet) Add(hash, repo string) (first string, added bool) {
+	shard := &cs.shards[hash[0]%contentSetShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	if first, ok := shard.first[hash]; ok {
+		return first, false
+	}
+	shard.first[hash] = repo
+	return repo, true
+}
+
+// Remove forgets hash, so a later copy can be saved if writing this one
+// failed
+func (cs *contentSet) Remove(hash string) {
+	shard := &cs.shards[hash[0]%contentSetShards]
+	shard.mu.Lock()
+	delete(shard.first, hash)
+	shard.mu.Unlock()
+}
+
+// hexHash formats a hash from Sum for metadata
+func hexHash(hash string) string {
+	return hex.EncodeToString([]byte(hash))
+}
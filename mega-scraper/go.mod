@@ -2,7 +2,11 @@ module mega-scraper
 
 go 1.24.1
 
-require github.com/go-git/go-git/v5 v5.16.2
+require (
+	codelupe v0.0.0
+	github.com/cespare/xxhash/v2 v2.3.0
+	github.com/go-git/go-git/v5 v5.16.2
+)
 
 require (
 	dario.cat/mergo v1.0.0 // indirect
@@ -20,8 +24,12 @@ require (
 	github.com/sergi/go-diff v1.3.2-0.20230802210424-5b0b94c5c0d3 // indirect
 	github.com/skeema/knownhosts v1.3.1 // indirect
 	github.com/xanzy/ssh-agent v0.3.3 // indirect
-	golang.org/x/crypto v0.37.0 // indirect
-	golang.org/x/net v0.39.0 // indirect
-	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/crypto v0.41.0 // indirect
+	golang.org/x/net v0.43.0 // indirect
+	golang.org/x/sys v0.35.0 // indirect
 	gopkg.in/warnings.v0 v0.1.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+// pkg/scrub and pkg/config are shared with the processors in the root module
+replace codelupe => ../
@@ -5,6 +5,8 @@ import (
 	"bufio"
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -12,6 +14,7 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -19,10 +22,14 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	sharedconfig "codelupe/pkg/config"
+	"codelupe/pkg/scrub"
+
 	"github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 )
 
 // RepoInfo holds repository metadata
@@ -46,21 +53,48 @@ type WorkerPool struct {
 	tokenManager *TokenManager
 	stats        *Stats
 	config       *Config
+	strategies   map[string]cloneStrategy
+	// seen dedups file content across repositories
+	seen   *contentSet
+	output datasetWriter
+	// scrubber finds secrets and personal data in accepted files
+	scrubber *scrub.Scanner
+	// apiClient is shared by metadata lookups; requests carry their own
+	// APITimeout deadline
+	apiClient *http.Client
+
+	// stopping is closed to stop taking new jobs while in-flight ones finish
+	stopping    chan struct{}
+	stopFeeding sync.Once
+	stopWorkers sync.Once
 }
 
-// TokenManager handles GitHub token rotation
+// Clone strategies, tried in Config.CloneStrategies order
+const (
+	strategyArchive = "archive"
+	strategyGit     = "git"
+	strategyGoGit   = "go-git"
+)
+
+// cloneStrategy fetches repo into dir, which exists and is empty
+type cloneStrategy func(ctx context.Context, repo RepoInfo, dir, token string) error
+
+// TokenManager handles GitHub token rotation. All fields are guarded by
+// mutex.
 type TokenManager struct {
 	tokens       []string
-	currentIndex int64
+	currentIndex int
 	rateLimits   map[string]*RateLimit
-	mutex        sync.RWMutex
+	mutex        sync.Mutex
+
+	// waited is the total time GetToken callers spent waiting for a reset
+	waited time.Duration
 }
 
 // RateLimit tracks API rate limiting per token
 type RateLimit struct {
 	Remaining int
 	ResetTime time.Time
-	mutex     sync.Mutex
 }
 
 // ProcessResult holds the result of processing a repository
@@ -71,6 +105,9 @@ type ProcessResult struct {
 	Stars         int
 	Error         error
 	Duration      time.Duration
+	// Truncated is set when ProcessTimeout cut the walk short; the files
+	// collected before it are kept
+	Truncated bool
 }
 
 // Stats tracks overall processing statistics
@@ -80,7 +117,11 @@ type Stats struct {
 	FilesAccepted   int64
 	FilesRejected   int64
 	DuplicatesFound int64
+	SecretsFound    int64 // files with secrets, whether masked, tagged or dropped
 	TotalSize       int64
+	MetadataFetched int64
+	MetadataFailed  int64
+	ReposTruncated  int64 // repos whose processing hit ProcessTimeout
 	Languages       map[string]int64
 	mutex           sync.RWMutex
 }
@@ -90,14 +131,75 @@ type Config struct {
 	OutputDir    string
 	MinStars     int
 	MaxWorkers   int
-	MinFileSize  int
-	MaxFileSize  int
 	QualityScore float64
 	TargetFiles  int64
 	TokenFile    string
 	RepoListFile string
+	// ProgressFile records processed repos so an interrupted run can resume
+	ProgressFile string
 	CloneTimeout time.Duration
 	APITimeout   time.Duration
+	// ProcessTimeout bounds the file walk of one repository; a repo still
+	// being processed when it expires is kept with what was collected so
+	// far and counted as truncated. 0 means no limit.
+	ProcessTimeout time.Duration
+	// CloneStrategies is the fallback order for fetching a repository
+	CloneStrategies []string
+	// ArchiveMaxSizeKB limits the archive strategy to repos the API reports
+	// as at most this size; larger ones go straight to a clone
+	ArchiveMaxSizeKB int
+	// HashAlgorithm is the content hash used to dedup files: md5 or xxhash
+	HashAlgorithm string
+	// Scrubber handles secrets in accepted files; nil masks them with every
+	// detector enabled
+	Scrubber *scrub.Scanner
+	// OutputFormat is "files" (one file plus .meta.json per accepted file)
+	// or "jsonl" (records appended to sharded JSONL files)
+	OutputFormat string
+	// ShardMaxBytes rotates JSONL shards at this size; 0 never rotates
+	ShardMaxBytes int64
+	// FlushInterval is how often buffered JSONL records are flushed
+	FlushInterval time.Duration
+	// MetadataWorkers is how many API lookups run ahead of the clone workers
+	MetadataWorkers int
+	// APIBaseURL is the GitHub API root, without a trailing slash
+	APIBaseURL string
+	// Files are the source file limits shared with the processors
+	Files sharedconfig.Files
+}
+
+// errDuplicateContent is returned by saveQualityFile for a file whose
+// content is already in the dataset
+var errDuplicateContent = errors.New("duplicate file content")
+
+// errSecretsFound is returned by saveQualityFile for a file dropped because
+// it contains secrets
+var errSecretsFound = errors.New("file contains secrets")
+
+// parseCloneStrategies parses a comma-separated strategy order such as
+// "archive,git,go-git"
+func parseCloneStrategies(list string) ([]string, error) {
+	var strategies []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		switch name {
+		case strategyArchive, strategyGit, strategyGoGit:
+		default:
+			return nil, fmt.Errorf("unknown clone strategy %q", name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			strategies = append(strategies, name)
+		}
+	}
+	if len(strategies) == 0 {
+		return nil, fmt.Errorf("no clone strategies in %q", list)
+	}
+	return strategies, nil
 }
 
 // FileQuality represents quality metrics for a code file
@@ -136,49 +238,215 @@ func NewTokenManager(tokenFile string) (*TokenManager, error) {
 		}
 	}
 
-	log.Printf("🔑 Loaded %d GitHub tokens", len(tokens))
+	log.Printf("🔑 Loaded %d GitHub tokens (%s)", len(tokens), summarizeTokenTypes(tokens))
 	return tm, nil
 }
 
-// GetToken returns the next available token with rate limit consideration
-func (tm *TokenManager) GetToken() string {
-	tm.mutex.RLock()
-	defer tm.mutex.RUnlock()
+// Validate checks every token against GET /rate_limit, dropping the ones
+// GitHub rejects and recording the real quota of the rest. Tokens that
+// can't be checked because of network errors are kept.
+func (tm *TokenManager) Validate(ctx context.Context, client *http.Client, apiBase string) error {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+
+	var valid []string
+	for i, token := range tm.tokens {
+		remaining, reset, err := checkToken(ctx, client, apiBase, token)
+		switch {
+		case errors.Is(err, errTokenRejected):
+			log.Printf("⚠️ Dropping %s token #%d: %v", tokenType(token), i+1, err)
+			delete(tm.rateLimits, token)
+			continue
+		case err != nil:
+			log.Printf("⚠️ Could not validate %s token #%d, keeping it: %v", tokenType(token), i+1, err)
+		default:
+			tm.rateLimits[token].Remaining = remaining
+			tm.rateLimits[token].ResetTime = reset
+		}
+		valid = append(valid, token)
+	}
+
+	if len(valid) == 0 {
+		return fmt.Errorf("all %d tokens were rejected by GitHub", len(tm.tokens))
+	}
+	tm.tokens = valid
+	tm.currentIndex = 0
+	log.Printf("🔑 %d GitHub tokens valid (%s)", len(valid), summarizeTokenTypes(valid))
+	return nil
+}
+
+// errTokenRejected means GitHub refused a token's credentials
+var errTokenRejected = errors.New("token rejected")
+
+// checkToken asks GitHub for a token's core rate limit
+func checkToken(ctx context.Context, client *http.Client, apiBase, token string) (int, time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", apiBase+"/rate_limit", nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return 0, time.Time{}, fmt.Errorf("%w: %s", errTokenRejected, resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, time.Time{}, fmt.Errorf("rate limit check failed: %s", resp.Status)
+	}
+
+	var body struct {
+		Resources struct {
+			Core struct {
+				Remaining int   `json:"remaining"`
+				Reset     int64 `json:"reset"`
+			} `json:"core"`
+		} `json:"resources"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, time.Time{}, err
+	}
+	return body.Resources.Core.Remaining, time.Unix(body.Resources.Core.Reset, 0), nil
+}
+
+// GetToken returns the next token with quota left, in round-robin order.
+// When every token is exhausted it sleeps until the earliest reset rather
+// than spending requests on certain 403s, returning early if ctx is done.
+func (tm *TokenManager) GetToken(ctx context.Context) (string, error) {
+	for {
+		token, reset := tm.availableToken(time.Now())
+		if token != "" {
+			return token, nil
+		}
+
+		wait := time.Until(reset)
+		if wait < time.Second {
+			wait = time.Second
+		}
+		log.Printf("⏳ All GitHub tokens exhausted, pausing %v until the earliest reset", wait.Round(time.Second))
+
+		start := time.Now()
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+		tm.mutex.Lock()
+		tm.waited += time.Since(start)
+		tm.mutex.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// availableToken returns the next token with quota left at now and advances
+// the rotation past it, or "" and the earliest reset time if there is none
+func (tm *TokenManager) availableToken(now time.Time) (string, time.Time) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
 
 	// Find token with available rate limit
+	var earliestReset time.Time
 	for i := 0; i < len(tm.tokens); i++ {
-		index := (int(atomic.LoadInt64(&tm.currentIndex)) + i) % len(tm.tokens)
+		index := (tm.currentIndex + i) % len(tm.tokens)
 		token := tm.tokens[index]
 
-		if rl, exists := tm.rateLimits[token]; exists {
-			rl.mutex.Lock()
-			if rl.Remaining > 10 || time.Now().After(rl.ResetTime) {
-				rl.mutex.Unlock()
-				atomic.StoreInt64(&tm.currentIndex, int64((index+1)%len(tm.tokens)))
-				return token
-			}
-			rl.mutex.Unlock()
+		rl, exists := tm.rateLimits[token]
+		if !exists {
+			continue
+		}
+		if rl.Remaining > 10 || now.After(rl.ResetTime) {
+			tm.currentIndex = (index + 1) % len(tm.tokens)
+			return token, time.Time{}
+		}
+		if earliestReset.IsZero() || rl.ResetTime.Before(earliestReset) {
+			earliestReset = rl.ResetTime
 		}
 	}
 
-	// If no token available, return the next one anyway (will hit rate limit)
-	index := int(atomic.AddInt64(&tm.currentIndex, 1)) % len(tm.tokens)
-	return tm.tokens[index]
+	return "", earliestReset
+}
+
+// Waited returns the total time spent waiting for rate limits to reset
+func (tm *TokenManager) Waited() time.Duration {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	return tm.waited
 }
 
 // UpdateRateLimit updates the rate limit for a specific token
 func (tm *TokenManager) UpdateRateLimit(token string, remaining int, resetTime time.Time) {
-	tm.mutex.RLock()
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
 	if rl, exists := tm.rateLimits[token]; exists {
-		rl.mutex.Lock()
 		rl.Remaining = remaining
 		rl.ResetTime = resetTime
-		rl.mutex.Unlock()
 	}
-	tm.mutex.RUnlock()
 }
 
-// loadTokens loads GitHub tokens from file
+// tokenPrefixes are the documented GitHub token prefixes and the kind of
+// token each marks. Fine-grained PATs come first since they share "gh".
+var tokenPrefixes = []struct {
+	prefix string
+	kind   string
+}{
+	{"github_pat_", "fine-grained"},
+	{"ghp_", "classic"},
+	{"gho_", "oauth"},
+	{"ghu_", "user-to-server"},
+	{"ghs_", "app installation"},
+	{"ghr_", "refresh"},
+}
+
+// Token length bounds: classic-style tokens are 40 characters and
+// fine-grained ones 93, but GitHub reserves the right to make them longer
+const (
+	minTokenLength = 40
+	maxTokenLength = 255
+)
+
+var tokenCharsPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// tokenType returns the kind of GitHub token, or "" if token isn't in a
+// recognised format
+func tokenType(token string) string {
+	if len(token) < minTokenLength || len(token) > maxTokenLength || !tokenCharsPattern.MatchString(token) {
+		return ""
+	}
+	for _, p := range tokenPrefixes {
+		if strings.HasPrefix(token, p.prefix) {
+			return p.kind
+		}
+	}
+	return ""
+}
+
+// summarizeTokenTypes counts tokens per kind, e.g. "2 classic, 1 fine-grained"
+func summarizeTokenTypes(tokens []string) string {
+	counts := make(map[string]int)
+	for _, token := range tokens {
+		counts[tokenType(token)]++
+	}
+	var parts []string
+	for _, p := range tokenPrefixes {
+		if n := counts[p.kind]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, p.kind))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loadTokens loads GitHub tokens from file, one per line. Blank lines and
+// # comments are ignored; malformed lines are skipped with a warning that
+// names the line but not its contents.
 func loadTokens(filename string) ([]string, error) {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -187,15 +455,21 @@ func loadTokens(filename string) ([]string, error) {
 	defer file.Close()
 
 	var tokens []string
+	seen := make(map[string]bool)
 	scanner := bufio.NewScanner(file)
 
-	for scanner.Scan() {
+	for lineNum := 1; scanner.Scan(); lineNum++ {
 		line := strings.TrimSpace(scanner.Text())
-		if line != "" && !strings.HasPrefix(line, "#") {
-			// Validate token format (basic check)
-			if strings.HasPrefix(line, "ghp_") && len(line) == 40 {
-				tokens = append(tokens, line)
-			}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if tokenType(line) == "" {
+			log.Printf("⚠️ Skipping malformed token on line %d of %s", lineNum, filename)
+			continue
+		}
+		if !seen[line] {
+			seen[line] = true
+			tokens = append(tokens, line)
 		}
 	}
 
@@ -203,10 +477,32 @@ func loadTokens(filename string) ([]string, error) {
 }
 
 // NewWorkerPool creates a new worker pool
-func NewWorkerPool(workerCount int, tm *TokenManager, config *Config) *WorkerPool {
+func NewWorkerPool(workerCount int, tm *TokenManager, config *Config) (*WorkerPool, error) {
+	hashAlgorithm := config.HashAlgorithm
+	if hashAlgorithm == "" {
+		hashAlgorithm = hashXXHash
+	}
+	seen, err := newContentSet(hashAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	output, err := newDatasetWriter(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.APIBaseURL == "" {
+		config.APIBaseURL = "https://api.github.com"
+	}
+	scrubber := config.Scrubber
+	if scrubber == nil {
+		if scrubber, err = scrub.New(scrub.ModeMask, nil); err != nil {
+			return nil, err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &WorkerPool{
+	wp := &WorkerPool{
 		workerCount:  workerCount,
 		jobQueue:     make(chan RepoInfo, workerCount*2),
 		resultQueue:  make(chan ProcessResult, workerCount*2),
@@ -216,7 +512,18 @@ func NewWorkerPool(workerCount int, tm *TokenManager, config *Config) *WorkerPoo
 		tokenManager: tm,
 		stats:        NewStats(),
 		config:       config,
-	}
+		seen:         seen,
+		output:       output,
+		scrubber:     scrubber,
+		apiClient:    &http.Client{},
+		stopping:     make(chan struct{}),
+	}
+	wp.strategies = map[string]cloneStrategy{
+		strategyArchive: wp.cloneWithArchive,
+		strategyGit:     wp.cloneWithGitCommand,
+		strategyGoGit:   wp.cloneWithGoGit,
+	}
+	return wp, nil
 }
 
 // NewStats creates a new stats tracker
@@ -236,20 +543,51 @@ func (wp *WorkerPool) Start() {
 	log.Printf("🚀 Started %d workers", wp.workerCount)
 }
 
-// Stop stops the worker pool
+// StopFeeding makes AddJob refuse new work and workers skip queued jobs;
+// repositories already being processed run to completion. Safe to call
+// more than once and from any goroutine.
+func (wp *WorkerPool) StopFeeding() {
+	wp.stopFeeding.Do(func() { close(wp.stopping) })
+}
+
+// Stopping reports whether StopFeeding has been called
+func (wp *WorkerPool) Stopping() bool {
+	select {
+	case <-wp.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop waits for the workers to finish and closes the result queue. It must
+// be called from the goroutine that calls AddJob, and is idempotent.
 func (wp *WorkerPool) Stop() {
-	close(wp.jobQueue)
-	wp.wg.Wait()
-	close(wp.resultQueue)
-	wp.cancel()
+	wp.stopWorkers.Do(func() {
+		wp.StopFeeding()
+		close(wp.jobQueue)
+		wp.wg.Wait()
+		close(wp.resultQueue)
+		wp.cancel()
+		if err := wp.output.Close(); err != nil {
+			log.Printf("⚠️ Failed to close dataset output: %v", err)
+		}
+	})
 }
 
-// AddJob adds a repository to the processing queue
-func (wp *WorkerPool) AddJob(repo RepoInfo) {
+// AddJob adds a repository to the processing queue. It returns false once
+// the pool is stopping.
+func (wp *WorkerPool) AddJob(repo RepoInfo) bool {
+	if wp.Stopping() {
+		return false
+	}
 	select {
 	case wp.jobQueue <- repo:
+		return true
+	case <-wp.stopping:
+		return false
 	case <-wp.ctx.Done():
-		return
+		return false
 	}
 }
 
@@ -263,6 +601,9 @@ func (wp *WorkerPool) worker(id int) {
 			if !ok {
 				return
 			}
+			if wp.Stopping() {
+				continue // Leave it for the next run
+			}
 
 			start := time.Now()
 			result := wp.processRepository(repo)
@@ -297,8 +638,11 @@ func (wp *WorkerPool) processRepository(repo RepoInfo) ProcessResult {
 	defer os.RemoveAll(tempDir)
 
 	// Clone repository
-	token := wp.tokenManager.GetToken()
-	if err := wp.cloneRepository(repo.URL, tempDir, token); err != nil {
+	token, err := wp.tokenManager.GetToken(wp.ctx)
+	if err != nil {
+		return ProcessResult{RepoURL: repo.URL, Error: err}
+	}
+	if err := wp.cloneRepository(repo, tempDir, token); err != nil {
 		return ProcessResult{
 			RepoURL: repo.URL,
 			Error:   fmt.Errorf("clone failed: %w", err),
@@ -306,72 +650,91 @@ func (wp *WorkerPool) processRepository(repo RepoInfo) ProcessResult {
 	}
 
 	// Process files
-	filesAdded, filesRejected := wp.processFiles(tempDir, repo)
+	ctx, cancel := wp.processContext()
+	defer cancel()
+	filesAdded, filesRejected, truncated := wp.processFiles(ctx, tempDir, repo)
+
+	// A repo interrupted by shutdown is left for the next run
+	if err := wp.ctx.Err(); err != nil {
+		return ProcessResult{RepoURL: repo.URL, Error: fmt.Errorf("processing interrupted: %w", err)}
+	}
 
 	// Update stats
 	atomic.AddInt64(&wp.stats.ReposProcessed, 1)
+	if truncated {
+		atomic.AddInt64(&wp.stats.ReposTruncated, 1)
+	}
 
 	return ProcessResult{
 		RepoURL:       repo.URL,
 		FilesAdded:    filesAdded,
 		FilesRejected: filesRejected,
 		Stars:         repo.Stars,
+		Truncated:     truncated,
 	}
 }
 
-// cloneRepository clones a repository using git with aggressive parallelization
-func (wp *WorkerPool) cloneRepository(repoURL, tempDir string, token string) error {
-	ctx, cancel := context.WithTimeout(wp.ctx, wp.config.CloneTimeout)
-	defer cancel()
-
-	// Try multiple clone strategies in parallel for maximum speed
-	type cloneResult struct {
-		err    error
-		method string
+// processContext bounds the processing of one repository by ProcessTimeout
+func (wp *WorkerPool) processContext() (context.Context, context.CancelFunc) {
+	if wp.config.ProcessTimeout <= 0 {
+		return context.WithCancel(wp.ctx)
 	}
+	return context.WithTimeout(wp.ctx, wp.config.ProcessTimeout)
+}
 
-	resultChan := make(chan cloneResult, 3)
-
-	// Method 1: go-git (most reliable)
-	go func() {
-		err := wp.cloneWithGoGit(ctx, repoURL, tempDir, token)
-		resultChan <- cloneResult{err: err, method: "go-git"}
-	}()
+// cloneRepository fetches repo into destDir, which must not exist yet. The
+// configured strategies are tried in order, each in its own temporary
+// directory next to destDir; the first to succeed is renamed into place and
+// failed attempts are removed. The archive strategy is skipped for repos
+// larger than ArchiveMaxSizeKB or of unknown size.
+func (wp *WorkerPool) cloneRepository(repo RepoInfo, destDir string, token string) error {
+	ctx, cancel := context.WithTimeout(wp.ctx, wp.config.CloneTimeout)
+	defer cancel()
 
-	// Method 2: git command (often faster for large repos)
-	go func() {
-		err := wp.cloneWithGitCommand(ctx, repoURL, tempDir, token)
-		resultChan <- cloneResult{err: err, method: "git-cmd"}
-	}()
+	var errs []error
+	for _, name := range wp.config.CloneStrategies {
+		if name == strategyArchive && (repo.Size <= 0 || repo.Size > wp.config.ArchiveMaxSizeKB) {
+			continue
+		}
+		strategy, ok := wp.strategies[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: unknown clone strategy", name))
+			continue
+		}
 
-	// Method 3: curl + unzip for GitHub repos (fastest for small repos)
-	go func() {
-		err := wp.cloneWithArchive(ctx, repoURL, tempDir, token)
-		resultChan <- cloneResult{err: err, method: "archive"}
-	}()
+		attemptDir, err := os.MkdirTemp(filepath.Dir(destDir), filepath.Base(destDir)+"."+name+"-")
+		if err != nil {
+			return err
+		}
 
-	// Return first successful result
-	for i := 0; i < 3; i++ {
-		select {
-		case result := <-resultChan:
-			if result.err == nil {
-				return nil // Success!
+		if err := strategy(ctx, repo, attemptDir, token); err != nil {
+			os.RemoveAll(attemptDir)
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			if ctx.Err() != nil {
+				break
 			}
-		case <-ctx.Done():
-			return ctx.Err()
+			continue
+		}
+
+		if err := os.Rename(attemptDir, destDir); err != nil {
+			os.RemoveAll(attemptDir)
+			return fmt.Errorf("failed to move %s result into place: %w", name, err)
 		}
+		return nil
 	}
 
-	// If all methods failed, try one more time with go-git
-	return wp.cloneWithGoGit(ctx, repoURL, tempDir, token)
+	if len(errs) == 0 {
+		return fmt.Errorf("no clone strategy applies to %s", repo.URL)
+	}
+	return errors.Join(errs...)
 }
 
 // cloneWithGoGit uses go-git library
-func (wp *WorkerPool) cloneWithGoGit(ctx context.Context, repoURL, tempDir, token string) error {
+func (wp *WorkerPool) cloneWithGoGit(ctx context.Context, repo RepoInfo, tempDir, token string) error {
 	_, err := git.PlainCloneContext(ctx, tempDir, false, &git.CloneOptions{
-		URL:   repoURL,
+		URL:   repo.URL,
 		Depth: 1,
-		Auth: &http.BasicAuth{
+		Auth: &githttp.BasicAuth{
 			Username: "token",
 			Password: token,
 		},
@@ -382,9 +745,9 @@ func (wp *WorkerPool) cloneWithGoGit(ctx context.Context, repoURL, tempDir, toke
 }
 
 // cloneWithGitCommand uses git CLI (often faster)
-func (wp *WorkerPool) cloneWithGitCommand(ctx context.Context, repoURL, tempDir, token string) error {
+func (wp *WorkerPool) cloneWithGitCommand(ctx context.Context, repo RepoInfo, tempDir, token string) error {
 	// Create authenticated URL
-	authURL := strings.Replace(repoURL, "https://", fmt.Sprintf("https://token:%s@", token), 1)
+	authURL := strings.Replace(repo.URL, "https://", fmt.Sprintf("https://token:%s@", token), 1)
 
 	cmd := exec.CommandContext(ctx, "git", "clone", "--depth", "1", "--single-branch", authURL, tempDir)
 	cmd.Env = append(os.Environ(),
@@ -396,15 +759,15 @@ func (wp *WorkerPool) cloneWithGitCommand(ctx context.Context, repoURL, tempDir,
 }
 
 // cloneWithArchive downloads repository as ZIP (fastest for small repos)
-func (wp *WorkerPool) cloneWithArchive(ctx context.Context, repoURL, tempDir, token string) error {
+func (wp *WorkerPool) cloneWithArchive(ctx context.Context, repo RepoInfo, tempDir, token string) error {
 	// Extract owner/repo from URL
-	parts := strings.Split(strings.TrimPrefix(repoURL, "https://github.com/"), "/")
+	parts := strings.Split(strings.TrimPrefix(repo.URL, "https://github.com/"), "/")
 	if len(parts) < 2 {
 		return fmt.Errorf("invalid GitHub URL")
 	}
 
 	// Download ZIP archive
-	zipURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/zipball", parts[0], parts[1])
+	zipURL := fmt.Sprintf("%s/repos/%s/%s/zipball", wp.config.APIBaseURL, parts[0], parts[1])
 
 	client := &http.Client{Timeout: wp.config.APITimeout}
 	req, err := http.NewRequestWithContext(ctx, "GET", zipURL, nil)
@@ -499,27 +862,30 @@ func (wp *WorkerPool) extractZip(zipFile, destDir string) error {
 	return nil
 }
 
-// processFiles processes all code files in a repository
-func (wp *WorkerPool) processFiles(repoDir string, repo RepoInfo) (int, int) {
-	filesAdded := 0
-	filesRejected := 0
-
+// processFiles processes the code files in a repository, checking ctx
+// before each entry. When ctx is done the walk stops and the counts so far
+// are returned, with truncated set if it was ctx's deadline that ended it.
+func (wp *WorkerPool) processFiles(ctx context.Context, repoDir string, repo RepoInfo) (filesAdded, filesRejected int, truncated bool) {
 	err := filepath.Walk(repoDir, func(path string, info os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		if err != nil {
 			return nil // Continue on errors
 		}
 
-		if !info.Mode().IsRegular() {
+		if info.IsDir() {
+			if path != repoDir && skipDirs[info.Name()] {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-
-		// Skip if file doesn't meet basic criteria
-		if !wp.shouldProcessFile(path, info) {
+		if !info.Mode().IsRegular() {
 			return nil
 		}
 
-		// Read and analyze file
-		content, err := os.ReadFile(path)
+		// Skip if file doesn't meet the shared file limits
+		content, _, err := wp.admitFile(path, info)
 		if err != nil {
 			return nil
 		}
@@ -537,7 +903,8 @@ func (wp *WorkerPool) processFiles(repoDir string, repo RepoInfo) (int, int) {
 		}
 
 		// Save high-quality file
-		if wp.saveQualityFile(path, string(content), quality, repo) {
+		switch err := wp.saveQualityFile(path, string(content), quality, repo); {
+		case err == nil:
 			filesAdded++
 			atomic.AddInt64(&wp.stats.FilesAccepted, 1)
 
@@ -545,7 +912,12 @@ func (wp *WorkerPool) processFiles(repoDir string, repo RepoInfo) (int, int) {
 			wp.stats.mutex.Lock()
 			wp.stats.Languages[quality.Language]++
 			wp.stats.mutex.Unlock()
-		} else {
+		case errors.Is(err, errDuplicateContent):
+			atomic.AddInt64(&wp.stats.DuplicatesFound, 1)
+		case errors.Is(err, errSecretsFound):
+			filesRejected++
+		default:
+			log.Printf("⚠️ %v", err)
 			filesRejected++
 		}
 
@@ -553,39 +925,43 @@ func (wp *WorkerPool) processFiles(repoDir string, repo RepoInfo) (int, int) {
 		return nil
 	})
 
-	if err != nil {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		log.Printf("⏱️ %s: processing stopped after %v, keeping %d files", repo.FullName, wp.config.ProcessTimeout, filesAdded)
+		truncated = true
+	case err != nil && !errors.Is(err, context.Canceled):
 		log.Printf("⚠️ Error walking directory %s: %v", repoDir, err)
 	}
 
-	return filesAdded, filesRejected
+	return filesAdded, filesRejected, truncated
 }
 
-// shouldProcessFile determines if a file should be processed
-func (wp *WorkerPool) shouldProcessFile(path string, info os.FileInfo) bool {
-	// Size checks
-	if info.Size() < int64(wp.config.MinFileSize) || info.Size() > int64(wp.config.MaxFileSize) {
-		return false
-	}
+// skipDirs are dependency, build and VCS directories. They are matched
+// against whole directory names, so src/builder or rebuild.go are kept.
+var skipDirs = map[string]bool{
+	".git": true, "node_modules": true, "target": true, "build": true, "dist": true,
+	"__pycache__": true, "vendor": true, ".venv": true, "venv": true,
+}
 
-	// Skip certain directories
-	skipDirs := []string{".git", "node_modules", "target", "build", "dist", "__pycache__", "vendor", ".venv", "venv"}
-	for _, dir := range skipDirs {
-		if strings.Contains(path, dir) {
-			return false
-		}
+// admitFile reads a file and applies the file limits shared with the
+// processors, returning its content and language when it becomes a sample
+func (wp *WorkerPool) admitFile(path string, info os.FileInfo) ([]byte, string, error) {
+	files := wp.config.Files
+	if err := files.CheckName(path); err != nil {
+		return nil, "", err
 	}
-
-	// Check file extension
-	ext := filepath.Ext(path)
-	supportedExts := []string{".py", ".js", ".ts", ".jsx", ".tsx", ".go", ".rs", ".java", ".cpp", ".c", ".h", ".hpp", ".cs", ".php", ".rb", ".swift", ".kt", ".scala"}
-
-	for _, supportedExt := range supportedExts {
-		if ext == supportedExt {
-			return true
-		}
+	if err := files.CheckSize(info.Size()); err != nil {
+		return nil, "", err
 	}
-
-	return false
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	language, err := files.Check(path, content)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, language, nil
 }
 
 // analyzeCodeQuality analyzes the quality of a code file
@@ -749,22 +1125,40 @@ func hasDocumentation(content, language string) bool {
 	return false
 }
 
+// testPatterns are the regexps hasTestCode looks for, per language
+var testPatterns = map[string][]string{
+	"python":     {"def test_", "class Test", "import unittest", "import pytest", "assert "},
+	"javascript": {"describe(", "it(", "test(", "expect(", "assert"},
+	"typescript": {"describe(", "it(", "test(", "expect(", "assert"},
+	"go":         {"func Test", "testing.T", "t.Error", "t.Fatal"},
+	"rust":       {"#[test]", "#[cfg(test)]", "assert!", "assert_eq!"},
+	"java":       {"@Test", "import.*junit", "Assert.", "assertEquals"},
+	"cpp":        {"TEST(", "EXPECT_", "ASSERT_", "#include.*gtest"},
+	"c":          {"assert(", "TEST_"},
+}
+
+// compiledTestPatterns holds testPatterns compiled once. Patterns that
+// aren't valid regexps (such as "describe(") never matched when they were
+// compiled on every call, so they are left out rather than changing what
+// counts as test code.
+var compiledTestPatterns = compileTestPatterns(testPatterns)
+
+func compileTestPatterns(patterns map[string][]string) map[string][]*regexp.Regexp {
+	compiled := make(map[string][]*regexp.Regexp, len(patterns))
+	for language, list := range patterns {
+		for _, pattern := range list {
+			if re, err := regexp.Compile(pattern); err == nil {
+				compiled[language] = append(compiled[language], re)
+			}
+		}
+	}
+	return compiled
+}
+
 // hasTestCode checks if the code contains test patterns
 func hasTestCode(content, language string) bool {
-	testPatterns := map[string][]string{
-		"python":     {"def test_", "class Test", "import unittest", "import pytest", "assert "},
-		"javascript": {"describe(", "it(", "test(", "expect(", "assert"},
-		"typescript": {"describe(", "it(", "test(", "expect(", "assert"},
-		"go":         {"func Test", "testing.T", "t.Error", "t.Fatal"},
-		"rust":       {"#[test]", "#[cfg(test)]", "assert!", "assert_eq!"},
-		"java":       {"@Test", "import.*junit", "Assert.", "assertEquals"},
-		"cpp":        {"TEST(", "EXPECT_", "ASSERT_", "#include.*gtest"},
-		"c":          {"assert(", "TEST_"},
-	}
-
-	patterns := testPatterns[language]
-	for _, pattern := range patterns {
-		if matched, _ := regexp.MatchString(pattern, content); matched {
+	for _, re := range compiledTestPatterns[language] {
+		if re.MatchString(content) {
 			return true
 		}
 	}
@@ -832,50 +1226,48 @@ func calculateQualityScore(loc int, commentRatio, complexity float64, hasDocs, h
 }
 
 // saveQualityFile saves a high-quality file to the dataset
-func (wp *WorkerPool) saveQualityFile(originalPath, content string, quality *FileQuality, repo RepoInfo) bool {
-	// Create output directory structure
-	outputDir := filepath.Join(wp.config.OutputDir, quality.Language)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		log.Printf("⚠️ Failed to create directory %s: %v", outputDir, err)
-		return false
-	}
-
-	// Generate unique filename
-	filename := fmt.Sprintf("%s_%d_%s",
-		strings.ReplaceAll(repo.FullName, "/", "_"),
-		time.Now().UnixNano(),
-		filepath.Base(originalPath))
-
-	outputPath := filepath.Join(outputDir, filename)
-
-	// Write file
-	if err := os.WriteFile(outputPath, []byte(content), 0644); err != nil {
-		log.Printf("⚠️ Failed to write file %s: %v", outputPath, err)
-		return false
+func (wp *WorkerPool) saveQualityFile(originalPath, content string, quality *FileQuality, repo RepoInfo) error {
+	scrubbed := wp.scrubber.Scan(content)
+	if len(scrubbed.Findings) > 0 {
+		atomic.AddInt64(&wp.stats.SecretsFound, 1)
+	}
+	if scrubbed.Drop {
+		return errSecretsFound
+	}
+	content = scrubbed.Content
+
+	// Skip content another repo already contributed, e.g. vendored libraries
+	hash := wp.seen.Sum([]byte(content))
+	if _, added := wp.seen.Add(hash, repo.FullName); !added {
+		return errDuplicateContent
+	}
+
+	rec := &datasetRecord{
+		OriginalPath:    originalPath,
+		RepoURL:         repo.URL,
+		RepoFullName:    repo.FullName,
+		RepoStars:       repo.Stars,
+		Language:        quality.Language,
+		LinesOfCode:     quality.LinesOfCode,
+		CommentRatio:    quality.CommentRatio,
+		ComplexityScore: quality.ComplexityScore,
+		HasDocs:         quality.HasDocs,
+		HasTests:        quality.HasTests,
+		StyleScore:      quality.StyleScore,
+		QualityScore:    quality.QualityScore,
+		ContentHash:     hexHash(hash),
+		HashAlgorithm:   wp.seen.algorithm,
+		FirstSeenRepo:   repo.FullName,
+		SecretsFound:    scrubbed.Detectors(),
+		CreatedAt:       time.Now().Format(time.RFC3339),
+		Content:         content,
+	}
+	if err := wp.output.Write(rec); err != nil {
+		wp.seen.Remove(hash)
+		return err
 	}
 
-	// Write metadata
-	metadata := map[string]interface{}{
-		"original_path":    originalPath,
-		"repo_url":         repo.URL,
-		"repo_full_name":   repo.FullName,
-		"repo_stars":       repo.Stars,
-		"language":         quality.Language,
-		"lines_of_code":    quality.LinesOfCode,
-		"comment_ratio":    quality.CommentRatio,
-		"complexity_score": quality.ComplexityScore,
-		"has_docs":         quality.HasDocs,
-		"has_tests":        quality.HasTests,
-		"style_score":      quality.StyleScore,
-		"quality_score":    quality.QualityScore,
-		"created_at":       time.Now().Format(time.RFC3339),
-	}
-
-	metadataPath := outputPath + ".meta.json"
-	metadataJSON, _ := json.MarshalIndent(metadata, "", "  ")
-	os.WriteFile(metadataPath, metadataJSON, 0644)
-
-	return true
+	return nil
 }
 
 // GetStats returns current processing statistics
@@ -893,8 +1285,11 @@ func (s *Stats) PrintStats() {
 	fmt.Printf("   📄 Files processed: %d\n", s.FilesProcessed)
 	fmt.Printf("   ✅ Files accepted: %d\n", s.FilesAccepted)
 	fmt.Printf("   ❌ Files rejected: %d\n", s.FilesRejected)
-	fmt.Printf("   🔄 Duplicates found: %d\n", s.DuplicatesFound)
+	fmt.Printf("   🔄 Duplicates found: %d (%.1f%% of quality files)\n", s.DuplicatesFound, s.DedupRatio()*100)
+	fmt.Printf("   🔐 Files with secrets: %d\n", s.SecretsFound)
 	fmt.Printf("   💾 Total size: %.2f MB\n", float64(s.TotalSize)/(1024*1024))
+	fmt.Printf("   🛰️ Metadata lookups: %d ok, %d failed\n", s.MetadataFetched, s.MetadataFailed)
+	fmt.Printf("   ⏱️ Repositories truncated: %d\n", s.ReposTruncated)
 
 	fmt.Printf("\n🔤 Language Distribution:\n")
 	for lang, count := range s.Languages {
@@ -902,6 +1297,57 @@ func (s *Stats) PrintStats() {
 	}
 }
 
+// DedupRatio is the fraction of quality files skipped as duplicates
+func (s *Stats) DedupRatio() float64 {
+	duplicates := atomic.LoadInt64(&s.DuplicatesFound)
+	total := duplicates + atomic.LoadInt64(&s.FilesAccepted)
+	if total == 0 {
+		return 0
+	}
+	return float64(duplicates) / float64(total)
+}
+
+// progressSaveInterval is how many results go by between progress saves
+const progressSaveInterval = 100
+
+// collectResults logs results as they arrive, records finished repos in
+// progress and stops feeding once targetFiles files have been accepted. It
+// returns when the result queue is closed by Stop.
+func (wp *WorkerPool) collectResults(progress *Progress, targetFiles int64) {
+	count := 0
+	for result := range wp.resultQueue {
+		if result.Error != nil {
+			log.Printf("⚠️ %s: %v", result.RepoURL, result.Error)
+		} else if result.Truncated {
+			log.Printf("⏱️ %s: %d files added (%d rejected) in %v, truncated",
+				result.RepoURL, result.FilesAdded, result.FilesRejected, result.Duration)
+		} else {
+			log.Printf("✅ %s: %d files added (%d rejected) in %v",
+				result.RepoURL, result.FilesAdded, result.FilesRejected, result.Duration)
+		}
+
+		// Repos aborted by shutdown are retried next run
+		if !errors.Is(result.Error, context.Canceled) {
+			progress.Done(result.RepoURL)
+		}
+
+		count++
+		if count%progressSaveInterval == 0 {
+			wp.stats.PrintStats()
+			if err := progress.Save(wp.stats); err != nil {
+				log.Printf("⚠️ Failed to save progress: %v", err)
+			}
+		}
+
+		// Check if target reached. Keep draining so workers finishing their
+		// last repos aren't left blocked on the queue.
+		if accepted := atomic.LoadInt64(&wp.stats.FilesAccepted); accepted >= targetFiles && !wp.Stopping() {
+			log.Printf("🎯 TARGET REACHED! %d files collected", accepted)
+			wp.StopFeeding()
+		}
+	}
+}
+
 // loadRepositories loads repository URLs from file
 func loadRepositories(filename string) ([]RepoInfo, error) {
 	file, err := os.Open(filename)
@@ -926,20 +1372,25 @@ func loadRepositories(filename string) ([]RepoInfo, error) {
 	return repos, scanner.Err()
 }
 
-// fetchRepoMetadata fetches repository metadata from GitHub API
+// fetchRepoMetadata fetches repository metadata from GitHub API. The
+// repo's URL is kept as loaded, since the API's "url" is the API endpoint.
 func (wp *WorkerPool) fetchRepoMetadata(repo *RepoInfo) error {
-	token := wp.tokenManager.GetToken()
-
 	// Extract owner/repo from URL
 	parts := strings.Split(strings.TrimPrefix(repo.URL, "https://github.com/"), "/")
 	if len(parts) < 2 {
 		return fmt.Errorf("invalid repository URL: %s", repo.URL)
 	}
 
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s", parts[0], parts[1])
+	token, err := wp.tokenManager.GetToken(wp.ctx)
+	if err != nil {
+		return err
+	}
+
+	apiURL := fmt.Sprintf("%s/repos/%s/%s", wp.config.APIBaseURL, parts[0], parts[1])
 
-	client := &http.Client{Timeout: wp.config.APITimeout}
-	req, err := http.NewRequest("GET", apiURL, nil)
+	ctx, cancel := context.WithTimeout(wp.ctx, wp.config.APITimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
 		return err
 	}
@@ -947,7 +1398,7 @@ func (wp *WorkerPool) fetchRepoMetadata(repo *RepoInfo) error {
 	req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 
-	resp, err := client.Do(req)
+	resp, err := wp.apiClient.Do(req)
 	if err != nil {
 		return err
 	}
@@ -973,7 +1424,10 @@ func (wp *WorkerPool) fetchRepoMetadata(repo *RepoInfo) error {
 		return err
 	}
 
-	return json.Unmarshal(body, repo)
+	repoURL := repo.URL
+	err = json.Unmarshal(body, repo)
+	repo.URL = repoURL
+	return err
 }
 
 func main() {
@@ -983,33 +1437,83 @@ func main() {
 		OutputDir:    "\\\\192.168.1.66\\plex3\\codelupe\\repos\\mega_dataset",
 		MinStars:     5,
 		MaxWorkers:   cpuCores * 8, // 192 workers (24 * 8) - INSANE PARALLELISM
-		MinFileSize:  100,          // 100 bytes
-		MaxFileSize:  500000,       // 500KB
 		QualityScore: 30.0,         // Minimum quality score
 		TargetFiles:  100000000,    // 100M files
 		TokenFile:    "github_tokens.txt",
 		RepoListFile: "repository_urls.txt",
+		ProgressFile: "mega_scraper_progress.json",
 		CloneTimeout: 15 * time.Second, // Even faster with your beast CPU
 		APITimeout:   3 * time.Second,  // Lightning fast API calls
+
+		ProcessTimeout: 2 * time.Minute, // Huge monorepos keep what they yield by then
+
+		CloneStrategies:  []string{strategyArchive, strategyGit, strategyGoGit},
+		ArchiveMaxSizeKB: 50 * 1024, // Zipballs beat a clone for small repos
+		HashAlgorithm:    hashXXHash,
+		FlushInterval:    5 * time.Second,
+		MetadataWorkers:  cpuCores * 2, // API lookups are cheap; keep the clone workers fed
+	}
+
+	shared, err := sharedconfig.FromEnv()
+	if err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+	config.Files = shared.Files
+
+	if order := os.Getenv("CLONE_STRATEGIES"); order != "" {
+		strategies, err := parseCloneStrategies(order)
+		if err != nil {
+			log.Fatalf("❌ Invalid CLONE_STRATEGIES: %v", err)
+		}
+		config.CloneStrategies = strategies
+	}
+	if algorithm := os.Getenv("DEDUP_HASH"); algorithm != "" {
+		config.HashAlgorithm = algorithm
+	}
+	if path := os.Getenv("PROGRESS_FILE"); path != "" {
+		config.ProgressFile = path
 	}
+	scrubber, err := scrub.FromEnv()
+	if err != nil {
+		log.Fatalf("❌ Invalid secret scrubbing settings: %v", err)
+	}
+	config.Scrubber = scrubber
 
 	log.Printf("🔥 BEAST MODE: Ryzen 3900X detected - %d CPU threads", cpuCores)
 	log.Printf("⚡ Launching %d concurrent workers (8x CPU threads)", config.MaxWorkers)
 
 	// Parse command line arguments
-	if len(os.Args) > 1 {
-		config.RepoListFile = os.Args[1]
+	validateTokens := flag.Bool("validate-tokens", false, "check each GitHub token with GET /rate_limit at startup and drop rejected ones")
+	flag.StringVar(&config.OutputFormat, "output-format", outputFiles, "how accepted files are stored: files or jsonl")
+	shardSizeMB := flag.Int64("shard-size-mb", 256, "rotate JSONL shards at this size (0 = never)")
+	flag.IntVar(&config.MetadataWorkers, "metadata-workers", config.MetadataWorkers, "concurrent GitHub API metadata lookups")
+	flag.DurationVar(&config.ProcessTimeout, "process-timeout", config.ProcessTimeout, "stop processing a repository's files after this long and keep what was collected (0 = no limit)")
+	flag.Parse()
+	config.ShardMaxBytes = *shardSizeMB << 20
+	if flag.NArg() > 0 {
+		config.RepoListFile = flag.Arg(0)
 	}
 
 	log.Printf("🚀 MEGA DATASET SCRAPER STARTING")
 	log.Printf("🎯 Target: %d high-quality files", config.TargetFiles)
 	log.Printf("⚙️ Workers: %d", config.MaxWorkers)
+	log.Printf("🔁 Clone strategies: %s", strings.Join(config.CloneStrategies, " → "))
+	log.Printf("💾 Output: %s in %s", config.OutputFormat, config.OutputDir)
+	log.Printf("🔐 Secret scrubbing: %s", config.Scrubber)
 
 	// Initialize token manager
 	tokenManager, err := NewTokenManager(config.TokenFile)
 	if err != nil {
 		log.Fatalf("❌ Failed to initialize token manager: %v", err)
 	}
+	if *validateTokens {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := tokenManager.Validate(ctx, &http.Client{Timeout: config.APITimeout}, "https://api.github.com")
+		cancel()
+		if err != nil {
+			log.Fatalf("❌ Token validation failed: %v", err)
+		}
+	}
 
 	// Load repositories
 	repos, err := loadRepositories(config.RepoListFile)
@@ -1020,65 +1524,72 @@ func main() {
 	log.Printf("📋 Loaded %d repositories", len(repos))
 
 	// Create worker pool
-	wp := NewWorkerPool(config.MaxWorkers, tokenManager, config)
-	wp.Start()
+	wp, err := NewWorkerPool(config.MaxWorkers, tokenManager, config)
+	if err != nil {
+		log.Fatalf("❌ Failed to create worker pool: %v", err)
+	}
 
-	// Start result processor
+	progress, err := LoadProgress(config.ProgressFile, wp.stats)
+	if err != nil {
+		log.Fatalf("❌ Failed to load progress: %v", err)
+	}
+	if n := progress.Len(); n > 0 {
+		log.Printf("♻️ Resuming: %d repositories already processed (%s)", n, config.ProgressFile)
+	}
+
+	// First signal stops feeding and lets in-flight repos finish; a second
+	// one aborts them
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
-		for result := range wp.resultQueue {
-			if result.Error != nil {
-				log.Printf("⚠️ %s: %v", result.RepoURL, result.Error)
-			} else {
-				log.Printf("✅ %s: %d files added (%d rejected) in %v",
-					result.RepoURL, result.FilesAdded, result.FilesRejected, result.Duration)
-			}
+		sig := <-sigChan
+		log.Printf("🛑 Received %v, finishing in-flight repositories (signal again to abort)...", sig)
+		wp.StopFeeding()
+		<-sigChan
+		log.Printf("🛑 Aborting in-flight repositories")
+		wp.cancel()
+	}()
 
-			// Print stats every 100 repos
-			if wp.stats.ReposProcessed%100 == 0 {
-				wp.stats.PrintStats()
-			}
+	wp.Start()
 
-			// Check if target reached
-			if wp.stats.FilesAccepted >= config.TargetFiles {
-				log.Printf("🎯 TARGET REACHED! %d files collected", wp.stats.FilesAccepted)
-				wp.Stop()
-				break
-			}
-		}
+	// Start result processor
+	resultsDone := make(chan struct{})
+	go func() {
+		defer close(resultsDone)
+		wp.collectResults(progress, config.TargetFiles)
 	}()
 
 	// Process repositories
 	startTime := time.Now()
-	for i, repo := range repos {
-		// Fetch metadata first
-		if err := wp.fetchRepoMetadata(&repo); err != nil {
-			log.Printf("⚠️ Failed to fetch metadata for %s: %v", repo.URL, err)
-			continue
-		}
-
-		// Add to processing queue
-		wp.AddJob(repo)
-
-		// Progress update
-		if i%1000 == 0 {
-			elapsed := time.Since(startTime)
-			rate := float64(wp.stats.FilesAccepted) / elapsed.Seconds()
-			log.Printf("📈 Progress: %d/%d repos | %d files | %.1f files/sec",
-				i, len(repos), wp.stats.FilesAccepted, rate)
-		}
-	}
+	skipped := wp.Prefetch(repos, progress)
 
 	// Wait for completion
 	wp.Stop()
+	<-resultsDone
+
+	if err := progress.Save(wp.stats); err != nil {
+		log.Printf("⚠️ Failed to save progress: %v", err)
+	}
+	if skipped > 0 {
+		log.Printf("⏭️ Skipped %d repositories processed by an earlier run", skipped)
+	}
 
 	// Final statistics
 	elapsed := time.Since(startTime)
 	wp.stats.PrintStats()
 
+	if wp.Stopping() && atomic.LoadInt64(&wp.stats.FilesAccepted) < config.TargetFiles {
+		log.Printf("⏸️ Stopped early; run again to resume from %s", config.ProgressFile)
+		return
+	}
+
 	log.Printf("\n🎉 MEGA DATASET COLLECTION COMPLETE!")
 	log.Printf("⏱️ Total time: %v", elapsed)
 	log.Printf("🏆 Final count: %d high-quality files", wp.stats.FilesAccepted)
 	log.Printf("📊 Average rate: %.1f files/second", float64(wp.stats.FilesAccepted)/elapsed.Seconds())
+	if waited := tokenManager.Waited(); waited > 0 {
+		log.Printf("⏳ Paused %v waiting for GitHub rate limit resets", waited.Round(time.Second))
+	}
 
 	// Compare to The Stack
 	theStackFiles := int64(54000000)
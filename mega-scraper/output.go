@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Output formats for accepted files
+const (
+	outputFiles = "files"
+	outputJSONL = "jsonl"
+)
+
+// datasetRecord is one accepted source file and its metadata. In files
+// mode the content goes to its own file and the rest to a .meta.json
+// sidecar; in jsonl mode the whole record is one line.
+type datasetRecord struct {
+	OriginalPath    string   `json:"original_path"`
+	RepoURL         string   `json:"repo_url"`
+	RepoFullName    string   `json:"repo_full_name"`
+	RepoStars       int      `json:"repo_stars"`
+	Language        string   `json:"language"`
+	LinesOfCode     int      `json:"lines_of_code"`
+	CommentRatio    float64  `json:"comment_ratio"`
+	ComplexityScore float64  `json:"complexity_score"`
+	HasDocs         bool     `json:"has_docs"`
+	HasTests        bool     `json:"has_tests"`
+	StyleScore      float64  `json:"style_score"`
+	QualityScore    float64  `json:"quality_score"`
+	ContentHash     string   `json:"content_hash"`
+	HashAlgorithm   string   `json:"hash_algorithm"`
+	FirstSeenRepo   string   `json:"first_seen_repo"`         // later copies elsewhere are skipped
+	SecretsFound    []string `json:"secrets_found,omitempty"` // scrub detectors that fired; masked unless SCRUB_MODE=tag
+	CreatedAt       string   `json:"created_at"`
+	Content         string   `json:"content,omitempty"`
+}
+
+// datasetWriter stores accepted files. Write is called concurrently by
+// workers.
+type datasetWriter interface {
+	Write(rec *datasetRecord) error
+	Close() error
+}
+
+// newDatasetWriter creates the writer for config.OutputFormat, defaulting
+// to one file per record
+func newDatasetWriter(config *Config) (datasetWriter, error) {
+	switch config.OutputFormat {
+	case "", outputFiles:
+		return &filesWriter{dir: config.OutputDir}, nil
+	case outputJSONL:
+		return newJSONLWriter(config.OutputDir, config.ShardMaxBytes, config.FlushInterval)
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected files or jsonl)", config.OutputFormat)
+	}
+}
+
+// filesWriter writes <dir>/<language>/<repo>_<nanos>_<name> plus a
+// .meta.json sidecar per record
+type filesWriter struct {
+	dir string
+}
+
+func (w *filesWriter) Write(rec *datasetRecord) error {
+	// Create output directory structure
+	outputDir := filepath.Join(w.dir, rec.Language)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", outputDir, err)
+	}
+
+	// Generate unique filename
+	filename := fmt.Sprintf("%s_%d_%s",
+		strings.ReplaceAll(rec.RepoFullName, "/", "_"),
+		time.Now().UnixNano(),
+		filepath.Base(rec.OriginalPath))
+
+	outputPath := filepath.Join(outputDir, filename)
+
+	// Write file
+	if err := os.WriteFile(outputPath, []byte(rec.Content), 0644); err != nil {
+		return fmt.Errorf("failed to write file %s: %w", outputPath, err)
+	}
+
+	// Write metadata
+	metadata := *rec
+	metadata.Content = ""
+	metadataPath := outputPath + ".meta.json"
+	metadataJSON, _ := json.MarshalIndent(metadata, "", "  ")
+	os.WriteFile(metadataPath, metadataJSON, 0644)
+
+	return nil
+}
+
+func (w *filesWriter) Close() error { return nil }
+
+// jsonlWriter appends records to dataset-<lang>-<shard>.jsonl files,
+// starting a new shard once the current one would exceed maxBytes.
+// Buffers are flushed every flushInterval and on Close.
+type jsonlWriter struct {
+	dir      string
+	maxBytes int64
+
+	mu     sync.Mutex
+	shards map[string]*jsonlShard // by language
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type jsonlShard struct {
+	index   int
+	file    *os.File
+	buf     *bufio.Writer
+	written int64
+}
+
+func newJSONLWriter(dir string, maxBytes int64, flushInterval time.Duration) (*jsonlWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+	if flushInterval <= 0 {
+		flushInterval = 5 * time.Second
+	}
+
+	w := &jsonlWriter{
+		dir:      dir,
+		maxBytes: maxBytes,
+		shards:   make(map[string]*jsonlShard),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go w.flushLoop(flushInterval)
+	return w, nil
+}
+
+func (w *jsonlWriter) flushLoop(interval time.Duration) {
+	defer close(w.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.Flush(); err != nil {
+				log.Printf("⚠️ Failed to flush JSONL output: %v", err)
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *jsonlWriter) Write(rec *datasetRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	shard, ok := w.shards[rec.Language]
+	if !ok {
+		if shard, err = w.openShard(rec.Language, nextShardIndex(w.dir, rec.Language)); err != nil {
+			return err
+		}
+		w.shards[rec.Language] = shard
+	} else if w.maxBytes > 0 && shard.written > 0 && shard.written+int64(len(line)) > w.maxBytes {
+		if err := shard.close(); err != nil {
+			return err
+		}
+		if shard, err = w.openShard(rec.Language, shard.index+1); err != nil {
+			delete(w.shards, rec.Language)
+			return err
+		}
+		w.shards[rec.Language] = shard
+	}
+
+	n, err := shard.buf.Write(line)
+	shard.written += int64(n)
+	return err
+}
+
+// Flush writes buffered records through to the shard files
+func (w *jsonlWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for lang, shard := range w.shards {
+		if err := shard.buf.Flush(); err != nil {
+			return fmt.Errorf("flushing %s shard: %w", lang, err)
+		}
+	}
+	return nil
+}
+
+// Close stops the flush loop and flushes and closes every shard
+func (w *jsonlWriter) Close() error {
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+	for lang, shard := range w.shards {
+		if err := shard.close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("closing %s shard: %w", lang, err)
+		}
+		delete(w.shards, lang)
+	}
+	return firstErr
+}
+
+func shardPath(dir, lang string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("dataset-%s-%05d.jsonl", lang, index))
+}
+
+// nextShardIndex returns the index after the highest existing shard for
+// lang, so a resumed run never appends to a shard a crash may have cut off
+// mid-line
+func nextShardIndex(dir, lang string) int {
+	matches, _ := filepath.Glob(filepath.Join(dir, "dataset-"+lang+"-*.jsonl"))
+	next := 0
+	for _, match := range matches {
+		suffix := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(match), "dataset-"+lang+"-"), ".jsonl")
+		if index, err := strconv.Atoi(suffix); err == nil && index >= next {
+			next = index + 1
+		}
+	}
+	return next
+}
+
+func (w *jsonlWriter) openShard(lang string, index int) (*jsonlShard, error) {
+	path := shardPath(w.dir, lang, index)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open shard %s: %w", path, err)
+	}
+	return &jsonlShard{index: index, file: file, buf: bufio.NewWriterSize(file, 1<<20)}, nil
+}
+
+func (s *jsonlShard) close() error {
+	if err := s.buf.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Prefetch looks up metadata for repos with MetadataWorkers concurrent API
+// calls and feeds them to the job queue, so clone workers aren't left
+// waiting on one lookup at a time. Repos progress already has are skipped;
+// a repo whose lookup fails is still queued, with Stars=0. It returns the
+// number skipped, once every repo is queued or the pool stops.
+func (wp *WorkerPool) Prefetch(repos []RepoInfo, progress *Progress) int {
+	workers := wp.config.MetadataWorkers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var queued int64
+	startTime := time.Now()
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if wp.Stopping() {
+					continue // Drain the remaining indexes without lookups
+				}
+				repo := repos[i]
+				if err := wp.fetchRepoMetadata(&repo); err != nil {
+					atomic.AddInt64(&wp.stats.MetadataFailed, 1)
+					log.Printf("⚠️ Failed to fetch metadata for %s, queuing without it: %v", repo.URL, err)
+					if repo.FullName == "" {
+						repo.FullName = fullNameFromURL(repo.URL)
+					}
+				} else {
+					atomic.AddInt64(&wp.stats.MetadataFetched, 1)
+				}
+
+				if !wp.AddJob(repo) {
+					continue
+				}
+
+				// Progress update
+				if n := atomic.AddInt64(&queued, 1); n%1000 == 0 {
+					elapsed := time.Since(startTime)
+					accepted := atomic.LoadInt64(&wp.stats.FilesAccepted)
+					log.Printf("📈 Progress: %d/%d repos queued | %d files | %.1f files/sec",
+						n, len(repos), accepted, float64(accepted)/elapsed.Seconds())
+				}
+			}
+		}()
+	}
+
+	skipped := 0
+feed:
+	for i, repo := range repos {
+		if progress != nil && progress.IsDone(repo.URL) {
+			skipped++
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-wp.stopping:
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	return skipped
+}
+
+// fullNameFromURL returns owner/repo from a GitHub URL
+func fullNameFromURL(repoURL string) string {
+	parts := strings.Split(strings.TrimPrefix(repoURL, "https://github.com/"), "/")
+	if len(parts) < 2 {
+		return repoURL
+	}
+	return parts[0] + "/" + strings.TrimSuffix(parts[1], ".git")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress records which repository URLs have been processed, so a run
+// that crashed or was interrupted can pick up where it left off
+type Progress struct {
+	path      string
+	mu        sync.Mutex
+	processed map[string]bool
+}
+
+// progressFile is the on-disk form of Progress
+type progressFile struct {
+	Processed []string      `json:"processed"`
+	Stats     statsSnapshot `json:"stats"`
+	SavedAt   time.Time     `json:"saved_at"`
+}
+
+// statsSnapshot is a point-in-time copy of Stats
+type statsSnapshot struct {
+	ReposProcessed  int64            `json:"repos_processed"`
+	FilesProcessed  int64            `json:"files_processed"`
+	FilesAccepted   int64            `json:"files_accepted"`
+	FilesRejected   int64            `json:"files_rejected"`
+	DuplicatesFound int64            `json:"duplicates_found"`
+	SecretsFound    int64            `json:"secrets_found"`
+	TotalSize       int64            `json:"total_size"`
+	MetadataFetched int64            `json:"metadata_fetched"`
+	MetadataFailed  int64            `json:"metadata_failed"`
+	ReposTruncated  int64            `json:"repos_truncated"`
+	Languages       map[string]int64 `json:"languages"`
+}
+
+// LoadProgress reads the progress file at path, restoring its counters
+// into stats. A missing file starts from scratch.
+func LoadProgress(path string, stats *Stats) (*Progress, error) {
+	p := &Progress{path: path, processed: make(map[string]bool)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file progressFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid progress file %s: %w", path, err)
+	}
+	for _, url := range file.Processed {
+		p.processed[url] = true
+	}
+	stats.restore(file.Stats)
+	return p, nil
+}
+
+// Done marks url as processed
+func (p *Progress) Done(url string) {
+	p.mu.Lock()
+	p.processed[url] = true
+	p.mu.Unlock()
+}
+
+// IsDone reports whether url was processed by this or an earlier run
+func (p *Progress) IsDone(url string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.processed[url]
+}
+
+// Len returns the number of processed URLs
+func (p *Progress) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.processed)
+}
+
+// Save writes the processed URLs and stats, replacing the file atomically
+// so a crash mid-write leaves the previous copy intact
+func (p *Progress) Save(stats *Stats) error {
+	p.mu.Lock()
+	file := progressFile{
+		Processed: make([]string, 0, len(p.processed)),
+		Stats:     stats.snapshot(),
+		SavedAt:   time.Now(),
+	}
+	for url := range p.processed {
+		file.Processed = append(file.Processed, url)
+	}
+	p.mu.Unlock()
+	sort.Strings(file.Processed)
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p.path), filepath.Base(p.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+func (s *Stats) snapshot() statsSnapshot {
+	s.mutex.RLock()
+	languages := make(map[string]int64, len(s.Languages))
+	for lang, count := range s.Languages {
+		languages[lang] = count
+	}
+	s.mutex.RUnlock()
+
+	return statsSnapshot{
+		ReposProcessed:  atomic.LoadInt64(&s.ReposProcessed),
+		FilesProcessed:  atomic.LoadInt64(&s.FilesProcessed),
+		FilesAccepted:   atomic.LoadInt64(&s.FilesAccepted),
+		FilesRejected:   atomic.LoadInt64(&s.FilesRejected),
+		DuplicatesFound: atomic.LoadInt64(&s.DuplicatesFound),
+		SecretsFound:    atomic.LoadInt64(&s.SecretsFound),
+		TotalSize:       atomic.LoadInt64(&s.TotalSize),
+		MetadataFetched: atomic.LoadInt64(&s.MetadataFetched),
+		MetadataFailed:  atomic.LoadInt64(&s.MetadataFailed),
+		ReposTruncated:  atomic.LoadInt64(&s.ReposTruncated),
+		Languages:       languages,
+	}
+}
+
+func (s *Stats) restore(snap statsSnapshot) {
+	atomic.StoreInt64(&s.ReposProcessed, snap.ReposProcessed)
+	atomic.StoreInt64(&s.FilesProcessed, snap.FilesProcessed)
+	atomic.StoreInt64(&s.FilesAccepted, snap.FilesAccepted)
+	atomic.StoreInt64(&s.FilesRejected, snap.FilesRejected)
+	atomic.StoreInt64(&s.DuplicatesFound, snap.DuplicatesFound)
+	atomic.StoreInt64(&s.SecretsFound, snap.SecretsFound)
+	atomic.StoreInt64(&s.TotalSize, snap.TotalSize)
+	atomic.StoreInt64(&s.MetadataFetched, snap.MetadataFetched)
+	atomic.StoreInt64(&s.MetadataFailed, snap.MetadataFailed)
+	atomic.StoreInt64(&s.ReposTruncated, snap.ReposTruncated)
+
+	s.mutex.Lock()
+	for lang, count := range snap.Languages {
+		s.Languages[lang] = count
+	}
+	s.mutex.Unlock()
+}
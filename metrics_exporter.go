@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -16,11 +19,13 @@ import (
 
 // Prometheus metrics
 var (
-	// Processing metrics
-	jobsTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
+	// Processing metrics. The totals are gauges set from the database counts
+	// on every collection, so they always equal what's in the database;
+	// adding the cumulative counts to counters would inflate them each cycle.
+	jobsTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
 			Name: "codelupe_jobs_total",
-			Help: "Total number of processing jobs",
+			Help: "Number of processing jobs by status",
 		},
 		[]string{"status"},
 	)
@@ -32,15 +37,15 @@ var (
 		},
 	)
 
-	filesProcessedTotal = prometheus.NewCounter(
-		prometheus.CounterOpts{
+	filesProcessedTotal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
 			Name: "codelupe_files_processed_total",
 			Help: "Total number of files processed",
 		},
 	)
 
-	bytesProcessedTotal = prometheus.NewCounter(
-		prometheus.CounterOpts{
+	bytesProcessedTotal = prometheus.NewGauge(
+		prometheus.GaugeOpts{
 			Name: "codelupe_bytes_processed_total",
 			Help: "Total bytes of code processed",
 		},
@@ -63,8 +68,8 @@ var (
 		},
 	)
 
-	languageFilesTotal = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
+	languageFilesTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
 			Name: "codelupe_language_files_total",
 			Help: "Total files processed by language",
 		},
@@ -80,8 +85,8 @@ var (
 		[]string{"worker_id", "worker_type"},
 	)
 
-	workerJobsCompleted = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
+	workerJobsCompleted = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
 			Name: "codelupe_worker_jobs_completed_total",
 			Help: "Total jobs completed by worker",
 		},
@@ -163,10 +168,88 @@ var (
 		},
 		[]string{"metric_type"}, // files_per_sec, jobs_per_sec, bytes_per_sec
 	)
+
+	// Derived SLO metrics, so alert rules can compare one value to a threshold
+	minutesSinceLastProcessedFile = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "codelupe_minutes_since_last_processed_file",
+			Help: "Minutes since the most recent file was processed",
+		},
+	)
+
+	jobFailureRatio = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "codelupe_job_failure_ratio",
+			Help: "Failed jobs divided by completed jobs over the last hour",
+		},
+	)
+
+	downloadBacklog = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "codelupe_download_backlog",
+			Help: "Number of repositories waiting to be downloaded",
+		},
+	)
 )
 
 type MetricsExporter struct {
 	db *sql.DB
+
+	// qualityWatermark is the highest processed_files id whose quality
+	// score has been observed, so each file is observed only once
+	qualityWatermark int64
+	// persistWatermark keeps qualityWatermark in metrics_exporter_state so
+	// a restart doesn't observe the last hour of files again
+	persistWatermark bool
+
+	thresholds alertThresholds
+
+	sloMu sync.RWMutex
+	slo   sloSnapshot
+}
+
+// alertThresholds are the limits /alerts checks the SLO metrics against
+type alertThresholds struct {
+	StallMinutes    float64
+	FailureRatio    float64
+	DownloadBacklog float64
+}
+
+// loadAlertThresholds reads the /alerts thresholds from the environment
+func loadAlertThresholds() (alertThresholds, error) {
+	var t alertThresholds
+	var err error
+	if t.StallMinutes, err = getEnvFloat("ALERT_STALL_MINUTES", 30); err != nil {
+		return t, err
+	}
+	if t.FailureRatio, err = getEnvFloat("ALERT_FAILURE_RATIO", 0.2); err != nil {
+		return t, err
+	}
+	if t.DownloadBacklog, err = getEnvFloat("ALERT_DOWNLOAD_BACKLOG", 10000); err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+func getEnvFloat(key string, defaultValue float64) (float64, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative number", key, raw)
+	}
+	return value, nil
+}
+
+// sloSnapshot holds the SLO metrics from the last collection for /alerts
+type sloSnapshot struct {
+	collectedAt          time.Time
+	hasProcessedFiles    bool
+	minutesSinceLastFile float64
+	failureRatio         float64
+	downloadBacklog      float64
 }
 
 func NewMetricsExporter(dbURL string) (*MetricsExporter, error) {
@@ -202,6 +285,9 @@ func (m *MetricsExporter) RegisterMetrics() {
 		repoFileCount,
 		errorsTotal,
 		processingRate,
+		minutesSinceLastProcessedFile,
+		jobFailureRatio,
+		downloadBacklog,
 	)
 }
 
@@ -223,8 +309,9 @@ func (m *MetricsExporter) UpdateJobMetrics() error {
 	}
 	defer rows.Close()
 
-	// Reset gauges
+	// Reset gauges so statuses with no jobs left drop out
 	jobsInProgress.Set(0)
+	jobsTotal.Reset()
 
 	for rows.Next() {
 		var status string
@@ -233,7 +320,7 @@ func (m *MetricsExporter) UpdateJobMetrics() error {
 			continue
 		}
 
-		jobsTotal.WithLabelValues(status).Add(count)
+		jobsTotal.WithLabelValues(status).Set(count)
 
 		if status == "processing" {
 			jobsInProgress.Set(count)
@@ -260,8 +347,8 @@ func (m *MetricsExporter) UpdateFileMetrics() error {
 		return err
 	}
 
-	filesProcessedTotal.Add(totalFiles)
-	bytesProcessedTotal.Add(totalBytes)
+	filesProcessedTotal.Set(totalFiles)
+	bytesProcessedTotal.Set(totalBytes)
 
 	// Language distribution
 	rows, err := m.db.Query(`
@@ -274,34 +361,93 @@ func (m *MetricsExporter) UpdateFileMetrics() error {
 	}
 	defer rows.Close()
 
+	languageFilesTotal.Reset()
 	for rows.Next() {
 		var language string
 		var count float64
 		if err := rows.Scan(&language, &count); err != nil {
 			continue
 		}
-		languageFilesTotal.WithLabelValues(language).Add(count)
+		languageFilesTotal.WithLabelValues(language).Set(count)
 	}
 
-	// Quality score distribution
+	// Quality score distribution of files added since the last collection.
+	// The hour bound keeps the first collection after a restart small.
 	qualityRows, err := m.db.Query(`
-		SELECT quality_score 
-		FROM processed_files 
-		WHERE processed_at >= NOW() - INTERVAL '1 hour'
-	`)
+		SELECT id, quality_score
+		FROM processed_files
+		WHERE id > $1 AND processed_at >= NOW() - INTERVAL '1 hour'
+		ORDER BY id
+	`, m.qualityWatermark)
 	if err != nil {
 		return err
 	}
 	defer qualityRows.Close()
 
+	watermark := m.qualityWatermark
 	for qualityRows.Next() {
+		var id int64
 		var score float64
-		if err := qualityRows.Scan(&score); err != nil {
+		if err := qualityRows.Scan(&id, &score); err != nil {
 			continue
 		}
 		qualityScoreHistogram.Observe(score)
+		watermark = id
+	}
+	if err := qualityRows.Err(); err != nil {
+		return err
+	}
+
+	if watermark != m.qualityWatermark {
+		m.qualityWatermark = watermark
+		if err := m.saveQualityWatermark(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadQualityWatermark restores the quality score watermark saved by a
+// previous run when persistence is enabled
+func (m *MetricsExporter) LoadQualityWatermark() error {
+	if !m.persistWatermark {
+		return nil
+	}
+
+	_, err := m.db.Exec(`
+		CREATE TABLE IF NOT EXISTS metrics_exporter_state (
+			name TEXT PRIMARY KEY,
+			value BIGINT NOT NULL,
+			updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create metrics_exporter_state: %w", err)
+	}
+
+	err = m.db.QueryRow(`
+		SELECT value FROM metrics_exporter_state WHERE name = 'quality_score_watermark'
+	`).Scan(&m.qualityWatermark)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to load quality score watermark: %w", err)
+	}
+	return nil
+}
+
+func (m *MetricsExporter) saveQualityWatermark() error {
+	if !m.persistWatermark {
+		return nil
 	}
 
+	_, err := m.db.Exec(`
+		INSERT INTO metrics_exporter_state (name, value, updated_at)
+		VALUES ('quality_score_watermark', $1, NOW())
+		ON CONFLICT (name) DO UPDATE SET value = EXCLUDED.value, updated_at = NOW()
+	`, m.qualityWatermark)
+	if err != nil {
+		return fmt.Errorf("failed to save quality score watermark: %w", err)
+	}
 	return nil
 }
 
@@ -311,11 +457,13 @@ func (m *MetricsExporter) UpdateWorkerMetrics() error {
 		dbQueryDuration.WithLabelValues("worker_stats").Observe(time.Since(start).Seconds())
 	}()
 
-	// Active workers
+	// Active and completed jobs per worker
 	rows, err := m.db.Query(`
-		SELECT worker_id, COUNT(*) 
-		FROM processing_jobs 
-		WHERE status = 'processing' AND worker_id IS NOT NULL
+		SELECT worker_id,
+			COUNT(*) FILTER (WHERE status = 'processing'),
+			COUNT(*) FILTER (WHERE status = 'completed')
+		FROM processing_jobs
+		WHERE worker_id IS NOT NULL
 		GROUP BY worker_id
 	`)
 	if err != nil {
@@ -325,15 +473,18 @@ func (m *MetricsExporter) UpdateWorkerMetrics() error {
 
 	// Reset worker gauges
 	workerActiveCount.Reset()
+	workerJobsCompleted.Reset()
 
 	for rows.Next() {
 		var workerID string
-		var jobCount float64
-		if err := rows.Scan(&workerID, &jobCount); err != nil {
+		var processing, completed float64
+		if err := rows.Scan(&workerID, &processing, &completed); err != nil {
 			continue
 		}
-		workerActiveCount.WithLabelValues(workerID, "processor").Set(1)
-		workerJobsCompleted.WithLabelValues(workerID).Add(jobCount)
+		if processing > 0 {
+			workerActiveCount.WithLabelValues(workerID, "processor").Set(1)
+		}
+		workerJobsCompleted.WithLabelValues(workerID).Set(completed)
 	}
 
 	return nil
@@ -414,6 +565,134 @@ func (m *MetricsExporter) UpdateProcessingRates() error {
 	return nil
 }
 
+func (m *MetricsExporter) UpdateSLOMetrics() error {
+	start := time.Now()
+	defer func() {
+		dbQueryDuration.WithLabelValues("slo_stats").Observe(time.Since(start).Seconds())
+	}()
+
+	var snapshot sloSnapshot
+
+	// Processing stall: NULL until the first file is processed
+	var minutes sql.NullFloat64
+	err := m.db.QueryRow(`
+		SELECT EXTRACT(EPOCH FROM NOW() - MAX(processed_at)) / 60
+		FROM processed_files
+	`).Scan(&minutes)
+	if err != nil {
+		return err
+	}
+	snapshot.hasProcessedFiles = minutes.Valid
+	snapshot.minutesSinceLastFile = minutes.Float64
+
+	// Failure ratio over the last hour
+	var failed, completed float64
+	err = m.db.QueryRow(`
+		SELECT
+			COUNT(*) FILTER (WHERE status = 'failed'),
+			COUNT(*) FILTER (WHERE status = 'completed')
+		FROM processing_jobs
+		WHERE updated_at >= NOW() - INTERVAL '1 hour'
+	`).Scan(&failed, &completed)
+	if err != nil {
+		return err
+	}
+	// With nothing completed, report the failures themselves so an hour of
+	// only failures still crosses the threshold
+	snapshot.failureRatio = failed / math.Max(completed, 1)
+
+	// Download backlog
+	err = m.db.QueryRow(`
+		SELECT COUNT(*) FROM repositories WHERE download_status = 'pending'
+	`).Scan(&snapshot.downloadBacklog)
+	if err != nil {
+		return err
+	}
+
+	minutesSinceLastProcessedFile.Set(snapshot.minutesSinceLastFile)
+	jobFailureRatio.Set(snapshot.failureRatio)
+	downloadBacklog.Set(snapshot.downloadBacklog)
+
+	snapshot.collectedAt = time.Now()
+	m.sloMu.Lock()
+	m.slo = snapshot
+	m.sloMu.Unlock()
+
+	return nil
+}
+
+// alertStatus is one rule in the /alerts response
+type alertStatus struct {
+	Name      string  `json:"name"`
+	State     string  `json:"state"` // firing or ok
+	Value     float64 `json:"value"`
+	Threshold float64 `json:"threshold"`
+	Summary   string  `json:"summary"`
+}
+
+// evaluateAlerts checks the last collected SLO metrics against the thresholds
+func (m *MetricsExporter) evaluateAlerts() (sloSnapshot, []alertStatus) {
+	m.sloMu.RLock()
+	slo := m.slo
+	m.sloMu.RUnlock()
+
+	check := func(name string, value, threshold float64, summary string) alertStatus {
+		state := "ok"
+		if value > threshold {
+			state = "firing"
+		}
+		return alertStatus{Name: name, State: state, Value: value, Threshold: threshold, Summary: summary}
+	}
+
+	stall := check("processing_stalled", slo.minutesSinceLastFile, m.thresholds.StallMinutes,
+		fmt.Sprintf("%.0f minutes since the last file was processed", slo.minutesSinceLastFile))
+	if !slo.hasProcessedFiles {
+		stall.Summary = "no files have been processed yet"
+	}
+
+	return slo, []alertStatus{
+		stall,
+		check("job_failure_ratio", slo.failureRatio, m.thresholds.FailureRatio,
+			fmt.Sprintf("%.2f failed jobs per completed job over the last hour", slo.failureRatio)),
+		check("download_backlog", slo.downloadBacklog, m.thresholds.DownloadBacklog,
+			fmt.Sprintf("%.0f repositories waiting to be downloaded", slo.downloadBacklog)),
+	}
+}
+
+// handleAlerts reports each alert rule as firing or ok. It responds 503
+// when any rule fires or no collection has succeeded yet, so a blackbox
+// check can page on the status code alone.
+func (m *MetricsExporter) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	slo, alerts := m.evaluateAlerts()
+
+	status := "ok"
+	if slo.collectedAt.IsZero() {
+		status, alerts = "unknown", []alertStatus{}
+	}
+	for _, alert := range alerts {
+		if alert.State == "firing" {
+			status = "firing"
+		}
+	}
+
+	response := map[string]interface{}{
+		"status":    status,
+		"alerts":    alerts,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+	}
+	if !slo.collectedAt.IsZero() {
+		response["collected_at"] = slo.collectedAt.UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("Failed to write alerts: %v", err)
+	}
+}
+
 func (m *MetricsExporter) UpdateDatabaseMetrics() error {
 	start := time.Now()
 	defer func() {
@@ -481,6 +760,11 @@ func (m *MetricsExporter) CollectAllMetrics() {
 		errorsTotal.WithLabelValues("metrics_exporter", "rate_metrics").Inc()
 	}
 
+	if err := m.UpdateSLOMetrics(); err != nil {
+		log.Printf("❌ Failed to update SLO metrics: %v", err)
+		errorsTotal.WithLabelValues("metrics_exporter", "slo_metrics").Inc()
+	}
+
 	if err := m.UpdateDatabaseMetrics(); err != nil {
 		log.Printf("❌ Failed to update database metrics: %v", err)
 		errorsTotal.WithLabelValues("metrics_exporter", "db_metrics").Inc()
@@ -530,10 +814,14 @@ func (m *MetricsExporter) StartMetricsServer(port string) {
 		}
 	})
 
+	// Alert status for checks that page without Prometheus
+	http.HandleFunc("/alerts", m.handleAlerts)
+
 	log.Printf("🌐 Metrics server starting on port %s", port)
 	log.Printf("📊 Prometheus metrics: http://localhost:%s/metrics", port)
 	log.Printf("💚 Health check: http://localhost:%s/health", port)
 	log.Printf("📈 Summary: http://localhost:%s/summary", port)
+	log.Printf("🚨 Alerts: http://localhost:%s/alerts", port)
 
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
@@ -559,6 +847,14 @@ func main() {
 	}
 	defer exporter.db.Close()
 
+	exporter.persistWatermark = os.Getenv("METRICS_PERSIST_WATERMARK") == "true"
+	if exporter.thresholds, err = loadAlertThresholds(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if err := exporter.LoadQualityWatermark(); err != nil {
+		log.Printf("⚠️  %v, starting from the last hour", err)
+	}
+
 	// Register Prometheus metrics
 	exporter.RegisterMetrics()
 
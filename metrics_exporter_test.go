@@ -2,12 +2,15 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func setupMockExporter(t *testing.T) (*MetricsExporter, sqlmock.Sqlmock) {
@@ -122,11 +125,11 @@ func TestUpdateFileMetrics(t *testing.T) {
 	mock.ExpectQuery("SELECT language, COUNT").WillReturnRows(langRows)
 
 	// Mock quality score query
-	qualityRows := sqlmock.NewRows([]string{"quality_score"}).
-		AddRow(85).
-		AddRow(90).
-		AddRow(75)
-	mock.ExpectQuery("SELECT quality_score").WillReturnRows(qualityRows)
+	qualityRows := sqlmock.NewRows([]string{"id", "quality_score"}).
+		AddRow(1, 85).
+		AddRow(2, 90).
+		AddRow(3, 75)
+	mock.ExpectQuery("SELECT id, quality_score").WillReturnRows(qualityRows)
 
 	err := exporter.UpdateFileMetrics()
 	if err != nil {
@@ -138,9 +141,9 @@ func TestUpdateWorkerMetrics(t *testing.T) {
 	exporter, mock := setupMockExporter(t)
 	defer exporter.db.Close()
 
-	rows := sqlmock.NewRows([]string{"worker_id", "count"}).
-		AddRow("worker_1", 10).
-		AddRow("worker_2", 8)
+	rows := sqlmock.NewRows([]string{"worker_id", "processing", "completed"}).
+		AddRow("worker_1", 1, 10).
+		AddRow("worker_2", 0, 8)
 
 	mock.ExpectQuery("SELECT worker_id, COUNT").WillReturnRows(rows)
 
@@ -150,6 +153,138 @@ func TestUpdateWorkerMetrics(t *testing.T) {
 	}
 }
 
+// TestCollection_GaugesMatchDatabase guards against re-adding the
+// cumulative database counts on every collection cycle
+func TestCollection_GaugesMatchDatabase(t *testing.T) {
+	exporter, mock := setupMockExporter(t)
+	defer exporter.db.Close()
+
+	for cycle := 0; cycle < 2; cycle++ {
+		mock.ExpectQuery(`SELECT status, COUNT`).
+			WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).
+				AddRow("completed", 50).
+				AddRow("processing", 5))
+		mock.ExpectQuery(`SELECT COUNT\(\*\), COALESCE\(SUM\(size\), 0\)\s+FROM processed_files\s*$`).
+			WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).AddRow(1000, 5000000))
+		mock.ExpectQuery(`SELECT language, COUNT`).
+			WillReturnRows(sqlmock.NewRows([]string{"language", "count"}).AddRow("Go", 400))
+		mock.ExpectQuery(`SELECT id, quality_score`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "quality_score"}))
+		mock.ExpectQuery(`SELECT worker_id`).
+			WillReturnRows(sqlmock.NewRows([]string{"worker_id", "processing", "completed"}).
+				AddRow("worker_1", 1, 10))
+
+		if err := exporter.UpdateJobMetrics(); err != nil {
+			t.Fatalf("cycle %d: UpdateJobMetrics() error = %v", cycle, err)
+		}
+		if err := exporter.UpdateFileMetrics(); err != nil {
+			t.Fatalf("cycle %d: UpdateFileMetrics() error = %v", cycle, err)
+		}
+		if err := exporter.UpdateWorkerMetrics(); err != nil {
+			t.Fatalf("cycle %d: UpdateWorkerMetrics() error = %v", cycle, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		collector prometheus.Collector
+		want      float64
+	}{
+		{"jobs completed", jobsTotal.WithLabelValues("completed"), 50},
+		{"jobs in progress", jobsInProgress, 5},
+		{"files processed", filesProcessedTotal, 1000},
+		{"bytes processed", bytesProcessedTotal, 5000000},
+		{"Go files", languageFilesTotal.WithLabelValues("Go"), 400},
+		{"worker jobs completed", workerJobsCompleted.WithLabelValues("worker_1"), 10},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(tt.collector); got != tt.want {
+			t.Errorf("%s = %v after two collections, want the database count %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func qualitySampleCount(t *testing.T) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := qualityScoreHistogram.Write(&m); err != nil {
+		t.Fatalf("Failed to read quality histogram: %v", err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+// expectFileTotals mocks the file count and language queries that come
+// before the quality score query
+func expectFileTotals(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT COUNT\(\*\), COALESCE`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "sum"}).AddRow(3, 300))
+	mock.ExpectQuery(`SELECT language, COUNT`).
+		WillReturnRows(sqlmock.NewRows([]string{"language", "count"}).AddRow("Go", 3))
+}
+
+func TestUpdateFileMetrics_QualityWatermark(t *testing.T) {
+	exporter, mock := setupMockExporter(t)
+	defer exporter.db.Close()
+	before := qualitySampleCount(t)
+
+	expectFileTotals(mock)
+	mock.ExpectQuery(`SELECT id, quality_score`).WithArgs(int64(0)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quality_score"}).
+			AddRow(7, 80).
+			AddRow(9, 60))
+	expectFileTotals(mock)
+	mock.ExpectQuery(`SELECT id, quality_score`).WithArgs(int64(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quality_score"}).AddRow(12, 40))
+	expectFileTotals(mock)
+	mock.ExpectQuery(`SELECT id, quality_score`).WithArgs(int64(12)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quality_score"}))
+
+	for cycle := 0; cycle < 3; cycle++ {
+		if err := exporter.UpdateFileMetrics(); err != nil {
+			t.Fatalf("cycle %d: UpdateFileMetrics() error = %v", cycle, err)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	if got := qualitySampleCount(t) - before; got != 3 {
+		t.Errorf("observed %d quality scores, want each of the 3 files once", got)
+	}
+	if exporter.qualityWatermark != 12 {
+		t.Errorf("qualityWatermark = %d, want 12", exporter.qualityWatermark)
+	}
+}
+
+func TestQualityWatermark_Persisted(t *testing.T) {
+	exporter, mock := setupMockExporter(t)
+	defer exporter.db.Close()
+	exporter.persistWatermark = true
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS metrics_exporter_state`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT value FROM metrics_exporter_state`).
+		WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(41))
+	expectFileTotals(mock)
+	mock.ExpectQuery(`SELECT id, quality_score`).WithArgs(int64(41)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "quality_score"}).AddRow(42, 70))
+	mock.ExpectExec(`INSERT INTO metrics_exporter_state`).WithArgs(int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := exporter.LoadQualityWatermark(); err != nil {
+		t.Fatalf("LoadQualityWatermark() error = %v", err)
+	}
+	if err := exporter.UpdateFileMetrics(); err != nil {
+		t.Fatalf("UpdateFileMetrics() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
 func TestUpdateRepositoryMetrics(t *testing.T) {
 	exporter, mock := setupMockExporter(t)
 	defer exporter.db.Close()
@@ -233,12 +368,12 @@ func TestCollectAllMetrics(t *testing.T) {
 	mock.ExpectQuery("SELECT language, COUNT").WillReturnRows(langRows)
 
 	// File metrics - quality
-	qualityRows := sqlmock.NewRows([]string{"quality_score"}).AddRow(85)
-	mock.ExpectQuery("SELECT quality_score").WillReturnRows(qualityRows)
+	qualityRows := sqlmock.NewRows([]string{"id", "quality_score"}).AddRow(1, 85)
+	mock.ExpectQuery("SELECT id, quality_score").WillReturnRows(qualityRows)
 
 	// Worker metrics
-	workerRows := sqlmock.NewRows([]string{"worker_id", "count"}).
-		AddRow("worker_1", 10)
+	workerRows := sqlmock.NewRows([]string{"worker_id", "processing", "completed"}).
+		AddRow("worker_1", 1, 10)
 	mock.ExpectQuery("SELECT worker_id, COUNT").WillReturnRows(workerRows)
 
 	// Repository metrics
@@ -263,6 +398,127 @@ func TestCollectAllMetrics(t *testing.T) {
 	exporter.CollectAllMetrics()
 }
 
+// expectSLOQueries mocks the UpdateSLOMetrics queries; a nil minutes means
+// no files have been processed
+func expectSLOQueries(mock sqlmock.Sqlmock, minutes interface{}, failed, completed, pending int) {
+	mock.ExpectQuery(`SELECT EXTRACT\(EPOCH FROM NOW\(\) - MAX\(processed_at\)\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"minutes"}).AddRow(minutes))
+	mock.ExpectQuery(`FROM processing_jobs\s+WHERE updated_at`).
+		WillReturnRows(sqlmock.NewRows([]string{"failed", "completed"}).AddRow(failed, completed))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM repositories WHERE download_status = 'pending'`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(pending))
+}
+
+func TestUpdateSLOMetrics(t *testing.T) {
+	tests := []struct {
+		name        string
+		minutes     interface{}
+		failed      int
+		completed   int
+		pending     int
+		wantMinutes float64
+		wantRatio   float64
+	}{
+		{"healthy", 2.5, 1, 20, 300, 2.5, 0.05},
+		{"only failures", 45.0, 4, 0, 0, 45, 4},
+		{"nothing processed yet", nil, 0, 0, 12, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter, mock := setupMockExporter(t)
+			defer exporter.db.Close()
+			expectSLOQueries(mock, tt.minutes, tt.failed, tt.completed, tt.pending)
+
+			if err := exporter.UpdateSLOMetrics(); err != nil {
+				t.Fatalf("UpdateSLOMetrics() error = %v", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("Unfulfilled expectations: %v", err)
+			}
+
+			if got := testutil.ToFloat64(minutesSinceLastProcessedFile); got != tt.wantMinutes {
+				t.Errorf("minutes since last file = %v, want %v", got, tt.wantMinutes)
+			}
+			if got := testutil.ToFloat64(jobFailureRatio); got != tt.wantRatio {
+				t.Errorf("failure ratio = %v, want %v", got, tt.wantRatio)
+			}
+			if got := testutil.ToFloat64(downloadBacklog); got != float64(tt.pending) {
+				t.Errorf("download backlog = %v, want %d", got, tt.pending)
+			}
+		})
+	}
+}
+
+func TestAlertsEndpoint(t *testing.T) {
+	exporter, mock := setupMockExporter(t)
+	defer exporter.db.Close()
+	exporter.thresholds = alertThresholds{StallMinutes: 30, FailureRatio: 0.2, DownloadBacklog: 1000}
+
+	getAlerts := func() (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		exporter.handleAlerts(w, httptest.NewRequest("GET", "/alerts", nil))
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to decode /alerts: %v", err)
+		}
+		return w.Code, body
+	}
+
+	// Nothing collected yet
+	if code, body := getAlerts(); code != http.StatusServiceUnavailable || body["status"] != "unknown" {
+		t.Errorf("before collection: %d %v, want 503 unknown", code, body["status"])
+	}
+
+	expectSLOQueries(mock, 5.0, 1, 20, 300)
+	if err := exporter.UpdateSLOMetrics(); err != nil {
+		t.Fatalf("UpdateSLOMetrics() error = %v", err)
+	}
+	if code, body := getAlerts(); code != http.StatusOK || body["status"] != "ok" {
+		t.Errorf("healthy: %d %v, want 200 ok", code, body)
+	}
+
+	expectSLOQueries(mock, 90.0, 1, 20, 5000)
+	if err := exporter.UpdateSLOMetrics(); err != nil {
+		t.Fatalf("UpdateSLOMetrics() error = %v", err)
+	}
+	code, body := getAlerts()
+	if code != http.StatusServiceUnavailable || body["status"] != "firing" {
+		t.Errorf("stalled: %d %v, want 503 firing", code, body["status"])
+	}
+	states := map[string]interface{}{}
+	for _, alert := range body["alerts"].([]interface{}) {
+		alert := alert.(map[string]interface{})
+		states[alert["name"].(string)] = alert["state"]
+	}
+	want := map[string]string{"processing_stalled": "firing", "job_failure_ratio": "ok", "download_backlog": "firing"}
+	for name, state := range want {
+		if states[name] != state {
+			t.Errorf("%s = %v, want %s", name, states[name], state)
+		}
+	}
+}
+
+func TestLoadAlertThresholds(t *testing.T) {
+	got, err := loadAlertThresholds()
+	if err != nil {
+		t.Fatalf("loadAlertThresholds() error = %v", err)
+	}
+	if got != (alertThresholds{StallMinutes: 30, FailureRatio: 0.2, DownloadBacklog: 10000}) {
+		t.Errorf("defaults = %+v", got)
+	}
+
+	t.Setenv("ALERT_FAILURE_RATIO", "0.5")
+	if got, _ := loadAlertThresholds(); got.FailureRatio != 0.5 {
+		t.Errorf("FailureRatio = %v, want 0.5 from ALERT_FAILURE_RATIO", got.FailureRatio)
+	}
+
+	t.Setenv("ALERT_STALL_MINUTES", "soon")
+	if _, err := loadAlertThresholds(); err == nil {
+		t.Error("loadAlertThresholds() should reject an invalid ALERT_STALL_MINUTES")
+	}
+}
+
 func TestHealthEndpoint(t *testing.T) {
 	exporter, _ := setupMockExporter(t)
 	defer exporter.db.Close()
@@ -351,12 +607,12 @@ func BenchmarkUpdateFileMetrics(b *testing.B) {
 
 	totalRows := sqlmock.NewRows([]string{"count", "sum"}).AddRow(1000, 5000000)
 	langRows := sqlmock.NewRows([]string{"language", "count"}).AddRow("Go", 400)
-	qualityRows := sqlmock.NewRows([]string{"quality_score"}).AddRow(85)
+	qualityRows := sqlmock.NewRows([]string{"id", "quality_score"}).AddRow(1, 85)
 
 	for i := 0; i < b.N; i++ {
 		mock.ExpectQuery("SELECT COUNT, COALESCE").WillReturnRows(totalRows)
 		mock.ExpectQuery("SELECT language, COUNT").WillReturnRows(langRows)
-		mock.ExpectQuery("SELECT quality_score").WillReturnRows(qualityRows)
+		mock.ExpectQuery("SELECT id, quality_score").WillReturnRows(qualityRows)
 	}
 
 	b.ResetTimer()
@@ -0,0 +1,4 @@
+-- Rollback repository sync columns
+
+ALTER TABLE repositories DROP COLUMN IF EXISTS sync_error;
+ALTER TABLE repositories DROP COLUMN IF EXISTS last_synced_at;
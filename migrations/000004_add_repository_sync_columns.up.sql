@@ -0,0 +1,8 @@
+-- Track incremental updates of existing clones
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS last_synced_at TIMESTAMP;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS sync_error TEXT;
+
+-- Comments
+COMMENT ON COLUMN repositories.last_synced_at IS 'Last successful git fetch of the local clone';
+COMMENT ON COLUMN repositories.sync_error IS 'Error from the last failed sync; NULL once a sync succeeds';
@@ -0,0 +1,6 @@
+-- Rollback repository filter reason
+
+UPDATE repositories SET download_status = 'pending' WHERE download_status = 'filtered';
+ALTER TABLE repositories DROP COLUMN IF EXISTS filter_reason;
+
+COMMENT ON COLUMN repositories.download_status IS 'Status: pending, downloading, downloaded, failed';
@@ -0,0 +1,7 @@
+-- Record why the downloader's quality filter rejected a repository
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS filter_reason TEXT;
+
+-- Comments
+COMMENT ON COLUMN repositories.filter_reason IS 'Quality filter rejection reason when download_status is filtered';
+COMMENT ON COLUMN repositories.download_status IS 'Status: pending, downloading, downloaded, failed, filtered';
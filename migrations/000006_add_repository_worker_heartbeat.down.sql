@@ -0,0 +1,4 @@
+-- Rollback repository worker heartbeat columns
+
+ALTER TABLE repositories DROP COLUMN IF EXISTS heartbeat_at;
+ALTER TABLE repositories DROP COLUMN IF EXISTS worker_id;
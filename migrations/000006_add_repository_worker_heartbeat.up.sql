@@ -0,0 +1,8 @@
+-- Track which downloader process owns an in-progress download
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS worker_id TEXT;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
+
+-- Comments
+COMMENT ON COLUMN repositories.worker_id IS 'Downloader process (host-pid) currently cloning the repository';
+COMMENT ON COLUMN repositories.heartbeat_at IS 'Last progress heartbeat while download_status is downloading';
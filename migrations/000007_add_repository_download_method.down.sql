@@ -0,0 +1,3 @@
+-- Rollback repository download method
+
+ALTER TABLE repositories DROP COLUMN IF EXISTS download_method;
@@ -0,0 +1,6 @@
+-- Record whether a repository was git cloned or fetched as a tarball
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS download_method TEXT;
+
+-- Comments
+COMMENT ON COLUMN repositories.download_method IS 'How the repository was downloaded: clone or tarball';
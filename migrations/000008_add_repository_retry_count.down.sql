@@ -0,0 +1,4 @@
+-- Rollback repository retry count
+
+DROP INDEX IF EXISTS idx_repos_failed_retry;
+ALTER TABLE repositories DROP COLUMN IF EXISTS retry_count;
@@ -0,0 +1,8 @@
+-- Count download retries so the retry command can give up on a repository
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS retry_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_repos_failed_retry ON repositories(retry_count) WHERE download_status = 'failed';
+
+-- Comments
+COMMENT ON COLUMN repositories.retry_count IS 'Number of times a failed download has been retried';
@@ -0,0 +1,5 @@
+-- Rollback repository license columns
+
+DROP INDEX IF EXISTS idx_repos_license_key;
+ALTER TABLE repositories DROP COLUMN IF EXISTS license_key;
+ALTER TABLE repositories DROP COLUMN IF EXISTS license_name;
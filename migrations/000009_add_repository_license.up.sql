@@ -0,0 +1,10 @@
+-- Record each repository's license so the dataset can exclude unwanted ones
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS license_name VARCHAR(255);
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS license_key VARCHAR(100);
+
+CREATE INDEX IF NOT EXISTS idx_repos_license_key ON repositories(license_key);
+
+-- Comments
+COMMENT ON COLUMN repositories.license_key IS 'SPDX license id from the GitHub API or detected from LICENSE/COPYING files; NONE or NOASSERTION when absent or unrecognised';
+COMMENT ON COLUMN repositories.license_name IS 'License name as reported by the GitHub API';
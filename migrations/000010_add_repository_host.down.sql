@@ -0,0 +1,4 @@
+-- Rollback repository host column
+
+DROP INDEX IF EXISTS idx_repos_host;
+ALTER TABLE repositories DROP COLUMN IF EXISTS host;
@@ -0,0 +1,8 @@
+-- Record which code host each repository lives on (GitHub, GitLab, Bitbucket)
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS host VARCHAR(50) DEFAULT 'github';
+
+CREATE INDEX IF NOT EXISTS idx_repos_host ON repositories(host);
+
+-- Comments
+COMMENT ON COLUMN repositories.host IS 'Code host the repository is cloned from: github, gitlab, bitbucket, or the hostname for anything else';
@@ -0,0 +1,5 @@
+-- Rollback normalized hash deduplication
+
+DROP TABLE IF EXISTS dedupe_stats;
+DROP INDEX IF EXISTS idx_files_normalized_hash;
+ALTER TABLE processed_files DROP COLUMN IF EXISTS normalized_hash;
@@ -0,0 +1,17 @@
+-- Deduplicate processed files on a normalized content hash (comments and
+-- whitespace stripped) and track how many duplicates were collapsed
+
+ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS normalized_hash TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_files_normalized_hash ON processed_files(normalized_hash);
+
+CREATE TABLE IF NOT EXISTS dedupe_stats (
+    language TEXT PRIMARY KEY,
+    exact_duplicates BIGINT NOT NULL DEFAULT 0,
+    near_duplicates BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Comments
+COMMENT ON COLUMN processed_files.normalized_hash IS 'MD5 of the content with comments stripped and whitespace collapsed; NULL for rows processed before normalized hashing';
+COMMENT ON TABLE dedupe_stats IS 'Files dropped per language as exact or near-duplicates of one already kept';
@@ -0,0 +1,5 @@
+-- Rollback processing job heartbeat columns
+
+DROP INDEX IF EXISTS idx_jobs_processing_heartbeat;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS attempts;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS heartbeat_at;
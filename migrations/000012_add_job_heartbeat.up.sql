@@ -0,0 +1,10 @@
+-- Let processor workers reclaim jobs left in processing by a crashed worker
+
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS heartbeat_at TIMESTAMP;
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_jobs_processing_heartbeat ON processing_jobs(heartbeat_at) WHERE status = 'processing';
+
+-- Comments
+COMMENT ON COLUMN processing_jobs.heartbeat_at IS 'Last heartbeat from the worker processing the job';
+COMMENT ON COLUMN processing_jobs.attempts IS 'Times the job was reclaimed from a worker that stopped heartbeating';
@@ -0,0 +1,6 @@
+-- Rollback content storage columns. Rows stored compressed or externally
+-- have no inline content and must be exported or rewritten first.
+
+ALTER TABLE processed_files DROP COLUMN IF EXISTS content_path;
+ALTER TABLE processed_files DROP COLUMN IF EXISTS content_zstd;
+ALTER TABLE processed_files ALTER COLUMN content SET NOT NULL;
@@ -0,0 +1,11 @@
+-- Allow processed file content to be stored zstd-compressed or outside the
+-- database (CONTENT_STORAGE=inline|compressed|external)
+
+ALTER TABLE processed_files ALTER COLUMN content DROP NOT NULL;
+ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS content_zstd BYTEA;
+ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS content_path TEXT;
+
+-- Comments
+COMMENT ON COLUMN processed_files.content IS 'File content when stored inline; NULL in compressed and external modes';
+COMMENT ON COLUMN processed_files.content_zstd IS 'zstd-compressed file content when CONTENT_STORAGE=compressed';
+COMMENT ON COLUMN processed_files.content_path IS 'Path of the zstd-compressed content file under CONTENT_STORAGE_DIR when CONTENT_STORAGE=external';
@@ -0,0 +1,5 @@
+-- Rollback keyset pagination indexes
+
+DROP INDEX IF EXISTS idx_repos_created_at_id;
+DROP INDEX IF EXISTS idx_repos_quality_id;
+DROP INDEX IF EXISTS idx_repos_stars_id;
@@ -0,0 +1,6 @@
+-- Composite indexes backing keyset pagination of /api/v1/repositories.
+-- Each sort order pages on (column, id), so the index must include id.
+
+CREATE INDEX IF NOT EXISTS idx_repos_stars_id ON repositories(stars DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_repos_quality_id ON repositories(quality_score DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_repos_created_at_id ON repositories(created_at DESC, id DESC);
@@ -0,0 +1,3 @@
+-- Rollback case-insensitive full_name index
+
+DROP INDEX IF EXISTS idx_repos_full_name_lower;
@@ -0,0 +1,4 @@
+-- Case-insensitive full_name lookups, used when repositories are queued
+-- through POST /api/v1/repositories
+
+CREATE INDEX IF NOT EXISTS idx_repos_full_name_lower ON repositories(LOWER(full_name));
@@ -0,0 +1,4 @@
+-- Rollback secret detection results
+
+DROP INDEX IF EXISTS idx_files_secrets_found;
+ALTER TABLE processed_files DROP COLUMN IF EXISTS secrets_found;
@@ -0,0 +1,8 @@
+-- Record which secret detectors (pkg/scrub) fired on each processed file
+
+ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS secrets_found TEXT[];
+
+CREATE INDEX IF NOT EXISTS idx_files_secrets_found ON processed_files(id) WHERE secrets_found IS NOT NULL;
+
+-- Comments
+COMMENT ON COLUMN processed_files.secrets_found IS 'Secret detectors that fired on the file (SCRUB_MODE=mask or tag); NULL when nothing was found';
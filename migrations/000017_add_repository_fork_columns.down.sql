@@ -0,0 +1,8 @@
+-- Rollback fork and mirror tracking
+
+DROP INDEX IF EXISTS idx_repositories_local_path;
+DROP INDEX IF EXISTS idx_repositories_head_commit;
+ALTER TABLE repositories DROP COLUMN IF EXISTS mirror_of;
+ALTER TABLE repositories DROP COLUMN IF EXISTS head_commit;
+ALTER TABLE repositories DROP COLUMN IF EXISTS parent_full_name;
+ALTER TABLE repositories DROP COLUMN IF EXISTS is_fork;
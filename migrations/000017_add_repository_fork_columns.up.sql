@@ -0,0 +1,16 @@
+-- Record forks and mirrors so the downloader and export can skip
+-- near-identical copies of the same project
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS is_fork BOOLEAN DEFAULT FALSE;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS parent_full_name VARCHAR(255);
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS head_commit VARCHAR(64);
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS mirror_of VARCHAR(255);
+
+CREATE INDEX IF NOT EXISTS idx_repositories_head_commit ON repositories(head_commit) WHERE head_commit IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_repositories_local_path ON repositories(local_path);
+
+-- Comments
+COMMENT ON COLUMN repositories.is_fork IS 'Whether the host reports the repository as a fork';
+COMMENT ON COLUMN repositories.parent_full_name IS 'Repository the fork was made from, when known';
+COMMENT ON COLUMN repositories.head_commit IS 'Default branch HEAD commit when the repository was cloned';
+COMMENT ON COLUMN repositories.mirror_of IS 'Older repository with the same name and HEAD commit; mirrors are left out of exports';
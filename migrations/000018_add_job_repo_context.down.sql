@@ -0,0 +1,5 @@
+-- Rollback repo context on processing jobs
+
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS manifest_excerpt;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS manifest_kind;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS repo_readme_excerpt;
@@ -0,0 +1,11 @@
+-- Store each repo's README and manifest excerpts once per processing job,
+-- for the export's --include-context
+
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS repo_readme_excerpt TEXT;
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS manifest_kind TEXT;
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS manifest_excerpt TEXT;
+
+-- Comments
+COMMENT ON COLUMN processing_jobs.repo_readme_excerpt IS 'Start of the repository README without badges, capped at 1 KB';
+COMMENT ON COLUMN processing_jobs.manifest_kind IS 'Dependency manifest file name, e.g. go.mod or package.json';
+COMMENT ON COLUMN processing_jobs.manifest_excerpt IS 'Start of the dependency manifest, capped at 1 KB';
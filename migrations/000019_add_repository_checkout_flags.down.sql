@@ -0,0 +1,4 @@
+-- Rollback submodule and LFS flags
+
+ALTER TABLE repositories DROP COLUMN IF EXISTS has_lfs;
+ALTER TABLE repositories DROP COLUMN IF EXISTS has_submodules;
@@ -0,0 +1,9 @@
+-- Record submodules and Git LFS use so near-empty checkouts can be told
+-- apart from repos whose code lives in submodules or LFS objects
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS has_submodules BOOLEAN DEFAULT FALSE;
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS has_lfs BOOLEAN DEFAULT FALSE;
+
+-- Comments
+COMMENT ON COLUMN repositories.has_submodules IS 'Whether the checkout has a .gitmodules file';
+COMMENT ON COLUMN repositories.has_lfs IS 'Whether .gitattributes tracks files with Git LFS; with LFS_SKIP_SMUDGE they are pointer files';
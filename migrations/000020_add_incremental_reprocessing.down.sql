@@ -0,0 +1,6 @@
+-- Rollback incremental reprocessing columns
+
+DROP INDEX IF EXISTS idx_files_removed;
+ALTER TABLE processed_files DROP COLUMN IF EXISTS removed_at;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS reprocess;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS head_commit;
@@ -0,0 +1,13 @@
+-- Reprocess repos that changed on disk since their job completed, keeping
+-- files that disappeared as removed until prune-removed deletes them
+
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS head_commit TEXT;
+ALTER TABLE processing_jobs ADD COLUMN IF NOT EXISTS reprocess BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS removed_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_files_removed ON processed_files(removed_at) WHERE removed_at IS NOT NULL;
+
+-- Comments
+COMMENT ON COLUMN processing_jobs.head_commit IS 'HEAD commit of the checkout when the job completed, or a tree: fingerprint for directories that are not git checkouts';
+COMMENT ON COLUMN processing_jobs.reprocess IS 'Whether the job only adds new content and marks vanished files removed';
+COMMENT ON COLUMN processed_files.removed_at IS 'When the file was found missing from its repo on reprocessing; excluded from exports by default';
@@ -0,0 +1,3 @@
+-- Rollback processed file metrics
+
+ALTER TABLE processed_files DROP COLUMN IF EXISTS metrics;
@@ -0,0 +1,6 @@
+-- Syntax tree metrics (pkg/codemetrics) for files in languages with an analyzer
+
+ALTER TABLE processed_files ADD COLUMN IF NOT EXISTS metrics JSONB;
+
+-- Comments
+COMMENT ON COLUMN processed_files.metrics IS 'Function count, average function length, cyclomatic complexity, exported identifier and comment ratios and whether the package has tests; NULL for languages without an analyzer and files that do not parse';
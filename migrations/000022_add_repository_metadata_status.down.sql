@@ -0,0 +1,4 @@
+-- Rollback checkout metadata status
+
+DROP INDEX IF EXISTS idx_repositories_metadata_incomplete;
+ALTER TABLE repositories DROP COLUMN IF EXISTS metadata_status;
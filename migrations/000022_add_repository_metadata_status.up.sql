@@ -0,0 +1,15 @@
+-- Checkout metadata (size, default branch, line counts, submodule and LFS
+-- flags) is collected after the download slot is freed, so a repo can be
+-- downloaded before its metadata is in
+
+ALTER TABLE repositories ADD COLUMN IF NOT EXISTS metadata_status VARCHAR(20);
+
+-- Downloads before this migration collected metadata inline
+UPDATE repositories SET metadata_status = 'complete'
+WHERE download_status = 'downloaded' AND metadata_status IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_repositories_metadata_incomplete ON repositories(metadata_status)
+    WHERE download_status = 'downloaded' AND metadata_status IS DISTINCT FROM 'complete';
+
+-- Comments
+COMMENT ON COLUMN repositories.metadata_status IS 'pending while checkout metadata is being collected, complete or failed after; NULL until downloaded. downloader backfill-metadata retries the rest';
@@ -0,0 +1,4 @@
+-- Rollback Elasticsearch sync watermarks
+
+DROP INDEX IF EXISTS idx_repositories_updated_at_full_name;
+DROP TABLE IF EXISTS es_sync_state;
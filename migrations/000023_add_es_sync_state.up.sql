@@ -0,0 +1,17 @@
+-- Watermarks for the downloader's sync-es command, which copies changed
+-- repositories rows into the repositories-enriched Elasticsearch index
+
+CREATE TABLE IF NOT EXISTS es_sync_state (
+    index_name TEXT PRIMARY KEY,
+    synced_through TIMESTAMP NOT NULL,
+    last_full_name TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Rows are read in (updated_at, full_name) order from the watermark on
+CREATE INDEX IF NOT EXISTS idx_repositories_updated_at_full_name ON repositories(updated_at, full_name);
+
+-- Comments
+COMMENT ON TABLE es_sync_state IS 'Position of the last repositories row indexed into each synced Elasticsearch index';
+COMMENT ON COLUMN es_sync_state.synced_through IS 'updated_at of the last row indexed; rows updated later are synced next';
+COMMENT ON COLUMN es_sync_state.last_full_name IS 'full_name of the last row indexed, breaking ties between rows with the same updated_at';
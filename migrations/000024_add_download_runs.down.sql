@@ -0,0 +1,4 @@
+-- Rollback downloader run reports
+
+DROP INDEX IF EXISTS idx_download_runs_started_at;
+DROP TABLE IF EXISTS download_runs;
@@ -0,0 +1,21 @@
+-- One row per downloader download, retry or update run, written when
+-- RECORD_DOWNLOAD_RUNS is set
+
+CREATE TABLE IF NOT EXISTS download_runs (
+    id SERIAL PRIMARY KEY,
+    command TEXT NOT NULL,
+    worker_id TEXT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    downloaded INTEGER NOT NULL DEFAULT 0,
+    failed INTEGER NOT NULL DEFAULT 0,
+    bytes_cloned BIGINT NOT NULL DEFAULT 0,
+    interrupted BOOLEAN NOT NULL DEFAULT FALSE,
+    report JSONB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_download_runs_started_at ON download_runs(started_at DESC);
+
+-- Comments
+COMMENT ON TABLE download_runs IS 'Run reports from the downloader, the same JSON it writes to the reports directory';
+COMMENT ON COLUMN download_runs.report IS 'Full run report: outcomes, top failure reasons, bytes cloned and downloads per language';
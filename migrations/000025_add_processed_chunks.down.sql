@@ -0,0 +1,4 @@
+-- Rollback processed file chunks
+
+DROP INDEX IF EXISTS idx_chunks_file;
+DROP TABLE IF EXISTS processed_chunks;
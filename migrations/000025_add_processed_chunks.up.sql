@@ -0,0 +1,22 @@
+-- Top-level declarations (functions, methods, classes, types) of processed
+-- files, written when the processor runs with --chunks
+
+CREATE TABLE IF NOT EXISTS processed_chunks (
+    id SERIAL PRIMARY KEY,
+    file_id INTEGER NOT NULL REFERENCES processed_files(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL,
+    name TEXT NOT NULL,
+    start_line INTEGER NOT NULL,
+    end_line INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    doc_comment TEXT,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_chunks_file ON processed_chunks(file_id);
+
+-- Comments
+COMMENT ON TABLE processed_chunks IS 'Function-level chunks of processed files, exported with export --granularity=chunk';
+COMMENT ON COLUMN processed_chunks.kind IS 'function, method, class or type';
+COMMENT ON COLUMN processed_chunks.start_line IS '1-based first line in the file, including decorators';
+COMMENT ON COLUMN processed_chunks.doc_comment IS 'Doc comment or docstring without comment markers';
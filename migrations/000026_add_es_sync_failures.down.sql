@@ -0,0 +1,4 @@
+-- Rollback Elasticsearch sync failures
+
+DROP INDEX IF EXISTS idx_es_sync_failures_failed_at;
+DROP TABLE IF EXISTS es_sync_failures;
@@ -0,0 +1,20 @@
+-- Repositories rows Elasticsearch rejected outright during sync-es, e.g. on
+-- a mapping error. Retrying them can't succeed, so the watermark moves past
+-- them and they are kept here instead.
+
+CREATE TABLE IF NOT EXISTS es_sync_failures (
+    index_name TEXT NOT NULL,
+    full_name TEXT NOT NULL,
+    row_updated_at TIMESTAMP NOT NULL,
+    status INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    failed_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (index_name, full_name)
+);
+
+CREATE INDEX IF NOT EXISTS idx_es_sync_failures_failed_at ON es_sync_failures(failed_at DESC);
+
+-- Comments
+COMMENT ON TABLE es_sync_failures IS 'Last permanent indexing failure of each repositories row, per synced Elasticsearch index';
+COMMENT ON COLUMN es_sync_failures.row_updated_at IS 'updated_at of the rejected row; a later repositories.updated_at means it has been sent again since';
+COMMENT ON COLUMN es_sync_failures.status IS 'HTTP status of the bulk item, a 4xx other than 429';
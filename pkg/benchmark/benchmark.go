@@ -2,26 +2,34 @@ package benchmark
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"text/tabwriter"
 	"time"
 )
 
 // Result represents a benchmark result
 type Result struct {
-	Name           string
-	Duration       time.Duration
-	Operations     int64
-	OpsPerSecond   float64
-	AvgLatency     time.Duration
-	MinLatency     time.Duration
-	MaxLatency     time.Duration
-	P50Latency     time.Duration
-	P95Latency     time.Duration
-	P99Latency     time.Duration
-	ErrorCount     int64
-	MemAllocated   uint64
-	MemAllocations uint64
+	Name           string        `json:"name"`
+	Duration       time.Duration `json:"duration_ns"`
+	Operations     int64         `json:"operations"`
+	OpsPerSecond   float64       `json:"ops_per_second"`
+	AvgLatency     time.Duration `json:"avg_latency_ns"`
+	MinLatency     time.Duration `json:"min_latency_ns"`
+	MaxLatency     time.Duration `json:"max_latency_ns"`
+	P50Latency     time.Duration `json:"p50_latency_ns"`
+	P95Latency     time.Duration `json:"p95_latency_ns"`
+	P99Latency     time.Duration `json:"p99_latency_ns"`
+	ErrorCount     int64         `json:"error_count"`
+	MemAllocated   uint64        `json:"mem_allocated_bytes"`
+	MemAllocations uint64        `json:"mem_allocations"`
 }
 
 // Benchmark represents a performance test
@@ -32,7 +40,9 @@ type Benchmark struct {
 	warmup      int
 	results     []time.Duration
 	errors      int64
-	mu          sync.Mutex
+
+	// now is the clock latencies are measured with, replaced in tests
+	now func() time.Time
 }
 
 // New creates a new benchmark
@@ -43,6 +53,7 @@ func New(name string) *Benchmark {
 		concurrency: 1,
 		warmup:      100,
 		results:     make([]time.Duration, 0),
+		now:         time.Now,
 	}
 }
 
@@ -66,201 +77,132 @@ func (b *Benchmark) WithWarmup(n int) *Benchmark {
 
 // Run executes the benchmark
 func (b *Benchmark) Run(fn func() error) *Result {
-	// Warmup phase
-	for i := 0; i < b.warmup; i++ {
-		fn()
-	}
-
-	// Reset results
-	b.results = make([]time.Duration, 0, b.iterations)
-	b.errors = 0
-
-	start := time.Now()
-
-	if b.concurrency <= 1 {
-		// Sequential execution
-		for i := 0; i < b.iterations; i++ {
-			opStart := time.Now()
-			if err := fn(); err != nil {
-				b.errors++
-			}
-			b.results = append(b.results, time.Since(opStart))
-		}
-	} else {
-		// Concurrent execution
-		b.runConcurrent(fn)
-	}
-
-	totalDuration := time.Since(start)
-
-	return b.computeResult(totalDuration)
+	return b.RunContext(context.Background(), func(context.Context) error {
+		return fn()
+	})
 }
 
-// RunContext executes the benchmark with context
+// RunContext executes the benchmark with context. When ctx is cancelled
+// the result covers the operations completed so far.
 func (b *Benchmark) RunContext(ctx context.Context, fn func(context.Context) error) *Result {
 	// Warmup phase
-	for i := 0; i < b.warmup; i++ {
+	for i := 0; i < b.warmup && ctx.Err() == nil; i++ {
 		fn(ctx)
 	}
 
-	// Reset results
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := b.now()
+
+	workers := b.runWorkers(ctx, fn)
+
+	totalDuration := b.now().Sub(start)
+	runtime.ReadMemStats(&after)
+
+	// Merge the per-worker results
 	b.results = make([]time.Duration, 0, b.iterations)
 	b.errors = 0
-
-	start := time.Now()
-
-	if b.concurrency <= 1 {
-		// Sequential execution
-		for i := 0; i < b.iterations; i++ {
-			select {
-			case <-ctx.Done():
-				return nil
-			default:
-				opStart := time.Now()
-				if err := fn(ctx); err != nil {
-					b.errors++
-				}
-				b.results = append(b.results, time.Since(opStart))
-			}
-		}
-	} else {
-		// Concurrent execution with context
-		b.runConcurrentContext(ctx, fn)
+	for _, w := range workers {
+		b.results = append(b.results, w.latencies...)
+		b.errors += w.errors
 	}
 
-	totalDuration := time.Since(start)
-
-	return b.computeResult(totalDuration)
+	result := b.computeResult(totalDuration)
+	result.MemAllocated = after.TotalAlloc - before.TotalAlloc
+	result.MemAllocations = after.Mallocs - before.Mallocs
+	return result
 }
 
-// runConcurrent executes the benchmark concurrently
-func (b *Benchmark) runConcurrent(fn func() error) {
-	var wg sync.WaitGroup
-	results := make(chan time.Duration, b.iterations)
-	errors := make(chan error, b.iterations)
-
-	iterationsPerWorker := b.iterations / b.concurrency
-	remainder := b.iterations % b.concurrency
-
-	for i := 0; i < b.concurrency; i++ {
-		wg.Add(1)
-		iterations := iterationsPerWorker
-		if i < remainder {
-			iterations++
-		}
+// workerResult holds what one worker measured. Each worker writes only its
+// own, so operations don't contend on a lock.
+type workerResult struct {
+	latencies []time.Duration
+	errors    int64
+}
 
-		go func() {
-			defer wg.Done()
-			for j := 0; j < iterations; j++ {
-				start := time.Now()
-				if err := fn(); err != nil {
-					errors <- err
-				}
-				results <- time.Since(start)
-			}
-		}()
+// runWorkers splits the iterations across the configured number of workers.
+// With a concurrency of one the work runs on the calling goroutine.
+func (b *Benchmark) runWorkers(ctx context.Context, fn func(context.Context) error) []workerResult {
+	concurrency := b.concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if b.iterations < concurrency {
+		concurrency = max(b.iterations, 1)
 	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-		close(errors)
-	}()
+	workers := make([]workerResult, concurrency)
+	iterationsPerWorker := b.iterations / concurrency
+	remainder := b.iterations % concurrency
 
-	for duration := range results {
-		b.mu.Lock()
-		b.results = append(b.results, duration)
-		b.mu.Unlock()
+	run := func(w *workerResult, iterations int) {
+		w.latencies = make([]time.Duration, 0, iterations)
+		for j := 0; j < iterations && ctx.Err() == nil; j++ {
+			opStart := b.now()
+			if err := fn(ctx); err != nil {
+				w.errors++
+			}
+			w.latencies = append(w.latencies, b.now().Sub(opStart))
+		}
 	}
 
-	for range errors {
-		b.errors++
+	if concurrency == 1 {
+		run(&workers[0], b.iterations)
+		return workers
 	}
-}
 
-// runConcurrentContext executes the benchmark concurrently with context
-func (b *Benchmark) runConcurrentContext(ctx context.Context, fn func(context.Context) error) {
 	var wg sync.WaitGroup
-	results := make(chan time.Duration, b.iterations)
-	errors := make(chan error, b.iterations)
-
-	iterationsPerWorker := b.iterations / b.concurrency
-	remainder := b.iterations % b.concurrency
-
-	for i := 0; i < b.concurrency; i++ {
-		wg.Add(1)
+	for i := range workers {
 		iterations := iterationsPerWorker
 		if i < remainder {
 			iterations++
 		}
 
-		go func() {
+		wg.Add(1)
+		go func(w *workerResult) {
 			defer wg.Done()
-			for j := 0; j < iterations; j++ {
-				select {
-				case <-ctx.Done():
-					return
-				default:
-					start := time.Now()
-					if err := fn(ctx); err != nil {
-						errors <- err
-					}
-					results <- time.Since(start)
-				}
-			}
-		}()
-	}
-
-	go func() {
-		wg.Wait()
-		close(results)
-		close(errors)
-	}()
-
-	for duration := range results {
-		b.mu.Lock()
-		b.results = append(b.results, duration)
-		b.mu.Unlock()
+			run(w, iterations)
+		}(&workers[i])
 	}
+	wg.Wait()
 
-	for range errors {
-		b.errors++
-	}
+	return workers
 }
 
 // computeResult calculates statistics from the results
 func (b *Benchmark) computeResult(totalDuration time.Duration) *Result {
 	if len(b.results) == 0 {
 		return &Result{
-			Name: b.name,
+			Name:       b.name,
+			Duration:   totalDuration,
+			ErrorCount: b.errors,
 		}
 	}
 
-	// Sort results for percentile calculation
+	// Sort results once for min, max and percentiles
 	sortedResults := make([]time.Duration, len(b.results))
 	copy(sortedResults, b.results)
 	sortDurations(sortedResults)
 
-	// Calculate statistics
 	var totalLatency time.Duration
-	minLatency := sortedResults[0]
-	maxLatency := sortedResults[len(sortedResults)-1]
-
-	for _, d := range b.results {
+	for _, d := range sortedResults {
 		totalLatency += d
 	}
 
-	avgLatency := totalLatency / time.Duration(len(b.results))
-	opsPerSecond := float64(len(b.results)) / totalDuration.Seconds()
+	avgLatency := totalLatency / time.Duration(len(sortedResults))
+	var opsPerSecond float64
+	if totalDuration > 0 {
+		opsPerSecond = float64(len(sortedResults)) / totalDuration.Seconds()
+	}
 
 	return &Result{
 		Name:         b.name,
 		Duration:     totalDuration,
-		Operations:   int64(len(b.results)),
+		Operations:   int64(len(sortedResults)),
 		OpsPerSecond: opsPerSecond,
 		AvgLatency:   avgLatency,
-		MinLatency:   minLatency,
-		MaxLatency:   maxLatency,
+		MinLatency:   sortedResults[0],
+		MaxLatency:   sortedResults[len(sortedResults)-1],
 		P50Latency:   percentile(sortedResults, 0.50),
 		P95Latency:   percentile(sortedResults, 0.95),
 		P99Latency:   percentile(sortedResults, 0.99),
@@ -270,97 +212,152 @@ func (b *Benchmark) computeResult(totalDuration time.Duration) *Result {
 
 // sortDurations sorts a slice of durations in ascending order
 func sortDurations(durations []time.Duration) {
-	// Simple insertion sort for small slices
-	for i := 1; i < len(durations); i++ {
-		j := i
-		for j > 0 && durations[j-1] > durations[j] {
-			durations[j-1], durations[j] = durations[j], durations[j-1]
-			j--
-		}
-	}
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
 }
 
-// percentile calculates the percentile value from sorted durations
+// percentile calculates the p-th percentile of sorted durations,
+// interpolating linearly between the two closest ranks
 func percentile(sorted []time.Duration, p float64) time.Duration {
 	if len(sorted) == 0 {
 		return 0
 	}
-	index := int(float64(len(sorted)-1) * p)
-	return sorted[index]
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower < 0 {
+		return sorted[0]
+	}
+	if upper >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	fraction := rank - float64(lower)
+	return sorted[lower] + time.Duration(math.Round(fraction*float64(sorted[upper]-sorted[lower])))
+}
+
+// String formats the result as a human-readable report
+func (r *Result) String() string {
+	var errorPct float64
+	if r.Operations > 0 {
+		errorPct = float64(r.ErrorCount) / float64(r.Operations) * 100
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "=== Benchmark: %s ===\n", r.Name)
+	fmt.Fprintf(&b, "Duration:       %v\n", r.Duration)
+	fmt.Fprintf(&b, "Operations:     %d\n", r.Operations)
+	fmt.Fprintf(&b, "Ops/sec:        %.2f\n", r.OpsPerSecond)
+	fmt.Fprintf(&b, "Avg Latency:    %v\n", r.AvgLatency)
+	fmt.Fprintf(&b, "Min Latency:    %v\n", r.MinLatency)
+	fmt.Fprintf(&b, "Max Latency:    %v\n", r.MaxLatency)
+	fmt.Fprintf(&b, "P50 Latency:    %v\n", r.P50Latency)
+	fmt.Fprintf(&b, "P95 Latency:    %v\n", r.P95Latency)
+	fmt.Fprintf(&b, "P99 Latency:    %v\n", r.P99Latency)
+	fmt.Fprintf(&b, "Errors:         %d (%.2f%%)\n", r.ErrorCount, errorPct)
+	fmt.Fprintf(&b, "Memory:         %d bytes in %d allocations\n", r.MemAllocated, r.MemAllocations)
+	return b.String()
+}
+
+// WriteJSON writes the result as indented JSON, with durations in
+// nanoseconds
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
 }
 
 // Print prints the benchmark result
 func (r *Result) Print() {
-	fmt.Printf("=== Benchmark: %s ===\n", r.Name)
-	fmt.Printf("Duration:       %v\n", r.Duration)
-	fmt.Printf("Operations:     %d\n", r.Operations)
-	fmt.Printf("Ops/sec:        %.2f\n", r.OpsPerSecond)
-	fmt.Printf("Avg Latency:    %v\n", r.AvgLatency)
-	fmt.Printf("Min Latency:    %v\n", r.MinLatency)
-	fmt.Printf("Max Latency:    %v\n", r.MaxLatency)
-	fmt.Printf("P50 Latency:    %v\n", r.P50Latency)
-	fmt.Printf("P95 Latency:    %v\n", r.P95Latency)
-	fmt.Printf("P99 Latency:    %v\n", r.P99Latency)
-	fmt.Printf("Errors:         %d (%.2f%%)\n", r.ErrorCount, float64(r.ErrorCount)/float64(r.Operations)*100)
-	fmt.Println()
+	fmt.Println(r.String())
 }
 
 // Suite represents a collection of benchmarks
 type Suite struct {
 	name    string
-	benches []*Benchmark
+	benches []suiteEntry
 	results []*Result
+	out     io.Writer
+}
+
+type suiteEntry struct {
+	bench *Benchmark
+	fn    func(context.Context) error
 }
 
 // NewSuite creates a new benchmark suite
 func NewSuite(name string) *Suite {
 	return &Suite{
 		name:    name,
-		benches: make([]*Benchmark, 0),
+		benches: make([]suiteEntry, 0),
 		results: make([]*Result, 0),
+		out:     os.Stdout,
 	}
 }
 
-// Add adds a benchmark to the suite
-func (s *Suite) Add(bench *Benchmark) {
-	s.benches = append(s.benches, bench)
+// WithOutput sets where Run reports progress, os.Stdout by default
+func (s *Suite) WithOutput(w io.Writer) *Suite {
+	s.out = w
+	return s
 }
 
-// Run executes all benchmarks in the suite
+// Add adds a benchmark running fn to the suite
+func (s *Suite) Add(bench *Benchmark, fn func() error) {
+	s.AddContext(bench, func(context.Context) error {
+		return fn()
+	})
+}
+
+// AddContext adds a benchmark running a context-aware fn to the suite
+func (s *Suite) AddContext(bench *Benchmark, fn func(context.Context) error) {
+	s.benches = append(s.benches, suiteEntry{bench: bench, fn: fn})
+}
+
+// Run executes all benchmarks in the suite in the order they were added
 func (s *Suite) Run() []*Result {
-	fmt.Printf("Running benchmark suite: %s\n", s.name)
-	fmt.Printf("%s\n", repeatString("=", 60))
+	return s.RunContext(context.Background())
+}
 
-	for _, bench := range s.benches {
-		// This would need to be modified to accept a function per benchmark
-		// For now, this is a placeholder
-		fmt.Printf("Benchmark: %s (skipped - needs function)\n", bench.name)
+// RunContext executes all benchmarks in the suite, stopping early when ctx
+// is cancelled
+func (s *Suite) RunContext(ctx context.Context) []*Result {
+	fmt.Fprintf(s.out, "Running benchmark suite: %s\n", s.name)
+	fmt.Fprintf(s.out, "%s\n", strings.Repeat("=", 60))
+
+	s.results = make([]*Result, 0, len(s.benches))
+	for _, entry := range s.benches {
+		if ctx.Err() != nil {
+			break
+		}
+		fmt.Fprintf(s.out, "Benchmark: %s\n", entry.bench.name)
+		s.results = append(s.results, entry.bench.RunContext(ctx, entry.fn))
 	}
 
 	return s.results
 }
 
-// PrintSummary prints a summary of all benchmark results
-func (s *Suite) PrintSummary() {
-	fmt.Printf("\n=== Benchmark Suite Summary: %s ===\n", s.name)
-	fmt.Printf("%s\n", repeatString("=", 60))
-
-	for _, result := range s.results {
-		fmt.Printf("%-30s | %10.2f ops/sec | %10v avg | %10v p99\n",
-			result.Name,
-			result.OpsPerSecond,
-			result.AvgLatency,
-			result.P99Latency,
+// Results returns the results of the last run
+func (s *Suite) Results() []*Result {
+	return s.results
+}
+
+// WriteTable renders the results of the last run as an aligned table
+func (s *Suite) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BENCHMARK\tOPS\tOPS/SEC\tAVG\tP50\tP95\tP99\tERRORS")
+	for _, r := range s.results {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f\t%v\t%v\t%v\t%v\t%d\n",
+			r.Name, r.Operations, r.OpsPerSecond,
+			r.AvgLatency, r.P50Latency, r.P95Latency, r.P99Latency,
+			r.ErrorCount,
 		)
 	}
-	fmt.Println()
+	return tw.Flush()
 }
 
-// repeatString repeats a string n times
-func repeatString(s string, n int) string {
-	result := ""
-	for i := 0; i < n; i++ {
-		result += s
-	}
-	return result
+// PrintSummary prints a summary of all benchmark results
+func (s *Suite) PrintSummary() {
+	fmt.Fprintf(s.out, "\n=== Benchmark Suite Summary: %s ===\n", s.name)
+	s.WriteTable(s.out)
+	fmt.Fprintln(s.out)
 }
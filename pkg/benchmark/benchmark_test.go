@@ -1,8 +1,11 @@
 package benchmark
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -56,7 +59,7 @@ func TestBenchmark_RunConcurrent(t *testing.T) {
 	var counter int64
 	result := b.Run(func() error {
 		time.Sleep(1 * time.Millisecond)
-		counter++
+		atomic.AddInt64(&counter, 1)
 		return nil
 	})
 
@@ -160,9 +163,157 @@ func TestPercentile(t *testing.T) {
 	p95 := percentile(durations, 0.95)
 	p99 := percentile(durations, 0.99)
 
-	assert.Equal(t, 5*time.Millisecond, p50)
-	assert.Equal(t, 9*time.Millisecond, p95)
-	assert.Equal(t, 9*time.Millisecond, p99)
+	// Interpolated between the closest ranks
+	assert.Equal(t, 5500*time.Microsecond, p50)
+	assert.Equal(t, 9550*time.Microsecond, p95)
+	assert.Equal(t, 9910*time.Microsecond, p99)
+}
+
+func TestPercentile_Edges(t *testing.T) {
+	assert.Equal(t, time.Duration(0), percentile(nil, 0.5))
+
+	single := []time.Duration{7 * time.Millisecond}
+	assert.Equal(t, 7*time.Millisecond, percentile(single, 0.5))
+	assert.Equal(t, 7*time.Millisecond, percentile(single, 0.99))
+
+	pair := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}
+	assert.Equal(t, 10*time.Millisecond, percentile(pair, 0))
+	assert.Equal(t, 15*time.Millisecond, percentile(pair, 0.5))
+	assert.Equal(t, 20*time.Millisecond, percentile(pair, 1))
+}
+
+// fakeClock is a clock that only moves when a workload advances it, so
+// latencies are exact
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestBenchmark_DeterministicLatencies(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	b := New("deterministic").WithIterations(100).WithWarmup(0)
+	b.now = clock.Now
+
+	// Operation i takes a distinct whole number of milliseconds from 1 to
+	// 100, visited in a scrambled order so sorting matters
+	i := 0
+	result := b.Run(func() error {
+		clock.advance(time.Duration((i*37)%100+1) * time.Millisecond)
+		i++
+		return nil
+	})
+
+	assert.Equal(t, int64(100), result.Operations)
+	assert.Equal(t, 5050*time.Millisecond, result.Duration)
+	assert.InDelta(t, 100/5.05, result.OpsPerSecond, 1e-9)
+	assert.Equal(t, 1*time.Millisecond, result.MinLatency)
+	assert.Equal(t, 100*time.Millisecond, result.MaxLatency)
+	assert.Equal(t, 50500*time.Microsecond, result.AvgLatency)
+	assert.Equal(t, 50500*time.Microsecond, result.P50Latency)
+	assert.Equal(t, 95050*time.Microsecond, result.P95Latency)
+	assert.Equal(t, 99010*time.Microsecond, result.P99Latency)
+}
+
+func TestBenchmark_WarmupNotMeasured(t *testing.T) {
+	calls := 0
+	result := New("warmup").WithIterations(20).WithWarmup(5).Run(func() error {
+		calls++
+		return nil
+	})
+
+	assert.Equal(t, 25, calls)
+	assert.Equal(t, int64(20), result.Operations)
+}
+
+func TestBenchmark_ConcurrentErrorsAndSplit(t *testing.T) {
+	var calls, failures int64
+	result := New("concurrent-errors").
+		WithIterations(1003).
+		WithConcurrency(7).
+		WithWarmup(0).
+		Run(func() error {
+			if atomic.AddInt64(&calls, 1)%4 == 0 {
+				atomic.AddInt64(&failures, 1)
+				return errors.New("simulated error")
+			}
+			return nil
+		})
+
+	assert.Equal(t, int64(1003), calls)
+	assert.Equal(t, int64(1003), result.Operations)
+	assert.Equal(t, failures, result.ErrorCount)
+	assert.LessOrEqual(t, result.MinLatency, result.P50Latency)
+	assert.LessOrEqual(t, result.P99Latency, result.MaxLatency)
+}
+
+func TestBenchmark_MoreWorkersThanIterations(t *testing.T) {
+	result := New("few").WithIterations(3).WithConcurrency(10).WithWarmup(0).Run(func() error {
+		return nil
+	})
+	assert.Equal(t, int64(3), result.Operations)
+
+	result = New("none").WithIterations(0).WithWarmup(0).Run(func() error {
+		return nil
+	})
+	assert.Equal(t, int64(0), result.Operations)
+	assert.Equal(t, "none", result.Name)
+}
+
+var allocSink [][]byte
+
+func TestBenchmark_MemoryStats(t *testing.T) {
+	allocSink = nil
+	result := New("allocating").WithIterations(50).WithWarmup(0).Run(func() error {
+		allocSink = append(allocSink, make([]byte, 64<<10))
+		return nil
+	})
+
+	assert.GreaterOrEqual(t, result.MemAllocated, uint64(50*64<<10))
+	assert.GreaterOrEqual(t, result.MemAllocations, uint64(50))
+}
+
+func TestResult_String(t *testing.T) {
+	r := &Result{
+		Name:           "report",
+		Duration:       2 * time.Second,
+		Operations:     200,
+		OpsPerSecond:   100,
+		P95Latency:     15 * time.Millisecond,
+		ErrorCount:     4,
+		MemAllocated:   2048,
+		MemAllocations: 8,
+	}
+
+	out := r.String()
+	assert.Contains(t, out, "=== Benchmark: report ===\n")
+	assert.Contains(t, out, "Ops/sec:        100.00\n")
+	assert.Contains(t, out, "P95 Latency:    15ms\n")
+	assert.Contains(t, out, "Errors:         4 (2.00%)\n")
+	assert.Contains(t, out, "Memory:         2048 bytes in 8 allocations\n")
+
+	// No division by zero without operations
+	assert.Contains(t, (&Result{Name: "empty"}).String(), "Errors:         0 (0.00%)\n")
+}
+
+func TestResult_WriteJSON(t *testing.T) {
+	r := &Result{
+		Name:         "json",
+		Duration:     time.Second,
+		Operations:   10,
+		OpsPerSecond: 10,
+		P99Latency:   250 * time.Millisecond,
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.WriteJSON(&buf))
+	assert.Contains(t, buf.String(), `"p99_latency_ns": 250000000`)
+
+	var decoded Result
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, *r, decoded)
 }
 
 // Benchmark tests (using Go's testing.B)
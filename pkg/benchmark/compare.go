@@ -0,0 +1,162 @@
+package benchmark
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// Comparison reports how a result moved against its baseline
+type Comparison struct {
+	Name     string
+	Baseline *Result
+	Current  *Result
+
+	// OpsPerSecondDeltaPct is the change in throughput; negative is slower
+	OpsPerSecondDeltaPct float64
+	// P95DeltaPct is the change in p95 latency; positive is slower
+	P95DeltaPct float64
+
+	TolerancePct float64
+	// Regressed is set when throughput dropped or p95 latency rose by more
+	// than TolerancePct
+	Regressed bool
+}
+
+// Compare reports the throughput and p95 latency deltas of current against
+// baseline. A zero baseline value can't be compared and counts as no
+// change.
+func Compare(baseline, current *Result, tolerancePct float64) Comparison {
+	c := Comparison{
+		Name:         current.Name,
+		Baseline:     baseline,
+		Current:      current,
+		TolerancePct: tolerancePct,
+	}
+	if baseline == nil {
+		return c
+	}
+
+	c.OpsPerSecondDeltaPct = deltaPct(baseline.OpsPerSecond, current.OpsPerSecond)
+	c.P95DeltaPct = deltaPct(float64(baseline.P95Latency), float64(current.P95Latency))
+	c.Regressed = c.OpsPerSecondDeltaPct < -tolerancePct || c.P95DeltaPct > tolerancePct
+	return c
+}
+
+func deltaPct(baseline, current float64) float64 {
+	if baseline == 0 {
+		return 0
+	}
+	return (current - baseline) / baseline * 100
+}
+
+// String summarizes the comparison on one line
+func (c Comparison) String() string {
+	if c.Baseline == nil {
+		return fmt.Sprintf("%s: no baseline", c.Name)
+	}
+	verdict := "ok"
+	if c.Regressed {
+		verdict = "REGRESSED"
+	}
+	return fmt.Sprintf("%s: ops/sec %+.1f%%, p95 %+.1f%% (tolerance %.1f%%): %s",
+		c.Name, c.OpsPerSecondDeltaPct, c.P95DeltaPct, c.TolerancePct, verdict)
+}
+
+// Comparisons are the comparisons of a suite run against a baseline
+type Comparisons []Comparison
+
+// Regressed reports whether any benchmark regressed
+func (cs Comparisons) Regressed() bool {
+	for _, c := range cs {
+		if c.Regressed {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteTable renders the comparisons as an aligned table
+func (cs Comparisons) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BENCHMARK\tBASE OPS/SEC\tOPS/SEC\tDELTA\tBASE P95\tP95\tDELTA\tRESULT")
+	for _, c := range cs {
+		if c.Baseline == nil {
+			fmt.Fprintf(tw, "%s\t-\t%.2f\t-\t-\t%v\t-\tnew\n", c.Name, c.Current.OpsPerSecond, c.Current.P95Latency)
+			continue
+		}
+		verdict := "ok"
+		if c.Regressed {
+			verdict = "REGRESSED"
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%.2f\t%+.1f%%\t%v\t%v\t%+.1f%%\t%s\n",
+			c.Name,
+			c.Baseline.OpsPerSecond, c.Current.OpsPerSecond, c.OpsPerSecondDeltaPct,
+			c.Baseline.P95Latency, c.Current.P95Latency, c.P95DeltaPct,
+			verdict,
+		)
+	}
+	return tw.Flush()
+}
+
+// Baseline is a saved suite run that later runs are compared against
+type Baseline struct {
+	Suite     string             `json:"suite"`
+	CreatedAt time.Time          `json:"created_at"`
+	Results   map[string]*Result `json:"results"`
+}
+
+// SaveBaseline writes the suite's latest results to path as JSON
+func (s *Suite) SaveBaseline(path string) error {
+	baseline := Baseline{
+		Suite:     s.name,
+		CreatedAt: time.Now().UTC(),
+		Results:   make(map[string]*Result, len(s.results)),
+	}
+	for _, r := range s.results {
+		baseline.Results[r.Name] = r
+	}
+
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to save baseline: %w", err)
+	}
+	return nil
+}
+
+// LoadBaseline reads a baseline written by SaveBaseline
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load baseline: %w", err)
+	}
+
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		return nil, fmt.Errorf("invalid baseline %s: %w", path, err)
+	}
+	if baseline.Results == nil {
+		baseline.Results = make(map[string]*Result)
+	}
+	return &baseline, nil
+}
+
+// Compare compares the suite's latest results with baseline. Benchmarks
+// missing from the baseline are reported without a verdict.
+func (s *Suite) Compare(baseline *Baseline, tolerancePct float64) Comparisons {
+	comparisons := make(Comparisons, 0, len(s.results))
+	for _, r := range s.results {
+		var base *Result
+		if baseline != nil {
+			base = baseline.Results[r.Name]
+		}
+		comparisons = append(comparisons, Compare(base, r, tolerancePct))
+	}
+	return comparisons
+}
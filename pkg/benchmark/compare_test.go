@@ -0,0 +1,144 @@
+package benchmark
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	baseline := &Result{Name: "index", OpsPerSecond: 1000, P95Latency: 10 * time.Millisecond}
+
+	tests := []struct {
+		name          string
+		current       *Result
+		wantOpsDelta  float64
+		wantP95Delta  float64
+		wantRegressed bool
+	}{
+		{"unchanged", &Result{OpsPerSecond: 1000, P95Latency: 10 * time.Millisecond}, 0, 0, false},
+		{"faster", &Result{OpsPerSecond: 1500, P95Latency: 5 * time.Millisecond}, 50, -50, false},
+		{"within tolerance", &Result{OpsPerSecond: 960, P95Latency: 10400 * time.Microsecond}, -4, 4, false},
+		{"throughput regression", &Result{OpsPerSecond: 900, P95Latency: 10 * time.Millisecond}, -10, 0, true},
+		{"latency regression", &Result{OpsPerSecond: 1000, P95Latency: 12 * time.Millisecond}, 0, 20, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.current.Name = "index"
+			c := Compare(baseline, tt.current, 5)
+
+			assert.InDelta(t, tt.wantOpsDelta, c.OpsPerSecondDeltaPct, 1e-9)
+			assert.InDelta(t, tt.wantP95Delta, c.P95DeltaPct, 1e-9)
+			assert.Equal(t, tt.wantRegressed, c.Regressed)
+		})
+	}
+}
+
+func TestCompare_NoBaseline(t *testing.T) {
+	c := Compare(nil, &Result{Name: "new", OpsPerSecond: 10}, 5)
+	assert.False(t, c.Regressed)
+	assert.Equal(t, "new: no baseline", c.String())
+
+	// A zero baseline can't be compared against
+	c = Compare(&Result{Name: "empty"}, &Result{Name: "empty", OpsPerSecond: 10}, 5)
+	assert.Zero(t, c.OpsPerSecondDeltaPct)
+	assert.False(t, c.Regressed)
+}
+
+func TestComparison_String(t *testing.T) {
+	c := Compare(
+		&Result{Name: "bulk", OpsPerSecond: 200, P95Latency: time.Millisecond},
+		&Result{Name: "bulk", OpsPerSecond: 100, P95Latency: time.Millisecond},
+		10,
+	)
+	assert.Equal(t, "bulk: ops/sec -50.0%, p95 +0.0% (tolerance 10.0%): REGRESSED", c.String())
+}
+
+func newTestSuite() *Suite {
+	suite := NewSuite("indexing").WithOutput(&bytes.Buffer{})
+	suite.Add(New("fast").WithIterations(20).WithWarmup(0), func() error {
+		return nil
+	})
+	suite.AddContext(New("failing").WithIterations(10).WithWarmup(0), func(context.Context) error {
+		return errors.New("boom")
+	})
+	return suite
+}
+
+func TestSuite_RunAndTable(t *testing.T) {
+	var progress bytes.Buffer
+	suite := newTestSuite().WithOutput(&progress)
+
+	results := suite.Run()
+	require.Len(t, results, 2)
+	assert.Equal(t, "fast", results[0].Name)
+	assert.Equal(t, int64(20), results[0].Operations)
+	assert.Equal(t, int64(10), results[1].ErrorCount)
+	assert.Equal(t, results, suite.Results())
+	assert.Contains(t, progress.String(), "Benchmark: failing\n")
+
+	var table bytes.Buffer
+	require.NoError(t, suite.WriteTable(&table))
+	lines := strings.Split(strings.TrimSuffix(table.String(), "\n"), "\n")
+	require.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "BENCHMARK  OPS  OPS/SEC"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "fast "), lines[1])
+	assert.True(t, strings.HasSuffix(lines[2], " 10"), lines[2])
+}
+
+func TestSuite_RunContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	results := newTestSuite().RunContext(ctx)
+	assert.Empty(t, results)
+}
+
+func TestSuite_BaselineRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "baseline.json")
+
+	suite := newTestSuite()
+	suite.Run()
+	require.NoError(t, suite.SaveBaseline(path))
+
+	baseline, err := LoadBaseline(path)
+	require.NoError(t, err)
+	assert.Equal(t, "indexing", baseline.Suite)
+	require.Len(t, baseline.Results, 2)
+	assert.Equal(t, *suite.Results()[0], *baseline.Results["fast"])
+
+	// Compared with itself nothing regresses
+	comparisons := suite.Compare(baseline, 0)
+	require.Len(t, comparisons, 2)
+	assert.False(t, comparisons.Regressed())
+
+	// Double the baseline's throughput so the current run looks slower
+	baseline.Results["fast"].OpsPerSecond *= 2
+	comparisons = suite.Compare(baseline, 5)
+	assert.True(t, comparisons.Regressed())
+
+	delete(baseline.Results, "failing")
+	var table bytes.Buffer
+	require.NoError(t, suite.Compare(baseline, 5).WriteTable(&table))
+	assert.Contains(t, table.String(), "REGRESSED")
+	assert.Regexp(t, `failing\s+-\s+\S+\s+-\s+-\s+\S+\s+-\s+new`, table.String())
+}
+
+func TestLoadBaseline_Errors(t *testing.T) {
+	_, err := LoadBaseline(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "bad.json")
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0644))
+	_, err = LoadBaseline(path)
+	assert.Error(t, err)
+}
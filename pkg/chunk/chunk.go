@@ -0,0 +1,121 @@
+// Package chunk splits source files into their top-level declarations
+// (functions, methods, classes and types) and the lines each spans, so a
+// dataset can be built from units smaller than a file, e.g. for
+// fill-in-the-middle training.
+//
+// Go is parsed with go/parser. Python, JavaScript and TypeScript are split
+// by indentation and bracket matching instead of a parser: ordinary code
+// splits correctly, but unusual formatting can move a boundary.
+package chunk
+
+import (
+	"sort"
+	"strings"
+)
+
+// Kinds of chunk
+const (
+	KindFunction = "function"
+	KindMethod   = "method"
+	KindClass    = "class"
+	KindType     = "type"
+)
+
+// Chunk is one top-level declaration of a file
+type Chunk struct {
+	Kind string
+	// Name is the declared name; Go methods are named Receiver.Method and
+	// anonymous default exports "default"
+	Name string
+	// StartLine and EndLine are 1-based and inclusive. A decorated Python
+	// or TypeScript declaration starts at its first decorator; the doc
+	// comment isn't part of the chunk.
+	StartLine int
+	EndLine   int
+	Content   string
+	// DocComment is the declaration's doc comment, or a Python docstring,
+	// without the comment markers
+	DocComment string
+}
+
+// splitters split the source of one language, keyed by the name the
+// processor's language detection gives
+var splitters = map[string]func(src []byte) ([]Chunk, error){
+	"Go":         splitGo,
+	"Python":     splitPython,
+	"JavaScript": splitJavaScript,
+	"TypeScript": splitJavaScript,
+}
+
+// Split returns the top-level declarations of src in source order. It
+// returns nil for languages Split doesn't handle, and an error for Go
+// source that doesn't parse.
+func Split(src []byte, language string) ([]Chunk, error) {
+	split, ok := splitters[language]
+	if !ok {
+		return nil, nil
+	}
+	return split(src)
+}
+
+// Languages returns the languages Split handles, sorted
+func Languages() []string {
+	names := make([]string, 0, len(splitters))
+	for name := range splitters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// splitLines splits src into lines without their line endings
+func splitLines(src []byte) []string {
+	text := strings.ReplaceAll(string(src), "\r\n", "\n")
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// newChunk builds a chunk of lines start to end, both 1-based
+func newChunk(lines []string, kind, name string, start, end int, doc string) Chunk {
+	return Chunk{
+		Kind:       kind,
+		Name:       name,
+		StartLine:  start,
+		EndLine:    end,
+		Content:    strings.Join(lines[start-1:end], "\n"),
+		DocComment: doc,
+	}
+}
+
+// isBlank reports whether line holds only whitespace
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// isIndented reports whether line starts with whitespace
+func isIndented(line string) bool {
+	return line != "" && (line[0] == ' ' || line[0] == '\t')
+}
+
+// dedent trims text and removes the indentation its lines after the first
+// share, the way docstrings and block comments are written
+func dedent(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		if isBlank(line) {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i := 1; i < len(lines); i++ {
+		if len(lines[i]) >= indent && indent > 0 {
+			lines[i] = lines[i][indent:]
+		} else {
+			lines[i] = strings.TrimSpace(lines[i])
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,197 @@
+package chunk
+
+import (
+	"strings"
+	"testing"
+)
+
+// bounds is what a test checks of a chunk
+type bounds struct {
+	kind, name string
+	start, end int
+	doc        string
+}
+
+func checkChunks(t *testing.T, language, src string, want []bounds) []Chunk {
+	t.Helper()
+	chunks, err := Split([]byte(src), language)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	if len(chunks) != len(want) {
+		for _, c := range chunks {
+			t.Logf("got %s %s %d-%d", c.Kind, c.Name, c.StartLine, c.EndLine)
+		}
+		t.Fatalf("Split() returned %d chunks, want %d", len(chunks), len(want))
+	}
+	lines := strings.Split(src, "\n")
+	for i, w := range want {
+		c := chunks[i]
+		if c.Kind != w.kind || c.Name != w.name || c.StartLine != w.start || c.EndLine != w.end || c.DocComment != w.doc {
+			t.Errorf("chunk %d = %s %q lines %d-%d doc %q; want %s %q lines %d-%d doc %q",
+				i, c.Kind, c.Name, c.StartLine, c.EndLine, c.DocComment, w.kind, w.name, w.start, w.end, w.doc)
+			continue
+		}
+		if want := strings.Join(lines[w.start-1:w.end], "\n"); c.Content != want {
+			t.Errorf("chunk %d content = %q, want lines %d-%d", i, c.Content, w.start, w.end)
+		}
+	}
+	return chunks
+}
+
+func TestSplit_Go(t *testing.T) {
+	src := `package server
+
+import "net/http"
+
+// Handler serves requests
+type Handler struct {
+	mux *http.ServeMux
+}
+
+type (
+	// ID names a thing
+	ID string
+	Set[T comparable] map[T]struct{}
+)
+
+// ServeHTTP implements http.Handler. The closure inside stays part of it.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wrap := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+		})
+	}
+	wrap(h.mux).ServeHTTP(w, r)
+}
+
+func (s Set[T]) Add(v T) { s[v] = struct{}{} }
+
+var unrelated = 1
+
+func New() *Handler {
+	return &Handler{mux: http.NewServeMux()}
+}
+`
+	checkChunks(t, "Go", src, []bounds{
+		{KindType, "Handler", 6, 8, "Handler serves requests"},
+		{KindType, "ID", 12, 12, "ID names a thing"},
+		{KindType, "Set", 13, 13, ""},
+		{KindMethod, "Handler.ServeHTTP", 17, 24, "ServeHTTP implements http.Handler. The closure inside stays part of it."},
+		{KindMethod, "Set.Add", 26, 26, ""},
+		{KindFunction, "New", 30, 32, ""},
+	})
+
+	if _, err := Split([]byte("func broken( {"), "Go"); err == nil {
+		t.Error("Split() succeeded on Go that doesn't parse")
+	}
+}
+
+func TestSplit_PythonNestedAndDecorated(t *testing.T) {
+	src := `import functools
+
+CONSTANT = 3
+
+
+@functools.lru_cache(
+    maxsize=None,
+)
+@trace
+def outer(a,
+          b):
+    """Add a and b
+    through a nested helper.
+    """
+    def inner(x):
+        return x + b
+
+    @staticmethod
+    def decorated():
+        pass
+# a comment at column zero inside the body
+    text = """
+not a statement
+"""
+    return inner(a)
+
+# Section comment, not part of either
+
+class Greeter(Base):
+    'Says hello.'
+
+    @property
+    def name(self):
+        return "world"
+
+async def fetch():
+    pass
+`
+	checkChunks(t, "Python", src, []bounds{
+		{KindFunction, "outer", 6, 25, "Add a and b\nthrough a nested helper."},
+		{KindClass, "Greeter", 29, 34, "Says hello."},
+		{KindFunction, "fetch", 36, 37, ""},
+	})
+}
+
+func TestSplit_TypeScriptNestedAndDecorated(t *testing.T) {
+	src := "import { Component } from '@angular/core';\n" +
+		"\n" +
+		"/**\n" +
+		" * Adds numbers.\n" +
+		" * Braces in strings don't count: '}'\n" +
+		" */\n" +
+		"export function add(a: number, b: number): number {\n" +
+		"  function inner() {\n" +
+		"    return \"}\";\n" +
+		"  }\n" +
+		"  const s = `template ${a} }`;\n" +
+		"  return a + b; // }\n" +
+		"}\n" +
+		"\n" +
+		"@Component({\n" +
+		"  selector: 'app-root',\n" +
+		"})\n" +
+		"export class AppComponent {\n" +
+		"  @Input() name = '';\n" +
+		"  greet() { return () => this.name; }\n" +
+		"}\n" +
+		"\n" +
+		"// Doubles x\n" +
+		"export const double = (x: number) =>\n" +
+		"  x * 2;\n" +
+		"\n" +
+		"const config = { a: 1 };\n" +
+		"\n" +
+		"export interface Shape {\n" +
+		"  area(): number;\n" +
+		"}\n" +
+		"type ID = string | number;\n" +
+		"export default function () {\n" +
+		"}\n"
+	checkChunks(t, "TypeScript", src, []bounds{
+		{KindFunction, "add", 7, 13, "Adds numbers.\nBraces in strings don't count: '}'"},
+		{KindClass, "AppComponent", 15, 21, ""},
+		{KindFunction, "double", 24, 25, "Doubles x"},
+		{KindType, "Shape", 29, 31, ""},
+		{KindType, "ID", 32, 32, ""},
+		{KindFunction, "default", 33, 34, ""},
+	})
+}
+
+// Comments that end in */ without opening a block comment are line
+// comments, or nothing
+func TestSplit_JavaScriptCommentEndingInBlockClose(t *testing.T) {
+	checkChunks(t, "JavaScript", "// globs like src/*/\nfunction walk() {\n}\n", []bounds{
+		{KindFunction, "walk", 2, 3, "globs like src/*/"},
+	})
+	checkChunks(t, "JavaScript", "run() // on src/*/\nfunction walk() {\n}\n", []bounds{
+		{KindFunction, "walk", 2, 3, ""},
+	})
+}
+
+func TestSplit_UnsupportedLanguage(t *testing.T) {
+	chunks, err := Split([]byte("fn main() {}"), "Rust")
+	if chunks != nil || err != nil {
+		t.Errorf("Split() = %v, %v; want nothing for a language without a splitter", chunks, err)
+	}
+}
@@ -0,0 +1,70 @@
+package chunk
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// splitGo returns the functions, methods and types declared at the top
+// level of a Go file. Function literals stay part of the function they're
+// in.
+func splitGo(src []byte) ([]Chunk, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(src)
+	var chunks []Chunk
+	add := func(kind, name string, from, to token.Pos, doc *ast.CommentGroup) {
+		chunks = append(chunks, newChunk(lines, kind, name,
+			fset.Position(from).Line, fset.Position(to).Line, strings.TrimSpace(doc.Text())))
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				add(KindMethod, receiverName(decl.Recv.List[0].Type)+"."+decl.Name.Name, decl.Pos(), decl.End(), decl.Doc)
+				continue
+			}
+			add(KindFunction, decl.Name.Name, decl.Pos(), decl.End(), decl.Doc)
+		case *ast.GenDecl:
+			if decl.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range decl.Specs {
+				spec := spec.(*ast.TypeSpec)
+				// A lone type keeps its "type" keyword; in a group each
+				// spec is its own chunk
+				if !decl.Lparen.IsValid() {
+					add(KindType, spec.Name.Name, decl.Pos(), decl.End(), decl.Doc)
+					continue
+				}
+				add(KindType, spec.Name.Name, spec.Pos(), spec.End(), spec.Doc)
+			}
+		}
+	}
+	return chunks, nil
+}
+
+// receiverName returns the type name of a method receiver, without the
+// pointer or type parameters
+func receiverName(expr ast.Expr) string {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return receiverName(expr.X)
+	case *ast.IndexExpr:
+		return receiverName(expr.X)
+	case *ast.IndexListExpr:
+		return receiverName(expr.X)
+	case *ast.ParenExpr:
+		return receiverName(expr.X)
+	case *ast.Ident:
+		return expr.Name
+	}
+	return ""
+}
@@ -0,0 +1,225 @@
+package chunk
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	jsDecl = regexp.MustCompile(`^(?:export\s+(?:default\s+)?)?(?:declare\s+)?(?:abstract\s+)?(?:async\s+)?` +
+		`(function\s*\*?|class|interface|enum|type|const|let|var)(?:\s+|\s*\*\s*|\b)([A-Za-z_$][\w$]*)?`)
+	// jsFuncValue matches the right-hand side of a variable holding a
+	// function or class, including arrow functions whose parameters
+	// continue on the next line
+	jsFuncValue = regexp.MustCompile(`=\s*(?:async\s+)?(?:function\b|class\b|[A-Za-z_$][\w$]*\s*=>|\(.*\)\s*(?::[^=]+)?=>|\($)`)
+)
+
+// jsScanner follows the brackets, comments and strings of JavaScript or
+// TypeScript source a line at a time. Template literal substitutions and
+// regular expression literals aren't understood.
+type jsScanner struct {
+	depth    int
+	comment  bool // inside /* */
+	template bool // inside a template literal
+}
+
+// continues reports whether the next line starts inside brackets, a
+// comment or a template literal
+func (s *jsScanner) continues() bool {
+	return s.depth > 0 || s.comment || s.template
+}
+
+// scan follows line and reports whether it opened a brace at depth zero,
+// i.e. the body of a top-level declaration
+func (s *jsScanner) scan(line string) (opened bool) {
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case s.comment:
+			if strings.HasPrefix(line[i:], "*/") {
+				s.comment = false
+				i++
+			}
+		case s.template:
+			if c == '\\' {
+				i++
+			} else if c == '`' {
+				s.template = false
+			}
+		case strings.HasPrefix(line[i:], "//"):
+			return opened
+		case strings.HasPrefix(line[i:], "/*"):
+			s.comment = true
+			i++
+		case c == '`':
+			s.template = true
+		case c == '\'' || c == '"':
+			for i++; i < len(line) && line[i] != c; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case c == '{' || c == '(' || c == '[':
+			if c == '{' && s.depth == 0 {
+				opened = true
+			}
+			s.depth++
+		case c == '}' || c == ')' || c == ']':
+			if s.depth > 0 {
+				s.depth--
+			}
+		}
+	}
+	return opened
+}
+
+// jsContinuations end a line whose statement goes on to the next one
+var jsContinuations = []string{"=", "=>", ",", "?", ":", "&&", "||", "+", "-", "|", "&", "."}
+
+// splitJavaScript returns the functions, classes, interfaces, enums and
+// type aliases declared at the top level of a JavaScript or TypeScript
+// file, along with variables holding a function or class. A declaration
+// with a body ends where its braces close, so nested functions stay inside
+// it; decorators above it are included.
+func splitJavaScript(src []byte) ([]Chunk, error) {
+	lines := splitLines(src)
+
+	var chunks []Chunk
+	var scanner jsScanner
+	var kind, name string
+	start, decorators := 0, 0
+	open, needsBody, bodyOpened := false, false, false
+
+	// ended reports whether the declaration is complete after line
+	ended := func(line string) bool {
+		if scanner.continues() {
+			return false
+		}
+		trimmed := strings.TrimSpace(line)
+		if needsBody {
+			return bodyOpened || strings.HasSuffix(trimmed, ";")
+		}
+		for _, suffix := range jsContinuations {
+			if strings.HasSuffix(trimmed, suffix) {
+				return false
+			}
+		}
+		return true
+	}
+	finish := func(last int) {
+		doc := jsDocComment(lines, start)
+		chunks = append(chunks, newChunk(lines, kind, name, start, last, doc))
+		open = false
+	}
+
+	for i, line := range lines {
+		continued := scanner.continues()
+		opened := scanner.scan(line)
+		if open {
+			bodyOpened = bodyOpened || opened
+			if ended(line) {
+				finish(i + 1)
+			}
+			continue
+		}
+		if continued || isBlank(line) || isIndented(line) {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@") {
+			if decorators == 0 {
+				decorators = i + 1
+			}
+			continue
+		}
+		m := jsDecl.FindStringSubmatch(line)
+		declStart := decorators
+		decorators = 0
+		if m == nil {
+			continue
+		}
+
+		keyword := strings.TrimRight(strings.Fields(m[1])[0], "*")
+		kind, name, needsBody = KindFunction, m[2], true
+		switch keyword {
+		case "class":
+			kind = KindClass
+		case "interface", "enum", "type":
+			if name == "" {
+				continue
+			}
+			kind, needsBody = KindType, keyword != "type"
+		case "const", "let", "var":
+			value := jsFuncValue.FindString(line)
+			if name == "" || value == "" {
+				continue
+			}
+			needsBody = false
+			if strings.Contains(value, "class") {
+				kind = KindClass
+			}
+		}
+		if name == "" {
+			name = "default"
+		}
+
+		start = i + 1
+		if declStart > 0 {
+			start = declStart
+		}
+		open, bodyOpened = true, opened
+		if ended(line) {
+			finish(i + 1)
+		}
+	}
+	if open {
+		finish(len(lines))
+	}
+	return chunks, nil
+}
+
+// jsDocComment returns the /* */ block or run of // comments ending on the
+// line above start, without the comment markers
+func jsDocComment(lines []string, start int) string {
+	above := start - 1 // 1-based
+	if above < 1 {
+		return ""
+	}
+
+	prev := strings.TrimSpace(lines[above-1])
+	switch {
+	case strings.HasSuffix(prev, "*/") && !strings.HasPrefix(prev, "//"):
+		first := above
+		for first >= 1 && !strings.Contains(lines[first-1], "/*") {
+			first--
+		}
+		if first < 1 {
+			return ""
+		}
+		text := strings.Join(lines[first-1:above], "\n")
+		// "/*/" opens and closes nothing
+		open, end := strings.Index(text, "/*")+2, strings.LastIndex(text, "*/")
+		if end < open {
+			return ""
+		}
+		text = text[open:end]
+		var out []string
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimPrefix(strings.TrimSpace(line), "*")
+			out = append(out, strings.TrimPrefix(line, " "))
+		}
+		return strings.TrimSpace(strings.Join(out, "\n"))
+	case strings.HasPrefix(prev, "//"):
+		first := above
+		for first > 1 && strings.HasPrefix(strings.TrimSpace(lines[first-2]), "//") {
+			first--
+		}
+		var out []string
+		for _, line := range lines[first-1 : above] {
+			line = strings.TrimPrefix(strings.TrimSpace(line), "//")
+			out = append(out, strings.TrimPrefix(line, " "))
+		}
+		return strings.TrimSpace(strings.Join(out, "\n"))
+	}
+	return ""
+}
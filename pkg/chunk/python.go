@@ -0,0 +1,150 @@
+package chunk
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	pyDecl = regexp.MustCompile(`^(?:async\s+)?(def|class)\s+([A-Za-z_]\w*)`)
+
+	pyTripleDouble = regexp.MustCompile(`(?s)^[rRuU]?"""(.*?)"""`)
+	pyTripleSingle = regexp.MustCompile(`(?s)^[rRuU]?'''(.*?)'''`)
+	pyOneLine      = regexp.MustCompile(`^[rRuU]?(?:"([^"\n]*)"|'([^'\n]*)')`)
+)
+
+// pyScanner follows the brackets, multi-line strings and backslash
+// continuations of Python source a line at a time, so the lines inside
+// them aren't taken for new statements
+type pyScanner struct {
+	depth     int
+	quote     string // the triple quote of an open multi-line string
+	backslash bool
+}
+
+// continues reports whether the next line belongs to the statement the
+// scanned lines started
+func (s *pyScanner) continues() bool {
+	return s.depth > 0 || s.quote != "" || s.backslash
+}
+
+func (s *pyScanner) scan(line string) {
+	s.backslash = false
+	for i := 0; i < len(line); i++ {
+		if s.quote != "" {
+			end := strings.Index(line[i:], s.quote)
+			if end < 0 {
+				return
+			}
+			i += end + len(s.quote) - 1
+			s.quote = ""
+			continue
+		}
+
+		switch c := line[i]; c {
+		case '#':
+			return
+		case '(', '[', '{':
+			s.depth++
+		case ')', ']', '}':
+			if s.depth > 0 {
+				s.depth--
+			}
+		case '\'', '"':
+			if triple := strings.Repeat(string(c), 3); strings.HasPrefix(line[i:], triple) {
+				s.quote = triple
+				i += 2
+				continue
+			}
+			for i++; i < len(line) && line[i] != c; i++ {
+				if line[i] == '\\' {
+					i++
+				}
+			}
+		case '\\':
+			s.backslash = i == len(line)-1
+		}
+	}
+}
+
+// splitPython returns the functions and classes defined at the top level
+// of a Python file. A declaration runs until the next statement at column
+// zero, so nested functions and methods stay inside it; decorators above it
+// are included.
+func splitPython(src []byte) ([]Chunk, error) {
+	lines := splitLines(src)
+
+	var chunks []Chunk
+	var scanner pyScanner
+	kind, name, start, header := "", "", 0, 0
+	decorators := 0 // line of the first decorator of the next declaration
+
+	finish := func(last int) {
+		if name == "" {
+			return
+		}
+		// Blank lines and comments at column zero before the next
+		// statement belong to neither declaration
+		for last > header && (isBlank(lines[last-1]) || strings.HasPrefix(lines[last-1], "#")) {
+			last--
+		}
+		chunks = append(chunks, newChunk(lines, kind, name, start, last, pyDocstring(lines, header, last)))
+		name = ""
+	}
+
+	for i, line := range lines {
+		continued := scanner.continues()
+		scanner.scan(line)
+		if continued || isBlank(line) || isIndented(line) || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		finish(i)
+		if strings.HasPrefix(line, "@") {
+			if decorators == 0 {
+				decorators = i + 1
+			}
+			continue
+		}
+		if m := pyDecl.FindStringSubmatch(line); m != nil {
+			kind, name, start, header = KindFunction, m[2], i+1, i+1
+			if m[1] == "class" {
+				kind = KindClass
+			}
+			if decorators > 0 {
+				start = decorators
+			}
+		}
+		decorators = 0
+	}
+	finish(len(lines))
+	return chunks, nil
+}
+
+// pyDocstring returns the docstring of the declaration whose header starts
+// on line header and whose body ends on line last
+func pyDocstring(lines []string, header, last int) string {
+	// The header can span lines, e.g. a parameter per line
+	var scanner pyScanner
+	bodyStart := header
+	for ; bodyStart <= last; bodyStart++ {
+		scanner.scan(lines[bodyStart-1])
+		if !scanner.continues() {
+			break
+		}
+	}
+	if bodyStart >= last {
+		return ""
+	}
+
+	body := strings.TrimSpace(strings.Join(lines[bodyStart:last], "\n"))
+	for _, re := range []*regexp.Regexp{pyTripleDouble, pyTripleSingle} {
+		if m := re.FindStringSubmatch(body); m != nil {
+			return dedent(m[1])
+		}
+	}
+	if m := pyOneLine.FindStringSubmatch(body); m != nil {
+		return m[1] + m[2]
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+// Package codemetrics measures the structure of source files from their
+// syntax tree rather than by matching substrings, which miscounts keywords
+// inside strings and comments. Each language has an Analyzer; files in
+// languages without one get no metrics.
+package codemetrics
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metrics are the measurements of one file. They are stored as JSON in
+// processed_files.metrics.
+type Metrics struct {
+	Functions        int     `json:"functions"`
+	AvgFunctionLines float64 `json:"avg_function_lines"`
+	// Cyclomatic complexity per function: 1 plus each branch and boolean
+	// operator
+	AvgComplexity float64 `json:"avg_complexity"`
+	MaxComplexity int     `json:"max_complexity"`
+	// ExportedRatio is the share of top-level identifiers that are exported
+	ExportedRatio float64 `json:"exported_ratio"`
+	// CommentRatio is the share of lines holding a comment
+	CommentRatio float64 `json:"comment_ratio"`
+	// HasTests is set when the file's package has tests next to it, or the
+	// file is a test itself
+	HasTests bool `json:"has_tests"`
+}
+
+// Analyzer computes Metrics for the files of one language
+type Analyzer interface {
+	// Language is the name the processor's language detection gives, e.g. "Go"
+	Language() string
+	// Analyze measures src, read from path. It may look at the files next
+	// to path, and returns an error for source it can't parse.
+	Analyze(path string, src []byte) (*Metrics, error)
+}
+
+var (
+	mu        sync.RWMutex
+	analyzers = make(map[string]Analyzer)
+)
+
+func init() {
+	Register(GoAnalyzer{})
+}
+
+// Register adds an analyzer, replacing any for the same language
+func Register(a Analyzer) {
+	mu.Lock()
+	defer mu.Unlock()
+	analyzers[a.Language()] = a
+}
+
+// For returns the analyzer for language, or nil if there is none
+func For(language string) Analyzer {
+	mu.RLock()
+	defer mu.RUnlock()
+	return analyzers[language]
+}
+
+// Languages returns the languages with an analyzer, sorted
+func Languages() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(analyzers))
+	for name := range analyzers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Weights are the quality score points each metric can earn a file
+type Weights struct {
+	Comments       int // comment ratio between 10% and 50%
+	Functions      int // at least one function
+	FunctionLength int // functions average at most 50 lines
+	Complexity     int // average complexity at most 10
+	Exported       int // exports part of its identifiers, but not all
+	Tests          int // the package has tests
+}
+
+// DefaultWeights keep the comment and function points the substring
+// heuristics gave, and add a few for the structural metrics
+var DefaultWeights = Weights{
+	Comments:       15,
+	Functions:      10,
+	FunctionLength: 5,
+	Complexity:     5,
+	Tests:          5,
+}
+
+// fields maps QUALITY_METRIC_WEIGHTS names to w's fields
+func (w *Weights) fields() map[string]*int {
+	return map[string]*int{
+		"comments":        &w.Comments,
+		"functions":       &w.Functions,
+		"function_length": &w.FunctionLength,
+		"complexity":      &w.Complexity,
+		"exported":        &w.Exported,
+		"tests":           &w.Tests,
+	}
+}
+
+// ParseWeights overrides DefaultWeights with "name=points" pairs such as
+// "tests=10,exported=5"
+func ParseWeights(s string) (Weights, error) {
+	w := DefaultWeights
+	fields := w.fields()
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		ptr := fields[strings.TrimSpace(name)]
+		if !ok || ptr == nil {
+			return Weights{}, fmt.Errorf("invalid metric weight %q: expected one of %s as name=points", part, strings.Join(weightNames(), ", "))
+		}
+		points, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || points < 0 {
+			return Weights{}, fmt.Errorf("invalid metric weight %q: points must be a non-negative integer", part)
+		}
+		*ptr = points
+	}
+	return w, nil
+}
+
+func weightNames() []string {
+	var w Weights
+	names := make([]string, 0, 6)
+	for name := range w.fields() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WeightsFromEnv reads QUALITY_METRIC_WEIGHTS
+func WeightsFromEnv() (Weights, error) {
+	return ParseWeights(os.Getenv("QUALITY_METRIC_WEIGHTS"))
+}
+
+// String describes the weights for startup logs
+func (w Weights) String() string {
+	fields := w.fields()
+	parts := make([]string, 0, len(fields))
+	for _, name := range weightNames() {
+		parts = append(parts, fmt.Sprintf("%s=%d", name, *fields[name]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// Score returns the points m earns under w
+func (w Weights) Score(m *Metrics) int {
+	score := 0
+	if m.CommentRatio > 0.1 && m.CommentRatio < 0.5 {
+		score += w.Comments
+	}
+	if m.Functions > 0 {
+		score += w.Functions
+		if m.AvgFunctionLines <= 50 {
+			score += w.FunctionLength
+		}
+		if m.AvgComplexity <= 10 {
+			score += w.Complexity
+		}
+	}
+	if m.ExportedRatio > 0 && m.ExportedRatio < 1 {
+		score += w.Exported
+	}
+	if m.HasTests {
+		score += w.Tests
+	}
+	return score
+}
+
+// round2 keeps two decimals, so stored metrics stay short and stable
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
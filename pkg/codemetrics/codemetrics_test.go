@@ -0,0 +1,104 @@
+package codemetrics
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestGoAnalyzer_Golden checks the metrics of each fixture against
+// testdata/<fixture>.golden.json. Run with -update after changing a metric
+// and review the diff.
+func TestGoAnalyzer_Golden(t *testing.T) {
+	for _, fixture := range []string{"shapes/shapes.go", "shapes/shapes_test.go", "script/main.go"} {
+		t.Run(fixture, func(t *testing.T) {
+			path := filepath.Join("testdata", fixture)
+			src, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := GoAnalyzer{}.Analyze(path, src)
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			got, _ := json.MarshalIndent(m, "", "  ")
+			got = append(got, '\n')
+
+			golden := filepath.Join("testdata", strings.TrimSuffix(fixture, ".go")+".golden.json")
+			if *update {
+				if err := os.WriteFile(golden, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("no golden file (run with -update): %v", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("metrics of %s =\n%s\nwant\n%s", fixture, got, want)
+			}
+		})
+	}
+}
+
+func TestGoAnalyzer_ParseError(t *testing.T) {
+	src := []byte("package broken\n\nfunc half( {\n")
+	if m, err := (GoAnalyzer{}).Analyze("broken.go", src); err == nil {
+		t.Errorf("Analyze() = %+v, want a parse error", m)
+	}
+}
+
+func TestFor(t *testing.T) {
+	if For("Go") == nil {
+		t.Error("no analyzer registered for Go")
+	}
+	if For("Python") != nil {
+		t.Error("For(Python) should be nil until an analyzer is registered")
+	}
+}
+
+func TestParseWeights(t *testing.T) {
+	w, err := ParseWeights(" tests=10, exported=5 ")
+	if err != nil {
+		t.Fatalf("ParseWeights() error = %v", err)
+	}
+	want := DefaultWeights
+	want.Tests, want.Exported = 10, 5
+	if w != want {
+		t.Errorf("ParseWeights() = %+v, want %+v", w, want)
+	}
+
+	if w, _ := ParseWeights(""); w != DefaultWeights {
+		t.Errorf("ParseWeights(\"\") = %+v, want the defaults", w)
+	}
+	for _, bad := range []string{"stars=5", "tests", "tests=-1", "tests=many"} {
+		if _, err := ParseWeights(bad); err == nil {
+			t.Errorf("ParseWeights(%q) error = nil", bad)
+		}
+	}
+}
+
+func TestWeightsScore(t *testing.T) {
+	w := Weights{Comments: 1, Functions: 2, FunctionLength: 4, Complexity: 8, Exported: 16, Tests: 32}
+	tests := []struct {
+		name string
+		m    Metrics
+		want int
+	}{
+		{"nothing", Metrics{}, 0},
+		{"short simple functions", Metrics{Functions: 3, AvgFunctionLines: 8, AvgComplexity: 3}, 2 + 4 + 8},
+		{"long complex functions", Metrics{Functions: 1, AvgFunctionLines: 120, AvgComplexity: 25}, 2},
+		{"commented and tested", Metrics{CommentRatio: 0.2, ExportedRatio: 0.5, HasTests: true}, 1 + 16 + 32},
+		{"all comments, all exported", Metrics{CommentRatio: 0.9, ExportedRatio: 1}, 0},
+	}
+	for _, tt := range tests {
+		if got := w.Score(&tt.m); got != tt.want {
+			t.Errorf("Score(%s) = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package codemetrics
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+)
+
+// GoAnalyzer measures Go files with go/parser
+type GoAnalyzer struct{}
+
+// Language implements Analyzer
+func (GoAnalyzer) Language() string { return "Go" }
+
+// Analyze implements Analyzer
+func (GoAnalyzer) Analyze(path string, src []byte) (*Metrics, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, path, src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metrics{}
+	var totalLines, totalComplexity, exported, identifiers int
+	countIdent := func(name *ast.Ident) {
+		if name == nil || name.Name == "_" {
+			return
+		}
+		identifiers++
+		if name.IsExported() {
+			exported++
+		}
+	}
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			countIdent(decl.Name)
+			if decl.Body == nil {
+				continue // implemented in assembly
+			}
+			m.Functions++
+			totalLines += fset.Position(decl.End()).Line - fset.Position(decl.Pos()).Line + 1
+			complexity := cyclomatic(decl.Body)
+			totalComplexity += complexity
+			m.MaxComplexity = max(m.MaxComplexity, complexity)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					countIdent(spec.Name)
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						countIdent(name)
+					}
+				}
+			}
+		}
+	}
+
+	if m.Functions > 0 {
+		m.AvgFunctionLines = round2(float64(totalLines) / float64(m.Functions))
+		m.AvgComplexity = round2(float64(totalComplexity) / float64(m.Functions))
+	}
+	if identifiers > 0 {
+		m.ExportedRatio = round2(float64(exported) / float64(identifiers))
+	}
+	if lines := fset.File(file.Pos()).LineCount(); lines > 0 {
+		m.CommentRatio = round2(float64(commentLines(fset, file)) / float64(lines))
+	}
+	m.HasTests = strings.HasSuffix(path, "_test.go") || hasTestsBeside(path, file.Name.Name)
+	return m, nil
+}
+
+// cyclomatic counts the decision points in body, including those of the
+// function literals inside it
+func cyclomatic(body *ast.BlockStmt) int {
+	complexity := 1
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			complexity++
+		case *ast.CaseClause:
+			if n.List != nil { // default doesn't branch
+				complexity++
+			}
+		case *ast.CommClause:
+			if n.Comm != nil {
+				complexity++
+			}
+		case *ast.BinaryExpr:
+			if n.Op == token.LAND || n.Op == token.LOR {
+				complexity++
+			}
+		}
+		return true
+	})
+	return complexity
+}
+
+// commentLines counts the lines holding at least part of a comment
+func commentLines(fset *token.FileSet, file *ast.File) int {
+	lines := make(map[int]bool)
+	for _, group := range file.Comments {
+		for _, c := range group.List {
+			for line := fset.Position(c.Pos()).Line; line <= fset.Position(c.End()).Line; line++ {
+				lines[line] = true
+			}
+		}
+	}
+	return len(lines)
+}
+
+// hasTestsBeside reports whether a _test.go file next to path belongs to
+// pkg, either as an internal test or as pkg_test
+func hasTestsBeside(path, pkg string) bool {
+	tests, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*_test.go"))
+	for _, test := range tests {
+		file, err := parser.ParseFile(token.NewFileSet(), test, nil, parser.PackageClauseOnly)
+		if err != nil {
+			continue
+		}
+		if name := file.Name.Name; name == pkg || name == pkg+"_test" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,9 @@
+package main
+
+import "fmt"
+
+const greeting = "func main() { if true { for {} } }"
+
+func main() {
+	fmt.Println(greeting)
+}
@@ -0,0 +1,48 @@
+// Package shapes computes areas. The words func, if and for in this
+// comment must not be counted.
+package shapes
+
+import "math"
+
+// Pi is re-exported for callers
+const Pi = math.Pi
+
+var scale = 1.0
+
+// Shape is anything with an area
+type Shape interface {
+	Area() float64
+}
+
+// Circle is a circle of radius R
+type Circle struct {
+	R float64
+}
+
+// Area implements Shape
+func (c Circle) Area() float64 {
+	return Pi * c.R * c.R * scale
+}
+
+// Classify names the size of s
+func Classify(s Shape) string {
+	a := s.Area()
+	switch {
+	case a < 0 || math.IsNaN(a):
+		return "invalid"
+	case a < 1:
+		return "small"
+	default:
+		return "large"
+	}
+}
+
+func total(shapes []Shape) float64 {
+	sum := 0.0
+	for _, s := range shapes {
+		if s != nil && s.Area() > 0 {
+			sum += s.Area()
+		}
+	}
+	return sum
+}
@@ -0,0 +1,13 @@
+package shapes_test
+
+import (
+	"testing"
+
+	"example.com/shapes"
+)
+
+func TestArea(t *testing.T) {
+	if got := (shapes.Circle{R: 1}).Area(); got != shapes.Pi {
+		t.Errorf("Area() = %v", got)
+	}
+}
@@ -0,0 +1,539 @@
+// Package config loads the settings shared by the crawler, downloader,
+// processors and API so every binary agrees on where Postgres,
+// Elasticsearch and the repositories live.
+//
+// Settings come from, in increasing precedence: built-in defaults, a YAML
+// file (the -config flag or CODELUPE_CONFIG), environment variables and
+// command-line flags. The environment variable names are the ones the
+// binaries have always read, so existing deployments keep working.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"codelupe/pkg/secrets"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the YAML config file when -config isn't given
+const FileEnv = "CODELUPE_CONFIG"
+
+// Config is the pipeline configuration
+type Config struct {
+	Database      Database      `yaml:"database"`
+	Elasticsearch Elasticsearch `yaml:"elasticsearch"`
+	GitHub        GitHub        `yaml:"github"`
+	Paths         Paths         `yaml:"paths"`
+	Concurrency   Concurrency   `yaml:"concurrency"`
+	RateLimits    RateLimits    `yaml:"rate_limits"`
+	Quality       Quality       `yaml:"quality"`
+	Files         Files         `yaml:"files"`
+}
+
+// Database holds the PostgreSQL connection settings
+type Database struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+
+	// URL, when set, is used as-is instead of the fields above
+	URL string `yaml:"url"`
+}
+
+// Elasticsearch holds the crawl index connection settings
+type Elasticsearch struct {
+	URL string `yaml:"url"`
+}
+
+// GitHub holds GitHub API credentials and the response cache
+type GitHub struct {
+	Token string `yaml:"token"`
+	// CacheDir keeps GitHub responses for conditional requests; "off"
+	// disables the cache
+	CacheDir string `yaml:"cache_dir"`
+}
+
+// Paths holds where the pipeline reads and writes files
+type Paths struct {
+	ReposDir        string `yaml:"repos_dir"`
+	CrawlCheckpoint string `yaml:"crawl_checkpoint"`
+	// DownloadReports is where the downloader writes a JSON report after
+	// each run; empty disables the reports
+	DownloadReports string `yaml:"download_reports"`
+}
+
+// Concurrency holds worker counts and batch sizes
+type Concurrency struct {
+	CrawlPages int `yaml:"crawl_pages"`
+	Downloads  int `yaml:"downloads"`
+	// MetadataWorkers measure finished downloads off the download slots
+	MetadataWorkers int `yaml:"metadata_workers"`
+	// ProcessorWorkers of 0 picks one worker per CPU, up to 16
+	ProcessorWorkers int `yaml:"processor_workers"`
+	ClaimBatchSize   int `yaml:"claim_batch_size"`
+}
+
+// RateLimits bounds how hard the pipeline pushes on GitHub
+type RateLimits struct {
+	// GitHubMaxWait is the longest the downloader sleeps for a rate limit
+	// reset before giving up on a request
+	GitHubMaxWait time.Duration `yaml:"github_max_wait"`
+	// CrawlMaxPages is how many search result pages the crawler reads per term
+	CrawlMaxPages int `yaml:"crawl_max_pages"`
+	// ReadyMaxWait is how long the crawler may be paused by rate limiting
+	// before /readyz reports it unready
+	ReadyMaxWait time.Duration `yaml:"ready_max_wait"`
+	// GitHubAPIInterval spaces the downloader's GitHub API requests; the
+	// default spends a token's 5,000 requests an hour. Below
+	// GitHubAPISlowdownBelow remaining requests they are slowed further to
+	// last until the quota resets (0 disables).
+	GitHubAPIInterval      time.Duration `yaml:"github_api_interval"`
+	GitHubAPISlowdownBelow int           `yaml:"github_api_slowdown_below"`
+	// CloneInterval spaces the start of git clones; 0 leaves them bounded
+	// only by concurrency.downloads
+	CloneInterval time.Duration `yaml:"clone_interval"`
+}
+
+// Quality holds the thresholds a repository must meet to be downloaded
+type Quality struct {
+	MinStars     int `yaml:"min_stars"`
+	MinForks     int `yaml:"min_forks"`
+	MinCodeLines int `yaml:"min_code_lines"`
+	// FilterFile is a downloader quality filter file whose fields override
+	// the thresholds above
+	FilterFile string `yaml:"filter_file"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Database: Database{
+			Host:     "localhost",
+			Port:     5432,
+			User:     "coding_user",
+			Password: "coding_pass",
+			Name:     "coding_db",
+			SSLMode:  "disable",
+		},
+		Elasticsearch: Elasticsearch{URL: "http://elasticsearch:9200"},
+		GitHub:        GitHub{CacheDir: "cache/github"},
+		Paths: Paths{
+			ReposDir:        "/app/repos",
+			CrawlCheckpoint: "logs/crawler_checkpoint.json",
+			DownloadReports: "logs/download_reports",
+		},
+		Concurrency: Concurrency{
+			CrawlPages:      2,
+			Downloads:       3,
+			MetadataWorkers: 2,
+			ClaimBatchSize:  10,
+		},
+		RateLimits: RateLimits{
+			GitHubMaxWait: 15 * time.Minute,
+			CrawlMaxPages: 5,
+			ReadyMaxWait:  10 * time.Minute,
+
+			GitHubAPIInterval:      720 * time.Millisecond,
+			GitHubAPISlowdownBelow: 500,
+		},
+		Quality: Quality{
+			MinStars:     10,
+			MinForks:     3,
+			MinCodeLines: 100,
+		},
+		Files: Files{
+			MinBytes:           100,
+			MaxBytes:           1 << 20,
+			MinLines:           5,
+			MaxLines:           2000,
+			MaxLinesByLanguage: map[string]int{"SQL": 500},
+		},
+	}
+}
+
+// setting ties one field to its YAML key, environment variables and flag
+type setting struct {
+	key    string // dotted YAML path
+	env    string
+	flag   string
+	usage  string
+	secret bool        // masked by Print, and may be given as <ENV>_FILE
+	ptr    interface{} // *string, *int, *time.Duration, *map[string]string or *map[string]int
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "database.url", env: "DATABASE_URL", secret: true, ptr: &c.Database.URL},
+		{key: "database.host", env: "POSTGRES_HOST", flag: "postgres-host", ptr: &c.Database.Host, usage: "PostgreSQL host"},
+		{key: "database.port", env: "POSTGRES_PORT", flag: "postgres-port", ptr: &c.Database.Port, usage: "PostgreSQL port"},
+		{key: "database.user", env: "POSTGRES_USER", flag: "postgres-user", ptr: &c.Database.User, usage: "PostgreSQL user"},
+		{key: "database.password", env: "POSTGRES_PASSWORD", secret: true, ptr: &c.Database.Password},
+		{key: "database.name", env: "POSTGRES_DB", flag: "postgres-db", ptr: &c.Database.Name, usage: "PostgreSQL database"},
+		{key: "database.sslmode", env: "POSTGRES_SSLMODE", flag: "postgres-sslmode", ptr: &c.Database.SSLMode, usage: "PostgreSQL sslmode"},
+
+		{key: "elasticsearch.url", env: "ELASTICSEARCH_URL", flag: "elasticsearch-url", ptr: &c.Elasticsearch.URL, usage: "Elasticsearch URL"},
+
+		{key: "github.token", env: "GITHUB_TOKEN", secret: true, ptr: &c.GitHub.Token},
+		{key: "github.cache_dir", env: "GITHUB_CACHE_DIR", flag: "github-cache-dir", ptr: &c.GitHub.CacheDir, usage: "Directory GitHub responses are cached in for conditional requests (\"off\" disables)"},
+
+		{key: "paths.repos_dir", env: "REPOS_DIR", flag: "repos-dir", ptr: &c.Paths.ReposDir, usage: "Directory repositories are downloaded to"},
+		{key: "paths.crawl_checkpoint", env: "CRAWL_CHECKPOINT_FILE", flag: "crawl-checkpoint", ptr: &c.Paths.CrawlCheckpoint, usage: "Crawler checkpoint file"},
+		{key: "paths.download_reports", env: "DOWNLOAD_REPORTS_DIR", flag: "download-reports-dir", ptr: &c.Paths.DownloadReports, usage: "Directory the downloader writes run reports to (empty disables)"},
+
+		{key: "concurrency.crawl_pages", env: "CRAWL_CONCURRENCY", flag: "crawl-concurrency", ptr: &c.Concurrency.CrawlPages, usage: "Search result pages the crawler fetches at once"},
+		{key: "concurrency.downloads", env: "MAX_CONCURRENT_DOWNLOADS", flag: "download-concurrency", ptr: &c.Concurrency.Downloads, usage: "Repositories downloaded at once"},
+		{key: "concurrency.metadata_workers", env: "METADATA_WORKERS", flag: "metadata-workers", ptr: &c.Concurrency.MetadataWorkers, usage: "Downloaded repositories measured (size, line counts) at once"},
+		{key: "concurrency.processor_workers", env: "PROCESSOR_WORKERS", flag: "processor-workers", ptr: &c.Concurrency.ProcessorWorkers, usage: "Processor workers (0 for one per CPU, up to 16)"},
+		{key: "concurrency.claim_batch_size", env: "CLAIM_BATCH_SIZE", flag: "claim-batch-size", ptr: &c.Concurrency.ClaimBatchSize, usage: "Jobs a processor claims at once"},
+
+		{key: "rate_limits.github_max_wait", env: "GITHUB_MAX_RATE_LIMIT_WAIT", flag: "github-max-wait", ptr: &c.RateLimits.GitHubMaxWait, usage: "Longest wait for a GitHub rate limit reset"},
+		{key: "rate_limits.crawl_max_pages", env: "CRAWL_MAX_PAGES", flag: "crawl-max-pages", ptr: &c.RateLimits.CrawlMaxPages, usage: "Search result pages crawled per term"},
+		{key: "rate_limits.ready_max_wait", env: "READY_MAX_RATE_LIMIT_WAIT", flag: "ready-max-wait", ptr: &c.RateLimits.ReadyMaxWait, usage: "Rate limit pause after which the crawler reports unready"},
+		{key: "rate_limits.github_api_interval", env: "GITHUB_API_INTERVAL", flag: "github-api-interval", ptr: &c.RateLimits.GitHubAPIInterval, usage: "Time between the downloader's GitHub API requests (0 for no limit)"},
+		{key: "rate_limits.github_api_slowdown_below", env: "GITHUB_API_SLOWDOWN_BELOW", flag: "github-api-slowdown-below", ptr: &c.RateLimits.GitHubAPISlowdownBelow, usage: "Remaining GitHub API quota below which requests are spread until the reset (0 disables)"},
+		{key: "rate_limits.clone_interval", env: "CLONE_INTERVAL", flag: "clone-interval", ptr: &c.RateLimits.CloneInterval, usage: "Time between starting git clones (0 for no limit beyond download concurrency)"},
+
+		{key: "quality.min_stars", env: "MIN_STARS", flag: "min-stars", ptr: &c.Quality.MinStars, usage: "Minimum stars to download a repository"},
+		{key: "quality.min_forks", env: "MIN_FORKS", flag: "min-forks", ptr: &c.Quality.MinForks, usage: "Minimum forks to download a repository"},
+		{key: "quality.min_code_lines", env: "MIN_CODE_LINES", flag: "min-code-lines", ptr: &c.Quality.MinCodeLines, usage: "Minimum lines of code to download a repository"},
+		{key: "quality.filter_file", env: "QUALITY_FILTER_CONFIG", flag: "quality-filter", ptr: &c.Quality.FilterFile, usage: "Quality filter YAML file"},
+
+		{key: "files.min_bytes", env: "FILE_MIN_BYTES", flag: "file-min-bytes", ptr: &c.Files.MinBytes, usage: "Smallest source file, in bytes, that becomes a sample"},
+		{key: "files.max_bytes", env: "FILE_MAX_BYTES", flag: "file-max-bytes", ptr: &c.Files.MaxBytes, usage: "Largest source file, in bytes, that becomes a sample"},
+		{key: "files.min_lines", env: "FILE_MIN_LINES", flag: "file-min-lines", ptr: &c.Files.MinLines, usage: "Fewest lines a sample may have"},
+		{key: "files.max_lines", env: "FILE_MAX_LINES", flag: "file-max-lines", ptr: &c.Files.MaxLines, usage: "Most lines a sample may have"},
+		{key: "files.max_lines_by_language", env: "FILE_MAX_LINES_BY_LANGUAGE", flag: "file-max-lines-by-language", ptr: &c.Files.MaxLinesByLanguage, usage: "Per-language line caps, e.g. SQL=500,JSON=300"},
+		{key: "files.extensions", env: "FILE_EXTENSIONS", flag: "file-extensions", ptr: &c.Files.Extensions, usage: "Allowed extensions and their languages, e.g. .go=Go,.rs=Rust (default: every recognized language)"},
+	}
+}
+
+// Load builds the configuration and parses args with fs. The settings'
+// flags are added to fs next to the caller's own, along with -config.
+// Secrets have no flags so they don't show up in process listings.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	c := Default()
+
+	configFile := fs.String("config", "", "YAML config file (env "+FileEnv+")")
+	flagValues := map[string]*flagValue{}
+	for _, s := range c.settings() {
+		if s.flag == "" {
+			continue
+		}
+		v := &flagValue{def: format(s.ptr)}
+		flagValues[s.flag] = v
+		fs.Var(v, s.flag, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	for _, s := range c.settings() {
+		v, ok := flagValues[s.flag]
+		if !ok || !v.set {
+			continue
+		}
+		if err := parse(s.ptr, v.value); err != nil {
+			return nil, fmt.Errorf("invalid -%s %q: %w", s.flag, v.value, err)
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// FromEnv builds the configuration from CODELUPE_CONFIG and the
+// environment, for commands that parse their own flags
+func FromEnv() (*Config, error) {
+	return Load(flag.NewFlagSet("config", flag.ContinueOnError), nil)
+}
+
+// loadFile overrides c with the settings in the YAML file at path
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides c with the settings' environment variables
+func (c *Config) loadEnv() error {
+	for _, s := range c.settings() {
+		raw := os.Getenv(s.env)
+		if s.secret && os.Getenv(s.env+"_FILE") != "" {
+			var err error
+			if raw, err = secrets.ReadSecret(s.env); err != nil {
+				return err
+			}
+		}
+		if raw == "" {
+			continue
+		}
+		if err := parse(s.ptr, raw); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", s.env, raw, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the settings are usable
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Database.URL != "" {
+		if u, err := url.Parse(c.Database.URL); err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+			errs = append(errs, errors.New("database.url must be a postgres:// URL"))
+		}
+	} else {
+		if c.Database.Host == "" {
+			errs = append(errs, errors.New("database.host is required"))
+		}
+		if c.Database.Port < 1 || c.Database.Port > 65535 {
+			errs = append(errs, fmt.Errorf("database.port %d is out of range", c.Database.Port))
+		}
+		if c.Database.Name == "" {
+			errs = append(errs, errors.New("database.name is required"))
+		}
+	}
+	if u, err := url.Parse(c.Elasticsearch.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("elasticsearch.url %q is not a URL", c.Elasticsearch.URL))
+	}
+	if c.Paths.ReposDir == "" {
+		errs = append(errs, errors.New("paths.repos_dir is required"))
+	}
+
+	for _, field := range []struct {
+		name  string
+		value int
+		min   int
+	}{
+		{"concurrency.crawl_pages", c.Concurrency.CrawlPages, 1},
+		{"concurrency.downloads", c.Concurrency.Downloads, 1},
+		{"concurrency.metadata_workers", c.Concurrency.MetadataWorkers, 1},
+		{"concurrency.processor_workers", c.Concurrency.ProcessorWorkers, 0},
+		{"concurrency.claim_batch_size", c.Concurrency.ClaimBatchSize, 1},
+		{"rate_limits.crawl_max_pages", c.RateLimits.CrawlMaxPages, 1},
+		{"rate_limits.github_api_slowdown_below", c.RateLimits.GitHubAPISlowdownBelow, 0},
+		{"quality.min_stars", c.Quality.MinStars, 0},
+		{"quality.min_forks", c.Quality.MinForks, 0},
+		{"quality.min_code_lines", c.Quality.MinCodeLines, 0},
+	} {
+		if field.value < field.min {
+			errs = append(errs, fmt.Errorf("%s must be at least %d, got %d", field.name, field.min, field.value))
+		}
+	}
+	if c.RateLimits.GitHubMaxWait < 0 {
+		errs = append(errs, errors.New("rate_limits.github_max_wait must not be negative"))
+	}
+	if c.RateLimits.ReadyMaxWait < 0 {
+		errs = append(errs, errors.New("rate_limits.ready_max_wait must not be negative"))
+	}
+	if c.RateLimits.GitHubAPIInterval < 0 {
+		errs = append(errs, errors.New("rate_limits.github_api_interval must not be negative"))
+	}
+	if c.RateLimits.CloneInterval < 0 {
+		errs = append(errs, errors.New("rate_limits.clone_interval must not be negative"))
+	}
+	errs = append(errs, c.Files.validate()...)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// DatabaseURL returns the PostgreSQL connection URL
+func (c *Config) DatabaseURL() string {
+	if c.Database.URL != "" {
+		return c.Database.URL
+	}
+	u := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(c.Database.User, c.Database.Password),
+		Host:     fmt.Sprintf("%s:%d", c.Database.Host, c.Database.Port),
+		Path:     "/" + c.Database.Name,
+		RawQuery: url.Values{"sslmode": {c.Database.SSLMode}}.Encode(),
+	}
+	return u.String()
+}
+
+// DatabaseAddress returns host:port of the database, without credentials,
+// for logging
+func (c *Config) DatabaseAddress() string {
+	if u, err := url.Parse(c.DatabaseURL()); err == nil {
+		return u.Host
+	}
+	return ""
+}
+
+// Print writes every setting to w, one "key = value" per line, with
+// secrets masked
+func (c *Config) Print(w io.Writer) {
+	for _, s := range c.settings() {
+		value := format(s.ptr)
+		if s.secret && value != "" {
+			value = mask(value)
+		}
+		fmt.Fprintf(w, "%s = %s\n", s.key, value)
+	}
+}
+
+// mask hides a secret. URLs keep everything but the password.
+func mask(value string) string {
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			return u.String()
+		}
+	}
+	return "********"
+}
+
+// flagValue records a flag's raw value so it can be applied after the
+// file and environment
+type flagValue struct {
+	def   string
+	value string
+	set   bool
+}
+
+func (v *flagValue) String() string {
+	if v == nil {
+		return ""
+	}
+	if v.set {
+		return v.value
+	}
+	return v.def
+}
+
+func (v *flagValue) Set(raw string) error {
+	v.value = raw
+	v.set = true
+	return nil
+}
+
+func parse(ptr interface{}, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch p := ptr.(type) {
+	case *string:
+		*p = raw
+	case *int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		*p = n
+	case *time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return errors.New("must be a duration like 30s")
+		}
+		*p = d
+	case *map[string]string:
+		pairs, err := parsePairs(raw)
+		if err != nil {
+			return err
+		}
+		*p = pairs
+	case *map[string]int:
+		pairs, err := parsePairs(raw)
+		if err != nil {
+			return err
+		}
+		m := make(map[string]int, len(pairs))
+		for k, v := range pairs {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("%s must be an integer", k)
+			}
+			m[k] = n
+		}
+		*p = m
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", ptr))
+	}
+	return nil
+}
+
+// parsePairs parses a comma-separated key=value list. The list replaces
+// the whole map rather than adding to it.
+func parsePairs(raw string) (map[string]string, error) {
+	pairs := make(map[string]string)
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(item, "=")
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%q must be key=value", item)
+		}
+		if _, dup := pairs[k]; dup {
+			return nil, fmt.Errorf("%s is listed twice", k)
+		}
+		pairs[k] = v
+	}
+	return pairs, nil
+}
+
+// formatPairs formats a map as a key=value list sorted by key
+func formatPairs[V any](m map[string]V) string {
+	items := make([]string, 0, len(m))
+	for k, v := range m {
+		items = append(items, fmt.Sprintf("%s=%v", k, v))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+func format(ptr interface{}) string {
+	switch p := ptr.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *time.Duration:
+		return p.String()
+	case *map[string]string:
+		return formatPairs(*p)
+	case *map[string]int:
+		return formatPairs(*p)
+	default:
+		panic(fmt.Sprintf("config: unsupported setting type %T", ptr))
+	}
+}
@@ -0,0 +1,74 @@
+// Package configtest provides the file-limit fixtures that pkg/config, the
+// processors and the mega scraper are all tested against, so every binary
+// is held to the same decisions.
+package configtest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// generatedFixtures are the fixtures that only exist to cross the default
+// line limits. They are written by FileLimitFixtures rather than checked in.
+var generatedFixtures = map[string]func() string{
+	// Past the 2000 line cap
+	"generated.rs": func() string {
+		return "pub const TABLE: [u8; 2100] = [\n" + strings.Repeat("    0,\n", 2100) + "];\n"
+	},
+	// Past the 500 line cap for SQL
+	"dump.sql": func() string {
+		var b strings.Builder
+		for i := 0; i < 600; i++ {
+			fmt.Fprintf(&b, "INSERT INTO widgets (id, name) VALUES (%d, 'widget %d');\n", i, i)
+		}
+		return b.String()
+	},
+	// As long as dump.sql but Rust, so only the default cap applies
+	"table.rs": func() string {
+		var b strings.Builder
+		b.WriteString("pub const TABLE: [u32; 600] = [\n")
+		for i := 0; i < 600; i++ {
+			fmt.Fprintf(&b, "    %d,\n", i*7919%65521)
+		}
+		b.WriteString("];\n")
+		return b.String()
+	},
+}
+
+// FileLimitFixtures returns a temporary directory with the fixtures in
+// testdata/filelimits and the generated ones. Its expected.txt lists the
+// language each is accepted as under the default limits, or "-".
+func FileLimitFixtures(t testing.TB) string {
+	t.Helper()
+	_, file, _, _ := runtime.Caller(0)
+	src := filepath.Join(filepath.Dir(file), "..", "..", "..", "testdata", "filelimits")
+	dir := t.TempDir()
+
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, entry.Name()), data, info.Mode().Perm()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range generatedFixtures {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content()), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
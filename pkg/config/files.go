@@ -0,0 +1,139 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"codelupe/pkg/langdetect"
+)
+
+// Files holds the limits a source file must meet to become a sample. The
+// processors and the mega scraper all apply them through Check, so the same
+// corpus produces the same dataset whichever binary ran.
+type Files struct {
+	MinBytes int `yaml:"min_bytes"`
+	MaxBytes int `yaml:"max_bytes"`
+	MinLines int `yaml:"min_lines"`
+	MaxLines int `yaml:"max_lines"`
+	// MaxLinesByLanguage overrides MaxLines for some languages, e.g. a
+	// lower cap for SQL dumps
+	MaxLinesByLanguage map[string]int `yaml:"max_lines_by_language"`
+	// Extensions, when set, is the allowlist of extensions and the
+	// language each is processed as; otherwise every file langdetect
+	// recognizes is accepted
+	Extensions map[string]string `yaml:"extensions"`
+}
+
+// Reasons a file is rejected, wrapped by the errors Check returns
+var (
+	ErrExtension = errors.New("extension not allowed")
+	ErrSize      = errors.New("file size out of range")
+	ErrEncoding  = errors.New("invalid UTF-8")
+	ErrEmpty     = errors.New("empty file")
+	ErrLanguage  = errors.New("unrecognized language")
+	ErrLines     = errors.New("line count out of range")
+)
+
+// CheckName rejects a file by name alone, before it is read
+func (f Files) CheckName(path string) error {
+	if len(f.Extensions) == 0 {
+		if !langdetect.Candidate(path) {
+			return ErrExtension
+		}
+		return nil
+	}
+	if f.extensionLanguage(path) == "" {
+		return fmt.Errorf("%w: %q", ErrExtension, filepath.Ext(path))
+	}
+	return nil
+}
+
+// CheckSize rejects a file by its size in bytes, before it is read
+func (f Files) CheckSize(size int64) error {
+	if size < int64(f.MinBytes) || size > int64(f.MaxBytes) {
+		return fmt.Errorf("%w: %d bytes", ErrSize, size)
+	}
+	return nil
+}
+
+// Check decides whether the file at path with the given content becomes a
+// sample, returning its language when it does
+func (f Files) Check(path string, content []byte) (string, error) {
+	if err := f.CheckName(path); err != nil {
+		return "", err
+	}
+	if err := f.CheckSize(int64(len(content))); err != nil {
+		return "", err
+	}
+	if !utf8.Valid(content) {
+		return "", ErrEncoding
+	}
+	if len(strings.TrimSpace(string(content))) == 0 {
+		return "", ErrEmpty
+	}
+
+	language := f.extensionLanguage(path)
+	if language == "" {
+		if language = langdetect.Detect(path, content); language == "" {
+			return "", ErrLanguage
+		}
+	}
+
+	lines := strings.Count(string(content), "\n") + 1
+	if max := f.MaxLinesFor(language); lines < f.MinLines || lines > max {
+		return "", fmt.Errorf("%w: %d lines", ErrLines, lines)
+	}
+	return language, nil
+}
+
+// MaxLinesFor returns the line cap for language
+func (f Files) MaxLinesFor(language string) int {
+	for lang, max := range f.MaxLinesByLanguage {
+		if strings.EqualFold(lang, language) {
+			return max
+		}
+	}
+	return f.MaxLines
+}
+
+// extensionLanguage returns the allowlisted language for path's extension
+func (f Files) extensionLanguage(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	for allowed, language := range f.Extensions {
+		if strings.ToLower(allowed) == ext {
+			return language
+		}
+	}
+	return ""
+}
+
+// validate reports every unusable limit
+func (f Files) validate() []error {
+	var errs []error
+	if f.MinBytes < 0 {
+		errs = append(errs, fmt.Errorf("files.min_bytes must not be negative, got %d", f.MinBytes))
+	}
+	if f.MaxBytes < 1 || f.MaxBytes < f.MinBytes {
+		errs = append(errs, fmt.Errorf("files.max_bytes must be at least files.min_bytes and 1, got %d", f.MaxBytes))
+	}
+	if f.MinLines < 0 {
+		errs = append(errs, fmt.Errorf("files.min_lines must not be negative, got %d", f.MinLines))
+	}
+	if f.MaxLines < 1 || f.MaxLines < f.MinLines {
+		errs = append(errs, fmt.Errorf("files.max_lines must be at least files.min_lines and 1, got %d", f.MaxLines))
+	}
+	for language, max := range f.MaxLinesByLanguage {
+		if max < 1 || max < f.MinLines {
+			errs = append(errs, fmt.Errorf("files.max_lines_by_language %s must be at least files.min_lines and 1, got %d", language, max))
+		}
+	}
+	for ext, language := range f.Extensions {
+		if !strings.HasPrefix(ext, ".") || len(ext) < 2 || language == "" {
+			errs = append(errs, fmt.Errorf("files.extensions entry %q=%q must map a .ext to a language", ext, language))
+		}
+	}
+	return errs
+}
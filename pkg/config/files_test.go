@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codelupe/pkg/config/configtest"
+)
+
+func TestFiles_Check(t *testing.T) {
+	files := Default().Files
+	goFile := []byte("package main\n\nimport \"fmt\"\n\n// main greets whoever runs the fixture program\nfunc main() {\n\tfmt.Println(\"hello\")\n}\n")
+
+	tests := []struct {
+		name    string
+		path    string
+		content []byte
+		want    string
+		wantErr error
+	}{
+		{"go", "main.go", goFile, "Go", nil},
+		{"unknown extension", "notes.txt", goFile, "", ErrExtension},
+		{"too small", "main.go", []byte("package main\n"), "", ErrSize},
+		{"too large", "main.go", []byte(strings.Repeat("x\n", 1<<19+1)), "", ErrSize},
+		{"not utf-8", "menu.py", append([]byte("print('caf\xe9')\n"), goFile...), "", ErrEncoding},
+		{"whitespace", "main.go", []byte(strings.Repeat(" \n", 60)), "", ErrEmpty},
+		{"no shebang", "deploy", []byte(strings.Repeat("deploy the site\n", 10)), "", ErrLanguage},
+		{"too few lines", "main.go", []byte("package main; func main() { println(\"a single line that is comfortably over one hundred bytes long\") }"), "", ErrLines},
+		{"sql over its cap", "seed.sql", []byte(strings.Repeat("INSERT INTO t VALUES (1);\n", 600)), "", ErrLines},
+		{"rust under the default cap", "table.rs", []byte(strings.Repeat("    1,\n", 600)), "Rust", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := files.Check(tt.path, tt.content)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("Check() = %q, %v; want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFiles_Extensions(t *testing.T) {
+	files := Default().Files
+	files.Extensions = map[string]string{".go": "Go", ".TPL": "Go"}
+
+	content := []byte(strings.Repeat("package main // template\n", 10))
+	if got, err := files.Check("page.tpl", content); got != "Go" || err != nil {
+		t.Errorf("Check(page.tpl) = %q, %v; want the allowlisted language", got, err)
+	}
+	if _, err := files.Check("main.py", content); !errors.Is(err, ErrExtension) {
+		t.Errorf("Check(main.py) error = %v, want %v", err, ErrExtension)
+	}
+	// Scripts need an allowlisted extension too
+	if err := files.CheckName("deploy"); !errors.Is(err, ErrExtension) {
+		t.Errorf("CheckName(deploy) = %v, want %v", err, ErrExtension)
+	}
+}
+
+func TestLoad_Files(t *testing.T) {
+	t.Setenv("FILE_MAX_LINES_BY_LANGUAGE", "SQL=300, JSON=200")
+	cfg, err := load(t, "-file-extensions", ".go=Go,.sql=SQL", "-file-max-lines", "1500")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Files.MaxLines != 1500 || cfg.Files.MaxLinesFor("sql") != 300 || cfg.Files.MaxLinesFor("Go") != 1500 {
+		t.Errorf("Files = %+v", cfg.Files)
+	}
+	if len(cfg.Files.Extensions) != 2 || cfg.Files.Extensions[".sql"] != "SQL" {
+		t.Errorf("Extensions = %v", cfg.Files.Extensions)
+	}
+
+	var buf strings.Builder
+	cfg.Print(&buf)
+	for _, want := range []string{
+		"files.max_lines_by_language = JSON=200,SQL=300\n",
+		"files.extensions = .go=Go,.sql=SQL\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("Print() missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+// TestFiles_Fixtures checks the decisions in the configtest fixtures, which
+// the processors and the mega scraper test against as well
+func TestFiles_Fixtures(t *testing.T) {
+	dir := configtest.FileLimitFixtures(t)
+	f, err := os.Open(filepath.Join(dir, "expected.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files := Default().Files
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, want, ok := strings.Cut(scanner.Text(), " ")
+		if !ok || strings.HasPrefix(name, "#") {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := files.Check(name, content)
+		if err != nil {
+			got = "-"
+		}
+		if got != want {
+			t.Errorf("Check(%s) = %q (%v), want %q", name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+// Package contentstore decides where processed file content lives: inline
+// in processed_files.content, zstd-compressed in content_zstd, or in
+// content-addressed files outside the database with only the path kept in
+// content_path. Readers go through Load, which handles rows written in any
+// mode, so switching modes never strands existing rows.
+package contentstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Storage modes, selected with CONTENT_STORAGE
+const (
+	ModeInline     = "inline"
+	ModeCompressed = "compressed"
+	ModeExternal   = "external"
+)
+
+// Stored is content as it goes into (or comes out of) a processed_files row.
+// Exactly one field is set.
+type Stored struct {
+	Inline     *string // content
+	Compressed []byte  // content_zstd
+	Path       string  // content_path, relative to the external root
+}
+
+// Columns returns the values for the content, content_zstd and
+// content_path columns, nil where the column should be NULL
+func (st Stored) Columns() (content, compressed, path any) {
+	if st.Inline != nil {
+		content = *st.Inline
+	}
+	if st.Compressed != nil {
+		compressed = st.Compressed
+	}
+	if st.Path != "" {
+		path = st.Path
+	}
+	return content, compressed, path
+}
+
+// FromColumns builds a Stored from scanned content, content_zstd and
+// content_path columns
+func FromColumns(content sql.NullString, compressed []byte, path sql.NullString) Stored {
+	var st Stored
+	if content.Valid {
+		st.Inline = &content.String
+	}
+	st.Compressed = compressed
+	st.Path = path.String
+	return st
+}
+
+// Store encodes content for storage and decodes it again
+type Store struct {
+	mode string
+	dir  string // root for external content
+
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// New creates a store for mode. dir is only used, and must be set, in
+// external mode; it is created if missing.
+func New(mode, dir string) (*Store, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{mode: mode, dir: dir, encoder: encoder, decoder: decoder}
+
+	switch mode {
+	case "", ModeInline:
+		s.mode = ModeInline
+	case ModeCompressed:
+	case ModeExternal:
+		if dir == "" {
+			return nil, errors.New("external content storage needs a directory")
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create content directory %s: %w", dir, err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown content storage %q (expected inline, compressed or external)", mode)
+	}
+	return s, nil
+}
+
+// FromEnv creates a store from CONTENT_STORAGE and CONTENT_STORAGE_DIR
+// (default /app/content), defaulting to inline
+func FromEnv() (*Store, error) {
+	dir := os.Getenv("CONTENT_STORAGE_DIR")
+	if dir == "" {
+		dir = "/app/content"
+	}
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("CONTENT_STORAGE")))
+	if mode != ModeExternal {
+		dir = ""
+	}
+	return New(mode, dir)
+}
+
+// Mode returns the mode new content is stored in
+func (s *Store) Mode() string {
+	return s.mode
+}
+
+// String describes the store for startup logs
+func (s *Store) String() string {
+	if s.mode == ModeExternal {
+		return fmt.Sprintf("%s (%s)", s.mode, s.dir)
+	}
+	return s.mode
+}
+
+// Save encodes content for storage. hash identifies the content and names
+// its file in external mode, so identical content is written once.
+func (s *Store) Save(hash string, content []byte) (Stored, error) {
+	switch s.mode {
+	case ModeCompressed:
+		return Stored{Compressed: s.encoder.EncodeAll(content, nil)}, nil
+	case ModeExternal:
+		rel, err := s.writeExternal(hash, content)
+		if err != nil {
+			return Stored{}, err
+		}
+		return Stored{Path: rel}, nil
+	default:
+		text := string(content)
+		return Stored{Inline: &text}, nil
+	}
+}
+
+// Load returns the content of a row written in any mode
+func (s *Store) Load(stored Stored) ([]byte, error) {
+	switch {
+	case stored.Inline != nil:
+		return []byte(*stored.Inline), nil
+	case stored.Compressed != nil:
+		content, err := s.decoder.DecodeAll(stored.Compressed, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress content: %w", err)
+		}
+		return content, nil
+	case stored.Path != "":
+		if s.dir == "" {
+			return nil, fmt.Errorf("content stored externally at %s but CONTENT_STORAGE_DIR is not configured", stored.Path)
+		}
+		compressed, err := os.ReadFile(filepath.Join(s.dir, stored.Path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read external content: %w", err)
+		}
+		content, err := s.decoder.DecodeAll(compressed, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress external content %s: %w", stored.Path, err)
+		}
+		return content, nil
+	default:
+		return nil, errors.New("row has no content")
+	}
+}
+
+// externalPath returns where content with hash is stored, relative to the
+// external root: ab/cd/abcd....zst
+func externalPath(hash string) (string, error) {
+	if len(hash) < 4 || strings.ContainsAny(hash, `/\.`) {
+		return "", fmt.Errorf("invalid content hash %q", hash)
+	}
+	return filepath.Join(hash[:2], hash[2:4], hash+".zst"), nil
+}
+
+// writeExternal stores content compressed under its hash, leaving an
+// existing copy alone. The file is renamed into place so readers never see
+// a partial write.
+func (s *Store) writeExternal(hash string, content []byte) (string, error) {
+	rel, err := externalPath(hash)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(s.dir, rel)
+	if _, err := os.Stat(path); err == nil {
+		return rel, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(s.encoder.EncodeAll(content, nil)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return rel, nil
+}
@@ -0,0 +1,129 @@
+package contentstore
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var sample = []byte(strings.Repeat("func add(a, b int) int {\n\treturn a + b\n}\n", 50))
+
+func TestStore_RoundTrip(t *testing.T) {
+	for _, mode := range []string{ModeInline, ModeCompressed, ModeExternal} {
+		t.Run(mode, func(t *testing.T) {
+			dir := ""
+			if mode == ModeExternal {
+				dir = t.TempDir()
+			}
+			s, err := New(mode, dir)
+			if err != nil {
+				t.Fatalf("New(%q) error: %v", mode, err)
+			}
+
+			stored, err := s.Save("0123456789abcdef", sample)
+			if err != nil {
+				t.Fatalf("Save() error: %v", err)
+			}
+			got, err := s.Load(stored)
+			if err != nil {
+				t.Fatalf("Load() error: %v", err)
+			}
+			if !bytes.Equal(got, sample) {
+				t.Errorf("Load() returned %d bytes, want the original %d", len(got), len(sample))
+			}
+		})
+	}
+}
+
+func TestStore_Compresses(t *testing.T) {
+	s, err := New(ModeCompressed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := s.Save("0123456789abcdef", sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Inline != nil || stored.Path != "" {
+		t.Errorf("Save() = %+v, want only Compressed set", stored)
+	}
+	if len(stored.Compressed) >= len(sample)/4 {
+		t.Errorf("compressed to %d bytes from %d, expected much smaller", len(stored.Compressed), len(sample))
+	}
+}
+
+func TestStore_ExternalIsContentAddressed(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(ModeExternal, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := s.Save("abcdef0123", sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := s.Save("abcdef0123", sample)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Path != second.Path || first.Path != filepath.Join("ab", "cd", "abcdef0123.zst") {
+		t.Errorf("paths = %q, %q; want both ab/cd/abcdef0123.zst", first.Path, second.Path)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(dir, "ab", "cd"))
+	if len(entries) != 1 {
+		t.Errorf("expected 1 file in ab/cd, found %d", len(entries))
+	}
+
+	if _, err := s.Save("../../etc", sample); err == nil {
+		t.Error("Save() with a path-like hash should fail")
+	}
+}
+
+func TestStore_LoadsRowsFromOtherModes(t *testing.T) {
+	// A table written inline and then switched to compressed must still read
+	compressed, err := New(ModeCompressed, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inline, _ := New(ModeInline, "")
+	old, _ := inline.Save("0123456789abcdef", sample)
+
+	got, err := compressed.Load(old)
+	if err != nil || !bytes.Equal(got, sample) {
+		t.Errorf("Load(inline row) = %d bytes, %v", len(got), err)
+	}
+
+	if _, err := compressed.Load(Stored{Path: "ab/cd/abcd.zst"}); err == nil {
+		t.Error("Load(external row) without a directory should fail")
+	}
+	if _, err := compressed.Load(Stored{}); err == nil {
+		t.Error("Load(empty row) should fail")
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	if _, err := New("s3", ""); err == nil {
+		t.Error("New(s3) should fail")
+	}
+	if _, err := New(ModeExternal, ""); err == nil {
+		t.Error("New(external) without a directory should fail")
+	}
+}
+
+func TestStored_Columns(t *testing.T) {
+	text := "package main"
+	content, compressed, path := Stored{Inline: &text}.Columns()
+	if content != text || compressed != nil || path != nil {
+		t.Errorf("Columns() = %v, %v, %v; want only content set", content, compressed, path)
+	}
+
+	st := FromColumns(sql.NullString{}, []byte{1, 2}, sql.NullString{})
+	if st.Inline != nil || len(st.Compressed) != 2 || st.Path != "" {
+		t.Errorf("FromColumns() = %+v, want only Compressed set", st)
+	}
+}
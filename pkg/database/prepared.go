@@ -73,12 +73,14 @@ func (ps *PreparedStatements) Close() error {
 
 // Common prepared statement queries
 const (
+	// Content may be inline, compressed or external; scan the three content
+	// columns with contentstore.FromColumns and read them with Store.Load
 	QueryFetchNewFiles = `
-		SELECT id, content, language, quality_score, file_path
+		SELECT id, content, content_zstd, content_path, language, quality_score, file_path
 		FROM processed_files
 		WHERE id > $1
 		  AND quality_score >= $2
-		  AND LENGTH(content) BETWEEN $3 AND $4
+		  AND size BETWEEN $3 AND $4
 		ORDER BY quality_score DESC, id ASC
 		LIMIT $5
 	`
@@ -88,14 +90,15 @@ const (
 		FROM processed_files
 		WHERE id > $1
 		  AND quality_score >= $2
-		  AND LENGTH(content) BETWEEN $3 AND $4
+		  AND size BETWEEN $3 AND $4
 	`
 
 	QueryInsertFile = `
 		INSERT INTO processed_files
-		(job_id, file_path, relative_path, content, language, lines, size, hash, repo_name, quality_score)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-		ON CONFLICT (hash) DO NOTHING
+		(job_id, file_path, relative_path, content, content_zstd, content_path,
+		 language, lines, size, hash, normalized_hash, repo_name, quality_score)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (normalized_hash) DO NOTHING
 	`
 
 	QueryUpdateRepoStatus = `
@@ -0,0 +1,135 @@
+package deduplication
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"unicode"
+)
+
+// NormalizeOptions controls how aggressively Normalize folds source text
+type NormalizeOptions struct {
+	// LowercaseIdentifiers folds case, so files differing only in the case
+	// of names hash the same. Off by default since it also folds string
+	// literals.
+	LowercaseIdentifiers bool
+}
+
+// commentSyntax describes how a language marks comments
+type commentSyntax struct {
+	line       []string
+	blockStart string
+	blockEnd   string
+}
+
+var (
+	cStyleComments   = commentSyntax{line: []string{"//"}, blockStart: "/*", blockEnd: "*/"}
+	hashComments     = commentSyntax{line: []string{"#"}}
+	phpComments      = commentSyntax{line: []string{"//", "#"}, blockStart: "/*", blockEnd: "*/"}
+	sqlComments      = commentSyntax{line: []string{"--"}, blockStart: "/*", blockEnd: "*/"}
+	luaComments      = commentSyntax{line: []string{"--"}}
+	assemblyComments = commentSyntax{line: []string{";"}}
+	languageComments = map[string]commentSyntax{
+		"Go": cStyleComments, "Java": cStyleComments, "JavaScript": cStyleComments,
+		"TypeScript": cStyleComments, "C": cStyleComments, "C++": cStyleComments,
+		"C/C++": cStyleComments, "C#": cStyleComments, "Rust": cStyleComments,
+		"Swift": cStyleComments, "Kotlin": cStyleComments, "Scala": cStyleComments,
+		"Dart": cStyleComments, "Solidity": cStyleComments, "Objective-C": cStyleComments,
+		"Python": hashComments, "Ruby": hashComments, "Shell": hashComments,
+		"R": hashComments, "Perl": hashComments, "Makefile": hashComments,
+		"Dockerfile": hashComments, "CMake": hashComments, "PowerShell": hashComments,
+		"PHP":      phpComments,
+		"SQL":      sqlComments,
+		"Lua":      luaComments,
+		"Assembly": assemblyComments,
+	}
+)
+
+// Normalize strips comments and collapses whitespace so copies of a file
+// that differ only in formatting or comments compare equal. String
+// literals are left intact. Languages without known comment syntax only
+// get whitespace collapsed.
+func Normalize(content, language string, opts NormalizeOptions) string {
+	text := stripComments(content, languageComments[language])
+	text = strings.Join(strings.FieldsFunc(text, unicode.IsSpace), " ")
+	if opts.LowercaseIdentifiers {
+		text = strings.ToLower(text)
+	}
+	return text
+}
+
+// ContentHashes returns the MD5 of content as-is and of its normalized form
+func ContentHashes(content []byte, language string, opts NormalizeOptions) (raw, normalized string) {
+	rawSum := md5.Sum(content)
+	normalizedSum := md5.Sum([]byte(Normalize(string(content), language, opts)))
+	return hex.EncodeToString(rawSum[:]), hex.EncodeToString(normalizedSum[:])
+}
+
+// stripComments removes comments, replacing each with a space so the
+// tokens either side stay apart
+func stripComments(content string, syntax commentSyntax) string {
+	if len(syntax.line) == 0 && syntax.blockStart == "" {
+		return content
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+
+	var quote byte // the open string delimiter, if inside a literal
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		if quote != 0 {
+			b.WriteByte(c)
+			switch {
+			case c == '\\' && i+1 < len(content):
+				i++
+				b.WriteByte(content[i])
+			case c == quote:
+				quote = 0
+			case c == '\n' && quote != '`':
+				quote = 0 // Unterminated literal (or a Rust lifetime); don't let it swallow the file
+			}
+			continue
+		}
+
+		if c == '"' || c == '\'' || c == '`' {
+			quote = c
+			b.WriteByte(c)
+			continue
+		}
+
+		if syntax.blockStart != "" && strings.HasPrefix(content[i:], syntax.blockStart) {
+			end := strings.Index(content[i+len(syntax.blockStart):], syntax.blockEnd)
+			if end < 0 {
+				break // Unterminated block comment runs to the end of the file
+			}
+			i += len(syntax.blockStart) + end + len(syntax.blockEnd) - 1
+			b.WriteByte(' ')
+			continue
+		}
+
+		if hasAnyPrefix(content[i:], syntax.line) {
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				break
+			}
+			i += end - 1 // Keep the newline itself
+			b.WriteByte(' ')
+			continue
+		}
+
+		b.WriteByte(c)
+	}
+
+	return b.String()
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package deduplication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		language string
+		opts     NormalizeOptions
+		want     string
+	}{
+		{
+			name:     "go comments and whitespace",
+			content:  "// Package x\nfunc  add(a, b int) int {\n\t/* sum */ return a+b // done\n}\n",
+			language: "Go",
+			want:     "func add(a, b int) int { return a+b }",
+		},
+		{
+			name:     "comment markers inside strings are kept",
+			content:  "url := \"http://example.com\" // home\nmsg := 'a # b'\n",
+			language: "Go",
+			want:     "url := \"http://example.com\" msg := 'a # b'",
+		},
+		{
+			name:     "escaped quote does not end the string",
+			content:  "s = \"say \\\"hi\\\" # not a comment\" # comment\n",
+			language: "Python",
+			want:     "s = \"say \\\"hi\\\" # not a comment\"",
+		},
+		{
+			name:     "python hash comments",
+			content:  "# header\ndef f():\n    return 1  # one\n",
+			language: "Python",
+			want:     "def f(): return 1",
+		},
+		{
+			name:     "sql dash and block comments",
+			content:  "-- all users\nSELECT * /* everything */ FROM users;\n",
+			language: "SQL",
+			want:     "SELECT * FROM users;",
+		},
+		{
+			name:     "unknown language only collapses whitespace",
+			content:  "  a   // b\n\n c ",
+			language: "Unknown",
+			want:     "a // b c",
+		},
+		{
+			name:     "unterminated block comment",
+			content:  "int x; /* trailing",
+			language: "C",
+			want:     "int x;",
+		},
+		{
+			name:     "lowercase identifiers",
+			content:  "func GetUser() {}",
+			language: "Go",
+			opts:     NormalizeOptions{LowercaseIdentifiers: true},
+			want:     "func getuser() {}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Normalize(tt.content, tt.language, tt.opts))
+		})
+	}
+}
+
+func TestContentHashes(t *testing.T) {
+	original := []byte("func add(a, b int) int {\n\treturn a + b\n}\n")
+	reformatted := []byte("// add sums its arguments\nfunc add(a, b int) int {\n    return a + b // sum\n}")
+
+	raw1, norm1 := ContentHashes(original, "Go", NormalizeOptions{})
+	raw2, norm2 := ContentHashes(reformatted, "Go", NormalizeOptions{})
+
+	assert.NotEqual(t, raw1, raw2)
+	assert.Equal(t, norm1, norm2)
+	assert.Len(t, raw1, 32)
+	assert.Len(t, norm1, 32)
+
+	_, lowered := ContentHashes([]byte("func Add(a, b int) int { return a + b }"), "Go", NormalizeOptions{LowercaseIdentifiers: true})
+	_, folded := ContentHashes(original, "Go", NormalizeOptions{LowercaseIdentifiers: true})
+	assert.Equal(t, folded, lowered)
+}
@@ -0,0 +1,598 @@
+// Package export streams processed_files, or the function-level chunks in
+// processed_chunks, into a training dataset: sharded JSONL or Parquet files
+// split into train/val/test by repository, plus a manifest describing what
+// was written.
+package export
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"codelupe/pkg/contentstore"
+
+	"github.com/lib/pq"
+)
+
+// Output formats
+const (
+	FormatJSONL   = "jsonl"
+	FormatParquet = "parquet"
+)
+
+// Granularities: a record per file or per chunk
+const (
+	GranularityFile  = "file"
+	GranularityChunk = "chunk"
+)
+
+// Split names, in the order ratios are given
+const (
+	SplitTrain = "train"
+	SplitVal   = "val"
+	SplitTest  = "test"
+)
+
+// MetaFields are the metadata fields a record can carry, in output order
+var MetaFields = []string{"language", "repo", "path", "quality_score", "lines", "size", "hash"}
+
+// ContextFields are the repo context fields added to the meta fields with
+// IncludeContext: the file's directory within the repo and the README and
+// manifest excerpts the processor stored for its repo
+var ContextFields = []string{"dir", "readme_excerpt", "manifest_kind", "manifest_excerpt"}
+
+// ChunkFields are added to the meta fields at chunk granularity: the
+// chunk's kind (function, method, class or type), declared name, lines
+// within the file and doc comment. The other fields describe the chunk's
+// file, except lines and size which measure the chunk.
+var ChunkFields = []string{"kind", "name", "start_line", "end_line", "doc_comment"}
+
+// Filter selects which processed files are exported
+type Filter struct {
+	Languages  []string `json:"languages,omitempty"` // empty means all, matched case-insensitively
+	MinQuality int      `json:"min_quality"`
+	MaxSize    int64    `json:"max_size,omitempty"` // bytes, 0 means no limit
+	// IncludeForks keeps files from repos the downloader marked as forks or
+	// mirrors, which are left out by default
+	IncludeForks bool `json:"include_forks,omitempty"`
+	// IncludeRemoved keeps files the processor marked as removed because
+	// they disappeared from their repo when it was reprocessed
+	IncludeRemoved bool `json:"include_removed,omitempty"`
+}
+
+// Options configures an export
+type Options struct {
+	Format          string
+	OutputDir       string
+	ShardMaxRecords int // records per shard file
+	BatchSize       int // rows fetched per query
+	Filter          Filter
+	Splits          Splits
+
+	// TextField names the content field; Meta lists the MetaFields each
+	// record carries under "meta"
+	TextField string
+	Meta      []string
+
+	// IncludeContext appends ContextFields to Meta
+	IncludeContext bool
+
+	// HuggingFace writes the Hugging Face hub layout: Parquet shards named
+	// data/<split>-00000-of-00003.parquet, with the meta fields as columns
+	// next to the text instead of nested under "meta"
+	HuggingFace bool
+
+	// Sampling caps the files taken per repo and per language
+	Sampling Sampling
+
+	// Granularity is GranularityFile (the default) for a record per file,
+	// or GranularityChunk for a record per chunk the processor stored with
+	// --chunks
+	Granularity string
+}
+
+// Manifest summarizes an export, written to manifest.json
+type Manifest struct {
+	CreatedAt   time.Time              `json:"created_at"`
+	Format      string                 `json:"format"`
+	Granularity string                 `json:"granularity"`
+	TextField   string                 `json:"text_field"`
+	MetaFields  []string               `json:"meta_fields"`
+	Filter      Filter                 `json:"filter"`
+	Ratios      Splits                 `json:"split_ratios"`
+	Total       int64                  `json:"total_records"`
+	Splits      map[string]*SplitStats `json:"splits"`
+	Sampling    *SampleStats           `json:"sampling,omitempty"`
+}
+
+// SplitStats counts what went into one split
+type SplitStats struct {
+	Records   int64            `json:"records"`
+	Repos     int              `json:"repos"`
+	Bytes     int64            `json:"bytes"`
+	Languages map[string]int64 `json:"languages"`
+	Shards    []string         `json:"shards"`
+
+	repos map[string]bool
+}
+
+// record is one processed file as read from the database
+type record struct {
+	id           int64
+	repo         string
+	path         string
+	language     string
+	qualityScore int64
+	lines        int64
+	size         int64
+	hash         string
+	stored       contentstore.Stored
+
+	readmeExcerpt   string
+	manifestKind    string
+	manifestExcerpt string
+
+	// Set at chunk granularity
+	kind       string
+	name       string
+	startLine  int64
+	endLine    int64
+	docComment string
+}
+
+func (o *Options) setDefaults() {
+	if o.HuggingFace {
+		o.Format = FormatParquet
+	}
+	if o.Format == "" {
+		o.Format = FormatJSONL
+	}
+	if o.Granularity == "" {
+		o.Granularity = GranularityFile
+	}
+	if o.ShardMaxRecords <= 0 {
+		o.ShardMaxRecords = 100000
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	if o.TextField == "" {
+		o.TextField = "text"
+	}
+	if o.Meta == nil {
+		o.Meta = MetaFields
+	}
+	if o.IncludeContext {
+		meta := append([]string{}, o.Meta...)
+		for _, field := range ContextFields {
+			if !contains(meta, field) {
+				meta = append(meta, field)
+			}
+		}
+		o.Meta = meta
+	}
+	if o.Granularity == GranularityChunk {
+		meta := append([]string{}, o.Meta...)
+		for _, field := range ChunkFields {
+			if !contains(meta, field) {
+				meta = append(meta, field)
+			}
+		}
+		o.Meta = meta
+	}
+	if o.Splits == (Splits{}) {
+		o.Splits = DefaultSplits
+	}
+}
+
+func (o *Options) validate() error {
+	if o.Format != FormatJSONL && o.Format != FormatParquet {
+		return fmt.Errorf("unknown format %q (expected jsonl or parquet)", o.Format)
+	}
+	if o.OutputDir == "" {
+		return fmt.Errorf("no output directory")
+	}
+	if o.Granularity != GranularityFile && o.Granularity != GranularityChunk {
+		return fmt.Errorf("unknown granularity %q (expected file or chunk)", o.Granularity)
+	}
+	chunks := o.Granularity == GranularityChunk
+	for _, field := range o.Meta {
+		if contains(MetaFields, field) ||
+			(o.IncludeContext && contains(ContextFields, field)) ||
+			(chunks && contains(ChunkFields, field)) {
+			continue
+		}
+		return fmt.Errorf("unknown meta field %q (expected one of %s)", field, strings.Join(MetaFields, ", "))
+	}
+	if o.HuggingFace && contains(o.Meta, o.TextField) {
+		return fmt.Errorf("text field %q clashes with a meta field", o.TextField)
+	}
+	if err := o.Sampling.Validate(); err != nil {
+		return err
+	}
+	if chunks && o.Sampling.Enabled() {
+		return fmt.Errorf("sampling selects files, so it can't be combined with chunk granularity")
+	}
+	return o.Splits.Validate()
+}
+
+func contains(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Run exports the processed files matching opts.Filter, reading content
+// through store. Rows are fetched BatchSize at a time by id, so memory use
+// doesn't grow with the table. With opts.Sampling a first pass reads the
+// candidates without their content and the second fetches only the
+// selected files. At chunk granularity the chunks of the matching files are
+// exported instead, each in the split of its file's repository.
+func Run(ctx context.Context, db *sql.DB, store *contentstore.Store, opts Options) (*Manifest, error) {
+	opts.setDefaults()
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	manifest := &Manifest{
+		CreatedAt:   time.Now().UTC(),
+		Format:      opts.Format,
+		Granularity: opts.Granularity,
+		TextField:   opts.TextField,
+		MetaFields:  opts.Meta,
+		Filter:      opts.Filter,
+		Ratios:      opts.Splits,
+		Splits:      make(map[string]*SplitStats),
+	}
+
+	outputs := make(map[string]*splitOutput)
+	for _, name := range []string{SplitTrain, SplitVal, SplitTest} {
+		stats := &SplitStats{Languages: make(map[string]int64), Shards: []string{}, repos: make(map[string]bool)}
+		manifest.Splits[name] = stats
+		dir, prefix := filepath.Join(opts.OutputDir, name), "part"
+		if opts.HuggingFace {
+			dir, prefix = filepath.Join(opts.OutputDir, "data"), HubSplitName(name)
+		}
+		outputs[name] = &splitOutput{
+			dir:        dir,
+			prefix:     prefix,
+			opts:       &opts,
+			stats:      stats,
+			maxRecords: opts.ShardMaxRecords,
+		}
+	}
+	closeAll := func() error {
+		var firstErr error
+		for _, out := range outputs {
+			if err := out.close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	languages := make([]string, len(opts.Filter.Languages))
+	for i, lang := range opts.Filter.Languages {
+		languages[i] = strings.ToLower(strings.TrimSpace(lang))
+	}
+
+	var lastID int64
+	next := func() ([]record, error) {
+		return fetchBatch(ctx, db, lastID, languages, opts.Filter, opts.BatchSize)
+	}
+	if opts.Granularity == GranularityChunk {
+		next = func() ([]record, error) {
+			return fetchChunks(ctx, db, lastID, languages, opts.Filter, opts.BatchSize)
+		}
+	}
+	if opts.Sampling.Enabled() {
+		ids, stats, err := sampleFiles(ctx, db, languages, opts.Filter, opts.Sampling, opts.BatchSize)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("🎲 Sampled %d of %d files (%d repos capped)", stats.Selected, stats.Candidates, stats.CappedRepos)
+		manifest.Sampling = stats
+		next = func() ([]record, error) {
+			// A batch can come back empty if its files were deleted since
+			for len(ids) > 0 {
+				n := min(opts.BatchSize, len(ids))
+				batch, err := fetchSelected(ctx, db, ids[:n])
+				ids = ids[n:]
+				if err != nil || len(batch) > 0 {
+					return batch, err
+				}
+			}
+			return nil, nil
+		}
+	}
+
+	for batches := 1; ; batches++ {
+		if err := ctx.Err(); err != nil {
+			closeAll()
+			return nil, err
+		}
+
+		batch, err := next()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, rec := range batch {
+			content, err := store.Load(rec.stored)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("failed to load content of %s %d: %w", opts.Granularity, rec.id, err)
+			}
+
+			split := opts.Splits.For(rec.repo)
+			if err := outputs[split].write(rec, content); err != nil {
+				closeAll()
+				return nil, err
+			}
+			manifest.Total++
+		}
+		lastID = batch[len(batch)-1].id
+
+		if batches%100 == 0 {
+			log.Printf("📤 Exported %d %ss", manifest.Total, opts.Granularity)
+		}
+	}
+
+	if err := closeAll(); err != nil {
+		return nil, err
+	}
+	for _, stats := range manifest.Splits {
+		stats.Repos = len(stats.repos)
+	}
+	if opts.HuggingFace {
+		if err := numberHubShards(opts.OutputDir, manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeManifest(filepath.Join(opts.OutputDir, "manifest.json"), manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportQuery pages through processed_files by id. $3 (languages) is
+// lowercased; an empty array matches every language. Unless $5 is set,
+// files from forks and mirrors are skipped; files whose job can't be
+// matched to a repository row are kept. Unless $6 is set, files marked
+// removed are skipped. The repo context comes from the file's job.
+const exportQuery = `
+	SELECT ` + recordColumns + `
+	FROM processed_files f
+	LEFT JOIN processing_jobs j ON j.id = f.job_id
+	WHERE f.id > $1
+	AND ` + exportFilter + `
+	ORDER BY f.id
+	LIMIT $7
+`
+
+const recordColumns = `f.id, f.repo_name, f.relative_path, f.language, f.quality_score, f.lines, f.size, f.hash,
+		f.content, f.content_zstd, f.content_path,
+		j.repo_readme_excerpt, j.manifest_kind, j.manifest_excerpt`
+
+const exportFilter = `f.quality_score >= $2
+	AND (cardinality($3::text[]) = 0 OR LOWER(f.language) = ANY($3::text[]))
+	AND ($4::bigint = 0 OR f.size <= $4::bigint)
+	AND ($5::boolean OR NOT EXISTS (
+		SELECT 1 FROM repositories r
+		WHERE r.local_path = j.repo_path AND (r.is_fork OR r.mirror_of IS NOT NULL)
+	))
+	AND ($6::boolean OR f.removed_at IS NULL)`
+
+// selectedQuery fetches the files sampling selected, already filtered
+const selectedQuery = `
+	SELECT ` + recordColumns + `
+	FROM processed_files f
+	LEFT JOIN processing_jobs j ON j.id = f.job_id
+	WHERE f.id = ANY($1::bigint[])
+	ORDER BY f.id
+`
+
+// chunkQuery pages through processed_chunks by id, keeping the chunks of
+// the files exportQuery would export. The chunk's own content, line count
+// and size take the place of its file's.
+const chunkQuery = `
+	SELECT c.id, f.repo_name, f.relative_path, f.language, f.quality_score,
+		c.end_line - c.start_line + 1, octet_length(c.content), f.hash,
+		c.content,
+		j.repo_readme_excerpt, j.manifest_kind, j.manifest_excerpt,
+		c.kind, c.name, c.start_line, c.end_line, c.doc_comment
+	FROM processed_chunks c
+	JOIN processed_files f ON f.id = c.file_id
+	LEFT JOIN processing_jobs j ON j.id = f.job_id
+	WHERE c.id > $1
+	AND ` + exportFilter + `
+	ORDER BY c.id
+	LIMIT $7
+`
+
+func fetchBatch(ctx context.Context, db *sql.DB, afterID int64, languages []string, filter Filter, limit int) ([]record, error) {
+	rows, err := db.QueryContext(ctx, exportQuery,
+		afterID, filter.MinQuality, pq.Array(languages), filter.MaxSize, filter.IncludeForks, filter.IncludeRemoved, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query processed files: %w", err)
+	}
+	return scanRecords(rows)
+}
+
+func fetchSelected(ctx context.Context, db *sql.DB, ids []int64) ([]record, error) {
+	rows, err := db.QueryContext(ctx, selectedQuery, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query processed files: %w", err)
+	}
+	return scanRecords(rows)
+}
+
+func fetchChunks(ctx context.Context, db *sql.DB, afterID int64, languages []string, filter Filter, limit int) ([]record, error) {
+	rows, err := db.QueryContext(ctx, chunkQuery,
+		afterID, filter.MinQuality, pq.Array(languages), filter.MaxSize, filter.IncludeForks, filter.IncludeRemoved, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query processed chunks: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []record
+	for rows.Next() {
+		var rec record
+		var content, readme, manifestKind, manifest, doc sql.NullString
+		if err := rows.Scan(&rec.id, &rec.repo, &rec.path, &rec.language, &rec.qualityScore,
+			&rec.lines, &rec.size, &rec.hash, &content,
+			&readme, &manifestKind, &manifest,
+			&rec.kind, &rec.name, &rec.startLine, &rec.endLine, &doc); err != nil {
+			return nil, err
+		}
+		// Chunks are always stored inline
+		rec.stored = contentstore.FromColumns(content, nil, sql.NullString{})
+		rec.readmeExcerpt = readme.String
+		rec.manifestKind = manifestKind.String
+		rec.manifestExcerpt = manifest.String
+		rec.docComment = doc.String
+		batch = append(batch, rec)
+	}
+	return batch, rows.Err()
+}
+
+func scanRecords(rows *sql.Rows) ([]record, error) {
+	defer rows.Close()
+
+	var batch []record
+	for rows.Next() {
+		var rec record
+		var content, contentPath, readme, manifestKind, manifest sql.NullString
+		var compressed []byte
+		if err := rows.Scan(&rec.id, &rec.repo, &rec.path, &rec.language, &rec.qualityScore,
+			&rec.lines, &rec.size, &rec.hash, &content, &compressed, &contentPath,
+			&readme, &manifestKind, &manifest); err != nil {
+			return nil, err
+		}
+		rec.stored = contentstore.FromColumns(content, compressed, contentPath)
+		rec.readmeExcerpt = readme.String
+		rec.manifestKind = manifestKind.String
+		rec.manifestExcerpt = manifest.String
+		batch = append(batch, rec)
+	}
+	return batch, rows.Err()
+}
+
+// meta returns the record's metadata restricted to fields
+func (r *record) meta(fields []string) map[string]any {
+	all := map[string]any{
+		"language":      r.language,
+		"repo":          r.repo,
+		"path":          r.path,
+		"quality_score": r.qualityScore,
+		"lines":         r.lines,
+		"size":          r.size,
+		"hash":          r.hash,
+
+		"dir":              path.Dir(filepath.ToSlash(r.path)),
+		"readme_excerpt":   r.readmeExcerpt,
+		"manifest_kind":    r.manifestKind,
+		"manifest_excerpt": r.manifestExcerpt,
+
+		"kind":        r.kind,
+		"name":        r.name,
+		"start_line":  r.startLine,
+		"end_line":    r.endLine,
+		"doc_comment": r.docComment,
+	}
+	meta := make(map[string]any, len(fields))
+	for _, field := range fields {
+		meta[field] = all[field]
+	}
+	return meta
+}
+
+// splitOutput writes one split's shards
+type splitOutput struct {
+	dir        string
+	prefix     string // shard file name prefix
+	opts       *Options
+	stats      *SplitStats
+	maxRecords int
+
+	shard      shardWriter
+	shardIndex int
+	inShard    int
+}
+
+func (o *splitOutput) write(rec record, content []byte) error {
+	if o.shard != nil && o.inShard >= o.maxRecords {
+		if err := o.close(); err != nil {
+			return err
+		}
+	}
+	if o.shard == nil {
+		if err := os.MkdirAll(o.dir, 0755); err != nil {
+			return err
+		}
+		name := fmt.Sprintf("%s-%05d.%s", o.prefix, o.shardIndex, o.opts.Format)
+		shard, err := newShardWriter(o.opts, filepath.Join(o.dir, name))
+		if err != nil {
+			return err
+		}
+		o.shard = shard
+		o.shardIndex++
+		o.inShard = 0
+		o.stats.Shards = append(o.stats.Shards, filepath.Join(filepath.Base(o.dir), name))
+	}
+
+	row := map[string]any{o.opts.TextField: string(content)}
+	if o.opts.HuggingFace {
+		for field, value := range rec.meta(o.opts.Meta) {
+			row[field] = value
+		}
+	} else {
+		row["meta"] = rec.meta(o.opts.Meta)
+	}
+	if err := o.shard.Write(row); err != nil {
+		return err
+	}
+
+	o.inShard++
+	o.stats.Records++
+	o.stats.Bytes += int64(len(content))
+	o.stats.Languages[rec.language]++
+	o.stats.repos[rec.repo] = true
+	return nil
+}
+
+func (o *splitOutput) close() error {
+	if o.shard == nil {
+		return nil
+	}
+	err := o.shard.Close()
+	o.shard = nil
+	return err
+}
+
+func writeManifest(path string, manifest *Manifest) error {
+	for _, stats := range manifest.Splits {
+		sort.Strings(stats.Shards)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
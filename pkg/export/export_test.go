@@ -0,0 +1,477 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codelupe/internal/dataset"
+	"codelupe/pkg/contentstore"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/parquet-go/parquet-go"
+)
+
+var exportColumns = []string{"id", "repo_name", "relative_path", "language", "quality_score",
+	"lines", "size", "hash", "content", "content_zstd", "content_path",
+	"repo_readme_excerpt", "manifest_kind", "manifest_excerpt"}
+
+func TestSplitsFor_Deterministic(t *testing.T) {
+	splits := Splits{Train: 0.8, Val: 0.1, Test: 0.1}
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		repo := fmt.Sprintf("owner%d_repo%d", i%97, i)
+		split := splits.For(repo)
+		if again := splits.For(repo); again != split {
+			t.Fatalf("For(%q) = %s then %s", repo, split, again)
+		}
+		counts[split]++
+	}
+
+	// Ratios hold to within a couple of percent over many repos
+	for split, want := range map[string]float64{SplitTrain: 0.8, SplitVal: 0.1, SplitTest: 0.1} {
+		got := float64(counts[split]) / 10000
+		if got < want-0.02 || got > want+0.02 {
+			t.Errorf("%s got %.3f of repos, want about %.2f", split, got, want)
+		}
+	}
+
+	if got := (Splits{Train: 1}).For("anything"); got != SplitTrain {
+		t.Errorf("For() with Train=1 = %s, want train", got)
+	}
+	if got := (Splits{Test: 1}).For("anything"); got != SplitTest {
+		t.Errorf("For() with Test=1 = %s, want test", got)
+	}
+}
+
+func TestParseSplits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Splits
+		wantErr bool
+	}{
+		{"0.8,0.1,0.1", Splits{0.8, 0.1, 0.1}, false},
+		{" 1, 0, 0 ", Splits{1, 0, 0}, false},
+		{"0.8,0.1", Splits{}, true},
+		{"0.8,0.1,0.2", Splits{}, true},
+		{"1.2,-0.1,-0.1", Splits{}, true},
+		{"a,b,c", Splits{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSplits(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSplits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ParseSplits() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRun_FilterArgs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+
+	// Languages are matched lowercased; quality, size and fork filters go to SQL
+	mock.ExpectQuery("SELECT f.id, f.repo_name").
+		WithArgs(int64(0), 70, pq.Array([]string{"go", "python"}), int64(4096), true, false, 1000).
+		WillReturnRows(sqlmock.NewRows(exportColumns))
+
+	_, err = Run(context.Background(), db, store, Options{
+		OutputDir: t.TempDir(),
+		Filter:    Filter{Languages: []string{"Go", " PYTHON"}, MinQuality: 70, MaxSize: 4096, IncludeForks: true},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRun_JSONL(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	store, _ := contentstore.New(contentstore.ModeCompressed, "")
+	compressed, _ := store.Save("h3", []byte("print('hi')"))
+
+	// Two pages, then an empty one ends the export
+	mock.ExpectQuery("SELECT f.id, f.repo_name").
+		WithArgs(int64(0), 0, sqlmock.AnyArg(), int64(0), false, false, 2).
+		WillReturnRows(sqlmock.NewRows(exportColumns).
+			AddRow(1, "repo-a", "main.go", "Go", 80, 3, 12, "h1", "package main", nil, nil, nil, nil, nil).
+			AddRow(2, "repo-a", "util.go", "Go", 75, 3, 12, "h2", "package util", nil, nil, nil, nil, nil))
+	mock.ExpectQuery("SELECT f.id, f.repo_name").
+		WithArgs(int64(2), 0, sqlmock.AnyArg(), int64(0), false, false, 2).
+		WillReturnRows(sqlmock.NewRows(exportColumns).
+			AddRow(3, "repo-a", "hi.py", "Python", 90, 1, 11, "h3", nil, compressed.Compressed, nil, nil, nil, nil))
+	mock.ExpectQuery("SELECT f.id, f.repo_name").
+		WithArgs(int64(3), 0, sqlmock.AnyArg(), int64(0), false, false, 2).
+		WillReturnRows(sqlmock.NewRows(exportColumns))
+
+	dir := t.TempDir()
+	manifest, err := Run(context.Background(), db, store, Options{
+		OutputDir:       dir,
+		BatchSize:       2,
+		ShardMaxRecords: 2,
+		TextField:       "content",
+		Meta:            []string{"language", "repo"},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// Every file of repo-a lands in one split, rotating shards after 2
+	split := DefaultSplits.For("repo-a")
+	stats := manifest.Splits[split]
+	if manifest.Total != 3 || stats.Records != 3 || stats.Repos != 1 {
+		t.Fatalf("manifest total=%d, %s=%+v; want 3 records from 1 repo", manifest.Total, split, stats)
+	}
+	if len(stats.Shards) != 2 {
+		t.Fatalf("shards = %v, want 2", stats.Shards)
+	}
+	if stats.Languages["Go"] != 2 || stats.Languages["Python"] != 1 {
+		t.Errorf("languages = %v, want Go:2 Python:1", stats.Languages)
+	}
+
+	var rows []map[string]any
+	for _, shard := range stats.Shards {
+		f, err := os.Open(filepath.Join(dir, shard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var row map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("invalid JSONL line %q: %v", scanner.Text(), err)
+			}
+			rows = append(rows, row)
+		}
+		f.Close()
+	}
+	if len(rows) != 3 {
+		t.Fatalf("read %d rows, want 3", len(rows))
+	}
+	if rows[2]["content"] != "print('hi')" {
+		t.Errorf("compressed row content = %v, want it decompressed", rows[2]["content"])
+	}
+	meta := rows[0]["meta"].(map[string]any)
+	if meta["repo"] != "repo-a" || meta["language"] != "Go" || meta["path"] != nil {
+		t.Errorf("meta = %v, want only language and repo", meta)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("manifest.json not written: %v", err)
+	}
+	var onDisk Manifest
+	if err := json.Unmarshal(data, &onDisk); err != nil || onDisk.Total != 3 {
+		t.Errorf("manifest.json = %s (err %v)", data, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRun_Parquet(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+
+	rows := sqlmock.NewRows(exportColumns)
+	for i := 1; i <= 5; i++ {
+		rows.AddRow(i, "repo-b", fmt.Sprintf("f%d.go", i), "Go", 80, 10, 100, fmt.Sprintf("h%d", i), "package main", nil, nil, nil, nil, nil)
+	}
+	mock.ExpectQuery("SELECT f.id, f.repo_name").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT f.id, f.repo_name").WillReturnRows(sqlmock.NewRows(exportColumns))
+
+	dir := t.TempDir()
+	manifest, err := Run(context.Background(), db, store, Options{Format: FormatParquet, OutputDir: dir})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	stats := manifest.Splits[DefaultSplits.For("repo-b")]
+	if len(stats.Shards) != 1 {
+		t.Fatalf("shards = %v, want 1", stats.Shards)
+	}
+	f, err := os.Open(filepath.Join(dir, stats.Shards[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatalf("invalid parquet file: %v", err)
+	}
+	if pf.NumRows() != 5 {
+		t.Errorf("NumRows() = %d, want 5", pf.NumRows())
+	}
+	if _, ok := pf.Schema().Lookup("meta", "quality_score"); !ok {
+		t.Errorf("schema %v missing meta.quality_score", pf.Schema())
+	}
+}
+
+func TestRun_IncludeContext(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+
+	mock.ExpectQuery("SELECT f.id, f.repo_name").
+		WillReturnRows(sqlmock.NewRows(exportColumns).
+			AddRow(1, "repo-c", "cmd/server/main.go", "Go", 80, 3, 12, "h1", "package main", nil, nil,
+				"# widget", "go.mod", "module widget").
+			AddRow(2, "repo-c", "main.py", "Python", 80, 1, 11, "h2", "print('hi')", nil, nil, nil, nil, nil))
+	mock.ExpectQuery("SELECT f.id, f.repo_name").WillReturnRows(sqlmock.NewRows(exportColumns))
+
+	dir := t.TempDir()
+	manifest, err := Run(context.Background(), db, store, Options{
+		OutputDir:      dir,
+		Meta:           []string{"repo"},
+		IncludeContext: true,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if want := append([]string{"repo"}, ContextFields...); fmt.Sprint(manifest.MetaFields) != fmt.Sprint(want) {
+		t.Errorf("MetaFields = %v, want %v", manifest.MetaFields, want)
+	}
+
+	stats := manifest.Splits[DefaultSplits.For("repo-c")]
+	data, err := os.ReadFile(filepath.Join(dir, stats.Shards[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var first, second struct {
+		Meta map[string]any `json:"meta"`
+	}
+	json.Unmarshal([]byte(lines[0]), &first)
+	json.Unmarshal([]byte(lines[1]), &second)
+
+	want := map[string]any{"repo": "repo-c", "dir": "cmd/server", "readme_excerpt": "# widget",
+		"manifest_kind": "go.mod", "manifest_excerpt": "module widget"}
+	if fmt.Sprint(first.Meta) != fmt.Sprint(want) {
+		t.Errorf("meta = %v, want %v", first.Meta, want)
+	}
+	// A repo with no README or manifest still gets the fields
+	if second.Meta["dir"] != "." || second.Meta["readme_excerpt"] != "" || second.Meta["manifest_kind"] != "" {
+		t.Errorf("meta without context = %v", second.Meta)
+	}
+}
+
+func TestRun_InvalidOptions(t *testing.T) {
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+	for name, opts := range map[string]Options{
+		"format":     {Format: "csv", OutputDir: "out"},
+		"meta field": {OutputDir: "out", Meta: []string{"stars"}},
+		// Context fields need IncludeContext
+		"context field": {OutputDir: "out", Meta: []string{"readme_excerpt"}},
+		"splits":        {OutputDir: "out", Splits: Splits{Train: 0.5}},
+		"language mix":  {OutputDir: "out", Sampling: Sampling{LanguageMix: map[string]float64{"go": 0.5}}},
+		"output dir":    {},
+		"granularity":   {OutputDir: "out", Granularity: "line"},
+		// Chunk fields need chunk granularity
+		"chunk field": {OutputDir: "out", Meta: []string{"name"}},
+		"chunk sampling": {OutputDir: "out", Granularity: GranularityChunk,
+			Sampling: Sampling{MaxFilesPerRepo: 10}},
+	} {
+		if _, err := Run(context.Background(), nil, store, opts); err == nil {
+			t.Errorf("Run() with bad %s: error = nil", name)
+		}
+	}
+}
+
+var chunkColumns = []string{"id", "repo_name", "relative_path", "language", "quality_score",
+	"lines", "size", "hash", "content",
+	"repo_readme_excerpt", "manifest_kind", "manifest_excerpt",
+	"kind", "name", "start_line", "end_line", "doc_comment"}
+
+func TestRun_ChunkGranularity(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Chunks page by their own id, with the same filter as files
+	mock.ExpectQuery("FROM processed_chunks c").
+		WithArgs(int64(0), 50, sqlmock.AnyArg(), int64(0), false, false, 1000).
+		WillReturnRows(sqlmock.NewRows(chunkColumns).
+			AddRow(7, "repo-a", "app.py", "Python", 80, 3, 30, "h1", "def run():\n    \"\"\"Go.\"\"\"\n    pass",
+				nil, nil, nil, "function", "run", 4, 6, "Go.").
+			AddRow(9, "repo-a", "app.py", "Python", 80, 2, 25, "h1", "class App:\n    pass",
+				nil, nil, nil, "class", "App", 9, 10, nil))
+	mock.ExpectQuery("FROM processed_chunks c").
+		WithArgs(int64(9), 50, sqlmock.AnyArg(), int64(0), false, false, 1000).
+		WillReturnRows(sqlmock.NewRows(chunkColumns))
+
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+	dir := t.TempDir()
+	manifest, err := Run(context.Background(), db, store, Options{
+		OutputDir:   dir,
+		Meta:        []string{"repo", "path", "lines"},
+		Filter:      Filter{MinQuality: 50},
+		Granularity: GranularityChunk,
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if manifest.Total != 2 || manifest.Granularity != GranularityChunk {
+		t.Fatalf("manifest total=%d granularity=%q, want 2 chunk records", manifest.Total, manifest.Granularity)
+	}
+	if want := []string{"repo", "path", "lines", "kind", "name", "start_line", "end_line", "doc_comment"}; strings.Join(manifest.MetaFields, ",") != strings.Join(want, ",") {
+		t.Errorf("meta fields = %v, want %v", manifest.MetaFields, want)
+	}
+
+	shard := manifest.Splits[DefaultSplits.For("repo-a")].Shards[0]
+	data, err := os.ReadFile(filepath.Join(dir, shard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("read %d rows, want 2", len(lines))
+	}
+	var row struct {
+		Text string         `json:"text"`
+		Meta map[string]any `json:"meta"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &row); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(row.Text, "def run():") {
+		t.Errorf("text = %q, want the chunk's content", row.Text)
+	}
+	meta := row.Meta
+	if meta["kind"] != "function" || meta["name"] != "run" || meta["start_line"] != 4.0 || meta["end_line"] != 6.0 ||
+		meta["doc_comment"] != "Go." || meta["path"] != "app.py" || meta["lines"] != 3.0 {
+		t.Errorf("meta = %v", meta)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRun_HuggingFace(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+
+	// Everything goes to validation so the shard count is predictable
+	rows := sqlmock.NewRows(exportColumns)
+	for i := 1; i <= 5; i++ {
+		rows.AddRow(i, "repo-d", fmt.Sprintf("f%d.go", i), "Go", 80, 10, 100, fmt.Sprintf("h%d", i), "package main", nil, nil, nil, nil, nil)
+	}
+	mock.ExpectQuery("SELECT f.id, f.repo_name").WillReturnRows(rows)
+	mock.ExpectQuery("SELECT f.id, f.repo_name").WillReturnRows(sqlmock.NewRows(exportColumns))
+
+	dir := t.TempDir()
+	manifest, err := Run(context.Background(), db, store, Options{
+		OutputDir:       dir,
+		HuggingFace:     true,
+		ShardMaxRecords: 2,
+		Splits:          Splits{Val: 1},
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{"data/validation-00000-of-00003.parquet", "data/validation-00001-of-00003.parquet",
+		"data/validation-00002-of-00003.parquet"}
+	if got := manifest.Splits[SplitVal].Shards; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("shards = %v, want %v", got, want)
+	}
+	f, err := os.Open(filepath.Join(dir, want[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	pf, err := parquet.OpenFile(f, info.Size())
+	if err != nil {
+		t.Fatalf("invalid parquet file: %v", err)
+	}
+	for _, column := range []string{"text", "language", "repo", "quality_score"} {
+		if _, ok := pf.Schema().Lookup(column); !ok {
+			t.Errorf("schema %v missing top-level column %s", pf.Schema(), column)
+		}
+	}
+}
+
+func TestDatasetCard(t *testing.T) {
+	manifest := &Manifest{
+		CreatedAt:  time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		TextField:  "text",
+		MetaFields: []string{"language", "quality_score"},
+		Filter:     Filter{MinQuality: 70},
+		Total:      30,
+		Splits: map[string]*SplitStats{
+			SplitTrain: {Records: 25, Repos: 4, Bytes: 4096, Languages: map[string]int64{"Go": 20, "Rust": 5},
+				Shards: []string{"data/train-00000-of-00001.parquet"}},
+			SplitVal:  {Records: 5, Repos: 1, Bytes: 512, Languages: map[string]int64{"Go": 5}, Shards: []string{"data/validation-00000-of-00001.parquet"}},
+			SplitTest: {Languages: map[string]int64{}, Shards: []string{}},
+		},
+	}
+	report := &dataset.Report{
+		Overall: &dataset.OverallStats{TotalFiles: 100, TotalSize: 1 << 20, TotalRepos: 9,
+			Licenses: []dataset.LicenseStats{{License: "MIT", FileCount: 90}, {License: "unknown", FileCount: 10}}},
+		QualityDistribution: []dataset.QualityTier{{Tier: "Excellent (90-100)", FileCount: 40, Percentage: 40}},
+	}
+
+	card := DatasetCard("acme/code", manifest, report)
+	for _, want := range []string{
+		"license: mit\n",
+		"- n<1K\n",
+		"  - split: train\n    path: data/train-*.parquet\n",
+		"  - split: validation\n    path: data/validation-*.parquet\n",
+		"  - name: quality_score\n    dtype: int64\n",
+		"# acme/code\n",
+		"| Go | 25 | 83.3% |\n",
+		"| Excellent (90-100) | 40 | 40.0% |\n",
+		"| MIT | 90 |",
+	} {
+		if !strings.Contains(card, want) {
+			t.Errorf("card missing %q:\n%s", want, card)
+		}
+	}
+	// The hub rejects a data_files pattern matching nothing
+	if strings.Contains(card, "path: data/test-*.parquet") {
+		t.Errorf("card lists the empty test split:\n%s", card)
+	}
+
+	report.Overall.Licenses = append(report.Overall.Licenses, dataset.LicenseStats{License: "Apache-2.0"})
+	if card := DatasetCard("acme/code", manifest, report); !strings.Contains(card, "license: other\n") {
+		t.Error("card with several licenses should say license: other")
+	}
+}
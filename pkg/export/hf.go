@@ -0,0 +1,215 @@
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"codelupe/internal/dataset"
+)
+
+// HubSplitName returns the name the Hugging Face hub expects for split:
+// "validation" rather than "val"
+func HubSplitName(split string) string {
+	if split == SplitVal {
+		return "validation"
+	}
+	return split
+}
+
+// numberHubShards renames each split's shards to the hub's
+// <split>-00000-of-00003.parquet pattern, which needs the shard count known
+func numberHubShards(dir string, manifest *Manifest) error {
+	for _, stats := range manifest.Splits {
+		sort.Strings(stats.Shards)
+		for i, shard := range stats.Shards {
+			ext := filepath.Ext(shard)
+			renamed := fmt.Sprintf("%s-of-%05d%s", strings.TrimSuffix(shard, ext), len(stats.Shards), ext)
+			if err := os.Rename(filepath.Join(dir, shard), filepath.Join(dir, renamed)); err != nil {
+				return fmt.Errorf("failed to rename shard %s: %w", shard, err)
+			}
+			stats.Shards[i] = renamed
+		}
+	}
+	return nil
+}
+
+// WriteDatasetCard writes the hub's README.md for an export in the Hugging
+// Face layout. See DatasetCard.
+func WriteDatasetCard(dir, title string, manifest *Manifest, report *dataset.Report) error {
+	return os.WriteFile(filepath.Join(dir, "README.md"), []byte(DatasetCard(title, manifest, report)), 0644)
+}
+
+// DatasetCard renders a dataset card: the YAML header the hub reads the
+// splits, features and tags from, then the export's splits and languages.
+// report, the dataset analyzer's statistics, adds the size, quality tiers
+// and licenses of the corpus the export was filtered from; it may be nil.
+func DatasetCard(title string, manifest *Manifest, report *dataset.Report) string {
+	var b strings.Builder
+	splits := []string{SplitTrain, SplitVal, SplitTest}
+
+	b.WriteString("---\n")
+	fmt.Fprintf(&b, "license: %s\n", licenseTag(report))
+	b.WriteString("task_categories:\n- text-generation\n")
+	b.WriteString("tags:\n- code\n")
+	fmt.Fprintf(&b, "size_categories:\n- %s\n", sizeCategory(manifest.Total))
+	b.WriteString("configs:\n- config_name: default\n  data_files:\n")
+	for _, split := range splits {
+		// The hub rejects a pattern that matches no file
+		if len(manifest.Splits[split].Shards) > 0 {
+			fmt.Fprintf(&b, "  - split: %s\n    path: data/%s-*.parquet\n", HubSplitName(split), HubSplitName(split))
+		}
+	}
+	b.WriteString("dataset_info:\n  features:\n")
+	fmt.Fprintf(&b, "  - name: %s\n    dtype: string\n", manifest.TextField)
+	for _, field := range manifest.MetaFields {
+		dtype := "string"
+		if field == "quality_score" || field == "lines" || field == "size" {
+			dtype = "int64"
+		}
+		fmt.Fprintf(&b, "  - name: %s\n    dtype: %s\n", field, dtype)
+	}
+	b.WriteString("  splits:\n")
+	for _, split := range splits {
+		stats := manifest.Splits[split]
+		fmt.Fprintf(&b, "  - name: %s\n    num_bytes: %d\n    num_examples: %d\n", HubSplitName(split), stats.Bytes, stats.Records)
+	}
+	b.WriteString("---\n\n")
+
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "Source files from GitHub repositories, collected and filtered by CodeLupe and exported on %s. ",
+		manifest.CreatedAt.Format("2006-01-02"))
+	row := "one file"
+	if manifest.Granularity == GranularityChunk {
+		row = "one top-level function, method, class or type of a file"
+	}
+	fmt.Fprintf(&b, "Each row is %s: its content in `%s` and its metadata in the other columns. ", row, manifest.TextField)
+	b.WriteString("Files are assigned to splits by repository, so no repository appears in more than one split.\n\n")
+
+	b.WriteString("## Filter\n\n")
+	fmt.Fprintf(&b, "- Minimum quality score: %d\n", manifest.Filter.MinQuality)
+	if len(manifest.Filter.Languages) > 0 {
+		fmt.Fprintf(&b, "- Languages: %s\n", strings.Join(manifest.Filter.Languages, ", "))
+	}
+	if manifest.Filter.MaxSize > 0 {
+		fmt.Fprintf(&b, "- Maximum file size: %s\n", humanBytes(manifest.Filter.MaxSize))
+	}
+	if !manifest.Filter.IncludeForks {
+		b.WriteString("- Forks and mirrors left out\n")
+	}
+	b.WriteString("\n## Splits\n\n| Split | Files | Repositories | Size |\n|---|---:|---:|---:|\n")
+	for _, split := range splits {
+		stats := manifest.Splits[split]
+		fmt.Fprintf(&b, "| %s | %d | %d | %s |\n", HubSplitName(split), stats.Records, stats.Repos, humanBytes(stats.Bytes))
+	}
+
+	languages := make(map[string]int64)
+	for _, stats := range manifest.Splits {
+		for language, n := range stats.Languages {
+			languages[language] += n
+		}
+	}
+	names := make([]string, 0, len(languages))
+	for language := range languages {
+		names = append(names, language)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if languages[names[i]] != languages[names[j]] {
+			return languages[names[i]] > languages[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	b.WriteString("\n## Languages\n\n| Language | Files | Share |\n|---|---:|---:|\n")
+	for _, language := range names {
+		fmt.Fprintf(&b, "| %s | %d | %.1f%% |\n", language, languages[language], percent(languages[language], manifest.Total))
+	}
+
+	if report != nil && report.Overall != nil {
+		overall := report.Overall
+		b.WriteString("\n## Source corpus\n\n")
+		fmt.Fprintf(&b, "The export was filtered from %d processed files (%s) from %d repositories, with an average quality score of %.1f.\n",
+			overall.TotalFiles, humanBytes(overall.TotalSize), overall.TotalRepos, overall.AvgQuality)
+
+		if len(report.QualityDistribution) > 0 {
+			b.WriteString("\n### Quality tiers\n\n| Tier | Files | Share |\n|---|---:|---:|\n")
+			for _, tier := range report.QualityDistribution {
+				fmt.Fprintf(&b, "| %s | %d | %.1f%% |\n", tier.Tier, tier.FileCount, tier.Percentage)
+			}
+		}
+		if len(overall.Licenses) > 0 {
+			b.WriteString("\n### Licenses\n\n")
+			b.WriteString("Each file keeps the license of the repository it came from.\n\n")
+			b.WriteString("| License | Files | Repositories | Share |\n|---|---:|---:|---:|\n")
+			for _, lic := range overall.Licenses {
+				fmt.Fprintf(&b, "| %s | %d | %d | %.1f%% |\n", lic.License, lic.FileCount, lic.RepoCount, lic.Percentage)
+			}
+		}
+	}
+	return b.String()
+}
+
+// licenseTag is the card's license: the corpus's license when every
+// repository with a known license shares it, otherwise "other"
+func licenseTag(report *dataset.Report) string {
+	if report == nil || report.Overall == nil {
+		return "other"
+	}
+	tag := ""
+	for _, lic := range report.Overall.Licenses {
+		if lic.License == "unknown" {
+			continue
+		}
+		if tag != "" {
+			return "other"
+		}
+		tag = strings.ToLower(lic.License)
+	}
+	if tag == "" {
+		return "other"
+	}
+	return tag
+}
+
+// sizeCategory returns the hub's size_categories bucket for n rows
+func sizeCategory(n int64) string {
+	bounds := []struct {
+		limit int64
+		name  string
+	}{
+		{1_000, "n<1K"},
+		{10_000, "1K<n<10K"},
+		{100_000, "10K<n<100K"},
+		{1_000_000, "100K<n<1M"},
+		{10_000_000, "1M<n<10M"},
+		{100_000_000, "10M<n<100M"},
+		{1_000_000_000, "100M<n<1B"},
+	}
+	for _, bound := range bounds {
+		if n < bound.limit {
+			return bound.name
+		}
+	}
+	return "1B<n<10B"
+}
+
+func percent(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
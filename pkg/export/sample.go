@@ -0,0 +1,292 @@
+package export
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// OtherLanguages is the language mix key covering every language the mix
+// doesn't name
+const OtherLanguages = "other"
+
+// Sampling limits how much of the export a single repository or language
+// can take. The zero value exports every matching file.
+type Sampling struct {
+	// MaxFilesPerRepo keeps a uniform random sample of at most this many
+	// files from each repository (0 for no limit)
+	MaxFilesPerRepo int `json:"max_files_per_repo,omitempty"`
+	// LanguageMix gives each language's share of the export, keyed by
+	// lowercased language; OtherLanguages covers the rest and languages
+	// without a share are left out. Each language takes its highest
+	// quality files, as many as the scarcest language allows.
+	LanguageMix map[string]float64 `json:"language_mix,omitempty"`
+	// Seed drives the per-repo samples, so reruns over the same files
+	// select the same ones
+	Seed int64 `json:"seed"`
+}
+
+// SampleStats records how sampling shaped an export
+type SampleStats struct {
+	Sampling
+	Candidates  int64 `json:"candidates"`   // files matching the filter
+	CappedRepos int   `json:"capped_repos"` // repos over MaxFilesPerRepo
+	Selected    int64 `json:"selected"`
+	// RealizedMix is each language's share of the selected files, keyed
+	// like LanguageMix when one is given, otherwise by lowercased language
+	RealizedMix map[string]float64 `json:"realized_mix"`
+}
+
+// Enabled reports whether any sampling applies
+func (s Sampling) Enabled() bool {
+	return s.MaxFilesPerRepo > 0 || len(s.LanguageMix) > 0
+}
+
+// Validate checks the limit is non-negative and the mix's shares are
+// non-negative and add up to 1
+func (s Sampling) Validate() error {
+	if s.MaxFilesPerRepo < 0 {
+		return fmt.Errorf("max files per repo must not be negative, got %d", s.MaxFilesPerRepo)
+	}
+	if len(s.LanguageMix) == 0 {
+		return nil
+	}
+	var sum float64
+	for language, share := range s.LanguageMix {
+		if share < 0 {
+			return fmt.Errorf("language mix share of %s must not be negative, got %v", language, share)
+		}
+		sum += share
+	}
+	if math.Abs(sum-1) > 1e-9 {
+		return fmt.Errorf("language mix shares must add up to 1, got %v (use %s for the remaining languages)", sum, OtherLanguages)
+	}
+	return nil
+}
+
+// ParseLanguageMix parses shares such as "python:0.3,go:0.2,other:0.5"
+func ParseLanguageMix(s string) (map[string]float64, error) {
+	mix := make(map[string]float64)
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		language, share, ok := strings.Cut(part, ":")
+		language = strings.ToLower(strings.TrimSpace(language))
+		if !ok || language == "" {
+			return nil, fmt.Errorf("invalid language mix entry %q: expected language:share", part)
+		}
+		r, err := strconv.ParseFloat(strings.TrimSpace(share), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid language mix entry %q: %w", part, err)
+		}
+		if _, dup := mix[language]; dup {
+			return nil, fmt.Errorf("language %s appears twice in the language mix", language)
+		}
+		mix[language] = r
+	}
+	if len(mix) == 0 {
+		return nil, nil
+	}
+	return mix, (Sampling{LanguageMix: mix}).Validate()
+}
+
+// candidate is a file the sampler may select
+type candidate struct {
+	id       int64
+	language string
+	quality  int64
+}
+
+// reservoir keeps a uniform sample of up to max of one repo's files
+// (algorithm R). Its random stream is derived from the seed and the repo
+// name only, so one repo's sample doesn't shift when another's files change.
+type reservoir struct {
+	kept []candidate
+	seen int
+	rng  *rand.Rand
+}
+
+func (r *reservoir) add(c candidate, max int, seed int64, repo string) {
+	r.seen++
+	if max <= 0 || len(r.kept) < max {
+		r.kept = append(r.kept, c)
+		return
+	}
+	if r.rng == nil {
+		h := fnv.New64a()
+		h.Write([]byte(repo))
+		r.rng = rand.New(rand.NewPCG(uint64(seed), h.Sum64()))
+	}
+	if j := r.rng.IntN(r.seen); j < max {
+		r.kept[j] = c
+	}
+}
+
+// sampler collects candidates in id order and selects the files to export
+type sampler struct {
+	opts       Sampling
+	repos      map[string]*reservoir
+	languages  map[string]string // interned language names
+	candidates int64
+}
+
+func newSampler(opts Sampling) *sampler {
+	return &sampler{opts: opts, repos: make(map[string]*reservoir), languages: make(map[string]string)}
+}
+
+func (s *sampler) add(repo, language string, id, quality int64) {
+	s.candidates++
+	interned, ok := s.languages[language]
+	if !ok {
+		interned = language
+		s.languages[language] = language
+	}
+	r := s.repos[repo]
+	if r == nil {
+		r = &reservoir{}
+		s.repos[repo] = r
+	}
+	r.add(candidate{id: id, language: interned, quality: quality}, s.opts.MaxFilesPerRepo, s.opts.Seed, repo)
+}
+
+// mixKey is the LanguageMix key language falls under, or "" if the mix
+// leaves it out
+func (s *sampler) mixKey(language string) string {
+	language = strings.ToLower(language)
+	if _, ok := s.opts.LanguageMix[language]; ok {
+		return language
+	}
+	if _, ok := s.opts.LanguageMix[OtherLanguages]; ok {
+		return OtherLanguages
+	}
+	return ""
+}
+
+// selectFiles returns the ids to export, ascending, and what was selected
+func (s *sampler) selectFiles() ([]int64, *SampleStats) {
+	stats := &SampleStats{Sampling: s.opts, Candidates: s.candidates, RealizedMix: make(map[string]float64)}
+
+	var pool []candidate
+	for _, r := range s.repos {
+		if s.opts.MaxFilesPerRepo > 0 && r.seen > s.opts.MaxFilesPerRepo {
+			stats.CappedRepos++
+		}
+		pool = append(pool, r.kept...)
+	}
+
+	key := func(c candidate) string { return strings.ToLower(c.language) }
+	if len(s.opts.LanguageMix) > 0 {
+		key = func(c candidate) string { return s.mixKey(c.language) }
+		pool = s.applyMix(pool)
+	}
+
+	ids := make([]int64, len(pool))
+	counts := make(map[string]int64)
+	for i, c := range pool {
+		ids[i] = c.id
+		counts[key(c)]++
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	stats.Selected = int64(len(ids))
+	for k, n := range counts {
+		stats.RealizedMix[k] = float64(n) / float64(stats.Selected)
+	}
+	return ids, stats
+}
+
+// applyMix picks each language's quota of its highest quality files. The
+// total is the largest the scarcest language can supply at its share, so
+// every share is met to within one file.
+func (s *sampler) applyMix(pool []candidate) []candidate {
+	groups := make(map[string][]candidate)
+	for _, c := range pool {
+		if k := s.mixKey(c.language); k != "" {
+			groups[k] = append(groups[k], c)
+		}
+	}
+
+	total := math.Inf(1)
+	for k, share := range s.opts.LanguageMix {
+		if share > 0 {
+			total = math.Min(total, float64(len(groups[k]))/share)
+		}
+	}
+
+	var selected []candidate
+	for k, group := range groups {
+		quota := int(math.Floor(s.opts.LanguageMix[k]*total + 1e-9))
+		if quota > len(group) {
+			quota = len(group)
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].quality != group[j].quality {
+				return group[i].quality > group[j].quality
+			}
+			return group[i].id < group[j].id
+		})
+		selected = append(selected, group[:quota]...)
+	}
+	return selected
+}
+
+// candidateQuery pages through the files matching the filter like
+// exportQuery, without their content
+const candidateQuery = `
+	SELECT f.id, f.repo_name, f.language, f.quality_score
+	FROM processed_files f
+	LEFT JOIN processing_jobs j ON j.id = f.job_id
+	WHERE f.id > $1
+	AND ` + exportFilter + `
+	ORDER BY f.id
+	LIMIT $7
+`
+
+// sampleFiles reads every candidate's id, repo, language and quality and
+// returns the ids sampling selects
+func sampleFiles(ctx context.Context, db *sql.DB, languages []string, filter Filter, opts Sampling, batchSize int) ([]int64, *SampleStats, error) {
+	s := newSampler(opts)
+	var lastID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		rows, err := db.QueryContext(ctx, candidateQuery,
+			lastID, filter.MinQuality, pq.Array(languages), filter.MaxSize, filter.IncludeForks, filter.IncludeRemoved, batchSize)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to query processed files: %w", err)
+		}
+		n := 0
+		for rows.Next() {
+			var id, quality int64
+			var repo, language string
+			if err := rows.Scan(&id, &repo, &language, &quality); err != nil {
+				rows.Close()
+				return nil, nil, err
+			}
+			s.add(repo, language, id, quality)
+			lastID = id
+			n++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+		if n == 0 {
+			break
+		}
+	}
+
+	ids, stats := s.selectFiles()
+	return ids, stats, nil
+}
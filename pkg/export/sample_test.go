@@ -0,0 +1,188 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"codelupe/pkg/contentstore"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestParseLanguageMix(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]float64
+		wantErr bool
+	}{
+		{"python:0.3,go:0.2,other:0.5", map[string]float64{"python": 0.3, "go": 0.2, "other": 0.5}, false},
+		{" Python : 0.5 , Go:0.5 ", map[string]float64{"python": 0.5, "go": 0.5}, false},
+		{"", nil, false},
+		{"python:0.3,go:0.2", nil, true},
+		{"python:0.5,python:0.5", nil, true},
+		{"python:1.5,go:-0.5", nil, true},
+		{"python", nil, true},
+		{"python:x", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseLanguageMix(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLanguageMix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseLanguageMix() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// addMonorepo feeds a sampler one repo of n files and a few small ones, in
+// id order as the candidate query returns them
+func addMonorepo(s *sampler, n int) {
+	id := int64(0)
+	for i := 0; i < n; i++ {
+		id++
+		s.add("big/monorepo", "Go", id, int64(i%100))
+		if i%1000 == 0 {
+			id++
+			s.add(fmt.Sprintf("small/repo%d", i/1000), "Python", id, 50)
+		}
+	}
+}
+
+func TestSampler_MaxFilesPerRepo(t *testing.T) {
+	opts := Sampling{MaxFilesPerRepo: 200, Seed: 7}
+
+	first := newSampler(opts)
+	addMonorepo(first, 20000)
+	ids, stats := first.selectFiles()
+
+	again := newSampler(opts)
+	addMonorepo(again, 20000)
+	if idsAgain, _ := again.selectFiles(); !reflect.DeepEqual(ids, idsAgain) {
+		t.Fatal("the same seed selected different files")
+	}
+
+	other := newSampler(Sampling{MaxFilesPerRepo: 200, Seed: 8})
+	addMonorepo(other, 20000)
+	if idsOther, _ := other.selectFiles(); reflect.DeepEqual(ids, idsOther) {
+		t.Error("a different seed selected the same files")
+	}
+
+	// The monorepo is cut to 200 and the 20 small repos are kept whole
+	if stats.Candidates != 20020 || stats.Selected != 220 || stats.CappedRepos != 1 {
+		t.Errorf("stats = %+v, want 220 of 20020 selected with 1 repo capped", stats)
+	}
+
+	// The sample is spread over the whole repo rather than its first files
+	var sum float64
+	monorepo := first.repos["big/monorepo"]
+	for _, c := range monorepo.kept {
+		sum += float64(c.id)
+	}
+	if mean := sum / float64(len(monorepo.kept)); mean < 0.4*20020 || mean > 0.6*20020 {
+		t.Errorf("mean sampled id = %.0f, want about %d", mean, 20020/2)
+	}
+}
+
+func TestSampler_LanguageMix(t *testing.T) {
+	s := newSampler(Sampling{LanguageMix: map[string]float64{"python": 0.5, "go": 0.3, "other": 0.2}})
+	id := int64(0)
+	for language, n := range map[string]int{"Python": 3000, "Go": 1000, "Rust": 500, "JavaScript": 2000} {
+		for i := 0; i < n; i++ {
+			id++
+			s.add(fmt.Sprintf("%s/repo%d", language, i%50), language, id, int64(i%100))
+		}
+	}
+	ids, stats := s.selectFiles()
+
+	// Go is the scarcest for its share, so it's taken whole
+	if stats.Selected != int64(len(ids)) || stats.Selected < 3300 || stats.Selected > 3334 {
+		t.Errorf("selected %d files, want about 3333", stats.Selected)
+	}
+	for language, want := range s.opts.LanguageMix {
+		if got := stats.RealizedMix[language]; math.Abs(got-want) > 0.005 {
+			t.Errorf("realized share of %s = %.4f, want %.2f", language, got, want)
+		}
+	}
+
+	// Each language's quota is its highest quality files
+	selected := make(map[int64]bool)
+	for _, id := range ids {
+		selected[id] = true
+	}
+	var minKept, maxDropped int64 = 100, -1
+	for _, r := range s.repos {
+		for _, c := range r.kept {
+			if c.language != "Python" {
+				continue
+			}
+			if selected[c.id] {
+				minKept = min(minKept, c.quality)
+			} else {
+				maxDropped = max(maxDropped, c.quality)
+			}
+		}
+	}
+	if maxDropped > minKept {
+		t.Errorf("dropped a Python file of quality %d while keeping one of %d", maxDropped, minKept)
+	}
+}
+
+func TestRun_Sampling(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	store, _ := contentstore.New(contentstore.ModeInline, "")
+
+	// The first pass reads the candidates without content
+	candidates := sqlmock.NewRows([]string{"id", "repo_name", "language", "quality_score"})
+	for id := 1; id <= 6; id++ {
+		candidates.AddRow(id, "big/repo", "Go", 80)
+	}
+	candidates.AddRow(7, "small/repo", "Go", 80)
+	mock.ExpectQuery("SELECT f.id, f.repo_name, f.language, f.quality_score").
+		WithArgs(int64(0), 0, sqlmock.AnyArg(), int64(0), false, false, 1000).
+		WillReturnRows(candidates)
+	mock.ExpectQuery("SELECT f.id, f.repo_name, f.language, f.quality_score").
+		WithArgs(int64(7), 0, sqlmock.AnyArg(), int64(0), false, false, 1000).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "repo_name", "language", "quality_score"}))
+
+	opts := Sampling{MaxFilesPerRepo: 2, Seed: 1}
+	s := newSampler(opts)
+	for id := int64(1); id <= 6; id++ {
+		s.add("big/repo", "Go", id, 80)
+	}
+	s.add("small/repo", "Go", 7, 80)
+	want, _ := s.selectFiles()
+
+	// The second fetches only the selected files
+	rows := sqlmock.NewRows(exportColumns)
+	for _, id := range want {
+		rows.AddRow(id, "big/repo", fmt.Sprintf("f%d.go", id), "Go", 80, 1, 10, "h", "package x", nil, nil, nil, nil, nil)
+	}
+	mock.ExpectQuery("WHERE f.id = ANY").WithArgs(pq.Array(want)).WillReturnRows(rows)
+
+	manifest, err := Run(context.Background(), db, store, Options{OutputDir: t.TempDir(), Sampling: opts})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if manifest.Total != 3 {
+		t.Errorf("exported %d files, want 3", manifest.Total)
+	}
+	if got := manifest.Sampling; got == nil || got.Candidates != 7 || got.Selected != 3 || got.MaxFilesPerRepo != 2 ||
+		got.Seed != 1 || got.RealizedMix["go"] != 1 {
+		t.Errorf("manifest sampling = %+v", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
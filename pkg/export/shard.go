@@ -0,0 +1,96 @@
+package export
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// shardWriter writes records to one output file
+type shardWriter interface {
+	Write(row map[string]any) error
+	Close() error
+}
+
+func newShardWriter(opts *Options, path string) (shardWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create shard %s: %w", path, err)
+	}
+
+	switch opts.Format {
+	case FormatParquet:
+		return &parquetShard{
+			file:   file,
+			writer: parquet.NewWriter(file, parquetSchema(opts), parquet.Compression(&parquet.Zstd)),
+		}, nil
+	default:
+		return &jsonlShard{file: file, buf: bufio.NewWriterSize(file, 1<<20)}, nil
+	}
+}
+
+type jsonlShard struct {
+	file *os.File
+	buf  *bufio.Writer
+}
+
+func (s *jsonlShard) Write(row map[string]any) error {
+	line, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	if _, err := s.buf.Write(line); err != nil {
+		return err
+	}
+	return s.buf.WriteByte('\n')
+}
+
+func (s *jsonlShard) Close() error {
+	if err := s.buf.Flush(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+type parquetShard struct {
+	file   *os.File
+	writer *parquet.Writer
+}
+
+func (s *parquetShard) Write(row map[string]any) error {
+	return s.writer.Write(row)
+}
+
+func (s *parquetShard) Close() error {
+	if err := s.writer.Close(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// parquetSchema builds the schema for the configured text field and meta
+// fields, nested under "meta" except in the Hugging Face layout
+func parquetSchema(opts *Options) *parquet.Schema {
+	meta := parquet.Group{}
+	for _, field := range opts.Meta {
+		switch field {
+		case "quality_score", "lines", "size", "start_line", "end_line":
+			meta[field] = parquet.Int(64)
+		default:
+			meta[field] = parquet.String()
+		}
+	}
+	if opts.HuggingFace {
+		meta[opts.TextField] = parquet.String()
+		return parquet.NewSchema("record", meta)
+	}
+	return parquet.NewSchema("record", parquet.Group{
+		opts.TextField: parquet.String(),
+		"meta":         meta,
+	})
+}
@@ -0,0 +1,68 @@
+package export
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Splits are the fractions of repositories assigned to train, val and test
+type Splits struct {
+	Train float64 `json:"train"`
+	Val   float64 `json:"val"`
+	Test  float64 `json:"test"`
+}
+
+// DefaultSplits is a 90/5/5 split
+var DefaultSplits = Splits{Train: 0.9, Val: 0.05, Test: 0.05}
+
+// ParseSplits parses "train,val,test" ratios such as "0.8,0.1,0.1"
+func ParseSplits(s string) (Splits, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 {
+		return Splits{}, fmt.Errorf("invalid splits %q: expected train,val,test", s)
+	}
+
+	var ratios [3]float64
+	for i, part := range parts {
+		r, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return Splits{}, fmt.Errorf("invalid splits %q: %w", s, err)
+		}
+		ratios[i] = r
+	}
+
+	splits := Splits{Train: ratios[0], Val: ratios[1], Test: ratios[2]}
+	return splits, splits.Validate()
+}
+
+// Validate checks the ratios are non-negative and add up to 1
+func (s Splits) Validate() error {
+	if s.Train < 0 || s.Val < 0 || s.Test < 0 {
+		return fmt.Errorf("split ratios must not be negative: %v/%v/%v", s.Train, s.Val, s.Test)
+	}
+	if sum := s.Train + s.Val + s.Test; math.Abs(sum-1) > 1e-9 {
+		return fmt.Errorf("split ratios must add up to 1, got %v", sum)
+	}
+	return nil
+}
+
+// For returns the split repo belongs to. It depends only on the repository
+// name, so every file of a repository lands in the same split and reruns
+// reproduce the same assignment.
+func (s Splits) For(repo string) string {
+	h := fnv.New64a()
+	h.Write([]byte(repo))
+	x := float64(h.Sum64()>>11) / (1 << 53) // uniform in [0, 1)
+
+	switch {
+	case x < s.Train:
+		return SplitTrain
+	case x < s.Train+s.Val:
+		return SplitVal
+	default:
+		return SplitTest
+	}
+}
@@ -0,0 +1,20 @@
+//go:build windows
+
+package fsutil
+
+import "golang.org/x/sys/windows"
+
+// Free reports the bytes available to the current user on the volume
+// holding path
+func Free(path string) (uint64, error) {
+	dir, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(dir, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return available, nil
+}
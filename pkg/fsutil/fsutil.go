@@ -0,0 +1,76 @@
+// Package fsutil provides portable filesystem measurements that work the
+// same on Linux and Windows hosts, without shelling out to du or wc.
+package fsutil
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DirSize returns the total size in bytes of the regular files under root.
+// Symlinks are not followed. With skipGit, .git directories are left out.
+// Unreadable entries below root are skipped, as du does.
+func DirSize(root string, skipGit bool) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.IsDir() {
+			if skipGit && d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if info, err := d.Info(); err == nil {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// lineBufferSize is how much of a file CountLines reads at a time
+const lineBufferSize = 32 * 1024
+
+// CountLines counts the newline characters in the file at path, like wc -l,
+// so a final line without a trailing newline isn't counted. The file is
+// streamed rather than loaded into memory.
+func CountLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return countNewlines(f)
+}
+
+func countNewlines(r io.Reader) (int, error) {
+	buf := make([]byte, lineBufferSize)
+	count := 0
+	for {
+		n, err := r.Read(buf)
+		count += bytes.Count(buf[:n], []byte{'\n'})
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package fsutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeFile(t testing.TB, path string, size int) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDirSize(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "main.go"), 100)
+	writeFile(t, filepath.Join(root, "pkg", "lib.go"), 250)
+	writeFile(t, filepath.Join(root, ".git", "objects", "pack"), 1000)
+
+	tests := []struct {
+		name    string
+		skipGit bool
+		want    int64
+	}{
+		{"including .git", false, 1350},
+		{"skipping .git", true, 350},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DirSize(root, tt.skipGit)
+			if err != nil {
+				t.Fatalf("DirSize() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("DirSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDirSize_IgnoresSymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "real.txt"), 100)
+	if err := os.Symlink(filepath.Join(root, "real.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	if got, err := DirSize(root, false); err != nil || got != 100 {
+		t.Errorf("DirSize() = %d, %v, want 100", got, err)
+	}
+}
+
+func TestDirSize_MissingRoot(t *testing.T) {
+	if _, err := DirSize(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Error("Expected error for a missing root")
+	}
+}
+
+func TestCountLines(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+	}{
+		{"empty", "", 0},
+		{"trailing newline", "a\nb\nc\n", 3},
+		{"no trailing newline", "a\nb\nc", 2},
+		{"crlf", "a\r\nb\r\n", 2},
+		{"larger than buffer", strings.Repeat("line\n", lineBufferSize), lineBufferSize},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := CountLines(path)
+			if err != nil {
+				t.Fatalf("CountLines() unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CountLines() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCountLines_MissingFile(t *testing.T) {
+	if _, err := CountLines(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestFree(t *testing.T) {
+	free, err := Free(t.TempDir())
+	if err != nil {
+		t.Fatalf("Free() unexpected error: %v", err)
+	}
+	if free == 0 {
+		t.Error("Expected some free space in the temp directory")
+	}
+}
+
+// syntheticRepo builds a tree shaped like a small clone: nested packages of
+// source files plus a .git directory
+func syntheticRepo(b *testing.B) string {
+	b.Helper()
+	root := b.TempDir()
+	for dir := 0; dir < 20; dir++ {
+		for file := 0; file < 25; file++ {
+			path := filepath.Join(root, fmt.Sprintf("pkg%d", dir), fmt.Sprintf("file%d.go", file))
+			writeFile(b, path, 2048)
+		}
+	}
+	writeFile(b, filepath.Join(root, ".git", "objects", "pack", "pack.pack"), 64*1024)
+	return root
+}
+
+// The du and wc benchmarks measure the implementations these functions
+// replaced, for comparison
+
+func BenchmarkDirSize(b *testing.B) {
+	root := syntheticRepo(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DirSize(root, false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDirSize_du(b *testing.B) {
+	if _, err := exec.LookPath("du"); err != nil {
+		b.Skip("du not installed")
+	}
+	root := syntheticRepo(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		out, err := exec.Command("du", "-sk", root).Output()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := strconv.Atoi(strings.Fields(string(out))[0]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkFiles(b *testing.B) []string {
+	b.Helper()
+	root := syntheticRepo(b)
+	var files []string
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err == nil && filepath.Ext(path) == ".go" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+func BenchmarkCountLines(b *testing.B) {
+	files := benchmarkFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, f := range files {
+			if _, err := CountLines(f); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkCountLines_wc(b *testing.B) {
+	if _, err := exec.LookPath("wc"); err != nil {
+		b.Skip("wc not installed")
+	}
+	files := benchmarkFiles(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, f := range files {
+			out, err := exec.Command("wc", "-l", f).Output()
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := strconv.Atoi(strings.Fields(string(out))[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+// Package ghclient is the HTTP client the crawler and downloader share for
+// GitHub. It sets the user agent and API token, and caches responses on
+// disk: a cached URL is revalidated with If-None-Match/If-Modified-Since,
+// so an unchanged page comes back as an empty 304, which GitHub doesn't
+// count against the API quota, and is served from the cache.
+package ghclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"codelupe/pkg/metrics"
+)
+
+// UserAgent identifies the pipeline to GitHub
+const UserAgent = "Mozilla/5.0 (compatible; CodeLupe/1.0)"
+
+// DefaultAPIHost is the host the token is sent to
+const DefaultAPIHost = "api.github.com"
+
+// MaxCachedBytes is the largest response body kept in the cache; bigger
+// ones, such as tarballs, are passed through untouched
+const MaxCachedBytes = 1 << 20
+
+// Client sends requests through HTTP, revalidating them against Cache
+type Client struct {
+	HTTP  *http.Client
+	Cache *Cache // nil disables caching
+	// Token is sent as "token <Token>" to APIHost only, so it never leaks
+	// to the web pages the crawler scrapes or to redirect targets
+	Token   string
+	APIHost string
+	// Name labels the cache metrics, e.g. "crawler"
+	Name string
+}
+
+// New returns a client for the public API
+func New(httpClient *http.Client, token string, cache *Cache, name string) *Client {
+	return &Client{HTTP: httpClient, Cache: cache, Token: token, APIHost: DefaultAPIHost, Name: name}
+}
+
+// With returns a copy of the client sending through httpClient, sharing the
+// cache, e.g. one per proxy
+func (c *Client) With(httpClient *http.Client) *Client {
+	clone := *c
+	clone.HTTP = httpClient
+	return &clone
+}
+
+// Do sends req. A GET whose URL is cached carries the cached validators; a
+// 304 answer is turned into the cached response, with the 304's headers
+// (such as the rate limit counters) over the cached ones.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	if c.Token != "" && req.URL.Host == c.APIHost && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "token "+c.Token)
+	}
+	if c.Cache == nil || req.Method != http.MethodGet {
+		return c.HTTP.Do(req)
+	}
+
+	key := cacheKey(req)
+	cached := c.Cache.load(key)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		c.count(&c.Cache.hits, "hit")
+		return cached.response(req, resp.Header), nil
+	}
+	c.count(&c.Cache.misses, "miss")
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || (etag == "" && lastModified == "") || resp.ContentLength > MaxCachedBytes {
+		return resp, nil
+	}
+
+	// Read up to the limit; a body that turns out bigger is passed on whole
+	// without being cached
+	head, err := io.ReadAll(io.LimitReader(resp.Body, MaxCachedBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(head) > MaxCachedBytes {
+		resp.Body = readCloser{io.MultiReader(bytes.NewReader(head), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(head))
+
+	entry := &entry{URL: req.URL.String(), ETag: etag, LastModified: lastModified,
+		Status: resp.StatusCode, Header: resp.Header, Body: head}
+	if err := c.Cache.store(key, entry); err != nil {
+		metrics.IncrCounterWithLabels("github_cache_errors_total", map[string]string{"client": c.Name}, 1)
+	}
+	return resp, nil
+}
+
+func (c *Client) count(counter *int64, result string) {
+	atomic.AddInt64(counter, 1)
+	metrics.IncrCounterWithLabels("github_cache_requests_total", map[string]string{"client": c.Name, "result": result}, 1)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// cacheKey identifies a response by URL and the headers that change it
+func cacheKey(req *http.Request) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%t", req.URL.String(), req.Header.Get("Accept"), req.Header.Get("Authorization") != "")
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Cache keeps responses in a directory, one JSON file per URL
+type Cache struct {
+	dir    string
+	hits   int64
+	misses int64
+}
+
+// OpenCache returns a cache in dir, creating it if needed. An empty dir or
+// "off" disables caching and returns nil.
+func OpenCache(dir string) (*Cache, error) {
+	if dir == "" || dir == "off" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create GitHub cache %s: %w", dir, err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Stats returns the requests answered from the cache and those that went
+// through to GitHub
+func (c *Cache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return atomic.LoadInt64(&c.hits), atomic.LoadInt64(&c.misses)
+}
+
+// entry is one cached response
+type entry struct {
+	URL          string      `json:"url"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last_modified,omitempty"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+}
+
+func (e *entry) response(req *http.Request, fresh http.Header) *http.Response {
+	header := e.Header.Clone()
+	for name, values := range fresh {
+		header[name] = values
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status)),
+		StatusCode:    e.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       req,
+	}
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// load returns the cached entry for key, or nil. A corrupt entry is treated
+// as missing and overwritten by the next response.
+func (c *Cache) load(key string) *entry {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil
+	}
+	var e entry
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil
+	}
+	return &e
+}
+
+// store writes the entry through a temporary file, so concurrent readers
+// never see half of it
+func (c *Cache) store(key string, e *entry) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".entry-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil && !errors.Is(err, fs.ErrExist) {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
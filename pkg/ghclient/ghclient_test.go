@@ -0,0 +1,169 @@
+package ghclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeGitHub serves one page with an ETag, answering 304 to a matching
+// If-None-Match, and records the conditional headers it was sent
+type fakeGitHub struct {
+	mu          sync.Mutex
+	etag        string
+	body        string
+	noneMatch   []string
+	authHeaders []string
+}
+
+func (f *fakeGitHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.noneMatch = append(f.noneMatch, r.Header.Get("If-None-Match"))
+	f.authHeaders = append(f.authHeaders, r.Header.Get("Authorization"))
+
+	w.Header().Set("X-RateLimit-Remaining", "4999")
+	if f.etag != "" && r.Header.Get("If-None-Match") == f.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("ETag", f.etag)
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, f.body)
+}
+
+func newTestClient(t *testing.T, server *httptest.Server) *Client {
+	t.Helper()
+	cache, err := OpenCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(server.URL)
+	client := New(server.Client(), "ghp_test", cache, "test")
+	client.APIHost = u.Host
+	return client
+}
+
+func get(t *testing.T, client *Client, target string) (*http.Response, string) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestDo_RevalidatesWithETag(t *testing.T) {
+	fake := &fakeGitHub{etag: `"v1"`, body: `{"language":"Go"}`}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	client := newTestClient(t, server)
+
+	resp, body := get(t, client, server.URL+"/repos/octo/widgets")
+	if resp.StatusCode != http.StatusOK || body != `{"language":"Go"}` {
+		t.Fatalf("first GET = %d %q", resp.StatusCode, body)
+	}
+
+	// The second request is conditional and the 304 is served from the cache
+	fake.mu.Lock()
+	fake.body = "changed, but the server says it isn't"
+	fake.mu.Unlock()
+	resp, body = get(t, client, server.URL+"/repos/octo/widgets")
+	if resp.StatusCode != http.StatusOK || body != `{"language":"Go"}` {
+		t.Errorf("revalidated GET = %d %q, want the cached 200", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || resp.Header.Get("X-RateLimit-Remaining") != "4999" {
+		t.Errorf("revalidated headers = %v, want the cached ones with the 304's", resp.Header)
+	}
+
+	if strings.Join(fake.noneMatch, ",") != `,"v1"` {
+		t.Errorf("If-None-Match sent = %q, want none then the ETag", fake.noneMatch)
+	}
+	if hits, misses := client.Cache.Stats(); hits != 1 || misses != 1 {
+		t.Errorf("Stats() = %d hits, %d misses, want 1 and 1", hits, misses)
+	}
+
+	// A new ETag replaces the cached body
+	fake.mu.Lock()
+	fake.etag, fake.body = `"v2"`, `{"language":"Rust"}`
+	fake.mu.Unlock()
+	if _, body = get(t, client, server.URL+"/repos/octo/widgets"); body != `{"language":"Rust"}` {
+		t.Errorf("GET after a change = %q", body)
+	}
+	if _, body = get(t, client, server.URL+"/repos/octo/widgets"); body != `{"language":"Rust"}` {
+		t.Errorf("cached GET after a change = %q", body)
+	}
+}
+
+func TestDo_TokenOnlyForAPIHost(t *testing.T) {
+	fake := &fakeGitHub{body: "<html></html>"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	client := newTestClient(t, server)
+	get(t, client, server.URL+"/")
+	client.APIHost = "api.github.com"
+	get(t, client, server.URL+"/")
+
+	if fake.authHeaders[0] != "token ghp_test" || fake.authHeaders[1] != "" {
+		t.Errorf("Authorization sent = %q, want the token for the API host only", fake.authHeaders)
+	}
+}
+
+func TestDo_SkipsUncacheableResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{"no validators", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "page")
+		}},
+		{"error status", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"e"`)
+			http.Error(w, "rate limited", http.StatusForbidden)
+		}},
+		{"too big", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"e"`)
+			io.WriteString(w, strings.Repeat("x", MaxCachedBytes+10))
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var conditional int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("If-None-Match") != "" {
+					conditional++
+				}
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+			client := newTestClient(t, server)
+
+			_, first := get(t, client, server.URL+"/big")
+			_, second := get(t, client, server.URL+"/big")
+			if conditional != 0 {
+				t.Errorf("sent %d conditional requests for an uncached response", conditional)
+			}
+			if first != second || first == "" {
+				t.Errorf("bodies = %d and %d bytes, want the whole body both times", len(first), len(second))
+			}
+		})
+	}
+}
+
+func TestOpenCache_Off(t *testing.T) {
+	for _, dir := range []string{"", "off"} {
+		cache, err := OpenCache(dir)
+		if cache != nil || err != nil {
+			t.Errorf("OpenCache(%q) = %v, %v, want nil", dir, cache, err)
+		}
+	}
+}
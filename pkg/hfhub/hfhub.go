@@ -0,0 +1,503 @@
+// Package hfhub pushes a directory to a Hugging Face hub dataset repository
+// through the hub's HTTP API. Parquet shards and other large files go
+// through Git LFS, those over MultipartThreshold in parts whose progress is
+// saved so an interrupted push picks up where it stopped; the rest are
+// committed inline.
+package hfhub
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultEndpoint is the public hub
+const DefaultEndpoint = "https://huggingface.co"
+
+// MultipartThreshold is the size above which a file is uploaded in parts
+const MultipartThreshold = 500 << 20
+
+// inlineMaxBytes is the largest file committed inline rather than via LFS
+const inlineMaxBytes = 1 << 20
+
+// lfsBatchSize is how many objects one LFS batch request asks about
+const lfsBatchSize = 100
+
+// StateFile records the parts of multipart uploads already sent. It lives
+// in the uploaded directory and is never uploaded itself.
+const StateFile = ".hf-upload-state.json"
+
+// Client talks to the hub as the owner of Token
+type Client struct {
+	Endpoint string
+	Token    string
+	HTTP     *http.Client
+}
+
+// New returns a client for the public hub
+func New(token string) *Client {
+	return &Client{
+		Endpoint: DefaultEndpoint,
+		Token:    token,
+		HTTP:     &http.Client{Timeout: 30 * time.Minute},
+	}
+}
+
+// CreateRepo creates the dataset repository repo ("owner/name") if it
+// doesn't exist yet
+func (c *Client) CreateRepo(ctx context.Context, repo string, private bool) error {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" {
+		return fmt.Errorf("invalid repository %q: expected owner/name", repo)
+	}
+	body := map[string]any{"type": "dataset", "organization": owner, "name": name, "private": private}
+	resp, err := c.do(ctx, http.MethodPost, c.Endpoint+"/api/repos/create", "application/json", jsonBody(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusConflict {
+		return nil // already exists
+	}
+	return checkStatus(resp, "create repository")
+}
+
+// file is one file to upload
+type file struct {
+	path string // relative, slash-separated
+	abs  string
+	size int64
+	oid  string // sha256, for LFS files
+}
+
+func (f *file) lfs() bool {
+	return f.size > inlineMaxBytes || strings.HasSuffix(f.path, ".parquet")
+}
+
+// UploadFolder commits every file under dir to revision of the dataset
+// repository repo in a single commit
+func (c *Client) UploadFolder(ctx context.Context, repo, revision, dir, message string) error {
+	files, err := listFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	// Only files over MultipartThreshold are offered the multipart transfer
+	var basic, multipart []*file
+	for _, f := range files {
+		if !f.lfs() {
+			continue
+		}
+		if f.oid, err = sha256File(f.abs); err != nil {
+			return err
+		}
+		if f.size > MultipartThreshold {
+			multipart = append(multipart, f)
+		} else {
+			basic = append(basic, f)
+		}
+	}
+	state, err := loadState(filepath.Join(dir, StateFile))
+	if err != nil {
+		return err
+	}
+	for _, group := range []struct {
+		files     []*file
+		transfers []string
+	}{{basic, []string{"basic"}}, {multipart, []string{"basic", "multipart"}}} {
+		for start := 0; start < len(group.files); start += lfsBatchSize {
+			batch := group.files[start:min(start+lfsBatchSize, len(group.files))]
+			if err := c.uploadLFS(ctx, repo, revision, batch, group.transfers, state); err != nil {
+				return err
+			}
+		}
+	}
+	return c.commit(ctx, repo, revision, message, files)
+}
+
+func listFiles(dir string) ([]*file, error) {
+	var files []*file
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != dir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, &file{path: filepath.ToSlash(rel), abs: path, size: info.Size()})
+		return nil
+	})
+	return files, err
+}
+
+func sha256File(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lfsAction is where and how to send an object, as returned by the batch API
+type lfsAction struct {
+	Href   string            `json:"href"`
+	Header map[string]string `json:"header"`
+}
+
+type lfsObject struct {
+	OID     string               `json:"oid"`
+	Size    int64                `json:"size"`
+	Actions map[string]lfsAction `json:"actions"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// uploadLFS sends the objects the hub doesn't have yet. An object with no
+// upload action is already stored, which is what makes a rerun after a
+// completed upload cheap.
+func (c *Client) uploadLFS(ctx context.Context, repo, revision string, files []*file, transfers []string, state *uploadState) error {
+	objects := make([]map[string]any, len(files))
+	byOID := make(map[string]*file, len(files))
+	for i, f := range files {
+		objects[i] = map[string]any{"oid": f.oid, "size": f.size}
+		byOID[f.oid] = f
+	}
+	req := map[string]any{
+		"operation": "upload",
+		"transfers": transfers,
+		"objects":   objects,
+		"hash_algo": "sha256",
+		"ref":       map[string]string{"name": "refs/heads/" + revision},
+	}
+	resp, err := c.do(ctx, http.MethodPost, c.Endpoint+"/datasets/"+repo+".git/info/lfs/objects/batch",
+		"application/vnd.git-lfs+json", jsonBody(req))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "LFS batch"); err != nil {
+		return err
+	}
+	var batch struct {
+		Objects []lfsObject `json:"objects"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return fmt.Errorf("invalid LFS batch response: %w", err)
+	}
+
+	for _, obj := range batch.Objects {
+		f := byOID[obj.OID]
+		if f == nil {
+			continue
+		}
+		if obj.Error != nil {
+			return fmt.Errorf("LFS batch rejected %s: %d %s", f.path, obj.Error.Code, obj.Error.Message)
+		}
+		upload, ok := obj.Actions["upload"]
+		if !ok {
+			continue
+		}
+		if _, multipart := upload.Header["chunk_size"]; multipart {
+			err = c.uploadParts(ctx, f, upload, state)
+		} else {
+			err = c.uploadBasic(ctx, f, upload)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", f.path, err)
+		}
+		if verify, ok := obj.Actions["verify"]; ok {
+			if err := c.verify(ctx, f, verify); err != nil {
+				return fmt.Errorf("failed to verify %s: %w", f.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Client) uploadBasic(ctx context.Context, f *file, action lfsAction) error {
+	body, err := os.Open(f.abs)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, action.Href, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = f.size
+	for name, value := range action.Header {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "upload")
+}
+
+// uploadParts sends a file in the chunk_size parts the batch response gave
+// presigned URLs for (header keys "1", "2", ...), then completes the upload
+// with their ETags. Each finished part is saved to state, so a rerun against
+// the same upload only sends the missing parts.
+func (c *Client) uploadParts(ctx context.Context, f *file, action lfsAction, state *uploadState) error {
+	chunkSize, err := strconv.ParseInt(action.Header["chunk_size"], 10, 64)
+	if err != nil || chunkSize <= 0 {
+		return fmt.Errorf("invalid chunk_size %q", action.Header["chunk_size"])
+	}
+	var partNumbers []int
+	for key := range action.Header {
+		if n, err := strconv.Atoi(key); err == nil {
+			partNumbers = append(partNumbers, n)
+		}
+	}
+	sort.Ints(partNumbers)
+
+	src, err := os.Open(f.abs)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	progress := state.start(f.oid, action.Href)
+	for _, n := range partNumbers {
+		if _, done := progress.ETags[n]; done {
+			continue
+		}
+		offset := int64(n-1) * chunkSize
+		length := min(chunkSize, f.size-offset)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, action.Header[strconv.Itoa(n)],
+			io.NewSectionReader(src, offset, length))
+		if err != nil {
+			return err
+		}
+		req.ContentLength = length
+		resp, err := c.HTTP.Do(req)
+		if err != nil {
+			return fmt.Errorf("part %d: %w", n, err)
+		}
+		resp.Body.Close()
+		if err := checkStatus(resp, fmt.Sprintf("part %d", n)); err != nil {
+			return err
+		}
+		if err := state.done(f.oid, n, resp.Header.Get("ETag")); err != nil {
+			return err
+		}
+	}
+
+	parts := make([]map[string]any, len(partNumbers))
+	for i, n := range partNumbers {
+		parts[i] = map[string]any{"partNumber": n, "etag": progress.ETags[n]}
+	}
+	resp, err := c.do(ctx, http.MethodPost, action.Href, "application/vnd.git-lfs+json",
+		jsonBody(map[string]any{"oid": f.oid, "parts": parts}))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp, "complete multipart upload"); err != nil {
+		return err
+	}
+	return state.finish(f.oid)
+}
+
+func (c *Client) verify(ctx context.Context, f *file, action lfsAction) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, action.Href,
+		jsonBody(map[string]any{"oid": f.oid, "size": f.size}))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.git-lfs+json")
+	for name, value := range action.Header {
+		req.Header.Set(name, value)
+	}
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "verify")
+}
+
+// commit creates one commit adding every file: LFS files by pointer, the
+// rest inline
+func (c *Client) commit(ctx context.Context, repo, revision, message string, files []*file) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.Encode(map[string]any{"key": "header", "value": map[string]string{"summary": message, "description": ""}})
+	for _, f := range files {
+		if f.lfs() {
+			enc.Encode(map[string]any{"key": "lfsFile", "value": map[string]any{
+				"path": f.path, "algo": "sha256", "oid": f.oid, "size": f.size,
+			}})
+			continue
+		}
+		content, err := os.ReadFile(f.abs)
+		if err != nil {
+			return err
+		}
+		enc.Encode(map[string]any{"key": "file", "value": map[string]string{
+			"path": f.path, "encoding": "base64", "content": base64.StdEncoding.EncodeToString(content),
+		}})
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, fmt.Sprintf("%s/api/datasets/%s/commit/%s", c.Endpoint, repo, revision),
+		"application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkStatus(resp, "commit")
+}
+
+// do sends an authenticated request to the hub
+func (c *Client) do(ctx context.Context, method, url, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", contentType)
+	if strings.HasPrefix(contentType, "application/vnd.git-lfs") {
+		req.Header.Set("Accept", contentType)
+	}
+	return c.HTTP.Do(req)
+}
+
+func jsonBody(v any) io.Reader {
+	data, _ := json.Marshal(v)
+	return bytes.NewReader(data)
+}
+
+func checkStatus(resp *http.Response, what string) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s returned %s: %s", what, resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// uploadState is the multipart progress saved in StateFile
+type uploadState struct {
+	path string
+	mu   sync.Mutex
+	// Uploads maps an object id to its upload in progress
+	Uploads map[string]*partProgress `json:"uploads"`
+}
+
+type partProgress struct {
+	// Upload identifies the upload the parts belong to; a new one means the
+	// hub started over and the saved parts are useless
+	Upload string         `json:"upload"`
+	ETags  map[int]string `json:"etags"`
+}
+
+// uploadID identifies a multipart upload by its completion URL's uploadId,
+// since the rest of the URL is re-signed on every batch request
+func uploadID(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		if id := u.Query().Get("uploadId"); id != "" {
+			return id
+		}
+	}
+	return href
+}
+
+func loadState(path string) (*uploadState, error) {
+	state := &uploadState{path: path, Uploads: make(map[string]*partProgress)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("invalid upload state %s: %w", path, err)
+	}
+	if state.Uploads == nil {
+		state.Uploads = make(map[string]*partProgress)
+	}
+	return state, nil
+}
+
+// start returns the saved progress of oid's upload completed at href,
+// forgetting any from a different upload
+func (s *uploadState) start(oid, href string) *partProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	progress := s.Uploads[oid]
+	if progress == nil || progress.Upload != uploadID(href) {
+		progress = &partProgress{Upload: uploadID(href), ETags: make(map[int]string)}
+		s.Uploads[oid] = progress
+	}
+	return progress
+}
+
+func (s *uploadState) done(oid string, part int, etag string) error {
+	s.mu.Lock()
+	s.Uploads[oid].ETags[part] = etag
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *uploadState) finish(oid string) error {
+	s.mu.Lock()
+	delete(s.Uploads, oid)
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *uploadState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.Uploads) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
@@ -0,0 +1,236 @@
+package hfhub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeHub plays the hub: the LFS batch API, presigned part URLs, multipart
+// completion and the commit API
+type fakeHub struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu        sync.Mutex
+	stored    map[string]bool // LFS objects the hub has
+	chunkSize int             // > 0 answers every upload with multipart
+	failPart  int             // part number whose next PUT fails
+	parts     []int           // part numbers received, in order
+	basic     []string        // oids uploaded whole
+	commit    []map[string]any
+	created   map[string]any
+}
+
+func newFakeHub(t *testing.T) *fakeHub {
+	h := &fakeHub{t: t, stored: make(map[string]bool)}
+	h.server = httptest.NewServer(http.HandlerFunc(h.serve))
+	t.Cleanup(h.server.Close)
+	return h
+}
+
+func (h *fakeHub) serve(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if strings.HasPrefix(r.URL.Path, "/api/") && r.Header.Get("Authorization") != "Bearer hf_test" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.URL.Path == "/api/repos/create":
+		if h.created != nil {
+			http.Error(w, "exists", http.StatusConflict)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&h.created)
+
+	case strings.HasSuffix(r.URL.Path, ".git/info/lfs/objects/batch"):
+		var req struct {
+			Transfers []string `json:"transfers"`
+			Objects   []struct {
+				OID  string `json:"oid"`
+				Size int64  `json:"size"`
+			} `json:"objects"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		var objects []map[string]any
+		for _, obj := range req.Objects {
+			entry := map[string]any{"oid": obj.OID, "size": obj.Size}
+			if !h.stored[obj.OID] {
+				entry["actions"] = map[string]any{"upload": h.uploadAction(obj.OID, obj.Size)}
+			}
+			objects = append(objects, entry)
+		}
+		json.NewEncoder(w).Encode(map[string]any{"objects": objects})
+
+	case strings.HasPrefix(r.URL.Path, "/upload/"):
+		oid := strings.TrimPrefix(r.URL.Path, "/upload/")
+		io.Copy(io.Discard, r.Body)
+		h.basic = append(h.basic, oid)
+		h.stored[oid] = true
+
+	case strings.HasPrefix(r.URL.Path, "/part/"):
+		var n int
+		fmt.Sscanf(r.URL.Query().Get("partNumber"), "%d", &n)
+		body, _ := io.ReadAll(r.Body)
+		if n == h.failPart {
+			h.failPart = 0
+			http.Error(w, "connection reset", http.StatusBadGateway)
+			return
+		}
+		h.parts = append(h.parts, n)
+		w.Header().Set("ETag", fmt.Sprintf(`"etag-%d-%d"`, n, len(body)))
+
+	case strings.HasPrefix(r.URL.Path, "/complete/"):
+		var req struct {
+			OID   string `json:"oid"`
+			Parts []struct {
+				PartNumber int    `json:"partNumber"`
+				ETag       string `json:"etag"`
+			} `json:"parts"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		for _, part := range req.Parts {
+			if part.ETag == "" {
+				http.Error(w, "missing etag", http.StatusBadRequest)
+				return
+			}
+		}
+		h.stored[req.OID] = true
+
+	case strings.HasPrefix(r.URL.Path, "/api/datasets/acme/code/commit/main"):
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(nil, 1<<20)
+		h.commit = nil
+		for scanner.Scan() {
+			var line map[string]any
+			json.Unmarshal(scanner.Bytes(), &line)
+			h.commit = append(h.commit, line)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"commitUrl": h.server.URL + "/datasets/acme/code/commit/abc"})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *fakeHub) uploadAction(oid string, size int64) map[string]any {
+	if h.chunkSize == 0 {
+		return map[string]any{"href": h.server.URL + "/upload/" + oid}
+	}
+	header := map[string]string{"chunk_size": fmt.Sprint(h.chunkSize)}
+	for n := 1; int64(n-1)*int64(h.chunkSize) < size; n++ {
+		// Signatures change on every batch request; the upload id doesn't
+		header[fmt.Sprint(n)] = fmt.Sprintf("%s/part/%s?partNumber=%d&sig=%d", h.server.URL, oid, n, len(h.parts))
+	}
+	return map[string]any{
+		"href":   fmt.Sprintf("%s/complete/%s?uploadId=u-%s&sig=%d", h.server.URL, oid, oid[:8], len(h.parts)),
+		"header": header,
+	}
+}
+
+func writeDataset(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "data"), 0755)
+	os.WriteFile(filepath.Join(dir, "README.md"), []byte("---\nlicense: mit\n---\n# acme/code\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "data", "train-00000-of-00001.parquet"), []byte(strings.Repeat("PAR1", 100)), 0644)
+	return dir
+}
+
+func newTestClient(h *fakeHub) *Client {
+	return &Client{Endpoint: h.server.URL, Token: "hf_test", HTTP: h.server.Client()}
+}
+
+func TestUploadFolder(t *testing.T) {
+	hub := newFakeHub(t)
+	dir := writeDataset(t)
+	client := newTestClient(hub)
+
+	if err := client.CreateRepo(context.Background(), "acme/code", true); err != nil {
+		t.Fatalf("CreateRepo() error = %v", err)
+	}
+	if err := client.CreateRepo(context.Background(), "acme/code", true); err != nil {
+		t.Errorf("CreateRepo() of an existing repo error = %v, want nil", err)
+	}
+	if hub.created["organization"] != "acme" || hub.created["name"] != "code" || hub.created["type"] != "dataset" {
+		t.Errorf("create request = %v", hub.created)
+	}
+
+	if err := client.UploadFolder(context.Background(), "acme/code", "main", dir, "Export 5 files"); err != nil {
+		t.Fatalf("UploadFolder() error = %v", err)
+	}
+	if len(hub.basic) != 1 {
+		t.Errorf("uploaded %d LFS objects, want the parquet shard", len(hub.basic))
+	}
+
+	// Header, the card inline and the shard by pointer
+	if len(hub.commit) != 3 {
+		t.Fatalf("commit = %v, want 3 lines", hub.commit)
+	}
+	keys := map[string]map[string]any{}
+	for _, line := range hub.commit {
+		value, _ := line["value"].(map[string]any)
+		keys[line["key"].(string)] = value
+	}
+	if keys["header"]["summary"] != "Export 5 files" {
+		t.Errorf("commit header = %v", keys["header"])
+	}
+	if keys["file"]["path"] != "README.md" || keys["file"]["encoding"] != "base64" {
+		t.Errorf("inline file = %v", keys["file"])
+	}
+	if keys["lfsFile"]["path"] != "data/train-00000-of-00001.parquet" || keys["lfsFile"]["size"] != float64(400) {
+		t.Errorf("lfs file = %v", keys["lfsFile"])
+	}
+
+	// A second push finds the object stored and uploads nothing
+	if err := client.UploadFolder(context.Background(), "acme/code", "main", dir, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if len(hub.basic) != 1 {
+		t.Errorf("re-push uploaded %d objects, want 0 more", len(hub.basic)-1)
+	}
+}
+
+func TestUploadFolder_ResumesMultipart(t *testing.T) {
+	hub := newFakeHub(t)
+	hub.chunkSize = 100 // the 400 byte shard goes in 4 parts
+	hub.failPart = 3
+	dir := writeDataset(t)
+	client := newTestClient(hub)
+
+	if err := client.UploadFolder(context.Background(), "acme/code", "main", dir, "Export"); err == nil {
+		t.Fatal("UploadFolder() should fail when a part fails")
+	}
+	if _, err := os.Stat(filepath.Join(dir, StateFile)); err != nil {
+		t.Fatalf("no upload state saved: %v", err)
+	}
+	if hub.commit != nil {
+		t.Error("committed despite the failed upload")
+	}
+
+	if err := client.UploadFolder(context.Background(), "acme/code", "main", dir, "Export"); err != nil {
+		t.Fatalf("resumed UploadFolder() error = %v", err)
+	}
+	if fmt.Sprint(hub.parts) != "[1 2 3 4]" {
+		t.Errorf("parts sent = %v, want 1 and 2 once, then 3 and 4 on resume", hub.parts)
+	}
+	if _, err := os.Stat(filepath.Join(dir, StateFile)); !os.IsNotExist(err) {
+		t.Errorf("upload state left behind after completing: %v", err)
+	}
+	for _, line := range hub.commit {
+		if value, _ := line["value"].(map[string]any); value["path"] == StateFile {
+			t.Error("upload state was committed")
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package langdetect works out which language a source file is written in.
+// The name decides when it can: a known extension or a well-known filename
+// such as Makefile. Files without one are recognized by their shebang line,
+// and extensions shared between languages (.h, .m) are settled by scoring
+// keywords in the content.
+package langdetect
+
+import (
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// extensions maps lowercased file extensions to languages. .h and .m map to
+// their most common language; Detect refines them from content.
+var extensions = map[string]string{
+	".py":    "Python",
+	".pyw":   "Python",
+	".pyi":   "Python",
+	".js":    "JavaScript",
+	".mjs":   "JavaScript",
+	".cjs":   "JavaScript",
+	".jsx":   "JavaScript",
+	".ts":    "TypeScript",
+	".tsx":   "TypeScript",
+	".mts":   "TypeScript",
+	".java":  "Java",
+	".c":     "C",
+	".h":     "C",
+	".cpp":   "C++",
+	".cc":    "C++",
+	".cxx":   "C++",
+	".hpp":   "C++",
+	".hh":    "C++",
+	".hxx":   "C++",
+	".cs":    "C#",
+	".php":   "PHP",
+	".rb":    "Ruby",
+	".go":    "Go",
+	".rs":    "Rust",
+	".swift": "Swift",
+	".kt":    "Kotlin",
+	".kts":   "Kotlin",
+	".scala": "Scala",
+	".sh":    "Shell",
+	".bash":  "Shell",
+	".zsh":   "Shell",
+	".sql":   "SQL",
+	".r":     "R",
+	".m":     "Objective-C",
+	".mm":    "Objective-C",
+	".pl":    "Perl",
+	".pm":    "Perl",
+	".lua":   "Lua",
+	".dart":  "Dart",
+	".vim":   "Vim",
+	".sol":   "Solidity",
+	".asm":   "Assembly",
+	".s":     "Assembly",
+	".ps1":   "PowerShell",
+	".hs":    "Haskell",
+}
+
+// filenames maps well-known lowercased file names to languages
+var filenames = map[string]string{
+	"makefile":       "Makefile",
+	"gnumakefile":    "Makefile",
+	"dockerfile":     "Dockerfile",
+	"containerfile":  "Dockerfile",
+	"cmakelists.txt": "CMake",
+	"rakefile":       "Ruby",
+	"gemfile":        "Ruby",
+	"vagrantfile":    "Ruby",
+	"jenkinsfile":    "Groovy",
+	"build.bazel":    "Starlark",
+	"workspace":      "Starlark",
+}
+
+// interpreters maps shebang interpreters, with version suffixes removed, to
+// languages
+var interpreters = map[string]string{
+	"python":  "Python",
+	"pypy":    "Python",
+	"node":    "JavaScript",
+	"nodejs":  "JavaScript",
+	"deno":    "JavaScript",
+	"bun":     "JavaScript",
+	"ts-node": "TypeScript",
+	"tsx":     "TypeScript",
+	"sh":      "Shell",
+	"bash":    "Shell",
+	"zsh":     "Shell",
+	"dash":    "Shell",
+	"ksh":     "Shell",
+	"ruby":    "Ruby",
+	"perl":    "Perl",
+	"php":     "PHP",
+	"lua":     "Lua",
+	"luajit":  "Lua",
+	"rscript": "R",
+	"pwsh":    "PowerShell",
+	"make":    "Makefile",
+	"swift":   "Swift",
+}
+
+// keyword patterns that tell apart languages sharing an extension
+var (
+	cppKeywords    = regexp.MustCompile(`\bclass\s+\w+|\bnamespace\b|\btemplate\s*<|\bstd::|\b(public|private|protected)\s*:|\bvirtual\b|\bnullptr\b|\bconstexpr\b|#include\s*<(iostream|vector|string|memory|map)>`)
+	objcKeywords   = regexp.MustCompile(`@(interface|implementation|protocol|property|end|synthesize)\b|#import\b|\[\s*self\s|\bNS[A-Z]\w+`)
+	matlabKeywords = regexp.MustCompile(`(?m)^\s*(function\b.*=|end\s*$|%)|\b(disp|zeros|ones|fprintf|numel|plot)\s*\(`)
+)
+
+// sampleSize bounds how much content is scored
+const sampleSize = 16 * 1024
+
+// FromName returns the language a file's name implies, or "" if the name
+// says nothing. It never reads the file.
+func FromName(path string) string {
+	base := strings.ToLower(filepath.Base(path))
+	if lang, ok := filenames[base]; ok {
+		return lang
+	}
+	if strings.HasPrefix(base, "dockerfile.") || strings.HasSuffix(base, ".dockerfile") {
+		return "Dockerfile"
+	}
+	return extensions[filepath.Ext(base)]
+}
+
+// Candidate reports whether path might be source code, so a walker can
+// decide whether to read it: either the name is known, or the file has no
+// extension and could be a shebang script.
+func Candidate(path string) bool {
+	return FromName(path) != "" || filepath.Ext(path) == ""
+}
+
+// Detect returns the language of a file from its path and content, or ""
+// if it isn't recognized as source code
+func Detect(path string, content []byte) string {
+	if len(content) > sampleSize {
+		content = content[:sampleSize]
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".h":
+		return disambiguateHeader(content)
+	case ".m":
+		return disambiguateM(content)
+	}
+	if lang := FromName(path); lang != "" {
+		return lang
+	}
+	return fromShebang(content)
+}
+
+// fromShebang maps a leading #! line to a language
+func fromShebang(content []byte) string {
+	if !bytes.HasPrefix(content, []byte("#!")) {
+		return ""
+	}
+	line := content[2:]
+	if i := bytes.IndexByte(line, '\n'); i >= 0 {
+		line = line[:i]
+	}
+
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return ""
+	}
+	interpreter := filepath.Base(fields[0])
+	if interpreter == "env" {
+		// #!/usr/bin/env [-S] [VAR=value...] python3 -u
+		interpreter = ""
+		for _, arg := range fields[1:] {
+			if strings.HasPrefix(arg, "-") || strings.Contains(arg, "=") {
+				continue
+			}
+			interpreter = filepath.Base(arg)
+			break
+		}
+	}
+
+	// python3.11 -> python
+	interpreter = strings.ToLower(strings.TrimRight(interpreter, "0123456789."))
+	return interpreters[interpreter]
+}
+
+// disambiguateHeader picks C, C++ or Objective-C for a .h file
+func disambiguateHeader(content []byte) string {
+	cpp := len(cppKeywords.FindAllIndex(content, -1))
+	objc := len(objcKeywords.FindAllIndex(content, -1))
+	switch {
+	case objc > 0 && objc >= cpp:
+		return "Objective-C"
+	case cpp > 0:
+		return "C++"
+	default:
+		return "C"
+	}
+}
+
+// disambiguateM picks Objective-C or MATLAB for a .m file
+func disambiguateM(content []byte) string {
+	objc := len(objcKeywords.FindAllIndex(content, -1))
+	matlab := len(matlabKeywords.FindAllIndex(content, -1))
+	if matlab > objc {
+		return "MATLAB"
+	}
+	return "Objective-C"
+}
@@ -0,0 +1,92 @@
+package langdetect
+
+import "testing"
+
+var samples = []struct {
+	name    string
+	path    string
+	content string
+	want    string
+}{
+	{"go by extension", "main.go", "package main\n\nfunc main() {}\n", "Go"},
+	{"python by extension", "app/models.py", "class User:\n    pass\n", "Python"},
+	{"uppercase extension", "LEGACY.PY", "print('hi')\n", "Python"},
+	{"typescript", "src/app.component.ts", "export class AppComponent {}\n", "TypeScript"},
+	{"rust", "src/lib.rs", "pub fn add(a: i32, b: i32) -> i32 { a + b }\n", "Rust"},
+	{"makefile", "Makefile", "build:\n\tgo build ./...\n", "Makefile"},
+	{"gnu makefile", "sub/GNUmakefile", "all:\n\t$(CC) -o x x.c\n", "Makefile"},
+	{"dockerfile", "Dockerfile", "FROM golang:1.24\nRUN go build\n", "Dockerfile"},
+	{"dockerfile variant", "docker/Dockerfile.processor", "FROM alpine\n", "Dockerfile"},
+	{"cmake", "CMakeLists.txt", "cmake_minimum_required(VERSION 3.10)\n", "CMake"},
+	{"gemfile", "Gemfile", "source 'https://rubygems.org'\n", "Ruby"},
+	{"env python shebang", "bin/manage", "#!/usr/bin/env python3\nimport sys\n", "Python"},
+	{"versioned python shebang", "tools/run", "#!/usr/bin/python3.11 -u\nprint(1)\n", "Python"},
+	{"bash shebang", "scripts/deploy", "#!/bin/bash\nset -euo pipefail\n", "Shell"},
+	{"env -S node shebang", "cli", "#!/usr/bin/env -S node --no-warnings\nconsole.log(1)\n", "JavaScript"},
+	{"env with variable", "hook", "#!/usr/bin/env LC_ALL=C perl -w\nprint 1;\n", "Perl"},
+	{"shebang on unknown extension", "index.cgi", "#!/usr/bin/ruby\nputs 'x'\n", "Ruby"},
+	{"extension beats shebang", "setup.sh", "#!/usr/bin/env python\n", "Shell"},
+	{"c header", "include/list.h", "#ifndef LIST_H\n#define LIST_H\nstruct node { int v; struct node *next; };\nvoid list_free(struct node *n);\n#endif\n", "C"},
+	{"c++ header", "include/vec.h", "#pragma once\n#include <vector>\nnamespace geo {\ntemplate <typename T>\nclass Vec {\npublic:\n  std::vector<T> data;\n};\n}\n", "C++"},
+	{"objective-c header", "Classes/AppDelegate.h", "#import <UIKit/UIKit.h>\n@interface AppDelegate : UIResponder\n@property (strong, nonatomic) UIWindow *window;\n@end\n", "Objective-C"},
+	{"objective-c implementation", "Classes/AppDelegate.m", "#import \"AppDelegate.h\"\n@implementation AppDelegate\n- (void)run { [self start]; }\n@end\n", "Objective-C"},
+	{"matlab", "analysis/smooth.m", "function y = smooth(x)\n% moving average\ny = zeros(size(x));\nfor i = 2:numel(x)\n  y(i) = (x(i-1) + x(i)) / 2;\nend\nend\n", "MATLAB"},
+	{"plain text without extension", "LICENSE", "MIT License\n\nPermission is hereby granted...\n", ""},
+	{"unknown extension", "notes.txt", "just some notes\n", ""},
+	{"unknown shebang", "script", "#!/usr/bin/env unknown-interp\n", ""},
+	{"empty shebang", "weird", "#!\n", ""},
+}
+
+func TestDetect(t *testing.T) {
+	for _, tt := range samples {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Detect(tt.path, []byte(tt.content)); got != tt.want {
+				t.Errorf("Detect(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect_Accuracy(t *testing.T) {
+	correct := 0
+	for _, tt := range samples {
+		if Detect(tt.path, []byte(tt.content)) == tt.want {
+			correct++
+		}
+	}
+
+	accuracy := float64(correct) / float64(len(samples))
+	t.Logf("detection accuracy: %d/%d (%.1f%%)", correct, len(samples), accuracy*100)
+	if accuracy < 0.95 {
+		t.Errorf("accuracy %.1f%% below 95%%", accuracy*100)
+	}
+}
+
+func TestCandidate(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"main.go", true},
+		{"Makefile", true},
+		{"bin/manage", true}, // no extension: may be a script
+		{"README.md", false},
+		{"data.json", false},
+		{"image.png", false},
+	}
+
+	for _, tt := range tests {
+		if got := Candidate(tt.path); got != tt.want {
+			t.Errorf("Candidate(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFromName(t *testing.T) {
+	if got := FromName("include/vec.h"); got != "C" {
+		t.Errorf("FromName(.h) = %q, want the C default", got)
+	}
+	if got := FromName("bin/manage"); got != "" {
+		t.Errorf("FromName(no extension) = %q, want empty", got)
+	}
+}
@@ -0,0 +1,253 @@
+// Package license identifies repository licenses by SPDX id and filters
+// repositories by license for the training corpus.
+package license
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// None is recorded for repositories without a license file
+	None = "NONE"
+	// NoAssertion is recorded when a license file exists but isn't recognised,
+	// matching what the GitHub API reports for "Other"
+	NoAssertion = "NOASSERTION"
+)
+
+// maxLicenseBytes is how much of each license file is read; every license
+// we recognise identifies itself well within this
+const maxLicenseBytes = 64 * 1024
+
+// rule recognises one license. The rule whose title appears earliest in the
+// text wins, so a GPL-3.0 text mentioning the AGPL in section 13 is still
+// GPL-3.0. Every entry in also must be present too.
+type rule struct {
+	id    string
+	title string
+	also  []string
+}
+
+// rules are checked in order when titles tie, so more specific variants
+// come first
+var rules = []rule{
+	{"AGPL-3.0", "gnu affero general public license", nil},
+	{"LGPL-3.0", "gnu lesser general public license", []string{"version 3"}},
+	{"LGPL-2.1", "gnu lesser general public license", nil},
+	{"LGPL-2.0", "gnu library general public license", nil},
+	{"GPL-3.0", "gnu general public license", []string{"version 3"}},
+	{"GPL-2.0", "gnu general public license", []string{"version 2"}},
+	{"Apache-2.0", "apache license", []string{"version 2.0"}},
+	{"MPL-2.0", "mozilla public license", []string{"2.0"}},
+	{"EPL-2.0", "eclipse public license", []string{"2.0"}},
+	{"EPL-1.0", "eclipse public license", nil},
+	{"BSL-1.0", "boost software license", nil},
+	{"Unlicense", "this is free and unencumbered software released into the public domain", nil},
+	{"CC0-1.0", "cc0 1.0 universal", nil},
+	{"MIT", "permission is hereby granted, free of charge", nil},
+	{"ISC", "permission to use, copy, modify, and", []string{"distribute this software for any purpose with or without fee"}},
+	{"BSD-3-Clause", "redistribution and use in source and binary forms", []string{"neither the name"}},
+	{"BSD-2-Clause", "redistribution and use in source and binary forms", nil},
+	{"Zlib", "this software is provided 'as-is'", []string{"altered source versions must be plainly marked"}},
+}
+
+var spdxTagPattern = regexp.MustCompile(`(?i)SPDX-License-Identifier:\s*([A-Za-z0-9.+-]+)`)
+
+// Match returns the SPDX id of the license in text, or NoAssertion if it
+// isn't one we recognise. An explicit SPDX-License-Identifier tag wins.
+func Match(text string) string {
+	if m := spdxTagPattern.FindStringSubmatch(text); m != nil {
+		return m[1]
+	}
+
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+
+	best, bestAt := NoAssertion, -1
+	for _, r := range rules {
+		at := strings.Index(normalized, r.title)
+		if at < 0 || (bestAt >= 0 && at >= bestAt) {
+			continue
+		}
+
+		matched := true
+		for _, phrase := range r.also {
+			if !strings.Contains(normalized, phrase) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			best, bestAt = r.id, at
+		}
+	}
+
+	return best
+}
+
+// isLicenseFile reports whether name is a conventional license file name:
+// LICENSE, LICENCE, COPYING or UNLICENSE, with any suffix
+func isLicenseFile(name string) bool {
+	upper := strings.ToUpper(name)
+	for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE"} {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect identifies the license of the repository checked out in dir from
+// its top-level license files. It returns None when there are none and
+// NoAssertion when none of them is recognised.
+func Detect(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isLicenseFile(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	if len(files) == 0 {
+		return None, nil
+	}
+
+	// Shortest first, so LICENSE is preferred over LICENSE-THIRD-PARTY
+	sort.Slice(files, func(i, j int) bool {
+		if len(files[i]) != len(files[j]) {
+			return len(files[i]) < len(files[j])
+		}
+		return files[i] < files[j]
+	})
+
+	for _, name := range files {
+		text, err := readHead(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		if id := Match(text); id != NoAssertion {
+			return id, nil
+		}
+	}
+
+	return NoAssertion, nil
+}
+
+func readHead(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxLicenseBytes))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Filter decides which licenses are admitted to the dataset. Patterns are
+// SPDX ids compared case-insensitively; a trailing "*" matches any suffix,
+// so "GPL-*" covers GPL-2.0-only and GPL-3.0-or-later (but not LGPL or AGPL).
+type Filter struct {
+	allowed []string
+	blocked []string
+}
+
+// NewFilter builds a filter from comma-separated allowed and blocked lists.
+// Blocked licenses are always rejected; if an allowed list is given, only
+// licenses on it pass. Both empty admits everything.
+func NewFilter(allowed, blocked string) (*Filter, error) {
+	f := &Filter{}
+	var err error
+	if f.allowed, err = parsePatterns(allowed); err != nil {
+		return nil, fmt.Errorf("allowed licenses: %w", err)
+	}
+	if f.blocked, err = parsePatterns(blocked); err != nil {
+		return nil, fmt.Errorf("blocked licenses: %w", err)
+	}
+	return f, nil
+}
+
+// FromEnv builds a filter from ALLOWED_LICENSES and BLOCKED_LICENSES
+func FromEnv() (*Filter, error) {
+	return NewFilter(os.Getenv("ALLOWED_LICENSES"), os.Getenv("BLOCKED_LICENSES"))
+}
+
+func parsePatterns(list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		p = strings.ToUpper(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+		if strings.Contains(strings.TrimSuffix(p, "*"), "*") {
+			return nil, fmt.Errorf("%q: \"*\" is only supported at the end", p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// Enabled reports whether the filter rejects anything. A nil filter is
+// disabled.
+func (f *Filter) Enabled() bool {
+	return f != nil && (len(f.allowed) > 0 || len(f.blocked) > 0)
+}
+
+// Allows reports whether a repository with the given SPDX id may be used,
+// and if not, why. An empty id is treated as NoAssertion.
+func (f *Filter) Allows(id string) (bool, string) {
+	if !f.Enabled() {
+		return true, ""
+	}
+	if id == "" {
+		id = NoAssertion
+	}
+
+	if matchesAny(f.blocked, id) {
+		return false, fmt.Sprintf("license %s is blocked", id)
+	}
+	if len(f.allowed) > 0 && !matchesAny(f.allowed, id) {
+		return false, fmt.Sprintf("license %s is not allowed", id)
+	}
+	return true, ""
+}
+
+func matchesAny(patterns []string, id string) bool {
+	id = strings.ToUpper(id)
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(id, prefix) {
+				return true
+			}
+		} else if p == id {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes the filter for startup logs
+func (f *Filter) String() string {
+	if !f.Enabled() {
+		return "all licenses allowed"
+	}
+	var parts []string
+	if len(f.allowed) > 0 {
+		parts = append(parts, "allowed="+strings.Join(f.allowed, ","))
+	}
+	if len(f.blocked) > 0 {
+		parts = append(parts, "blocked="+strings.Join(f.blocked, ","))
+	}
+	return strings.Join(parts, " ")
+}
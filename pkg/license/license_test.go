@@ -0,0 +1,179 @@
+package license
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "MIT",
+			text: "MIT License\n\nCopyright (c) 2024 Someone\n\nPermission is hereby granted, free of charge, to any person obtaining a copy",
+			want: "MIT",
+		},
+		{
+			name: "Apache with wrapped title",
+			text: "\n                                 Apache License\n                           Version 2.0, January 2004\n",
+			want: "Apache-2.0",
+		},
+		{
+			name: "GPL-3.0 mentioning the AGPL later",
+			text: "GNU GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007\n...\n13. Use with the GNU Affero General Public License.",
+			want: "GPL-3.0",
+		},
+		{
+			name: "GPL-2.0 or later notice",
+			text: "This program is free software; you can redistribute it under the terms of the GNU General Public License\nas published by the Free Software Foundation; either version 2 of the License, or (at your option) any later version.",
+			want: "GPL-2.0",
+		},
+		{
+			name: "AGPL",
+			text: "GNU AFFERO GENERAL PUBLIC LICENSE\nVersion 3, 19 November 2007\n... version 3 of the GNU General Public License",
+			want: "AGPL-3.0",
+		},
+		{
+			name: "LGPL-3.0 referencing the GPL",
+			text: "GNU LESSER GENERAL PUBLIC LICENSE\nVersion 3, 29 June 2007\nThis version of the GNU Lesser General Public License incorporates the terms of version 3 of the GNU General Public License",
+			want: "LGPL-3.0",
+		},
+		{
+			name: "BSD-3-Clause",
+			text: "Redistribution and use in source and binary forms, with or without modification, are permitted...\n3. Neither the name of the copyright holder nor the names",
+			want: "BSD-3-Clause",
+		},
+		{
+			name: "BSD-2-Clause",
+			text: "Redistribution and use in source and binary forms, with or without modification, are permitted provided that",
+			want: "BSD-2-Clause",
+		},
+		{
+			name: "ISC",
+			text: "Permission to use, copy, modify, and/or distribute this software for any purpose with or without fee is hereby granted",
+			want: "ISC",
+		},
+		{
+			name: "SPDX tag wins",
+			text: "// SPDX-License-Identifier: MPL-2.0\nPermission is hereby granted, free of charge",
+			want: "MPL-2.0",
+		},
+		{
+			name: "unrecognised",
+			text: "All rights reserved. Do not copy.",
+			want: NoAssertion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Match(tt.text); got != tt.want {
+				t.Errorf("Match() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	write := func(t *testing.T, dir, name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("no license file", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "main.go", "package main")
+
+		if got, err := Detect(dir); err != nil || got != None {
+			t.Errorf("Detect() = %q, %v, want %q", got, err, None)
+		}
+	})
+
+	t.Run("COPYING", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "COPYING", "GNU GENERAL PUBLIC LICENSE\nVersion 2, June 1991")
+
+		if got, err := Detect(dir); err != nil || got != "GPL-2.0" {
+			t.Errorf("Detect() = %q, %v, want GPL-2.0", got, err)
+		}
+	})
+
+	t.Run("prefers main license file", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "LICENSE-THIRD-PARTY", "GNU GENERAL PUBLIC LICENSE\nVersion 3")
+		write(t, dir, "LICENSE.md", "Permission is hereby granted, free of charge")
+
+		if got, err := Detect(dir); err != nil || got != "MIT" {
+			t.Errorf("Detect() = %q, %v, want MIT", got, err)
+		}
+	})
+
+	t.Run("unrecognised license file", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "LICENSE", "Proprietary. All rights reserved.")
+
+		if got, err := Detect(dir); err != nil || got != NoAssertion {
+			t.Errorf("Detect() = %q, %v, want %q", got, err, NoAssertion)
+		}
+	})
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed string
+		blocked string
+		id      string
+		want    bool
+	}{
+		{"disabled", "", "", "GPL-3.0", true},
+		{"blocked exact", "", "GPL-3.0", "GPL-3.0", false},
+		{"blocked case-insensitive", "", "gpl-3.0", "GPL-3.0", false},
+		{"blocked wildcard", "", "GPL-*, AGPL-*", "GPL-3.0-or-later", false},
+		{"wildcard does not match LGPL", "", "GPL-*", "LGPL-2.1", true},
+		{"not blocked", "", "GPL-*", "MIT", true},
+		{"allowed", "MIT,Apache-2.0", "", "Apache-2.0", true},
+		{"not in allowed", "MIT,Apache-2.0", "", "MPL-2.0", false},
+		{"unknown with allow list", "MIT", "", "", false},
+		{"unknown with block list", "", "GPL-*", NoAssertion, true},
+		{"blocked beats allowed", "GPL-*", "GPL-2.0", "GPL-2.0", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := NewFilter(tt.allowed, tt.blocked)
+			if err != nil {
+				t.Fatalf("NewFilter() unexpected error: %v", err)
+			}
+			got, reason := f.Allows(tt.id)
+			if got != tt.want {
+				t.Errorf("Allows(%q) = %v (%s), want %v", tt.id, got, reason, tt.want)
+			}
+			if !got && reason == "" {
+				t.Error("Expected a reason for a rejected license")
+			}
+		})
+	}
+}
+
+func TestNewFilter_InvalidPattern(t *testing.T) {
+	if _, err := NewFilter("", "G*PL"); err == nil {
+		t.Error("Expected error for a wildcard in the middle of a pattern")
+	}
+}
+
+func TestFilter_NilIsDisabled(t *testing.T) {
+	var f *Filter
+	if f.Enabled() {
+		t.Error("nil filter should be disabled")
+	}
+	if ok, _ := f.Allows("GPL-3.0"); !ok {
+		t.Error("nil filter should allow everything")
+	}
+}
@@ -2,87 +2,314 @@ package metrics
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-// Metrics holds application metrics
+// maxWindow is how many recent observations a histogram keeps for quantiles
+const maxWindow = 1000
+
+// Metrics holds application metrics. Each metric name maps to its series,
+// keyed by their rendered label set ("" for the unlabeled series).
 type Metrics struct {
-	mu               sync.RWMutex
-	counters         map[string]int64
-	gauges           map[string]float64
-	histograms       map[string][]float64
-	lastUpdate       time.Time
+	mu         sync.RWMutex
+	counters   map[string]map[string]int64
+	gauges     map[string]map[string]float64
+	histograms map[string]map[string]*histogram
+	buckets    map[string][]float64
+	lastUpdate time.Time
+}
+
+// histogram tracks one histogram series. Without buckets it is exposed as a
+// summary: quantiles over the last maxWindow observations plus the
+// all-time count and sum. With buckets it is exposed as a Prometheus
+// histogram.
+type histogram struct {
+	window []float64
+	count  uint64
+	sum    float64
+
+	upperBounds []float64
+	bucketCount []uint64 // observations <= upperBounds[i], not cumulative
 }
 
 // NewMetrics creates a new Metrics instance
 func NewMetrics() *Metrics {
 	return &Metrics{
-		counters:   make(map[string]int64),
-		gauges:     make(map[string]float64),
-		histograms: make(map[string][]float64),
+		counters:   make(map[string]map[string]int64),
+		gauges:     make(map[string]map[string]float64),
+		histograms: make(map[string]map[string]*histogram),
+		buckets:    make(map[string][]float64),
 		lastUpdate: time.Now(),
 	}
 }
 
 // IncrCounter increments a counter metric
 func (m *Metrics) IncrCounter(name string, value int64) {
+	m.IncrCounterWithLabels(name, nil, value)
+}
+
+// IncrCounterWithLabels increments the series of a counter with the given
+// labels
+func (m *Metrics) IncrCounterWithLabels(name string, labels map[string]string, value int64) {
+	key := renderLabels(labels)
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.counters[name] += value
+	if m.counters[name] == nil {
+		m.counters[name] = make(map[string]int64)
+	}
+	m.counters[name][key] += value
 	m.lastUpdate = time.Now()
 }
 
 // SetGauge sets a gauge metric
 func (m *Metrics) SetGauge(name string, value float64) {
+	m.SetGaugeWithLabels(name, nil, value)
+}
+
+// SetGaugeWithLabels sets the series of a gauge with the given labels
+func (m *Metrics) SetGaugeWithLabels(name string, labels map[string]string, value float64) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gauges[name] == nil {
+		m.gauges[name] = make(map[string]float64)
+	}
+	m.gauges[name][key] = value
+	m.lastUpdate = time.Now()
+}
+
+// AddGauge adjusts a gauge by delta, for values like in-flight work that
+// go up and down
+func (m *Metrics) AddGauge(name string, delta float64) {
+	m.AddGaugeWithLabels(name, nil, delta)
+}
+
+// AddGaugeWithLabels adjusts the series of a gauge with the given labels
+func (m *Metrics) AddGaugeWithLabels(name string, labels map[string]string, delta float64) {
+	key := renderLabels(labels)
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.gauges[name] = value
+	if m.gauges[name] == nil {
+		m.gauges[name] = make(map[string]float64)
+	}
+	m.gauges[name][key] += delta
 	m.lastUpdate = time.Now()
 }
 
 // ObserveHistogram adds an observation to a histogram
 func (m *Metrics) ObserveHistogram(name string, value float64) {
+	m.ObserveHistogramWithLabels(name, nil, value)
+}
+
+// ObserveHistogramWithLabels adds an observation to the series of a
+// histogram with the given labels
+func (m *Metrics) ObserveHistogramWithLabels(name string, labels map[string]string, value float64) {
+	key := renderLabels(labels)
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.histograms[name] = append(m.histograms[name], value)
-	// Keep last 1000 observations
-	if len(m.histograms[name]) > 1000 {
-		m.histograms[name] = m.histograms[name][len(m.histograms[name])-1000:]
+	if m.histograms[name] == nil {
+		m.histograms[name] = make(map[string]*histogram)
 	}
+	h := m.histograms[name][key]
+	if h == nil {
+		h = newHistogram(m.buckets[name])
+		m.histograms[name][key] = h
+	}
+	h.observe(value)
 	m.lastUpdate = time.Now()
 }
 
-// GetMetrics returns all metrics as a formatted string
+// SetHistogramBuckets makes name a bucketed Prometheus histogram with the
+// given upper bounds instead of a summary of quantiles. Observations
+// already made for name are discarded, so call it at startup.
+func (m *Metrics) SetHistogramBuckets(name string, upperBounds []float64) {
+	bounds := make([]float64, 0, len(upperBounds))
+	for _, b := range upperBounds {
+		if !math.IsInf(b, +1) && !math.IsNaN(b) {
+			bounds = append(bounds, b)
+		}
+	}
+	sort.Float64s(bounds)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.buckets[name] = bounds
+	delete(m.histograms, name)
+}
+
+func newHistogram(upperBounds []float64) *histogram {
+	h := &histogram{upperBounds: upperBounds}
+	if upperBounds != nil {
+		h.bucketCount = make([]uint64, len(upperBounds))
+	}
+	return h
+}
+
+func (h *histogram) observe(value float64) {
+	h.count++
+	h.sum += value
+	if h.upperBounds != nil {
+		if i := sort.SearchFloat64s(h.upperBounds, value); i < len(h.upperBounds) {
+			h.bucketCount[i]++
+		}
+		return
+	}
+
+	h.window = append(h.window, value)
+	if len(h.window) > maxWindow {
+		h.window = h.window[len(h.window)-maxWindow:]
+	}
+}
+
+// GetMetrics returns all metrics in the Prometheus text exposition format
 func (m *Metrics) GetMetrics() string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	result := "# HELP Metrics\n"
-	result += fmt.Sprintf("# Last updated: %s\n\n", m.lastUpdate.Format(time.RFC3339))
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Last updated: %s\n", m.lastUpdate.Format(time.RFC3339))
+
+	for _, name := range sortedKeys(m.counters) {
+		fmt.Fprintf(&b, "# TYPE %s counter\n", name)
+		for _, labels := range sortedKeys(m.counters[name]) {
+			fmt.Fprintf(&b, "%s%s %d\n", name, wrap(labels), m.counters[name][labels])
+		}
+	}
+
+	for _, name := range sortedKeys(m.gauges) {
+		fmt.Fprintf(&b, "# TYPE %s gauge\n", name)
+		for _, labels := range sortedKeys(m.gauges[name]) {
+			fmt.Fprintf(&b, "%s%s %.2f\n", name, wrap(labels), m.gauges[name][labels])
+		}
+	}
+
+	for _, name := range sortedKeys(m.histograms) {
+		series := m.histograms[name]
+		if _, bucketed := m.buckets[name]; bucketed {
+			fmt.Fprintf(&b, "# TYPE %s histogram\n", name)
+		} else {
+			fmt.Fprintf(&b, "# TYPE %s summary\n", name)
+		}
+		for _, labels := range sortedKeys(series) {
+			series[labels].write(&b, name, labels)
+		}
+	}
+
+	return b.String()
+}
+
+// write renders the series' samples; labels is its rendered label set
+func (h *histogram) write(b *strings.Builder, name, labels string) {
+	if h.upperBounds != nil {
+		var cumulative uint64
+		for i, bound := range h.upperBounds {
+			cumulative += h.bucketCount[i]
+			fmt.Fprintf(b, "%s_bucket%s %d\n", name, wrap(join(labels, `le="`+formatFloat(bound)+`"`)), cumulative)
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", name, wrap(join(labels, `le="+Inf"`)), h.count)
+	} else {
+		sorted := append([]float64(nil), h.window...)
+		sort.Float64s(sorted)
+		for _, q := range quantiles {
+			fmt.Fprintf(b, "%s%s %.4f\n", name, wrap(join(labels, fmt.Sprintf(`quantile="%g"`, q))), quantile(sorted, q))
+		}
+	}
+	fmt.Fprintf(b, "%s_sum%s %.4f\n", name, wrap(labels), h.sum)
+	fmt.Fprintf(b, "%s_count%s %d\n", name, wrap(labels), h.count)
+}
+
+// quantiles reported for every histogram without buckets
+var quantiles = []float64{0.5, 0.95, 0.99}
 
-	result += "# Counters\n"
-	for name, value := range m.counters {
-		result += fmt.Sprintf("%s %d\n", name, value)
+// quantile returns the q-th quantile of sorted using the nearest-rank method
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(q*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
 	}
+	return sorted[rank]
+}
 
-	result += "\n# Gauges\n"
-	for name, value := range m.gauges {
-		result += fmt.Sprintf("%s %.2f\n", name, value)
+// renderLabels renders labels as name="value" pairs sorted by name, the
+// form they take inside braces in the exposition. Invalid characters in
+// names are replaced with underscores and values are escaped.
+func renderLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, sanitizeLabelName(name)+`="`+escapeLabelValue(value)+`"`)
 	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
 
-	result += "\n# Histograms (count)\n"
-	for name, values := range m.histograms {
-		result += fmt.Sprintf("%s_count %d\n", name, len(values))
+// sanitizeLabelName makes name match [a-zA-Z_][a-zA-Z0-9_]*
+func sanitizeLabelName(name string) string {
+	if name == "" {
+		return "_"
 	}
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
 
-	return result
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+// join appends an extra name="value" pair to a rendered label set
+func join(labels, pair string) string {
+	if labels == "" {
+		return pair
+	}
+	return labels + "," + pair
+}
+
+// wrap puts a rendered label set in braces, or returns "" when it's empty
+func wrap(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 // ServeHTTP implements http.Handler for exposing metrics
 func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
 	fmt.Fprint(w, m.GetMetrics())
 }
 
@@ -94,16 +321,47 @@ func IncrCounter(name string, value int64) {
 	globalMetrics.IncrCounter(name, value)
 }
 
+// IncrCounterWithLabels increments a global counter series
+func IncrCounterWithLabels(name string, labels map[string]string, value int64) {
+	globalMetrics.IncrCounterWithLabels(name, labels, value)
+}
+
 // SetGauge sets a global gauge
 func SetGauge(name string, value float64) {
 	globalMetrics.SetGauge(name, value)
 }
 
+// SetGaugeWithLabels sets a global gauge series
+func SetGaugeWithLabels(name string, labels map[string]string, value float64) {
+	globalMetrics.SetGaugeWithLabels(name, labels, value)
+}
+
+// AddGauge adjusts a global gauge by delta
+func AddGauge(name string, delta float64) {
+	globalMetrics.AddGauge(name, delta)
+}
+
+// AddGaugeWithLabels adjusts a global gauge series by delta
+func AddGaugeWithLabels(name string, labels map[string]string, delta float64) {
+	globalMetrics.AddGaugeWithLabels(name, labels, delta)
+}
+
 // ObserveHistogram adds a global histogram observation
 func ObserveHistogram(name string, value float64) {
 	globalMetrics.ObserveHistogram(name, value)
 }
 
+// ObserveHistogramWithLabels adds an observation to a global histogram
+// series
+func ObserveHistogramWithLabels(name string, labels map[string]string, value float64) {
+	globalMetrics.ObserveHistogramWithLabels(name, labels, value)
+}
+
+// SetHistogramBuckets gives a global histogram Prometheus buckets
+func SetHistogramBuckets(name string, upperBounds []float64) {
+	globalMetrics.SetHistogramBuckets(name, upperBounds)
+}
+
 // Handler returns the metrics HTTP handler
 func Handler() http.Handler {
 	return globalMetrics
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestGetMetrics_HistogramQuantiles(t *testing.T) {
+	m := NewMetrics()
+	for i := 1; i <= 100; i++ {
+		m.ObserveHistogram("clone_duration_seconds", float64(i))
+	}
+
+	out := m.GetMetrics()
+	for _, want := range []string{
+		"clone_duration_seconds_count 100\n",
+		"clone_duration_seconds_sum 5050.0000\n",
+		`clone_duration_seconds{quantile="0.5"} 50.0000`,
+		`clone_duration_seconds{quantile="0.95"} 95.0000`,
+		`clone_duration_seconds{quantile="0.99"} 99.0000`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	tests := []struct {
+		name   string
+		sorted []float64
+		q      float64
+		want   float64
+	}{
+		{"empty", nil, 0.95, 0},
+		{"single", []float64{7}, 0.95, 7},
+		{"median", []float64{1, 2, 3, 4}, 0.5, 2},
+		{"p95 of few", []float64{1, 2, 3}, 0.95, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quantile(tt.sorted, tt.q); got != tt.want {
+				t.Errorf("quantile() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddGauge(t *testing.T) {
+	m := NewMetrics()
+	m.AddGauge("active_clones", 1)
+	m.AddGauge("active_clones", 1)
+	m.AddGauge("active_clones", -1)
+
+	if out := m.GetMetrics(); !strings.Contains(out, "active_clones 1.00\n") {
+		t.Errorf("Expected active_clones 1.00 in output:\n%s", out)
+	}
+}
+
+// parseExposition parses out with the Prometheus text parser, failing the
+// test when it isn't valid exposition format
+func parseExposition(t *testing.T, out string) map[string]*dto.MetricFamily {
+	t.Helper()
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(out))
+	if err != nil {
+		t.Fatalf("invalid exposition format: %v\n%s", err, out)
+	}
+	return families
+}
+
+// labelsOf returns a metric's labels as a map
+func labelsOf(metric *dto.Metric) map[string]string {
+	labels := map[string]string{}
+	for _, pair := range metric.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	return labels
+}
+
+func TestGetMetrics_ExpositionFormat(t *testing.T) {
+	m := NewMetrics()
+	m.IncrCounter("repos_total", 3)
+	m.IncrCounterWithLabels("scrapes_total", map[string]string{"outcome": "success", "term": "rust"}, 2)
+	m.IncrCounterWithLabels("scrapes_total", map[string]string{"term": "rust", "outcome": "success"}, 1)
+	m.IncrCounterWithLabels("scrapes_total", map[string]string{"outcome": "error", "term": "go"}, 1)
+	m.SetGaugeWithLabels("queue_depth", map[string]string{"queue": "clone"}, 4)
+	m.AddGaugeWithLabels("queue_depth", map[string]string{"queue": "clone"}, -1)
+	m.ObserveHistogramWithLabels("scrape_duration_seconds", map[string]string{"outcome": "success"}, 0.5)
+	m.ObserveHistogramWithLabels("scrape_duration_seconds", map[string]string{"outcome": "success"}, 1.5)
+
+	families := parseExposition(t, m.GetMetrics())
+
+	if got := families["repos_total"].GetMetric()[0].GetCounter().GetValue(); got != 3 {
+		t.Errorf("repos_total = %v, want 3", got)
+	}
+
+	scrapes := families["scrapes_total"]
+	if scrapes.GetType() != dto.MetricType_COUNTER || len(scrapes.GetMetric()) != 2 {
+		t.Fatalf("scrapes_total = %v", scrapes)
+	}
+	for _, metric := range scrapes.GetMetric() {
+		labels := labelsOf(metric)
+		want := map[string]float64{"success": 3, "error": 1}[labels["outcome"]]
+		if metric.GetCounter().GetValue() != want {
+			t.Errorf("scrapes_total%v = %v, want %v", labels, metric.GetCounter().GetValue(), want)
+		}
+	}
+
+	if got := families["queue_depth"].GetMetric()[0].GetGauge().GetValue(); got != 3 {
+		t.Errorf("queue_depth = %v, want 3", got)
+	}
+
+	summary := families["scrape_duration_seconds"]
+	if summary.GetType() != dto.MetricType_SUMMARY {
+		t.Fatalf("scrape_duration_seconds type = %v, want summary", summary.GetType())
+	}
+	s := summary.GetMetric()[0]
+	if labelsOf(s)["outcome"] != "success" || s.GetSummary().GetSampleCount() != 2 || s.GetSummary().GetSampleSum() != 2 {
+		t.Errorf("scrape_duration_seconds = %v", s)
+	}
+	if len(s.GetSummary().GetQuantile()) != len(quantiles) {
+		t.Errorf("quantiles = %v", s.GetSummary().GetQuantile())
+	}
+}
+
+func TestGetMetrics_LabelEscaping(t *testing.T) {
+	m := NewMetrics()
+	value := "C:\\repos\n\"quoted\""
+	m.IncrCounterWithLabels("files_total", map[string]string{"path": value, "bad-name": "x", "0lead": "y"}, 1)
+
+	families := parseExposition(t, m.GetMetrics())
+
+	labels := labelsOf(families["files_total"].GetMetric()[0])
+	if labels["path"] != value {
+		t.Errorf("path label = %q, want %q", labels["path"], value)
+	}
+	if labels["bad_name"] != "x" || labels["_lead"] != "y" {
+		t.Errorf("labels = %v, want sanitized names", labels)
+	}
+}
+
+func TestSetHistogramBuckets(t *testing.T) {
+	m := NewMetrics()
+	m.ObserveHistogram("clone_duration_seconds", 100) // discarded by SetHistogramBuckets
+	m.SetHistogramBuckets("clone_duration_seconds", []float64{10, 1, 5, math.Inf(+1)})
+	for _, v := range []float64{0.5, 1, 3, 7, 60} {
+		m.ObserveHistogramWithLabels("clone_duration_seconds", map[string]string{"host": "github"}, v)
+	}
+
+	out := m.GetMetrics()
+	families := parseExposition(t, out)
+
+	family := families["clone_duration_seconds"]
+	if family.GetType() != dto.MetricType_HISTOGRAM {
+		t.Fatalf("type = %v, want histogram", family.GetType())
+	}
+	h := family.GetMetric()[0].GetHistogram()
+	if h.GetSampleCount() != 5 || h.GetSampleSum() != 71.5 {
+		t.Errorf("count = %d, sum = %v", h.GetSampleCount(), h.GetSampleSum())
+	}
+
+	want := map[float64]uint64{1: 2, 5: 3, 10: 4, math.Inf(+1): 5}
+	if len(h.GetBucket()) != len(want) {
+		t.Fatalf("buckets = %v", h.GetBucket())
+	}
+	for _, bucket := range h.GetBucket() {
+		if bucket.GetCumulativeCount() != want[bucket.GetUpperBound()] {
+			t.Errorf("bucket le=%v = %d, want %d", bucket.GetUpperBound(), bucket.GetCumulativeCount(), want[bucket.GetUpperBound()])
+		}
+	}
+	if !strings.Contains(out, `clone_duration_seconds_bucket{host="github",le="+Inf"} 5`) {
+		t.Errorf("missing +Inf bucket:\n%s", out)
+	}
+}
+
+func TestGetMetrics_UnlabeledUnchanged(t *testing.T) {
+	m := NewMetrics()
+	m.IncrCounter("downloads_total", 2)
+	m.SetGauge("active_clones", 1)
+
+	out := m.GetMetrics()
+	for _, want := range []string{"downloads_total 2\n", "active_clones 1.00\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in output:\n%s", want, out)
+		}
+	}
+	parseExposition(t, out)
+}
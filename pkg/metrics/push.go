@@ -0,0 +1,212 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultPushInterval is how often a Pusher pushes when
+// METRICS_PUSH_INTERVAL isn't set
+const defaultPushInterval = 15 * time.Second
+
+// Pusher periodically pushes a registry to a Prometheus Pushgateway so
+// runs that finish before Prometheus scrapes them still report. A nil
+// Pusher does nothing, so callers don't need to check whether pushing is
+// configured.
+type Pusher struct {
+	metrics  *Metrics
+	endpoint string
+	interval time.Duration
+	client   *http.Client
+
+	startOnce sync.Once
+	started   atomic.Bool
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// NewPusher returns a Pusher sending m to the Pushgateway at gatewayURL
+// under the given job and instance labels
+func NewPusher(m *Metrics, gatewayURL, job, instance string, interval time.Duration) (*Pusher, error) {
+	if job == "" {
+		return nil, fmt.Errorf("pushgateway job name is required")
+	}
+	u, err := url.Parse(gatewayURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid pushgateway URL %q", gatewayURL)
+	}
+	if interval <= 0 {
+		interval = defaultPushInterval
+	}
+
+	endpoint := strings.TrimSuffix(u.String(), "/") + "/metrics" +
+		groupingKey("job", job) + groupingKey("instance", instance)
+
+	return &Pusher{
+		metrics:  m,
+		endpoint: endpoint,
+		interval: interval,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// NewPusherFromEnv returns a Pusher for the global registry configured by
+// METRICS_PUSH_URL, METRICS_PUSH_INSTANCE (default the hostname) and
+// METRICS_PUSH_INTERVAL, or nil when METRICS_PUSH_URL isn't set
+func NewPusherFromEnv(job string) (*Pusher, error) {
+	gatewayURL := os.Getenv("METRICS_PUSH_URL")
+	if gatewayURL == "" {
+		return nil, nil
+	}
+
+	instance := os.Getenv("METRICS_PUSH_INSTANCE")
+	if instance == "" {
+		instance, _ = os.Hostname()
+	}
+
+	interval := defaultPushInterval
+	if raw := os.Getenv("METRICS_PUSH_INTERVAL"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid METRICS_PUSH_INTERVAL %q: must be a positive duration like 15s", raw)
+		}
+		interval = d
+	}
+
+	return NewPusher(globalMetrics, gatewayURL, job, instance, interval)
+}
+
+// groupingKey renders one label of the Pushgateway grouping key path.
+// Values that can't appear in a path segment are base64 encoded.
+func groupingKey(name, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+		if encoded == "" {
+			encoded = "="
+		}
+		return "/" + name + "@base64/" + encoded
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
+
+// Push sends the current metrics once
+func (p *Pusher) Push(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint,
+		bytes.NewBufferString(p.metrics.GetMetrics()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Start pushes every interval until Stop is called. Failed pushes are
+// reported to onError, which may be nil.
+func (p *Pusher) Start(onError func(error)) {
+	if p == nil {
+		return
+	}
+
+	p.startOnce.Do(func() {
+		p.started.Store(true)
+		go p.run(onError)
+	})
+}
+
+func (p *Pusher) run(onError func(error)) {
+	defer close(p.done)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+			err := p.Push(ctx)
+			cancel()
+			if err != nil && onError != nil {
+				onError(err)
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Stop ends periodic pushing, if started, and pushes the final values
+func (p *Pusher) Stop(ctx context.Context) error {
+	if p == nil {
+		return nil
+	}
+
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	if p.started.Load() {
+		// Wait for an in-flight push so the final one isn't overtaken
+		select {
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return p.Push(ctx)
+}
+
+// DumpToFile writes the metrics in the exposition format to path, replacing
+// it atomically, so batch jobs can archive their final values. An empty
+// path does nothing.
+func (m *Metrics) DumpToFile(path string) error {
+	if path == "" {
+		return nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.WriteString(tmp, m.GetMetrics()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// DumpToFile writes the global metrics to path
+func DumpToFile(path string) error {
+	return globalMetrics.DumpToFile(path)
+}
@@ -0,0 +1,238 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pushRecorder is a fake Pushgateway that records each push
+type pushRecorder struct {
+	mu     sync.Mutex
+	paths  []string
+	bodies []string
+	status int
+}
+
+func (p *pushRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.paths = append(p.paths, r.URL.EscapedPath())
+	p.bodies = append(p.bodies, string(body))
+	if p.status != 0 {
+		http.Error(w, "push rejected", p.status)
+	}
+}
+
+func (p *pushRecorder) pushes() ([]string, []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.paths...), append([]string(nil), p.bodies...)
+}
+
+func TestPusher_Push(t *testing.T) {
+	gateway := &pushRecorder{}
+	srv := httptest.NewServer(gateway)
+	defer srv.Close()
+
+	m := NewMetrics()
+	m.IncrCounter("repos_downloaded", 3)
+
+	p, err := NewPusher(m, srv.URL+"/", "downloader", "worker-1", time.Minute)
+	if err != nil {
+		t.Fatalf("NewPusher() error = %v", err)
+	}
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	paths, bodies := gateway.pushes()
+	if len(paths) != 1 || paths[0] != "/metrics/job/downloader/instance/worker-1" {
+		t.Fatalf("paths = %v", paths)
+	}
+	if !strings.Contains(bodies[0], "repos_downloaded 3\n") {
+		t.Errorf("body = %q", bodies[0])
+	}
+}
+
+func TestPusher_GroupingKeyEncoding(t *testing.T) {
+	tests := []struct {
+		instance string
+		want     string
+	}{
+		{"host:9090", "/metrics/job/analyzer/instance/host:9090"},
+		{"a/b", "/metrics/job/analyzer/instance@base64/YS9i"},
+		{"", "/metrics/job/analyzer/instance@base64/="},
+	}
+
+	for _, tt := range tests {
+		p, err := NewPusher(NewMetrics(), "http://gateway:9091", "analyzer", tt.instance, 0)
+		if err != nil {
+			t.Fatalf("NewPusher(%q) error = %v", tt.instance, err)
+		}
+		if got := strings.TrimPrefix(p.endpoint, "http://gateway:9091"); got != tt.want {
+			t.Errorf("instance %q: endpoint path = %q, want %q", tt.instance, got, tt.want)
+		}
+	}
+}
+
+func TestPusher_PushError(t *testing.T) {
+	srv := httptest.NewServer(&pushRecorder{status: http.StatusBadRequest})
+	defer srv.Close()
+
+	p, err := NewPusher(NewMetrics(), srv.URL, "downloader", "worker-1", 0)
+	if err != nil {
+		t.Fatalf("NewPusher() error = %v", err)
+	}
+	if err := p.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "push rejected") {
+		t.Errorf("Push() error = %v, want the gateway's rejection", err)
+	}
+}
+
+func TestPusher_StartAndStop(t *testing.T) {
+	gateway := &pushRecorder{}
+	srv := httptest.NewServer(gateway)
+	defer srv.Close()
+
+	m := NewMetrics()
+	p, err := NewPusher(m, srv.URL, "downloader", "worker-1", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewPusher() error = %v", err)
+	}
+	p.Start(func(err error) { t.Errorf("periodic push failed: %v", err) })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if paths, _ := gateway.pushes(); len(paths) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no periodic push within 2s")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	m.SetGauge("final_value", 42)
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	_, bodies := gateway.pushes()
+	if last := bodies[len(bodies)-1]; !strings.Contains(last, "final_value 42.00\n") {
+		t.Errorf("last push should carry the final values, got %q", last)
+	}
+
+	// Nothing is pushed once stopped
+	n := len(bodies)
+	time.Sleep(30 * time.Millisecond)
+	if _, bodies := gateway.pushes(); len(bodies) != n {
+		t.Errorf("pushed %d times after Stop", len(bodies)-n)
+	}
+}
+
+func TestPusher_StopWithoutStart(t *testing.T) {
+	gateway := &pushRecorder{}
+	srv := httptest.NewServer(gateway)
+	defer srv.Close()
+
+	p, err := NewPusher(NewMetrics(), srv.URL, "analyzer", "", 0)
+	if err != nil {
+		t.Fatalf("NewPusher() error = %v", err)
+	}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if paths, _ := gateway.pushes(); len(paths) != 1 {
+		t.Errorf("Stop() pushed %d times, want 1", len(paths))
+	}
+}
+
+func TestNewPusher_Invalid(t *testing.T) {
+	for _, gatewayURL := range []string{"", "gateway:9091", "://bad"} {
+		if _, err := NewPusher(NewMetrics(), gatewayURL, "job", "instance", 0); err == nil {
+			t.Errorf("NewPusher(%q) should fail", gatewayURL)
+		}
+	}
+	if _, err := NewPusher(NewMetrics(), "http://gateway:9091", "", "instance", 0); err == nil {
+		t.Error("NewPusher() without a job should fail")
+	}
+}
+
+func TestNewPusherFromEnv(t *testing.T) {
+	t.Setenv("METRICS_PUSH_URL", "")
+	p, err := NewPusherFromEnv("downloader")
+	if err != nil || p != nil {
+		t.Fatalf("NewPusherFromEnv() = %v, %v; want nil when METRICS_PUSH_URL is unset", p, err)
+	}
+
+	// A nil Pusher is a no-op
+	p.Start(nil)
+	if err := p.Push(context.Background()); err != nil {
+		t.Errorf("nil Push() error = %v", err)
+	}
+	if err := p.Stop(context.Background()); err != nil {
+		t.Errorf("nil Stop() error = %v", err)
+	}
+
+	t.Setenv("METRICS_PUSH_URL", "http://gateway:9091")
+	t.Setenv("METRICS_PUSH_INSTANCE", "batch-7")
+	t.Setenv("METRICS_PUSH_INTERVAL", "1m")
+	p, err = NewPusherFromEnv("downloader")
+	if err != nil {
+		t.Fatalf("NewPusherFromEnv() error = %v", err)
+	}
+	if p.endpoint != "http://gateway:9091/metrics/job/downloader/instance/batch-7" || p.interval != time.Minute {
+		t.Errorf("endpoint = %q, interval = %v", p.endpoint, p.interval)
+	}
+
+	t.Setenv("METRICS_PUSH_INTERVAL", "often")
+	if _, err := NewPusherFromEnv("downloader"); err == nil {
+		t.Error("NewPusherFromEnv() should reject an invalid METRICS_PUSH_INTERVAL")
+	}
+}
+
+func TestDumpToFile(t *testing.T) {
+	m := NewMetrics()
+	m.IncrCounterWithLabels("files_analyzed", map[string]string{"language": "go"}, 12)
+
+	if err := m.DumpToFile(""); err != nil {
+		t.Errorf("DumpToFile(\"\") error = %v", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "final.prom")
+	if err := os.WriteFile(path, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.DumpToFile(path); err != nil {
+		t.Fatalf("DumpToFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != m.GetMetrics() {
+		t.Errorf("file = %q, want the exposition text", data)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+
+	if err := m.DumpToFile(filepath.Join(dir, "missing", "final.prom")); err == nil {
+		t.Error("DumpToFile() should fail when the directory doesn't exist")
+	}
+}
@@ -0,0 +1,248 @@
+// Package notify tells people when a pipeline stage finishes or stalls,
+// through a generic JSON webhook, Slack or Discord.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"codelupe/pkg/secrets"
+)
+
+// Event types
+const (
+	EventCrawlCompleted         = "crawl_completed"
+	EventDownloadCycleCompleted = "download_cycle_completed"
+	EventProcessingCompleted    = "processing_completed"
+	EventProcessingStalled      = "processing_stalled"
+)
+
+// Event is one pipeline milestone
+type Event struct {
+	Type    string `json:"type"`
+	Source  string `json:"source"` // the binary that sent it, e.g. "crawler"
+	Summary string `json:"summary"`
+	// Stats are the run's counters, e.g. repos indexed or files processed
+	Stats map[string]any `json:"stats,omitempty"`
+	Time  time.Time      `json:"time"`
+}
+
+// Text renders the event as a message: the summary, then one line of
+// stats in name order
+func (e Event) Text() string {
+	if len(e.Stats) == 0 {
+		return e.Source + ": " + e.Summary
+	}
+	names := make([]string, 0, len(e.Stats))
+	for name := range e.Stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	stats := make([]string, len(names))
+	for i, name := range names {
+		stats[i] = fmt.Sprintf("%s=%v", name, e.Stats[name])
+	}
+	return e.Source + ": " + e.Summary + "\n" + strings.Join(stats, " ")
+}
+
+// Notifier delivers events
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+// Multi sends each event to every notifier in it. An empty Multi, which
+// FromEnv returns when nothing is configured, does nothing, so callers
+// don't need to check whether notifications are set up.
+type Multi []Notifier
+
+// Notify sends event to every notifier, returning their errors joined. A
+// failing notifier doesn't stop the others.
+func (m Multi) Notify(ctx context.Context, event Event) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FromEnv returns notifiers for each of NOTIFY_WEBHOOK_URL (the event as
+// JSON), NOTIFY_SLACK_WEBHOOK_URL and NOTIFY_DISCORD_WEBHOOK_URL that is
+// set. Webhook URLs carry their credentials, so each can also be read from
+// a <NAME>_FILE secret.
+func FromEnv() (Multi, error) {
+	var m Multi
+	var errs []error
+	for _, target := range []struct {
+		env string
+		new func(string) Notifier
+	}{
+		{"NOTIFY_WEBHOOK_URL", func(u string) Notifier { return NewWebhook(u) }},
+		{"NOTIFY_SLACK_WEBHOOK_URL", func(u string) Notifier { return NewSlack(u) }},
+		{"NOTIFY_DISCORD_WEBHOOK_URL", func(u string) Notifier { return NewDiscord(u) }},
+	} {
+		raw, err := secrets.ReadSecret(target.env)
+		if errors.Is(err, secrets.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid %s: must be an http(s) URL", target.env))
+			continue
+		}
+		m = append(m, target.new(raw))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return m, nil
+}
+
+// String describes the configured notifiers for startup logs
+func (m Multi) String() string {
+	if len(m) == 0 {
+		return "off"
+	}
+	names := make([]string, len(m))
+	for i, n := range m {
+		names[i] = fmt.Sprint(n)
+	}
+	return strings.Join(names, ", ")
+}
+
+// Webhook POSTs the event as JSON
+type Webhook struct{ poster }
+
+// NewWebhook returns a notifier posting to url
+func NewWebhook(url string) *Webhook {
+	return &Webhook{newPoster(url)}
+}
+
+// Notify posts event
+func (w *Webhook) Notify(ctx context.Context, event Event) error {
+	return w.post(ctx, event)
+}
+
+func (w *Webhook) String() string { return "webhook" }
+
+// Slack posts the event to a Slack incoming webhook
+type Slack struct{ poster }
+
+// NewSlack returns a notifier posting to the Slack incoming webhook at url
+func NewSlack(url string) *Slack {
+	return &Slack{newPoster(url)}
+}
+
+// Notify posts event as a message
+func (s *Slack) Notify(ctx context.Context, event Event) error {
+	return s.post(ctx, map[string]string{"text": event.Text()})
+}
+
+func (s *Slack) String() string { return "slack" }
+
+// maxDiscordContent is the longest message Discord accepts
+const maxDiscordContent = 2000
+
+// Discord posts the event to a Discord webhook
+type Discord struct{ poster }
+
+// NewDiscord returns a notifier posting to the Discord webhook at url
+func NewDiscord(url string) *Discord {
+	return &Discord{newPoster(url)}
+}
+
+// Notify posts event as a message, cut to Discord's length limit
+func (d *Discord) Notify(ctx context.Context, event Event) error {
+	text := event.Text()
+	if runes := []rune(text); len(runes) > maxDiscordContent {
+		text = string(runes[:maxDiscordContent])
+	}
+	return d.post(ctx, map[string]string{"content": text})
+}
+
+func (d *Discord) String() string { return "discord" }
+
+// Delivery attempts per event; 5xx and 429 responses and network errors
+// are retried after retryBackoff, doubling each time
+const maxAttempts = 3
+
+var retryBackoff = time.Second
+
+// poster sends JSON payloads to one URL
+type poster struct {
+	url    string
+	client *http.Client
+}
+
+func newPoster(url string) poster {
+	return poster{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (p poster) post(ctx context.Context, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		retry, err := p.send(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == maxAttempts {
+			return fmt.Errorf("notification failed after %d attempt(s): %w", attempt, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("notification failed: %w", ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// send makes one delivery attempt, reporting whether a failure is worth
+// retrying. The URL is left out of errors since it holds the credentials.
+func (p poster) send(ctx context.Context, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.New("invalid notification URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func init() {
+	retryBackoff = time.Millisecond
+}
+
+// newReceiver records the bodies posted to it, answering with statuses in
+// turn; the last status repeats
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, *[]string) {
+	t.Helper()
+
+	var bodies []string
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(data))
+
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[i])
+	}))
+	t.Cleanup(server.Close)
+	return server, &bodies
+}
+
+var event = Event{
+	Type:    EventCrawlCompleted,
+	Source:  "crawler",
+	Summary: "Crawl completed",
+	Stats:   map[string]any{"repos_indexed": 120, "errors": 2},
+	Time:    time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestWebhook(t *testing.T) {
+	server, bodies := newReceiver(t, http.StatusNoContent)
+
+	if err := NewWebhook(server.URL).Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify() unexpected error: %v", err)
+	}
+	if len(*bodies) != 1 {
+		t.Fatalf("got %d requests, want 1", len(*bodies))
+	}
+	var got Event
+	if err := json.Unmarshal([]byte((*bodies)[0]), &got); err != nil {
+		t.Fatalf("body %s is not an event: %v", (*bodies)[0], err)
+	}
+	if got.Type != EventCrawlCompleted || got.Source != "crawler" || got.Stats["repos_indexed"] != 120.0 || !got.Time.Equal(event.Time) {
+		t.Errorf("posted event = %+v", got)
+	}
+}
+
+func TestChatPayloads(t *testing.T) {
+	wantText := "crawler: Crawl completed\nerrors=2 repos_indexed=120"
+
+	server, bodies := newReceiver(t, http.StatusOK)
+	if err := NewSlack(server.URL).Notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewDiscord(server.URL).Notify(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+
+	var slack map[string]string
+	json.Unmarshal([]byte((*bodies)[0]), &slack)
+	if slack["text"] != wantText {
+		t.Errorf("Slack text = %q, want %q", slack["text"], wantText)
+	}
+	var discord map[string]string
+	json.Unmarshal([]byte((*bodies)[1]), &discord)
+	if discord["content"] != wantText {
+		t.Errorf("Discord content = %q, want %q", discord["content"], wantText)
+	}
+
+	long := Event{Source: "processor", Summary: strings.Repeat("é", 3000)}
+	if err := NewDiscord(server.URL).Notify(context.Background(), long); err != nil {
+		t.Fatal(err)
+	}
+	json.Unmarshal([]byte((*bodies)[2]), &discord)
+	if n := len([]rune(discord["content"])); n != maxDiscordContent {
+		t.Errorf("Discord content is %d characters, want it cut to %d", n, maxDiscordContent)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		statuses  []int
+		wantCalls int
+		wantError bool
+	}{
+		{"succeeds after 5xx", []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK}, 3, false},
+		{"retries rate limiting", []int{http.StatusTooManyRequests, http.StatusOK}, 2, false},
+		{"gives up after max attempts", []int{http.StatusInternalServerError}, maxAttempts, true},
+		{"no retry on 4xx", []int{http.StatusNotFound}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, bodies := newReceiver(t, tt.statuses...)
+			err := NewSlack(server.URL).Notify(context.Background(), event)
+			if (err != nil) != tt.wantError {
+				t.Errorf("Notify() error = %v, want error %v", err, tt.wantError)
+			}
+			if len(*bodies) != tt.wantCalls {
+				t.Errorf("got %d attempts, want %d", len(*bodies), tt.wantCalls)
+			}
+			if err != nil && strings.Contains(err.Error(), server.URL) {
+				t.Errorf("error %q leaks the webhook URL", err)
+			}
+		})
+	}
+}
+
+func TestMulti(t *testing.T) {
+	failing, _ := newReceiver(t, http.StatusBadRequest)
+	working, bodies := newReceiver(t, http.StatusOK)
+
+	m := Multi{NewWebhook(failing.URL), NewSlack(working.URL)}
+	if err := m.Notify(context.Background(), Event{Source: "downloader", Summary: "Download cycle completed"}); err == nil {
+		t.Error("Notify() should report the failing notifier")
+	}
+	if len(*bodies) != 1 {
+		t.Errorf("a failing notifier stopped delivery to the others")
+	}
+
+	if err := (Multi{}).Notify(context.Background(), event); err != nil {
+		t.Errorf("empty Multi Notify() = %v, want nil", err)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	m, err := FromEnv()
+	if err != nil || len(m) != 0 || m.String() != "off" {
+		t.Errorf("FromEnv() with nothing set = %v, %v; want no notifiers", m, err)
+	}
+
+	secret := filepath.Join(t.TempDir(), "discord")
+	os.WriteFile(secret, []byte("https://discord.com/api/webhooks/1/abc\n"), 0600)
+	t.Setenv("NOTIFY_SLACK_WEBHOOK_URL", "https://hooks.slack.com/services/T0/B0/x")
+	t.Setenv("NOTIFY_DISCORD_WEBHOOK_URL_FILE", secret)
+	m, err = FromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.String() != "slack, discord" {
+		t.Errorf("FromEnv() = %s, want slack, discord", m)
+	}
+
+	t.Setenv("NOTIFY_WEBHOOK_URL", "hooks.internal/pipeline")
+	if _, err := FromEnv(); err == nil || !strings.Contains(err.Error(), "NOTIFY_WEBHOOK_URL") {
+		t.Errorf("FromEnv() error = %v, want an invalid NOTIFY_WEBHOOK_URL", err)
+	}
+}
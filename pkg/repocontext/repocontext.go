@@ -0,0 +1,147 @@
+// Package repocontext reads the repository-level files that give a training
+// sample its context: the README and the dependency manifest.
+package repocontext
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Excerpt caps, in bytes. The context is repeated for every sample from a
+// repo, so it is kept to a summary.
+const (
+	MaxReadmeExcerpt   = 1024
+	MaxManifestExcerpt = 1024
+)
+
+// maxReadBytes bounds how much of each file is read
+const maxReadBytes = 64 * 1024
+
+// readmeNames are checked in order, case-insensitively
+var readmeNames = []string{"readme.md", "readme.rst", "readme.txt", "readme"}
+
+// manifestNames are checked in order; the first present is the repo's
+// manifest, so a Go service with a package.json for its web assets is
+// described by go.mod
+var manifestNames = []string{
+	"go.mod",
+	"Cargo.toml",
+	"package.json",
+	"pyproject.toml",
+	"setup.py",
+	"requirements.txt",
+	"pom.xml",
+	"build.gradle.kts",
+	"build.gradle",
+	"pubspec.yaml",
+	"Gemfile",
+	"composer.json",
+}
+
+// Context is the repo-level context shared by every file in a repo
+type Context struct {
+	ReadmeExcerpt   string
+	ManifestKind    string // manifest file name, e.g. "go.mod"; "" when there is none
+	ManifestExcerpt string
+}
+
+// Load reads the context of the repository checked out in dir from its
+// top-level README and manifest. Missing files leave their fields empty.
+func Load(dir string) (Context, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return Context{}, err
+	}
+	files := make(map[string]string, len(entries)) // lowercased -> actual name
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files[strings.ToLower(entry.Name())] = entry.Name()
+		}
+	}
+
+	var ctx Context
+	for _, name := range readmeNames {
+		if actual, ok := files[name]; ok {
+			text, err := readHead(filepath.Join(dir, actual))
+			if err != nil {
+				return Context{}, err
+			}
+			ctx.ReadmeExcerpt = truncate(summarize(text), MaxReadmeExcerpt)
+			break
+		}
+	}
+	for _, name := range manifestNames {
+		if actual, ok := files[strings.ToLower(name)]; ok {
+			text, err := readHead(filepath.Join(dir, actual))
+			if err != nil {
+				return Context{}, err
+			}
+			ctx.ManifestKind = name
+			ctx.ManifestExcerpt = truncate(strings.TrimSpace(text), MaxManifestExcerpt)
+			break
+		}
+	}
+	return ctx, nil
+}
+
+// badgeLine matches lines made up only of images, badges and HTML tags,
+// which say nothing about the code
+var badgeLine = regexp.MustCompile(`^(?:\s*(?:\[?!\[[^\]]*\]\([^)]*\)(?:\]\([^)]*\))?|<[^>]+>))+\s*$`)
+
+// summarize drops badge and markup-only lines and runs of blank lines from
+// a README
+func summarize(text string) string {
+	var kept []string
+	blank := true // drops leading blank lines too
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimRight(line, " \t")
+		if badgeLine.MatchString(line) {
+			continue
+		}
+		if line == "" {
+			if blank {
+				continue
+			}
+			blank = true
+		} else {
+			blank = false
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+// truncate cuts s to at most max bytes, at a line break when one falls in
+// the second half and otherwise at a rune boundary
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	cut := s[:max]
+	if i := strings.LastIndexByte(cut, '\n'); i > max/2 {
+		return strings.TrimRight(cut[:i], " \t\n")
+	}
+	// Back up to the start of a rune split by the cut
+	for len(cut) > 0 && !utf8.RuneStart(s[len(cut)]) {
+		cut = cut[:len(cut)-1]
+	}
+	return cut
+}
+
+func readHead(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, maxReadBytes))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
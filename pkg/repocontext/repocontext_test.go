@@ -0,0 +1,96 @@
+package repocontext
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func write(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	t.Run("readme and manifest", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "README.md", "# widget\n\n[![Build](https://ci/badge.svg)](https://ci)\n<p align=\"center\"><img src=\"logo.png\"></p>\n\n\nA widget server.\n")
+		write(t, dir, "Cargo.toml", "[package]\nname = \"widget\"\n\n[dependencies]\ntokio = \"1\"\n")
+
+		ctx, err := Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "# widget\n\nA widget server."; ctx.ReadmeExcerpt != want {
+			t.Errorf("ReadmeExcerpt = %q, want %q", ctx.ReadmeExcerpt, want)
+		}
+		if ctx.ManifestKind != "Cargo.toml" || !strings.Contains(ctx.ManifestExcerpt, "tokio") {
+			t.Errorf("manifest = %q, %q", ctx.ManifestKind, ctx.ManifestExcerpt)
+		}
+	})
+
+	t.Run("multiple manifests", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "package.json", `{"name": "web-assets"}`)
+		write(t, dir, "go.mod", "module example.com/widget\n\ngo 1.22\n")
+		write(t, dir, "requirements.txt", "requests\n")
+
+		ctx, err := Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx.ManifestKind != "go.mod" || ctx.ManifestExcerpt != "module example.com/widget\n\ngo 1.22" {
+			t.Errorf("manifest = %q, %q; want go.mod", ctx.ManifestKind, ctx.ManifestExcerpt)
+		}
+	})
+
+	t.Run("no readme", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "main.go", "package main\n")
+		os.Mkdir(filepath.Join(dir, "README"), 0755) // a directory doesn't count
+
+		ctx, err := Load(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ctx != (Context{}) {
+			t.Errorf("Load() = %+v, want empty context", ctx)
+		}
+	})
+
+	t.Run("lowercase readme", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, dir, "readme.rst", "Widget\n======\n")
+
+		ctx, _ := Load(dir)
+		if ctx.ReadmeExcerpt != "Widget\n======" {
+			t.Errorf("ReadmeExcerpt = %q", ctx.ReadmeExcerpt)
+		}
+	})
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Load() should fail for a missing directory")
+	}
+}
+
+func TestLoad_CapsExcerpts(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, "README.md", strings.Repeat("A line about the widget.\n", 200))
+	write(t, dir, "package.json", "{\"description\": \""+strings.Repeat("é", 2000)+"\"}")
+
+	ctx, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctx.ReadmeExcerpt) > MaxReadmeExcerpt || !strings.HasSuffix(ctx.ReadmeExcerpt, "widget.") {
+		t.Errorf("ReadmeExcerpt should end on a whole line within %d bytes, got %d bytes ending %q",
+			MaxReadmeExcerpt, len(ctx.ReadmeExcerpt), ctx.ReadmeExcerpt[len(ctx.ReadmeExcerpt)-10:])
+	}
+	if len(ctx.ManifestExcerpt) > MaxManifestExcerpt || !utf8.ValidString(ctx.ManifestExcerpt) {
+		t.Errorf("ManifestExcerpt should be valid UTF-8 within %d bytes, got %d bytes", MaxManifestExcerpt, len(ctx.ManifestExcerpt))
+	}
+}
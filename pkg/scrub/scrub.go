@@ -0,0 +1,322 @@
+// Package scrub finds credentials and personal data in source files before
+// they enter the dataset, and drops the file, masks the matches or just
+// records what was found.
+package scrub
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Redaction modes, selected with SCRUB_MODE
+const (
+	ModeDrop = "drop" // leave the file out of the dataset
+	ModeMask = "mask" // replace each match with a [REDACTED:detector] marker
+	ModeTag  = "tag"  // keep the content as is and only record the findings
+)
+
+// Detector names, as accepted in SCRUB_DETECTORS and recorded in
+// secrets_found
+const (
+	AWSKey      = "aws_key"
+	PrivateKey  = "private_key"
+	Credential  = "credential"
+	HighEntropy = "high_entropy"
+	Email       = "email"
+)
+
+// minEntropy is the Shannon entropy, in bits per character, above which a
+// token counts as a likely secret. Random base64 sits near 6; identifiers
+// and prose stay well under 4.
+const minEntropy = 4.5
+
+// detector finds one kind of secret. The first submatch that took part in
+// a match, when the pattern has any, is the part that gets masked, so
+// "password = hunter2" keeps its key.
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+	// keep rejects matches that look like secrets but aren't
+	keep func(match string) bool
+}
+
+// credentialKeys are the key names whose values are treated as credentials
+const credentialKeys = `(?:password|passwd|secret|secret_?key|api_?key|access_?key|access_?token|auth_?token|token)`
+
+// detectors are checked in order; a match overlapping an earlier detector's
+// is dropped, so a private key block isn't also reported as high entropy
+var detectors = []detector{
+	{
+		name:    PrivateKey,
+		pattern: regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY(?: BLOCK)?-----.*?(?:-----END [A-Z ]*PRIVATE KEY(?: BLOCK)?-----|\z)`),
+	},
+	{
+		name:    AWSKey,
+		pattern: regexp.MustCompile(`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|AIPA|ANPA|ANVA)[0-9A-Z]{16}\b|(?i:aws_?secret_?access_?key)["']?\s*[:=]\s*["']?([A-Za-z0-9/+]{40})\b`),
+	},
+	{
+		// Unquoted values only count in .env-style KEY=value lines, so
+		// "token = nextToken()" in code isn't reported
+		name: Credential,
+		pattern: regexp.MustCompile(`(?im)^[ \t]*(?:export[ \t]+)?[a-z0-9_]*` + credentialKeys + `[ \t]*=[ \t]*["']?([^\s"'()$]{8,})["']?[ \t]*$` +
+			`|\b[a-z0-9_]*` + credentialKeys + `["']?[ \t]*[:=][ \t]*["']([^\s"']{8,})["']`),
+		keep: isLiteralValue,
+	},
+	{
+		name:    HighEntropy,
+		pattern: regexp.MustCompile(`[A-Za-z0-9+/_\-]{24,}={0,2}`),
+		keep:    isRandomToken,
+	},
+	{
+		name:    Email,
+		pattern: regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(?:\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+		keep:    isRealEmail,
+	},
+}
+
+// Finding is one detected secret
+type Finding struct {
+	Detector string
+	Line     int // 1-based line the match starts on
+	start    int
+	end      int
+}
+
+// Result is the outcome of scanning one file
+type Result struct {
+	// Content is the file with matches masked in mask mode, and unchanged
+	// otherwise
+	Content  string
+	Findings []Finding
+	// Drop is set in drop mode when anything was found
+	Drop bool
+}
+
+// Detectors returns the names of the detectors that fired, sorted and
+// without repeats
+func (r Result) Detectors() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, f := range r.Findings {
+		if !seen[f.Detector] {
+			seen[f.Detector] = true
+			names = append(names, f.Detector)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Counts returns how many matches each detector found
+func (r Result) Counts() map[string]int {
+	counts := make(map[string]int)
+	for _, f := range r.Findings {
+		counts[f.Detector]++
+	}
+	return counts
+}
+
+// Scanner runs the enabled detectors over file content
+type Scanner struct {
+	mode      string
+	detectors []detector
+}
+
+// New creates a scanner for mode with the named detectors enabled. No
+// names enables every detector.
+func New(mode string, names []string) (*Scanner, error) {
+	s := &Scanner{mode: mode}
+	switch mode {
+	case "":
+		s.mode = ModeMask
+	case ModeDrop, ModeMask, ModeTag:
+	default:
+		return nil, fmt.Errorf("unknown scrub mode %q (expected drop, mask or tag)", mode)
+	}
+
+	if len(names) == 0 {
+		s.detectors = detectors
+		return s, nil
+	}
+	enabled := make(map[string]bool)
+	for _, name := range names {
+		known := false
+		for _, d := range detectors {
+			if d.name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown secret detector %q", name)
+		}
+		enabled[name] = true
+	}
+	for _, d := range detectors {
+		if enabled[d.name] {
+			s.detectors = append(s.detectors, d)
+		}
+	}
+	return s, nil
+}
+
+// FromEnv creates a scanner from SCRUB_MODE (default mask) and
+// SCRUB_DETECTORS, a comma-separated list of detectors (default all)
+func FromEnv() (*Scanner, error) {
+	var names []string
+	for _, name := range strings.Split(os.Getenv("SCRUB_DETECTORS"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return New(strings.ToLower(strings.TrimSpace(os.Getenv("SCRUB_MODE"))), names)
+}
+
+// Mode returns how the scanner handles files with findings
+func (s *Scanner) Mode() string {
+	return s.mode
+}
+
+// String describes the scanner for startup logs
+func (s *Scanner) String() string {
+	names := make([]string, len(s.detectors))
+	for i, d := range s.detectors {
+		names[i] = d.name
+	}
+	return fmt.Sprintf("%s (%s)", s.mode, strings.Join(names, ", "))
+}
+
+// Scan looks for secrets in content and applies the scanner's mode
+func (s *Scanner) Scan(content string) Result {
+	var findings []Finding
+	for _, d := range s.detectors {
+		for _, loc := range d.pattern.FindAllStringSubmatchIndex(content, -1) {
+			start, end := loc[0], loc[1]
+			for i := 2; i+1 < len(loc); i += 2 {
+				if loc[i] >= 0 {
+					start, end = loc[i], loc[i+1]
+					break
+				}
+			}
+			if d.keep != nil && !d.keep(content[start:end]) {
+				continue
+			}
+			if overlaps(findings, start, end) {
+				continue
+			}
+			findings = append(findings, Finding{
+				Detector: d.name,
+				Line:     strings.Count(content[:start], "\n") + 1,
+				start:    start,
+				end:      end,
+			})
+		}
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].start < findings[j].start })
+
+	result := Result{Content: content, Findings: findings}
+	if len(findings) == 0 {
+		return result
+	}
+	switch s.mode {
+	case ModeDrop:
+		result.Drop = true
+	case ModeMask:
+		result.Content = mask(content, findings)
+	}
+	return result
+}
+
+func overlaps(findings []Finding, start, end int) bool {
+	for _, f := range findings {
+		if start < f.end && f.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// mask replaces each finding, which must be sorted and not overlap
+func mask(content string, findings []Finding) string {
+	var b strings.Builder
+	b.Grow(len(content))
+	last := 0
+	for _, f := range findings {
+		b.WriteString(content[last:f.start])
+		b.WriteString("[REDACTED:" + f.Detector + "]")
+		last = f.end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// isLiteralValue rejects credential values that are clearly not secrets:
+// references to other variables and common placeholders
+func isLiteralValue(value string) bool {
+	lower := strings.ToLower(value)
+	for _, placeholder := range []string{"changeme", "password", "example", "placeholder", "your_", "xxxxxxxx", "********"} {
+		if strings.Contains(lower, placeholder) {
+			return false
+		}
+	}
+	// os.environ / process.env / settings.SECRET_KEY and the like
+	if strings.Contains(value, ".") && !strings.ContainsAny(value, "0123456789") {
+		return false
+	}
+	return true
+}
+
+// isRandomToken reports whether a long token looks randomly generated: it
+// mixes letters and digits and has high entropy
+func isRandomToken(token string) bool {
+	var letters, digits bool
+	for _, r := range token {
+		switch {
+		case r >= '0' && r <= '9':
+			digits = true
+		case (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			letters = true
+		}
+	}
+	return letters && digits && entropy(token) >= minEntropy
+}
+
+// entropy returns the Shannon entropy of s in bits per byte
+func entropy(s string) float64 {
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// isRealEmail rejects documentation addresses under the reserved example
+// domains and addresses that are really package or image references
+func isRealEmail(address string) bool {
+	domain := strings.ToLower(address[strings.LastIndex(address, "@")+1:])
+	for _, reserved := range []string{"example.com", "example.org", "example.net", "localhost"} {
+		if domain == reserved || strings.HasSuffix(domain, "."+reserved) {
+			return false
+		}
+	}
+	// "logo@2x.png" and similar asset names
+	for _, ext := range []string{".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp"} {
+		if strings.HasSuffix(domain, ext) {
+			return false
+		}
+	}
+	return true
+}
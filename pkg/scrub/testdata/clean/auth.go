@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidPasswordForAccountHolder is returned when the hash doesn't match
+var ErrInvalidPasswordForAccountHolder = errors.New("invalid password")
+
+// Check compares a password with its stored hash. Contact the maintainers
+// at maintainers@example.com for questions.
+func Check(password string, hash []byte) error {
+	token := nextToken()
+	apiKey := os.Getenv("API_KEY")
+	secret := loadSecretFromVault(apiKey)
+	_ = secret
+	_ = token
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return ErrInvalidPasswordForAccountHolder
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+import React from "react";
+import { useAuthenticationContextProvider } from "../hooks/useAuthenticationContextProvider";
+
+export function LoginForm({ onSubmit }: { onSubmit: (password: string) => void }) {
+  const { token, setToken } = useAuthenticationContextProvider();
+  return <input type="password" placeholder="Enter your password" onChange={(e) => setToken(e.target.value)} />;
+}
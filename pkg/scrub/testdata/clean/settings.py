@@ -0,0 +1,11 @@
+import os
+
+SECRET_KEY = os.environ["DJANGO_SECRET_KEY"]
+DATABASE_PASSWORD = settings.DATABASE_PASSWORD
+password = get_password()
+tokenizer_path = "/models/tokenizer.json"
+DEFAULT_PASSWORD = "changeme"
+
+# Release 2.4.1 fixed issue 4417; see commit log
+REQUEST_ID_HEADER = "X-Request-ID"
+logo = "images/logo@2x.png"
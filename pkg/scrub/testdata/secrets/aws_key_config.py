@@ -0,0 +1,8 @@
+# Synthetic credentials for scrub tests; none of these are real.
+import boto3
+
+client = boto3.client(
+    "s3",
+    aws_access_key_id="AKIAHBRPOIG8F1CBFNO6",
+    region_name="us-east-1",
+)
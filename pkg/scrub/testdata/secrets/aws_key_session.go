@@ -0,0 +1,4 @@
+package deploy
+
+// Synthetic temporary key for scrub tests
+const sessionKeyID = "ASIAKXWNREK8PK3YR9OU"
@@ -0,0 +1,5 @@
+// Synthetic token for scrub tests
+export const client = new ApiClient({
+  baseUrl: "https://api.internal",
+  authToken: "cu7tGz0IEqcWPmsw3Xd3",
+});
@@ -0,0 +1,4 @@
+"""Synthetic contact details for scrub tests."""
+
+__author__ = "Jane Doe <jane.doe@acme-widgets.io>"
+MAINTAINERS = ["ops-team@corp.acme.dev"]
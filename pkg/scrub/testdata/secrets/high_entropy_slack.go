@@ -0,0 +1,4 @@
+package notify
+
+// Synthetic webhook path for scrub tests
+var hook = "https://hooks.internal/services/BM0lIsugfuQstCMTBkSCwCcU36wNBrOY"
@@ -0,0 +1,2 @@
+# Synthetic signing secret for scrub tests
+WEBHOOK_SIGNING = "p1RFH8xeb+NmvnDl9GJW+S9/sX9e7MyERM0VumwU"
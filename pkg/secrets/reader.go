@@ -1,11 +1,15 @@
 package secrets
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 )
 
+// ErrNotFound is returned when neither a secret nor its _FILE variable is set
+var ErrNotFound = errors.New("secret not found")
+
 // ReadSecret reads a secret from either a file (Docker secret) or environment variable
 // It first tries the _FILE suffix (Docker secret), then falls back to the env var itself
 func ReadSecret(envVar string) (string, error) {
@@ -24,7 +28,7 @@ func ReadSecret(envVar string) (string, error) {
 		return value, nil
 	}
 
-	return "", fmt.Errorf("secret not found: %s (tried both %s and %s)", envVar, fileEnvVar, envVar)
+	return "", fmt.Errorf("%w: %s (tried both %s and %s)", ErrNotFound, envVar, fileEnvVar, envVar)
 }
 
 // ReadSecretOrDefault reads a secret with a default fallback value
@@ -36,13 +40,52 @@ func ReadSecretOrDefault(envVar, defaultValue string) string {
 	return value
 }
 
-// MustReadSecret reads a secret and panics if it fails (use for required secrets)
-func MustReadSecret(envVar string) string {
-	value, err := ReadSecret(envVar)
+// MissingError lists required settings that aren't set
+type MissingError struct {
+	Names []string
+}
+
+func (e *MissingError) Error() string {
+	return fmt.Sprintf("missing required configuration: %s (set each variable or <NAME>_FILE)", strings.Join(e.Names, ", "))
+}
+
+// ReadSecrets reads every named secret, returning them by name. All missing
+// names are reported in one *MissingError, joined with any secret files that
+// couldn't be read, so a deployment can be fixed in one go.
+func ReadSecrets(names ...string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	missing := &MissingError{}
+	var errs []error
+
+	for _, name := range names {
+		value, err := ReadSecret(name)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			missing.Names = append(missing.Names, name)
+		case err != nil:
+			errs = append(errs, err)
+		default:
+			values[name] = value
+		}
+	}
+
+	if len(missing.Names) > 0 {
+		errs = append([]error{missing}, errs...)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return values, nil
+}
+
+// MustReadSecret reads every named secret like ReadSecrets and panics
+// listing all that are missing (use for required secrets)
+func MustReadSecret(names ...string) map[string]string {
+	values, err := ReadSecrets(names...)
 	if err != nil {
-		panic(fmt.Sprintf("required secret not found: %s", envVar))
+		panic(err.Error())
 	}
-	return value
+	return values
 }
 
 // DatabaseConfig holds database connection configuration
@@ -74,17 +117,12 @@ func LoadDatabaseConfig() (*DatabaseConfig, error) {
 	}
 
 	// Load user and password from secrets
-	user, err := ReadSecret("POSTGRES_USER")
-	if err != nil {
-		return nil, fmt.Errorf("failed to load database user: %w", err)
-	}
-	config.User = user
-
-	password, err := ReadSecret("POSTGRES_PASSWORD")
+	credentials, err := ReadSecrets("POSTGRES_USER", "POSTGRES_PASSWORD")
 	if err != nil {
-		return nil, fmt.Errorf("failed to load database password: %w", err)
+		return nil, fmt.Errorf("failed to load database credentials: %w", err)
 	}
-	config.Password = password
+	config.User = credentials["POSTGRES_USER"]
+	config.Password = credentials["POSTGRES_PASSWORD"]
 
 	return config, nil
 }
@@ -124,3 +162,25 @@ func LoadGitHubConfig() (*GitHubConfig, error) {
 
 	return config, nil
 }
+
+// LoadAPIKeys loads the keys accepted by the API server from API_KEYS (or
+// the API_KEYS_FILE secret), separated by commas or newlines. An entry of
+// the form sha256:<hex> is the SHA-256 digest of a key rather than the key
+// itself, so plaintext keys needn't be stored.
+func LoadAPIKeys() ([]string, error) {
+	value, err := ReadSecret("API_KEYS")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load API keys: %w", err)
+	}
+
+	var keys []string
+	for _, key := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("API_KEYS is set but contains no keys")
+	}
+	return keys, nil
+}
@@ -1,8 +1,11 @@
 package secrets
 
 import (
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -132,3 +135,96 @@ func TestLoadDatabaseConfig(t *testing.T) {
 		t.Errorf("Expected password 'testpass', got %q", config.Password)
 	}
 }
+
+func TestLoadAPIKeys(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "api_keys.txt")
+	content := "key-one, key-two\nsha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08\n\n"
+	if err := os.WriteFile(keyFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("API_KEYS_FILE", keyFile)
+
+	keys, err := LoadAPIKeys()
+	if err != nil {
+		t.Fatalf("LoadAPIKeys failed: %v", err)
+	}
+
+	want := []string{"key-one", "key-two", "sha256:9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+	if strings.Join(keys, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %q, got %q", want, keys)
+	}
+}
+
+func TestLoadAPIKeys_Empty(t *testing.T) {
+	t.Setenv("API_KEYS", " , ")
+	if _, err := LoadAPIKeys(); err == nil {
+		t.Error("Expected error for API_KEYS without keys")
+	}
+}
+
+func TestReadSecrets(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "db_password")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REQ_HOST", "db.internal")
+	t.Setenv("REQ_PASSWORD_FILE", secretFile)
+
+	values, err := ReadSecrets("REQ_HOST", "REQ_PASSWORD")
+	if err != nil {
+		t.Fatalf("ReadSecrets failed: %v", err)
+	}
+	if values["REQ_HOST"] != "db.internal" || values["REQ_PASSWORD"] != "from-file" {
+		t.Errorf("Unexpected values %v", values)
+	}
+}
+
+func TestReadSecrets_ReportsAllMissing(t *testing.T) {
+	t.Setenv("REQ_HOST", "db.internal")
+	t.Setenv("REQ_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	_, err := ReadSecrets("REQ_USER", "REQ_HOST", "REQ_PASSWORD", "REQ_TOKEN")
+	if err == nil {
+		t.Fatal("Expected error for missing secrets")
+	}
+
+	var missing *MissingError
+	if !errors.As(err, &missing) {
+		t.Fatalf("Expected a *MissingError, got %v", err)
+	}
+	if strings.Join(missing.Names, ",") != "REQ_USER,REQ_PASSWORD" {
+		t.Errorf("Expected REQ_USER and REQ_PASSWORD missing, got %v", missing.Names)
+	}
+	if !strings.Contains(err.Error(), "failed to read secret file") {
+		t.Errorf("Expected the unreadable REQ_TOKEN_FILE in %q", err)
+	}
+}
+
+func TestMustReadSecret(t *testing.T) {
+	t.Setenv("REQ_HOST", "db.internal")
+	if values := MustReadSecret("REQ_HOST"); values["REQ_HOST"] != "db.internal" {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			t.Fatal("Expected MustReadSecret to panic")
+		}
+		if msg := fmt.Sprint(r); !strings.Contains(msg, "REQ_USER, REQ_PASSWORD") {
+			t.Errorf("Expected one message naming every missing secret, got %q", msg)
+		}
+	}()
+	MustReadSecret("REQ_USER", "REQ_HOST", "REQ_PASSWORD")
+}
+
+func TestLoadDatabaseConfig_MissingCredentials(t *testing.T) {
+	for _, name := range []string{"POSTGRES_USER", "POSTGRES_USER_FILE", "POSTGRES_PASSWORD", "POSTGRES_PASSWORD_FILE"} {
+		t.Setenv(name, "")
+	}
+
+	_, err := LoadDatabaseConfig()
+	if err == nil || !strings.Contains(err.Error(), "POSTGRES_USER, POSTGRES_PASSWORD") {
+		t.Errorf("Expected both credentials reported missing, got %v", err)
+	}
+}
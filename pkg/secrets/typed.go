@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// readOptional reads name, reporting whether it was set. Only an unreadable
+// secret file is an error.
+func readOptional(name string) (string, bool, error) {
+	value, err := ReadSecret(name)
+	if errors.Is(err, ErrNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// ReadInt reads an integer setting, returning defaultValue when it's unset
+func ReadInt(name string, defaultValue int) (int, error) {
+	raw, ok, err := readOptional(name)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return defaultValue, fmt.Errorf("invalid %s %q: must be an integer", name, raw)
+	}
+	return n, nil
+}
+
+// ReadFloat reads a number setting, returning defaultValue when it's unset
+func ReadFloat(name string, defaultValue float64) (float64, error) {
+	raw, ok, err := readOptional(name)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	f, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return defaultValue, fmt.Errorf("invalid %s %q: must be a number", name, raw)
+	}
+	return f, nil
+}
+
+// ReadDuration reads a duration setting such as "30s", returning
+// defaultValue when it's unset
+func ReadDuration(name string, defaultValue time.Duration) (time.Duration, error) {
+	raw, ok, err := readOptional(name)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil {
+		return defaultValue, fmt.Errorf("invalid %s %q: must be a duration like 30s or 5m", name, raw)
+	}
+	return d, nil
+}
+
+// ReadBool reads a boolean setting (true/false, 1/0, yes/no), returning
+// defaultValue when it's unset
+func ReadBool(name string, defaultValue bool) (bool, error) {
+	raw, ok, err := readOptional(name)
+	if err != nil || !ok {
+		return defaultValue, err
+	}
+	switch raw {
+	case "yes", "on":
+		return true, nil
+	case "no", "off":
+		return false, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return defaultValue, fmt.Errorf("invalid %s %q: must be true or false", name, raw)
+	}
+	return b, nil
+}
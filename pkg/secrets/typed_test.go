@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadInt(t *testing.T) {
+	if n, err := ReadInt("TYPED_INT", 7); err != nil || n != 7 {
+		t.Errorf("ReadInt unset = %d, %v; want default 7", n, err)
+	}
+
+	t.Setenv("TYPED_INT", "42")
+	if n, err := ReadInt("TYPED_INT", 7); err != nil || n != 42 {
+		t.Errorf("ReadInt = %d, %v; want 42", n, err)
+	}
+
+	t.Setenv("TYPED_INT", "4.2")
+	if _, err := ReadInt("TYPED_INT", 7); err == nil {
+		t.Error("Expected error for a non-integer")
+	}
+}
+
+func TestReadFloat(t *testing.T) {
+	t.Setenv("TYPED_FLOAT", "0.5")
+	if f, err := ReadFloat("TYPED_FLOAT", 1); err != nil || f != 0.5 {
+		t.Errorf("ReadFloat = %v, %v; want 0.5", f, err)
+	}
+
+	t.Setenv("TYPED_FLOAT", "half")
+	if _, err := ReadFloat("TYPED_FLOAT", 1); err == nil {
+		t.Error("Expected error for a non-number")
+	}
+}
+
+func TestReadDuration(t *testing.T) {
+	if d, err := ReadDuration("TYPED_DURATION", time.Minute); err != nil || d != time.Minute {
+		t.Errorf("ReadDuration unset = %v, %v; want default 1m", d, err)
+	}
+
+	t.Setenv("TYPED_DURATION", "90s")
+	if d, err := ReadDuration("TYPED_DURATION", time.Minute); err != nil || d != 90*time.Second {
+		t.Errorf("ReadDuration = %v, %v; want 90s", d, err)
+	}
+
+	// A bare number has no unit
+	t.Setenv("TYPED_DURATION", "30")
+	if _, err := ReadDuration("TYPED_DURATION", time.Minute); err == nil {
+		t.Error("Expected error for a duration without a unit")
+	}
+}
+
+func TestReadBool(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    bool
+		wantErr bool
+	}{
+		{"true", true, false},
+		{"1", true, false},
+		{"yes", true, false},
+		{"FALSE", false, false},
+		{"off", false, false},
+		{"maybe", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			t.Setenv("TYPED_BOOL", tt.raw)
+			got, err := ReadBool("TYPED_BOOL", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadBool(%q) error = %v, wantErr %v", tt.raw, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ReadBool(%q) = %v, want %v", tt.raw, got, tt.want)
+			}
+		})
+	}
+
+	if got, err := ReadBool("TYPED_BOOL_UNSET", true); err != nil || !got {
+		t.Errorf("ReadBool unset = %v, %v; want default true", got, err)
+	}
+}
+
+func TestTypedReaders_SecretFile(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "workers")
+	if err := os.WriteFile(secretFile, []byte("12\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TYPED_WORKERS_FILE", secretFile)
+
+	if n, err := ReadInt("TYPED_WORKERS", 1); err != nil || n != 12 {
+		t.Errorf("ReadInt from file = %d, %v; want 12", n, err)
+	}
+
+	// An unreadable secret file is an error, not the default
+	t.Setenv("TYPED_WORKERS_FILE", filepath.Join(dir, "missing"))
+	if _, err := ReadInt("TYPED_WORKERS", 1); err == nil {
+		t.Error("Expected error for an unreadable secret file")
+	}
+}
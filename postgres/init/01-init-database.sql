@@ -12,6 +12,7 @@ CREATE TABLE repositories (
     description TEXT,
     url VARCHAR(512) NOT NULL,
     clone_url VARCHAR(512) NOT NULL,
+    host VARCHAR(50) DEFAULT 'github',
     language VARCHAR(100),
     stars INTEGER DEFAULT 0,
     forks INTEGER DEFAULT 0,
@@ -21,9 +22,18 @@ CREATE TABLE repositories (
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     crawled_at TIMESTAMP,
     downloaded_at TIMESTAMP,
+    last_synced_at TIMESTAMP,
+    sync_error TEXT,
     download_status VARCHAR(50) DEFAULT 'pending',
+    worker_id TEXT,
+    heartbeat_at TIMESTAMP,
     topics TEXT[],
     is_fork BOOLEAN DEFAULT FALSE,
+    parent_full_name VARCHAR(255),
+    head_commit VARCHAR(64),
+    mirror_of VARCHAR(255),
+    has_submodules BOOLEAN DEFAULT FALSE,
+    has_lfs BOOLEAN DEFAULT FALSE,
     is_archived BOOLEAN DEFAULT FALSE,
     is_private BOOLEAN DEFAULT FALSE,
     default_branch VARCHAR(100) DEFAULT 'main',
@@ -32,7 +42,10 @@ CREATE TABLE repositories (
     license_name VARCHAR(255),
     license_key VARCHAR(100),
     local_path TEXT,
+    download_method VARCHAR(20),
     error_message TEXT,
+    retry_count INTEGER NOT NULL DEFAULT 0,
+    filter_reason TEXT,
     quality_score INTEGER DEFAULT 0,
     code_lines INTEGER DEFAULT 0,
     file_count INTEGER DEFAULT 0
@@ -46,6 +59,10 @@ CREATE INDEX idx_repositories_forks ON repositories(forks DESC);
 CREATE INDEX idx_repositories_download_status ON repositories(download_status);
 CREATE INDEX idx_repositories_quality_score ON repositories(quality_score DESC);
 CREATE INDEX idx_repositories_owner_login ON repositories(owner_login);
+CREATE INDEX idx_repositories_license_key ON repositories(license_key);
+CREATE INDEX idx_repositories_host ON repositories(host);
+CREATE INDEX idx_repositories_head_commit ON repositories(head_commit) WHERE head_commit IS NOT NULL;
+CREATE INDEX idx_repositories_local_path ON repositories(local_path);
 CREATE INDEX idx_repositories_topics ON repositories USING GIN(topics);
 CREATE INDEX idx_repositories_created_at ON repositories(created_at DESC);
 CREATE INDEX idx_repositories_crawled_at ON repositories(crawled_at DESC);
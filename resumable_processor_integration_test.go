@@ -0,0 +1,71 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"codelupe/internal/testenv"
+)
+
+// TestIntegration_ProcessorProcessesDownloads is the third stage of the
+// pipeline test: it processes the checkouts the downloader stage placed in
+// testenv.ReposDir into processed_files.
+func TestIntegration_ProcessorProcessesDownloads(t *testing.T) {
+	db := testenv.Postgres(t)
+	cfg := testenv.Config(t)
+
+	// NewResumableProcessor creates its own tables on top of the migrated
+	// schema, so it runs before they are emptied
+	p, err := NewResumableProcessor(cfg)
+	if err != nil {
+		t.Fatalf("NewResumableProcessor() error = %v", err)
+	}
+	defer p.db.Close()
+	if _, err := db.Exec(`TRUNCATE processed_files, processing_jobs, processing_checkpoints, dedupe_stats`); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if err := p.Run(ctx); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// The flat layout nests clones as <owner>/<repo> and the processor
+	// takes each directory under REPOS_DIR as a repository, so every
+	// fixture owner is one job
+	var completed, failed int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FILTER (WHERE status = 'completed'), COUNT(*) FILTER (WHERE status = 'failed')
+		FROM processing_jobs`).Scan(&completed, &failed); err != nil {
+		t.Fatal(err)
+	}
+	if completed != 3 || failed != 0 {
+		t.Errorf("jobs: %d completed and %d failed, want 3 completed", completed, failed)
+	}
+
+	rows, err := db.Query(`SELECT language, COUNT(*) FROM processed_files GROUP BY language`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	got := make(map[string]int)
+	for rows.Next() {
+		var language string
+		var n int
+		if err := rows.Scan(&language, &n); err != nil {
+			t.Fatal(err)
+		}
+		got[language] = n
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"Go": 3, "Python": 3, "Rust": 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("processed files by language = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"codelupe/internal/dataset"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Run with: go test dataset_analyzer.go dataset_analyzer_test.go
+
+func newMockDatasetAnalyzer(t *testing.T) (*DatasetAnalyzer, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return &DatasetAnalyzer{Analyzer: dataset.NewAnalyzer(db), db: db}, mock
+}
+
+// expectReportQueries mocks a dataset of 100 Go and 50 Python files
+func expectReportQueries(mock sqlmock.Sqlmock) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("COUNT\\(\\*\\) as total_files").
+		WillReturnRows(sqlmock.NewRows([]string{"total_files", "total_size", "total_repos",
+			"avg_quality", "avg_file_size", "avg_lines_per_file"}).
+			AddRow(150, 300000, 3, 82.5, 2000.0, 80.0))
+	mock.ExpectQuery("MIN\\(processed_at\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"start_time", "end_time"}).
+			AddRow(start, start.Add(90*time.Second)))
+	mock.ExpectQuery("GROUP BY language").
+		WillReturnRows(sqlmock.NewRows([]string{"language", "file_count", "total_size",
+			"avg_size", "avg_quality", "avg_lines"}).
+			AddRow("Go", 100, 200000, 2000.0, 85.0, 90.0).
+			AddRow("Python", 50, 100000, 2000.0, 77.5, 60.0))
+	mock.ExpectQuery("WHERE language = \\$1").WithArgs("Go", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"repo_name", "file_count", "total_size"}).
+			AddRow("acme/widgets", 100, 200000))
+	mock.ExpectQuery("WHERE language = \\$1").WithArgs("Python", 5).
+		WillReturnRows(sqlmock.NewRows([]string{"repo_name", "file_count", "total_size"}).
+			AddRow("acme/tools", 30, 60000).
+			AddRow("acme/scripts", 20, 40000))
+	mock.ExpectQuery("license_key").
+		WillReturnRows(sqlmock.NewRows([]string{"license", "file_count", "repo_count", "total_size"}).
+			AddRow("mit", 150, 3, 300000))
+	mock.ExpectQuery("quality_tier").
+		WillReturnRows(sqlmock.NewRows([]string{"quality_tier", "file_count"}).
+			AddRow("Good (80-89)", 120).
+			AddRow("Fair (70-79)", 30))
+}
+
+func TestWriteReport_JSON(t *testing.T) {
+	da, mock := newMockDatasetAnalyzer(t)
+	expectReportQueries(mock)
+
+	var buf bytes.Buffer
+	if err := da.writeReport(&buf, "json"); err != nil {
+		t.Fatalf("writeReport() error = %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	// Decode generically so the test pins the field names consumers rely on
+	var report map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	for _, key := range []string{"generated_at", "processing_time_seconds", "overall", "quality_distribution"} {
+		if _, ok := report[key]; !ok {
+			t.Errorf("report is missing %q", key)
+		}
+	}
+	if report["processing_time_seconds"] != 90.0 {
+		t.Errorf("processing_time_seconds = %v, want 90", report["processing_time_seconds"])
+	}
+
+	overall := report["overall"].(map[string]any)
+	for _, key := range []string{"total_files", "total_size", "total_repos", "avg_quality",
+		"avg_file_size", "avg_lines_per_file", "languages", "licenses"} {
+		if _, ok := overall[key]; !ok {
+			t.Errorf("overall is missing %q", key)
+		}
+	}
+	if overall["total_files"] != 150.0 {
+		t.Errorf("total_files = %v, want 150", overall["total_files"])
+	}
+
+	languages := overall["languages"].([]any)
+	if len(languages) != 2 {
+		t.Fatalf("got %d languages, want 2", len(languages))
+	}
+	python := languages[1].(map[string]any)
+	if python["language"] != "Python" || python["file_count"] != 50.0 {
+		t.Errorf("languages[1] = %v, want Python with 50 files", python)
+	}
+	for _, key := range []string{"total_size", "avg_size", "avg_quality", "avg_lines", "percentage", "size_percentage"} {
+		if _, ok := python[key]; !ok {
+			t.Errorf("language stats missing %q", key)
+		}
+	}
+	topRepo := python["top_repos"].([]any)[0].(map[string]any)
+	if topRepo["repo_name"] != "acme/tools" || topRepo["file_count"] != 30.0 || topRepo["total_size"] != 60000.0 {
+		t.Errorf("top_repos[0] = %v", topRepo)
+	}
+
+	license := overall["licenses"].([]any)[0].(map[string]any)
+	if license["license"] != "mit" || license["repo_count"] != 3.0 || license["percentage"] != 100.0 {
+		t.Errorf("licenses[0] = %v", license)
+	}
+
+	tiers := report["quality_distribution"].([]any)
+	if len(tiers) != 2 {
+		t.Fatalf("got %d quality tiers, want 2", len(tiers))
+	}
+	good := tiers[0].(map[string]any)
+	if good["tier"] != "Good (80-89)" || good["file_count"] != 120.0 || good["percentage"] != 80.0 {
+		t.Errorf("quality_distribution[0] = %v", good)
+	}
+}
+
+func TestWriteReport_CSV(t *testing.T) {
+	da, mock := newMockDatasetAnalyzer(t)
+	expectReportQueries(mock)
+
+	var buf bytes.Buffer
+	if err := da.writeReport(&buf, "csv"); err != nil {
+		t.Fatalf("writeReport() error = %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("output is not valid CSV: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d rows, want a header and one per language", len(records))
+	}
+	if records[0][0] != "language" || records[0][1] != "file_count" {
+		t.Errorf("header = %v", records[0])
+	}
+	if got := records[1]; got[0] != "Go" || got[1] != "100" || got[2] != "66.67" {
+		t.Errorf("Go row = %v", got)
+	}
+}
@@ -1,31 +1,55 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"crypto/md5"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"codelupe/pkg/langdetect"
+
 	"github.com/joho/godotenv"
-	"github.com/lib/pq"
 	_ "github.com/lib/pq"
+	"gopkg.in/yaml.v3"
 )
 
 type QualityAnalyzer struct {
 	db               *sql.DB
+	profile          string // profile the settings below started from
 	securityPatterns map[string]*regexp.Regexp
 	excludePatterns  []*regexp.Regexp
 	languageWeights  map[string]float64
 	minQualityScore  float64
 	maxFilesPerRepo  int
+	workers          int // files analyzed concurrently per repository
+
+	// Compiled qualityIndicators, codeSmellPatterns and controlFlowPatterns,
+	// matched against every file
+	qualityIndicators []*regexp.Regexp
+	codeSmells        []*regexp.Regexp
+	controlFlow       []*regexp.Regexp
+
+	// force re-analyzes repositories even when a stored analysis matches
+	// their current content
+	force bool
+
+	// walkDir walks a repository for analysis; replaced in tests
+	walkDir func(root string, fn fs.WalkDirFunc) error
 }
 
 type RepoQuality struct {
@@ -34,7 +58,8 @@ type RepoQuality struct {
 	LocalPath        string
 	QualityScore     float64
 	SecurityScore    float64
-	CodeFiles        []CodeFile
+	Fingerprint      string     // content the analysis was made from, see repoFingerprint
+	CodeFiles        []CodeFile // only the storedTopFiles best when loaded from analysis_results
 	TotalFiles       int
 	ValidFiles       int
 	TotalLines       int
@@ -54,9 +79,16 @@ type CodeFile struct {
 	QualityScore     float64
 	Complexity       int
 	IsHighQuality    bool
-	Content          string
+	Hash             string // md5 of the content, which isn't kept
 }
 
+// storedTopFiles is how many files raw_result lists, best first
+const storedTopFiles = 50
+
+// fileSink receives the content of each high-quality file as it is
+// analyzed. It is called from several workers at once.
+type fileSink func(file *CodeFile, content []byte)
+
 type QualityMetrics struct {
 	AvgLinesPerFile     float64
 	FunctionDensity     float64
@@ -68,44 +100,65 @@ type QualityMetrics struct {
 	ComplexityScore     float64
 }
 
+// Files/directories to completely exclude, grouped so profiles can pick
+// which kinds of files they leave out
 var (
-	// Files/directories to completely exclude
-	excludePatterns = []string{
-		// Documentation and metadata
+	docExcludePatterns = []string{
 		`(?i)readme\.md$`, `(?i)changelog\.md$`, `(?i)license\.?.*$`, `(?i)contributing\.md$`,
 		`(?i)code_of_conduct\.md$`, `(?i)security\.md$`, `(?i)authors\.md$`, `(?i)maintainers\.md$`,
 		`(?i)\.github/`, `(?i)docs?/`, `(?i)documentation/`, `(?i)wiki/`,
+	}
 
-		// Generated files
+	generatedExcludePatterns = []string{
 		`(?i)\.pb\.go$`, `(?i)\.pb\.py$`, `(?i)_pb2\.py$`, `(?i)\.proto$`,
 		`(?i)\.generated\.`, `(?i)\.gen\.`, `(?i)autogen`, `(?i)codegen`,
 		`(?i)vendor/`, `(?i)node_modules/`, `(?i)\.git/`, `(?i)\.svn/`,
 		`(?i)build/`, `(?i)dist/`, `(?i)target/`, `(?i)bin/`, `(?i)obj/`,
+	}
 
-		// Config and data files
+	configExcludePatterns = []string{
 		`(?i)\.json$`, `(?i)\.xml$`, `(?i)\.yaml$`, `(?i)\.yml$`, `(?i)\.toml$`,
 		`(?i)\.ini$`, `(?i)\.cfg$`, `(?i)\.conf$`, `(?i)config\.`, `(?i)\.env$`,
 		`(?i)\.txt$`, `(?i)\.log$`, `(?i)\.csv$`, `(?i)\.tsv$`,
+	}
 
-		// Media and binary files
+	binaryExcludePatterns = []string{
 		`(?i)\.(png|jpg|jpeg|gif|svg|ico|pdf|zip|tar|gz|bz2|xz)$`,
 		`(?i)\.(exe|dll|so|dylib|bin|dat|db|sqlite)$`,
+	}
 
-		// Lock files and package management
+	lockExcludePatterns = []string{
 		`(?i)package-lock\.json$`, `(?i)yarn\.lock$`, `(?i)composer\.lock$`,
 		`(?i)pipfile\.lock$`, `(?i)poetry\.lock$`, `(?i)go\.sum$`,
+	}
 
-		// Test fixtures and samples that aren't quality code
+	// Test fixtures and samples that aren't quality code
+	fixtureExcludePatterns = []string{
 		`(?i)fixtures?/`, `(?i)samples?/`, `(?i)examples?/.*\.(txt|dat|bin)$`,
 		`(?i)test.*\.(json|xml|yaml|yml)$`, `(?i)mock.*\.(json|xml|yaml|yml)$`,
+	}
 
-		// Minified and compressed code
+	minifiedExcludePatterns = []string{
 		`(?i)\.min\.js$`, `(?i)\.min\.css$`, `(?i)-min\.`, `(?i)\.bundle\.`,
+	}
 
-		// IDE and editor files
+	editorExcludePatterns = []string{
 		`(?i)\.vscode/`, `(?i)\.idea/`, `(?i)\.eclipse/`, `(?i)\.(DS_Store|gitignore|gitkeep)$`,
 	}
 
+	// strictExcludePatterns are only left out by the strict profile: code
+	// that is vendored, mocked or mechanical rather than written
+	strictExcludePatterns = []string{
+		`(?i)third_party/`, `(?i)mocks?/`, `(?i)migrations?/`, `(?i)\.d\.ts$`, `(?i)_mock\.go$`,
+	}
+)
+
+var (
+	// Files/directories excluded by the balanced profile
+	excludePatterns = concat(docExcludePatterns, generatedExcludePatterns, configExcludePatterns,
+		binaryExcludePatterns, lockExcludePatterns, fixtureExcludePatterns,
+		minifiedExcludePatterns, editorExcludePatterns)
+
 	// High-value coding patterns for target technologies
 	codingPatterns = map[string]string{
 		"angular":       `(?i)(angular|@angular|component|service|module|directive|pipe|injectable|ngrx|rxjs)`,
@@ -159,83 +212,527 @@ var (
 		`(?i)collection`, `(?i)aggregate`, `(?i)find\(`, // MongoDB
 		`(?i)index`, `(?i)mapping`, `(?i)search`, // Elasticsearch
 	}
+
+	// Code smells that cost a file its quality bonus
+	codeSmellPatterns = []string{
+		`(?i)print\(.*debug`, `(?i)console\.log`, // Debug prints
+		`(?i)todo.*hack`, `(?i)fixme.*hack`, // Hack comments
+		`(?i)copy.*paste`, `(?i)duplicate`, // Copy-paste indicators
+		`(?i)lorem.*ipsum`,     // Placeholder text
+		`(?i)test.*test.*test`, // Repetitive test names
+	}
+
+	// Control flow statements counted for complexity
+	controlFlowPatterns = []string{
+		`if\s*\(`, `else`, `while\s*\(`, `for\s*\(`, `switch\s*\(`,
+		`case\s+`, `catch\s*\(`, `try\s*{`, `finally\s*{`,
+	}
 )
 
-func NewQualityAnalyzer() (*QualityAnalyzer, error) {
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: .env file not found: %v", err)
+// Analyzer profiles, preset bundles of the settings below selected with
+// --profile or QUALITY_PROFILE
+const (
+	ProfileStrict     = "strict"
+	ProfileBalanced   = "balanced"
+	ProfilePermissive = "permissive"
+)
+
+// analyzerSettings are the knobs that decide which files are analyzed and
+// which of them count as high quality
+type analyzerSettings struct {
+	Profile         string
+	MinQualityScore float64
+	MaxFilesPerRepo int
+	ExcludePatterns []string
+	LanguageWeights map[string]float64
+}
+
+func (s analyzerSettings) String() string {
+	return fmt.Sprintf("profile=%s min_quality_score=%.2f max_files_per_repo=%d exclude_patterns=%d language_weights=%d",
+		s.Profile, s.MinQualityScore, s.MaxFilesPerRepo, len(s.ExcludePatterns), len(s.LanguageWeights))
+}
+
+// profileSettings returns the preset bundle for profile. Balanced is what
+// the analyzer has always used; strict keeps fewer, better files and
+// permissive keeps config and docs alongside the code.
+func profileSettings(profile string) (analyzerSettings, error) {
+	settings := analyzerSettings{
+		Profile:         profile,
+		MinQualityScore: 0.7,  // Only keep high-quality code
+		MaxFilesPerRepo: 1000, // Prevent processing massive repos
+		ExcludePatterns: excludePatterns,
+		LanguageWeights: make(map[string]float64, len(languageWeights)),
+	}
+	for language, weight := range languageWeights {
+		settings.LanguageWeights[language] = weight
+	}
+
+	switch profile {
+	case ProfileBalanced:
+	case ProfileStrict:
+		settings.MinQualityScore = 0.8
+		settings.MaxFilesPerRepo = 500
+		settings.ExcludePatterns = concat(excludePatterns, strictExcludePatterns)
+	case ProfilePermissive:
+		settings.MinQualityScore = 0.5
+		settings.MaxFilesPerRepo = 5000
+		settings.ExcludePatterns = concat(generatedExcludePatterns, binaryExcludePatterns,
+			lockExcludePatterns, minifiedExcludePatterns, editorExcludePatterns)
+		settings.LanguageWeights["json"] = 0.6
+		settings.LanguageWeights["yaml"] = 0.6
+		settings.LanguageWeights["toml"] = 0.6
+	default:
+		return analyzerSettings{}, fmt.Errorf("unknown profile %q, want strict, balanced or permissive", profile)
+	}
+	return settings, nil
+}
+
+// QualityAnalyzerConfig is the YAML file named by QUALITY_ANALYZER_CONFIG.
+// Fields left out keep the profile's value.
+type QualityAnalyzerConfig struct {
+	Profile         string   `yaml:"profile"`
+	MinQualityScore *float64 `yaml:"min_quality_score"`
+	MaxFilesPerRepo *int     `yaml:"max_files_per_repo"`
+	// ExcludePatterns replaces the profile's patterns
+	ExcludePatterns []string `yaml:"exclude_patterns"`
+	// LanguageWeights are merged over the profile's weights
+	LanguageWeights map[string]float64 `yaml:"language_weights"`
+}
+
+// configProblem is one unusable value in a config file
+type configProblem struct {
+	Line  int
+	Field string
+	Err   string
+}
+
+func (p configProblem) String() string {
+	return fmt.Sprintf("%d: %s: %s", p.Line, p.Field, p.Err)
+}
+
+// parseAnalyzerConfig decodes a config file, rejecting unknown fields, and
+// reports every value that can't be used along with the line it is on
+func parseAnalyzerConfig(data []byte) (QualityAnalyzerConfig, []configProblem, error) {
+	var cfg QualityAnalyzerConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, nil, err
 	}
 
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return cfg, nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return cfg, nil, nil
+	}
+
+	var problems []configProblem
+	fields := doc.Content[0].Content
+	for i := 0; i+1 < len(fields); i += 2 {
+		key, value := fields[i].Value, fields[i+1]
+		switch key {
+		case "profile":
+			if _, err := profileSettings(cfg.Profile); err != nil {
+				problems = append(problems, configProblem{value.Line, key, err.Error()})
+			}
+		case "min_quality_score":
+			if score := *cfg.MinQualityScore; score < 0 || score > 1 {
+				problems = append(problems, configProblem{value.Line, key, fmt.Sprintf("must be between 0 and 1, got %g", score)})
+			}
+		case "max_files_per_repo":
+			if max := *cfg.MaxFilesPerRepo; max < 1 {
+				problems = append(problems, configProblem{value.Line, key, fmt.Sprintf("must be at least 1, got %d", max)})
+			}
+		case "exclude_patterns":
+			for j, item := range value.Content {
+				if _, err := regexp.Compile(item.Value); err != nil {
+					problems = append(problems, configProblem{item.Line, fmt.Sprintf("%s[%d]", key, j), err.Error()})
+				}
+			}
+		case "language_weights":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				language := value.Content[j].Value
+				if weight := cfg.LanguageWeights[language]; weight < 0 {
+					problems = append(problems, configProblem{value.Content[j+1].Line, key + "." + language,
+						fmt.Sprintf("must not be negative, got %g", weight)})
+				}
+			}
+		}
+	}
+	return cfg, problems, nil
+}
+
+// apply overrides settings with the fields set in the config
+func (cfg QualityAnalyzerConfig) apply(settings analyzerSettings) analyzerSettings {
+	if cfg.MinQualityScore != nil {
+		settings.MinQualityScore = *cfg.MinQualityScore
+	}
+	if cfg.MaxFilesPerRepo != nil {
+		settings.MaxFilesPerRepo = *cfg.MaxFilesPerRepo
+	}
+	if cfg.ExcludePatterns != nil {
+		settings.ExcludePatterns = cfg.ExcludePatterns
+	}
+	for language, weight := range cfg.LanguageWeights {
+		settings.LanguageWeights[language] = weight
+	}
+	return settings
+}
+
+// loadAnalyzerSettings resolves the effective settings. The profile comes
+// from the flag, QUALITY_PROFILE or the config file, in that order, and
+// defaults to balanced. The config file named by QUALITY_ANALYZER_CONFIG is
+// applied over the profile, then the QUALITY_* variables over both.
+func loadAnalyzerSettings(profile string) (analyzerSettings, error) {
+	var cfg QualityAnalyzerConfig
+	if path := os.Getenv("QUALITY_ANALYZER_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return analyzerSettings{}, fmt.Errorf("failed to read quality analyzer config: %w", err)
+		}
+		var problems []configProblem
+		if cfg, problems, err = parseAnalyzerConfig(data); err != nil {
+			return analyzerSettings{}, fmt.Errorf("invalid quality analyzer config %s: %w", path, err)
+		}
+		if len(problems) > 0 {
+			return analyzerSettings{}, fmt.Errorf("invalid quality analyzer config %s:%s (and %d more, see validate-config)",
+				path, problems[0], len(problems)-1)
+		}
+	}
+
+	if profile == "" {
+		profile = os.Getenv("QUALITY_PROFILE")
+	}
+	if profile == "" {
+		profile = cfg.Profile
+	}
+	if profile == "" {
+		profile = ProfileBalanced
+	}
+	settings, err := profileSettings(profile)
+	if err != nil {
+		return analyzerSettings{}, err
+	}
+	settings = cfg.apply(settings)
+
+	if value := os.Getenv("QUALITY_MIN_SCORE"); value != "" {
+		score, err := strconv.ParseFloat(value, 64)
+		if err != nil || score < 0 || score > 1 {
+			return analyzerSettings{}, fmt.Errorf("QUALITY_MIN_SCORE must be a number between 0 and 1, got %q", value)
+		}
+		settings.MinQualityScore = score
+	}
+	if value := os.Getenv("QUALITY_MAX_FILES_PER_REPO"); value != "" {
+		max, err := strconv.Atoi(value)
+		if err != nil || max < 1 {
+			return analyzerSettings{}, fmt.Errorf("QUALITY_MAX_FILES_PER_REPO must be a positive integer, got %q", value)
+		}
+		settings.MaxFilesPerRepo = max
+	}
+	if value := os.Getenv("QUALITY_EXCLUDE_PATTERNS"); value != "" {
+		settings.ExcludePatterns = nil
+		for _, pattern := range strings.Split(value, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				settings.ExcludePatterns = append(settings.ExcludePatterns, pattern)
+			}
+		}
+	}
+	if value := os.Getenv("QUALITY_LANGUAGE_WEIGHTS"); value != "" {
+		for _, pair := range strings.Split(value, ",") {
+			language, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			w, err := strconv.ParseFloat(weight, 64)
+			if !ok || language == "" || err != nil || w < 0 {
+				return analyzerSettings{}, fmt.Errorf("QUALITY_LANGUAGE_WEIGHTS entries must look like go=0.95, got %q", pair)
+			}
+			settings.LanguageWeights[language] = w
+		}
+	}
+	return settings, nil
+}
+
+func NewQualityAnalyzer(settings analyzerSettings) (*QualityAnalyzer, error) {
 	db, err := connectPostgreSQL()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
 	}
 
+	qa := newQualityAnalyzer(db)
+	if err := qa.configure(settings); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return qa, nil
+}
+
+// newQualityAnalyzer builds an analyzer on db with the balanced profile,
+// compiling every pattern once
+func newQualityAnalyzer(db *sql.DB) *QualityAnalyzer {
 	// Compile coding patterns
 	compiledPatterns := make(map[string]*regexp.Regexp)
 	for name, pattern := range codingPatterns {
 		compiledPatterns[name] = regexp.MustCompile(pattern)
 	}
 
-	// Compile exclude patterns
-	var compiledExcludes []*regexp.Regexp
-	for _, pattern := range excludePatterns {
-		compiledExcludes = append(compiledExcludes, regexp.MustCompile(pattern))
+	qa := &QualityAnalyzer{
+		db:                db,
+		securityPatterns:  compiledPatterns, // Now contains coding patterns
+		qualityIndicators: compileAll(qualityIndicators),
+		codeSmells:        compileAll(codeSmellPatterns),
+		controlFlow:       compileAll(controlFlowPatterns),
+		workers:           runtime.NumCPU(),
+		walkDir:           filepath.WalkDir,
+	}
+	settings, _ := profileSettings(ProfileBalanced)
+	if err := qa.configure(settings); err != nil {
+		panic(err)
+	}
+	return qa
+}
+
+// configure switches the analyzer to settings, compiling its exclude patterns
+func (qa *QualityAnalyzer) configure(settings analyzerSettings) error {
+	compiledExcludes := make([]*regexp.Regexp, len(settings.ExcludePatterns))
+	for i, pattern := range settings.ExcludePatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+		compiledExcludes[i] = re
+	}
+
+	qa.profile = settings.Profile
+	qa.excludePatterns = compiledExcludes
+	qa.languageWeights = settings.LanguageWeights
+	qa.minQualityScore = settings.MinQualityScore
+	qa.maxFilesPerRepo = settings.MaxFilesPerRepo
+	return nil
+}
+
+// runValidateConfig checks the config file at path without connecting to
+// anything, writing one path:line: field: error line per problem
+func runValidateConfig(path string, w io.Writer) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	cfg, problems, err := parseAnalyzerConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(w, "%s:%s\n", path, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d invalid settings", path, len(problems))
+	}
+
+	profile := cfg.Profile
+	if profile == "" {
+		profile = ProfileBalanced
+	}
+	settings, _ := profileSettings(profile)
+	fmt.Fprintf(w, "%s: ok (%s)\n", path, cfg.apply(settings))
+	return nil
+}
+
+func concat(groups ...[]string) []string {
+	var all []string
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+func compileAll(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = regexp.MustCompile(pattern)
+	}
+	return compiled
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func connectPostgreSQL() (*sql.DB, error) {
+	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		getEnv("POSTGRES_HOST", "localhost"), getEnv("POSTGRES_PORT", "5432"),
+		getEnv("POSTGRES_USER", "coding_user"), getEnv("POSTGRES_PASSWORD", "coding_pass"),
+		getEnv("POSTGRES_DB", "coding_db"))
+
+	db, err := sql.Open("postgres", psqlInfo)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// repoFingerprint identifies the content of a checkout: the HEAD commit for
+// git repositories, otherwise a hash of every file's path, size and mtime.
+// A re-downloaded repository gets a new fingerprint.
+func repoFingerprint(repoPath string) (string, error) {
+	if commit, err := gitHead(repoPath); err == nil {
+		return "git:" + commit, nil
+	}
+
+	h := md5.New()
+	err := filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(repoPath, path)
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", filepath.ToSlash(rel), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return "tree:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// gitHead resolves .git/HEAD to a commit without shelling out to git
+func gitHead(repoPath string) (string, error) {
+	gitDir := filepath.Join(repoPath, ".git")
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+
+	ref, symbolic := strings.CutPrefix(strings.TrimSpace(string(head)), "ref: ")
+	if !symbolic {
+		return ref, nil // detached HEAD
+	}
+	if commit, err := os.ReadFile(filepath.Join(gitDir, filepath.FromSlash(ref))); err == nil {
+		return strings.TrimSpace(string(commit)), nil
+	}
+
+	packed, err := os.ReadFile(filepath.Join(gitDir, "packed-refs"))
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(packed), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[1] == ref {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("ref %s not found", ref)
+}
+
+// AnalyzeCached returns the stored analysis of a repository when it was made
+// from the same content, without walking the tree again. Otherwise, or with
+// force set, it runs AnalyzeRepository. cached reports which happened.
+func (qa *QualityAnalyzer) AnalyzeCached(repoPath, repoID, fullName string) (quality *RepoQuality, cached bool, err error) {
+	fingerprint, err := repoFingerprint(repoPath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fingerprint repository: %w", err)
+	}
+
+	if !qa.force {
+		quality, err := qa.storedAnalysis(repoID, fingerprint)
+		if err != nil {
+			return nil, false, err
+		}
+		if quality != nil {
+			return quality, true, nil
+		}
+	}
+
+	quality, err = qa.analyzeRepository(repoPath, repoID, fullName, fingerprint)
+	return quality, false, err
+}
+
+// storedAnalysis loads the latest analysis of a repository made from
+// content with fingerprint, or nil if there is none
+func (qa *QualityAnalyzer) storedAnalysis(repoID, fingerprint string) (*RepoQuality, error) {
+	var raw []byte
+	err := qa.db.QueryRow(`
+		SELECT raw_result FROM analysis_results
+		WHERE repository_id = $1 AND analysis_type = 'quality_analysis'
+		  AND raw_result->>'Fingerprint' = $2
+		ORDER BY created_at DESC
+		LIMIT 1`, repoID, fingerprint).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load stored analysis: %w", err)
 	}
 
-	return &QualityAnalyzer{
-		db:               db,
-		securityPatterns: compiledPatterns, // Now contains coding patterns
-		excludePatterns:  compiledExcludes,
-		languageWeights:  languageWeights,
-		minQualityScore:  0.7,  // Only keep high-quality code
-		maxFilesPerRepo:  1000, // Prevent processing massive repos
-	}, nil
+	var quality RepoQuality
+	if err := json.Unmarshal(raw, &quality); err != nil {
+		return nil, fmt.Errorf("failed to decode stored analysis: %w", err)
+	}
+	return &quality, nil
 }
 
 func (qa *QualityAnalyzer) AnalyzeRepository(repoPath, repoID, fullName string) (*RepoQuality, error) {
+	fingerprint, err := repoFingerprint(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fingerprint repository: %w", err)
+	}
+	return qa.analyzeRepository(repoPath, repoID, fullName, fingerprint)
+}
+
+func (qa *QualityAnalyzer) analyzeRepository(repoPath, repoID, fullName, fingerprint string) (*RepoQuality, error) {
 	log.Printf("Analyzing repository quality: %s", fullName)
 
+	quality, err := qa.analyzeFiles(repoPath, repoID, fullName, fingerprint, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store results in database
+	if err := qa.storeQualityResults(quality); err != nil {
+		log.Printf("Failed to store quality results: %v", err)
+	}
+
+	log.Printf("Repository %s: Quality=%.2f, Security=%.2f, Files=%d/%d",
+		fullName, quality.QualityScore, quality.SecurityScore, quality.ValidFiles, quality.TotalFiles)
+
+	return quality, nil
+}
+
+// analyzeFiles scores every file of a repository and the repository as a
+// whole. File contents are dropped once scored, after high-quality ones are
+// handed to sink if it isn't nil.
+func (qa *QualityAnalyzer) analyzeFiles(repoPath, repoID, fullName, fingerprint string, sink fileSink) (*RepoQuality, error) {
 	quality := &RepoQuality{
 		ID:               repoID,
 		FullName:         fullName,
 		LocalPath:        repoPath,
 		Languages:        make(map[string]int),
 		SecurityPatterns: make(map[string]int), // Now contains coding patterns
+		Fingerprint:      fingerprint,
 		CreatedAt:        time.Now(),
 	}
 
-	// Walk through repository files
-	err := filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+	// Collect paths first so the file limit picks the same files every run
+	var paths []string
+	err := qa.walkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return nil // Skip files we can't read
 		}
 
-		// Skip if we've hit the file limit
-		if quality.TotalFiles >= qa.maxFilesPerRepo {
-			return filepath.SkipDir
-		}
-
 		// Skip directories and excluded files
 		if d.IsDir() || qa.shouldExcludeFile(path) {
 			return nil
 		}
 
-		quality.TotalFiles++
-
-		// Analyze the file
-		if codeFile, err := qa.analyzeFile(path, repoPath); err == nil && codeFile != nil {
-			quality.CodeFiles = append(quality.CodeFiles, *codeFile)
-			quality.ValidFiles++
-			quality.ValidLines += codeFile.Lines
-			quality.Languages[codeFile.Language]++
-
-			// Count coding patterns
-			for _, pattern := range codeFile.SecurityPatterns { // Field name kept for compatibility
-				quality.SecurityPatterns[pattern]++
-			}
-		}
-
+		paths = append(paths, path)
 		return nil
 	})
 
@@ -243,16 +740,62 @@ func (qa *QualityAnalyzer) AnalyzeRepository(repoPath, repoID, fullName string)
 		return nil, fmt.Errorf("failed to walk repository: %w", err)
 	}
 
-	// Calculate quality metrics
-	qa.calculateQualityMetrics(quality)
+	sort.Strings(paths)
+	if len(paths) > qa.maxFilesPerRepo {
+		paths = paths[:qa.maxFilesPerRepo]
+	}
+	quality.TotalFiles = len(paths)
 
-	// Store results in database
-	if err := qa.storeQualityResults(quality); err != nil {
-		log.Printf("Failed to store quality results: %v", err)
+	// Analyze files across the worker pool
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	pathChan := make(chan string)
+
+	workers := qa.workers
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range pathChan {
+				codeFile, content, err := qa.analyzeFile(path, repoPath)
+				if err != nil || codeFile == nil {
+					continue
+				}
+				if sink != nil && codeFile.IsHighQuality {
+					sink(codeFile, content)
+				}
+
+				mu.Lock()
+				quality.CodeFiles = append(quality.CodeFiles, *codeFile)
+				quality.ValidFiles++
+				quality.ValidLines += codeFile.Lines
+				quality.Languages[codeFile.Language]++
+
+				// Count coding patterns
+				for _, pattern := range codeFile.SecurityPatterns { // Field name kept for compatibility
+					quality.SecurityPatterns[pattern]++
+				}
+				mu.Unlock()
+			}
+		}()
 	}
 
-	log.Printf("Repository %s: Quality=%.2f, Security=%.2f, Files=%d/%d",
-		fullName, quality.QualityScore, quality.SecurityScore, quality.ValidFiles, quality.TotalFiles)
+	for _, path := range paths {
+		pathChan <- path
+	}
+	close(pathChan)
+	wg.Wait()
+
+	// Workers finish in any order
+	sort.Slice(quality.CodeFiles, func(i, j int) bool {
+		return quality.CodeFiles[i].Path < quality.CodeFiles[j].Path
+	})
+
+	// Calculate quality metrics
+	qa.calculateQualityMetrics(quality)
 
 	return quality, nil
 }
@@ -266,28 +809,36 @@ func (qa *QualityAnalyzer) shouldExcludeFile(path string) bool {
 	return false
 }
 
-func (qa *QualityAnalyzer) analyzeFile(filePath, repoRoot string) (*CodeFile, error) {
+// analyzeFile scores a single file, returning its content alongside for
+// the caller to extract or drop
+func (qa *QualityAnalyzer) analyzeFile(filePath, repoRoot string) (*CodeFile, []byte, error) {
 	// Get relative path
 	relPath, err := filepath.Rel(repoRoot, filePath)
 	if err != nil {
 		relPath = filePath
 	}
 
-	// Detect language
-	language := qa.detectLanguage(filePath)
-	if language == "" {
-		return nil, fmt.Errorf("unsupported language")
+	// Extensionless files may still be scripts, so only known non-code
+	// names are rejected before reading
+	if !langdetect.Candidate(filePath) {
+		return nil, nil, fmt.Errorf("unsupported language")
 	}
 
 	// Read file content
 	content, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Skip binary files and very large files
 	if len(content) == 0 || len(content) > 1024*1024 || qa.isBinaryContent(content) {
-		return nil, fmt.Errorf("binary or oversized file")
+		return nil, nil, fmt.Errorf("binary or oversized file")
+	}
+
+	// Detect language
+	language := qa.detectLanguage(filePath, content)
+	if language == "" {
+		return nil, nil, fmt.Errorf("unsupported language")
 	}
 
 	contentStr := string(content)
@@ -295,65 +846,41 @@ func (qa *QualityAnalyzer) analyzeFile(filePath, repoRoot string) (*CodeFile, er
 
 	// Skip very small files (likely not meaningful)
 	if lines < 10 {
-		return nil, fmt.Errorf("file too small")
+		return nil, nil, fmt.Errorf("file too small")
 	}
 
 	codeFile := &CodeFile{
 		Path:     relPath,
 		Language: language,
 		Lines:    lines,
-		Content:  contentStr,
 	}
+	sum := md5.Sum(content)
+	codeFile.Hash = hex.EncodeToString(sum[:])
 
 	// Find coding patterns
 	codeFile.SecurityPatterns = qa.findCodingPatterns(contentStr) // Field name kept for compatibility
 
 	// Calculate code quality
-	codeFile.QualityScore = qa.calculateFileQuality(codeFile)
+	codeFile.QualityScore = qa.calculateFileQuality(codeFile, contentStr)
 	codeFile.Complexity = qa.calculateComplexity(contentStr, language)
 
 	// Determine if this is high-quality code worth keeping
 	codeFile.IsHighQuality = codeFile.QualityScore >= qa.minQualityScore
 
-	return codeFile, nil
+	return codeFile, content, nil
 }
 
-func (qa *QualityAnalyzer) detectLanguage(filePath string) string {
-	ext := strings.ToLower(filepath.Ext(filePath))
-	base := strings.ToLower(filepath.Base(filePath))
-
-	langMap := map[string]string{
-		".go":  "go",
-		".py":  "python",
-		".rs":  "rust",
-		".c":   "c",
-		".cpp": "cpp", ".cc": "cpp", ".cxx": "cpp",
-		".java": "java",
-		".js":   "javascript", ".mjs": "javascript",
-		".ts":  "typescript",
-		".cs":  "csharp",
-		".rb":  "ruby",
-		".php": "php",
-		".sh":  "shell", ".bash": "shell",
-		".ps1":   "powershell",
-		".pl":    "perl",
-		".swift": "swift",
-		".kt":    "kotlin",
-		".scala": "scala",
-		".hs":    "haskell",
-		".asm":   "assembly", ".s": "assembly",
-	}
-
-	if lang, ok := langMap[ext]; ok {
-		return lang
-	}
-
-	// Special cases
-	if base == "makefile" || base == "dockerfile" {
-		return "shell"
+// detectLanguage returns the analyzer's lowercase language id (the keys of
+// languageWeights), e.g. "cpp" for C++
+func (qa *QualityAnalyzer) detectLanguage(filePath string, content []byte) string {
+	switch lang := langdetect.Detect(filePath, content); lang {
+	case "C++":
+		return "cpp"
+	case "C#":
+		return "csharp"
+	default:
+		return strings.ToLower(lang)
 	}
-
-	return ""
 }
 
 func (qa *QualityAnalyzer) findCodingPatterns(content string) []string {
@@ -366,7 +893,7 @@ func (qa *QualityAnalyzer) findCodingPatterns(content string) []string {
 	return patterns
 }
 
-func (qa *QualityAnalyzer) calculateFileQuality(file *CodeFile) float64 {
+func (qa *QualityAnalyzer) calculateFileQuality(file *CodeFile, content string) float64 {
 	score := 0.0
 
 	// Language weight
@@ -390,15 +917,15 @@ func (qa *QualityAnalyzer) calculateFileQuality(file *CodeFile) float64 {
 
 	// Quality indicators
 	qualityCount := 0
-	for _, indicator := range qualityIndicators {
-		if matched, _ := regexp.MatchString(indicator, file.Content); matched {
+	for _, indicator := range qa.qualityIndicators {
+		if indicator.MatchString(content) {
 			qualityCount++
 		}
 	}
 	score += float64(qualityCount) * 0.05
 
 	// Penalize files with obvious code smells
-	if qa.hasCodeSmells(file.Content) {
+	if qa.hasCodeSmells(content) {
 		score -= 0.3
 	}
 
@@ -414,16 +941,8 @@ func (qa *QualityAnalyzer) calculateFileQuality(file *CodeFile) float64 {
 }
 
 func (qa *QualityAnalyzer) hasCodeSmells(content string) bool {
-	smells := []string{
-		`(?i)print\(.*debug`, `(?i)console\.log`, // Debug prints
-		`(?i)todo.*hack`, `(?i)fixme.*hack`, // Hack comments
-		`(?i)copy.*paste`, `(?i)duplicate`, // Copy-paste indicators
-		`(?i)lorem.*ipsum`,     // Placeholder text
-		`(?i)test.*test.*test`, // Repetitive test names
-	}
-
-	for _, smell := range smells {
-		if matched, _ := regexp.MatchString(smell, content); matched {
+	for _, smell := range qa.codeSmells {
+		if smell.MatchString(content) {
 			return true
 		}
 	}
@@ -434,14 +953,8 @@ func (qa *QualityAnalyzer) calculateComplexity(content, language string) int {
 	complexity := 0
 
 	// Count control flow statements
-	controlFlow := []string{
-		`if\s*\(`, `else`, `while\s*\(`, `for\s*\(`, `switch\s*\(`,
-		`case\s+`, `catch\s*\(`, `try\s*{`, `finally\s*{`,
-	}
-
-	for _, pattern := range controlFlow {
-		matches := regexp.MustCompile(pattern).FindAllString(content, -1)
-		complexity += len(matches)
+	for _, pattern := range qa.controlFlow {
+		complexity += len(pattern.FindAllStringIndex(content, -1))
 	}
 
 	return complexity
@@ -538,7 +1051,7 @@ func (qa *QualityAnalyzer) storeQualityResults(quality *RepoQuality) error {
 	description := fmt.Sprintf("Quality: %.2f, Coding Patterns: %.2f, Files: %d/%d, Lines: %d",
 		quality.QualityScore, quality.SecurityScore, quality.ValidFiles, quality.TotalFiles, quality.ValidLines)
 
-	rawResult, _ := json.Marshal(quality)
+	rawResult, _ := json.Marshal(quality.stored())
 
 	_, err := qa.db.Exec(query,
 		quality.ID, "quality_analysis", "Repository Coding Quality Analysis",
@@ -571,6 +1084,21 @@ func (qa *QualityAnalyzer) storeQualityResults(quality *RepoQuality) error {
 	return err
 }
 
+// stored returns the analysis as kept in raw_result: the aggregate metrics
+// and the storedTopFiles highest scoring files, so a large repository
+// doesn't make for an enormous row
+func (q *RepoQuality) stored() RepoQuality {
+	s := *q
+	s.CodeFiles = append([]CodeFile(nil), q.CodeFiles...)
+	sort.SliceStable(s.CodeFiles, func(i, j int) bool {
+		return s.CodeFiles[i].QualityScore > s.CodeFiles[j].QualityScore
+	})
+	if len(s.CodeFiles) > storedTopFiles {
+		s.CodeFiles = s.CodeFiles[:storedTopFiles]
+	}
+	return s
+}
+
 func (qa *QualityAnalyzer) GetTopQualityRepos(limit int, minQualityScore float64) ([]RepoQuality, error) {
 	query := `
 		SELECT r.id, r.full_name, r.local_path, 
@@ -617,14 +1145,59 @@ func (qa *QualityAnalyzer) Close() error {
 
 func main() {
 	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run quality_analyzer.go analyze|extract|report [options]")
+		log.Fatal("Usage: go run quality_analyzer.go analyze|extract|report|validate-config [options] [--force] [--profile=strict|balanced|permissive]")
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Printf("Warning: .env file not found: %v", err)
+	}
+
+	// --force re-analyzes repositories whose stored analysis is current;
+	// --profile picks the preset settings
+	var args []string
+	force := false
+	profile := ""
+	for i := 2; i < len(os.Args); i++ {
+		arg := os.Args[i]
+		switch {
+		case arg == "--force" || arg == "-force":
+			force = true
+		case strings.HasPrefix(arg, "--profile="):
+			profile = strings.TrimPrefix(arg, "--profile=")
+		case arg == "--profile" && i+1 < len(os.Args):
+			i++
+			profile = os.Args[i]
+		default:
+			args = append(args, arg)
+		}
+	}
+
+	if os.Args[1] == "validate-config" {
+		path := os.Getenv("QUALITY_ANALYZER_CONFIG")
+		if len(args) > 0 {
+			path = args[0]
+		}
+		if path == "" {
+			log.Fatal("Usage: go run quality_analyzer.go validate-config <config.yaml>")
+		}
+		if err := runValidateConfig(path, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	analyzer, err := NewQualityAnalyzer()
+	settings, err := loadAnalyzerSettings(profile)
+	if err != nil {
+		log.Fatal("Invalid analyzer settings: ", err)
+	}
+	log.Printf("Quality analyzer settings: %s", settings)
+
+	analyzer, err := NewQualityAnalyzer(settings)
 	if err != nil {
 		log.Fatal("Failed to create analyzer:", err)
 	}
 	defer analyzer.Close()
+	analyzer.force = force
 
 	command := os.Args[1]
 
@@ -635,8 +1208,8 @@ func main() {
 		}
 	case "extract":
 		minScore := 0.8
-		if len(os.Args) > 2 {
-			if _, err := fmt.Sscanf(os.Args[2], "%f", &minScore); err != nil {
+		if len(args) > 0 {
+			if _, err := fmt.Sscanf(args[0], "%f", &minScore); err != nil {
 				log.Fatal("Invalid quality score:", err)
 			}
 		}
@@ -648,7 +1221,7 @@ func main() {
 			log.Fatal("Report generation failed:", err)
 		}
 	default:
-		log.Fatal("Invalid command. Use 'analyze', 'extract', or 'report'")
+		log.Fatal("Invalid command. Use 'analyze', 'extract', 'report' or 'validate-config'")
 	}
 }
 
@@ -670,19 +1243,14 @@ func analyzeAllRepos(analyzer *QualityAnalyzer) error {
 			continue
 		}
 
-		// Check if already analyzed
-		var analyzed bool
-		checkQuery := `SELECT EXISTS(SELECT 1 FROM analysis_results 
-					   WHERE repository_id = $1 AND analysis_type = 'quality_analysis')`
-		analyzer.db.QueryRow(checkQuery, id).Scan(&analyzed)
-
-		if analyzed {
-			log.Printf("Skipping already analyzed repo: %s", fullName)
+		// Skip repos whose content hasn't changed since they were analyzed
+		_, cached, err := analyzer.AnalyzeCached(localPath, id, fullName)
+		if err != nil {
+			log.Printf("Failed to analyze %s: %v", fullName, err)
 			continue
 		}
-
-		if _, err := analyzer.AnalyzeRepository(localPath, id, fullName); err != nil {
-			log.Printf("Failed to analyze %s: %v", fullName, err)
+		if cached {
+			log.Printf("Skipping already analyzed repo: %s", fullName)
 			continue
 		}
 
@@ -721,30 +1289,16 @@ func extractHighQualityDataset(analyzer *QualityAnalyzer, minScore float64) erro
 			continue
 		}
 
-		// Re-analyze to get file details
-		quality, err := analyzer.AnalyzeRepository(repo.LocalPath, repo.ID, repo.FullName)
+		// Stored analyses don't keep file contents, so the repo is scored
+		// again and its high-quality files written out as they're found
+		fileCount, lineCount, err := analyzer.extractRepository(repo, repoDir)
 		if err != nil {
-			log.Printf("Failed to re-analyze %s: %v", repo.FullName, err)
+			log.Printf("Failed to analyze %s: %v", repo.FullName, err)
 			continue
 		}
 
-		// Extract only high-quality files
-		fileCount := 0
-		for _, file := range quality.CodeFiles {
-			if !file.IsHighQuality {
-				continue
-			}
-
-			outputPath := filepath.Join(repoDir, strings.ReplaceAll(file.Path, "/", "_"))
-			if err := os.WriteFile(outputPath, []byte(file.Content), 0644); err != nil {
-				continue
-			}
-
-			fileCount++
-			totalLines += file.Lines
-		}
-
 		totalFiles += fileCount
+		totalLines += lineCount
 		log.Printf("Extracted %d high-quality files from %s", fileCount, repo.FullName)
 	}
 
@@ -766,6 +1320,25 @@ func extractHighQualityDataset(analyzer *QualityAnalyzer, minScore float64) erro
 	return nil
 }
 
+// extractRepository writes the high-quality files of a repository to dir
+// without holding their contents, returning how many files and lines were
+// written
+func (qa *QualityAnalyzer) extractRepository(repo RepoQuality, dir string) (files, lines int, err error) {
+	var mu sync.Mutex
+	_, err = qa.analyzeFiles(repo.LocalPath, repo.ID, repo.FullName, "", func(file *CodeFile, content []byte) {
+		outputPath := filepath.Join(dir, strings.ReplaceAll(filepath.ToSlash(file.Path), "/", "_"))
+		if err := os.WriteFile(outputPath, content, 0644); err != nil {
+			return
+		}
+
+		mu.Lock()
+		files++
+		lines += file.Lines
+		mu.Unlock()
+	})
+	return files, lines, err
+}
+
 func generateQualityReport(analyzer *QualityAnalyzer) error {
 	// Get quality statistics
 	query := `
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Run with: go test quality_analyzer.go quality_analyzer_test.go
+
+const testCommit = "3f2a9c1e8b7d6a5f4e3d2c1b0a9f8e7d6c5b4a39"
+
+// newTestAnalyzer returns an analyzer on a mock database that counts how
+// often it walks a repository
+func newTestAnalyzer(t *testing.T) (*QualityAnalyzer, sqlmock.Sqlmock, *int) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	walks := 0
+	qa := newQualityAnalyzer(db)
+	qa.walkDir = func(root string, fn fs.WalkDirFunc) error {
+		walks++
+		return filepath.WalkDir(root, fn)
+	}
+	return qa, mock, &walks
+}
+
+// gitRepo creates a checkout whose HEAD points at testCommit
+func gitRepo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	refs := filepath.Join(dir, ".git", "refs", "heads")
+	if err := os.MkdirAll(refs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644)
+	os.WriteFile(filepath.Join(refs, "main"), []byte(testCommit+"\n"), 0644)
+	return dir
+}
+
+func TestRepoFingerprint_Git(t *testing.T) {
+	dir := gitRepo(t)
+	if got, err := repoFingerprint(dir); err != nil || got != "git:"+testCommit {
+		t.Errorf("repoFingerprint() = %q, %v; want git:%s", got, err, testCommit)
+	}
+
+	// Refs that were packed by git gc
+	os.Remove(filepath.Join(dir, ".git", "refs", "heads", "main"))
+	os.WriteFile(filepath.Join(dir, ".git", "packed-refs"),
+		[]byte("# pack-refs with: peeled fully-peeled sorted\n"+testCommit+" refs/heads/main\n"), 0644)
+	if got, err := repoFingerprint(dir); err != nil || got != "git:"+testCommit {
+		t.Errorf("repoFingerprint() with packed refs = %q, %v", got, err)
+	}
+
+	// Detached HEAD
+	os.WriteFile(filepath.Join(dir, ".git", "HEAD"), []byte(testCommit+"\n"), 0644)
+	if got, err := repoFingerprint(dir); err != nil || got != "git:"+testCommit {
+		t.Errorf("repoFingerprint() detached = %q, %v", got, err)
+	}
+}
+
+func TestRepoFingerprint_TreeChanges(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "main.go")
+	os.WriteFile(file, []byte("package main\n"), 0644)
+
+	first, err := repoFingerprint(dir)
+	if err != nil || !strings.HasPrefix(first, "tree:") {
+		t.Fatalf("repoFingerprint() = %q, %v; want a tree hash", first, err)
+	}
+	if again, _ := repoFingerprint(dir); again != first {
+		t.Errorf("fingerprint changed without changes: %q then %q", first, again)
+	}
+
+	os.WriteFile(file, []byte("package main\n\nfunc main() {}\n"), 0644)
+	if changed, _ := repoFingerprint(dir); changed == first {
+		t.Error("fingerprint unchanged after a file was rewritten")
+	}
+}
+
+// goodSource scores as high quality, plainSource doesn't
+var (
+	goodSource  = strings.Repeat("// Package widget renders widgets\npackage widget\n\ntype Widget struct{ name string }\n\nfunc (w *Widget) Name() string {\n\treturn w.name\n}\n", 8)
+	plainSource = strings.Repeat("x := 1\n", 12)
+)
+
+func TestExtractHighQualityDataset_StreamsHighQualityFiles(t *testing.T) {
+	qa, mock, walks := newTestAnalyzer(t)
+	repoPath := gitRepo(t)
+	os.MkdirAll(filepath.Join(repoPath, "pkg"), 0755)
+	os.WriteFile(filepath.Join(repoPath, "pkg", "widget.go"), []byte(goodSource), 0644)
+	os.WriteFile(filepath.Join(repoPath, "scratch.go"), []byte(plainSource), 0644)
+	t.Chdir(t.TempDir())
+
+	// Stored analyses don't hold contents, so neither is one looked up nor
+	// is the extraction's analysis stored
+	mock.ExpectQuery("FROM repositories r").
+		WithArgs(0.8, 1000).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "full_name", "local_path",
+			"quality_score", "security_score", "valid_files", "total_files"}).
+			AddRow("42", "acme/widgets", repoPath, 0.9, 0.5, 2, 3))
+
+	if err := extractHighQualityDataset(qa, 0.8); err != nil {
+		t.Fatalf("extractHighQualityDataset() error = %v", err)
+	}
+
+	if *walks != 1 {
+		t.Errorf("repository walked %d times, want 1", *walks)
+	}
+	got, err := os.ReadFile(filepath.Join("high_quality_dataset_0.8", "acme_widgets", "pkg_widget.go"))
+	if err != nil || string(got) != goodSource {
+		t.Errorf("extracted file = %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join("high_quality_dataset_0.8", "acme_widgets", "scratch.go")); err == nil {
+		t.Error("low-quality file was extracted")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAnalyzeCached_ChangedRepoIsWalked(t *testing.T) {
+	qa, mock, walks := newTestAnalyzer(t)
+	repoPath := gitRepo(t)
+
+	// Only analyses of an older commit are stored
+	mock.ExpectQuery("SELECT raw_result FROM analysis_results").
+		WithArgs("42", "git:"+testCommit).
+		WillReturnRows(sqlmock.NewRows([]string{"raw_result"}))
+	mock.ExpectExec("INSERT INTO analysis_results").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE repositories").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	quality, cached, err := qa.AnalyzeCached(repoPath, "42", "acme/widgets")
+	if err != nil {
+		t.Fatalf("AnalyzeCached() error = %v", err)
+	}
+	if cached || *walks != 1 {
+		t.Errorf("cached = %v, walks = %d; want a fresh analysis", cached, *walks)
+	}
+	if quality.Fingerprint != "git:"+testCommit {
+		t.Errorf("Fingerprint = %q, want it stored with the analysis", quality.Fingerprint)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAnalyzeCached_Force(t *testing.T) {
+	qa, mock, walks := newTestAnalyzer(t)
+	qa.force = true
+
+	// No lookup of the stored analysis at all
+	mock.ExpectExec("INSERT INTO analysis_results").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE repositories").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	_, cached, err := qa.AnalyzeCached(gitRepo(t), "42", "acme/widgets")
+	if err != nil {
+		t.Fatalf("AnalyzeCached() error = %v", err)
+	}
+	if cached || *walks != 1 {
+		t.Errorf("cached = %v, walks = %d; want --force to re-analyze", cached, *walks)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+// Run with -race: files are analyzed concurrently
+func TestAnalyzeRepository_Concurrent(t *testing.T) {
+	qa, mock, _ := newTestAnalyzer(t)
+	qa.workers = 8
+	qa.maxFilesPerRepo = 75
+
+	repoPath := t.TempDir()
+	for i := 0; i < 100; i++ {
+		content := goodSource
+		if i%2 == 1 {
+			content = plainSource
+		}
+		dir := filepath.Join(repoPath, fmt.Sprintf("pkg%d", i%10))
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%03d.go", i)), []byte(content), 0644)
+	}
+
+	mock.ExpectExec("INSERT INTO analysis_results").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE repositories").WillReturnResult(sqlmock.NewResult(0, 1))
+	quality, err := qa.AnalyzeRepository(repoPath, "42", "acme/widgets")
+	if err != nil {
+		t.Fatalf("AnalyzeRepository() error = %v", err)
+	}
+
+	// The limit keeps the first 75 paths in sorted order, whatever the walk
+	// or worker order
+	if quality.TotalFiles != 75 || quality.ValidFiles != 75 || quality.Languages["go"] != 75 {
+		t.Fatalf("TotalFiles=%d ValidFiles=%d Languages=%v; want 75 Go files",
+			quality.TotalFiles, quality.ValidFiles, quality.Languages)
+	}
+	first, last := quality.CodeFiles[0].Path, quality.CodeFiles[74].Path
+	if first != filepath.Join("pkg0", "file000.go") || last != filepath.Join("pkg7", "file047.go") {
+		t.Errorf("CodeFiles run from %s to %s, want pkg0/file000.go to pkg7/file047.go", first, last)
+	}
+	for i := 1; i < len(quality.CodeFiles); i++ {
+		if quality.CodeFiles[i-1].Path >= quality.CodeFiles[i].Path {
+			t.Fatalf("CodeFiles not sorted at %d: %s, %s", i, quality.CodeFiles[i-1].Path, quality.CodeFiles[i].Path)
+		}
+	}
+
+	highQuality := 0
+	for _, file := range quality.CodeFiles {
+		if file.IsHighQuality {
+			highQuality++
+		}
+		if len(file.Hash) != 32 {
+			t.Errorf("%s: Hash = %q, want an md5", file.Path, file.Hash)
+		}
+	}
+	if highQuality == 0 || highQuality == len(quality.CodeFiles) {
+		t.Errorf("%d of %d files high quality, want a mix", highQuality, len(quality.CodeFiles))
+	}
+
+	// A second run scores identically
+	mock.ExpectExec("INSERT INTO analysis_results").WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("UPDATE repositories").WillReturnResult(sqlmock.NewResult(0, 1))
+	again, err := qa.AnalyzeRepository(repoPath, "42", "acme/widgets")
+	if err != nil || again.QualityScore != quality.QualityScore {
+		t.Errorf("QualityScore = %v then %v (%v), want a deterministic score", quality.QualityScore, again.QualityScore, err)
+	}
+}
+
+// rawResultSize captures the size of the raw_result written to
+// analysis_results
+type rawResultSize struct{ n *int }
+
+func (r rawResultSize) Match(v driver.Value) bool {
+	raw, ok := v.([]byte)
+	*r.n = len(raw)
+	return ok
+}
+
+// A large repository must not be held in memory, nor stored, whole
+func TestAnalyzeRepository_MemoryBound(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 5,000 files")
+	}
+	qa, mock, _ := newTestAnalyzer(t)
+	qa.workers = 4
+	qa.maxFilesPerRepo = 5000
+
+	// 5,000 high-quality files of ~1KB each. Holding their contents
+	// would take more than the budget on its own.
+	repoPath := t.TempDir()
+	content := []byte(goodSource)
+	for i := 0; i < 5000; i++ {
+		dir := filepath.Join(repoPath, fmt.Sprintf("pkg%02d", i%50))
+		os.MkdirAll(dir, 0755)
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file%04d.go", i)), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var rawSize int
+	mock.ExpectExec("INSERT INTO analysis_results").
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(),
+			rawResultSize{&rawSize}, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE repositories").WillReturnResult(sqlmock.NewResult(0, 1))
+
+	const heapBudget = 4 << 20
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	quality, err := qa.AnalyzeRepository(repoPath, "42", "acme/monorepo")
+	if err != nil {
+		t.Fatalf("AnalyzeRepository() error = %v", err)
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	if quality.ValidFiles != 5000 {
+		t.Fatalf("ValidFiles = %d, want 5000", quality.ValidFiles)
+	}
+	if retained := int64(after.HeapAlloc) - int64(before.HeapAlloc); retained > heapBudget {
+		t.Errorf("analysis retains %d bytes of heap, budget is %d", retained, heapBudget)
+	}
+	runtime.KeepAlive(quality)
+
+	if rawSize == 0 || rawSize > 64<<10 {
+		t.Errorf("raw_result is %d bytes, want the aggregates and top %d files only", rawSize, storedTopFiles)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRepoQualityStored_KeepsTopFiles(t *testing.T) {
+	quality := &RepoQuality{ValidFiles: 120}
+	for i := 0; i < 120; i++ {
+		quality.CodeFiles = append(quality.CodeFiles, CodeFile{
+			Path:         fmt.Sprintf("file%03d.go", i),
+			QualityScore: float64(i%60) / 60,
+		})
+	}
+
+	stored := quality.stored()
+	if len(stored.CodeFiles) != storedTopFiles {
+		t.Fatalf("stored %d files, want %d", len(stored.CodeFiles), storedTopFiles)
+	}
+	for i := 1; i < len(stored.CodeFiles); i++ {
+		if stored.CodeFiles[i-1].QualityScore < stored.CodeFiles[i].QualityScore {
+			t.Fatalf("stored files not best first at %d", i)
+		}
+	}
+	if stored.CodeFiles[0].Path != "file059.go" || stored.ValidFiles != 120 {
+		t.Errorf("stored starts with %s and %d valid files", stored.CodeFiles[0].Path, stored.ValidFiles)
+	}
+	if len(quality.CodeFiles) != 120 || quality.CodeFiles[0].Path != "file000.go" {
+		t.Error("stored() changed the analysis it was called on")
+	}
+}
+
+// fileQualityUncompiled and complexityUncompiled are the scoring functions as
+// they were before patterns were precompiled, kept as the golden reference
+// and benchmark baseline
+func fileQualityUncompiled(qa *QualityAnalyzer, file *CodeFile, content string) float64 {
+	score := 0.0
+	if weight, ok := qa.languageWeights[file.Language]; ok {
+		score += weight * 0.3
+	}
+	codingBonus := float64(len(file.SecurityPatterns)) * 0.15
+	if codingBonus > 0.5 {
+		codingBonus = 0.5
+	}
+	score += codingBonus
+	if file.Lines >= 50 && file.Lines <= 500 {
+		score += 0.2
+	} else if file.Lines >= 20 && file.Lines <= 1000 {
+		score += 0.1
+	}
+
+	qualityCount := 0
+	for _, indicator := range qualityIndicators {
+		if matched, _ := regexp.MatchString(indicator, content); matched {
+			qualityCount++
+		}
+	}
+	score += float64(qualityCount) * 0.05
+
+	for _, smell := range codeSmellPatterns {
+		if matched, _ := regexp.MatchString(smell, content); matched {
+			score -= 0.3
+			break
+		}
+	}
+
+	if score > 1.0 {
+		score = 1.0
+	}
+	if score < 0.0 {
+		score = 0.0
+	}
+	return score
+}
+
+func complexityUncompiled(content string) int {
+	complexity := 0
+	for _, pattern := range controlFlowPatterns {
+		complexity += len(regexp.MustCompile(pattern).FindAllString(content, -1))
+	}
+	return complexity
+}
+
+// qualityFixture generates n source files of varied languages, sizes and
+// smells, and their contents
+func qualityFixture(n int) ([]CodeFile, []string) {
+	snippets := []struct{ language, body string }{
+		{"go", "package widget\n\n// Widget renders\ntype Widget struct{ name string }\n\nfunc (w *Widget) Render() string {\n\tif w.name == \"\" {\n\t\treturn \"none\"\n\t}\n\tfor i := 0; i < 3; i++ {\n\t\tdefer cleanup()\n\t}\n\treturn w.name\n}\n"},
+		{"python", "class Service:\n    def __init__(self):\n        self.items = []\n\n    async def fetch(self):\n        if (self.items):\n            print(\"debug\", self.items)\n        return [i for i in self.items]\n\ndef test_fetch():\n    assert Service().items == []\n"},
+		{"typescript", "@Component({ selector: 'app' })\nexport class AppComponent {\n  items: string[] = [];\n  load(): Promise<void> {\n    try {\n      console.log('loading');\n    } finally {\n      this.items = [];\n    }\n    return Promise.resolve();\n  }\n}\n"},
+		{"rust", "pub struct Stack<T> { items: Vec<T> }\n\nimpl<T> Stack<T> {\n    pub fn push(&mut self, t: T) { self.items.push(t) }\n}\n\ntrait Shape { fn area(&self) -> f64; }\n"},
+		{"csharp", "namespace Shop {\n  public abstract class OrderService {\n    public int Total(int[] xs) {\n      switch (xs.Length) { case 0: return 0; default: break; }\n      while (true) { break; }\n      return 1;\n    }\n  }\n}\n"},
+		{"sql", "CREATE TABLE users (id INT);\nSELECT * FROM users;\nINSERT INTO users VALUES (1);\n-- TODO: hack around the duplicate index\n"},
+	}
+
+	files := make([]CodeFile, n)
+	contents := make([]string, n)
+	for i := range files {
+		s := snippets[i%len(snippets)]
+		content := strings.Repeat(s.body, 1+i%7) + fmt.Sprintf("// file %d\n", i)
+		files[i] = CodeFile{
+			Path:     fmt.Sprintf("src/file%d", i),
+			Language: s.language,
+			Lines:    strings.Count(content, "\n") + 1,
+		}
+		contents[i] = content
+		if i%3 == 0 {
+			files[i].SecurityPatterns = []string{"go", "python"}
+		}
+	}
+	return files, contents
+}
+
+func TestProfileSettings(t *testing.T) {
+	tests := []struct {
+		profile  string
+		minScore float64
+		maxFiles int
+		excluded map[string]bool // path -> whether the profile skips it
+	}{
+		{ProfileBalanced, 0.7, 1000, map[string]bool{
+			"main.go": false, "README.md": true, "config.yaml": true, "vendor/x/y.go": true, "migrations/001.sql": false,
+		}},
+		{ProfileStrict, 0.8, 500, map[string]bool{
+			"main.go": false, "README.md": true, "config.yaml": true, "migrations/001.sql": true, "types/index.d.ts": true,
+		}},
+		{ProfilePermissive, 0.5, 5000, map[string]bool{
+			"main.go": false, "README.md": false, "config.yaml": false, "vendor/x/y.go": true, "app.min.js": true,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.profile, func(t *testing.T) {
+			settings, err := profileSettings(tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			qa := newQualityAnalyzer(nil)
+			if err := qa.configure(settings); err != nil {
+				t.Fatal(err)
+			}
+			if qa.profile != tt.profile || qa.minQualityScore != tt.minScore || qa.maxFilesPerRepo != tt.maxFiles {
+				t.Errorf("settings = %s, want min %v and max %d", settings, tt.minScore, tt.maxFiles)
+			}
+			for path, want := range tt.excluded {
+				if got := qa.shouldExcludeFile(path); got != want {
+					t.Errorf("shouldExcludeFile(%q) = %v, want %v", path, got, want)
+				}
+			}
+		})
+	}
+
+	permissive, _ := profileSettings(ProfilePermissive)
+	if permissive.LanguageWeights["yaml"] <= languageWeights["yaml"] || languageWeights["yaml"] != 0.45 {
+		t.Errorf("permissive yaml weight = %v, want it raised without touching the default", permissive.LanguageWeights["yaml"])
+	}
+	if _, err := profileSettings("lenient"); err == nil {
+		t.Error("profileSettings(lenient) should fail")
+	}
+}
+
+func TestParseAnalyzerConfig_Problems(t *testing.T) {
+	config := `profile: strict
+min_quality_score: 1.5
+exclude_patterns:
+  - '(?i)generated/'
+  - '(?i)(unclosed'
+  - '[z-a]'
+language_weights:
+  go: 1.0
+  php: -0.2
+`
+	cfg, problems, err := parseAnalyzerConfig([]byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != ProfileStrict || len(cfg.ExcludePatterns) != 3 {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	want := []struct {
+		line  int
+		field string
+	}{{2, "min_quality_score"}, {5, "exclude_patterns[1]"}, {6, "exclude_patterns[2]"}, {9, "language_weights.php"}}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %v, want %d", problems, len(want))
+	}
+	for i, w := range want {
+		if problems[i].Line != w.line || problems[i].Field != w.field {
+			t.Errorf("problem %d = %s, want line %d %s", i, problems[i], w.line, w.field)
+		}
+	}
+
+	if _, _, err := parseAnalyzerConfig([]byte("min_score: 0.5\n")); err == nil {
+		t.Error("unknown field should fail")
+	}
+}
+
+func TestRunValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.yaml")
+	good := filepath.Join(dir, "good.yaml")
+	os.WriteFile(bad, []byte("profile: balanced\nexclude_patterns:\n  - '*.go'\n"), 0644)
+	os.WriteFile(good, []byte("profile: permissive\nmax_files_per_repo: 200\n"), 0644)
+
+	var out strings.Builder
+	if err := runValidateConfig(bad, &out); err == nil {
+		t.Error("runValidateConfig should fail for an invalid regex")
+	}
+	if !strings.HasPrefix(out.String(), bad+":3: exclude_patterns[0]: ") {
+		t.Errorf("output = %q, want the file and line of the bad pattern", out.String())
+	}
+
+	out.Reset()
+	if err := runValidateConfig(good, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "profile=permissive") || !strings.Contains(out.String(), "max_files_per_repo=200") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestLoadAnalyzerSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quality.yaml")
+	os.WriteFile(path, []byte("profile: strict\nmax_files_per_repo: 300\nlanguage_weights:\n  go: 1.0\n"), 0644)
+	t.Setenv("QUALITY_ANALYZER_CONFIG", path)
+	t.Setenv("QUALITY_PROFILE", "")
+	t.Setenv("QUALITY_MIN_SCORE", "0.9")
+	t.Setenv("QUALITY_MAX_FILES_PER_REPO", "")
+	t.Setenv("QUALITY_EXCLUDE_PATTERNS", `(?i)test/, (?i)\.pb\.go$`)
+	t.Setenv("QUALITY_LANGUAGE_WEIGHTS", "python=0.8")
+
+	settings, err := loadAnalyzerSettings("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if settings.Profile != ProfileStrict || settings.MaxFilesPerRepo != 300 || settings.MinQualityScore != 0.9 {
+		t.Errorf("settings = %s, want strict from the file with 300 files and min 0.9", settings)
+	}
+	if len(settings.ExcludePatterns) != 2 || settings.ExcludePatterns[1] != `(?i)\.pb\.go$` {
+		t.Errorf("exclude patterns = %q", settings.ExcludePatterns)
+	}
+	if settings.LanguageWeights["go"] != 1.0 || settings.LanguageWeights["python"] != 0.8 || settings.LanguageWeights["rust"] != 0.9 {
+		t.Errorf("language weights = %v", settings.LanguageWeights)
+	}
+
+	// The flag beats QUALITY_PROFILE, which beats the file
+	t.Setenv("QUALITY_PROFILE", ProfilePermissive)
+	if settings, _ := loadAnalyzerSettings(""); settings.Profile != ProfilePermissive {
+		t.Errorf("profile = %s, want permissive from QUALITY_PROFILE", settings.Profile)
+	}
+	if settings, _ := loadAnalyzerSettings(ProfileBalanced); settings.Profile != ProfileBalanced {
+		t.Errorf("profile = %s, want balanced from the flag", settings.Profile)
+	}
+
+	t.Setenv("QUALITY_MIN_SCORE", "high")
+	if _, err := loadAnalyzerSettings(""); err == nil {
+		t.Error("loadAnalyzerSettings should reject QUALITY_MIN_SCORE=high")
+	}
+}
+
+func TestCalculateFileQuality_MatchesUncompiled(t *testing.T) {
+	qa := newQualityAnalyzer(nil)
+	files, contents := qualityFixture(300)
+	for i, file := range files {
+		content := contents[i]
+		if got, want := qa.calculateFileQuality(&file, content), fileQualityUncompiled(qa, &file, content); got != want {
+			t.Errorf("%s (%s): quality = %v, uncompiled = %v", file.Path, file.Language, got, want)
+		}
+		if got, want := qa.calculateComplexity(content, file.Language), complexityUncompiled(content); got != want {
+			t.Errorf("%s (%s): complexity = %d, uncompiled = %d", file.Path, file.Language, got, want)
+		}
+	}
+}
+
+func BenchmarkCalculateFileQuality(b *testing.B) {
+	qa := newQualityAnalyzer(nil)
+	files, contents := qualityFixture(1000)
+
+	b.Run("uncompiled", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := range files {
+				fileQualityUncompiled(qa, &files[i], contents[i])
+				complexityUncompiled(contents[i])
+			}
+		}
+	})
+	b.Run("compiled", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for i := range files {
+				qa.calculateFileQuality(&files[i], contents[i])
+				qa.calculateComplexity(contents[i], files[i].Language)
+			}
+		}
+	})
+}
@@ -3,8 +3,6 @@ package main
 import (
 	"bufio"
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
@@ -16,7 +14,10 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
-	"unicode/utf8"
+
+	"codelupe/pkg/config"
+	"codelupe/pkg/deduplication"
+	"codelupe/pkg/langdetect"
 )
 
 func getEnv(key, defaultValue string) string {
@@ -34,6 +35,10 @@ type FileResult struct {
 	Size     int64  `json:"size"`
 	Hash     string `json:"hash"`
 	Path     string `json:"path"`
+
+	// NormalizedHash ignores comments and whitespace, so reformatted
+	// copies of a file share it
+	NormalizedHash string `json:"normalized_hash"`
 }
 
 // TrainingData represents the final training format
@@ -53,6 +58,9 @@ type ProcessorStats struct {
 	BytesProcessed int64
 	ReposProcessed int64
 	ErrorCount     int64
+	FilesWritten   int64
+	ExactDupes     int64
+	NearDupes      int64
 	StartTime      time.Time
 	LanguageCount  map[string]int64
 	mu             sync.RWMutex
@@ -60,17 +68,16 @@ type ProcessorStats struct {
 
 // UltraFastProcessor optimized for Ryzen 9 3900X
 type UltraFastProcessor struct {
-	reposDir       string
-	workerCount    int
-	stats          *ProcessorStats
-	codeExtensions map[string]string
-	skipDirs       map[string]bool
-	maxFileSize    int64
-	minFileSize    int64
+	reposDir      string
+	workerCount   int
+	stats         *ProcessorStats
+	skipDirs      map[string]bool
+	files         config.Files
+	normalizeOpts deduplication.NormalizeOptions
 }
 
 // NewUltraFastProcessor creates optimized processor
-func NewUltraFastProcessor(reposDir string) *UltraFastProcessor {
+func NewUltraFastProcessor(reposDir string, files config.Files) *UltraFastProcessor {
 	// Optimize for Ryzen 9 3900X (24 threads)
 	workerCount := runtime.GOMAXPROCS(0) * 4 // 96 workers for maximum throughput
 	if workerCount > 128 {
@@ -87,35 +94,6 @@ func NewUltraFastProcessor(reposDir string) *UltraFastProcessor {
 			StartTime:     time.Now(),
 			LanguageCount: make(map[string]int64),
 		},
-		codeExtensions: map[string]string{
-			".py":    "Python",
-			".js":    "JavaScript",
-			".ts":    "TypeScript",
-			".jsx":   "JavaScript",
-			".tsx":   "TypeScript",
-			".java":  "Java",
-			".cpp":   "C++",
-			".c":     "C",
-			".h":     "C/C++",
-			".cs":    "C#",
-			".php":   "PHP",
-			".rb":    "Ruby",
-			".go":    "Go",
-			".rs":    "Rust",
-			".swift": "Swift",
-			".kt":    "Kotlin",
-			".scala": "Scala",
-			".sh":    "Shell",
-			".sql":   "SQL",
-			".r":     "R",
-			".m":     "Objective-C",
-			".pl":    "Perl",
-			".lua":   "Lua",
-			".dart":  "Dart",
-			".vim":   "Vim",
-			".sol":   "Solidity",
-			".asm":   "Assembly",
-		},
 		skipDirs: map[string]bool{
 			".git":          true,
 			".svn":          true,
@@ -135,8 +113,10 @@ func NewUltraFastProcessor(reposDir string) *UltraFastProcessor {
 			"Pods":          true,
 			".pub-cache":    true,
 		},
-		maxFileSize: 1024 * 1024, // 1MB max
-		minFileSize: 100,         // 100 bytes min
+		files: files,
+		normalizeOpts: deduplication.NormalizeOptions{
+			LowercaseIdentifiers: getEnv("DEDUP_LOWERCASE", "false") == "true",
+		},
 	}
 }
 
@@ -216,8 +196,7 @@ func (p *UltraFastProcessor) isValidRepository(repoPath string) bool {
 			return nil
 		}
 
-		ext := strings.ToLower(filepath.Ext(d.Name()))
-		if _, exists := p.codeExtensions[ext]; exists {
+		if langdetect.FromName(d.Name()) != "" {
 			codeFileCount++
 			if codeFileCount >= 3 {
 				return filepath.SkipAll // Found enough code files
@@ -232,22 +211,18 @@ func (p *UltraFastProcessor) isValidRepository(repoPath string) bool {
 
 // processFile processes a single file with ultra-fast optimization
 func (p *UltraFastProcessor) processFile(filePath string) (*FileResult, error) {
-	// Fast extension check
-	ext := strings.ToLower(filepath.Ext(filePath))
-	language, exists := p.codeExtensions[ext]
-	if !exists {
-		return nil, fmt.Errorf("unsupported extension")
+	// Fast name and size checks before reading; extensionless files may
+	// still be scripts
+	if err := p.files.CheckName(filePath); err != nil {
+		return nil, err
 	}
-
-	// Fast file size check
 	info, err := os.Stat(filePath)
 	if err != nil {
 		return nil, err
 	}
-
 	size := info.Size()
-	if size < p.minFileSize || size > p.maxFileSize {
-		return nil, fmt.Errorf("file size out of range: %d", size)
+	if err := p.files.CheckSize(size); err != nil {
+		return nil, err
 	}
 
 	// Ultra-fast file reading
@@ -256,25 +231,15 @@ func (p *UltraFastProcessor) processFile(filePath string) (*FileResult, error) {
 		return nil, err
 	}
 
-	// Fast UTF-8 validation
-	if !utf8.Valid(content) {
-		return nil, fmt.Errorf("invalid UTF-8")
+	language, err := p.files.Check(filePath, content)
+	if err != nil {
+		return nil, err
 	}
-
 	text := string(content)
-	if len(strings.TrimSpace(text)) == 0 {
-		return nil, fmt.Errorf("empty file")
-	}
-
-	// Fast line counting
 	lines := strings.Count(text, "\n") + 1
-	if lines < 5 || lines > 2000 {
-		return nil, fmt.Errorf("line count out of range: %d", lines)
-	}
 
-	// Ultra-fast hash calculation
-	hash := md5.Sum(content)
-	hashStr := hex.EncodeToString(hash[:])
+	// Hash raw and normalized content for deduplication
+	hash, normalized := deduplication.ContentHashes(content, language, p.normalizeOpts)
 
 	// Update statistics atomically
 	atomic.AddInt64(&p.stats.FilesProcessed, 1)
@@ -295,15 +260,17 @@ func (p *UltraFastProcessor) processFile(filePath string) (*FileResult, error) {
 		Language: language,
 		Lines:    lines,
 		Size:     size,
-		Hash:     hashStr,
+		Hash:     hash,
 		Path:     filepath.ToSlash(relPath), // Use forward slashes for JSON
+
+		NormalizedHash: normalized,
 	}, nil
 }
 
-// processRepository processes all files in a repository
-func (p *UltraFastProcessor) processRepository(ctx context.Context, repoPath string) ([]*FileResult, error) {
-	var results []*FileResult
-	var mu sync.Mutex
+// processRepository processes all files in a repository, sending each
+// result to out. It returns how many files were sent.
+func (p *UltraFastProcessor) processRepository(ctx context.Context, repoPath string, out chan<- *FileResult) (int64, error) {
+	var sent int64
 	var wg sync.WaitGroup
 
 	// Channel for file paths
@@ -316,9 +283,11 @@ func (p *UltraFastProcessor) processRepository(ctx context.Context, repoPath str
 			defer wg.Done()
 			for filePath := range fileChan {
 				if result, err := p.processFile(filePath); err == nil {
-					mu.Lock()
-					results = append(results, result)
-					mu.Unlock()
+					select {
+					case out <- result:
+						atomic.AddInt64(&sent, 1)
+					case <-ctx.Done():
+					}
 				} else {
 					atomic.AddInt64(&p.stats.ErrorCount, 1)
 				}
@@ -347,8 +316,7 @@ func (p *UltraFastProcessor) processRepository(ctx context.Context, repoPath str
 				return nil
 			}
 
-			ext := strings.ToLower(filepath.Ext(d.Name()))
-			if _, exists := p.codeExtensions[ext]; exists {
+			if langdetect.Candidate(d.Name()) {
 				select {
 				case fileChan <- path:
 				case <-ctx.Done():
@@ -363,25 +331,37 @@ func (p *UltraFastProcessor) processRepository(ctx context.Context, repoPath str
 	wg.Wait()
 	atomic.AddInt64(&p.stats.ReposProcessed, 1)
 
-	return results, nil
+	return sent, nil
 }
 
-// processAllRepositories processes all repositories with maximum parallelism
-func (p *UltraFastProcessor) processAllRepositories(ctx context.Context) ([]*FileResult, error) {
+// processAllRepositories processes all repositories with maximum
+// parallelism, streaming results to a single writer that appends them to
+// outputFile. Only the dedup hash set is held in memory.
+func (p *UltraFastProcessor) processAllRepositories(ctx context.Context, outputFile string) error {
 	repos, err := p.scanRepositories(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	if len(repos) == 0 {
-		return nil, fmt.Errorf("no repositories found")
+		return fmt.Errorf("no repositories found")
+	}
+
+	writer, err := newDatasetWriter(outputFile, p.stats)
+	if err != nil {
+		return fmt.Errorf("failed to create dataset: %w", err)
 	}
 
 	fmt.Printf("🔥 Processing %d repositories with MAXIMUM PARALLELISM\n", len(repos))
+	fmt.Printf("💾 Streaming dataset to %s\n", outputFile)
 	start := time.Now()
 
-	var allResults []*FileResult
-	var mu sync.Mutex
+	results := make(chan *FileResult, p.workerCount)
+	writeDone := make(chan error, 1)
+	go func() {
+		writeDone <- writer.run(results)
+	}()
+
 	var wg sync.WaitGroup
 
 	// Channel for repositories
@@ -398,13 +378,9 @@ func (p *UltraFastProcessor) processAllRepositories(ctx context.Context) ([]*Fil
 		go func() {
 			defer wg.Done()
 			for repoPath := range repoChan {
-				results, err := p.processRepository(ctx, repoPath)
-				if err == nil && len(results) > 0 {
-					mu.Lock()
-					allResults = append(allResults, results...)
-					mu.Unlock()
-
-					fmt.Printf("✅ Processed %s: %d files\n", filepath.Base(repoPath), len(results))
+				sent, err := p.processRepository(ctx, repoPath, results)
+				if err == nil && sent > 0 {
+					fmt.Printf("✅ Processed %s: %d files\n", filepath.Base(repoPath), sent)
 				}
 
 				// Progress update
@@ -429,15 +405,26 @@ func (p *UltraFastProcessor) processAllRepositories(ctx context.Context) ([]*Fil
 	}()
 
 	wg.Wait()
+	close(results)
+
+	writeErr := <-writeDone
+	if err := writer.Close(); err != nil && writeErr == nil {
+		writeErr = err
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to write dataset: %w", writeErr)
+	}
 
 	processingTime := time.Since(start)
+	filesFound := atomic.LoadInt64(&p.stats.FilesProcessed)
 	fmt.Printf("\n🎉 ULTRA-FAST PROCESSING COMPLETE!\n")
 	fmt.Printf("⚡ Processing time: %.2fs\n", processingTime.Seconds())
 	fmt.Printf("📊 Repositories: %d\n", len(repos))
-	fmt.Printf("📄 Files found: %d\n", len(allResults))
-	fmt.Printf("🚀 Rate: %.0f files/sec\n", float64(len(allResults))/processingTime.Seconds())
+	fmt.Printf("📄 Files found: %d\n", filesFound)
+	fmt.Printf("🚀 Rate: %.0f files/sec\n", float64(filesFound)/processingTime.Seconds())
+	writer.printSummary()
 
-	return allResults, nil
+	return nil
 }
 
 // printProgress prints current processing statistics
@@ -452,70 +439,105 @@ func (p *UltraFastProcessor) printProgress(completed, total int) {
 		filesProcessed, float64(bytesProcessed)/(1024*1024), rate)
 }
 
-// saveDataset saves results as JSON training dataset
-func (p *UltraFastProcessor) saveDataset(results []*FileResult, outputFile string) error {
-	fmt.Printf("💾 Saving %d files to %s...\n", len(results), outputFile)
-	start := time.Now()
+// datasetWriter appends deduplicated results to a JSONL file as they
+// arrive. It is driven by a single goroutine (run), so its dedup state needs
+// no locking; counts go to the shared stats atomically.
+type datasetWriter struct {
+	file   *os.File
+	writer *bufio.Writer
+	stats  *ProcessorStats
+
+	seen      map[string]string // normalized hash -> raw hash
+	nearDupes map[string]int64  // per language
+}
 
+// newDatasetWriter creates outputFile, truncating any existing file
+func newDatasetWriter(outputFile string, stats *ProcessorStats) (*datasetWriter, error) {
 	file, err := os.Create(outputFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-
-	// Write JSON array start
-	writer.WriteString("[\n")
-
-	// Deduplicate by hash
-	seen := make(map[string]bool)
-	written := 0
-
-	for i, result := range results {
-		if seen[result.Hash] {
-			continue
-		}
-		seen[result.Hash] = true
+	return &datasetWriter{
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		stats:     stats,
+		seen:      make(map[string]string),
+		nearDupes: make(map[string]int64),
+	}, nil
+}
 
-		// Convert to training format
-		trainingData := TrainingData{
-			Text: result.Content,
-		}
-		trainingData.Meta.Language = result.Language
-		trainingData.Meta.Lines = result.Lines
-		trainingData.Meta.Path = result.Path
-		trainingData.Meta.Size = result.Size
-
-		// Write JSON
-		data, err := json.Marshal(trainingData)
-		if err != nil {
+// run writes results until the channel is closed. After a write error it
+// keeps draining so producers never block, and returns the first error.
+func (w *datasetWriter) run(results <-chan *FileResult) error {
+	var firstErr error
+	for result := range results {
+		if firstErr != nil {
 			continue
 		}
-
-		writer.Write(data)
-		if i < len(results)-1 {
-			writer.WriteString(",")
+		if err := w.write(result); err != nil {
+			firstErr = err
 		}
-		writer.WriteString("\n")
-		written++
+	}
+	return firstErr
+}
 
-		// Progress for large datasets
-		if written%10000 == 0 {
-			fmt.Printf("💾 Saved %d/%d files\n", written, len(results))
+// write appends result as one JSON line unless a copy was already written
+func (w *datasetWriter) write(result *FileResult) error {
+	// Deduplicate by normalized hash, keeping the first copy
+	if kept, ok := w.seen[result.NormalizedHash]; ok {
+		if kept == result.Hash {
+			atomic.AddInt64(&w.stats.ExactDupes, 1)
+		} else {
+			atomic.AddInt64(&w.stats.NearDupes, 1)
+			w.nearDupes[result.Language]++
 		}
+		return nil
 	}
+	w.seen[result.NormalizedHash] = result.Hash
 
-	writer.WriteString("]\n")
+	// Convert to training format
+	trainingData := TrainingData{
+		Text: result.Content,
+	}
+	trainingData.Meta.Language = result.Language
+	trainingData.Meta.Lines = result.Lines
+	trainingData.Meta.Path = result.Path
+	trainingData.Meta.Size = result.Size
 
-	saveTime := time.Since(start)
-	fmt.Printf("✅ Saved %d unique files in %.2fs (%.0f files/sec)\n",
-		written, saveTime.Seconds(), float64(written)/saveTime.Seconds())
+	data, err := json.Marshal(trainingData)
+	if err != nil {
+		return err
+	}
+	if _, err := w.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
 
+	// Progress for large datasets
+	if written := atomic.AddInt64(&w.stats.FilesWritten, 1); written%10000 == 0 {
+		fmt.Printf("💾 Saved %d files\n", written)
+	}
 	return nil
 }
 
+// Close flushes buffered lines and closes the file
+func (w *datasetWriter) Close() error {
+	if err := w.writer.Flush(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// printSummary reports what was written and what deduplication dropped
+func (w *datasetWriter) printSummary() {
+	fmt.Printf("✅ Saved %d unique files\n", atomic.LoadInt64(&w.stats.FilesWritten))
+	fmt.Printf("🧬 Dropped %d exact and %d near-duplicates\n",
+		atomic.LoadInt64(&w.stats.ExactDupes), atomic.LoadInt64(&w.stats.NearDupes))
+	for lang, count := range w.nearDupes {
+		fmt.Printf("   %s: %d near-duplicates\n", lang, count)
+	}
+}
+
 // printFinalStats prints comprehensive statistics
 func (p *UltraFastProcessor) printFinalStats() {
 	elapsed := time.Since(p.stats.StartTime)
@@ -561,29 +583,28 @@ func main() {
 		log.Fatalf("❌ Directory %s does not exist!", reposDir)
 	}
 
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
 	// Create processor
-	processor := NewUltraFastProcessor(reposDir)
+	processor := NewUltraFastProcessor(reposDir, cfg.Files)
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
 	defer cancel()
 
-	// Process all repositories
-	results, err := processor.processAllRepositories(ctx)
-	if err != nil {
+	// Process all repositories, streaming the dataset to disk
+	outputFile := fmt.Sprintf("%s/ultra_fast_go_dataset_%d.jsonl", filepath.Dir(reposDir), time.Now().Unix())
+	if err := processor.processAllRepositories(ctx, outputFile); err != nil {
 		log.Fatalf("❌ Processing failed: %v", err)
 	}
 
-	if len(results) == 0 {
+	if atomic.LoadInt64(&processor.stats.FilesWritten) == 0 {
 		log.Fatal("❌ No files processed!")
 	}
 
-	// Save dataset
-	outputFile := fmt.Sprintf("%s/ultra_fast_go_dataset_%d.json", filepath.Dir(reposDir), time.Now().Unix())
-	if err := processor.saveDataset(results, outputFile); err != nil {
-		log.Fatalf("❌ Failed to save dataset: %v", err)
-	}
-
 	// Print final statistics
 	processor.printFinalStats()
 
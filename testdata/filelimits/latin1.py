@@ -0,0 +1,4 @@
+# Caf menu, saved as Latin-1
+prices = {"caf": 2.5, "crme brle": 6.0}
+for item, price in prices.items():
+    print(item, price)
@@ -0,0 +1,8 @@
+package main
+
+import "fmt"
+
+// main prints a greeting for the file limit fixtures
+func main() {
+	fmt.Println("hello, fixtures")
+}
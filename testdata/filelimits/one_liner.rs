@@ -0,0 +1 @@
+fn main() { let v: Vec<u32> = (0..1000).filter(|n| n % 3 == 0 && n % 5 == 0).collect(); println!("{:?}", v); }
@@ -0,0 +1,43 @@
+CREATE TABLE widgets (
+    col_0 INTEGER,
+    col_1 INTEGER,
+    col_2 INTEGER,
+    col_3 INTEGER,
+    col_4 INTEGER,
+    col_5 INTEGER,
+    col_6 INTEGER,
+    col_7 INTEGER,
+    col_8 INTEGER,
+    col_9 INTEGER,
+    col_10 INTEGER,
+    col_11 INTEGER,
+    col_12 INTEGER,
+    col_13 INTEGER,
+    col_14 INTEGER,
+    col_15 INTEGER,
+    col_16 INTEGER,
+    col_17 INTEGER,
+    col_18 INTEGER,
+    col_19 INTEGER,
+    col_20 INTEGER,
+    col_21 INTEGER,
+    col_22 INTEGER,
+    col_23 INTEGER,
+    col_24 INTEGER,
+    col_25 INTEGER,
+    col_26 INTEGER,
+    col_27 INTEGER,
+    col_28 INTEGER,
+    col_29 INTEGER,
+    col_30 INTEGER,
+    col_31 INTEGER,
+    col_32 INTEGER,
+    col_33 INTEGER,
+    col_34 INTEGER,
+    col_35 INTEGER,
+    col_36 INTEGER,
+    col_37 INTEGER,
+    col_38 INTEGER,
+    col_39 INTEGER,
+    id SERIAL PRIMARY KEY
+);
@@ -0,0 +1,13 @@
+package fastjson
+
+import "strconv"
+
+// AppendString appends s to dst as a quoted JSON string
+func AppendString(dst []byte, s string) []byte {
+	return strconv.AppendQuote(dst, s)
+}
+
+// AppendInt appends n to dst as a JSON number
+func AppendInt(dst []byte, n int64) []byte {
+	return strconv.AppendInt(dst, n, 10)
+}
@@ -0,0 +1,18 @@
+package fastjson
+
+// tokenKind is the kind of a lexical token
+type tokenKind int
+
+const (
+	tokenString tokenKind = iota
+	tokenNumber
+	tokenDelim
+)
+
+// skipSpace returns the index of the first non-space byte at or after i
+func skipSpace(data []byte, i int) int {
+	for i < len(data) && (data[i] == ' ' || data[i] == '\n' || data[i] == '\t' || data[i] == '\r') {
+		i++
+	}
+	return i
+}
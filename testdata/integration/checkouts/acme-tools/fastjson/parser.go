@@ -0,0 +1,27 @@
+package fastjson
+
+import "errors"
+
+// ErrSyntax is returned for malformed input
+var ErrSyntax = errors.New("fastjson: syntax error")
+
+// Valid reports whether data holds a single JSON value
+func Valid(data []byte) bool {
+	i := skipSpace(data, 0)
+	if i == len(data) {
+		return false
+	}
+	depth := 0
+	for ; i < len(data); i++ {
+		switch data[i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
@@ -0,0 +1,13 @@
+"""A pipeline runs records through a sequence of stages."""
+
+from stages import Stage
+
+
+class Pipeline:
+    def __init__(self, *stages: Stage):
+        self.stages = list(stages)
+
+    def run(self, records):
+        for stage in self.stages:
+            records = stage.apply(records)
+        return list(records)
@@ -0,0 +1,22 @@
+"""Building blocks for pipelines."""
+
+
+class Stage:
+    def apply(self, records):
+        raise NotImplementedError
+
+
+class Filter(Stage):
+    def __init__(self, predicate):
+        self.predicate = predicate
+
+    def apply(self, records):
+        return (r for r in records if self.predicate(r))
+
+
+class Map(Stage):
+    def __init__(self, fn):
+        self.fn = fn
+
+    def apply(self, records):
+        return (self.fn(r) for r in records)
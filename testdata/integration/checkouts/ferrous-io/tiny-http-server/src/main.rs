@@ -0,0 +1,18 @@
+mod request;
+mod response;
+
+use std::io::{Read, Write};
+use std::net::TcpListener;
+
+fn main() -> std::io::Result<()> {
+    let listener = TcpListener::bind("127.0.0.1:8080")?;
+    for stream in listener.incoming() {
+        let mut stream = stream?;
+        let mut buf = [0u8; 4096];
+        let n = stream.read(&mut buf)?;
+        let req = request::parse(&buf[..n]);
+        let body = format!("you asked for {}", req.path);
+        stream.write_all(&response::ok(&body))?;
+    }
+    Ok(())
+}
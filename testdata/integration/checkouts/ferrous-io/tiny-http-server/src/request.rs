@@ -0,0 +1,14 @@
+pub struct Request {
+    pub method: String,
+    pub path: String,
+}
+
+/// Parses the request line of an HTTP/1.1 request
+pub fn parse(raw: &[u8]) -> Request {
+    let text = String::from_utf8_lossy(raw);
+    let mut parts = text.lines().next().unwrap_or("").split_whitespace();
+    Request {
+        method: parts.next().unwrap_or("GET").to_string(),
+        path: parts.next().unwrap_or("/").to_string(),
+    }
+}
@@ -0,0 +1,9 @@
+/// Builds a 200 response with a plain text body
+pub fn ok(body: &str) -> Vec<u8> {
+    let mut out = Vec::new();
+    out.extend_from_slice(b"HTTP/1.1 200 OK\r\n");
+    out.extend_from_slice(b"Content-Type: text/plain\r\n");
+    out.extend_from_slice(format!("Content-Length: {}\r\n\r\n", body.len()).as_bytes());
+    out.extend_from_slice(body.as_bytes());
+    out
+}